@@ -89,3 +89,27 @@ Create the name of the cluster role binding to use
 {{- end }}
 
 
+
+{{/*
+The -validate-paths of the main webhook, if restricted, and of webhookPaths
+*/}}
+{{- define "kubeenforcer.validatePaths" -}}
+{{- $paths := list }}
+{{- with .Values.admissionWebhook.webhookConfiguration.validators }}
+{{- $paths = append $paths (printf "/validate=%s" (join "+" .)) }}
+{{- end }}
+{{- range .Values.admissionWebhook.webhookPaths }}
+{{- $paths = append $paths (printf "/validate/%s=%s" .name (join "+" .validators)) }}
+{{- end }}
+{{- join "," $paths }}
+{{- end }}
+
+{{- define "kubeenforcer.internalErrorPolicies" -}}
+{{- $policies := list }}
+{{- range .Values.admissionWebhook.webhookPaths }}
+{{- if .internalErrorPolicy }}
+{{- $policies = append $policies (printf "/validate/%s=%s" .name .internalErrorPolicy) }}
+{{- end }}
+{{- end }}
+{{- join "," $policies }}
+{{- end }}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/cel-admission-webhook/pkg/pki"
+	"k8s.io/klog/v2"
+
+	"github.com/kubescape/kubeenforcer/pkg/bench"
+)
+
+// benchMain implements the `bench` subcommand. It returns the process exit
+// code.
+func benchMain(args []string) int {
+	var opts bench.Options
+	var inProcess bool
+
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	fs.StringVar(&opts.URL, "url", "https://127.0.0.1:8443/validate", "Validate endpoint of the instance to benchmark.")
+	fs.Float64Var(&opts.QPS, "qps", 50, "Target requests per second, up to 1000000. 0 sends as fast as possible.")
+	fs.DurationVar(&opts.Duration, "duration", 30*time.Second, "How long to run the benchmark.")
+	fs.IntVar(&opts.Concurrency, "concurrency", 8, "Number of concurrent requests.")
+	fs.StringVar(&opts.CAFile, "ca", "", "CA bundle used to verify the webhook's serving certificate.")
+	fs.BoolVar(&opts.Insecure, "insecure", false, "Skip verification of the webhook's serving certificate.")
+	fs.StringVar(&opts.Recorded, "recorded", "", "File or directory of recorded AdmissionReview JSON to replay. Synthetic reviews are used if empty.")
+	fs.BoolVar(&inProcess, "in-process", false, "Start a webhook in this process against the current cluster and benchmark it instead of -url.")
+	fs.Parse(args)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if inProcess {
+		url, caFile, cleanup, err := startInProcess(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to start in-process webhook: %v\n", err)
+			return 1
+		}
+		defer cleanup()
+		opts.URL = url
+		opts.CAFile = caFile
+	}
+
+	report, err := bench.Run(ctx, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "benchmark failed: %v\n", err)
+		return 1
+	}
+
+	report.Print(os.Stdout)
+	return 0
+}
+
+// startInProcess serves the webhook on a loopback port with a throwaway
+// self-signed certificate and waits for it to become healthy.
+func startInProcess(ctx context.Context) (url string, caFile string, cleanup func(), err error) {
+	dir, err := os.MkdirTemp("", "kubeenforcer-bench")
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	ca, err := pki.GenerateCA(&pki.CAConfig{CommonName: "kubeenforcer-bench"})
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", "", nil, err
+	}
+	keyPair, err := ca.CreateCertificate("localhost", time.Hour)
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", "", nil, err
+	}
+
+	opts := options{
+		certFile: filepath.Join(dir, "server.pem"),
+		keyFile:  filepath.Join(dir, "server-key.pem"),
+	}
+	caFile = filepath.Join(dir, "ca.pem")
+	for path, data := range map[string][]byte{
+		caFile:        ca.CertificatePem,
+		opts.certFile: keyPair.CertificatePem,
+		opts.keyFile:  keyPair.PrivateKeyPem,
+	} {
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			os.RemoveAll(dir)
+			return "", "", nil, err
+		}
+	}
+
+	// Reserve a free port for the server
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", "", nil, err
+	}
+	opts.listenAddr = l.Addr().String()
+	l.Close()
+	baseURL := fmt.Sprintf("https://localhost:%d", l.Addr().(*net.TCPAddr).Port)
+
+	serverCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		serve(serverCtx, opts)
+	}()
+
+	cleanup = func() {
+		cancel()
+		<-done
+		os.RemoveAll(dir)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(ca.CertificatePem)
+	client := &http.Client{
+		Timeout:   time.Second,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}},
+	}
+	err = wait.PollUntilContextTimeout(ctx, 500*time.Millisecond, time.Minute, true, func(ctx context.Context) (bool, error) {
+		resp, err := client.Get(baseURL + "/health")
+		if err != nil {
+			return false, nil
+		}
+		resp.Body.Close()
+		return resp.StatusCode == http.StatusOK, nil
+	})
+	if err != nil {
+		cleanup()
+		return "", "", nil, err
+	}
+
+	klog.Infof("in-process webhook listening on %s", opts.listenAddr)
+	return baseURL + "/validate", caFile, cleanup, nil
+}
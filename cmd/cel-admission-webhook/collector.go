@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"k8s.io/klog/v2"
+
+	"github.com/kubescape/kubeenforcer/pkg/collector"
+)
+
+// collectorMain implements the `collector` subcommand, which receives
+// decision streams from kubeenforcer agents in other clusters. It returns the
+// process exit code.
+func collectorMain(args []string) int {
+	var grpcAddr, httpAddr string
+	var certFile, keyFile, clientCAFile string
+
+	fs := flag.NewFlagSet("collector", flag.ExitOnError)
+	fs.StringVar(&grpcAddr, "addr", "0.0.0.0:9443", "Address agents connect to.")
+	fs.StringVar(&httpAddr, "http-addr", "0.0.0.0:8080", "Address serving the fleet view as JSON.")
+	fs.StringVar(&certFile, "cert", "server.pem", "Path to TLS certificate file.")
+	fs.StringVar(&keyFile, "key", "server-key.pem", "Path to TLS key file.")
+	fs.StringVar(&clientCAFile, "client-ca", "ca.pem", "CA bundle agent client certificates must be signed by.")
+	fs.Parse(args)
+
+	klog.EnableContextualLogging(true)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	tlsConfig, err := collector.TLSConfig(certFile, keyFile, clientCAFile, true)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load TLS configuration: %v\n", err)
+		return 1
+	}
+
+	l, err := net.Listen("tcp", grpcAddr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to listen: %v\n", err)
+		return 1
+	}
+
+	c := collector.NewCollector()
+	httpServer := &http.Server{Addr: httpAddr, Handler: c.Handler()}
+
+	serverCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errs := make(chan error, 2)
+	go func() { errs <- c.Serve(serverCtx, l, tlsConfig) }()
+	go func() { errs <- httpServer.ListenAndServe() }()
+
+	klog.Infof("collector listening on %s, fleet view on %s", grpcAddr, httpAddr)
+
+	exitCode := 0
+	select {
+	case <-ctx.Done():
+	case err := <-errs:
+		klog.Errorf("collector stopped: %v", err)
+		exitCode = 1
+	}
+
+	cancel()
+	if err := httpServer.Close(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		klog.Errorf("failed to close fleet view server: %v", err)
+	}
+	return exitCode
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/restmapper"
+
+	"k8s.io/cel-admission-webhook/pkg/apis/admissionregistration.x-k8s.io/v1alpha1"
+	"k8s.io/cel-admission-webhook/pkg/generated/clientset/versioned"
+
+	"github.com/kubescape/kubeenforcer/pkg/coverage"
+)
+
+// coverageMain implements the `coverage` subcommand, which reports the
+// operations on the resources of the current cluster that policies cover by
+// enforcement, and the blind spots they do not. It returns the process exit
+// code.
+func coverageMain(args []string) int {
+	var policyPaths, output string
+	var all bool
+
+	fs := flag.NewFlagSet("coverage", flag.ExitOnError)
+	fs.StringVar(&policyPaths, "policy", "", "Comma-separated files or directories of policies and bindings to report on, instead of those of the cluster.")
+	fs.BoolVar(&all, "all", false, "List covered operations too, not only blind spots.")
+	fs.StringVar(&output, "o", "text", "Output format: text or json.")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s coverage [flags]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+
+	restConfig, err := loadClientConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load cluster configuration: %v\n", err)
+		return 1
+	}
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	var policies []*v1alpha1.ValidatingAdmissionPolicy
+	var bindings []*v1alpha1.ValidatingAdmissionPolicyBinding
+	if policyPaths != "" {
+		if policies, bindings, err = loadPolicies(policyPaths); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+	} else if policies, bindings, err = listPolicies(context.Background(), versioned.NewForConfigOrDie(restConfig)); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	resources, err := restmapper.GetAPIGroupResources(client.Discovery())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to discover resources: %v\n", err)
+		return 1
+	}
+	report := coverage.Compute(policies, bindings, resources)
+	if !all {
+		report.Entries = coverage.BlindSpots(report)
+	}
+
+	switch output {
+	case "json":
+		out, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Println(string(out))
+	default:
+		for _, e := range report.Entries {
+			gv := e.Version
+			if e.Group != "" {
+				gv = e.Group + "/" + e.Version
+			}
+			status := "UNCOVERED"
+			switch {
+			case e.Partial:
+				status = "PARTIAL"
+			case e.Covered():
+				status = "COVERED"
+			}
+			fmt.Printf("%-9s %s %s %s", status, gv, e.Resource, e.Operation)
+			if len(e.Enforced) > 0 {
+				fmt.Printf(" enforced by %s", strings.Join(e.Enforced, ","))
+			}
+			if len(e.Audited) > 0 {
+				fmt.Printf(" audited by %s", strings.Join(e.Audited, ","))
+			}
+			fmt.Println()
+		}
+		fmt.Printf("%d of %d operations covered by enforcement, %d partially\n", report.Covered, report.Total, report.Partial)
+	}
+	return 0
+}
+
+// listPolicies lists the policies and bindings of the cluster.
+func listPolicies(ctx context.Context, client versioned.Interface) ([]*v1alpha1.ValidatingAdmissionPolicy, []*v1alpha1.ValidatingAdmissionPolicyBinding, error) {
+	policyList, err := client.AdmissionregistrationV1alpha1().ValidatingAdmissionPolicies().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list policies: %w", err)
+	}
+	bindingList, err := client.AdmissionregistrationV1alpha1().ValidatingAdmissionPolicyBindings().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list bindings: %w", err)
+	}
+	policies := make([]*v1alpha1.ValidatingAdmissionPolicy, len(policyList.Items))
+	for i := range policyList.Items {
+		policies[i] = &policyList.Items[i]
+	}
+	bindings := make([]*v1alpha1.ValidatingAdmissionPolicyBinding, len(bindingList.Items))
+	for i := range bindingList.Items {
+		bindings[i] = &bindingList.Items[i]
+	}
+	return policies, bindings, nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// egressFeatures returns the flags of the configured features that connect
+// to anything but the API server, which -no-egress forbids.
+func egressFeatures(opts options) []string {
+	var features []string
+	for _, f := range []struct {
+		flag       string
+		configured bool
+	}{
+		{"-alertmanager", opts.alertmanagerHost != ""},
+		{"-alert-dedup=redis", opts.alertDedup == "redis"},
+		{"-cert-source=vault", opts.certSource == "vault"},
+		{"-collector", opts.collectorAddr != ""},
+		{"-policy-server", opts.policyServerURL != ""},
+		{"-opa-bundle", opts.opaBundleURL != ""},
+		{"-telemetry-endpoint", opts.telemetryEndpoint != ""},
+		{"-grafana-url", opts.grafanaURL != ""},
+		{"-audit-events-webhook", opts.auditEventsWebhook != ""},
+		{"-mirror-url", opts.mirrorURL != ""},
+		{"-otlp-logs-endpoint", opts.otlpLogsEndpoint != ""},
+		{"-splunk-hec-url", opts.splunkHECURL != ""},
+		{"-elasticsearch-url", opts.elasticsearchURL != ""},
+		{"-datadog-api-key-file", opts.datadogAPIKeyFile != ""},
+		{"-google-chat-config", opts.googleChatConfig != ""},
+		{"-mattermost-webhook-file", opts.mattermostWebhookFile != ""},
+		{"-rocketchat-webhook-file", opts.rocketChatWebhookFile != ""},
+		{"-jira-url", opts.jiraURL != ""},
+		{"-commit-status-github-token-file", opts.gitHubTokenFile != ""},
+		{"-commit-status-gitlab-token-file", opts.gitLabTokenFile != ""},
+	} {
+		if f.configured {
+			features = append(features, f.flag)
+		}
+	}
+	return features
+}
+
+// checkNoEgress fails if any outbound network feature is configured.
+func checkNoEgress(opts options) error {
+	if features := egressFeatures(opts); len(features) > 0 {
+		return fmt.Errorf("outbound network features are configured: %s", strings.Join(features, ", "))
+	}
+	return nil
+}
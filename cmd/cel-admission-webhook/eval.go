@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"sigs.k8s.io/yaml"
+
+	"github.com/kubescape/kubeenforcer/pkg/playground"
+)
+
+// evalMain implements the `eval` subcommand, which evaluates a CEL
+// expression against manifests as a policy would, for prototyping policies.
+// It returns the process exit code: 1 if the expression failed.
+func evalMain(args []string) int {
+	var input playground.Input
+	var objectFile, oldObjectFile, paramsFile, requestFile string
+
+	fs := flag.NewFlagSet("eval", flag.ExitOnError)
+	fs.StringVar(&objectFile, "object", "", "YAML or JSON manifest bound to object.")
+	fs.StringVar(&oldObjectFile, "old-object", "", "YAML or JSON manifest bound to oldObject.")
+	fs.StringVar(&paramsFile, "params", "", "YAML or JSON manifest bound to params.")
+	fs.StringVar(&requestFile, "request", "", "YAML or JSON admission request bound to request.")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s eval [flags] <expression>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	input.Expression = fs.Arg(0)
+
+	for _, v := range []struct {
+		file string
+		into *interface{}
+	}{
+		{objectFile, &input.Object},
+		{oldObjectFile, &input.OldObject},
+		{paramsFile, &input.Params},
+		{requestFile, &input.Request},
+	} {
+		if v.file == "" {
+			continue
+		}
+		data, err := os.ReadFile(v.file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		if err := yaml.Unmarshal(data, v.into); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to parse %s: %v\n", v.file, err)
+			return 1
+		}
+	}
+
+	output := playground.Eval(context.Background(), input)
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(output)
+	if output.Error != "" {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kubescape/kubeenforcer/pkg/fips"
+)
+
+// fipsIncompatibleFeatures returns the flags of the configured features that
+// use algorithms outside of the FIPS 140 validated module, which -fips
+// forbids.
+func fipsIncompatibleFeatures(opts options) []string {
+	var features []string
+	// Policy bundles are signed with ed25519
+	if opts.policyServerURL != "" {
+		features = append(features, "-policy-server")
+	}
+	return features
+}
+
+// policyServerFIPSIncompatibleFeatures are the flags of the policy-server
+// subcommand that use algorithms outside of the FIPS 140 validated module,
+// which are always in use since it cannot serve unsigned bundles.
+var policyServerFIPSIncompatibleFeatures = []string{
+	// Bundles are signed with ed25519
+	"-signing-key",
+}
+
+// checkFIPS fails unless FIPS 140 validated crypto is in use and none of the
+// configured features, found by fipsIncompatibleFeatures, relies on other
+// algorithms.
+func checkFIPS(features []string) error {
+	if err := fips.Check(); err != nil {
+		return err
+	}
+	if len(features) > 0 {
+		return fmt.Errorf("features using algorithms that are not FIPS-approved are configured: %s", strings.Join(features, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestFIPSIncompatibleFeatures(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     options
+		features []string
+	}{
+		{
+			name: "none",
+		},
+		{
+			name:     "policy bundles",
+			opts:     options{policyServerURL: "https://policies.example.com:9444"},
+			features: []string{"-policy-server"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			features := fipsIncompatibleFeatures(tt.opts)
+			if strings.Join(features, ",") != strings.Join(tt.features, ",") {
+				t.Errorf("fipsIncompatibleFeatures() = %v, want %v", features, tt.features)
+			}
+			if err := checkFIPS(features); err == nil && len(features) > 0 {
+				t.Errorf("checkFIPS(%v) succeeded", features)
+			}
+		})
+	}
+}
+
+func TestPolicyServerFIPS(t *testing.T) {
+	if err := checkFIPS(policyServerFIPSIncompatibleFeatures); err == nil {
+		t.Fatal("checkFIPS() succeeded for the policy server, which signs bundles with ed25519")
+	}
+
+	stderr := os.Stderr
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stderr = w
+	code := policyServerMain([]string{"-fips", "-signing-key", "missing.pem"})
+	os.Stderr = stderr
+	w.Close()
+	out, _ := io.ReadAll(r)
+
+	// The check fails before the signing key is loaded
+	if code != 1 || !strings.HasPrefix(string(out), "invalid configuration with -fips: ") {
+		t.Errorf("policyServerMain(-fips) = %d, %q, want 1 and a FIPS error", code, out)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/kubescape/kubeenforcer/pkg/decision"
+	"github.com/kubescape/kubeenforcer/pkg/impact"
+	"github.com/kubescape/kubeenforcer/pkg/simulate"
+)
+
+// impactMain implements the `impact` subcommand, which reports the impact
+// of enabling policies for change review: the existing objects they would
+// deny, and the requests they would have denied while shadow-evaluated with
+// the Audit action. It returns the process exit code.
+func impactMain(args []string) int {
+	var policyPaths, decisionsSource, tokenFile, caFile, output string
+	var since time.Duration
+	var opts simulate.Options
+
+	fs := flag.NewFlagSet("impact", flag.ExitOnError)
+	fs.StringVar(&policyPaths, "policy", "", "Comma-separated files or directories of policies, and optionally their bindings, to report on.")
+	fs.StringVar(&opts.Namespace, "namespace", "", "Only evaluate the objects of this namespace. All namespaces and cluster-scoped objects if empty.")
+	fs.StringVar(&opts.OwnerLabel, "owner-label", "", "Label naming the owners of workloads or namespaces, e.g. team, to report the affected owners.")
+	fs.StringVar(&decisionsSource, "decisions", "", "URL of the decisions API, e.g. https://kubeenforcer.kubescape.svc/api/v1/decisions, or file of decisions it returned, with the results of the shadow evaluation. The report has no shadow evaluation results if empty.")
+	fs.StringVar(&tokenFile, "decisions-token-file", "", "File holding a bearer token for the decisions API.")
+	fs.StringVar(&caFile, "decisions-ca", "", "CA bundle used to verify the decisions API.")
+	fs.DurationVar(&since, "since", 7*24*time.Hour, "How far back the shadow evaluation results go.")
+	fs.StringVar(&output, "o", "markdown", "Output format: markdown or json.")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s impact -policy <file or directory> [flags]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if policyPaths == "" || fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+
+	policies, bindings, err := loadPolicies(policyPaths)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	simulator, err := newSimulator()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	now := time.Now()
+	var shadow *impact.Shadow
+	if decisionsSource != "" {
+		shadow = &impact.Shadow{Since: now.Add(-since), Until: now}
+		if shadow.Decisions, err = loadDecisions(ctx, decisionsSource, tokenFile, caFile, since); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to load decisions: %v\n", err)
+			return 1
+		}
+		// Files may hold decisions older than the window, and the API may
+		// not keep decisions as far back, in which case the window starts
+		// at the oldest decision
+		decisions := shadow.Decisions[:0]
+		oldest := now
+		for _, d := range shadow.Decisions {
+			if d.Time.Before(shadow.Since) {
+				continue
+			}
+			decisions = append(decisions, d)
+			if d.Time.Before(oldest) {
+				oldest = d.Time
+			}
+		}
+		shadow.Decisions = decisions
+		if len(decisions) > 0 {
+			shadow.Since = oldest
+		}
+	}
+
+	simulation, err := simulator.Run(ctx, policies, bindings, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	report := impact.Build(policies, simulation, shadow, now)
+
+	switch output {
+	case "json":
+		out, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Println(string(out))
+	default:
+		report.WriteMarkdown(os.Stdout)
+	}
+	return 0
+}
+
+// loadDecisions reads the decisions of the last since from the decisions
+// API at source, or from the file source, most recent first.
+func loadDecisions(ctx context.Context, source, tokenFile, caFile string, since time.Duration) ([]*decision.Decision, error) {
+	var body io.ReadCloser
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		u, err := url.Parse(source)
+		if err != nil {
+			return nil, err
+		}
+		query := u.Query()
+		query.Set("since", since.String())
+		u.RawQuery = query.Encode()
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		if tokenFile != "" {
+			token, err := os.ReadFile(tokenFile)
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+		}
+		client, err := newHTTPClient(caFile, "", "")
+		if err != nil {
+			return nil, err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("%s returned %s", source, resp.Status)
+		}
+		body = resp.Body
+	} else {
+		f, err := os.Open(source)
+		if err != nil {
+			return nil, err
+		}
+		body = f
+	}
+	defer body.Close()
+
+	var decisions []*decision.Decision
+	if err := json.NewDecoder(body).Decode(&decisions); err != nil {
+		return nil, fmt.Errorf("failed to parse decisions: %w", err)
+	}
+	return decisions, nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"k8s.io/cel-admission-webhook/pkg/apis/admissionregistration.x-k8s.io/v1alpha1"
+
+	"github.com/kubescape/kubeenforcer/pkg/conflict"
+	"github.com/kubescape/kubeenforcer/pkg/distribution"
+)
+
+// lintMain implements the `lint` subcommand, which checks the policies and
+// bindings in the given files and directories before they are applied. It
+// returns the process exit code: 1 if problems were found.
+func lintMain(args []string) int {
+	var output string
+
+	fs := flag.NewFlagSet("lint", flag.ExitOnError)
+	fs.StringVar(&output, "o", "text", "Output format: text or json.")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s lint [flags] <file or directory>...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	// Later manifests replace earlier ones of the same name, as when they
+	// are applied in order
+	policies := map[string]*v1alpha1.ValidatingAdmissionPolicy{}
+	bindings := map[string]*v1alpha1.ValidatingAdmissionPolicyBinding{}
+	files, err := manifestFiles(fs.Args())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	for _, file := range files {
+		bundle, err := distribution.LoadFile("", file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to load %v\n", err)
+			return 1
+		}
+		for i := range bundle.Policies {
+			policies[bundle.Policies[i].Name] = &bundle.Policies[i]
+		}
+		for i := range bundle.Bindings {
+			bindings[bundle.Bindings[i].Name] = &bundle.Bindings[i]
+		}
+	}
+
+	policyList := make([]*v1alpha1.ValidatingAdmissionPolicy, 0, len(policies))
+	for _, p := range policies {
+		policyList = append(policyList, p)
+	}
+	bindingList := make([]*v1alpha1.ValidatingAdmissionPolicyBinding, 0, len(bindings))
+	for _, b := range bindings {
+		bindingList = append(bindingList, b)
+	}
+	conflicts := conflict.Detect(policyList, bindingList)
+
+	switch output {
+	case "json":
+		out, _ := json.MarshalIndent(conflicts, "", "  ")
+		fmt.Println(string(out))
+	default:
+		for _, c := range conflicts {
+			fmt.Printf("%s: %s\n", c.Kind, c.Message)
+		}
+		fmt.Printf("%d policies, %d bindings, %d conflicts\n", len(policies), len(bindings), len(conflicts))
+	}
+
+	if len(conflicts) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// manifestFiles expands directories in paths to the YAML and JSON files they
+// contain.
+func manifestFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(path, "*"))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(matches)
+		for _, match := range matches {
+			switch filepath.Ext(match) {
+			case ".yaml", ".yml", ".json":
+				files = append(files, match)
+			}
+		}
+	}
+	return files, nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kubescape/kubeenforcer/pkg/webhook"
+)
+
+// listenFlag collects repeated -listen flags of the form
+// addr[,cert=path][,key=path].
+type listenFlag []webhook.Listener
+
+func (f *listenFlag) String() string {
+	var addrs []string
+	for _, l := range *f {
+		addrs = append(addrs, l.Addr)
+	}
+	return strings.Join(addrs, " ")
+}
+
+func (f *listenFlag) Set(value string) error {
+	parts := strings.Split(value, ",")
+	l := webhook.Listener{Addr: parts[0]}
+	if l.Addr == "" {
+		return fmt.Errorf("missing address in %q", value)
+	}
+
+	for _, part := range parts[1:] {
+		key, v, ok := strings.Cut(part, "=")
+		if !ok {
+			return fmt.Errorf("invalid listener setting %q, expected key=value", part)
+		}
+		switch key {
+		case "cert":
+			l.CertFile = v
+		case "key":
+			l.KeyFile = v
+		default:
+			return fmt.Errorf("unknown listener setting %q", key)
+		}
+	}
+
+	*f = append(*f, l)
+	return nil
+}
@@ -2,55 +2,629 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"flag"
 	"fmt"
+	"net"
+	"net/http"
+	"os"
 	"os/signal"
+	"runtime"
+	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
 	apiextensionsclientset "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
 	apiextensionsclientsetscheme "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/scheme"
 	apiextensionsinformers "k8s.io/apiextensions-apiserver/pkg/client/informers/externalversions"
 	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/apiserver/pkg/admission"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
 	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	clientsetscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/metadata"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/klog/v2"
 	aggregatorclientsetscheme "k8s.io/kube-aggregator/pkg/client/clientset_generated/clientset/scheme"
 
 	"k8s.io/cel-admission-webhook/pkg/controller/admissionregistration.x-k8s.io/v1alpha1"
-	"k8s.io/cel-admission-webhook/pkg/controller/schemaresolver"
 	"k8s.io/cel-admission-webhook/pkg/generated/clientset/versioned"
 	"k8s.io/cel-admission-webhook/pkg/generated/clientset/versioned/scheme"
 	"k8s.io/cel-admission-webhook/pkg/generated/informers/externalversions"
-	"k8s.io/cel-admission-webhook/pkg/validator"
 
+	"github.com/kubescape/kubeenforcer/pkg/admin"
+	"github.com/kubescape/kubeenforcer/pkg/alertmanager"
+	"github.com/kubescape/kubeenforcer/pkg/auditlog"
+	"github.com/kubescape/kubeenforcer/pkg/authz"
+	"github.com/kubescape/kubeenforcer/pkg/certexpiry"
+	"github.com/kubescape/kubeenforcer/pkg/certsource"
+	"github.com/kubescape/kubeenforcer/pkg/changes"
+	"github.com/kubescape/kubeenforcer/pkg/chat"
+	"github.com/kubescape/kubeenforcer/pkg/collector"
+	"github.com/kubescape/kubeenforcer/pkg/conflict"
+	"github.com/kubescape/kubeenforcer/pkg/coverage"
+	"github.com/kubescape/kubeenforcer/pkg/crdscheme"
+	"github.com/kubescape/kubeenforcer/pkg/dashboard"
+	"github.com/kubescape/kubeenforcer/pkg/datadog"
+	"github.com/kubescape/kubeenforcer/pkg/decision"
+	"github.com/kubescape/kubeenforcer/pkg/decisiondb"
+	"github.com/kubescape/kubeenforcer/pkg/decisionstream"
+	"github.com/kubescape/kubeenforcer/pkg/deletionprotection"
+	"github.com/kubescape/kubeenforcer/pkg/distribution"
+	"github.com/kubescape/kubeenforcer/pkg/elasticsearch"
+	"github.com/kubescape/kubeenforcer/pkg/errorbudget"
+	"github.com/kubescape/kubeenforcer/pkg/exception"
+	"github.com/kubescape/kubeenforcer/pkg/explain"
+	"github.com/kubescape/kubeenforcer/pkg/exprcache"
+	"github.com/kubescape/kubeenforcer/pkg/fips"
+	"github.com/kubescape/kubeenforcer/pkg/genname"
+	"github.com/kubescape/kubeenforcer/pkg/gitfeedback"
+	"github.com/kubescape/kubeenforcer/pkg/googlechat"
+	"github.com/kubescape/kubeenforcer/pkg/grafana"
+	"github.com/kubescape/kubeenforcer/pkg/helmrelease"
+	"github.com/kubescape/kubeenforcer/pkg/informerhealth"
+	"github.com/kubescape/kubeenforcer/pkg/jira"
+	"github.com/kubescape/kubeenforcer/pkg/loglevel"
+	"github.com/kubescape/kubeenforcer/pkg/lookup"
+	"github.com/kubescape/kubeenforcer/pkg/maintenance"
+	"github.com/kubescape/kubeenforcer/pkg/mirror"
+	"github.com/kubescape/kubeenforcer/pkg/mutation"
+	"github.com/kubescape/kubeenforcer/pkg/namespacepolicy"
+	"github.com/kubescape/kubeenforcer/pkg/networkpolicy"
+	"github.com/kubescape/kubeenforcer/pkg/otellog"
+	"github.com/kubescape/kubeenforcer/pkg/outcome"
+	"github.com/kubescape/kubeenforcer/pkg/playground"
+	"github.com/kubescape/kubeenforcer/pkg/policycache"
+	"github.com/kubescape/kubeenforcer/pkg/policycheck"
+	"github.com/kubescape/kubeenforcer/pkg/policyset"
+	"github.com/kubescape/kubeenforcer/pkg/priority"
+	"github.com/kubescape/kubeenforcer/pkg/redaction"
+	"github.com/kubescape/kubeenforcer/pkg/registry"
+	"github.com/kubescape/kubeenforcer/pkg/remediation"
+	"github.com/kubescape/kubeenforcer/pkg/requiredmetadata"
+	"github.com/kubescape/kubeenforcer/pkg/schemavalidation"
+	"github.com/kubescape/kubeenforcer/pkg/splunk"
+	"github.com/kubescape/kubeenforcer/pkg/telemetry"
+	"github.com/kubescape/kubeenforcer/pkg/typecheck"
+	"github.com/kubescape/kubeenforcer/pkg/uniqueness"
+	"github.com/kubescape/kubeenforcer/pkg/variables"
 	"github.com/kubescape/kubeenforcer/pkg/webhook"
+	"github.com/kubescape/kubeenforcer/pkg/webhookconfig"
 )
 
+// options holds the settings for running the webhook server
+type options struct {
+	certFile, keyFile string
+	certSource        string
+	spiffeEndpoint    string
+	vault             certsource.VaultOptions
+	certSecret        string
+	listenAddr        string
+	listeners         listenFlag
+	httpOptions       webhook.HTTPOptions
+	healthOptions     webhook.HealthOptions
+
+	authTokenFile   string
+	authTokenReview bool
+	authAudiences   string
+	authUsers       string
+	authClientCA    string
+	adminUsers      string
+	adminGroups     string
+
+	alertmanagerHost string
+	alertmanagerCert string
+	alertmanagerKey  string
+	alertmanagerCA   string
+
+	certExpiryAlertWindow time.Duration
+	informerStaleAfter    time.Duration
+	selfTest              webhook.SelfTestOptions
+	schemaRefreshInterval time.Duration
+	coerceCustomResources bool
+	denyStorm             webhook.DenyStormOptions
+	memoryPressure        webhook.MemoryPressureOptions
+	memoryHeapLimit       string
+	memoryExemptGroups    string
+
+	alertDedup              string
+	alertDedupWindow        time.Duration
+	alertDedupNamespace     string
+	alertDedupConfigMap     string
+	alertDedupRedis         string
+	alertDedupRedisUsername string
+	alertDedupRedisPassword string
+	alertDedupRedisTLS      bool
+	alertDedupRedisCA       string
+	alertGitOpsWindow       time.Duration
+	alertStableFingerprints bool
+
+	autoScopeRules    bool
+	webhookConfigName string
+	webhookName       string
+
+	webhookFailurePolicy  string
+	webhookTimeoutSeconds int
+	webhookSideEffects    string
+
+	collectorAddr string
+	clusterName   string
+	collectorCert string
+	collectorKey  string
+	collectorCA   string
+
+	decisionStreamAddr     string
+	decisionStreamClientCA string
+	decisionAPISize        int
+	decisionDB             string
+	decisionDBRetention    time.Duration
+	decisionDBMaxSize      string
+
+	policyServerURL       string
+	policyServerCA        string
+	policyServerCert      string
+	policyServerKey       string
+	policyServerPublicKey string
+	policyServerInterval  time.Duration
+
+	opaBundleURL       string
+	opaBundleCA        string
+	opaBundleTokenFile string
+	opaBundlePublicKey string
+	opaBundleKeyID     string
+	opaBundleScope     string
+	opaBundleInterval  time.Duration
+	clusterLabels      string
+
+	namespacePolicies bool
+
+	authorizer                bool
+	authorizerAuthorizedTTL   time.Duration
+	authorizerUnauthorizedTTL time.Duration
+
+	autoRemediate bool
+
+	mutatingAdmissionPolicies bool
+	securityContextDefaults   string
+
+	maintenanceWindows        string
+	maintenanceWindowsLeaseNS string
+
+	policyExceptions              bool
+	policyExceptionMaxTTL         time.Duration
+	policyExceptionReviewInterval time.Duration
+	policyExceptionLeaseNS        string
+	policyExceptionPolicies       string
+
+	adminAddr string
+	dashboard bool
+	logLevels *loglevel.Levels
+
+	otlpLogsEndpoint string
+	otlpLogsHeaders  string
+	otlpLogsCA       string
+
+	logAllowedEvery      int
+	redactionConfig      string
+	largeObjectThreshold string
+
+	policyPriorities string
+	shortCircuitDeny bool
+
+	validatePolicies  bool
+	policyCacheDir    string
+	policyCacheSize   int
+	typeCheckPolicies bool
+
+	playground bool
+
+	explainUsers  string
+	explainGroups string
+
+	telemetryEndpoint string
+	telemetryInterval time.Duration
+
+	grafanaURL       string
+	grafanaTokenFile string
+	grafanaTags      string
+
+	splunkHECURL             string
+	splunkHECTokenFile       string
+	splunkHECCA              string
+	splunkIndex              string
+	splunkDecisionSourceType string
+	splunkAlertSourceType    string
+	splunkAllDecisions       bool
+
+	elasticsearchURL          string
+	elasticsearchIndexPrefix  string
+	elasticsearchAPIKeyFile   string
+	elasticsearchUsername     string
+	elasticsearchPasswordFile string
+	elasticsearchCA           string
+	elasticsearchILMPolicy    string
+
+	datadogAPIKeyFile string
+	datadogSite       string
+	datadogTags       string
+	datadogTeamLabel  string
+	datadogMetrics    bool
+
+	googleChatConfig string
+
+	gitHubTokenFile string
+	gitHubAPI       string
+	gitLabTokenFile string
+	gitLabURL       string
+	argoCDNamespace string
+	gitRepositories string
+
+	jiraURL       string
+	jiraProject   string
+	jiraIssueType string
+	jiraUser      string
+	jiraTokenFile string
+	jiraCA        string
+	jiraThreshold time.Duration
+
+	mattermostWebhookFile string
+	rocketChatWebhookFile string
+	chatMessageTemplate   string
+
+	auditEventsFile        string
+	auditEventsWebhook     string
+	auditEventsWebhookCA   string
+	auditEventsWebhookCert string
+	auditEventsWebhookKey  string
+
+	mirrorURL  string
+	mirrorRate float64
+	mirrorCA   string
+	mirrorCert string
+	mirrorKey  string
+
+	bindingOutcomes         bool
+	bindingErrorSpikeFactor float64
+	bindingIdleAfter        time.Duration
+
+	policyErrorBudget         float64
+	policyErrorBudgetWindow   time.Duration
+	policyErrorBudgetCooldown time.Duration
+	policyErrorBudgetLeaseNS  string
+
+	noEgress bool
+	fips     bool
+
+	scaleTargetMetadata bool
+	bindingMetadata     bool
+	resolveNames        bool
+	resolveNamesTimeout time.Duration
+	helmReleaseSecrets  bool
+
+	uniquenessConstraints string
+	lookupResources       string
+	expressionCacheSize   int
+	requireNetworkPolicy  string
+	deletionProtection    string
+	metadataRequirements  bool
+	schemaValidation      string
+
+	validatorFailurePolicies string
+	validatePaths            string
+	internalErrorPolicy      string
+	internalErrorPolicies    string
+
+	pluginValidators string
+	pluginMutators   string
+	pluginConfig     string
+}
+
 func main() {
-	var certFile, keyFile string
-	var listenAddr string
-	var alertmanagerHost string
-	flag.StringVar(&certFile, "cert", "server.pem", "Path to TLS certificate file.")
-	flag.StringVar(&keyFile, "key", "server-key.pem", "Path to TLS key file.")
-	flag.StringVar(&listenAddr, "addr", "0.0.0.0:8443", "Address to listen on.")
-	flag.StringVar(&alertmanagerHost, "alertmanager", "", "Address of alertmanager.")
+	// Subcommands evaluating policies offline need the functions too
+	changes.Enable()
+
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "bench":
+			os.Exit(benchMain(os.Args[2:]))
+		case "collector":
+			os.Exit(collectorMain(os.Args[2:]))
+		case "coverage":
+			os.Exit(coverageMain(os.Args[2:]))
+		case "eval":
+			os.Exit(evalMain(os.Args[2:]))
+		case "impact":
+			os.Exit(impactMain(os.Args[2:]))
+		case "lint":
+			os.Exit(lintMain(os.Args[2:]))
+		case "policy-server":
+			os.Exit(policyServerMain(os.Args[2:]))
+		case "simulate":
+			os.Exit(simulateMain(os.Args[2:]))
+		}
+	}
+
+	var opts options
+	flag.StringVar(&opts.certFile, "cert", "server.pem", "Path to TLS certificate file.")
+	flag.StringVar(&opts.keyFile, "key", "server-key.pem", "Path to TLS key file.")
+	flag.StringVar(&opts.certSource, "cert-source", "file", "Where the serving certificate comes from: file, spiffe to obtain it from a SPIRE agent, vault to issue it from a Vault PKI role, or secret to watch a Secret through the API. Certificates from other sources than file are written to -cert and -key.")
+	flag.StringVar(&opts.spiffeEndpoint, "spiffe-endpoint-socket", envOrDefault("SPIFFE_ENDPOINT_SOCKET", "unix:///run/spire/sockets/agent.sock"), "Address of the SPIFFE Workload API.")
+	flag.StringVar(&opts.certSecret, "cert-secret", "", "kubernetes.io/tls Secret holding the serving certificate with -cert-source=secret, as name or namespace/name. Defaults to the pod's namespace.")
+	flag.StringVar(&opts.vault.Address, "vault-addr", os.Getenv("VAULT_ADDR"), "Address of the Vault server issuing the serving certificate with -cert-source=vault.")
+	flag.StringVar(&opts.vault.CAFile, "vault-ca", "", "CA bundle used to verify the Vault server.")
+	flag.StringVar(&opts.vault.Mount, "vault-pki-mount", "pki", "Path of the Vault PKI secrets engine.")
+	flag.StringVar(&opts.vault.Role, "vault-pki-role", "", "Vault PKI role the serving certificate is issued from.")
+	flag.StringVar(&opts.vault.CommonName, "vault-common-name", "", "Common name of the serving certificate, e.g. kubeenforcer.kubescape.svc.")
+	flag.Func("vault-alt-names", "Comma separated DNS names added to the serving certificate.", func(v string) error {
+		opts.vault.AltNames = strings.Split(v, ",")
+		return nil
+	})
+	flag.DurationVar(&opts.vault.TTL, "vault-ttl", 0, "Lifetime of issued serving certificates. Uses the role's default if 0.")
+	flag.StringVar(&opts.vault.Token, "vault-token", os.Getenv("VAULT_TOKEN"), "Vault token. Kubernetes auth is used if empty.")
+	flag.StringVar(&opts.vault.KubernetesMount, "vault-kubernetes-mount", "kubernetes", "Path of the Vault Kubernetes auth method.")
+	flag.StringVar(&opts.vault.KubernetesRole, "vault-kubernetes-role", "", "Vault Kubernetes auth role to log in as.")
+	flag.StringVar(&opts.vault.JWTFile, "vault-jwt-file", "/var/run/secrets/kubernetes.io/serviceaccount/token", "Service account token used for Vault Kubernetes auth.")
+	flag.StringVar(&opts.listenAddr, "addr", "0.0.0.0:8443", "Address to listen on. Use unix:///path/to/socket to listen on a unix domain socket.")
+	flag.Var(&opts.listeners, "listen", "Additional address to listen on, as addr[,cert=path][,key=path]. Certificate and key default to -cert and -key. Can be repeated, e.g. for IPv4 and IPv6 addresses.")
+	flag.Func("http2-max-concurrent-streams", "Maximum concurrent HTTP/2 streams per connection. Uses the net/http default if unset.", func(v string) error {
+		n, err := strconv.ParseUint(v, 10, 32)
+		opts.httpOptions.MaxConcurrentStreams = uint32(n)
+		return err
+	})
+	flag.DurationVar(&opts.httpOptions.IdleTimeout, "http-idle-timeout", 0, "How long idle keep-alive connections are kept open. Uses the net/http default if 0.")
+	flag.BoolVar(&opts.httpOptions.DisableKeepAlives, "http-disable-keep-alives", false, "Close every connection after serving a single request.")
+	flag.DurationVar(&opts.httpOptions.ShutdownDelay, "shutdown-delay", 5*time.Second, "How long to keep serving after being marked not ready on SIGTERM, so the API server stops sending new requests.")
+	flag.DurationVar(&opts.httpOptions.ShutdownGracePeriod, "shutdown-grace-period", 25*time.Second, "Maximum time to wait for in-flight admissions on SIGTERM before closing connections. Should be below the pod's terminationGracePeriodSeconds.")
+	flag.BoolVar(&opts.httpOptions.ReusePort, "listen-reuse-port", false, "Set SO_REUSEPORT on TCP listeners, so a new process can listen on the same addresses while the previous one drains.")
+	flag.BoolVar(&opts.httpOptions.Handoff, "handoff", false, "On SIGUSR2, start the executable again with the same arguments, passing it the webhook listeners; the new process sends SIGTERM to this one once it started. For in-place binary upgrades of processes that are not a container's main process.")
+	flag.DurationVar(&opts.healthOptions.CertExpiryWindow, "cert-expiry-window", 72*time.Hour, "Fail readiness once a serving certificate expires within this window, or within a third of its lifetime if shorter. Disabled if 0.")
+	flag.BoolVar(&opts.healthOptions.CertChecksFailHealth, "cert-checks-fail-health", false, "Also fail /health when a serving certificate is expiring or does not match its key.")
+	flag.StringVar(&opts.authTokenFile, "auth-token-file", "", "File holding a static bearer token required on /validate.")
+	flag.BoolVar(&opts.authTokenReview, "auth-token-review", false, "Accept bearer tokens on /validate that pass a TokenReview, such as the API server's service account token.")
+	flag.StringVar(&opts.authAudiences, "auth-token-audiences", "", "Comma separated audiences reviewed tokens must be valid for.")
+	flag.StringVar(&opts.authUsers, "auth-users", "", "Comma separated users allowed to call /validate with a reviewed token. Any authenticated user if empty.")
+	flag.StringVar(&opts.authClientCA, "auth-client-ca", "", "CA bundle verifying client certificates on /validate. Requests with a verified client certificate are accepted.")
+	flag.StringVar(&opts.adminUsers, "admin-users", "", "Comma separated users allowed to call the admin endpoints on the webhook listeners, such as /admin/eval, with a reviewed token or a client certificate naming them. Admin endpoints are disabled if this and -admin-groups are empty.")
+	flag.StringVar(&opts.adminGroups, "admin-groups", "", "Comma separated groups whose members may call the admin endpoints on the webhook listeners, with a reviewed token or a client certificate with the group as organization.")
+	flag.StringVar(&opts.alertmanagerHost, "alertmanager", "", "Address of alertmanager.")
+	flag.StringVar(&opts.alertmanagerCert, "alertmanager-cert", "", "Client certificate presented to alertmanager. Alerts are sent over HTTPS if this or -alertmanager-ca is set.")
+	flag.StringVar(&opts.alertmanagerKey, "alertmanager-key", "", "Key of the client certificate presented to alertmanager.")
+	flag.StringVar(&opts.alertmanagerCA, "alertmanager-ca", "", "CA bundle used to verify alertmanager.")
+	flag.DurationVar(&opts.schemaRefreshInterval, "schema-refresh-interval", 10*time.Minute, "How long the OpenAPI schemas of resources, including CRDs, used to type check policies are cached before being resolved again through discovery. Schemas of a CRD are also dropped when it changes.")
+	flag.BoolVar(&opts.coerceCustomResources, "coerce-custom-resources", false, "Apply the defaults of the structural schemas of CRDs to custom resources before evaluating them, and convert their numbers to the integer or number type of their fields, so CEL expressions see them as the API server does.")
+	flag.DurationVar(&opts.informerStaleAfter, "informer-stale-after", 2*time.Minute, "Fail readiness once the policy, binding or namespace informers failed to watch the API server for this long.")
+	flag.BoolVar(&opts.selfTest.Enabled, "self-test", false, "Fail readiness until a startup self-test passes: policies compile, a synthetic admission review is answered over TLS on the first listener, and alertmanager, if configured, is reachable.")
+	flag.DurationVar(&opts.selfTest.Timeout, "self-test-timeout", 10*time.Second, "Timeout of each attempt of the startup self-test.")
+	flag.IntVar(&opts.denyStorm.Threshold, "deny-storm-threshold", 0, "Throttle the denials of a user once more than this many of its requests were denied within -deny-storm-window: they are returned as rate limited, and replaced by a single alert. Disabled if 0.")
+	flag.DurationVar(&opts.denyStorm.Window, "deny-storm-window", time.Minute, "Sliding window denials are counted over for -deny-storm-threshold.")
+	flag.DurationVar(&opts.denyStorm.Cooldown, "deny-storm-cooldown", 5*time.Minute, "How long denials of a user stay throttled after its denial rate last exceeded -deny-storm-threshold.")
+	flag.StringVar(&opts.memoryHeapLimit, "memory-pressure-heap-limit", "0", "Go heap size above which admission requests of users outside -memory-pressure-exempt-groups fail fast with 503 Service Unavailable, handled by the API server according to the failure policy of the webhook, e.g. 400Mi. Disabled if 0.")
+	flag.Float64Var(&opts.memoryPressure.CgroupWatermark, "memory-pressure-cgroup-watermark", 0, "Fraction of the container's memory limit above which admission requests of users outside -memory-pressure-exempt-groups fail fast, e.g. 0.9. Disabled if 0.")
+	flag.StringVar(&opts.memoryExemptGroups, "memory-pressure-exempt-groups", "system:masters,system:nodes", "Comma separated groups whose requests are evaluated under memory pressure.")
+	flag.DurationVar(&opts.certExpiryAlertWindow, "cert-expiry-alert-window", 14*24*time.Hour, "Alert once a serving or alertmanager client certificate expires within this window.")
+	flag.StringVar(&opts.alertDedup, "alert-dedup", "none", "Alert deduplication backend: none, memory, configmap or redis.")
+	flag.DurationVar(&opts.alertDedupWindow, "alert-dedup-window", 10*time.Minute, "How long an alert suppresses identical alerts.")
+	flag.StringVar(&opts.alertDedupNamespace, "alert-dedup-namespace", os.Getenv("POD_NAMESPACE"), "Namespace of the alert deduplication ConfigMap.")
+	flag.StringVar(&opts.alertDedupConfigMap, "alert-dedup-configmap", "kubeenforcer-alert-dedup", "Name of the ConfigMap shared by replicas for alert deduplication.")
+	flag.StringVar(&opts.alertDedupRedis, "alert-dedup-redis", "", "Address of the Redis server used for alert deduplication.")
+	flag.StringVar(&opts.alertDedupRedisUsername, "alert-dedup-redis-username", "", "Redis ACL user authenticating with the password of -alert-dedup-redis-password-file.")
+	flag.StringVar(&opts.alertDedupRedisPassword, "alert-dedup-redis-password-file", "", "File containing the password authenticating with the Redis server of -alert-dedup-redis.")
+	flag.BoolVar(&opts.alertDedupRedisTLS, "alert-dedup-redis-tls", false, "Connect to the Redis server of -alert-dedup-redis over TLS.")
+	flag.StringVar(&opts.alertDedupRedisCA, "alert-dedup-redis-ca", "", "CA bundle used to verify the Redis server of -alert-dedup-redis, instead of the system roots. Implies -alert-dedup-redis-tls.")
+	flag.DurationVar(&opts.alertGitOpsWindow, "alert-gitops-window", 0, "How long an alert for an object deployed by an Argo CD or Flux application suppresses identical alerts for the same revision, as GitOps controllers retry denied syncs. Uses the -alert-dedup backend, or memory if none. Disabled if 0.")
+	flag.BoolVar(&opts.alertStableFingerprints, "alert-stable-fingerprints", false, "Identify policy violation alerts by policy, namespace, owner workload and violation only, labelling them with their fingerprint, so Alertmanager groups alerts sent for the same violation by retries, replicas and successive pods.")
+	flag.BoolVar(&opts.autoScopeRules, "auto-scope-rules", false, "Keep the rules of the -webhook-name webhook limited to the resources matched by loaded policies and the rules of the other enabled validators. Fails to start with validators that do not report their rules.")
+	flag.StringVar(&opts.webhookConfigName, "webhook-config-name", "kubeenforcer", "Name of the ValidatingWebhookConfiguration managed by kubeenforcer.")
+	flag.StringVar(&opts.webhookName, "webhook-name", "", "Name of the webhook within the configuration to manage. All webhooks are managed if empty.")
+	flag.StringVar(&opts.webhookFailurePolicy, "webhook-failure-policy", "", "failurePolicy to enforce on the managed webhook (Fail or Ignore). Left unmanaged if empty.")
+	flag.IntVar(&opts.webhookTimeoutSeconds, "webhook-timeout-seconds", 0, "timeoutSeconds to enforce on the managed webhook, between 1 and 30. Left unmanaged if 0.")
+	flag.StringVar(&opts.webhookSideEffects, "webhook-side-effects", "", "sideEffects to enforce on the managed webhook (None or NoneOnDryRun). Left unmanaged if empty.")
+	flag.StringVar(&opts.collectorAddr, "collector", "", "Address of a central kubeenforcer collector to stream decisions to.")
+	flag.StringVar(&opts.clusterName, "cluster-name", "", "Name this cluster reports to the collector as.")
+	flag.StringVar(&opts.collectorCert, "collector-cert", "", "Client certificate presented to the collector.")
+	flag.StringVar(&opts.collectorKey, "collector-key", "", "Key of the client certificate presented to the collector.")
+	flag.StringVar(&opts.collectorCA, "collector-ca", "", "CA bundle used to verify the collector.")
+	flag.StringVar(&opts.decisionStreamAddr, "decision-stream-addr", "", "Address to serve the gRPC decision stream on. Disabled if empty.")
+	flag.StringVar(&opts.decisionStreamClientCA, "decision-stream-client-ca", "", "CA bundle decision stream subscribers must present client certificates from. Required with -decision-stream-addr.")
+	flag.IntVar(&opts.decisionAPISize, "decision-api-size", 0, "Number of recent decisions kept in memory and served at /api/v1/decisions on the webhook listeners. Disabled if 0. Requires authentication and -admin-users or -admin-groups to be configured.")
+	flag.StringVar(&opts.decisionDB, "decision-db", "", "Path of an embedded database persisting decisions across restarts. When set, /api/v1/decisions and the dashboard are served from it instead of memory.")
+	flag.DurationVar(&opts.decisionDBRetention, "decision-db-retention", 7*24*time.Hour, "How long decisions are kept in the -decision-db database. Forever if 0.")
+	flag.StringVar(&opts.decisionDBMaxSize, "decision-db-max-size", "1Gi", "Size the -decision-db database is kept under by deleting the oldest decisions, e.g. 512Mi. Unlimited if 0.")
+	flag.StringVar(&opts.policyServerURL, "policy-server", "", "URL of a central kubeenforcer policy server to fetch policy bundles from.")
+	flag.StringVar(&opts.policyServerCA, "policy-server-ca", "", "CA bundle used to verify the policy server.")
+	flag.StringVar(&opts.policyServerCert, "policy-server-cert", "", "Client certificate presented to the policy server.")
+	flag.StringVar(&opts.policyServerKey, "policy-server-key", "", "Key of the client certificate presented to the policy server.")
+	flag.StringVar(&opts.policyServerPublicKey, "policy-server-public-key", "", "PEM encoded ed25519 public key policy bundles must be signed with.")
+	flag.DurationVar(&opts.policyServerInterval, "policy-server-interval", time.Minute, "How often to poll the policy server.")
+	flag.StringVar(&opts.opaBundleURL, "opa-bundle", "", "URL of a bundle on a server implementing the OPA Bundle Service API to fetch policies and bindings from, instead of a policy server.")
+	flag.StringVar(&opts.opaBundleCA, "opa-bundle-ca", "", "CA bundle used to verify the OPA bundle server.")
+	flag.StringVar(&opts.opaBundleTokenFile, "opa-bundle-token-file", "", "File containing a bearer token to authenticate to the OPA bundle server with.")
+	flag.StringVar(&opts.opaBundlePublicKey, "opa-bundle-public-key", "", "PEM encoded RSA or ECDSA public key OPA bundles must be signed with. Unsigned bundles are accepted if empty.")
+	flag.StringVar(&opts.opaBundleKeyID, "opa-bundle-key-id", "", "Key ID OPA bundle signatures must name, if they name one.")
+	flag.StringVar(&opts.opaBundleScope, "opa-bundle-scope", "", "Scope OPA bundle signatures must name.")
+	flag.DurationVar(&opts.opaBundleInterval, "opa-bundle-interval", time.Minute, "How often to poll the OPA bundle server.")
+	flag.StringVar(&opts.clusterLabels, "cluster-labels", "", "Labels of this cluster used by the policy server to select a rollout stage, e.g. env=prod,region=eu.")
+	flag.BoolVar(&opts.namespacePolicies, "namespace-policies", false, "Enforce NamespacePolicies defined by application teams in their own namespaces.")
+	flag.BoolVar(&opts.authorizer, "authorizer", false, "Back the CEL authorizer variable with SubjectAccessReviews, so policies can check the requesting user's permissions. Requires create on subjectaccessreviews.")
+	flag.DurationVar(&opts.authorizerAuthorizedTTL, "authorizer-authorized-ttl", 10*time.Second, "How long to cache allowed SubjectAccessReview results.")
+	flag.DurationVar(&opts.authorizerUnauthorizedTTL, "authorizer-unauthorized-ttl", 10*time.Second, "How long to cache denied SubjectAccessReview results.")
+	flag.BoolVar(&opts.autoRemediate, "auto-remediate", false, "Serve /mutate, which fixes violations of policies annotated with "+remediation.AutoRemediateAnnotation+"=true instead of denying them, when a remediation is known.")
+	flag.BoolVar(&opts.mutatingAdmissionPolicies, "mutating-admission-policies", false, "Serve /mutate, which applies MutatingAdmissionPolicies.")
+	flag.StringVar(&opts.securityContextDefaults, "security-context-defaults", "", "Comma separated security context defaults injected on /mutate into pod specs omitting them, in namespaces labelled "+mutation.SecurityContextDefaultsLabel+"=enabled: runAsNonRoot, seccompProfile, dropAllCapabilities, readOnlyRootFilesystem.")
+	flag.StringVar(&opts.maintenanceWindows, "maintenance-windows", "", "YAML file of cron scheduled maintenance windows during which the bindings of selected policies are downgraded from Deny to Audit.")
+	flag.StringVar(&opts.maintenanceWindowsLeaseNS, "maintenance-windows-lease-namespace", os.Getenv("POD_NAMESPACE"), "Namespace of the Lease electing the replica which downgrades and restores bindings during maintenance windows.")
+	flag.BoolVar(&opts.policyExceptions, "policy-exceptions", false, "Stop enforcing policies in the namespaces of active PolicyExceptions until they expire.")
+	flag.DurationVar(&opts.policyExceptionMaxTTL, "policy-exception-max-ttl", 0, "Maximum lifetime of a PolicyException from its creation. Unlimited if 0.")
+	flag.DurationVar(&opts.policyExceptionReviewInterval, "policy-exception-review-interval", 7*24*time.Hour, "How often to alert on active PolicyExceptions as a reminder to review them. Disabled if 0.")
+	flag.StringVar(&opts.policyExceptionPolicies, "policy-exception-policies", "", "Comma separated policies PolicyExceptions may stop enforcing. Exceptions of other policies are not applied. Required with -policy-exceptions.")
+	flag.StringVar(&opts.policyExceptionLeaseNS, "policy-exception-lease-namespace", os.Getenv("POD_NAMESPACE"), "Namespace of the Lease electing the replica which applies PolicyExceptions and alerts on them.")
+	flag.StringVar(&opts.adminAddr, "admin-addr", "", "Address to serve the unauthenticated admin API on, e.g. 127.0.0.1:8090 for /conflicts. Disabled if empty.")
+	flag.StringVar(&opts.policyPriorities, "policy-priorities", "", "Comma separated priorities policies can be labelled with through "+priority.PriorityLabel+". Policies are evaluated from the highest priority to the lowest, unlabelled ones at priority 0. Evaluation order is unspecified if empty.")
+	flag.BoolVar(&opts.shortCircuitDeny, "short-circuit-deny", false, "Stop evaluating lower priority policies once a request is denied. Otherwise the denials of all priorities are reported.")
+	flag.BoolVar(&opts.dashboard, "dashboard", false, "Serve a read-only web dashboard of policies, recent denials, per-policy counts and certificate expiry at /dashboard on the admin address, only to clients connecting from loopback.")
+	flag.BoolVar(&opts.playground, "playground", false, "Serve /admin/eval on the webhook listeners, which evaluates CEL expressions against supplied objects. Requires authentication and -admin-users or -admin-groups to be configured.")
+	flag.StringVar(&opts.explainUsers, "explain-users", "", "Comma separated users who may ask for a trace of how their requests were evaluated, through the "+explain.Annotation+"=true annotation or the "+explain.Header+": true header.")
+	flag.StringVar(&opts.explainGroups, "explain-groups", "", "Comma separated groups whose members may ask for a trace of how their requests were evaluated.")
+	flag.StringVar(&opts.telemetryEndpoint, "telemetry-endpoint", "", "URL anonymous usage telemetry (kubeenforcer version and counts of policies, bindings and requests) is POSTed to. Disabled if empty.")
+	flag.DurationVar(&opts.telemetryInterval, "telemetry-interval", 24*time.Hour, "How often usage telemetry is sent.")
+	flag.StringVar(&opts.grafanaURL, "grafana-url", "", "URL of a Grafana instance to publish denials to as annotations, tagged with the policy and namespace. Disabled if empty.")
+	flag.StringVar(&opts.grafanaTokenFile, "grafana-token-file", "", "File containing a Grafana service account token or API key with permission to create annotations.")
+	flag.StringVar(&opts.grafanaTags, "grafana-tags", "", "Comma separated tags added to every Grafana annotation, e.g. cluster:prod.")
+	flag.StringVar(&opts.splunkHECURL, "splunk-hec-url", "", "URL of a Splunk HTTP Event Collector to send decisions and alerts to, e.g. https://splunk:8088. Disabled if empty.")
+	flag.StringVar(&opts.splunkHECTokenFile, "splunk-hec-token-file", "", "File containing the HTTP Event Collector token.")
+	flag.StringVar(&opts.splunkHECCA, "splunk-hec-ca", "", "CA bundle used to verify the HTTP Event Collector.")
+	flag.StringVar(&opts.splunkIndex, "splunk-index", "", "Splunk index events are written to. The default index of the token if empty.")
+	flag.StringVar(&opts.splunkDecisionSourceType, "splunk-decision-sourcetype", "kubeenforcer:decision", "Splunk source type of decisions.")
+	flag.StringVar(&opts.splunkAlertSourceType, "splunk-alert-sourcetype", "kubeenforcer:alert", "Splunk source type of alerts.")
+	flag.BoolVar(&opts.splunkAllDecisions, "splunk-all-decisions", false, "Send every decision to Splunk. Otherwise only denied, audited and warned requests are sent.")
+	flag.StringVar(&opts.elasticsearchURL, "elasticsearch-url", "", "URL of an Elasticsearch or OpenSearch cluster to index decisions in through the bulk API. Disabled if empty.")
+	flag.StringVar(&opts.elasticsearchIndexPrefix, "elasticsearch-index-prefix", "kubeenforcer-decisions", "Prefix of the daily indices decisions are indexed in, <prefix>-YYYY.MM.DD, and name of their index template.")
+	flag.StringVar(&opts.elasticsearchAPIKeyFile, "elasticsearch-api-key-file", "", "File containing an Elasticsearch API key, base64 encoded as sent in the Authorization header.")
+	flag.StringVar(&opts.elasticsearchUsername, "elasticsearch-username", "", "Username to authenticate to the cluster with, with the password in -elasticsearch-password-file.")
+	flag.StringVar(&opts.elasticsearchPasswordFile, "elasticsearch-password-file", "", "File containing the password of -elasticsearch-username.")
+	flag.StringVar(&opts.elasticsearchCA, "elasticsearch-ca", "", "CA bundle used to verify the cluster.")
+	flag.StringVar(&opts.elasticsearchILMPolicy, "elasticsearch-ilm-policy", "", "Elasticsearch index lifecycle policy the index template assigns to new indices. Not supported by OpenSearch, whose ISM policies select indices with an ism_template.")
+	flag.StringVar(&opts.datadogAPIKeyFile, "datadog-api-key-file", "", "File containing a Datadog API key to send denials to Datadog as events with. Disabled if empty.")
+	flag.StringVar(&opts.datadogSite, "datadog-site", envOrDefault("DD_SITE", "datadoghq.com"), "Datadog site of the organization, e.g. datadoghq.eu.")
+	flag.StringVar(&opts.datadogTags, "datadog-tags", "", "Comma separated tags added to every Datadog event and metric, e.g. env:prod.")
+	flag.StringVar(&opts.datadogTeamLabel, "datadog-team-label", "", "Namespace label whose value tags Datadog events and metrics with team:<value>, e.g. team.")
+	flag.BoolVar(&opts.datadogMetrics, "datadog-metrics", false, "Also submit the count of all decisions to Datadog as the kubeenforcer.decisions metric.")
+	flag.StringVar(&opts.googleChatConfig, "google-chat-config", "", "YAML file routing denials by namespace to the incoming webhooks of Google Chat spaces, posted as cards. Disabled if empty.")
+	flag.StringVar(&opts.mattermostWebhookFile, "mattermost-webhook-file", "", "File containing the URL of a Mattermost incoming webhook to post alerts to, after deduplication. Disabled if empty.")
+	flag.StringVar(&opts.rocketChatWebhookFile, "rocketchat-webhook-file", "", "File containing the URL of a Rocket.Chat incoming webhook to post alerts to, after deduplication. Disabled if empty.")
+	flag.StringVar(&opts.chatMessageTemplate, "chat-message-template", "", "File containing a Go template of the text of the alerts posted to Mattermost and Rocket.Chat, executed with the alert. A summary of the alert if empty.")
+	flag.StringVar(&opts.gitHubTokenFile, "commit-status-github-token-file", "", "File containing a GitHub token allowed to create commit statuses, to post a failed status on the commit of every denied object deployed from GitHub. Disabled if empty.")
+	flag.StringVar(&opts.gitHubAPI, "commit-status-github-api", "https://api.github.com", "API URL of GitHub, e.g. https://github.example.com/api/v3 for GitHub Enterprise Server.")
+	flag.StringVar(&opts.gitLabTokenFile, "commit-status-gitlab-token-file", "", "File containing a GitLab token with the api scope, to post a failed status on the commit of every denied object deployed from GitLab. Disabled if empty.")
+	flag.StringVar(&opts.gitLabURL, "commit-status-gitlab-url", "https://gitlab.com", "URL of GitLab.")
+	flag.StringVar(&opts.argoCDNamespace, "commit-status-argocd-namespace", "argocd", "Namespace of the Argo CD applications the commits of objects tracked by Argo CD are read from. Requires get access to applications.argoproj.io.")
+	flag.StringVar(&opts.gitRepositories, "commit-status-repositories", "", "Comma separated repositories, e.g. github.com/example/payments, whose commits are read from the git annotations of objects. Commits of other repositories are only read from the Argo CD applications managing the objects.")
+	flag.StringVar(&opts.jiraURL, "jira-url", "", "URL of a Jira instance to open issues in for policy violations of a workload that persist for -jira-threshold. Disabled if empty.")
+	flag.StringVar(&opts.jiraProject, "jira-project", "", "Key of the Jira project issues are opened in.")
+	flag.StringVar(&opts.jiraIssueType, "jira-issue-type", "Task", "Type of the Jira issues.")
+	flag.StringVar(&opts.jiraUser, "jira-user", "", "Email address of the Jira Cloud user of the API token in -jira-token-file. Empty for a Jira Data Center personal access token.")
+	flag.StringVar(&opts.jiraTokenFile, "jira-token-file", "", "File containing a Jira API token or personal access token.")
+	flag.StringVar(&opts.jiraCA, "jira-ca", "", "CA bundle used to verify the Jira instance.")
+	flag.DurationVar(&opts.jiraThreshold, "jira-threshold", 24*time.Hour, "How long a violation must persist for a Jira issue to be opened, and how often the issue is commented on while it does.")
+	flag.StringVar(&opts.auditEventsFile, "audit-events-file", "", "File to append decisions to as Kubernetes audit events (audit.k8s.io/v1), one JSON event per line like the API server log backend, or - for stdout. Disabled if empty.")
+	flag.StringVar(&opts.auditEventsWebhook, "audit-events-webhook", "", "URL to post decisions to as Kubernetes audit event lists, like the API server webhook backend. Disabled if empty.")
+	flag.StringVar(&opts.auditEventsWebhookCA, "audit-events-webhook-ca", "", "CA bundle used to verify the audit events webhook.")
+	flag.StringVar(&opts.auditEventsWebhookCert, "audit-events-webhook-cert", "", "Client certificate presented to the audit events webhook.")
+	flag.StringVar(&opts.auditEventsWebhookKey, "audit-events-webhook-key", "", "Key of the client certificate presented to the audit events webhook.")
+	flag.StringVar(&opts.mirrorURL, "mirror-url", "", "Base URL of a secondary kubeenforcer, e.g. one running a candidate version or policy set, to mirror a sample of validated admission reviews to, asynchronously and on the path they were received on. Its responses only feed metrics. Disabled if empty.")
+	flag.Float64Var(&opts.mirrorRate, "mirror-sample-rate", 0.1, "Fraction of admission reviews mirrored to -mirror-url, between 0 and 1.")
+	flag.StringVar(&opts.mirrorCA, "mirror-ca", "", "CA bundle used to verify the secondary webhook of -mirror-url.")
+	flag.StringVar(&opts.mirrorCert, "mirror-cert", "", "Client certificate presented to the secondary webhook of -mirror-url.")
+	flag.StringVar(&opts.mirrorKey, "mirror-key", "", "Key of the client certificate presented to the secondary webhook of -mirror-url.")
+	flag.BoolVar(&opts.bindingOutcomes, "binding-outcomes", false, "Track the match, deny and error rates of every policy binding, and flag bindings whose errors spike or which match no request, as metrics and on /outcomes of the admin address.")
+	flag.Float64Var(&opts.bindingErrorSpikeFactor, "binding-error-spike-factor", 3, "How many times its error rate over the previous day the error rate of a binding over the last hour must reach to be flagged.")
+	flag.DurationVar(&opts.bindingIdleAfter, "binding-idle-after", 7*24*time.Hour, "How long a binding must match no request to be flagged. Disabled if 0.")
+	flag.Float64Var(&opts.policyErrorBudget, "policy-error-budget", 0, "Fraction of the requests matched by a policy failing closed whose evaluation may fail before its evaluation errors are ignored for a cooldown, e.g. 0.1. Enables binding outcome tracking. Disabled if 0.")
+	flag.DurationVar(&opts.policyErrorBudgetWindow, "policy-error-budget-window", 10*time.Minute, "Period over which the evaluation error rate of policies is measured.")
+	flag.DurationVar(&opts.policyErrorBudgetCooldown, "policy-error-budget-cooldown", 30*time.Minute, "How long a policy exceeding its error budget is degraded before failing closed again.")
+	flag.StringVar(&opts.policyErrorBudgetLeaseNS, "policy-error-budget-lease-namespace", os.Getenv("POD_NAMESPACE"), "Namespace of the Lease electing the replica which degrades and restores policies.")
+	flag.BoolVar(&opts.typeCheckPolicies, "type-check-policies", false, "Type check the expressions of policies against the schemas of the resources they match, and publish warnings in their status.typeChecking.")
+	flag.BoolVar(&opts.validatePolicies, "validate-policies", false, "Reject ValidatingAdmissionPolicies whose expressions do not compile, and bindings with invalid validation actions.")
+	flag.StringVar(&opts.policyCacheDir, "policy-cache-dir", "", "Directory persisting the results of -validate-policies across restarts, keyed by the hash of each policy, so policies validated before are not compiled again. Disabled if empty.")
+	flag.IntVar(&opts.policyCacheSize, "policy-cache-size", 4096, "Results kept in -policy-cache-dir, the least recently used being deleted first. Unlimited if 0.")
+	flag.StringVar(&opts.validatorFailurePolicies, "validator-failure-policies", "", "Comma separated name=Fail|Ignore failure policies of the validators: policy-validation, schema-validation, policies, uniqueness, network-policy, metadata-requirements and enabled plugin validators. Errors of validators that are not denials fail requests with Fail, the default, and are ignored with Ignore.")
+	flag.StringVar(&opts.validatePaths, "validate-paths", "", "Comma separated /path=validator+validator paths served in addition to /validate, each validating with only the named validators, e.g. /validate/rbac=policies, so they can be registered as webhooks with their own rules, failure policy and timeout. /validate can be listed to restrict its validators too.")
+	flag.StringVar(&opts.internalErrorPolicy, "internal-error-policy", "", "Fail or Ignore: deny, or allow with a warning, requests that cannot be evaluated because of internal errors, e.g. objects that fail to decode, stale informers or panics. If empty, they are answered with HTTP errors, so the failure policy of the webhook configuration applies.")
+	flag.StringVar(&opts.internalErrorPolicies, "internal-error-policies", "", "Comma separated /path=Fail|Ignore overrides of -internal-error-policy for /validate and the validate paths, e.g. /validate/rbac=Fail.")
+	flag.StringVar(&opts.pluginValidators, "plugin-validators", "", "Comma separated registered validators to enable, appended to the validator chain under their name.")
+	flag.StringVar(&opts.pluginMutators, "plugin-mutators", "", "Comma separated registered mutators to enable, run on /mutate after the built-in ones.")
+	flag.StringVar(&opts.pluginConfig, "plugin-config", "", "YAML file mapping plugin names to their configuration.")
+	flag.BoolVar(&opts.scaleTargetMetadata, "scale-target-metadata", false, "Add the labels and annotations of the scaled workload to the Scale objects of scale subresource requests, so policies can select workloads on scaling. Requires get access to the workloads.")
+	flag.StringVar(&opts.uniquenessConstraints, "uniqueness-constraints", "", "YAML file of constraints requiring field values, e.g. Ingress hosts, to be unique across all objects of a resource. Requires list and watch access to the constrained resources.")
+	flag.IntVar(&opts.expressionCacheSize, "expression-cache-size", 0, "Cache the results of comprehensions over the request shared by several policies, e.g. object.spec.containers.all(c, has(c.resources)), in up to this many entries, so they are computed once per request. Disabled if 0, when policy variables alone are cached, in up to 4096 entries.")
+	flag.StringVar(&opts.lookupResources, "lookup-resources", "", "Comma separated resources, e.g. namespaces,networkpolicies.networking.k8s.io, policies can read from informer caches through the lookup.get(resource, namespace, name) and lookup.list(resource, namespace) CEL functions. Requires list and watch access to the resources.")
+	flag.StringVar(&opts.requireNetworkPolicy, "require-network-policy", "", "Deny or Warn on creating workloads in namespaces without any NetworkPolicy. Namespaces labelled "+networkpolicy.ModeLabel+"=Deny|Warn|Disabled override it; with Disabled, only labelled namespaces are checked. Off if empty.")
+	flag.StringVar(&opts.deletionProtection, "deletion-protection", "", "Comma separated resources, e.g. namespaces,customresourcedefinitions.apiextensions.k8s.io, whose objects labelled "+deletionprotection.ProtectedLabel+"=true may not be deleted. Off if empty.")
+	flag.StringVar(&opts.schemaValidation, "schema-validation", "", "Deny or Warn on custom resources that do not match the OpenAPI schema published for their kind, before policies evaluate them. Off if empty.")
+	flag.BoolVar(&opts.metadataRequirements, "metadata-requirements", false, "Enforce MetadataRequirements, which require matched objects to carry labels and annotations with valid values.")
+	flag.BoolVar(&opts.bindingMetadata, "binding-metadata", false, "Add the labels and annotations of the bound pod, and the labels of the target node as target.labels, to the Binding objects of pod binding requests, so policies can constrain scheduling. Requires get access to pods and nodes.")
+	flag.BoolVar(&opts.resolveNames, "resolve-generated-names", false, "Report audited objects created with generateName by the name the API server generates, watching for their creation, and defer their alerts and decision records until then. Requires list and watch access to the audited resources.")
+	flag.DurationVar(&opts.resolveNamesTimeout, "resolve-generated-names-timeout", 30*time.Second, "How long to wait for objects created with generateName to be created before reporting them without a name.")
+	flag.BoolVar(&opts.helmReleaseSecrets, "helm-release-secrets", false, "Look up Helm releases in the Secrets Helm stores them in, to name the release of objects labelled with it but not annotated, such as pods, and the chart of releases. Requires get, list and watch access to Secrets.")
+	flag.BoolVar(&opts.noEgress, "no-egress", false, "Air-gapped mode: refuse to start if any feature connecting to anything but the API server is configured, such as alertmanager, Redis, Vault, a collector, a policy server or telemetry.")
+	flag.BoolVar(&opts.fips, "fips", false, "FIPS mode: refuse to start unless built with FIPS 140 validated crypto (GOEXPERIMENT=boringcrypto), which restricts TLS to FIPS-approved versions, cipher suites and curves, or if any feature using other algorithms is configured, such as ed25519 signed policy bundles.")
+	opts.logLevels = loglevel.New()
+	opts.logLevels.AddFlags(flag.CommandLine)
+	flag.StringVar(&opts.otlpLogsEndpoint, "otlp-logs-endpoint", os.Getenv("OTEL_EXPORTER_OTLP_LOGS_ENDPOINT"), "OTLP/HTTP endpoint of an OpenTelemetry collector to export logs to in addition to stderr, e.g. http://otel-collector:4318. Disabled if empty.")
+	flag.StringVar(&opts.otlpLogsHeaders, "otlp-logs-headers", os.Getenv("OTEL_EXPORTER_OTLP_LOGS_HEADERS"), "Comma separated key=value headers sent with exported logs, e.g. for authentication.")
+	flag.StringVar(&opts.otlpLogsCA, "otlp-logs-ca", "", "CA bundle used to verify the OTLP endpoint.")
+	flag.StringVar(&opts.largeObjectThreshold, "large-object-threshold", "0", "Size above which the objects of an admission review, e.g. huge ConfigMaps or custom resources, are decoded straight into unstructured objects and their raw bytes released early, to cap the memory used per request, e.g. 1Mi. Disabled if 0.")
+	flag.IntVar(&opts.logAllowedEvery, "log-sample-allowed", 1, "Log the review response of only one in this many allowed requests, at -v=2. Denials are always logged.")
+	flag.StringVar(&opts.redactionConfig, "redaction-config", "", "YAML file of rules selecting fields of objects, by kind, whose values are masked in logs, decision records and alerts.")
 	flag.Parse()
 
 	klog.EnableContextualLogging(true)
 
+	if err := checkWebhookConfiguration(opts); err != nil {
+		klog.Errorf("Invalid webhook configuration: %v", err)
+		os.Exit(1)
+	}
+
+	if opts.noEgress {
+		if err := checkNoEgress(opts); err != nil {
+			klog.Errorf("Invalid configuration with -no-egress: %v", err)
+			os.Exit(1)
+		}
+		klog.Info("Running without egress, outbound network features are disabled")
+	}
+
+	if opts.fips {
+		if err := checkFIPS(fipsIncompatibleFeatures(opts)); err != nil {
+			klog.Errorf("Invalid configuration with -fips: %v", err)
+			os.Exit(1)
+		}
+		klog.Info("Running in FIPS mode, using FIPS 140 validated crypto")
+	}
+
+	if opts.otlpLogsEndpoint != "" {
+		exporter, err := newLogExporter(opts)
+		if err != nil {
+			klog.Errorf("Failed to configure OTLP log export: %v", err)
+			os.Exit(1)
+		}
+		otellog.Install(exporter)
+
+		// Logs are exported until the end, after the serving context
+		logContext, stopLogs := context.WithCancel(context.Background())
+		exported := make(chan struct{})
+		go func() {
+			defer close(exported)
+			exporter.Run(logContext)
+		}()
+		defer func() {
+			stopLogs()
+			<-exported
+		}()
+	}
+
 	// Handle SIGINT and SIGTERM by cancelling the root context
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
+	serve(ctx, opts)
+}
+
+// serve connects to the cluster, starts the policy informers and runs the
+// webhook server until ctx is cancelled or one of the workers fails.
+func serve(ctx context.Context, opts options) {
 	restConfig, err := loadClientConfig()
 	if err != nil {
 		fmt.Printf("Failed to load Client Configuration: %v", err)
@@ -76,8 +650,31 @@ func main() {
 		return
 	}
 
-	// Override the typed validating admission policy client in the kubeClient
-	kubeClient := v1alpha1.NewWrappedClient(unwrappedKubeClient, customClient)
+	// Override the typed validating admission policy client in the kubeClient,
+	// whose policies have their variables expanded
+	policyClient := v1alpha1.NewWrappedClient(unwrappedKubeClient, customClient)
+	// Variables are bound with the cache marking shared comprehensions, so
+	// it is enabled even if they are not cached
+	cacheSize := opts.expressionCacheSize
+	if cacheSize == 0 {
+		cacheSize = exprcache.DefaultSize
+	}
+	exprcache.Enable(cacheSize)
+	if opts.expressionCacheSize > 0 {
+		// Shared comprehensions are marked before variables are expanded,
+		// which would bind them to variables
+		policyClient = exprcache.NewClient(policyClient)
+	}
+	if opts.policyErrorBudget > 0 {
+		policyClient = errorbudget.NewClient(policyClient)
+	}
+	if opts.maintenanceWindows != "" {
+		policyClient = maintenance.NewClient(policyClient)
+	}
+	if opts.policyExceptions {
+		policyClient = exception.NewClient(policyClient)
+	}
+	kubeClient := variables.NewClient(policyClient, exprcache.Memo)
 
 	dynamicClient, err := dynamic.NewForConfig(restConfig)
 	if err != nil {
@@ -91,15 +688,119 @@ func main() {
 		return
 	}
 
+	var hec *splunk.HEC
+	if opts.splunkHECURL != "" {
+		hec, err = newHEC(opts)
+		if err != nil {
+			klog.Errorf("Failed to create the Splunk HTTP Event Collector client: %v", err)
+			return
+		}
+	}
+
+	chatNotifiers, err := newChatNotifiers(opts)
+	if err != nil {
+		klog.Errorf("Failed to create the chat notifiers: %v", err)
+		return
+	}
+
+	var jiraTracker *jira.Tracker
+	if opts.jiraURL != "" {
+		jiraTracker, err = newJiraTracker(opts)
+		if err != nil {
+			klog.Errorf("Failed to create the Jira integration: %v", err)
+			return
+		}
+	}
+
+	var alertForwarders []alertmanager.Forwarder
+	if hec != nil {
+		alertForwarders = append(alertForwarders, hec)
+	}
+	for _, notifier := range chatNotifiers {
+		alertForwarders = append(alertForwarders, notifier)
+	}
+	if jiraTracker != nil {
+		alertForwarders = append(alertForwarders, jiraTracker)
+	}
+
+	var alerter *alertmanager.AlertManager
+	if opts.alertmanagerHost != "" || len(alertForwarders) > 0 {
+		// Without -alertmanager, alerts are only forwarded
+		alerter = alertmanager.New(opts.alertmanagerHost, "")
+		alerter.CertFile = opts.alertmanagerCert
+		alerter.KeyFile = opts.alertmanagerKey
+		alerter.CAFile = opts.alertmanagerCA
+		alerter.StableFingerprints = opts.alertStableFingerprints
+
+		// Windows are set in milliseconds in Redis, which rejects 0
+		if opts.alertDedup != "none" && opts.alertDedup != "" && opts.alertDedupWindow < time.Millisecond {
+			klog.Errorf("-alert-dedup-window must be at least 1ms, got %v", opts.alertDedupWindow)
+			return
+		}
+		if opts.alertGitOpsWindow != 0 && opts.alertGitOpsWindow < time.Millisecond {
+			klog.Errorf("-alert-gitops-window must be at least 1ms, or 0 to disable it, got %v", opts.alertGitOpsWindow)
+			return
+		}
+
+		var redisOptions alertmanager.RedisOptions
+		if opts.alertDedup == "redis" {
+			if redisOptions, err = newRedisOptions(opts); err != nil {
+				klog.Errorf("Failed to configure the alert deduplication Redis connection: %v", err)
+				return
+			}
+		}
+		switch opts.alertDedup {
+		case "none", "":
+		case "memory":
+			alerter.Dedup = alertmanager.NewMemoryDeduplicator(opts.alertDedupWindow)
+		case "configmap":
+			alerter.Dedup = alertmanager.NewConfigMapDeduplicator(unwrappedKubeClient, opts.alertDedupNamespace, opts.alertDedupConfigMap, opts.alertDedupWindow)
+		case "redis":
+			alerter.Dedup = alertmanager.NewRedisDeduplicator(redisOptions, opts.alertDedupWindow)
+		default:
+			klog.Errorf("Unknown alert deduplication backend %q", opts.alertDedup)
+			return
+		}
+		if opts.alertGitOpsWindow > 0 {
+			switch opts.alertDedup {
+			case "configmap":
+				// Its own ConfigMap, which is pruned by its window
+				alerter.GitOpsDedup = alertmanager.NewConfigMapDeduplicator(unwrappedKubeClient, opts.alertDedupNamespace, opts.alertDedupConfigMap+"-gitops", opts.alertGitOpsWindow)
+			case "redis":
+				alerter.GitOpsDedup = alertmanager.NewRedisDeduplicator(redisOptions, opts.alertGitOpsWindow)
+			default:
+				alerter.GitOpsDedup = alertmanager.NewMemoryDeduplicator(opts.alertGitOpsWindow)
+			}
+		}
+		alerter.Forwarders = alertForwarders
+	}
+
+	certSource, err := newCertSource(opts, unwrappedKubeClient)
+	if err != nil {
+		klog.Errorf("Failed to configure certificate source: %v", err)
+		return
+	}
+
 	// used to keep process alive until all workers are finished
 	waitGroup := sync.WaitGroup{}
 	serverContext, serverCancel := context.WithCancel(ctx)
 
+	if certSource != nil {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			if err := certSource.Run(serverContext); err != nil {
+				klog.Errorf("certificate source stopped due to error: %v", err)
+			}
+		}()
+	}
+
 	// Start any informers
 	// What is appropriate resync perriod?
 	factory := informers.NewSharedInformerFactory(kubeClient, 30*time.Second)
 	customFactory := externalversions.NewSharedInformerFactory(customClient, 30*time.Second)
 	apiextensionsFactory := apiextensionsinformers.NewSharedInformerFactory(apiextensionsClient, 30*time.Second)
+	dynamicFactory := dynamicinformer.NewDynamicSharedInformerFactory(dynamicClient, 30*time.Second)
 
 	restmapper := meta.NewLazyRESTMapperLoader(func() (meta.RESTMapper, error) {
 		groupResources, err := restmapper.GetAPIGroupResources(kubeClient.Discovery())
@@ -115,6 +816,16 @@ func main() {
 		return false, nil
 	})
 
+	// Lookups are declared in the CEL libraries, so must be enabled before
+	// any policy is compiled
+	if opts.lookupResources != "" {
+		if err := lookup.Enable(dynamicFactory, restmapper, splitList(opts.lookupResources)); err != nil {
+			klog.Errorf("Failed to enable lookups: %v", err)
+			serverCancel()
+			return
+		}
+	}
+
 	// structuralschemaController := structuralschema.NewController(
 	// 	apiextensionsFactory.Apiextensions().V1().CustomResourceDefinitions().Informer(),
 	// )
@@ -123,12 +834,143 @@ func main() {
 		Run(context.Context) error
 	}
 
-	validators := []admission.ValidationInterface{
-		v1alpha1.NewPlugin(factory, kubeClient, restmapper, schemaresolver.New(apiextensionsFactory.Apiextensions().V1().CustomResourceDefinitions(), kubeClient.Discovery()), dynamicClient, nil),
+	var policyAuthorizer authorizer.Authorizer
+	if opts.authorizer {
+		policyAuthorizer = authz.NewSubjectAccessReview(unwrappedKubeClient, opts.authorizerAuthorizedTTL, opts.authorizerUnauthorizedTTL)
+	}
+
+	schemaResolver := crdscheme.NewResolver(apiextensionsFactory.Apiextensions().V1().CustomResourceDefinitions(), kubeClient.Discovery(), opts.schemaRefreshInterval)
+
+	// Every priority is evaluated by its own plugin, whose informers only
+	// load the policies of that priority
+	var validators []namedValidator
+	if opts.validatePolicies {
+		var cache *policycache.Cache
+		if opts.policyCacheDir != "" {
+			// Validation results depend on the functions declared by the
+			// CEL libraries, so the lookups enabled are hashed into keys
+			cache, err = policycache.Open(opts.policyCacheDir, policycache.Options{Salt: "lookup=" + opts.lookupResources, MaxEntries: opts.policyCacheSize})
+			if err != nil {
+				klog.Errorf("Failed to open the policy cache: %v", err)
+				serverCancel()
+				return
+			}
+		}
+		validators = append(validators, namedValidator{"policy-validation", policycheck.New(cache)})
+	} else if opts.policyCacheDir != "" {
+		klog.Errorf("-policy-cache-dir requires -validate-policies")
+		serverCancel()
+		return
+	}
+	if opts.schemaValidation != "" {
+		mode, err := schemavalidation.ParseMode(opts.schemaValidation)
+		if err != nil {
+			klog.Errorf("Invalid -schema-validation: %v", err)
+			serverCancel()
+			return
+		}
+		validators = append(validators, namedValidator{"schema-validation", schemavalidation.New(mode, schemaResolver)})
+	}
+	var tierFactories []informers.SharedInformerFactory
+	if opts.policyPriorities == "" {
+		validators = append(validators, namedValidator{"policies", v1alpha1.NewPlugin(factory, kubeClient, restmapper, schemaResolver, dynamicClient, policyAuthorizer)})
+	} else {
+		levels, err := priority.ParseLevels(splitList(opts.policyPriorities))
+		if err != nil {
+			klog.Errorf("Invalid policy priorities: %v", err)
+			serverCancel()
+			return
+		}
+		var tiers []priority.Tier
+		for _, level := range levels {
+			tierClient := priority.NewClient(kubeClient, level.Selector)
+			tierFactory := informers.NewSharedInformerFactory(tierClient, 30*time.Second)
+			tierFactories = append(tierFactories, tierFactory)
+			tiers = append(tiers, priority.Tier{
+				Priority:  level.Priority,
+				Validator: v1alpha1.NewPlugin(tierFactory, tierClient, restmapper, schemaResolver, dynamicClient, policyAuthorizer),
+			})
+		}
+		validators = append(validators, namedValidator{"policies", priority.NewValidator(tiers, opts.shortCircuitDeny)})
+	}
+
+	if opts.uniquenessConstraints != "" {
+		constraints, err := uniqueness.LoadConstraints(opts.uniquenessConstraints)
+		if err != nil {
+			klog.Errorf("Failed to load uniqueness constraints: %v", err)
+			serverCancel()
+			return
+		}
+		validator, err := uniqueness.New(dynamicFactory, constraints)
+		if err != nil {
+			klog.Errorf("Failed to index uniqueness constraints: %v", err)
+			serverCancel()
+			return
+		}
+		validators = append(validators, namedValidator{"uniqueness", validator})
+	}
+
+	if opts.requireNetworkPolicy != "" {
+		mode, err := networkpolicy.ParseMode(opts.requireNetworkPolicy)
+		if err != nil {
+			klog.Errorf("Invalid -require-network-policy: %v", err)
+			serverCancel()
+			return
+		}
+		validators = append(validators, namedValidator{"network-policy", networkpolicy.New(mode, factory.Core().V1().Namespaces(), factory.Networking().V1().NetworkPolicies())})
+	}
+
+	if opts.deletionProtection != "" {
+		resources, err := deletionprotection.ParseResources(opts.deletionProtection)
+		if err != nil {
+			klog.Errorf("Invalid -deletion-protection: %v", err)
+			serverCancel()
+			return
+		}
+		validators = append(validators, namedValidator{"deletion-protection", deletionprotection.New(resources)})
+	}
+
+	if opts.metadataRequirements {
+		validators = append(validators, namedValidator{"metadata-requirements", requiredmetadata.New(
+			dynamicFactory.ForResource(requiredmetadata.GroupVersionResource),
+			factory.Core().V1().Namespaces().Lister(),
+			unwrappedKubeClient,
+		)})
+	}
+
+	pluginConfig, err := loadPluginConfig(opts.pluginConfig)
+	if err != nil {
+		klog.Errorf("Failed to load plugin configuration: %v", err)
+		serverCancel()
+		return
+	}
+	pluginValidators, pluginMutators, err := newPlugins(splitList(opts.pluginValidators), splitList(opts.pluginMutators), pluginConfig, registry.Options{
+		KubeClient:      kubeClient,
+		DynamicClient:   dynamicClient,
+		InformerFactory: factory,
+		RESTMapper:      restmapper,
+	})
+	if err != nil {
+		klog.Errorf("Failed to create plugins: %v", err)
+		serverCancel()
+		return
+	}
+	validators = append(validators, pluginValidators...)
+
+	if opts.typeCheckPolicies {
+		controller := typecheck.New(customClient, customFactory.Admissionregistration().V1alpha1().ValidatingAdmissionPolicies(), typecheck.NewChecker(schemaResolver, restmapper))
+
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			if err := controller.Run(serverContext); err != nil {
+				klog.Errorf("policy type checking controller stopped due to error: %v", err)
+			}
+		}()
 	}
 
 	for _, v := range validators {
-		if r, ok := v.(runnable); ok {
+		if r, ok := v.validator.(runnable); ok {
 			waitGroup.Add(1)
 			go func() {
 				err := r.Run(serverContext)
@@ -141,32 +983,1244 @@ func main() {
 		}
 	}
 
-	webhook := webhook.New(listenAddr, certFile, keyFile, alertmanagerHost, clientsetscheme.Scheme, validator.NewMulti(validators...))
+	reconcilerOptions := webhookconfig.Options{
+		ConfigName:  opts.webhookConfigName,
+		WebhookName: opts.webhookName,
+		ScopeRules:  opts.autoScopeRules,
+	}
+	if opts.autoScopeRules {
+		// Other webhooks of the configuration, e.g. those of the validate
+		// paths, serve other validators
+		if opts.webhookName == "" {
+			klog.Errorf("-webhook-name is required with -auto-scope-rules")
+			serverCancel()
+			return
+		}
+		rules, err := extraRules(validators)
+		if err != nil {
+			klog.Errorf("Cannot scope the webhook rules: %v", err)
+			serverCancel()
+			return
+		}
+		reconcilerOptions.ExtraRules = rules
+	}
+	if opts.webhookFailurePolicy != "" {
+		failurePolicy := admissionregistrationv1.FailurePolicyType(opts.webhookFailurePolicy)
+		reconcilerOptions.FailurePolicy = &failurePolicy
+	}
+	if opts.webhookTimeoutSeconds > 0 {
+		timeoutSeconds := int32(opts.webhookTimeoutSeconds)
+		reconcilerOptions.TimeoutSeconds = &timeoutSeconds
+	}
+	if opts.webhookSideEffects != "" {
+		sideEffects := admissionregistrationv1.SideEffectClass(opts.webhookSideEffects)
+		reconcilerOptions.SideEffects = &sideEffects
+	}
+	if alerter != nil {
+		reconcilerOptions.OnDrift = func(webhook, field, actual, desired string) {
+			alerter.Alert(&alertmanager.AlertInfo{
+				Name:        "Webhook configuration drift",
+				Severity:    "warning",
+				Resource:    "validatingwebhookconfigurations",
+				Instance:    opts.webhookConfigName,
+				Description: fmt.Sprintf("%s of webhook %s was changed to %s, reverting to %s", field, webhook, actual, desired),
+			})
+		}
+	}
+
+	if reconcilerOptions.ScopeRules || reconcilerOptions.FailurePolicy != nil || reconcilerOptions.TimeoutSeconds != nil || reconcilerOptions.SideEffects != nil {
+		reconciler := webhookconfig.New(
+			unwrappedKubeClient,
+			customFactory.Admissionregistration().V1alpha1().ValidatingAdmissionPolicies(),
+			factory.Admissionregistration().V1().ValidatingWebhookConfigurations(),
+			reconcilerOptions,
+		)
 
-	// Start HTTP REST server for webhook
-	waitGroup.Add(1)
-	go func() {
-		defer func() {
-			// Cancel the server context to stop other workers
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			if err := reconciler.Run(serverContext); err != nil {
+				klog.Errorf("webhook configuration reconciler stopped due to error: %v", err)
+			}
+		}()
+	}
+
+	var redactor *redaction.Redactor
+	if opts.redactionConfig != "" {
+		var err error
+		if redactor, err = redaction.Load(opts.redactionConfig); err != nil {
+			klog.Errorf("Failed to load redaction config: %v", err)
 			serverCancel()
-			waitGroup.Done()
+			return
+		}
+	}
+
+	var decisionSinks []decision.Sink
+	if opts.collectorAddr != "" {
+		tlsConfig, err := collector.TLSConfig(opts.collectorCert, opts.collectorKey, opts.collectorCA, false)
+		if err != nil {
+			klog.Errorf("Failed to load collector TLS configuration: %v", err)
+			serverCancel()
+			return
+		}
+
+		policyLister := customFactory.Admissionregistration().V1alpha1().ValidatingAdmissionPolicies().Lister()
+		bindingLister := customFactory.Admissionregistration().V1alpha1().ValidatingAdmissionPolicyBindings().Lister()
+		agent := collector.NewAgent(opts.collectorAddr, opts.clusterName, tlsConfig, func() (*collector.PolicyStatus, error) {
+			status := &collector.PolicyStatus{}
+			policies, err := policyLister.List(labels.Everything())
+			if err != nil {
+				return nil, err
+			}
+			for _, p := range policies {
+				status.Policies = append(status.Policies, p.Name)
+			}
+			bindings, err := bindingLister.List(labels.Everything())
+			if err != nil {
+				return nil, err
+			}
+			for _, b := range bindings {
+				status.Bindings = append(status.Bindings, b.Name)
+			}
+			return status, nil
+		})
+		decisionSinks = append(decisionSinks, agent)
+
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			if err := agent.Run(serverContext); err != nil {
+				klog.Errorf("collector agent stopped due to error: %v", err)
+			}
 		}()
+	}
 
-		cancellationReason := webhook.Run(serverContext)
-		klog.Infof("webhook server closure reason: %v", cancellationReason)
+	if opts.decisionStreamAddr != "" {
+		tlsConfig, err := decisionStreamTLSConfig(opts)
+		if err != nil {
+			klog.Errorf("Failed to load decision stream TLS configuration: %v", err)
+			serverCancel()
+			return
+		}
+
+		l, err := net.Listen("tcp", opts.decisionStreamAddr)
+		if err != nil {
+			klog.Errorf("Failed to listen for decision stream: %v", err)
+			serverCancel()
+			return
+		}
+
+		streamServer := decisionstream.NewServer()
+		decisionSinks = append(decisionSinks, streamServer)
+
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			if err := streamServer.Serve(serverContext, l, tlsConfig); err != nil && serverContext.Err() == nil {
+				klog.Errorf("decision stream stopped due to error: %v", err)
+			}
+		}()
+	}
+
+	var decisionStore decision.Querier
+	if opts.decisionDB != "" {
+		maxSize, err := resource.ParseQuantity(opts.decisionDBMaxSize)
+		if err != nil {
+			klog.Errorf("Invalid -decision-db-max-size: %v", err)
+			serverCancel()
+			return
+		}
+		db, err := decisiondb.Open(opts.decisionDB, decisiondb.Options{Retention: opts.decisionDBRetention, MaxSize: maxSize.Value()})
+		if err != nil {
+			klog.Errorf("Failed to open decision database: %v", err)
+			serverCancel()
+			return
+		}
+		decisionStore = db
+		decisionSinks = append(decisionSinks, db)
+
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			if err := db.Run(serverContext); err != nil {
+				klog.Errorf("decision database stopped due to error: %v", err)
+			}
+		}()
+	} else if opts.decisionAPISize > 0 || (opts.dashboard && opts.adminAddr != "") {
+		size := opts.decisionAPISize
+		if size == 0 {
+			size = dashboard.DefaultDecisions
+		}
+		store := decision.NewStore(size)
+		decisionStore = store
+		decisionSinks = append(decisionSinks, store)
+	}
+
+	if opts.policyServerURL != "" || opts.opaBundleURL != "" {
+		var agent *distribution.Agent
+		var err error
+		switch {
+		case opts.policyServerURL != "" && opts.opaBundleURL != "":
+			err = fmt.Errorf("-policy-server and -opa-bundle are mutually exclusive")
+		case opts.policyServerURL != "":
+			agent, err = newDistributionAgent(opts, customClient)
+		default:
+			agent, err = newOPABundleAgent(opts, customClient)
+		}
+		if err != nil {
+			klog.Errorf("Failed to configure policy distribution agent: %v", err)
+			serverCancel()
+			return
+		}
+
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			if err := agent.Run(serverContext); err != nil {
+				klog.Errorf("policy distribution agent stopped due to error: %v", err)
+			}
+		}()
+	}
+
+	if opts.namespacePolicies {
+		controller := namespacepolicy.New(customClient, dynamicFactory.ForResource(namespacepolicy.GroupVersionResource))
+
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			if err := controller.Run(serverContext); err != nil {
+				klog.Errorf("namespace policy controller stopped due to error: %v", err)
+			}
+		}()
+	}
+
+	if opts.maintenanceWindows != "" {
+		windows, err := maintenance.LoadWindows(opts.maintenanceWindows)
+		if err != nil {
+			klog.Errorf("Failed to load maintenance windows: %v", err)
+			serverCancel()
+			return
+		}
+		if opts.maintenanceWindowsLeaseNS == "" {
+			klog.Errorf("-maintenance-windows-lease-namespace is required with -maintenance-windows")
+			serverCancel()
+			return
+		}
+		identity, err := os.Hostname()
+		if err != nil {
+			klog.Errorf("Failed to get the hostname identifying the replica: %v", err)
+			serverCancel()
+			return
+		}
+		controller := maintenance.New(
+			customClient,
+			unwrappedKubeClient,
+			customFactory.Admissionregistration().V1alpha1().ValidatingAdmissionPolicyBindings(),
+			windows,
+			maintenance.Options{LeaseNamespace: opts.maintenanceWindowsLeaseNS, Identity: identity},
+			alerter,
+		)
+
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			if err := controller.Run(serverContext); err != nil {
+				klog.Errorf("maintenance window controller stopped due to error: %v", err)
+			}
+		}()
+	}
+
+	if opts.policyExceptions {
+		if opts.policyExceptionPolicies == "" {
+			klog.Errorf("-policy-exception-policies is required with -policy-exceptions")
+			serverCancel()
+			return
+		}
+		if opts.policyExceptionLeaseNS == "" {
+			klog.Errorf("-policy-exception-lease-namespace is required with -policy-exceptions")
+			serverCancel()
+			return
+		}
+		identity, err := os.Hostname()
+		if err != nil {
+			klog.Errorf("Failed to get the hostname identifying the replica: %v", err)
+			serverCancel()
+			return
+		}
+		controller := exception.New(
+			customClient,
+			dynamicClient,
+			unwrappedKubeClient,
+			dynamicFactory.ForResource(exception.GroupVersionResource),
+			customFactory.Admissionregistration().V1alpha1().ValidatingAdmissionPolicyBindings(),
+			exception.Options{
+				Policies:       splitList(opts.policyExceptionPolicies),
+				MaxTTL:         opts.policyExceptionMaxTTL,
+				ReviewInterval: opts.policyExceptionReviewInterval,
+				LeaseNamespace: opts.policyExceptionLeaseNS,
+				Identity:       identity,
+			},
+			alerter,
+		)
+
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			if err := controller.Run(serverContext); err != nil {
+				klog.Errorf("policy exception controller stopped due to error: %v", err)
+			}
+		}()
+	}
+
+	conflictMonitor := conflict.NewMonitor(
+		customFactory.Admissionregistration().V1alpha1().ValidatingAdmissionPolicies(),
+		customFactory.Admissionregistration().V1alpha1().ValidatingAdmissionPolicyBindings(),
+	)
+
+	waitGroup.Add(1)
+	go func() {
+		defer waitGroup.Done()
+		if err := conflictMonitor.Run(serverContext); err != nil {
+			klog.Errorf("policy conflict monitor stopped due to error: %v", err)
+		}
 	}()
 
-	// Start after informers have been requested from factory
-	factory.Start(serverContext.Done())
-	apiextensionsFactory.Start(serverContext.Done())
-	customFactory.Start(serverContext.Done())
+	var outcomeTracker *outcome.Tracker
+	if opts.bindingOutcomes || opts.policyErrorBudget > 0 {
+		outcomeTracker = outcome.New(
+			factory.Admissionregistration().V1alpha1().ValidatingAdmissionPolicies(),
+			factory.Admissionregistration().V1alpha1().ValidatingAdmissionPolicyBindings(),
+			factory.Core().V1().Namespaces().Lister(),
+			kubeClient,
+			outcome.Options{ErrorSpikeFactor: opts.bindingErrorSpikeFactor, IdleAfter: opts.bindingIdleAfter},
+		)
 
-	// Wait for controller and HTTP server to stop. They both signal to the other's
-	// context that it is time to wrap up
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			if err := outcomeTracker.Run(serverContext); err != nil {
+				klog.Errorf("binding outcome tracker stopped due to error: %v", err)
+			}
+		}()
+	}
+
+	var nameResolver *genname.Resolver
+	if opts.resolveNames {
+		nameResolver = genname.New(dynamicClient, opts.resolveNamesTimeout)
+
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			if err := nameResolver.Run(serverContext); err != nil {
+				klog.Errorf("generated name resolver stopped due to error: %v", err)
+			}
+		}()
+	}
+
+	var helmReleases *helmrelease.Resolver
+	if opts.helmReleaseSecrets {
+		metadataClient, err := metadata.NewForConfig(restConfig)
+		if err != nil {
+			klog.Errorf("Failed to create metadata client: %v", err)
+			serverCancel()
+			return
+		}
+		helmReleases = helmrelease.New(unwrappedKubeClient, metadataClient)
+
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			if err := helmReleases.Run(serverContext); err != nil {
+				klog.Errorf("Helm release resolver stopped due to error: %v", err)
+			}
+		}()
+	}
+
+	var errorBudget *errorbudget.Controller
+	if opts.policyErrorBudget > 0 {
+		if opts.policyErrorBudgetLeaseNS == "" {
+			klog.Errorf("-policy-error-budget-lease-namespace is required with -policy-error-budget")
+			serverCancel()
+			return
+		}
+		identity, err := os.Hostname()
+		if err != nil {
+			klog.Errorf("Failed to get the hostname identifying the replica: %v", err)
+			serverCancel()
+			return
+		}
+		errorBudget = errorbudget.New(
+			customClient,
+			unwrappedKubeClient,
+			customFactory.Admissionregistration().V1alpha1().ValidatingAdmissionPolicies(),
+			outcomeTracker,
+			errorbudget.Options{
+				Threshold:      opts.policyErrorBudget,
+				Window:         opts.policyErrorBudgetWindow,
+				Cooldown:       opts.policyErrorBudgetCooldown,
+				LeaseNamespace: opts.policyErrorBudgetLeaseNS,
+				Identity:       identity,
+			},
+			alerter,
+		)
+
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			if err := errorBudget.Run(serverContext); err != nil {
+				klog.Errorf("policy error budget controller stopped due to error: %v", err)
+			}
+		}()
+	}
+
+	if opts.telemetryEndpoint != "" {
+		policyLister := customFactory.Admissionregistration().V1alpha1().ValidatingAdmissionPolicies().Lister()
+		bindingLister := customFactory.Admissionregistration().V1alpha1().ValidatingAdmissionPolicyBindings().Lister()
+		reporter := telemetry.New(opts.telemetryEndpoint, opts.telemetryInterval, func() (telemetry.Counts, error) {
+			policies, err := policyLister.List(labels.Everything())
+			if err != nil {
+				return telemetry.Counts{}, err
+			}
+			bindings, err := bindingLister.List(labels.Everything())
+			if err != nil {
+				return telemetry.Counts{}, err
+			}
+			return telemetry.Counts{Policies: len(policies), Bindings: len(bindings)}, nil
+		}, factory.Core().V1().Namespaces().Lister())
+		decisionSinks = append(decisionSinks, reporter)
+
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			if err := reporter.Run(serverContext); err != nil {
+				klog.Errorf("telemetry reporter stopped due to error: %v", err)
+			}
+		}()
+	}
+
+	if opts.grafanaURL != "" {
+		var token string
+		if opts.grafanaTokenFile != "" {
+			data, err := os.ReadFile(opts.grafanaTokenFile)
+			if err != nil {
+				klog.Errorf("Failed to read Grafana token: %v", err)
+				serverCancel()
+				return
+			}
+			token = strings.TrimSpace(string(data))
+		}
+		publisher := grafana.New(opts.grafanaURL, token, splitList(opts.grafanaTags))
+		decisionSinks = append(decisionSinks, publisher)
+
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			if err := publisher.Run(serverContext); err != nil {
+				klog.Errorf("Grafana annotation publisher stopped due to error: %v", err)
+			}
+		}()
+	}
+
+	if hec != nil {
+		decisionSinks = append(decisionSinks, hec)
+
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			if err := hec.Run(serverContext); err != nil {
+				klog.Errorf("Splunk HTTP Event Collector sink stopped due to error: %v", err)
+			}
+		}()
+	}
+
+	if opts.elasticsearchURL != "" {
+		sink, err := newElasticsearchSink(opts)
+		if err != nil {
+			klog.Errorf("Failed to create the Elasticsearch sink: %v", err)
+			serverCancel()
+			return
+		}
+		decisionSinks = append(decisionSinks, sink)
+
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			if err := sink.Run(serverContext); err != nil {
+				klog.Errorf("Elasticsearch sink stopped due to error: %v", err)
+			}
+		}()
+	}
+
+	if opts.datadogAPIKeyFile != "" {
+		data, err := os.ReadFile(opts.datadogAPIKeyFile)
+		if err != nil {
+			klog.Errorf("Failed to read the Datadog API key: %v", err)
+			serverCancel()
+			return
+		}
+		httpClient, err := newHTTPClient("", "", "")
+		if err != nil {
+			klog.Errorf("Failed to create the Datadog HTTP client: %v", err)
+			serverCancel()
+			return
+		}
+		sink := datadog.New(strings.TrimSpace(string(data)), datadog.Options{
+			Site:      opts.datadogSite,
+			Tags:      splitList(opts.datadogTags),
+			TeamLabel: opts.datadogTeamLabel,
+			Metrics:   opts.datadogMetrics,
+		}, factory.Core().V1().Namespaces().Lister(), httpClient)
+		decisionSinks = append(decisionSinks, sink)
+
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			if err := sink.Run(serverContext); err != nil {
+				klog.Errorf("Datadog sink stopped due to error: %v", err)
+			}
+		}()
+	}
+
+	if opts.googleChatConfig != "" {
+		config, err := googlechat.LoadConfig(opts.googleChatConfig)
+		if err != nil {
+			klog.Errorf("Failed to load the Google Chat configuration: %v", err)
+			serverCancel()
+			return
+		}
+		notifier := googlechat.New(config)
+		decisionSinks = append(decisionSinks, notifier)
+
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			if err := notifier.Run(serverContext); err != nil {
+				klog.Errorf("Google Chat notifier stopped due to error: %v", err)
+			}
+		}()
+	}
+
+	for _, notifier := range chatNotifiers {
+		notifier := notifier
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			if err := notifier.Run(serverContext); err != nil {
+				klog.Errorf("chat notifier stopped due to error: %v", err)
+			}
+		}()
+	}
+
+	if jiraTracker != nil {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			if err := jiraTracker.Run(serverContext); err != nil {
+				klog.Errorf("Jira integration stopped due to error: %v", err)
+			}
+		}()
+	}
+
+	if opts.gitHubTokenFile != "" || opts.gitLabTokenFile != "" {
+		sink, err := newCommitStatusSink(opts, dynamicClient)
+		if err != nil {
+			klog.Errorf("Failed to create the commit status sink: %v", err)
+			serverCancel()
+			return
+		}
+		decisionSinks = append(decisionSinks, sink)
+
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			if err := sink.Run(serverContext); err != nil {
+				klog.Errorf("commit status sink stopped due to error: %v", err)
+			}
+		}()
+	}
+
+	var auditSinks []*auditlog.Sink
+	if opts.auditEventsFile != "" {
+		sink, err := auditlog.NewFile(opts.auditEventsFile)
+		if err != nil {
+			klog.Errorf("Failed to open the audit events file: %v", err)
+			serverCancel()
+			return
+		}
+		auditSinks = append(auditSinks, sink)
+	}
+	if opts.auditEventsWebhook != "" {
+		httpClient, err := newHTTPClient(opts.auditEventsWebhookCA, opts.auditEventsWebhookCert, opts.auditEventsWebhookKey)
+		if err != nil {
+			klog.Errorf("Failed to create the audit events webhook client: %v", err)
+			serverCancel()
+			return
+		}
+		auditSinks = append(auditSinks, auditlog.NewWebhook(opts.auditEventsWebhook, httpClient))
+	}
+	for _, sink := range auditSinks {
+		sink := sink
+		decisionSinks = append(decisionSinks, sink)
+
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			if err := sink.Run(serverContext); err != nil {
+				klog.Errorf("audit event sink stopped due to error: %v", err)
+			}
+		}()
+	}
+
+	var reviewMirror *mirror.Mirror
+	if opts.mirrorURL != "" {
+		if opts.mirrorRate < 0 || opts.mirrorRate > 1 {
+			klog.Errorf("Invalid -mirror-sample-rate %v: must be between 0 and 1", opts.mirrorRate)
+			serverCancel()
+			return
+		}
+		httpClient, err := newHTTPClient(opts.mirrorCA, opts.mirrorCert, opts.mirrorKey)
+		if err != nil {
+			klog.Errorf("Failed to create the mirror client: %v", err)
+			serverCancel()
+			return
+		}
+		reviewMirror = mirror.New(opts.mirrorURL, opts.mirrorRate, httpClient)
+
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			if err := reviewMirror.Run(serverContext); err != nil {
+				klog.Errorf("Admission review mirror stopped due to error: %v", err)
+			}
+		}()
+	}
+
+	listeners := []webhook.Listener{{Addr: opts.listenAddr, CertFile: opts.certFile, KeyFile: opts.keyFile}}
+	for _, l := range opts.listeners {
+		if l.CertFile == "" {
+			l.CertFile = opts.certFile
+		}
+		if l.KeyFile == "" {
+			l.KeyFile = opts.keyFile
+		}
+		listeners = append(listeners, l)
+	}
+
+	certs := []certexpiry.Certificate{}
+	for _, l := range listeners {
+		certs = append(certs, certexpiry.Certificate{Name: "serving", File: l.CertFile})
+	}
+	if alerter != nil && opts.alertmanagerCert != "" {
+		certs = append(certs, certexpiry.Certificate{Name: "alertmanager-client", File: opts.alertmanagerCert})
+	}
+	certMonitor := certexpiry.New(certs, opts.certExpiryAlertWindow, alerter)
+
+	waitGroup.Add(1)
+	go func() {
+		defer waitGroup.Done()
+		if err := certMonitor.Run(serverContext); err != nil {
+			klog.Errorf("certificate expiry monitor stopped due to error: %v", err)
+		}
+	}()
+
+	if alerter != nil {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			if err := alerter.Run(serverContext); err != nil {
+				klog.Errorf("alert sender stopped due to error: %v", err)
+			}
+		}()
+	}
+
+	if opts.adminAddr != "" {
+		adminServer := admin.New(opts.adminAddr)
+		adminServer.Handle("/conflicts", conflictMonitor)
+		adminServer.Handle("/coverage", coverage.NewHandler(
+			customFactory.Admissionregistration().V1alpha1().ValidatingAdmissionPolicies(),
+			customFactory.Admissionregistration().V1alpha1().ValidatingAdmissionPolicyBindings(),
+			unwrappedKubeClient.Discovery(),
+		))
+		if outcomeTracker != nil {
+			adminServer.Handle("/outcomes", outcomeTracker)
+		}
+		if errorBudget != nil {
+			adminServer.Handle("/degraded", errorBudget)
+		}
+		adminServer.HandleLocal("/loglevel", opts.logLevels)
+		if opts.dashboard {
+			adminServer.HandleLocal("/dashboard", dashboard.New(
+				customFactory.Admissionregistration().V1alpha1().ValidatingAdmissionPolicies().Lister(),
+				customFactory.Admissionregistration().V1alpha1().ValidatingAdmissionPolicyBindings().Lister(),
+				decisionStore,
+				certMonitor,
+			))
+		}
+
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			if err := adminServer.Run(serverContext); err != nil {
+				klog.Errorf("admin API stopped due to error: %v", err)
+			}
+		}()
+	}
+
+	authOptions := webhook.AuthOptions{
+		ClientCAFile: opts.authClientCA,
+		AdminUsers:   splitList(opts.adminUsers),
+		AdminGroups:  splitList(opts.adminGroups),
+	}
+	if opts.authTokenFile != "" {
+		token, err := os.ReadFile(opts.authTokenFile)
+		if err != nil {
+			klog.Errorf("Failed to read authentication token: %v", err)
+			serverCancel()
+			return
+		}
+		authOptions.Token = strings.TrimSpace(string(token))
+	}
+	if opts.authTokenReview {
+		authOptions.TokenReviewClient = unwrappedKubeClient
+		authOptions.Audiences = splitList(opts.authAudiences)
+		authOptions.Users = splitList(opts.authUsers)
+	}
+
+	var mutators []webhook.Mutator
+	if opts.mutatingAdmissionPolicies {
+		mutators = append(mutators, mutation.New(
+			dynamicFactory.ForResource(mutation.PolicyResource),
+			dynamicFactory.ForResource(mutation.BindingResource),
+			factory.Core().V1().Namespaces().Lister(),
+			unwrappedKubeClient,
+		))
+	}
+	if opts.securityContextDefaults != "" {
+		defaults, err := mutation.ParseSecurityContextDefaults(splitList(opts.securityContextDefaults))
+		if err != nil {
+			klog.Errorf("Invalid security context defaults: %v", err)
+			serverCancel()
+			return
+		}
+		mutators = append(mutators, mutation.NewSecurityContextDefaulter(defaults, factory.Core().V1().Namespaces().Lister()))
+	}
+	mutators = append(mutators, pluginMutators...)
+
+	var autoRemediate remediation.Policies
+	if opts.autoRemediate {
+		autoRemediate = remediation.NewPolicies(customFactory.Admissionregistration().V1alpha1().ValidatingAdmissionPolicies().Lister())
+	}
+
+	// Admin endpoints are served on the webhook listeners behind the same
+	// authentication as /validate, to the admin users and groups
+	var adminHandler http.Handler
+	if opts.playground {
+		adminMux := http.NewServeMux()
+		adminMux.Handle("/admin/eval", playground.Handler{})
+		adminHandler = adminMux
+	}
+	var apiHandler http.Handler
+	if opts.decisionAPISize > 0 || opts.decisionDB != "" {
+		apiMux := http.NewServeMux()
+		apiMux.Handle("/api/v1/decisions", decision.NewHandler(decisionStore))
+		apiHandler = apiMux
+	}
+
+	var explainer webhook.Explainer
+	if opts.explainUsers != "" || opts.explainGroups != "" {
+		explainer = explain.New(
+			factory.Admissionregistration().V1alpha1().ValidatingAdmissionPolicies(),
+			factory.Admissionregistration().V1alpha1().ValidatingAdmissionPolicyBindings(),
+			factory.Core().V1().Namespaces().Lister(),
+			kubeClient,
+			restmapper,
+			dynamicClient,
+			policyAuthorizer,
+			explain.Options{Users: splitList(opts.explainUsers), Groups: splitList(opts.explainGroups)},
+		)
+	}
+
+	webhookOptions, err := validatorOptions(validators, opts.validatorFailurePolicies)
+	if err != nil {
+		klog.Errorf("Invalid validator failure policies: %v", err)
+		serverCancel()
+		return
+	}
+	pathOptions, err := validatePathOptions(validators, opts.validatePaths)
+	if err != nil {
+		klog.Errorf("Invalid validate paths: %v", err)
+		serverCancel()
+		return
+	}
+	webhookOptions = append(webhookOptions, pathOptions...)
+	internalErrors, err := internalErrorOptions(opts.internalErrorPolicy, opts.internalErrorPolicies)
+	if err != nil {
+		klog.Errorf("Invalid internal error policies: %v", err)
+		serverCancel()
+		return
+	}
+	webhookOptions = append(webhookOptions, webhook.WithInternalErrorOptions(internalErrors))
+	largeObjectThreshold, err := resource.ParseQuantity(opts.largeObjectThreshold)
+	if err != nil {
+		klog.Errorf("Invalid -large-object-threshold: %v", err)
+		serverCancel()
+		return
+	}
+	memoryHeapLimit, err := resource.ParseQuantity(opts.memoryHeapLimit)
+	if err != nil {
+		klog.Errorf("Invalid -memory-pressure-heap-limit: %v", err)
+		serverCancel()
+		return
+	}
+	opts.memoryPressure.HeapLimit = memoryHeapLimit.Value()
+	opts.memoryPressure.ExemptGroups = splitList(opts.memoryExemptGroups)
+
+	// Policies are evaluated against the caches of these informers, which
+	// must be tracked before they are started
+	informerHealth := informerhealth.New(opts.informerStaleAfter)
+	trackedFactories := map[string]informers.SharedInformerFactory{"": factory}
+	for i, f := range tierFactories {
+		trackedFactories[fmt.Sprintf("priority-%d/", i)] = f
+	}
+	for prefix, f := range trackedFactories {
+		for name, informer := range map[string]cache.SharedIndexInformer{
+			"validatingadmissionpolicies":       f.Admissionregistration().V1alpha1().ValidatingAdmissionPolicies().Informer(),
+			"validatingadmissionpolicybindings": f.Admissionregistration().V1alpha1().ValidatingAdmissionPolicyBindings().Informer(),
+			"namespaces":                        f.Core().V1().Namespaces().Informer(),
+		} {
+			if err := informerHealth.Track(prefix+name, informer); err != nil {
+				klog.Errorf("Failed to track informer health: %v", err)
+				serverCancel()
+				return
+			}
+		}
+	}
+	webhookOptions = append(webhookOptions,
+		webhook.WithTLS(opts.certFile, opts.keyFile),
+		webhook.WithListeners(opts.listeners...),
+		webhook.WithHTTPOptions(opts.httpOptions),
+		webhook.WithHealthOptions(opts.healthOptions),
+		webhook.WithReadinessCheck("informers", informerHealth.Check),
+		webhook.WithAuth(authOptions),
+		webhook.WithAlertManager(alerter),
+		webhook.WithDenyStormProtection(opts.denyStorm),
+		webhook.WithMemoryPressureShedding(opts.memoryPressure),
+		webhook.WithLogSampling(opts.logAllowedEvery),
+		webhook.WithLargeObjectThreshold(int(largeObjectThreshold.Value())),
+		webhook.WithRedactor(redactor),
+		webhook.WithDecisionSinks(decisionSinks...),
+		webhook.WithMutators(mutators...),
+		webhook.WithAutoRemediation(autoRemediate),
+		webhook.WithPolicies(customFactory.Admissionregistration().V1alpha1().ValidatingAdmissionPolicies().Lister()),
+		webhook.WithScheme(clientsetscheme.Scheme),
+		webhook.WithObjectInterfaces(crdscheme.NewObjectInterfaces(admission.NewObjectInterfacesFromScheme(clientsetscheme.Scheme), apiextensionsFactory.Apiextensions().V1().CustomResourceDefinitions())),
+		webhook.WithAdminHandler(adminHandler),
+		webhook.WithAPIHandler(apiHandler),
+		webhook.WithExplainer(explainer),
+		webhook.WithVersionInfo(webhook.VersionInfo{Version: telemetry.Version(), GoVersion: runtime.Version(), FIPS: fips.Enabled()}),
+		webhook.WithVersionStamp(telemetry.Version(), policyset.NewTracker(
+			customFactory.Admissionregistration().V1alpha1().ValidatingAdmissionPolicies(),
+			customFactory.Admissionregistration().V1alpha1().ValidatingAdmissionPolicyBindings(),
+		)),
+	)
+	if outcomeTracker != nil {
+		webhookOptions = append(webhookOptions, webhook.WithOutcomeTracker(outcomeTracker))
+	}
+	if reviewMirror != nil {
+		webhookOptions = append(webhookOptions, webhook.WithMirror(reviewMirror))
+	}
+	if nameResolver != nil {
+		webhookOptions = append(webhookOptions, webhook.WithNameResolver(nameResolver))
+	}
+	if opts.selfTest.Enabled {
+		if opts.alertmanagerHost != "" {
+			opts.selfTest.Checks = map[string]webhook.SelfTestCheck{"alertmanager": alerter.Check}
+		}
+		webhookOptions = append(webhookOptions, webhook.WithSelfTest(opts.selfTest))
+	}
+	if opts.coerceCustomResources {
+		webhookOptions = append(webhookOptions, webhook.WithCoercer(crdscheme.NewCoercer(apiextensionsFactory.Apiextensions().V1().CustomResourceDefinitions())))
+	}
+	if opts.scaleTargetMetadata {
+		webhookOptions = append(webhookOptions, webhook.WithScaleTargetMetadata(dynamicClient))
+	}
+	if opts.bindingMetadata {
+		webhookOptions = append(webhookOptions, webhook.WithBindingMetadata(dynamicClient))
+	}
+	if helmReleases != nil {
+		webhookOptions = append(webhookOptions, webhook.WithHelmReleases(helmReleases))
+	}
+	webhook := webhook.New(opts.listenAddr, webhookOptions...)
+
+	if certSource != nil {
+		klog.Infof("waiting for the serving certificate from the %s certificate source", opts.certSource)
+		select {
+		case <-certSource.Ready():
+		case <-serverContext.Done():
+		}
+	}
+
+	// Start HTTP REST server for webhook
+	waitGroup.Add(1)
+	go func() {
+		defer func() {
+			// Cancel the server context to stop other workers
+			serverCancel()
+			waitGroup.Done()
+		}()
+
+		cancellationReason := webhook.Run(serverContext)
+		klog.Infof("webhook server closure reason: %v", cancellationReason)
+	}()
+
+	// Start after informers have been requested from factory
+	factory.Start(serverContext.Done())
+	for _, f := range tierFactories {
+		f.Start(serverContext.Done())
+	}
+	apiextensionsFactory.Start(serverContext.Done())
+	customFactory.Start(serverContext.Done())
+	dynamicFactory.Start(serverContext.Done())
+
+	// Wait for controller and HTTP server to stop. They both signal to the other's
+	// context that it is time to wrap up
 	waitGroup.Wait()
 	klog.Infof("exiting")
 }
 
+// newCertSource returns the Source keeping the serving certificate files up
+// to date, or nil if they are provided by other means.
+func newCertSource(opts options, client kubernetes.Interface) (certsource.Source, error) {
+	switch opts.certSource {
+	case "file", "":
+		return nil, nil
+	case "spiffe":
+		return certsource.NewSPIFFE(opts.spiffeEndpoint, opts.certFile, opts.keyFile), nil
+	case "vault":
+		return certsource.NewVault(opts.vault, opts.certFile, opts.keyFile)
+	case "secret":
+		namespace, name, found := strings.Cut(opts.certSecret, "/")
+		if !found {
+			namespace, name = os.Getenv("POD_NAMESPACE"), opts.certSecret
+		}
+		if namespace == "" || name == "" {
+			return nil, fmt.Errorf("invalid certificate Secret %q", opts.certSecret)
+		}
+		return certsource.NewSecret(client, namespace, name, opts.certFile, opts.keyFile), nil
+	default:
+		return nil, fmt.Errorf("unknown certificate source %q", opts.certSource)
+	}
+}
+
+// splitList splits a comma separated flag value, returning nil if empty.
+func splitList(v string) []string {
+	if v == "" {
+		return nil
+	}
+	return strings.Split(v, ",")
+}
+
+// newLogExporter creates the exporter of logs to -otlp-logs-endpoint.
+func newLogExporter(opts options) (*otellog.Exporter, error) {
+	headers := map[string]string{}
+	for _, header := range splitList(opts.otlpLogsHeaders) {
+		k, v, ok := strings.Cut(header, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid header %q, expected key=value", header)
+		}
+		headers[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	httpClient, err := newHTTPClient(opts.otlpLogsCA, "", "")
+	if err != nil {
+		return nil, err
+	}
+	resource := map[string]string{
+		"service.name":    "kubeenforcer",
+		"service.version": telemetry.Version(),
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		resource["service.instance.id"] = hostname
+	}
+	if namespace := os.Getenv("POD_NAMESPACE"); namespace != "" {
+		resource["k8s.namespace.name"] = namespace
+	}
+	return otellog.New(opts.otlpLogsEndpoint, headers, resource, httpClient), nil
+}
+
+// newHEC creates the sink of decisions and alerts to -splunk-hec-url.
+func newHEC(opts options) (*splunk.HEC, error) {
+	if opts.splunkHECTokenFile == "" {
+		return nil, fmt.Errorf("-splunk-hec-token-file is required")
+	}
+	data, err := os.ReadFile(opts.splunkHECTokenFile)
+	if err != nil {
+		return nil, err
+	}
+	httpClient, err := newHTTPClient(opts.splunkHECCA, "", "")
+	if err != nil {
+		return nil, err
+	}
+	hecOptions := splunk.Options{
+		Index:              opts.splunkIndex,
+		DecisionSourceType: opts.splunkDecisionSourceType,
+		AlertSourceType:    opts.splunkAlertSourceType,
+		AllDecisions:       opts.splunkAllDecisions,
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		hecOptions.Host = hostname
+	}
+	return splunk.New(opts.splunkHECURL, strings.TrimSpace(string(data)), hecOptions, httpClient), nil
+}
+
+// newElasticsearchSink creates the sink of decisions to -elasticsearch-url.
+func newElasticsearchSink(opts options) (*elasticsearch.Sink, error) {
+	auth := elasticsearch.Auth{Username: opts.elasticsearchUsername}
+	for _, secret := range []struct {
+		file  string
+		value *string
+	}{
+		{opts.elasticsearchAPIKeyFile, &auth.APIKey},
+		{opts.elasticsearchPasswordFile, &auth.Password},
+	} {
+		if secret.file == "" {
+			continue
+		}
+		data, err := os.ReadFile(secret.file)
+		if err != nil {
+			return nil, err
+		}
+		*secret.value = strings.TrimSpace(string(data))
+	}
+	httpClient, err := newHTTPClient(opts.elasticsearchCA, "", "")
+	if err != nil {
+		return nil, err
+	}
+	return elasticsearch.New(opts.elasticsearchURL, opts.elasticsearchIndexPrefix, auth, opts.elasticsearchILMPolicy, httpClient), nil
+}
+
+// newChatNotifiers creates the notifiers of alerts to -mattermost-webhook-file
+// and -rocketchat-webhook-file.
+func newChatNotifiers(opts options) ([]*chat.Notifier, error) {
+	if opts.mattermostWebhookFile == "" && opts.rocketChatWebhookFile == "" {
+		return nil, nil
+	}
+	tmpl, err := chat.LoadTemplate(opts.chatMessageTemplate)
+	if err != nil {
+		return nil, err
+	}
+	var notifiers []*chat.Notifier
+	for _, webhook := range []struct {
+		flavor chat.Flavor
+		file   string
+	}{
+		{chat.Mattermost, opts.mattermostWebhookFile},
+		{chat.RocketChat, opts.rocketChatWebhookFile},
+	} {
+		if webhook.file == "" {
+			continue
+		}
+		data, err := os.ReadFile(webhook.file)
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, chat.New(webhook.flavor, strings.TrimSpace(string(data)), tmpl))
+	}
+	return notifiers, nil
+}
+
+// newJiraTracker creates the tracker of persistent violations opening issues
+// in -jira-url.
+func newJiraTracker(opts options) (*jira.Tracker, error) {
+	if opts.jiraProject == "" || opts.jiraTokenFile == "" {
+		return nil, fmt.Errorf("-jira-project and -jira-token-file are required")
+	}
+	data, err := os.ReadFile(opts.jiraTokenFile)
+	if err != nil {
+		return nil, err
+	}
+	httpClient, err := newHTTPClient(opts.jiraCA, "", "")
+	if err != nil {
+		return nil, err
+	}
+	return jira.New(opts.jiraURL, jira.Auth{User: opts.jiraUser, Token: strings.TrimSpace(string(data))}, jira.Options{
+		Project:   opts.jiraProject,
+		IssueType: opts.jiraIssueType,
+		Threshold: opts.jiraThreshold,
+	}, httpClient), nil
+}
+
+// newCommitStatusSink creates the sink posting commit statuses for denials
+// to GitHub and GitLab.
+func newCommitStatusSink(opts options, dynamicClient dynamic.Interface) (*gitfeedback.Sink, error) {
+	sinkOptions := gitfeedback.Options{
+		GitHubAPI:       opts.gitHubAPI,
+		GitLabURL:       opts.gitLabURL,
+		ArgoCDNamespace: opts.argoCDNamespace,
+		Repositories:    splitList(opts.gitRepositories),
+	}
+	for _, token := range []struct {
+		file  string
+		value *string
+	}{
+		{opts.gitHubTokenFile, &sinkOptions.GitHubToken},
+		{opts.gitLabTokenFile, &sinkOptions.GitLabToken},
+	} {
+		if token.file == "" {
+			continue
+		}
+		data, err := os.ReadFile(token.file)
+		if err != nil {
+			return nil, err
+		}
+		*token.value = strings.TrimSpace(string(data))
+	}
+	httpClient, err := newHTTPClient("", "", "")
+	if err != nil {
+		return nil, err
+	}
+	return gitfeedback.New(sinkOptions, dynamicClient, httpClient)
+}
+
+// newRedisOptions returns the connection options of the alert deduplication
+// Redis server.
+func newRedisOptions(opts options) (alertmanager.RedisOptions, error) {
+	redisOptions := alertmanager.RedisOptions{
+		Addr:     opts.alertDedupRedis,
+		Username: opts.alertDedupRedisUsername,
+	}
+	if opts.alertDedupRedisPassword != "" {
+		data, err := os.ReadFile(opts.alertDedupRedisPassword)
+		if err != nil {
+			return redisOptions, err
+		}
+		redisOptions.Password = strings.TrimSpace(string(data))
+	}
+	if opts.alertDedupRedisTLS || opts.alertDedupRedisCA != "" {
+		redisOptions.TLS = &tls.Config{MinVersion: tls.VersionTLS12}
+		if opts.alertDedupRedisCA != "" {
+			pem, err := os.ReadFile(opts.alertDedupRedisCA)
+			if err != nil {
+				return redisOptions, err
+			}
+			redisOptions.TLS.RootCAs = x509.NewCertPool()
+			if !redisOptions.TLS.RootCAs.AppendCertsFromPEM(pem) {
+				return redisOptions, fmt.Errorf("no certificates found in %s", opts.alertDedupRedisCA)
+			}
+		}
+	}
+	return redisOptions, nil
+}
+
+func envOrDefault(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// decisionStreamTLSConfig serves the decision stream with the webhook's own
+// certificate, reloaded as it is renewed, requiring client certificates
+// since decisions reveal the objects admitted.
+func decisionStreamTLSConfig(opts options) (*tls.Config, error) {
+	if opts.decisionStreamClientCA == "" {
+		return nil, fmt.Errorf("-decision-stream-client-ca is required with -decision-stream-addr")
+	}
+	pem, err := os.ReadFile(opts.decisionStreamClientCA)
+	if err != nil {
+		return nil, err
+	}
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", opts.decisionStreamClientCA)
+	}
+	return &tls.Config{
+		GetCertificate: certsource.NewKeyPair(opts.certFile, opts.keyFile).GetCertificate,
+		ClientCAs:      clientCAs,
+		ClientAuth:     tls.RequireAndVerifyClientCert,
+		MinVersion:     tls.VersionTLS12,
+	}, nil
+}
+
+// newDistributionAgent creates the agent applying bundles from the policy
+// server configured in opts.
+func newDistributionAgent(opts options, client versioned.Interface) (*distribution.Agent, error) {
+	publicKey, err := distribution.LoadPublicKey(opts.policyServerPublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	clusterLabels, err := labels.ConvertSelectorToLabelsMap(opts.clusterLabels)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cluster labels: %w", err)
+	}
+
+	httpClient, err := newHTTPClient(opts.policyServerCA, opts.policyServerCert, opts.policyServerKey)
+	if err != nil {
+		return nil, err
+	}
+	return distribution.NewAgent(opts.policyServerURL, opts.clusterName, clusterLabels, publicKey, httpClient, client, opts.policyServerInterval), nil
+}
+
+// newOPABundleAgent creates the agent applying the OPA bundle configured in
+// opts.
+func newOPABundleAgent(opts options, client versioned.Interface) (*distribution.Agent, error) {
+	var verifier *distribution.OPAVerifier
+	if opts.opaBundlePublicKey != "" {
+		var err error
+		if verifier, err = distribution.LoadOPAVerifier(opts.opaBundlePublicKey, opts.opaBundleKeyID, opts.opaBundleScope); err != nil {
+			return nil, err
+		}
+	}
+
+	var token string
+	if opts.opaBundleTokenFile != "" {
+		data, err := os.ReadFile(opts.opaBundleTokenFile)
+		if err != nil {
+			return nil, err
+		}
+		token = strings.TrimSpace(string(data))
+	}
+
+	httpClient, err := newHTTPClient(opts.opaBundleCA, "", "")
+	if err != nil {
+		return nil, err
+	}
+	return distribution.NewOPAAgent(opts.opaBundleURL, token, verifier, httpClient, client, opts.opaBundleInterval), nil
+}
+
+// newHTTPClient creates the client of outbound integrations, e.g. bundles
+// and sinks, verifying the server with the CA bundle in caFile and
+// presenting the client certificate in certFile, unless they are empty.
+// Like http.DefaultTransport, it honors the HTTP_PROXY, HTTPS_PROXY and
+// NO_PROXY environment variables.
+func newHTTPClient(caFile, certFile, keyFile string) (*http.Client, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: transport,
+	}, nil
+}
+
 func loadClientConfig() (*rest.Config, error) {
 	// Connect to k8s
 	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
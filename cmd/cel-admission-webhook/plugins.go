@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"sigs.k8s.io/yaml"
+
+	"github.com/kubescape/kubeenforcer/pkg/registry"
+	"github.com/kubescape/kubeenforcer/pkg/webhook"
+	// Validators and mutators of other modules are compiled in by importing
+	// them here for their registration side effects, e.g.
+	// _ "example.com/kubeenforcer-plugins/imagesigning"
+)
+
+// loadPluginConfig reads the YAML file mapping plugin names to their
+// configuration. No plugin is configured if file is empty.
+func loadPluginConfig(file string) (map[string]json.RawMessage, error) {
+	config := map[string]json.RawMessage{}
+	if file == "" {
+		return config, nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", file, err)
+	}
+	return config, nil
+}
+
+// newPlugins creates the registered validators and mutators enabled by
+// name, passing each its configuration.
+func newPlugins(validatorNames, mutatorNames []string, config map[string]json.RawMessage, opts registry.Options) ([]namedValidator, []webhook.Mutator, error) {
+	var validators []namedValidator
+	for _, name := range validatorNames {
+		opts.Config = config[name]
+		v, err := registry.NewValidator(name, opts)
+		if err != nil {
+			return nil, nil, err
+		}
+		validators = append(validators, namedValidator{name, v})
+	}
+
+	var mutators []webhook.Mutator
+	for _, name := range mutatorNames {
+		opts.Config = config[name]
+		m, err := registry.NewMutator(name, opts)
+		if err != nil {
+			return nil, nil, err
+		}
+		mutators = append(mutators, m)
+	}
+	return validators, mutators, nil
+}
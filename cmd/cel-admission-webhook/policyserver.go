@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"k8s.io/klog/v2"
+
+	"github.com/kubescape/kubeenforcer/pkg/distribution"
+)
+
+// policyServerMain implements the `policy-server` subcommand, which serves
+// signed policy bundles to kubeenforcer agents in other clusters. It returns
+// the process exit code.
+func policyServerMain(args []string) int {
+	var addr, configFile, signingKeyFile string
+	var certFile, keyFile, clientCAFile string
+	var fipsMode bool
+
+	fs := flag.NewFlagSet("policy-server", flag.ExitOnError)
+	fs.StringVar(&addr, "addr", "0.0.0.0:9444", "Address agents fetch bundles from.")
+	fs.StringVar(&configFile, "config", "bundles.yaml", "Path to the bundle and rollout stage configuration. Reloaded on SIGHUP.")
+	fs.StringVar(&signingKeyFile, "signing-key", "signing-key.pem", "PEM encoded ed25519 private key bundles are signed with.")
+	fs.StringVar(&certFile, "cert", "server.pem", "Path to TLS certificate file.")
+	fs.StringVar(&keyFile, "key", "server-key.pem", "Path to TLS key file.")
+	fs.StringVar(&clientCAFile, "client-ca", "", "CA bundle agent client certificates must be signed by. Client certificates are not required if empty.")
+	fs.BoolVar(&fipsMode, "fips", false, "FIPS mode: refuse to start unless built with FIPS 140 validated crypto (GOEXPERIMENT=boringcrypto). Since bundles are signed with ed25519, which is not FIPS-approved, the policy server never starts in FIPS mode.")
+	fs.Parse(args)
+
+	klog.EnableContextualLogging(true)
+
+	if fipsMode {
+		if err := checkFIPS(policyServerFIPSIncompatibleFeatures); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid configuration with -fips: %v\n", err)
+			return 1
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	signingKey, err := distribution.LoadPrivateKey(signingKeyFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load signing key: %v\n", err)
+		return 1
+	}
+
+	server := distribution.NewServer(signingKey)
+	if err := server.Load(configFile); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load bundles: %v\n", err)
+		return 1
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if clientCAFile != "" {
+		pem, err := os.ReadFile(clientCAFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read client CA: %v\n", err)
+			return 1
+		}
+		tlsConfig.ClientCAs = x509.NewCertPool()
+		if !tlsConfig.ClientCAs.AppendCertsFromPEM(pem) {
+			fmt.Fprintf(os.Stderr, "no certificates found in %s\n", clientCAFile)
+			return 1
+		}
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	defer signal.Stop(reload)
+	go func() {
+		for range reload {
+			if err := server.Load(configFile); err != nil {
+				klog.Errorf("failed to reload bundles, keeping the previous configuration: %v", err)
+			}
+		}
+	}()
+
+	httpServer := &http.Server{Addr: addr, Handler: server.Handler(), TLSConfig: tlsConfig}
+
+	errs := make(chan error, 1)
+	go func() { errs <- httpServer.ListenAndServeTLS(certFile, keyFile) }()
+
+	klog.Infof("policy server listening on %s", addr)
+
+	exitCode := 0
+	select {
+	case <-ctx.Done():
+	case err := <-errs:
+		klog.Errorf("policy server stopped: %v", err)
+		exitCode = 1
+	}
+
+	if err := httpServer.Close(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		klog.Errorf("failed to close policy server: %v", err)
+	}
+	return exitCode
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+
+	"k8s.io/cel-admission-webhook/pkg/apis/admissionregistration.x-k8s.io/v1alpha1"
+	"k8s.io/cel-admission-webhook/pkg/generated/clientset/versioned"
+
+	"github.com/kubescape/kubeenforcer/pkg/distribution"
+	"github.com/kubescape/kubeenforcer/pkg/simulate"
+)
+
+// simulateMain implements the `simulate` subcommand, which reports the
+// existing objects of the current cluster that policies would deny if they
+// were enforced, before they are applied. It returns the process exit code:
+// 1 if objects would be denied.
+func simulateMain(args []string) int {
+	var policyPaths, output string
+	var opts simulate.Options
+
+	fs := flag.NewFlagSet("simulate", flag.ExitOnError)
+	fs.StringVar(&policyPaths, "policy", "", "Comma-separated files or directories of policies, and optionally their bindings, to simulate.")
+	fs.StringVar(&opts.Namespace, "namespace", "", "Only evaluate the objects of this namespace. All namespaces and cluster-scoped objects if empty.")
+	fs.StringVar(&output, "o", "text", "Output format: text or json.")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s simulate -policy <file or directory> [flags]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if policyPaths == "" || fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+
+	policies, bindings, err := loadPolicies(policyPaths)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	simulator, err := newSimulator()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	report, err := simulator.Run(ctx, policies, bindings, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	switch output {
+	case "json":
+		out, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Println(string(out))
+	default:
+		for _, v := range report.Violations {
+			object := v.Name
+			if v.Namespace != "" {
+				object = v.Namespace + "/" + v.Name
+			}
+			policy := v.Policy
+			if v.Binding != "" {
+				policy += " (" + v.Binding + ")"
+			}
+			fmt.Printf("%s %s %s by %s: %s\n", strings.Join(v.Actions, ","), v.Resource, object, policy, v.Message)
+		}
+		fmt.Printf("%d policies, %d matching objects, %d would be denied\n", len(policies), report.Objects, report.Denied())
+	}
+
+	if report.Denied() > 0 {
+		return 1
+	}
+	return 0
+}
+
+// loadPolicies loads the policies and bindings of the comma-separated files
+// and directories in paths.
+func loadPolicies(paths string) ([]*v1alpha1.ValidatingAdmissionPolicy, []*v1alpha1.ValidatingAdmissionPolicyBinding, error) {
+	files, err := manifestFiles(splitList(paths))
+	if err != nil {
+		return nil, nil, err
+	}
+	var policies []*v1alpha1.ValidatingAdmissionPolicy
+	var bindings []*v1alpha1.ValidatingAdmissionPolicyBinding
+	for _, file := range files {
+		bundle, err := distribution.LoadFile("", file)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to load %w", err)
+		}
+		for i := range bundle.Policies {
+			policies = append(policies, &bundle.Policies[i])
+		}
+		for i := range bundle.Bindings {
+			bindings = append(bindings, &bundle.Bindings[i])
+		}
+	}
+	if len(policies) == 0 {
+		return nil, nil, fmt.Errorf("no policies in %s", paths)
+	}
+	return policies, bindings, nil
+}
+
+// newSimulator creates a Simulator for the current cluster.
+func newSimulator() (*simulate.Simulator, error) {
+	restConfig, err := loadClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load cluster configuration: %w", err)
+	}
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	policyClient, err := versioned.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	dynamicClient, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	return simulate.New(client, policyClient, dynamicClient), nil
+}
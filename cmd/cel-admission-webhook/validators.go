@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	"k8s.io/apiserver/pkg/admission"
+
+	"github.com/kubescape/kubeenforcer/pkg/webhook"
+	"github.com/kubescape/kubeenforcer/pkg/webhookconfig"
+)
+
+// namedValidator is a validator of the webhook's chain, named for its
+// failure policy and metrics.
+type namedValidator struct {
+	name      string
+	validator admission.ValidationInterface
+}
+
+// validatorOptions returns the options adding validators to the webhook's
+// chain, with the failure policies given as name=Fail|Ignore pairs in
+// failurePolicies. Validators default to Fail.
+func validatorOptions(validators []namedValidator, failurePolicies string) ([]webhook.Option, error) {
+	policies := map[string]admissionregistrationv1.FailurePolicyType{}
+	for _, pair := range splitList(failurePolicies) {
+		name, policy, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid failure policy %q, expected name=Fail|Ignore", pair)
+		}
+		switch admissionregistrationv1.FailurePolicyType(policy) {
+		case admissionregistrationv1.Fail, admissionregistrationv1.Ignore:
+		default:
+			return nil, fmt.Errorf("invalid failure policy %q for validator %s, expected Fail or Ignore", policy, name)
+		}
+		policies[name] = admissionregistrationv1.FailurePolicyType(policy)
+	}
+
+	var options []webhook.Option
+	for _, v := range validators {
+		policy, ok := policies[v.name]
+		if !ok {
+			policy = admissionregistrationv1.Fail
+		}
+		delete(policies, v.name)
+		options = append(options, webhook.WithValidator(v.name, v.validator, policy))
+	}
+	for name := range policies {
+		return nil, fmt.Errorf("failure policy set for unknown or disabled validator %s", name)
+	}
+	return options, nil
+}
+
+// validatePathOptions returns the options serving the paths given as
+// path=validator+validator pairs in paths, each validating with the named
+// validators of the chain. /validate can be listed to restrict its chain.
+func validatePathOptions(validators []namedValidator, paths string) ([]webhook.Option, error) {
+	known := map[string]bool{}
+	for _, v := range validators {
+		known[v.name] = true
+	}
+
+	var options []webhook.Option
+	seen := map[string]bool{"/mutate": true}
+	for _, pair := range splitList(paths) {
+		path, names, ok := strings.Cut(pair, "=")
+		if !ok || !strings.HasPrefix(path, "/") {
+			return nil, fmt.Errorf("invalid validate path %q, expected /path=validator+validator", pair)
+		}
+		if seen[path] {
+			return nil, fmt.Errorf("duplicate validate path %s", path)
+		}
+		seen[path] = true
+
+		selected := strings.Split(names, "+")
+		for _, name := range selected {
+			if !known[name] {
+				return nil, fmt.Errorf("validate path %s: unknown or disabled validator %s", path, name)
+			}
+		}
+		options = append(options, webhook.WithValidatePath(path, selected...))
+	}
+	return options, nil
+}
+
+// extraRules returns the webhook rules of the validators that handle requests
+// regardless of the loaded policies, for the webhook configuration to keep
+// when its rules are scoped to the policies. The policies validator is
+// covered by the policies' own rules. It fails for validators that cannot
+// report the requests they handle, which scoped rules would cut off.
+func extraRules(validators []namedValidator) ([]admissionregistrationv1.RuleWithOperations, error) {
+	var rules []admissionregistrationv1.RuleWithOperations
+	for _, v := range validators {
+		if v.name == "policies" {
+			continue
+		}
+		source, ok := v.validator.(webhookconfig.RuleSource)
+		if !ok {
+			return nil, fmt.Errorf("validator %s does not report its webhook rules and would receive no requests with scoped rules", v.name)
+		}
+		rules = append(rules, source.Rules()...)
+	}
+	return rules, nil
+}
+
+// internalErrorOptions returns the failure policy of internal errors, Fail,
+// Ignore or empty, and its overrides given as /path=Fail|Ignore pairs in
+// paths.
+func internalErrorOptions(policy, paths string) (webhook.InternalErrorOptions, error) {
+	opts := webhook.InternalErrorOptions{FailurePolicy: admissionregistrationv1.FailurePolicyType(policy)}
+	switch opts.FailurePolicy {
+	case "", admissionregistrationv1.Fail, admissionregistrationv1.Ignore:
+	default:
+		return opts, fmt.Errorf("invalid failure policy %q, expected Fail or Ignore", policy)
+	}
+	for _, pair := range splitList(paths) {
+		path, pathPolicy, ok := strings.Cut(pair, "=")
+		if !ok || !strings.HasPrefix(path, "/") {
+			return opts, fmt.Errorf("invalid failure policy %q, expected /path=Fail|Ignore", pair)
+		}
+		switch admissionregistrationv1.FailurePolicyType(pathPolicy) {
+		case admissionregistrationv1.Fail, admissionregistrationv1.Ignore:
+		default:
+			return opts, fmt.Errorf("invalid failure policy %q for path %s, expected Fail or Ignore", pathPolicy, path)
+		}
+		if opts.PathFailurePolicies == nil {
+			opts.PathFailurePolicies = map[string]admissionregistrationv1.FailurePolicyType{}
+		}
+		opts.PathFailurePolicies[path] = admissionregistrationv1.FailurePolicyType(pathPolicy)
+	}
+	return opts, nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+)
+
+// checkWebhookConfiguration fails if the values to enforce on the managed
+// webhook would be rejected by the API server, so they are not found wrong
+// on every reconciliation.
+func checkWebhookConfiguration(opts options) error {
+	switch admissionregistrationv1.FailurePolicyType(opts.webhookFailurePolicy) {
+	case "", admissionregistrationv1.Fail, admissionregistrationv1.Ignore:
+	default:
+		return fmt.Errorf("-webhook-failure-policy must be Fail or Ignore, got %q", opts.webhookFailurePolicy)
+	}
+	// admissionregistration.k8s.io/v1 only accepts webhooks without side
+	// effects, or which skip them on dry-run
+	switch admissionregistrationv1.SideEffectClass(opts.webhookSideEffects) {
+	case "", admissionregistrationv1.SideEffectClassNone, admissionregistrationv1.SideEffectClassNoneOnDryRun:
+	default:
+		return fmt.Errorf("-webhook-side-effects must be None or NoneOnDryRun, got %q", opts.webhookSideEffects)
+	}
+	if opts.webhookTimeoutSeconds != 0 && (opts.webhookTimeoutSeconds < 1 || opts.webhookTimeoutSeconds > 30) {
+		return fmt.Errorf("-webhook-timeout-seconds must be between 1 and 30, got %d", opts.webhookTimeoutSeconds)
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+var logger klog.Logger = klog.LoggerWithName(klog.Background(), "admin")
+
+// Server serves the admin API, which exposes kubeenforcer's internal state
+// to operators. It has no authentication and should only listen on
+// loopback, to be reached through kubectl port-forward.
+type Server struct {
+	addr string
+	mux  *http.ServeMux
+
+	lock  sync.Mutex
+	paths []string
+}
+
+// New creates a Server listening on addr.
+func New(addr string) *Server {
+	s := &Server{addr: addr, mux: http.NewServeMux()}
+	s.mux.HandleFunc("/", s.handleIndex)
+	return s
+}
+
+// Handle registers handler for path. It must be called before Run.
+func (s *Server) Handle(path string, handler http.Handler) {
+	s.lock.Lock()
+	s.paths = append(s.paths, path)
+	s.lock.Unlock()
+	s.mux.Handle(path, handler)
+}
+
+// HandleLocal registers handler for path like Handle, only serving it to
+// clients connecting from loopback, e.g. through kubectl port-forward,
+// whatever address the server listens on. Others are refused with 403. It
+// is meant for endpoints exposing request data or changing the server.
+func (s *Server) HandleLocal(path string, handler http.Handler) {
+	s.Handle(path, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !fromLoopback(r) {
+			http.Error(w, "only served to loopback clients", http.StatusForbidden)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+}
+
+// fromLoopback returns whether r comes from a loopback address.
+func fromLoopback(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// handleIndex lists the registered endpoints.
+func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	s.lock.Lock()
+	paths := append([]string(nil), s.paths...)
+	s.lock.Unlock()
+	sort.Strings(paths)
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	for _, path := range paths {
+		w.Write([]byte(path + "\n"))
+	}
+}
+
+// Run serves the admin API until ctx is cancelled.
+func (s *Server) Run(ctx context.Context) error {
+	server := &http.Server{Addr: s.addr, Handler: s.mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	logger.Info("serving admin API", "addr", s.addr)
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandleLocal(t *testing.T) {
+	s := New("")
+	s.HandleLocal("/dashboard", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		remoteAddr string
+		status     int
+	}{
+		{"127.0.0.1:51234", http.StatusOK},
+		{"[::1]:51234", http.StatusOK},
+		{"10.0.0.12:51234", http.StatusForbidden},
+		{"[fd00::12]:51234", http.StatusForbidden},
+		{"malformed", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/dashboard", nil)
+		req.RemoteAddr = tt.remoteAddr
+		rec := httptest.NewRecorder()
+		s.mux.ServeHTTP(rec, req)
+		if rec.Code != tt.status {
+			t.Errorf("GET /dashboard from %s = %d, want %d", tt.remoteAddr, rec.Code, tt.status)
+		}
+	}
+}
@@ -2,21 +2,67 @@ package alertmanager
 
 import (
 	"context"
+	"sync/atomic"
 	"time"
 
 	httptransport "github.com/go-openapi/runtime/client"
 	"github.com/go-openapi/strfmt"
 	"github.com/prometheus/alertmanager/api/v2/client"
 	alertapi "github.com/prometheus/alertmanager/api/v2/client/alert"
+	"github.com/prometheus/alertmanager/api/v2/client/general"
 	"github.com/prometheus/alertmanager/api/v2/models"
 	"k8s.io/klog/v2"
 )
 
 var logger klog.Logger = klog.LoggerWithName(klog.Background(), "alertmanager")
 
+const (
+	queueSize   = 1000
+	sendTimeout = 10 * time.Second
+)
+
+// AlertManager deduplicates alerts and sends them to alertmanager and the
+// forwarders. Alerts are queued and sent by Run, so raising one never waits
+// on a deduplication backend or alertmanager; they are dropped if the queue
+// is full.
 type AlertManager struct {
 	Host    string
 	ApiPath string
+
+	// Dedup suppresses alerts that were already sent recently. Nil disables
+	// deduplication.
+	Dedup Deduplicator
+
+	// CertFile and KeyFile are a client certificate presented to
+	// alertmanager, CAFile verifies its serving certificate. Alerts are sent
+	// over HTTPS if any of them is set.
+	CertFile, KeyFile, CAFile string
+
+	// StableFingerprints identifies policy violation alerts by their
+	// fingerprint label rather than by their instance and requesting user,
+	// which are sent as annotations instead, so alertmanager merges the
+	// alerts for the same violation, whichever pod of the workload, replica or
+	// retry raised them.
+	StableFingerprints bool
+
+	// GitOpsDedup, if not nil, deduplicates the alerts of objects deployed
+	// by a GitOps application instead of Dedup, usually over a longer
+	// window, as GitOps controllers retry denied syncs indefinitely. A new
+	// revision of the application alerts again.
+	GitOpsDedup Deduplicator
+
+	// Forwarders receive every alert after deduplication, e.g. to index
+	// them in a SIEM, whether or not Host is set.
+	Forwarders []Forwarder
+
+	queue   chan *AlertInfo
+	dropped atomic.Int64
+}
+
+// Forwarder sends alerts to a destination other than alertmanager. Forward
+// must not block.
+type Forwarder interface {
+	Forward(alertInfo *AlertInfo, fingerprint string)
 }
 
 func New(host string, apiPath string) *AlertManager {
@@ -27,13 +73,70 @@ func New(host string, apiPath string) *AlertManager {
 	return &AlertManager{
 		Host:    host,
 		ApiPath: apiPath,
+		queue:   make(chan *AlertInfo, queueSize),
 	}
 }
 
+// Alert queues an alert to be sent by Run.
 func (alertmanager *AlertManager) Alert(alertInfo *AlertInfo) {
-	alert := alertmanager.createAlert(alertInfo)
+	select {
+	case alertmanager.queue <- alertInfo:
+	default:
+		alertmanager.dropped.Add(1)
+	}
+}
+
+// Run sends the queued alerts until ctx is cancelled.
+func (alertmanager *AlertManager) Run(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case alertInfo := <-alertmanager.queue:
+			if dropped := alertmanager.dropped.Swap(0); dropped > 0 {
+				logger.Info("dropped alerts because they could not be sent fast enough", "count", dropped)
+			}
+			alertmanager.send(ctx, alertInfo)
+		}
+	}
+}
+
+func (alertmanager *AlertManager) send(ctx context.Context, alertInfo *AlertInfo) {
+	logger := logger
+	if alertInfo.DecisionID != "" {
+		logger = logger.WithValues("decision", alertInfo.DecisionID)
+	}
+
+	fingerprint := Fingerprint(alertInfo)
+	dedup, key := alertmanager.Dedup, fingerprint
+	if alertmanager.GitOpsDedup != nil && alertInfo.GitOpsApplication != "" {
+		dedup, key = alertmanager.GitOpsDedup, gitOpsKey(alertInfo, fingerprint)
+	}
+	if dedup != nil {
+		ctx, cancel := context.WithTimeout(ctx, sendTimeout)
+		seen, err := dedup.Seen(ctx, key)
+		cancel()
+		if err != nil {
+			// Prefer a duplicate alert over a lost one
+			logger.Error(err, "Alert deduplication failed")
+		} else if seen {
+			logger.V(4).Info("Suppressing duplicate alert", "alert", alertInfo.Name)
+			return
+		}
+	}
+
+	for _, forwarder := range alertmanager.Forwarders {
+		forwarder.Forward(alertInfo, fingerprint)
+	}
+	if alertmanager.Host == "" {
+		return
+	}
 
-	response, err := alertmanager.sendAlertToAlertmanager(alert)
+	alert := alertmanager.createAlert(alertInfo, fingerprint)
+
+	ctx, cancel := context.WithTimeout(ctx, sendTimeout)
+	defer cancel()
+	response, err := alertmanager.sendAlertToAlertmanager(ctx, alert)
 	if err != nil {
 		logger.Error(err, "Alert manager error")
 		return
@@ -42,7 +145,7 @@ func (alertmanager *AlertManager) Alert(alertInfo *AlertInfo) {
 	logger.Info("Response from alertmanager", "response", response)
 }
 
-func (alertmanager *AlertManager) createAlert(alertInfo *AlertInfo) *models.PostableAlert {
+func (alertmanager *AlertManager) createAlert(alertInfo *AlertInfo, fingerprint string) *models.PostableAlert {
 	alert := &models.PostableAlert{
 		Annotations: map[string]string{
 			"description": alertInfo.Description,
@@ -60,17 +163,77 @@ func (alertmanager *AlertManager) createAlert(alertInfo *AlertInfo) *models.Post
 		StartsAt: strfmt.DateTime(time.Now().UTC()),
 		//EndsAt:   strfmt.DateTime(time.Now().Add(time.Hour).UTC()),
 	}
+	if alertInfo.Remediation != "" {
+		alert.Annotations["remediation"] = alertInfo.Remediation
+	}
+	if alertInfo.Policy != "" {
+		alert.Labels["policy"] = alertInfo.Policy
+	}
+	if alertInfo.Workload != "" {
+		alert.Labels["workload"] = alertInfo.Workload
+	}
+	if alertInfo.GitOpsApplication != "" {
+		alert.Labels["gitops_application"] = alertInfo.GitOpsApplication
+	}
+	if alertInfo.GitOpsRevision != "" {
+		alert.Annotations["gitops_revision"] = alertInfo.GitOpsRevision
+	}
+	// Alertmanager identifies alerts by their labels, so only those of the
+	// fingerprint are kept to merge alerts for the same violation
+	if alertmanager.StableFingerprints && alertInfo.Policy != "" && alertInfo.Workload != "" {
+		alert.Labels["fingerprint"] = fingerprint
+		for _, name := range []string{"instance", "requesting_user"} {
+			alert.Annotations[name] = alert.Labels[name]
+			delete(alert.Labels, name)
+		}
+	} else {
+		alert.Annotations["fingerprint"] = fingerprint
+	}
+	// An annotation rather than a label, so alerts for the same violation
+	// are still grouped
+	if alertInfo.DecisionID != "" {
+		alert.Annotations["decision_id"] = alertInfo.DecisionID
+	}
 
 	return alert
 }
 
-func (alertmanager *AlertManager) sendAlertToAlertmanager(alert *models.PostableAlert) (*alertapi.PostAlertsOK, error) {
+// Check reads the status of alertmanager, verifying that alerts can be sent
+// without sending one.
+func (alertmanager *AlertManager) Check(ctx context.Context) error {
+	alertmanagerClient, err := alertmanager.client()
+	if err != nil {
+		return err
+	}
+	_, err = alertmanagerClient.General.GetStatus(general.NewGetStatusParamsWithContext(ctx))
+	return err
+}
+
+func (alertmanager *AlertManager) client() (*client.AlertmanagerAPI, error) {
 	transport := httptransport.New(alertmanager.Host, alertmanager.ApiPath, nil)
-	alertmanagerClient := client.New(transport, nil)
+	if alertmanager.CertFile != "" || alertmanager.CAFile != "" {
+		httpClient, err := httptransport.TLSClient(httptransport.TLSClientOptions{
+			Certificate: alertmanager.CertFile,
+			Key:         alertmanager.KeyFile,
+			CA:          alertmanager.CAFile,
+		})
+		if err != nil {
+			return nil, err
+		}
+		transport = httptransport.NewWithClient(alertmanager.Host, alertmanager.ApiPath, []string{"https"}, httpClient)
+	}
+	return client.New(transport, nil), nil
+}
+
+func (alertmanager *AlertManager) sendAlertToAlertmanager(ctx context.Context, alert *models.PostableAlert) (*alertapi.PostAlertsOK, error) {
+	alertmanagerClient, err := alertmanager.client()
+	if err != nil {
+		return nil, err
+	}
 
 	postAlertsParams := alertapi.PostAlertsParams{
 		Alerts:  []*models.PostableAlert{alert},
-		Context: context.Background(),
+		Context: ctx,
 	}
 
 	response, err := alertmanagerClient.Alert.PostAlerts(&postAlertsParams)
@@ -0,0 +1,338 @@
+package alertmanager
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+// Deduplicator decides whether an alert with a given fingerprint has already
+// been sent recently, possibly by another replica.
+type Deduplicator interface {
+	// Seen records the fingerprint and reports whether it had already been
+	// recorded within the deduplication window.
+	Seen(ctx context.Context, fingerprint string) (bool, error)
+}
+
+// Fingerprint identifies an alert by the violation it describes, so the same
+// violation reported by several replicas, or again on a retried request,
+// maps to the same value. Policy violations are identified by the policy, the
+// owner workload and a hash of the violation, so the violations of the pods
+// of a workload share their fingerprint; other alerts by all their fields.
+func Fingerprint(alertInfo *AlertInfo) string {
+	fields := []string{
+		alertInfo.Name,
+		alertInfo.Resource,
+		alertInfo.Instance,
+		alertInfo.Namespace,
+		alertInfo.RequestingUser,
+		alertInfo.Description,
+	}
+	if alertInfo.Policy != "" && alertInfo.Workload != "" {
+		violation := sha256.Sum256([]byte(alertInfo.Description))
+		fields = []string{
+			alertInfo.Policy,
+			alertInfo.Namespace,
+			alertInfo.Workload,
+			hex.EncodeToString(violation[:]),
+		}
+	}
+	h := sha256.New()
+	for _, v := range fields {
+		h.Write([]byte(v))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// gitOpsKey returns the deduplication key of an alert of an object deployed
+// by a GitOps application: its fingerprint and the revision of the
+// application, so retried syncs of a revision share it. It differs from the
+// fingerprint, so it does not collide with that of another alert in Redis.
+func gitOpsKey(alertInfo *AlertInfo, fingerprint string) string {
+	h := sha256.Sum256([]byte("gitops\x00" + fingerprint + "\x00" + alertInfo.GitOpsRevision))
+	return hex.EncodeToString(h[:])
+}
+
+// NewMemoryDeduplicator returns a Deduplicator local to this process.
+func NewMemoryDeduplicator(window time.Duration) Deduplicator {
+	return &memoryDeduplicator{window: window, seen: map[string]time.Time{}}
+}
+
+type memoryDeduplicator struct {
+	lock   sync.Mutex
+	window time.Duration
+	seen   map[string]time.Time
+}
+
+func (d *memoryDeduplicator) Seen(ctx context.Context, fingerprint string) (bool, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	now := time.Now()
+	for k, t := range d.seen {
+		if now.Sub(t) > d.window {
+			delete(d.seen, k)
+		}
+	}
+
+	if _, ok := d.seen[fingerprint]; ok {
+		return true, nil
+	}
+	d.seen[fingerprint] = now
+	return false, nil
+}
+
+// maxConfigMapEntries is how many fingerprints a ConfigMap holds, about
+// 700KiB, within the 1MiB limit of objects.
+const maxConfigMapEntries = 8000
+
+// NewConfigMapDeduplicator returns a Deduplicator that shares fingerprints
+// between replicas through the data of a ConfigMap. Expired fingerprints are
+// pruned on every write to keep the ConfigMap small, and the oldest ones are
+// evicted once it holds 8000, so a burst of distinct alerts may repeat the
+// oldest ones early rather than fail to be recorded.
+func NewConfigMapDeduplicator(client kubernetes.Interface, namespace, name string, window time.Duration) Deduplicator {
+	return &configMapDeduplicator{
+		client:     client,
+		namespace:  namespace,
+		name:       name,
+		window:     window,
+		maxEntries: maxConfigMapEntries,
+	}
+}
+
+type configMapDeduplicator struct {
+	client     kubernetes.Interface
+	namespace  string
+	name       string
+	window     time.Duration
+	maxEntries int
+}
+
+func (d *configMapDeduplicator) Seen(ctx context.Context, fingerprint string) (bool, error) {
+	seen := false
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		configMaps := d.client.CoreV1().ConfigMaps(d.namespace)
+
+		cm, err := configMaps.Get(ctx, d.name, metav1.GetOptions{})
+		if k8serrors.IsNotFound(err) {
+			cm = &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: d.name, Namespace: d.namespace},
+			}
+			cm.Data = map[string]string{fingerprint: time.Now().UTC().Format(time.RFC3339)}
+			_, err = configMaps.Create(ctx, cm, metav1.CreateOptions{})
+			if k8serrors.IsAlreadyExists(err) {
+				// Another replica created it first, retry as a conflict
+				return k8serrors.NewConflict(corev1.Resource("configmaps"), d.name, err)
+			}
+			return err
+		} else if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		data := map[string]string{}
+		for k, v := range cm.Data {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil || now.Sub(t) > d.window {
+				continue
+			}
+			data[k] = v
+		}
+
+		if _, ok := data[fingerprint]; ok {
+			seen = true
+			return nil
+		}
+
+		if len(data) >= d.maxEntries {
+			evictOldest(data, len(data)-d.maxEntries+1)
+		}
+		data[fingerprint] = now.UTC().Format(time.RFC3339)
+		cm.Data = data
+		_, err = configMaps.Update(ctx, cm, metav1.UpdateOptions{})
+		return err
+	})
+	return seen, err
+}
+
+// evictOldest deletes the n oldest fingerprints of data.
+func evictOldest(data map[string]string, n int) {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	// RFC 3339 UTC times sort chronologically
+	sort.Slice(keys, func(i, j int) bool {
+		if data[keys[i]] != data[keys[j]] {
+			return data[keys[i]] < data[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	for _, k := range keys[:n] {
+		delete(data, k)
+	}
+	logger.V(2).Info("evicted alert fingerprints from the full deduplication ConfigMap", "count", n)
+}
+
+// RedisOptions configures the connection to the Redis server of a
+// Deduplicator.
+type RedisOptions struct {
+	Addr string
+
+	// Username and Password authenticate connections with AUTH. Username is
+	// only set for Redis 6 ACL users.
+	Username string
+	Password string
+
+	// TLS, if not nil, connects over TLS with this configuration.
+	TLS *tls.Config
+}
+
+// maxIdleRedisConns is how many connections to Redis are kept open.
+const maxIdleRedisConns = 4
+
+// NewRedisDeduplicator returns a Deduplicator backed by a Redis server, using
+// SET NX with an expiry so Redis prunes old fingerprints. Connections are
+// reused between calls.
+func NewRedisDeduplicator(opts RedisOptions, window time.Duration) Deduplicator {
+	return &redisDeduplicator{opts: opts, window: window, idle: make(chan *redisConn, maxIdleRedisConns)}
+}
+
+type redisDeduplicator struct {
+	opts   RedisOptions
+	window time.Duration
+	idle   chan *redisConn
+}
+
+type redisConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (d *redisDeduplicator) Seen(ctx context.Context, fingerprint string) (bool, error) {
+	var reply string
+	for reuse := true; ; reuse = false {
+		conn, reused, err := d.get(ctx, reuse)
+		if err != nil {
+			return false, err
+		}
+		if deadline, ok := ctx.Deadline(); ok {
+			conn.SetDeadline(deadline)
+		} else {
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+		}
+
+		reply, err = conn.do("SET", "kubeenforcer:alert:"+fingerprint, "1", "NX", "PX", fmt.Sprint(d.window.Milliseconds()))
+		if err != nil {
+			// The connection may be in the middle of a reply
+			conn.Close()
+			if reused {
+				// Redis may have closed the idle connection, retry on a new one
+				continue
+			}
+			return false, err
+		}
+		d.put(conn)
+		break
+	}
+
+	switch {
+	case reply == "+OK":
+		return false, nil
+	case reply == "$-1":
+		// NX prevented the set, the key already exists
+		return true, nil
+	case strings.HasPrefix(reply, "-"):
+		return false, fmt.Errorf("redis: %s", strings.TrimPrefix(reply, "-"))
+	default:
+		return false, fmt.Errorf("redis: unexpected reply %q", reply)
+	}
+}
+
+// get returns an idle connection if reuse is set and there is one, or else a
+// new authenticated one, and whether it was reused.
+func (d *redisDeduplicator) get(ctx context.Context, reuse bool) (*redisConn, bool, error) {
+	if reuse {
+		select {
+		case conn := <-d.idle:
+			return conn, true, nil
+		default:
+		}
+	}
+
+	var conn net.Conn
+	var err error
+	if d.opts.TLS != nil {
+		dialer := tls.Dialer{Config: d.opts.TLS}
+		conn, err = dialer.DialContext(ctx, "tcp", d.opts.Addr)
+	} else {
+		var dialer net.Dialer
+		conn, err = dialer.DialContext(ctx, "tcp", d.opts.Addr)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	c := &redisConn{Conn: conn, reader: bufio.NewReader(conn)}
+
+	if d.opts.Password != "" {
+		if deadline, ok := ctx.Deadline(); ok {
+			c.SetDeadline(deadline)
+		}
+		args := []string{"AUTH", d.opts.Password}
+		if d.opts.Username != "" {
+			args = []string{"AUTH", d.opts.Username, d.opts.Password}
+		}
+		reply, err := c.do(args...)
+		if err == nil && reply != "+OK" {
+			err = fmt.Errorf("redis: authentication failed: %s", strings.TrimPrefix(reply, "-"))
+		}
+		if err != nil {
+			c.Close()
+			return nil, false, err
+		}
+	}
+	return c, false, nil
+}
+
+// put keeps conn for reuse, or closes it if enough connections are idle.
+func (d *redisDeduplicator) put(conn *redisConn) {
+	select {
+	case d.idle <- conn:
+	default:
+		conn.Close()
+	}
+}
+
+// do sends a command and returns the first line of its reply.
+func (c *redisConn) do(args ...string) (string, error) {
+	var cmd strings.Builder
+	fmt.Fprintf(&cmd, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&cmd, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if _, err := c.Write([]byte(cmd.String())); err != nil {
+		return "", err
+	}
+
+	reply, err := c.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(reply), nil
+}
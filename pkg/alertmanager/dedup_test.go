@@ -0,0 +1,288 @@
+package alertmanager
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// fakeRedis serves the SET NX and AUTH commands of Redis, requiring password
+// if not empty, and counts the connections accepted.
+type fakeRedis struct {
+	listener net.Listener
+	password string
+
+	lock        sync.Mutex
+	keys        map[string]bool
+	connections int
+	conns       []net.Conn
+}
+
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &fakeRedis{listener: listener, password: password, keys: map[string]bool{}}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			r.lock.Lock()
+			r.connections++
+			r.conns = append(r.conns, conn)
+			r.lock.Unlock()
+			go r.serve(conn)
+		}
+	}()
+	return r
+}
+
+// closeConnections closes the accepted connections, as Redis does with idle
+// clients after its timeout.
+func (r *fakeRedis) closeConnections() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, conn := range r.conns {
+		conn.Close()
+	}
+	r.conns = nil
+}
+
+func (r *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	authenticated := r.password == ""
+	for {
+		args, err := readCommand(reader)
+		if err != nil {
+			return
+		}
+		reply := "-ERR unknown command"
+		switch {
+		case args[0] == "AUTH":
+			if args[len(args)-1] == r.password {
+				authenticated = true
+				reply = "+OK"
+			} else {
+				reply = "-WRONGPASS invalid password"
+			}
+		case !authenticated:
+			reply = "-NOAUTH Authentication required."
+		case args[0] == "SET" && len(args) == 6 && args[3] == "NX" && args[4] == "PX":
+			r.lock.Lock()
+			if r.keys[args[1]] {
+				reply = "$-1"
+			} else {
+				r.keys[args[1]] = true
+				reply = "+OK"
+			}
+			r.lock.Unlock()
+		}
+		if _, err := conn.Write([]byte(reply + "\r\n")); err != nil {
+			return
+		}
+	}
+}
+
+func readCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("invalid command %q", line)
+	}
+	args := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		if _, err := reader.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		arg, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, strings.TrimSuffix(arg, "\r\n"))
+	}
+	return args, nil
+}
+
+func (r *fakeRedis) connectionCount() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.connections
+}
+
+func TestDeduplicators(t *testing.T) {
+	tests := []struct {
+		name  string
+		dedup func(t *testing.T, window time.Duration) Deduplicator
+	}{
+		{
+			name: "memory",
+			dedup: func(t *testing.T, window time.Duration) Deduplicator {
+				return NewMemoryDeduplicator(window)
+			},
+		},
+		{
+			name: "configmap",
+			dedup: func(t *testing.T, window time.Duration) Deduplicator {
+				return NewConfigMapDeduplicator(fake.NewSimpleClientset(), "kubeenforcer", "alert-dedup", window)
+			},
+		},
+		{
+			name: "redis",
+			dedup: func(t *testing.T, window time.Duration) Deduplicator {
+				return NewRedisDeduplicator(RedisOptions{Addr: newFakeRedis(t, "").listener.Addr().String()}, window)
+			},
+		},
+		{
+			name: "redis with password",
+			dedup: func(t *testing.T, window time.Duration) Deduplicator {
+				redis := newFakeRedis(t, "secret")
+				return NewRedisDeduplicator(RedisOptions{Addr: redis.listener.Addr().String(), Username: "kubeenforcer", Password: "secret"}, window)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			dedup := tt.dedup(t, time.Hour)
+			for i, step := range []struct {
+				fingerprint string
+				seen        bool
+			}{
+				{"a", false},
+				{"a", true},
+				{"b", false},
+				{"a", true},
+				{"b", true},
+			} {
+				seen, err := dedup.Seen(ctx, step.fingerprint)
+				if err != nil {
+					t.Fatalf("step %d: %v", i, err)
+				}
+				if seen != step.seen {
+					t.Errorf("step %d: Seen(%q) = %v, want %v", i, step.fingerprint, seen, step.seen)
+				}
+			}
+		})
+	}
+}
+
+func TestConfigMapDeduplicatorPrunesExpired(t *testing.T) {
+	client := fake.NewSimpleClientset(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "kubeenforcer", Name: "alert-dedup"},
+		Data: map[string]string{
+			"old":     time.Now().Add(-2 * time.Hour).UTC().Format(time.RFC3339),
+			"recent":  time.Now().UTC().Format(time.RFC3339),
+			"invalid": "yesterday",
+		},
+	})
+	dedup := NewConfigMapDeduplicator(client, "kubeenforcer", "alert-dedup", time.Hour)
+
+	ctx := context.Background()
+	if seen, err := dedup.Seen(ctx, "old"); err != nil || seen {
+		t.Errorf("Seen(old) = %v, %v, want false, nil", seen, err)
+	}
+	if seen, err := dedup.Seen(ctx, "recent"); err != nil || !seen {
+		t.Errorf("Seen(recent) = %v, %v, want true, nil", seen, err)
+	}
+
+	cm, err := client.CoreV1().ConfigMaps("kubeenforcer").Get(ctx, "alert-dedup", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cm.Data["invalid"]; ok {
+		t.Errorf("invalid entry was not pruned: %v", cm.Data)
+	}
+	if len(cm.Data) != 2 {
+		t.Errorf("data = %v, want old and recent", cm.Data)
+	}
+}
+
+func TestConfigMapDeduplicatorEvictsOldest(t *testing.T) {
+	now := time.Now().UTC()
+	client := fake.NewSimpleClientset(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "kubeenforcer", Name: "alert-dedup"},
+		Data: map[string]string{
+			"oldest": now.Add(-3 * time.Minute).Format(time.RFC3339),
+			"older":  now.Add(-2 * time.Minute).Format(time.RFC3339),
+			"recent": now.Add(-time.Minute).Format(time.RFC3339),
+		},
+	})
+	dedup := NewConfigMapDeduplicator(client, "kubeenforcer", "alert-dedup", time.Hour).(*configMapDeduplicator)
+	dedup.maxEntries = 3
+
+	ctx := context.Background()
+	if seen, err := dedup.Seen(ctx, "new"); err != nil || seen {
+		t.Errorf("Seen(new) = %v, %v, want false, nil", seen, err)
+	}
+
+	cm, err := client.CoreV1().ConfigMaps("kubeenforcer").Get(ctx, "alert-dedup", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"new", "older", "recent"}
+	var got []string
+	for k := range cm.Data {
+		got = append(got, k)
+	}
+	sort.Strings(got)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("fingerprints = %v, want %v", got, want)
+	}
+}
+
+func TestRedisDeduplicatorReusesConnections(t *testing.T) {
+	redis := newFakeRedis(t, "")
+	dedup := NewRedisDeduplicator(RedisOptions{Addr: redis.listener.Addr().String()}, time.Hour)
+
+	ctx := context.Background()
+	for i := 0; i < 10; i++ {
+		if _, err := dedup.Seen(ctx, fmt.Sprint(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := redis.connectionCount(); n != 1 {
+		t.Errorf("opened %d connections, want 1", n)
+	}
+
+	// An idle connection closed by Redis is replaced
+	redis.closeConnections()
+	seen, err := dedup.Seen(ctx, "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !seen {
+		t.Error("Seen(0) = false after reconnecting, want true")
+	}
+	if n := redis.connectionCount(); n != 2 {
+		t.Errorf("opened %d connections, want 2", n)
+	}
+}
+
+func TestRedisDeduplicatorWrongPassword(t *testing.T) {
+	redis := newFakeRedis(t, "secret")
+	dedup := NewRedisDeduplicator(RedisOptions{Addr: redis.listener.Addr().String(), Password: "wrong"}, time.Hour)
+
+	if _, err := dedup.Seen(context.Background(), "a"); err == nil || !strings.Contains(err.Error(), "authentication failed") {
+		t.Errorf("Seen() error = %v, want an authentication failure", err)
+	}
+}
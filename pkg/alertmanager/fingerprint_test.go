@@ -0,0 +1,122 @@
+package alertmanager
+
+import "testing"
+
+func TestFingerprint(t *testing.T) {
+	violation := AlertInfo{
+		Name:           "Policy violation",
+		Resource:       "pods",
+		Instance:       "web-7d4b9c8f6-x2x9k",
+		Namespace:      "payments",
+		RequestingUser: "system:serviceaccount:kube-system:replicaset-controller",
+		Description:    "privileged containers are not allowed",
+		Policy:         "deny-privileged",
+		Workload:       "Deployment/web",
+	}
+	other := AlertInfo{
+		Name:           "Webhook configuration drift",
+		Resource:       "validatingwebhookconfigurations",
+		Instance:       "kubeenforcer",
+		RequestingUser: "admin",
+		Description:    "failurePolicy of webhook webhook.kubeenforcer.io was changed to Ignore, reverting to Fail",
+	}
+
+	tests := []struct {
+		name   string
+		a      AlertInfo
+		change func(*AlertInfo)
+		same   bool
+	}{
+		{
+			name:   "violation of another pod of the workload",
+			a:      violation,
+			change: func(a *AlertInfo) { a.Instance = "web-7d4b9c8f6-q8z2m" },
+			same:   true,
+		},
+		{
+			name:   "violation requested by another user",
+			a:      violation,
+			change: func(a *AlertInfo) { a.RequestingUser = "alice" },
+			same:   true,
+		},
+		{
+			name:   "violation with another decision ID and revision",
+			a:      violation,
+			change: func(a *AlertInfo) { a.DecisionID = "b7c1"; a.GitOpsRevision = "9f2e" },
+			same:   true,
+		},
+		{
+			name:   "violation of another policy",
+			a:      violation,
+			change: func(a *AlertInfo) { a.Policy = "deny-host-network" },
+		},
+		{
+			name:   "violation of another workload",
+			a:      violation,
+			change: func(a *AlertInfo) { a.Workload = "Deployment/api" },
+		},
+		{
+			name:   "violation in another namespace",
+			a:      violation,
+			change: func(a *AlertInfo) { a.Namespace = "checkout" },
+		},
+		{
+			name:   "another violation of the policy",
+			a:      violation,
+			change: func(a *AlertInfo) { a.Description = "host network is not allowed" },
+		},
+		{
+			name:   "alert without a policy uses every field",
+			a:      violation,
+			change: func(a *AlertInfo) { a.Policy = ""; a.Instance = "web-7d4b9c8f6-q8z2m" },
+		},
+		{
+			name:   "alert without a workload uses every field",
+			a:      violation,
+			change: func(a *AlertInfo) { a.Workload = ""; a.RequestingUser = "alice" },
+		},
+		{
+			name:   "other alert with the same fields",
+			a:      other,
+			change: func(a *AlertInfo) {},
+			same:   true,
+		},
+		{
+			name:   "other alert for another instance",
+			a:      other,
+			change: func(a *AlertInfo) { a.Instance = "kubeenforcer-audit" },
+		},
+		{
+			name:   "other alert by another user",
+			a:      other,
+			change: func(a *AlertInfo) { a.RequestingUser = "bob" },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := tt.a, tt.a
+			tt.change(&b)
+			fa, fb := Fingerprint(&a), Fingerprint(&b)
+			if fa != Fingerprint(&a) {
+				t.Fatal("Fingerprint() is not deterministic")
+			}
+			if (fa == fb) != tt.same {
+				t.Errorf("Fingerprint() = %s and %s, want same %v", fa, fb, tt.same)
+			}
+		})
+	}
+}
+
+func TestGitOpsKey(t *testing.T) {
+	alert := AlertInfo{Policy: "deny-privileged", Workload: "Deployment/web", GitOpsApplication: "Application/web", GitOpsRevision: "9f2e"}
+	fingerprint := Fingerprint(&alert)
+
+	if key := gitOpsKey(&alert, fingerprint); key == fingerprint {
+		t.Error("gitOpsKey() equals the fingerprint")
+	}
+	next := alert
+	next.GitOpsRevision = "a31c"
+	if gitOpsKey(&alert, fingerprint) == gitOpsKey(&next, Fingerprint(&next)) {
+		t.Error("gitOpsKey() is the same for another revision")
+	}
+}
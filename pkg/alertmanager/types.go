@@ -8,4 +8,22 @@ type AlertInfo struct {
 	Description    string
 	Namespace      string
 	RequestingUser string
+
+	// Remediation is a machine-readable fix for the violation, if known.
+	Remediation string
+
+	// Policy and Workload are the policy violated and the workload owning
+	// the violating object, as Kind/name, if known. They identify the
+	// violation in its fingerprint.
+	Policy   string
+	Workload string
+
+	// GitOpsApplication is the Argo CD Application or Flux Kustomization or
+	// HelmRelease deploying the violating object, as Kind/name, and
+	// GitOpsRevision the commit it was deployed from, if known.
+	GitOpsApplication string
+	GitOpsRevision    string
+
+	// DecisionID identifies the admission decision that raised the alert.
+	DecisionID string
 }
@@ -0,0 +1,247 @@
+package auditlog
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+	"k8s.io/klog/v2"
+
+	"github.com/kubescape/kubeenforcer/pkg/decision"
+)
+
+var logger klog.Logger = klog.LoggerWithName(klog.Background(), "auditlog")
+
+const (
+	bufferSize    = 1000
+	batchSize     = 100
+	flushInterval = time.Second
+	sendTimeout   = 10 * time.Second
+)
+
+// annotationPrefix prefixes the annotations of the events, like the
+// annotations admission plugins add to API server audit events.
+const annotationPrefix = "kubeenforcer.kubescape.io/"
+
+// Sink writes decisions as Kubernetes audit events, audit.k8s.io/v1 Event,
+// so audit ingestion pipelines and detection rules apply to them: to a file
+// one JSON event per line like the API server log backend, or to a webhook
+// as EventLists like the API server webhook backend. It is a decision.Sink;
+// decisions are buffered and dropped if the output cannot keep up.
+type Sink struct {
+	// file is the output of file sinks
+	file io.WriteCloser
+	// url and client post to the webhook of webhook sinks
+	url    string
+	client *http.Client
+
+	buffer  chan *decision.Decision
+	dropped atomic.Int64
+}
+
+// NewFile creates a Sink appending events to the file at path, or writing
+// them to stdout if path is -.
+func NewFile(path string) (*Sink, error) {
+	var file io.WriteCloser = os.Stdout
+	if path != "-" {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+		if err != nil {
+			return nil, err
+		}
+		file = f
+	}
+	return &Sink{file: file, buffer: make(chan *decision.Decision, bufferSize)}, nil
+}
+
+// NewWebhook creates a Sink posting events to the webhook at url with
+// client.
+func NewWebhook(url string, client *http.Client) *Sink {
+	c := *client
+	c.Timeout = sendTimeout
+	return &Sink{url: url, client: &c, buffer: make(chan *decision.Decision, bufferSize)}
+}
+
+func (s *Sink) Record(d *decision.Decision) {
+	select {
+	case s.buffer <- d:
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+// Run writes buffered decisions until ctx is cancelled.
+func (s *Sink) Run(ctx context.Context) error {
+	logger.Info("writing decisions as audit events", "output", s.output())
+	defer logger.Info("stopped writing audit events")
+	if s.file != nil && s.file != os.Stdout {
+		defer s.file.Close()
+	}
+
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	var batch []auditv1.Event
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := s.write(ctx, batch); err != nil && ctx.Err() == nil {
+			logger.Error(err, "failed to write audit events", "count", len(batch))
+		}
+		batch = batch[:0]
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case d := <-s.buffer:
+			batch = append(batch, *event(d))
+			if len(batch) >= batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			if dropped := s.dropped.Swap(0); dropped > 0 {
+				logger.Info("dropped decisions because the audit event output could not keep up", "count", dropped)
+			}
+			flush()
+		}
+	}
+}
+
+func (s *Sink) output() string {
+	if s.file != nil {
+		if f, ok := s.file.(*os.File); ok {
+			return f.Name()
+		}
+	}
+	return s.url
+}
+
+func (s *Sink) write(ctx context.Context, events []auditv1.Event) error {
+	if s.file != nil {
+		w := bufio.NewWriter(s.file)
+		encoder := json.NewEncoder(w)
+		for i := range events {
+			if err := encoder.Encode(&events[i]); err != nil {
+				return err
+			}
+		}
+		return w.Flush()
+	}
+
+	body, err := json.Marshal(&auditv1.EventList{
+		TypeMeta: metav1.TypeMeta{APIVersion: auditv1.SchemeGroupVersion.String(), Kind: "EventList"},
+		Items:    events,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("audit webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// event returns the audit event of d, at the Metadata level and the
+// ResponseComplete stage. The request URI is the API path of the object, the
+// verb the lowercased admission operation, the response status 200 for
+// allowed requests and 403 for denied ones, and the decision details are
+// annotations prefixed with kubeenforcer.kubescape.io/.
+func event(d *decision.Decision) *auditv1.Event {
+	code := int32(http.StatusOK)
+	if !d.Allowed {
+		code = http.StatusForbidden
+	}
+	out := &auditv1.Event{
+		TypeMeta:   metav1.TypeMeta{APIVersion: auditv1.SchemeGroupVersion.String(), Kind: "Event"},
+		Level:      auditv1.LevelMetadata,
+		AuditID:    types.UID(d.ID),
+		Stage:      auditv1.StageResponseComplete,
+		RequestURI: requestURI(d),
+		Verb:       strings.ToLower(d.Operation),
+		ObjectRef: &auditv1.ObjectReference{
+			Resource:    d.Resource,
+			Namespace:   d.Namespace,
+			Name:        d.Name,
+			APIGroup:    d.Group,
+			APIVersion:  d.Version,
+			Subresource: d.SubResource,
+		},
+		ResponseStatus: &metav1.Status{
+			Status:  metav1.StatusSuccess,
+			Code:    code,
+			Message: d.Message,
+		},
+		RequestReceivedTimestamp: metav1.NewMicroTime(d.Time),
+		StageTimestamp:           metav1.NewMicroTime(d.Time),
+		Annotations: map[string]string{
+			annotationPrefix + "decision": "allow",
+			annotationPrefix + "uid":      d.UID,
+		},
+	}
+	out.User.Username = d.User
+	if !d.Allowed {
+		out.ResponseStatus.Status = metav1.StatusFailure
+		out.ResponseStatus.Reason = metav1.StatusReasonForbidden
+		out.Annotations[annotationPrefix+"decision"] = "deny"
+	}
+	for name, value := range map[string]string{
+		"policy":     d.Policy,
+		"actions":    strings.Join(d.Actions, ","),
+		"cluster":    d.Cluster,
+		"policy-set": d.PolicySet,
+		"version":    d.KubeenforcerVersion,
+	} {
+		if value != "" {
+			out.Annotations[annotationPrefix+name] = value
+		}
+	}
+	if d.Throttled {
+		out.Annotations[annotationPrefix+"throttled"] = "true"
+	}
+	if d.Collection {
+		out.Annotations[annotationPrefix+"collection"] = "true"
+	}
+	return out
+}
+
+// requestURI returns the API path of the object of d, e.g.
+// /apis/apps/v1/namespaces/default/deployments/web.
+func requestURI(d *decision.Decision) string {
+	path := "/api/" + d.Version
+	if d.Group != "" {
+		path = "/apis/" + d.Group + "/" + d.Version
+	}
+	if d.Namespace != "" && d.Resource != "namespaces" {
+		path += "/namespaces/" + d.Namespace
+	}
+	path += "/" + d.Resource
+	if d.Name != "" {
+		path += "/" + d.Name
+		if d.SubResource != "" {
+			path += "/" + d.SubResource
+		}
+	}
+	return path
+}
@@ -0,0 +1,161 @@
+package authz
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+
+	"github.com/kubescape/kubeenforcer/pkg/metrics"
+)
+
+var logger klog.Logger = klog.LoggerWithName(klog.Background(), "authz")
+
+var (
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "authorizer",
+		Name:      "requests_total",
+		Help:      "SubjectAccessReviews sent for CEL authorizer checks, by response code.",
+	}, []string{"code"})
+
+	requestLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "authorizer",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of SubjectAccessReviews sent for CEL authorizer checks, by response code.",
+		Buckets:   prometheus.ExponentialBuckets(0.005, 2, 10),
+	}, []string{"code"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(requestsTotal, requestLatency)
+}
+
+type subjectAccessReview struct {
+	client          kubernetes.Interface
+	authorizedTTL   time.Duration
+	unauthorizedTTL time.Duration
+
+	lock      sync.Mutex
+	decisions map[[sha256.Size]byte]cachedDecision
+}
+
+type cachedDecision struct {
+	decision authorizer.Decision
+	reason   string
+	expires  time.Time
+}
+
+// NewSubjectAccessReview returns an Authorizer that asks the API server
+// through SubjectAccessReviews, which backs the authorizer variable of CEL
+// policy expressions such as
+//
+//	authorizer.group('').resource('pods').subresource('exec').check('create').allowed()
+//
+// Decisions are cached for the given TTLs as policies are evaluated on every
+// admission.
+func NewSubjectAccessReview(client kubernetes.Interface, authorizedTTL, unauthorizedTTL time.Duration) authorizer.Authorizer {
+	return &subjectAccessReview{
+		client:          client,
+		authorizedTTL:   authorizedTTL,
+		unauthorizedTTL: unauthorizedTTL,
+		decisions:       map[[sha256.Size]byte]cachedDecision{},
+	}
+}
+
+func (s *subjectAccessReview) Authorize(ctx context.Context, attrs authorizer.Attributes) (authorizer.Decision, string, error) {
+	spec := specFor(attrs)
+	raw, err := json.Marshal(spec)
+	if err != nil {
+		return authorizer.DecisionNoOpinion, "", err
+	}
+	key := sha256.Sum256(raw)
+
+	s.lock.Lock()
+	cached, ok := s.decisions[key]
+	s.lock.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.decision, cached.reason, nil
+	}
+
+	start := time.Now()
+	review, err := s.client.AuthorizationV1().SubjectAccessReviews().Create(ctx, &authorizationv1.SubjectAccessReview{Spec: spec}, metav1.CreateOptions{})
+	code := "201"
+	if status, ok := err.(k8serrors.APIStatus); ok {
+		code = strconv.Itoa(int(status.Status().Code))
+	} else if err != nil {
+		code = "<error>"
+	}
+	requestsTotal.WithLabelValues(code).Inc()
+	requestLatency.WithLabelValues(code).Observe(time.Since(start).Seconds())
+	if err != nil {
+		logger.Error(err, "failed to create subject access review", "user", attrs.GetUser().GetName())
+		return authorizer.DecisionNoOpinion, "", err
+	}
+
+	decision, ttl := authorizer.DecisionNoOpinion, s.unauthorizedTTL
+	switch {
+	case review.Status.Allowed:
+		decision, ttl = authorizer.DecisionAllow, s.authorizedTTL
+	case review.Status.Denied:
+		decision = authorizer.DecisionDeny
+	}
+
+	s.lock.Lock()
+	now := time.Now()
+	for k, v := range s.decisions {
+		if now.After(v.expires) {
+			delete(s.decisions, k)
+		}
+	}
+	s.decisions[key] = cachedDecision{decision: decision, reason: review.Status.Reason, expires: now.Add(ttl)}
+	s.lock.Unlock()
+
+	return decision, review.Status.Reason, nil
+}
+
+// specFor translates authorization attributes into a SubjectAccessReview
+// spec, as the API server's webhook authorizer does.
+func specFor(attrs authorizer.Attributes) authorizationv1.SubjectAccessReviewSpec {
+	spec := authorizationv1.SubjectAccessReviewSpec{}
+	if user := attrs.GetUser(); user != nil {
+		spec.User = user.GetName()
+		spec.UID = user.GetUID()
+		spec.Groups = user.GetGroups()
+		if extra := user.GetExtra(); len(extra) > 0 {
+			spec.Extra = make(map[string]authorizationv1.ExtraValue, len(extra))
+			for k, v := range extra {
+				spec.Extra[k] = v
+			}
+		}
+	}
+
+	if attrs.IsResourceRequest() {
+		spec.ResourceAttributes = &authorizationv1.ResourceAttributes{
+			Namespace:   attrs.GetNamespace(),
+			Verb:        attrs.GetVerb(),
+			Group:       attrs.GetAPIGroup(),
+			Version:     attrs.GetAPIVersion(),
+			Resource:    attrs.GetResource(),
+			Subresource: attrs.GetSubresource(),
+			Name:        attrs.GetName(),
+		}
+	} else {
+		spec.NonResourceAttributes = &authorizationv1.NonResourceAttributes{
+			Path: attrs.GetPath(),
+			Verb: attrs.GetVerb(),
+		}
+	}
+	return spec
+}
@@ -0,0 +1,287 @@
+package bench
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/klog/v2"
+)
+
+var logger klog.Logger = klog.LoggerWithName(klog.Background(), "bench")
+
+type Options struct {
+	// URL of the validate endpoint, e.g. https://127.0.0.1:8443/validate
+	URL string
+
+	// Requests per second to attempt, up to MaxQPS. Zero means as fast as
+	// the workers allow.
+	QPS float64
+
+	// How long to run for.
+	Duration time.Duration
+
+	// Number of concurrent workers issuing requests.
+	Concurrency int
+
+	// Path to a CA bundle used to verify the server. If empty and Insecure is
+	// false, the system roots are used.
+	CAFile   string
+	Insecure bool
+
+	// Directory or file of recorded AdmissionReview JSON documents to replay.
+	// If empty, synthetic Pod CREATE reviews are generated.
+	Recorded string
+}
+
+// MaxQPS is the highest rate requests can be scheduled at.
+const MaxQPS = 1e6
+
+type Report struct {
+	Requests int
+	Allowed  int
+	Denied   int
+	Errors   int
+	Elapsed  time.Duration
+
+	latencies histogram
+}
+
+// Percentile returns the p-th percentile of the latencies, within 1%.
+func (r *Report) Percentile(p float64) time.Duration {
+	return r.latencies.percentile(p)
+}
+
+func (r *Report) Print(w io.Writer) {
+	qps := 0.0
+	if r.Elapsed > 0 {
+		qps = float64(r.Requests) / r.Elapsed.Seconds()
+	}
+	fmt.Fprintf(w, "requests:\t%d (%.1f/s)\n", r.Requests, qps)
+	fmt.Fprintf(w, "allowed:\t%d\n", r.Allowed)
+	fmt.Fprintf(w, "denied:\t\t%d\n", r.Denied)
+	fmt.Fprintf(w, "errors:\t\t%d\n", r.Errors)
+	fmt.Fprintf(w, "latency p50:\t%v\n", r.Percentile(50))
+	fmt.Fprintf(w, "latency p90:\t%v\n", r.Percentile(90))
+	fmt.Fprintf(w, "latency p99:\t%v\n", r.Percentile(99))
+	fmt.Fprintf(w, "latency max:\t%v\n", r.Percentile(100))
+}
+
+// Run replays admission reviews against opts.URL until opts.Duration elapses
+// or ctx is cancelled, and returns the collected latency report. With a QPS,
+// latencies are measured from when requests were scheduled to be sent, so
+// the time they wait for a worker while the webhook is slow is counted.
+func Run(ctx context.Context, opts Options) (*Report, error) {
+	if math.IsNaN(opts.QPS) || opts.QPS < 0 || opts.QPS > MaxQPS {
+		return nil, fmt.Errorf("qps must be between 0 and %g, got %g", float64(MaxQPS), opts.QPS)
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 1
+	}
+	if opts.Duration <= 0 {
+		opts.Duration = 10 * time.Second
+	}
+
+	reviews, err := loadReviews(opts.Recorded)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := newClient(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, opts.Duration)
+	defer cancel()
+
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	report := &Report{}
+	start := time.Now()
+
+	// Tokens are handed out at the requested rate, carrying the time their
+	// request is scheduled for; workers block on them.
+	tokens := make(chan token, opts.Concurrency)
+	go func() {
+		defer close(tokens)
+
+		var interval time.Duration
+		var timer *time.Timer
+		if opts.QPS > 0 {
+			interval = time.Duration(float64(time.Second) / opts.QPS)
+			timer = time.NewTimer(0)
+			defer timer.Stop()
+			<-timer.C
+		}
+		for i := 0; ; i++ {
+			t := token{i: i}
+			if timer != nil {
+				t.scheduled = start.Add(time.Duration(i) * interval)
+				if wait := time.Until(t.scheduled); wait > 0 {
+					timer.Reset(wait)
+					select {
+					case <-ctx.Done():
+						return
+					case <-timer.C:
+					}
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case tokens <- t:
+			}
+		}
+	}()
+
+	for w := 0; w < opts.Concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range tokens {
+				began := t.scheduled
+				if began.IsZero() {
+					began = time.Now()
+				}
+				allowed, err := send(ctx, client, opts.URL, reviews[t.i%len(reviews)])
+				latency := time.Since(began)
+
+				if err != nil && ctx.Err() != nil {
+					// Requests cut short by the end of the run are not counted
+					continue
+				}
+
+				lock.Lock()
+				report.Requests++
+				switch {
+				case err != nil:
+					report.Errors++
+					logger.V(2).Info("request failed", "err", err)
+				case allowed:
+					report.Allowed++
+				default:
+					report.Denied++
+				}
+				report.latencies.record(latency)
+				lock.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+	report.Elapsed = time.Since(start)
+	return report, nil
+}
+
+// token is a request to send: the index of its review and, with a QPS, when
+// it is scheduled to be sent.
+type token struct {
+	i         int
+	scheduled time.Time
+}
+
+func send(ctx context.Context, client *http.Client, url string, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return false, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+
+	var review admissionv1.AdmissionReview
+	if err := json.NewDecoder(resp.Body).Decode(&review); err != nil {
+		return false, err
+	}
+	if review.Response == nil {
+		return false, fmt.Errorf("admission review has no response")
+	}
+	return review.Response.Allowed, nil
+}
+
+func newClient(opts Options) (*http.Client, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: opts.Insecure}
+	if opts.CAFile != "" {
+		pem, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", opts.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig:     tlsConfig,
+			MaxIdleConnsPerHost: opts.Concurrency,
+		},
+	}, nil
+}
+
+// loadReviews reads every *.json file under path as an AdmissionReview. If
+// path is empty a set of synthetic reviews is returned instead.
+func loadReviews(path string) ([][]byte, error) {
+	if path == "" {
+		return syntheticReviews()
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []string{path}
+	if info.IsDir() {
+		files, err = filepath.Glob(filepath.Join(path, "*.json"))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var res [][]byte
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+
+		var review admissionv1.AdmissionReview
+		if err := json.Unmarshal(data, &review); err != nil {
+			return nil, fmt.Errorf("%s: %w", f, err)
+		}
+		if review.Request == nil {
+			return nil, fmt.Errorf("%s: admission review has no request", f)
+		}
+		res = append(res, data)
+	}
+
+	if len(res) == 0 {
+		return nil, fmt.Errorf("no recorded admission reviews found in %s", path)
+	}
+	return res, nil
+}
@@ -0,0 +1,62 @@
+package bench
+
+import (
+	"math"
+	"time"
+)
+
+// bucketGrowth is the ratio between the bounds of successive buckets of a
+// histogram, which bounds the error of its percentiles.
+const bucketGrowth = 1.01
+
+var logBucketGrowth = math.Log(bucketGrowth)
+
+// histogram counts latencies in buckets growing by 1%, so percentiles are
+// reported within 1% in constant memory however many requests are sent:
+// about 3000 buckets cover up to an hour.
+type histogram struct {
+	counts []int64
+	total  int64
+	max    time.Duration
+}
+
+// bucket returns the bucket of d, whose upper bound is
+// bucketGrowth^bucket nanoseconds.
+func bucket(d time.Duration) int {
+	if d <= 1 {
+		return 0
+	}
+	return int(math.Ceil(math.Log(float64(d)) / logBucketGrowth))
+}
+
+func (h *histogram) record(d time.Duration) {
+	b := bucket(d)
+	if b >= len(h.counts) {
+		h.counts = append(h.counts, make([]int64, b+1-len(h.counts))...)
+	}
+	h.counts[b]++
+	h.total++
+	if d > h.max {
+		h.max = d
+	}
+}
+
+// percentile returns the upper bound of the bucket holding the p-th
+// percentile, or the largest latency recorded if lower.
+func (h *histogram) percentile(p float64) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	rank := int64(float64(h.total-1)*p/100) + 1
+	var seen int64
+	for b, count := range h.counts {
+		seen += count
+		if seen >= rank {
+			if upper := time.Duration(math.Pow(bucketGrowth, float64(b))); upper < h.max {
+				return upper
+			}
+			return h.max
+		}
+	}
+	return h.max
+}
@@ -0,0 +1,83 @@
+package bench
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistogramPercentile(t *testing.T) {
+	tests := []struct {
+		name      string
+		latencies []time.Duration
+		p         float64
+		want      time.Duration
+	}{
+		{
+			name: "empty",
+			p:    50,
+			want: 0,
+		},
+		{
+			name:      "single latency",
+			latencies: []time.Duration{3 * time.Millisecond},
+			p:         50,
+			want:      3 * time.Millisecond,
+		},
+		{
+			name:      "median",
+			latencies: []time.Duration{time.Millisecond, 2 * time.Millisecond, 3 * time.Millisecond, 4 * time.Millisecond, 5 * time.Millisecond},
+			p:         50,
+			want:      3 * time.Millisecond,
+		},
+		{
+			name:      "lowest",
+			latencies: []time.Duration{time.Millisecond, 2 * time.Millisecond, time.Second},
+			p:         0,
+			want:      time.Millisecond,
+		},
+		{
+			name:      "maximum is exact",
+			latencies: []time.Duration{time.Millisecond, 1234567 * time.Microsecond},
+			p:         100,
+			want:      1234567 * time.Microsecond,
+		},
+		{
+			name:      "outlier in the tail",
+			latencies: append(repeat(10*time.Millisecond, 99), time.Minute),
+			p:         99,
+			want:      10 * time.Millisecond,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var h histogram
+			for _, d := range tt.latencies {
+				h.record(d)
+			}
+			got := h.percentile(tt.p)
+			// Within the 1% precision of the buckets
+			if got < tt.want || float64(got) > float64(tt.want)*bucketGrowth {
+				t.Errorf("percentile(%v) = %v, want %v within 1%%", tt.p, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHistogramSize(t *testing.T) {
+	var h histogram
+	for i := 0; i < 100000; i++ {
+		h.record(time.Duration(i) * time.Millisecond)
+	}
+	h.record(time.Hour)
+	if len(h.counts) > 3000 {
+		t.Errorf("histogram has %d buckets, want at most 3000", len(h.counts))
+	}
+}
+
+func repeat(d time.Duration, n int) []time.Duration {
+	out := make([]time.Duration, n)
+	for i := range out {
+		out[i] = d
+	}
+	return out
+}
@@ -0,0 +1,80 @@
+package bench
+
+import (
+	"encoding/json"
+	"fmt"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// syntheticReviews returns a small mix of Pod CREATE reviews, some of which
+// should trip the example policies (privileged, hostPath) and some of which
+// should not.
+func syntheticReviews() ([][]byte, error) {
+	privileged := true
+	pods := []*corev1.Pod{
+		newPod("bench-plain", corev1.Container{Name: "app", Image: "nginx"}),
+		newPod("bench-privileged", corev1.Container{
+			Name:            "app",
+			Image:           "nginx",
+			SecurityContext: &corev1.SecurityContext{Privileged: &privileged},
+		}),
+	}
+
+	hostMount := newPod("bench-hostpath", corev1.Container{Name: "app", Image: "nginx"})
+	hostMount.Spec.Volumes = []corev1.Volume{{
+		Name:         "host",
+		VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/"}},
+	}}
+	pods = append(pods, hostMount)
+
+	var res [][]byte
+	for i, pod := range pods {
+		raw, err := json.Marshal(pod)
+		if err != nil {
+			return nil, err
+		}
+
+		review := admissionv1.AdmissionReview{
+			TypeMeta: metav1.TypeMeta{
+				Kind:       "AdmissionReview",
+				APIVersion: "admission.k8s.io/v1",
+			},
+			Request: &admissionv1.AdmissionRequest{
+				UID:       types.UID(fmt.Sprintf("bench-%d", i)),
+				Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+				Resource:  metav1.GroupVersionResource{Version: "v1", Resource: "pods"},
+				Name:      pod.Name,
+				Namespace: pod.Namespace,
+				Operation: admissionv1.Create,
+				UserInfo:  authenticationv1.UserInfo{Username: "kubeenforcer-bench"},
+				Object:    runtime.RawExtension{Raw: raw},
+			},
+		}
+
+		data, err := json.Marshal(review)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, data)
+	}
+	return res, nil
+}
+
+func newPod(name string, container corev1.Container) *corev1.Pod {
+	return &corev1.Pod{
+		TypeMeta: metav1.TypeMeta{Kind: "Pod", APIVersion: "v1"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{container},
+		},
+	}
+}
@@ -0,0 +1,150 @@
+package certexpiry
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+
+	"github.com/kubescape/kubeenforcer/pkg/alertmanager"
+	"github.com/kubescape/kubeenforcer/pkg/metrics"
+)
+
+var logger klog.Logger = klog.LoggerWithName(klog.Background(), "certexpiry")
+
+var certExpirySeconds = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: metrics.Namespace,
+	Name:      "cert_expiry_seconds",
+	Help:      "Seconds until the certificate expires. Negative once expired.",
+}, []string{"cert", "file"})
+
+func init() {
+	metrics.Registry.MustRegister(certExpirySeconds)
+}
+
+const (
+	checkInterval = time.Minute
+
+	// realertInterval is how often an expiring certificate is alerted on
+	// again while it has not been rotated.
+	realertInterval = 24 * time.Hour
+)
+
+// Certificate is a PEM certificate file to monitor.
+type Certificate struct {
+	// Name identifies the certificate's purpose, e.g. serving.
+	Name string
+	File string
+}
+
+// Monitor exports the expiry of certificates as metrics and alerts once they
+// are about to expire.
+type Monitor struct {
+	certs   []Certificate
+	window  time.Duration
+	alerter *alertmanager.AlertManager
+
+	alerted map[Certificate]time.Time
+}
+
+// New creates a Monitor alerting through alerter, which may be nil, once a
+// certificate expires within window.
+func New(certs []Certificate, window time.Duration, alerter *alertmanager.AlertManager) *Monitor {
+	return &Monitor{
+		certs:   certs,
+		window:  window,
+		alerter: alerter,
+		alerted: map[Certificate]time.Time{},
+	}
+}
+
+// Run checks the certificates every minute until ctx is cancelled.
+func (m *Monitor) Run(ctx context.Context) error {
+	logger.Info("starting certificate expiry monitor", "certificates", len(m.certs))
+	defer logger.Info("stopped certificate expiry monitor")
+
+	wait.UntilWithContext(ctx, func(ctx context.Context) { m.check() }, checkInterval)
+	return nil
+}
+
+// Status is the expiry of a monitored certificate.
+type Status struct {
+	Certificate
+	NotAfter time.Time
+	// Expiring is set once the certificate expires within the alert window.
+	Expiring bool
+	// Error is set if the certificate could not be read.
+	Error string
+}
+
+// Status reads the expiry of every monitored certificate.
+func (m *Monitor) Status() []Status {
+	statuses := make([]Status, len(m.certs))
+	for i, cert := range m.certs {
+		statuses[i].Certificate = cert
+		notAfter, err := expiry(cert.File)
+		if err != nil {
+			statuses[i].Error = err.Error()
+			continue
+		}
+		statuses[i].NotAfter = notAfter
+		statuses[i].Expiring = time.Until(notAfter) < m.window
+	}
+	return statuses
+}
+
+func (m *Monitor) check() {
+	for _, cert := range m.certs {
+		notAfter, err := expiry(cert.File)
+		if err != nil {
+			logger.Error(err, "failed to read certificate", "cert", cert.Name, "file", cert.File)
+			continue
+		}
+
+		remaining := time.Until(notAfter)
+		certExpirySeconds.WithLabelValues(cert.Name, cert.File).Set(remaining.Seconds())
+
+		if remaining >= m.window {
+			delete(m.alerted, cert)
+			continue
+		}
+		if time.Since(m.alerted[cert]) < realertInterval {
+			continue
+		}
+
+		logger.Info("certificate is about to expire", "cert", cert.Name, "file", cert.File, "notAfter", notAfter)
+		if m.alerter != nil {
+			m.alerter.Alert(&alertmanager.AlertInfo{
+				Name:        "Certificate expiring",
+				Severity:    "warning",
+				Resource:    "certificate",
+				Instance:    cert.Name,
+				Description: fmt.Sprintf("%s certificate %s expires at %s", cert.Name, cert.File, notAfter.UTC().Format(time.RFC3339)),
+			})
+		}
+		m.alerted[cert] = time.Now()
+	}
+}
+
+// expiry returns the NotAfter of the first certificate in file.
+func expiry(file string) (time.Time, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return time.Time{}, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return time.Time{}, fmt.Errorf("no PEM data found in %s", file)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return cert.NotAfter, nil
+}
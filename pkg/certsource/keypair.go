@@ -0,0 +1,55 @@
+package certsource
+
+import (
+	"crypto/tls"
+	"os"
+	"sync"
+	"time"
+)
+
+// KeyPair serves a certificate and key from files, reloading them when they
+// change, so listeners other than the webhook's pick up the certificates
+// written by a Source or mounted from a Secret.
+type KeyPair struct {
+	certFile, keyFile string
+
+	lock            sync.Mutex
+	certMod, keyMod time.Time
+	cert            *tls.Certificate
+}
+
+// NewKeyPair returns a KeyPair for certFile and keyFile, which are loaded
+// on the first handshake.
+func NewKeyPair(certFile, keyFile string) *KeyPair {
+	return &KeyPair{certFile: certFile, keyFile: keyFile}
+}
+
+// GetCertificate returns the current certificate, for tls.Config.
+func (k *KeyPair) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	certInfo, err := os.Stat(k.certFile)
+	if err != nil {
+		return nil, err
+	}
+	keyInfo, err := os.Stat(k.keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	if k.cert != nil && certInfo.ModTime().Equal(k.certMod) && keyInfo.ModTime().Equal(k.keyMod) {
+		return k.cert, nil
+	}
+	cert, err := tls.LoadX509KeyPair(k.certFile, k.keyFile)
+	if err != nil {
+		// Keep serving the previous certificate while the files are
+		// being replaced
+		if k.cert != nil {
+			logger.Error(err, "failed to reload certificate", "cert", k.certFile)
+			return k.cert, nil
+		}
+		return nil, err
+	}
+	k.cert, k.certMod, k.keyMod = &cert, certInfo.ModTime(), keyInfo.ModTime()
+	return k.cert, nil
+}
@@ -0,0 +1,101 @@
+package certsource
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"os"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Secret reads the serving certificate from a kubernetes.io/tls Secret
+// through the API and watches it for changes. Unlike a mounted Secret, an
+// update is picked up without waiting for the kubelet to sync the volume.
+type Secret struct {
+	client            kubernetes.Interface
+	namespace, name   string
+	certFile, keyFile string
+
+	ready chan struct{}
+}
+
+// NewSecret creates a Source for the Secret namespace/name.
+func NewSecret(client kubernetes.Interface, namespace, name, certFile, keyFile string) *Secret {
+	return &Secret{
+		client:    client,
+		namespace: namespace,
+		name:      name,
+		certFile:  certFile,
+		keyFile:   keyFile,
+		ready:     make(chan struct{}),
+	}
+}
+
+func (s *Secret) Ready() <-chan struct{} {
+	return s.ready
+}
+
+func (s *Secret) Run(ctx context.Context) error {
+	logger.Info("starting Secret certificate source", "namespace", s.namespace, "name", s.name)
+	defer logger.Info("stopped Secret certificate source")
+
+	// Only watch the one Secret, so RBAC can be limited to its name
+	factory := informers.NewSharedInformerFactoryWithOptions(s.client, 0,
+		informers.WithNamespace(s.namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", s.name).String()
+		}),
+	)
+
+	update := func(obj interface{}) {
+		secret, ok := obj.(*corev1.Secret)
+		if !ok {
+			return
+		}
+		if err := s.write(secret); err != nil {
+			logger.Error(err, "failed to update serving certificate from Secret", "namespace", s.namespace, "name", s.name)
+			return
+		}
+		select {
+		case <-s.ready:
+		default:
+			close(s.ready)
+		}
+	}
+	factory.Core().V1().Secrets().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    update,
+		UpdateFunc: func(_, obj interface{}) { update(obj) },
+	})
+
+	factory.Start(ctx.Done())
+	<-ctx.Done()
+	factory.Shutdown()
+	return nil
+}
+
+func (s *Secret) write(secret *corev1.Secret) error {
+	certPEM, keyPEM := secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey]
+	if _, err := tls.X509KeyPair(certPEM, keyPEM); err != nil {
+		return fmt.Errorf("invalid key pair: %w", err)
+	}
+
+	// Resyncs deliver the same Secret again, don't trigger a server restart
+	if current, err := os.ReadFile(s.certFile); err == nil && bytes.Equal(current, certPEM) {
+		if current, err := os.ReadFile(s.keyFile); err == nil && bytes.Equal(current, keyPEM) {
+			return nil
+		}
+	}
+
+	if err := writePEMKeyPair(s.certFile, s.keyFile, certPEM, keyPEM); err != nil {
+		return err
+	}
+	logger.Info("updated serving certificate from Secret", "namespace", s.namespace, "name", s.name, "resourceVersion", secret.ResourceVersion)
+	return nil
+}
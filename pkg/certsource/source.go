@@ -0,0 +1,67 @@
+package certsource
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+
+	"k8s.io/klog/v2"
+)
+
+var logger klog.Logger = klog.LoggerWithName(klog.Background(), "certsource")
+
+// Source keeps the serving certificate and key files up to date from an
+// external issuer. The webhook already reloads its certificate when the
+// files change, so a Source only has to write them.
+type Source interface {
+	// Run obtains and renews the certificate until ctx is cancelled.
+	Run(ctx context.Context) error
+
+	// Ready is closed once the certificate files were written for the
+	// first time.
+	Ready() <-chan struct{}
+}
+
+// writeKeyPair writes a DER certificate chain and PKCS#8 DER key as PEM
+// files. Each file is replaced atomically so a reload never sees a partial
+// write.
+func writeKeyPair(certFile, keyFile string, chain []*x509.Certificate, pkcs8Key []byte) error {
+	var certPEM []byte
+	for _, cert := range chain {
+		certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8Key})
+	return writePEMKeyPair(certFile, keyFile, certPEM, keyPEM)
+}
+
+// writePEMKeyPair writes PEM encoded certificate and key files.
+func writePEMKeyPair(certFile, keyFile string, certPEM, keyPEM []byte) error {
+	// Write the key first, the certificate change triggers the reload
+	if err := writeFileAtomic(keyFile, keyPEM, 0600); err != nil {
+		return err
+	}
+	return writeFileAtomic(certFile, certPEM, 0644)
+}
+
+func writeFileAtomic(file string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(file), "."+filepath.Base(file)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
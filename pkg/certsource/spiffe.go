@@ -0,0 +1,206 @@
+package certsource
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protowire"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// The SPIFFE Workload API is a protobuf gRPC service. Only FetchX509SVID is
+// needed, so its messages are encoded by hand instead of pulling in the
+// generated SPIFFE client and its dependencies.
+const fetchX509SVIDPath = "/SpiffeWorkloadAPI/FetchX509SVID"
+
+// SPIFFE obtains the serving certificate from a SPIRE agent via the SPIFFE
+// Workload API. The agent pushes a new X509-SVID before the current one
+// expires, which is written to the certificate files.
+type SPIFFE struct {
+	endpoint          string
+	certFile, keyFile string
+
+	ready chan struct{}
+}
+
+// NewSPIFFE creates a Source reading X509-SVIDs from the Workload API at
+// endpoint, e.g. unix:///run/spire/sockets/agent.sock.
+func NewSPIFFE(endpoint, certFile, keyFile string) *SPIFFE {
+	return &SPIFFE{
+		endpoint: endpoint,
+		certFile: certFile,
+		keyFile:  keyFile,
+		ready:    make(chan struct{}),
+	}
+}
+
+func (s *SPIFFE) Ready() <-chan struct{} {
+	return s.ready
+}
+
+func (s *SPIFFE) Run(ctx context.Context) error {
+	logger.Info("starting SPIFFE certificate source", "endpoint", s.endpoint)
+	defer logger.Info("stopped SPIFFE certificate source")
+
+	conn, err := grpc.DialContext(ctx, s.endpoint, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	backoff := wait.Backoff{Duration: time.Second, Factor: 2, Jitter: 0.1, Steps: 8, Cap: time.Minute}
+	for ctx.Err() == nil {
+		err := s.watch(ctx, conn)
+		if ctx.Err() != nil {
+			break
+		}
+
+		delay := backoff.Step()
+		logger.Error(err, "workload API stream closed, reconnecting", "after", delay)
+		select {
+		case <-ctx.Done():
+		case <-time.After(delay):
+		}
+	}
+	return nil
+}
+
+func (s *SPIFFE) watch(ctx context.Context, conn *grpc.ClientConn) error {
+	// The agent rejects requests without this header
+	ctx = metadata.AppendToOutgoingContext(ctx, "workload.spiffe.io", "true")
+
+	stream, err := conn.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true}, fetchX509SVIDPath, grpc.ForceCodec(rawCodec{}))
+	if err != nil {
+		return err
+	}
+	// X509SVIDRequest has no fields
+	request := []byte{}
+	if err := stream.SendMsg(&request); err != nil {
+		return err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return err
+	}
+
+	for {
+		var response []byte
+		if err := stream.RecvMsg(&response); err != nil {
+			return err
+		}
+
+		id, chain, key, err := parseX509SVIDResponse(response)
+		if err != nil {
+			return err
+		}
+		if err := writeKeyPair(s.certFile, s.keyFile, chain, key); err != nil {
+			return err
+		}
+		logger.Info("received X509-SVID", "id", id, "notAfter", chain[0].NotAfter)
+
+		select {
+		case <-s.ready:
+		default:
+			close(s.ready)
+		}
+	}
+}
+
+// parseX509SVIDResponse returns the first SVID of an X509SVIDResponse:
+//
+//	message X509SVIDResponse { repeated X509SVID svids = 1; ... }
+//	message X509SVID {
+//	  string spiffe_id = 1;
+//	  bytes x509_svid = 2;      // ASN.1 DER certificate chain
+//	  bytes x509_svid_key = 3;  // PKCS#8 DER private key
+//	  ...
+//	}
+func parseX509SVIDResponse(b []byte) (string, []*x509.Certificate, []byte, error) {
+	var svid []byte
+	err := consumeFields(b, func(num protowire.Number, value []byte) {
+		if num == 1 && svid == nil {
+			svid = value
+		}
+	})
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if svid == nil {
+		return "", nil, nil, errors.New("workload API returned no X509-SVID")
+	}
+
+	var id string
+	var certs, key []byte
+	err = consumeFields(svid, func(num protowire.Number, value []byte) {
+		switch num {
+		case 1:
+			id = string(value)
+		case 2:
+			certs = value
+		case 3:
+			key = value
+		}
+	})
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	chain, err := x509.ParseCertificates(certs)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if len(chain) == 0 || len(key) == 0 {
+		return "", nil, nil, fmt.Errorf("X509-SVID %s is missing its certificate or key", id)
+	}
+	return id, chain, key, nil
+}
+
+// consumeFields calls fn with every length-delimited field of a protobuf
+// message and skips all others.
+func consumeFields(b []byte, fn func(num protowire.Number, value []byte)) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		if typ == protowire.BytesType {
+			value, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			fn(num, value)
+			b = b[n:]
+			continue
+		}
+
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+// rawCodec passes already encoded protobuf messages through as *[]byte.
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	return *v.(*[]byte), nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	*v.(*[]byte) = append([]byte(nil), data...)
+	return nil
+}
+
+func (rawCodec) Name() string {
+	return "proto"
+}
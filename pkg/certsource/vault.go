@@ -0,0 +1,246 @@
+package certsource
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const minRenewInterval = 10 * time.Second
+
+// VaultOptions configures issuing the serving certificate from a Vault PKI
+// secrets engine.
+type VaultOptions struct {
+	// Address of the Vault server, e.g. https://vault.example.com:8200.
+	Address string
+	// CAFile verifies the Vault server. The system roots are used if empty.
+	CAFile string
+
+	// Mount is the path of the PKI secrets engine and Role the role
+	// certificates are issued from.
+	Mount string
+	Role  string
+
+	CommonName string
+	AltNames   []string
+	TTL        time.Duration
+
+	// Token authenticates with a static Vault token. If empty, Kubernetes
+	// auth is used with KubernetesRole and the service account token in
+	// JWTFile.
+	Token           string
+	KubernetesMount string
+	KubernetesRole  string
+	JWTFile         string
+}
+
+// Vault issues the serving certificate from a Vault PKI role and issues a
+// new one once two thirds of its lifetime have passed.
+type Vault struct {
+	opts              VaultOptions
+	certFile, keyFile string
+	client            *http.Client
+
+	ready chan struct{}
+}
+
+// NewVault creates a Source issuing certificates as configured in opts.
+func NewVault(opts VaultOptions, certFile, keyFile string) (*Vault, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if opts.CAFile != "" {
+		pem, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", opts.CAFile)
+		}
+	}
+	if opts.Token == "" && opts.KubernetesRole == "" {
+		return nil, errors.New("either a Vault token or a Kubernetes auth role is required")
+	}
+
+	return &Vault{
+		opts:     opts,
+		certFile: certFile,
+		keyFile:  keyFile,
+		client: &http.Client{
+			Timeout:   30 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		},
+		ready: make(chan struct{}),
+	}, nil
+}
+
+func (v *Vault) Ready() <-chan struct{} {
+	return v.ready
+}
+
+func (v *Vault) Run(ctx context.Context) error {
+	logger.Info("starting Vault certificate source", "address", v.opts.Address, "role", v.opts.Role)
+	defer logger.Info("stopped Vault certificate source")
+
+	retry := 5 * time.Second
+	for {
+		var next time.Duration
+		cert, err := v.issue(ctx)
+		if err != nil {
+			logger.Error(err, "failed to issue certificate from Vault", "retryAfter", retry)
+			next = retry
+			if retry *= 2; retry > 5*time.Minute {
+				retry = 5 * time.Minute
+			}
+		} else {
+			retry = 5 * time.Second
+			lifetime := cert.NotAfter.Sub(cert.NotBefore)
+			next = time.Until(cert.NotBefore.Add(lifetime * 2 / 3))
+			if next < minRenewInterval {
+				// Guard against hammering Vault over clock skew or very
+				// short TTLs
+				next = minRenewInterval
+			}
+			logger.Info("issued certificate from Vault", "serial", cert.SerialNumber.String(), "notAfter", cert.NotAfter, "renewIn", next.Round(time.Second))
+
+			select {
+			case <-v.ready:
+			default:
+				close(v.ready)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(next):
+		}
+	}
+}
+
+// issue requests a new certificate and writes it to the certificate files.
+func (v *Vault) issue(ctx context.Context) (*x509.Certificate, error) {
+	token, err := v.token(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("authenticating: %w", err)
+	}
+
+	request := map[string]string{
+		"common_name":        v.opts.CommonName,
+		"alt_names":          strings.Join(v.opts.AltNames, ","),
+		"format":             "pem",
+		"private_key_format": "pkcs8",
+	}
+	if v.opts.TTL > 0 {
+		request["ttl"] = v.opts.TTL.String()
+	}
+
+	var response struct {
+		Data struct {
+			Certificate string   `json:"certificate"`
+			CAChain     []string `json:"ca_chain"`
+			PrivateKey  string   `json:"private_key"`
+		} `json:"data"`
+	}
+	path := fmt.Sprintf("/v1/%s/issue/%s", strings.Trim(v.opts.Mount, "/"), v.opts.Role)
+	if err := v.post(ctx, path, token, request, &response); err != nil {
+		return nil, err
+	}
+
+	chain, err := parsePEMCertificates(response.Data.Certificate + "\n" + strings.Join(response.Data.CAChain, "\n"))
+	if err != nil {
+		return nil, err
+	}
+	key, _ := pem.Decode([]byte(response.Data.PrivateKey))
+	if key == nil {
+		return nil, errors.New("Vault returned no private key")
+	}
+
+	if err := writeKeyPair(v.certFile, v.keyFile, chain, key.Bytes); err != nil {
+		return nil, err
+	}
+	return chain[0], nil
+}
+
+func (v *Vault) token(ctx context.Context) (string, error) {
+	if v.opts.Token != "" {
+		return v.opts.Token, nil
+	}
+
+	jwt, err := os.ReadFile(v.opts.JWTFile)
+	if err != nil {
+		return "", err
+	}
+
+	var response struct {
+		Auth struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}
+	path := fmt.Sprintf("/v1/auth/%s/login", strings.Trim(v.opts.KubernetesMount, "/"))
+	request := map[string]string{"role": v.opts.KubernetesRole, "jwt": strings.TrimSpace(string(jwt))}
+	if err := v.post(ctx, path, "", request, &response); err != nil {
+		return "", err
+	}
+	if response.Auth.ClientToken == "" {
+		return "", errors.New("Vault login returned no token")
+	}
+	return response.Auth.ClientToken, nil
+}
+
+func (v *Vault) post(ctx context.Context, path, token string, request, response interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(v.opts.Address, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("%s returned %s: %s", path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(resp.Body).Decode(response)
+}
+
+func parsePEMCertificates(data string) ([]*x509.Certificate, error) {
+	var chain []*x509.Certificate
+	rest := []byte(data)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, cert)
+	}
+	if len(chain) == 0 {
+		return nil, errors.New("Vault returned no certificate")
+	}
+	return chain, nil
+}
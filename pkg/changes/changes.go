@@ -0,0 +1,231 @@
+package changes
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"google.golang.org/protobuf/types/known/structpb"
+	"k8s.io/apiserver/pkg/cel/library"
+)
+
+// ignored are the paths that change on every update without being changed
+// by the requester.
+var ignored = map[string]bool{
+	"metadata.resourceVersion": true,
+	"metadata.managedFields":   true,
+	"metadata.generation":      true,
+}
+
+var enableOnce sync.Once
+
+// Enable declares changes.fields(object, oldObject), which returns the
+// sorted paths of the fields that differ between object and oldObject, e.g.
+// spec.template.spec.containers[0].image, and changes.touches(object,
+// oldObject, path), which returns whether the field at path, or a field
+// within or containing it, changed. Added and removed fields and list items
+// are reported at their own path rather than at their leaves. A null object
+// or oldObject counts as empty, so every top-level field is changed on
+// creation and deletion. It must be called before any expression is
+// compiled, since the functions are added to the Kubernetes CEL libraries.
+func Enable() {
+	enableOnce.Do(func() {
+		library.ExtensionLibs = append(library.ExtensionLibs, functions)
+	})
+}
+
+var functions = cel.Lib(changesLib{})
+
+type changesLib struct{}
+
+func (changesLib) CompileOptions() []cel.EnvOption {
+	return []cel.EnvOption{
+		cel.Function("changes.fields",
+			cel.Overload("changes_fields_dyn_dyn", []*cel.Type{cel.DynType, cel.DynType}, cel.ListType(cel.StringType),
+				cel.BinaryBinding(fields))),
+		cel.Function("changes.touches",
+			cel.Overload("changes_touches_dyn_dyn_string", []*cel.Type{cel.DynType, cel.DynType, cel.StringType}, cel.BoolType,
+				cel.FunctionBinding(touches))),
+	}
+}
+
+func (changesLib) ProgramOptions() []cel.ProgramOption {
+	return nil
+}
+
+func fields(object, oldObject ref.Val) ref.Val {
+	paths, err := changed(object, oldObject)
+	if err != nil {
+		return types.NewErr("changes.fields: %v", err)
+	}
+	return types.DefaultTypeAdapter.NativeToValue(paths)
+}
+
+func touches(args ...ref.Val) ref.Val {
+	paths, err := changed(args[0], args[1])
+	if err != nil {
+		return types.NewErr("changes.touches: %v", err)
+	}
+	path := string(args[2].(types.String))
+	for _, p := range paths {
+		if within(p, path) || within(path, p) {
+			return types.True
+		}
+	}
+	return types.False
+}
+
+// within returns whether path is prefix or a field within it.
+func within(path, prefix string) bool {
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	rest := path[len(prefix):]
+	return rest == "" || rest[0] == '.' || rest[0] == '['
+}
+
+// cacheSize bounds the memoized diffs. Expressions of a policy share the
+// objects they are evaluated against, so their diff is computed once per
+// request and policy.
+const cacheSize = 64
+
+type entry struct {
+	// object and oldObject are kept so their maps are not reused by other
+	// objects while they key the cache
+	object, oldObject interface{}
+	paths             []string
+}
+
+var (
+	cacheLock sync.Mutex
+	cache     = map[[2]uintptr]*entry{}
+)
+
+// changed returns the paths of the fields that differ between object and
+// oldObject.
+func changed(object, oldObject ref.Val) ([]string, error) {
+	o, err := native(object)
+	if err != nil {
+		return nil, err
+	}
+	old, err := native(oldObject)
+	if err != nil {
+		return nil, err
+	}
+
+	key, cacheable := cacheKey(o, old)
+	if cacheable {
+		cacheLock.Lock()
+		e, ok := cache[key]
+		cacheLock.Unlock()
+		if ok {
+			return e.paths, nil
+		}
+	}
+
+	paths := []string{}
+	diff("", o, old, &paths)
+	sort.Strings(paths)
+
+	if cacheable {
+		cacheLock.Lock()
+		if len(cache) >= cacheSize {
+			cache = map[[2]uintptr]*entry{}
+		}
+		cache[key] = &entry{object: o, oldObject: old, paths: paths}
+		cacheLock.Unlock()
+	}
+	return paths, nil
+}
+
+// native returns the JSON value of v: a map, list, string, number, bool or
+// nil.
+func native(v ref.Val) (interface{}, error) {
+	if v == types.NullValue {
+		return nil, nil
+	}
+	if m, ok := v.Value().(map[string]interface{}); ok {
+		return m, nil
+	}
+	pb, err := v.ConvertToNative(reflect.TypeOf(&structpb.Value{}))
+	if err != nil {
+		return nil, err
+	}
+	return pb.(*structpb.Value).AsInterface(), nil
+}
+
+// cacheKey keys the diff of two maps by their identity.
+func cacheKey(object, oldObject interface{}) ([2]uintptr, bool) {
+	var key [2]uintptr
+	for i, v := range []interface{}{object, oldObject} {
+		switch m := v.(type) {
+		case map[string]interface{}:
+			key[i] = reflect.ValueOf(m).Pointer()
+		case nil:
+		default:
+			return key, false
+		}
+	}
+	return key, true
+}
+
+func diff(path string, a, b interface{}, paths *[]string) {
+	if ignored[path] {
+		return
+	}
+	am, aIsMap := a.(map[string]interface{})
+	bm, bIsMap := b.(map[string]interface{})
+	if path == "" && (a == nil || b == nil) {
+		// Created or deleted objects count as empty
+		aIsMap, bIsMap = true, true
+	}
+	if aIsMap && bIsMap {
+		for k, v := range am {
+			if w, ok := bm[k]; ok {
+				diff(join(path, k), v, w, paths)
+			} else if !ignored[join(path, k)] {
+				*paths = append(*paths, join(path, k))
+			}
+		}
+		for k := range bm {
+			if _, ok := am[k]; !ok && !ignored[join(path, k)] {
+				*paths = append(*paths, join(path, k))
+			}
+		}
+		return
+	}
+
+	al, aIsList := a.([]interface{})
+	bl, bIsList := b.([]interface{})
+	if aIsList && bIsList {
+		for i := 0; i < len(al) || i < len(bl); i++ {
+			p := fmt.Sprintf("%s[%d]", path, i)
+			if i < len(al) && i < len(bl) {
+				diff(p, al[i], bl[i], paths)
+			} else {
+				*paths = append(*paths, p)
+			}
+		}
+		return
+	}
+
+	if !reflect.DeepEqual(a, b) {
+		*paths = append(*paths, path)
+	}
+}
+
+// join returns the path of field key of the map at path.
+func join(path, key string) string {
+	if strings.ContainsAny(key, ".[]'") {
+		return fmt.Sprintf("%s['%s']", path, strings.ReplaceAll(key, "'", `\'`))
+	}
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
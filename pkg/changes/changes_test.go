@@ -0,0 +1,164 @@
+package changes
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/google/cel-go/cel"
+	"sigs.k8s.io/yaml"
+)
+
+func parse(t *testing.T, s string) interface{} {
+	t.Helper()
+	if s == "" {
+		return nil
+	}
+	var v interface{}
+	if err := yaml.Unmarshal([]byte(s), &v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name      string
+		object    string
+		oldObject string
+		want      []string
+	}{
+		{
+			name:      "unchanged",
+			object:    `{metadata: {name: web}, spec: {replicas: 3}}`,
+			oldObject: `{metadata: {name: web}, spec: {replicas: 3}}`,
+			want:      []string{},
+		},
+		{
+			name:      "changed leaf",
+			object:    `{spec: {replicas: 5, paused: false}}`,
+			oldObject: `{spec: {replicas: 3, paused: false}}`,
+			want:      []string{"spec.replicas"},
+		},
+		{
+			name:      "added and removed fields at their own path",
+			object:    `{spec: {template: {metadata: {labels: {app: web}}}}}`,
+			oldObject: `{spec: {selector: {app: web}}}`,
+			want:      []string{"spec.selector", "spec.template"},
+		},
+		{
+			name:      "changed list item",
+			object:    `{spec: {containers: [{name: app, image: app:2}, {name: proxy, image: proxy:1}]}}`,
+			oldObject: `{spec: {containers: [{name: app, image: app:1}, {name: proxy, image: proxy:1}]}}`,
+			want:      []string{"spec.containers[0].image"},
+		},
+		{
+			name:      "added and removed list items",
+			object:    `{spec: {args: [a, b, c]}, status: {conditions: []}}`,
+			oldObject: `{spec: {args: [a]}, status: {conditions: [{type: Ready}]}}`,
+			want:      []string{"spec.args[1]", "spec.args[2]", "status.conditions[0]"},
+		},
+		{
+			name:      "changed type",
+			object:    `{spec: {ports: "80"}}`,
+			oldObject: `{spec: {ports: [80]}}`,
+			want:      []string{"spec.ports"},
+		},
+		{
+			name:      "fields changed by the API server are ignored",
+			object:    `{metadata: {resourceVersion: "2", generation: 2, managedFields: [{manager: kubectl}]}}`,
+			oldObject: `{metadata: {resourceVersion: "1", generation: 1}}`,
+			want:      []string{},
+		},
+		{
+			name:      "keys with separators are quoted",
+			object:    `{metadata: {labels: {app.kubernetes.io/name: web, "it's": x}}}`,
+			oldObject: `{metadata: {labels: {}}}`,
+			want:      []string{`metadata.labels['app.kubernetes.io/name']`, `metadata.labels['it\'s']`},
+		},
+		{
+			name:   "creation changes every top-level field",
+			object: `{metadata: {name: web}, spec: {replicas: 3}}`,
+			want:   []string{"metadata", "spec"},
+		},
+		{
+			name:      "deletion changes every top-level field",
+			oldObject: `{metadata: {name: web}}`,
+			want:      []string{"metadata"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []string{}
+			diff("", parse(t, tt.object), parse(t, tt.oldObject), &got)
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diff() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithin(t *testing.T) {
+	tests := []struct {
+		path   string
+		prefix string
+		want   bool
+	}{
+		{path: "spec.replicas", prefix: "spec.replicas", want: true},
+		{path: "spec.template.spec", prefix: "spec", want: true},
+		{path: "spec.containers[0].image", prefix: "spec.containers", want: true},
+		{path: "metadata.labels['app.kubernetes.io/name']", prefix: "metadata.labels", want: true},
+		{path: "spec.replicasMax", prefix: "spec.replicas"},
+		{path: "spec", prefix: "spec.replicas"},
+		{path: "status.replicas", prefix: "spec"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path+" in "+tt.prefix, func(t *testing.T) {
+			if got := within(tt.path, tt.prefix); got != tt.want {
+				t.Errorf("within(%q, %q) = %v, want %v", tt.path, tt.prefix, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTouches(t *testing.T) {
+	env, err := cel.NewEnv(functions, cel.Variable("object", cel.DynType), cel.Variable("oldObject", cel.DynType))
+	if err != nil {
+		t.Fatal(err)
+	}
+	object := parse(t, `{spec: {template: {spec: {containers: [{name: app, image: app:2}]}}, replicas: 3}}`)
+	oldObject := parse(t, `{spec: {template: {spec: {containers: [{name: app, image: app:1}]}}, replicas: 3}}`)
+
+	tests := []struct {
+		expression string
+		want       bool
+	}{
+		{expression: `changes.touches(object, oldObject, "spec.template.spec.containers[0].image")`, want: true},
+		{expression: `changes.touches(object, oldObject, "spec.template")`, want: true},
+		{expression: `changes.touches(object, oldObject, "spec.template.spec.containers[0].image.tag")`, want: true},
+		{expression: `changes.touches(object, oldObject, "spec.replicas")`},
+		{expression: `changes.touches(object, oldObject, "spec.temp")`},
+		{expression: `changes.fields(object, oldObject) == ["spec.template.spec.containers[0].image"]`, want: true},
+		{expression: `changes.fields(object, null) == ["spec"]`, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			ast, issues := env.Compile(tt.expression)
+			if issues.Err() != nil {
+				t.Fatal(issues.Err())
+			}
+			program, err := env.Program(ast)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out, _, err := program.Eval(map[string]interface{}{"object": object, "oldObject": oldObject})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out.Value() != tt.want {
+				t.Errorf("%s = %v, want %v", tt.expression, out.Value(), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,223 @@
+package chat
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/kubescape/kubeenforcer/pkg/alertmanager"
+)
+
+var logger klog.Logger = klog.LoggerWithName(klog.Background(), "chat")
+
+const (
+	bufferSize  = 1000
+	sendTimeout = 10 * time.Second
+)
+
+// Flavor is a chat server whose incoming webhooks take Slack-style messages
+// with attachments.
+type Flavor string
+
+const (
+	Mattermost Flavor = "Mattermost"
+	RocketChat Flavor = "Rocket.Chat"
+)
+
+// DefaultTemplate is the template of the message text if none is
+// configured.
+const DefaultTemplate = `**{{ .Name }}**: {{ .Description }}`
+
+// Alert is what message templates are executed with.
+type Alert struct {
+	alertmanager.AlertInfo
+	// Fingerprint identifies the violation, as in deduplication.
+	Fingerprint string
+}
+
+// LoadTemplate parses the Go text/template in file, or DefaultTemplate if
+// file is empty.
+func LoadTemplate(file string) (*template.Template, error) {
+	text := DefaultTemplate
+	if file != "" {
+		raw, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		text = string(raw)
+	}
+	tmpl, err := template.New("message").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid message template: %w", err)
+	}
+	// Catches references to unknown fields
+	if err := tmpl.Execute(io.Discard, &Alert{}); err != nil {
+		return nil, fmt.Errorf("invalid message template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// Notifier posts alerts to a Mattermost or Rocket.Chat channel through an
+// incoming webhook. It is an alertmanager.Forwarder, so it gets the alerts
+// sent to alertmanager after the same deduplication; alerts are buffered and
+// dropped if the server cannot keep up.
+type Notifier struct {
+	flavor   Flavor
+	url      string
+	template *template.Template
+	client   *http.Client
+
+	buffer  chan *Alert
+	dropped atomic.Int64
+}
+
+// New creates a Notifier posting to the incoming webhook at url of a chat
+// server of flavor, the message text rendered by tmpl.
+func New(flavor Flavor, url string, tmpl *template.Template) *Notifier {
+	return &Notifier{
+		flavor:   flavor,
+		url:      url,
+		template: tmpl,
+		client:   &http.Client{Timeout: sendTimeout},
+		buffer:   make(chan *Alert, bufferSize),
+	}
+}
+
+func (n *Notifier) Forward(alertInfo *alertmanager.AlertInfo, fingerprint string) {
+	select {
+	case n.buffer <- &Alert{AlertInfo: *alertInfo, Fingerprint: fingerprint}:
+	default:
+		n.dropped.Add(1)
+	}
+}
+
+// Run posts buffered alerts until ctx is cancelled.
+func (n *Notifier) Run(ctx context.Context) error {
+	logger.Info("posting alerts", "server", n.flavor)
+	defer logger.Info("stopped posting alerts", "server", n.flavor)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case alert := <-n.buffer:
+			if dropped := n.dropped.Swap(0); dropped > 0 {
+				logger.Info("dropped alerts because the chat server could not keep up", "server", n.flavor, "count", dropped)
+			}
+			if err := n.post(ctx, alert); err != nil && ctx.Err() == nil {
+				// Not the webhook URL, which holds credentials
+				logger.Error(err, "failed to post alert", "server", n.flavor, "alert", alert.Name, "decision", alert.DecisionID)
+			}
+		}
+	}
+}
+
+// field is a field of a message attachment.
+type field struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short"`
+}
+
+type attachment struct {
+	Fallback string  `json:"fallback,omitempty"`
+	Color    string  `json:"color"`
+	Fields   []field `json:"fields"`
+	Footer   string  `json:"footer,omitempty"`
+}
+
+func (n *Notifier) post(ctx context.Context, alert *Alert) error {
+	var text strings.Builder
+	if err := n.template.Execute(&text, alert); err != nil {
+		return fmt.Errorf("failed to render the message template: %w", err)
+	}
+	body, err := json.Marshal(n.message(text.String(), alert))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		// The error of the client quotes the URL
+		if urlErr, ok := err.(*url.Error); ok {
+			return urlErr.Err
+		}
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s returned %s", n.flavor, resp.Status)
+	}
+	return nil
+}
+
+// message returns the webhook payload of alert: text, and an attachment
+// colored by severity listing the resource, namespace, user, policy,
+// workload and GitOps application. Mattermost names the sender username, Rocket.Chat alias.
+func (n *Notifier) message(text string, alert *Alert) map[string]interface{} {
+	var fields []field
+	for _, f := range []field{
+		{"Severity", alert.Severity, true},
+		{"Namespace", alert.Namespace, true},
+		{"Resource", alert.Resource, true},
+		{"Instance", alert.Instance, true},
+		{"Requested by", alert.RequestingUser, true},
+		{"Policy", alert.Policy, true},
+		{"Workload", alert.Workload, true},
+		{"GitOps application", alert.GitOpsApplication, true},
+		{"Revision", alert.GitOpsRevision, true},
+		{"Remediation", alert.Remediation, false},
+	} {
+		if f.Value != "" {
+			fields = append(fields, f)
+		}
+	}
+	footer := "kubeenforcer"
+	if alert.DecisionID != "" {
+		footer += " decision " + alert.DecisionID
+	}
+
+	message := map[string]interface{}{
+		"text": text,
+		"attachments": []attachment{{
+			Fallback: alert.Name + ": " + alert.Description,
+			Color:    color(alert.Severity),
+			Fields:   fields,
+			Footer:   footer,
+		}},
+	}
+	if n.flavor == RocketChat {
+		message["alias"] = "kubeenforcer"
+	} else {
+		message["username"] = "kubeenforcer"
+	}
+	return message
+}
+
+// color returns the attachment color of severity. Policy violations have
+// the status reason of the request as severity, and are shown red.
+func color(severity string) string {
+	switch severity {
+	case "info":
+		return "#439fe0"
+	case "warning":
+		return "#e8a317"
+	}
+	return "#d00000"
+}
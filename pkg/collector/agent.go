@@ -0,0 +1,164 @@
+package collector
+
+import (
+	"context"
+	"crypto/tls"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+
+	"github.com/kubescape/kubeenforcer/pkg/decision"
+)
+
+var logger klog.Logger = klog.LoggerWithName(klog.Background(), "collector")
+
+const (
+	agentBufferSize     = 10000
+	agentBatchSize      = 500
+	agentFlushInterval  = time.Second
+	agentStatusInterval = time.Minute
+)
+
+// Agent streams the decisions of this cluster to a central collector. It is
+// a decision.Sink; decisions are buffered and dropped if the collector cannot
+// keep up so admission is never slowed down.
+type Agent struct {
+	addr      string
+	cluster   string
+	tlsConfig *tls.Config
+	policies  func() (*PolicyStatus, error)
+
+	buffer  chan *decision.Decision
+	dropped atomic.Int64
+}
+
+// NewAgent creates an Agent reporting as cluster to the collector at addr.
+// policies is called periodically to report the loaded policies and may be
+// nil.
+func NewAgent(addr, cluster string, tlsConfig *tls.Config, policies func() (*PolicyStatus, error)) *Agent {
+	return &Agent{
+		addr:      addr,
+		cluster:   cluster,
+		tlsConfig: tlsConfig,
+		policies:  policies,
+		buffer:    make(chan *decision.Decision, agentBufferSize),
+	}
+}
+
+func (a *Agent) Record(d *decision.Decision) {
+	select {
+	case a.buffer <- d:
+	default:
+		a.dropped.Add(1)
+	}
+}
+
+// Run connects to the collector and streams decisions until ctx is
+// cancelled, reconnecting with backoff when the stream breaks.
+func (a *Agent) Run(ctx context.Context) error {
+	logger.Info("starting collector agent", "collector", a.addr, "cluster", a.cluster)
+	defer logger.Info("stopped collector agent")
+
+	conn, err := grpc.DialContext(ctx, a.addr, grpc.WithTransportCredentials(credentials.NewTLS(a.tlsConfig)))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	newBackoff := func() wait.Backoff {
+		return wait.Backoff{Duration: time.Second, Factor: 2, Jitter: 0.1, Steps: 8, Cap: 2 * time.Minute}
+	}
+	backoff := newBackoff()
+	for ctx.Err() == nil {
+		started := time.Now()
+		err := a.stream(ctx, conn)
+		if ctx.Err() != nil {
+			break
+		}
+		if time.Since(started) > agentStatusInterval {
+			// The stream was healthy for a while, start over with short delays
+			backoff = newBackoff()
+		}
+
+		delay := backoff.Step()
+		logger.Error(err, "collector stream closed, reconnecting", "after", delay)
+		select {
+		case <-ctx.Done():
+		case <-time.After(delay):
+		}
+	}
+	return nil
+}
+
+func (a *Agent) stream(ctx context.Context, conn *grpc.ClientConn) error {
+	stream, err := openReportStream(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	if err := a.sendStatus(stream); err != nil {
+		return err
+	}
+
+	flush := time.NewTicker(agentFlushInterval)
+	defer flush.Stop()
+	status := time.NewTicker(agentStatusInterval)
+	defer status.Stop()
+
+	var batch []*decision.Decision
+	send := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if dropped := a.dropped.Swap(0); dropped > 0 {
+			logger.Info("dropped decisions because the collector could not keep up", "count", dropped)
+		}
+		err := stream.SendMsg(&Report{Cluster: a.cluster, Decisions: batch})
+		batch = nil
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			send()
+			stream.CloseSend()
+			return ctx.Err()
+		case d := <-a.buffer:
+			// The decision is shared with the other sinks
+			c := *d
+			c.Cluster = a.cluster
+			batch = append(batch, &c)
+			if len(batch) >= agentBatchSize {
+				if err := send(); err != nil {
+					return err
+				}
+			}
+		case <-flush.C:
+			if err := send(); err != nil {
+				return err
+			}
+		case <-status.C:
+			if err := a.sendStatus(stream); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (a *Agent) sendStatus(stream grpc.ClientStream) error {
+	if a.policies == nil {
+		return nil
+	}
+
+	status, err := a.policies()
+	if err != nil {
+		logger.Error(err, "failed to collect policy status")
+		return nil
+	}
+	return stream.SendMsg(&Report{Cluster: a.cluster, Policies: status})
+}
@@ -0,0 +1,174 @@
+package collector
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/kubescape/kubeenforcer/pkg/decision"
+)
+
+const recentDecisionsPerCluster = 1000
+
+// Collector receives decision streams from agents and keeps a fleet-wide
+// view of recent decisions and policy status per cluster.
+type Collector struct {
+	lock     sync.RWMutex
+	clusters map[string]*clusterState
+
+	// Decisions, if set, additionally receives every reported decision.
+	Decisions decision.Sink
+}
+
+type clusterState struct {
+	LastSeen  time.Time     `json:"lastSeen"`
+	Connected int           `json:"connectedAgents"`
+	Allowed   int64         `json:"allowed"`
+	Denied    int64         `json:"denied"`
+	Policies  *PolicyStatus `json:"policies,omitempty"`
+
+	recent []*decision.Decision
+}
+
+func NewCollector() *Collector {
+	return &Collector{clusters: map[string]*clusterState{}}
+}
+
+// Serve accepts agent streams on l until ctx is cancelled.
+func (c *Collector) Serve(ctx context.Context, l net.Listener, tlsConfig *tls.Config) error {
+	server := grpc.NewServer(grpc.Creds(credentials.NewTLS(tlsConfig)))
+	server.RegisterService(&serviceDesc, c)
+	healthServer := registerHealth(server)
+
+	go func() {
+		<-ctx.Done()
+		healthServer.Shutdown()
+		server.Stop()
+	}()
+
+	if err := server.Serve(l); err != nil && ctx.Err() == nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+func (c *Collector) report(stream grpc.ServerStream) error {
+	var cluster string
+	received := 0
+
+	defer func() {
+		if cluster == "" {
+			return
+		}
+		c.lock.Lock()
+		c.clusters[cluster].Connected--
+		c.lock.Unlock()
+		logger.Info("agent disconnected", "cluster", cluster)
+	}()
+
+	for {
+		var report Report
+		err := stream.RecvMsg(&report)
+		if errors.Is(err, io.EOF) {
+			return stream.SendMsg(&Ack{Received: received})
+		} else if err != nil {
+			return err
+		}
+
+		if report.Cluster == "" {
+			return errors.New("report is missing the cluster name")
+		}
+
+		c.lock.Lock()
+		state, ok := c.clusters[report.Cluster]
+		if !ok {
+			state = &clusterState{}
+			c.clusters[report.Cluster] = state
+		}
+		if cluster == "" {
+			cluster = report.Cluster
+			state.Connected++
+			logger.Info("agent connected", "cluster", cluster)
+		}
+
+		state.LastSeen = time.Now()
+		if report.Policies != nil {
+			state.Policies = report.Policies
+		}
+		for _, d := range report.Decisions {
+			d.Cluster = report.Cluster
+			if d.Allowed {
+				state.Allowed++
+			} else {
+				state.Denied++
+			}
+		}
+		state.recent = append(state.recent, report.Decisions...)
+		if over := len(state.recent) - recentDecisionsPerCluster; over > 0 {
+			state.recent = append([]*decision.Decision(nil), state.recent[over:]...)
+		}
+		c.lock.Unlock()
+
+		received += len(report.Decisions)
+		if c.Decisions != nil {
+			for _, d := range report.Decisions {
+				c.Decisions.Record(d)
+			}
+		}
+	}
+}
+
+// Handler serves the fleet view as JSON:
+//
+//	/clusters             status of every cluster that has reported
+//	/decisions?cluster=x  recent decisions, optionally of one cluster
+func (c *Collector) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/clusters", func(w http.ResponseWriter, r *http.Request) {
+		c.lock.RLock()
+		defer c.lock.RUnlock()
+		writeJSON(w, c.clusters)
+	})
+	mux.HandleFunc("/decisions", func(w http.ResponseWriter, r *http.Request) {
+		cluster := r.URL.Query().Get("cluster")
+
+		c.lock.RLock()
+		res := []*decision.Decision{}
+		for name, state := range c.clusters {
+			if cluster == "" || cluster == name {
+				res = append(res, state.recent...)
+			}
+		}
+		c.lock.RUnlock()
+
+		sort.Slice(res, func(i, j int) bool { return res[i].Time.After(res[j].Time) })
+		writeJSON(w, res)
+	})
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// registerHealth serves the grpc.health.v1 service on server, reporting the
+// collector service, and the server as a whole, as serving until shut down.
+func registerHealth(server *grpc.Server) *health.Server {
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus(serviceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(server, healthServer)
+	return healthServer
+}
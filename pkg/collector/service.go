@@ -0,0 +1,94 @@
+package collector
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"google.golang.org/grpc"
+
+	"github.com/kubescape/kubeenforcer/pkg/decision"
+	"github.com/kubescape/kubeenforcer/pkg/grpcjson"
+)
+
+// The collector protocol is a single client-streaming RPC using the JSON
+// codec.
+const (
+	serviceName = "kubeenforcer.collector.v1.Collector"
+	reportPath  = "/" + serviceName + "/Report"
+)
+
+// Report is sent by an agent. Each message carries the decisions made since
+// the previous message and, periodically, the agent's policy status.
+type Report struct {
+	Cluster   string               `json:"cluster"`
+	Decisions []*decision.Decision `json:"decisions,omitempty"`
+	Policies  *PolicyStatus        `json:"policies,omitempty"`
+}
+
+// PolicyStatus describes the policies loaded in an agent's cluster.
+type PolicyStatus struct {
+	Policies []string `json:"policies"`
+	Bindings []string `json:"bindings"`
+}
+
+// Ack is returned by the collector once an agent closes its stream.
+type Ack struct {
+	Received int `json:"received"`
+}
+
+// reportServer is implemented by the collector.
+type reportServer interface {
+	report(stream grpc.ServerStream) error
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*reportServer)(nil),
+	Streams: []grpc.StreamDesc{{
+		StreamName:    "Report",
+		ClientStreams: true,
+		Handler: func(srv interface{}, stream grpc.ServerStream) error {
+			return srv.(reportServer).report(stream)
+		},
+	}},
+}
+
+var reportStreamDesc = &grpc.StreamDesc{StreamName: "Report", ClientStreams: true}
+
+func openReportStream(ctx context.Context, conn *grpc.ClientConn) (grpc.ClientStream, error) {
+	return conn.NewStream(ctx, reportStreamDesc, reportPath, grpc.CallContentSubtype(grpcjson.Name))
+}
+
+// TLSConfig builds a mutual TLS configuration. For a server, clients must
+// present a certificate signed by caFile; for a client, the server
+// certificate is verified against caFile.
+func TLSConfig(certFile, keyFile, caFile string, server bool) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if server {
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	} else {
+		config.RootCAs = pool
+	}
+	return config, nil
+}
@@ -0,0 +1,284 @@
+package conflict
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"k8s.io/cel-admission-webhook/pkg/apis/admissionregistration.x-k8s.io/v1alpha1"
+)
+
+// Kind classifies a conflict.
+type Kind string
+
+const (
+	// DuplicateBinding is a policy bound twice for overlapping requests
+	// with the same actions and parameters, so it is evaluated twice.
+	DuplicateBinding Kind = "DuplicateBinding"
+
+	// DuplicatePolicy is two policies with the same checks bound for
+	// overlapping requests with the same actions.
+	DuplicatePolicy Kind = "DuplicatePolicy"
+
+	// ContradictoryActions is the same checks bound for overlapping requests
+	// with different actions, e.g. Deny and Audit. The strongest action wins,
+	// so the others have no effect.
+	ContradictoryActions Kind = "ContradictoryActions"
+)
+
+// Conflict is a pair of bindings whose match constraints overlap.
+type Conflict struct {
+	Kind     Kind     `json:"kind"`
+	Bindings []string `json:"bindings"`
+	Policies []string `json:"policies"`
+	Message  string   `json:"message"`
+}
+
+// Detect returns the conflicts between bindings of policies. Overlap is
+// approximated: excludeResourceRules are ignored, and selectors only rule out
+// an overlap when their requirements obviously contradict.
+func Detect(policies []*v1alpha1.ValidatingAdmissionPolicy, bindings []*v1alpha1.ValidatingAdmissionPolicyBinding) []Conflict {
+	byName := map[string]*v1alpha1.ValidatingAdmissionPolicy{}
+	for _, p := range policies {
+		byName[p.Name] = p
+	}
+
+	type bound struct {
+		binding *v1alpha1.ValidatingAdmissionPolicyBinding
+		policy  *v1alpha1.ValidatingAdmissionPolicy
+	}
+	var bounds []bound
+	for _, b := range bindings {
+		// Policies without match constraints match nothing
+		if p, ok := byName[b.Spec.PolicyName]; ok && p.Spec.MatchConstraints != nil {
+			bounds = append(bounds, bound{binding: b, policy: p})
+		}
+	}
+	sort.Slice(bounds, func(i, j int) bool { return bounds[i].binding.Name < bounds[j].binding.Name })
+
+	var conflicts []Conflict
+	for i := range bounds {
+		for j := i + 1; j < len(bounds); j++ {
+			a, b := bounds[i], bounds[j]
+			if !sameChecks(a.policy, b.policy) || !reflect.DeepEqual(a.binding.Spec.ParamRef, b.binding.Spec.ParamRef) {
+				continue
+			}
+			if !overlaps(a.policy, a.binding, b.policy, b.binding) {
+				continue
+			}
+
+			c := Conflict{
+				Bindings: []string{a.binding.Name, b.binding.Name},
+				Policies: []string{a.policy.Name, b.policy.Name},
+			}
+			actionsA, actionsB := actions(a.binding), actions(b.binding)
+			switch {
+			case actionsA != actionsB:
+				c.Kind = ContradictoryActions
+				c.Message = fmt.Sprintf("bindings %s (%s) and %s (%s) apply the same checks to overlapping requests with different actions", a.binding.Name, actionsA, b.binding.Name, actionsB)
+			case a.policy.Name == b.policy.Name:
+				c.Kind = DuplicateBinding
+				c.Message = fmt.Sprintf("bindings %s and %s bind policy %s to overlapping requests", a.binding.Name, b.binding.Name, a.policy.Name)
+			default:
+				c.Kind = DuplicatePolicy
+				c.Message = fmt.Sprintf("policies %s and %s apply the same checks to overlapping requests", a.policy.Name, b.policy.Name)
+			}
+			conflicts = append(conflicts, c)
+		}
+	}
+	return conflicts
+}
+
+// sameChecks returns whether two policies evaluate the same expressions
+// against the same parameters.
+func sameChecks(a, b *v1alpha1.ValidatingAdmissionPolicy) bool {
+	if a.Name == b.Name {
+		return true
+	}
+	return reflect.DeepEqual(a.Spec.Validations, b.Spec.Validations) &&
+		reflect.DeepEqual(a.Spec.MatchConditions, b.Spec.MatchConditions) &&
+		reflect.DeepEqual(a.Spec.ParamKind, b.Spec.ParamKind)
+}
+
+// actions returns the validation actions of binding in a canonical form.
+func actions(binding *v1alpha1.ValidatingAdmissionPolicyBinding) string {
+	names := make([]string, 0, len(binding.Spec.ValidationActions))
+	seen := map[v1alpha1.ValidationAction]bool{}
+	for _, a := range binding.Spec.ValidationActions {
+		if !seen[a] {
+			seen[a] = true
+			names = append(names, string(a))
+		}
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+// overlaps returns whether some request could be matched by both bindings.
+func overlaps(policyA *v1alpha1.ValidatingAdmissionPolicy, bindingA *v1alpha1.ValidatingAdmissionPolicyBinding, policyB *v1alpha1.ValidatingAdmissionPolicy, bindingB *v1alpha1.ValidatingAdmissionPolicyBinding) bool {
+	matchA := []*v1alpha1.MatchResources{policyA.Spec.MatchConstraints, bindingA.Spec.MatchResources}
+	matchB := []*v1alpha1.MatchResources{policyB.Spec.MatchConstraints, bindingB.Spec.MatchResources}
+
+	for _, a := range matchA {
+		for _, b := range matchB {
+			if a == nil || b == nil {
+				continue
+			}
+			if !rulesOverlap(a.ResourceRules, b.ResourceRules) {
+				return false
+			}
+		}
+	}
+
+	var namespaceA, namespaceB, objectA, objectB []metav1.LabelSelectorRequirement
+	for _, m := range matchA {
+		if m != nil {
+			namespaceA = append(namespaceA, requirements(m.NamespaceSelector)...)
+			objectA = append(objectA, requirements(m.ObjectSelector)...)
+		}
+	}
+	for _, m := range matchB {
+		if m != nil {
+			namespaceB = append(namespaceB, requirements(m.NamespaceSelector)...)
+			objectB = append(objectB, requirements(m.ObjectSelector)...)
+		}
+	}
+	return !contradicts(namespaceA, namespaceB) && !contradicts(objectA, objectB)
+}
+
+// rulesOverlap returns whether any rule of a overlaps any rule of b. An empty
+// list matches everything, as in binding matchResources.
+func rulesOverlap(a, b []v1alpha1.NamedRuleWithOperations) bool {
+	if len(a) == 0 || len(b) == 0 {
+		return true
+	}
+	for _, ra := range a {
+		for _, rb := range b {
+			if ruleOverlaps(ra, rb) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func ruleOverlaps(a, b v1alpha1.NamedRuleWithOperations) bool {
+	operations := func(ops []v1alpha1.OperationType) []string {
+		result := make([]string, len(ops))
+		for i, op := range ops {
+			result[i] = string(op)
+		}
+		return result
+	}
+	if !intersects(operations(a.Operations), operations(b.Operations)) ||
+		!intersects(a.APIGroups, b.APIGroups) ||
+		!intersects(a.APIVersions, b.APIVersions) {
+		return false
+	}
+	// Empty resourceNames match every name
+	if len(a.ResourceNames) > 0 && len(b.ResourceNames) > 0 && !intersects(a.ResourceNames, b.ResourceNames) {
+		return false
+	}
+	for _, ra := range a.Resources {
+		for _, rb := range b.Resources {
+			if resourcesOverlap(ra, rb) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// intersects returns whether a and b share a value, where * matches any.
+func intersects(a, b []string) bool {
+	for _, va := range a {
+		for _, vb := range b {
+			if va == "*" || vb == "*" || va == vb {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// resourcesOverlap compares resource/subresource patterns such as pods,
+// pods/* and */scale. A lone * matches all resources but no subresources.
+func resourcesOverlap(a, b string) bool {
+	partsA, partsB := strings.Split(a, "/"), strings.Split(b, "/")
+	if len(partsA) != len(partsB) {
+		return false
+	}
+	for i := range partsA {
+		if partsA[i] != "*" && partsB[i] != "*" && partsA[i] != partsB[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// requirements returns the requirements of selector, including its
+// matchLabels.
+func requirements(selector *metav1.LabelSelector) []metav1.LabelSelectorRequirement {
+	if selector == nil {
+		return nil
+	}
+	result := append([]metav1.LabelSelectorRequirement(nil), selector.MatchExpressions...)
+	for key, value := range selector.MatchLabels {
+		result = append(result, metav1.LabelSelectorRequirement{Key: key, Operator: metav1.LabelSelectorOpIn, Values: []string{value}})
+	}
+	return result
+}
+
+// contradicts returns whether no label set can satisfy both a requirement of
+// a and a requirement of b.
+func contradicts(a, b []metav1.LabelSelectorRequirement) bool {
+	for _, ra := range a {
+		for _, rb := range b {
+			if ra.Key == rb.Key && (excludes(ra, rb) || excludes(rb, ra)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// excludes returns whether requirement a rules out every value allowed by b.
+func excludes(a, b metav1.LabelSelectorRequirement) bool {
+	switch a.Operator {
+	case metav1.LabelSelectorOpIn:
+		switch b.Operator {
+		case metav1.LabelSelectorOpIn:
+			return !intersectsExactly(a.Values, b.Values)
+		case metav1.LabelSelectorOpNotIn:
+			return subset(a.Values, b.Values)
+		case metav1.LabelSelectorOpDoesNotExist:
+			return true
+		}
+	case metav1.LabelSelectorOpExists:
+		return b.Operator == metav1.LabelSelectorOpDoesNotExist
+	}
+	return false
+}
+
+func intersectsExactly(a, b []string) bool {
+	for _, va := range a {
+		for _, vb := range b {
+			if va == vb {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func subset(a, b []string) bool {
+	for _, va := range a {
+		if !intersectsExactly([]string{va}, b) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,156 @@
+package conflict
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+
+	informers "k8s.io/cel-admission-webhook/pkg/generated/informers/externalversions/admissionregistration.x-k8s.io/v1alpha1"
+	listers "k8s.io/cel-admission-webhook/pkg/generated/listers/admissionregistration.x-k8s.io/v1alpha1"
+
+	"github.com/kubescape/kubeenforcer/pkg/metrics"
+)
+
+var logger klog.Logger = klog.LoggerWithName(klog.Background(), "conflict")
+
+var policyConflicts = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: metrics.Namespace,
+	Name:      "policy_conflicts",
+	Help:      "Number of conflicting binding pairs among the loaded policies, by kind.",
+}, []string{"kind"})
+
+func init() {
+	metrics.Registry.MustRegister(policyConflicts)
+}
+
+const queueKey = "conflicts"
+
+// Monitor detects conflicts whenever policies or bindings change, logs new
+// ones and exports them as metrics. It serves the current conflicts as JSON.
+type Monitor struct {
+	policies  listers.ValidatingAdmissionPolicyLister
+	bindings  listers.ValidatingAdmissionPolicyBindingLister
+	hasSynced []cache.InformerSynced
+	queue     workqueue.RateLimitingInterface
+
+	lock      sync.RWMutex
+	conflicts []Conflict
+}
+
+// NewMonitor creates a Monitor for the policies and bindings watched by the
+// given informers.
+func NewMonitor(policyInformer informers.ValidatingAdmissionPolicyInformer, bindingInformer informers.ValidatingAdmissionPolicyBindingInformer) *Monitor {
+	m := &Monitor{
+		policies: policyInformer.Lister(),
+		bindings: bindingInformer.Lister(),
+		hasSynced: []cache.InformerSynced{
+			policyInformer.Informer().HasSynced,
+			bindingInformer.Informer().HasSynced,
+		},
+		queue: workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+	}
+
+	// Changes usually come in bursts, e.g. when applying a bundle, so
+	// detection is delayed until they settle
+	enqueue := func(interface{}) { m.queue.AddAfter(queueKey, time.Second) }
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc:    enqueue,
+		UpdateFunc: func(_, _ interface{}) { enqueue(nil) },
+		DeleteFunc: enqueue,
+	}
+	policyInformer.Informer().AddEventHandler(handler)
+	bindingInformer.Informer().AddEventHandler(handler)
+
+	return m
+}
+
+// Run detects conflicts on policy and binding changes until ctx is
+// cancelled.
+func (m *Monitor) Run(ctx context.Context) error {
+	defer m.queue.ShutDown()
+
+	logger.Info("starting policy conflict monitor")
+	defer logger.Info("stopped policy conflict monitor")
+
+	if !cache.WaitForCacheSync(ctx.Done(), m.hasSynced...) {
+		return ctx.Err()
+	}
+
+	go func() {
+		<-ctx.Done()
+		m.queue.ShutDown()
+	}()
+
+	for m.processNext() {
+	}
+	return nil
+}
+
+func (m *Monitor) processNext() bool {
+	key, shutdown := m.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer m.queue.Done(key)
+
+	if err := m.detect(); err != nil {
+		logger.Error(err, "failed to detect policy conflicts")
+		m.queue.AddRateLimited(key)
+		return true
+	}
+	m.queue.Forget(key)
+	return true
+}
+
+func (m *Monitor) detect() error {
+	policies, err := m.policies.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	bindings, err := m.bindings.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	conflicts := Detect(policies, bindings)
+
+	m.lock.Lock()
+	known := map[string]bool{}
+	for _, c := range m.conflicts {
+		known[c.Message] = true
+	}
+	m.conflicts = conflicts
+	m.lock.Unlock()
+
+	counts := map[Kind]int{DuplicateBinding: 0, DuplicatePolicy: 0, ContradictoryActions: 0}
+	for _, c := range conflicts {
+		counts[c.Kind]++
+		if !known[c.Message] {
+			logger.Info("policy conflict detected", "kind", c.Kind, "bindings", c.Bindings, "message", c.Message)
+		}
+	}
+	for kind, count := range counts {
+		policyConflicts.WithLabelValues(string(kind)).Set(float64(count))
+	}
+	return nil
+}
+
+// Conflicts returns the conflicts found by the last detection.
+func (m *Monitor) Conflicts() []Conflict {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return append([]Conflict{}, m.conflicts...)
+}
+
+// ServeHTTP writes the current conflicts as a JSON list.
+func (m *Monitor) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(m.Conflicts())
+}
@@ -0,0 +1,327 @@
+package coverage
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/klog/v2"
+
+	"k8s.io/cel-admission-webhook/pkg/apis/admissionregistration.x-k8s.io/v1alpha1"
+	informers "k8s.io/cel-admission-webhook/pkg/generated/informers/externalversions/admissionregistration.x-k8s.io/v1alpha1"
+	listers "k8s.io/cel-admission-webhook/pkg/generated/listers/admissionregistration.x-k8s.io/v1alpha1"
+)
+
+var logger klog.Logger = klog.LoggerWithName(klog.Background(), "coverage")
+
+// connectSubresources are the subresources whose requests are admitted as
+// CONNECT.
+var connectSubresources = map[string]bool{"exec": true, "attach": true, "portforward": true, "proxy": true}
+
+// Entry is the coverage of an operation on a resource.
+type Entry struct {
+	Group      string `json:"group"`
+	Version    string `json:"version"`
+	Kind       string `json:"kind"`
+	Resource   string `json:"resource"`
+	Namespaced bool   `json:"namespaced"`
+	Operation  string `json:"operation"`
+	// Enforced are the policies denying requests of the entry, and Audited
+	// those only auditing or warning on them.
+	Enforced []string `json:"enforced,omitempty"`
+	Audited  []string `json:"audited,omitempty"`
+	// Partial is set if every enforcing policy only applies to some
+	// requests of the entry, by selectors, names or match conditions.
+	Partial bool `json:"partial,omitempty"`
+}
+
+// Covered returns whether a policy denies requests of the entry.
+func (e *Entry) Covered() bool {
+	return len(e.Enforced) > 0
+}
+
+// Report is the coverage of the resources served by a cluster.
+type Report struct {
+	Entries []Entry `json:"entries"`
+	Covered int     `json:"covered"`
+	Partial int     `json:"partial"`
+	Total   int     `json:"total"`
+}
+
+// Compute cross-references the resource rules of policies, through their
+// bindings, with the resources discovered in a cluster, in their preferred
+// versions, to report which operations on which resources are covered by
+// enforcement. Policies without bindings cover nothing.
+func Compute(policies []*v1alpha1.ValidatingAdmissionPolicy, bindings []*v1alpha1.ValidatingAdmissionPolicyBinding, resources []*restmapper.APIGroupResources) *Report {
+	byPolicy := map[string][]*v1alpha1.ValidatingAdmissionPolicyBinding{}
+	for _, binding := range bindings {
+		byPolicy[binding.Spec.PolicyName] = append(byPolicy[binding.Spec.PolicyName], binding)
+	}
+
+	report := &Report{Entries: []Entry{}}
+	for _, group := range resources {
+		version := group.Group.PreferredVersion.Version
+		for _, resource := range group.VersionedResources[version] {
+			for _, operation := range operations(resource) {
+				entry := Entry{
+					Group:      group.Group.Name,
+					Version:    version,
+					Kind:       resource.Kind,
+					Resource:   resource.Name,
+					Namespaced: resource.Namespaced,
+					Operation:  string(operation),
+				}
+				cover(&entry, policies, byPolicy)
+				report.Entries = append(report.Entries, entry)
+				report.Total++
+				if entry.Covered() {
+					report.Covered++
+					if entry.Partial {
+						report.Partial++
+					}
+				}
+			}
+		}
+	}
+	sort.Slice(report.Entries, func(i, j int) bool {
+		a, b := report.Entries[i], report.Entries[j]
+		if a.Group != b.Group {
+			return a.Group < b.Group
+		}
+		if a.Resource != b.Resource {
+			return a.Resource < b.Resource
+		}
+		return a.Operation < b.Operation
+	})
+	return report
+}
+
+// operations returns the operations admitted on a discovered resource.
+func operations(resource metav1.APIResource) []admissionregistrationv1.OperationType {
+	_, subresource, _ := strings.Cut(resource.Name, "/")
+	if connectSubresources[subresource] {
+		return []admissionregistrationv1.OperationType{admissionregistrationv1.Connect}
+	}
+	var ops []admissionregistrationv1.OperationType
+	if hasVerb(resource.Verbs, "create") {
+		ops = append(ops, admissionregistrationv1.Create)
+	}
+	if hasVerb(resource.Verbs, "update") || hasVerb(resource.Verbs, "patch") {
+		ops = append(ops, admissionregistrationv1.Update)
+	}
+	if hasVerb(resource.Verbs, "delete") || hasVerb(resource.Verbs, "deletecollection") {
+		ops = append(ops, admissionregistrationv1.Delete)
+	}
+	return ops
+}
+
+func hasVerb(verbs metav1.Verbs, verb string) bool {
+	for _, v := range verbs {
+		if v == verb {
+			return true
+		}
+	}
+	return false
+}
+
+// cover adds the policies covering entry to it.
+func cover(entry *Entry, policies []*v1alpha1.ValidatingAdmissionPolicy, bindings map[string][]*v1alpha1.ValidatingAdmissionPolicyBinding) {
+	partial := true
+	for _, policy := range policies {
+		constraints := policy.Spec.MatchConstraints
+		if constraints == nil || !matches(constraints, entry) {
+			continue
+		}
+		enforced, audited := false, false
+		policyPartial := restricts(constraints) || len(policy.Spec.MatchConditions) > 0
+		for _, binding := range bindings[policy.Name] {
+			bindingPartial := false
+			if m := binding.Spec.MatchResources; m != nil {
+				if len(m.ResourceRules) > 0 && !matches(m, entry) {
+					continue
+				}
+				if excluded(m, entry) {
+					continue
+				}
+				bindingPartial = restricts(m)
+			}
+			for _, action := range binding.Spec.ValidationActions {
+				switch action {
+				case v1alpha1.Deny:
+					enforced = true
+					if !policyPartial && !bindingPartial {
+						partial = false
+					}
+				case v1alpha1.Warn, v1alpha1.Audit:
+					audited = true
+				}
+			}
+		}
+		switch {
+		case enforced:
+			entry.Enforced = append(entry.Enforced, policy.Name)
+		case audited:
+			entry.Audited = append(entry.Audited, policy.Name)
+		}
+	}
+	entry.Partial = entry.Covered() && partial
+}
+
+// matches returns whether the resource rules of m match entry, less its
+// exclusions. With the Equivalent match policy, the default, rules cover
+// every version of a resource.
+func matches(m *v1alpha1.MatchResources, entry *Entry) bool {
+	if excluded(m, entry) {
+		return false
+	}
+	for _, rule := range m.ResourceRules {
+		if ruleMatches(rule, m.MatchPolicy, entry) {
+			return true
+		}
+	}
+	return false
+}
+
+// excluded returns whether entry is excluded by m regardless of names.
+func excluded(m *v1alpha1.MatchResources, entry *Entry) bool {
+	for _, rule := range m.ExcludeResourceRules {
+		if len(rule.ResourceNames) == 0 && ruleMatches(rule, m.MatchPolicy, entry) {
+			return true
+		}
+	}
+	return false
+}
+
+func ruleMatches(rule v1alpha1.NamedRuleWithOperations, matchPolicy *v1alpha1.MatchPolicyType, entry *Entry) bool {
+	exact := matchPolicy != nil && *matchPolicy == v1alpha1.Exact
+	if !matchesAny(rule.APIGroups, entry.Group) || (exact && !matchesAny(rule.APIVersions, entry.Version)) {
+		return false
+	}
+	if !matchesResource(rule.Resources, entry.Resource) || !matchesOperation(rule.Operations, entry.Operation) {
+		return false
+	}
+	if rule.Scope != nil {
+		switch *rule.Scope {
+		case v1alpha1.ClusterScope:
+			return !entry.Namespaced
+		case v1alpha1.NamespacedScope:
+			return entry.Namespaced
+		}
+	}
+	return true
+}
+
+// restricts returns whether m only matches some requests of the resources
+// it matches.
+func restricts(m *v1alpha1.MatchResources) bool {
+	if !emptySelector(m.NamespaceSelector) || !emptySelector(m.ObjectSelector) {
+		return true
+	}
+	for _, rule := range m.ResourceRules {
+		if len(rule.ResourceNames) > 0 {
+			return true
+		}
+	}
+	for _, rule := range m.ExcludeResourceRules {
+		if len(rule.ResourceNames) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func emptySelector(selector *metav1.LabelSelector) bool {
+	return selector == nil || (len(selector.MatchLabels) == 0 && len(selector.MatchExpressions) == 0)
+}
+
+func matchesAny(values []string, value string) bool {
+	for _, v := range values {
+		if v == value || v == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesResource(patterns []string, resource string) bool {
+	main, sub, hasSub := strings.Cut(resource, "/")
+	for _, pattern := range patterns {
+		switch {
+		case pattern == resource, pattern == "*/*":
+			return true
+		case pattern == "*" && !hasSub:
+			return true
+		case hasSub && (pattern == main+"/*" || pattern == "*/"+sub):
+			return true
+		}
+	}
+	return false
+}
+
+func matchesOperation(operations []admissionregistrationv1.OperationType, operation string) bool {
+	for _, op := range operations {
+		if op == admissionregistrationv1.OperationAll || string(op) == operation {
+			return true
+		}
+	}
+	return false
+}
+
+// Handler serves the coverage of the loaded policies as JSON, only the
+// blind spots with ?uncovered=true.
+type Handler struct {
+	policies  listers.ValidatingAdmissionPolicyLister
+	bindings  listers.ValidatingAdmissionPolicyBindingLister
+	discovery discovery.DiscoveryInterface
+}
+
+// NewHandler creates a Handler for the policies and bindings of the given
+// informers and the resources discovered through client.
+func NewHandler(policyInformer informers.ValidatingAdmissionPolicyInformer, bindingInformer informers.ValidatingAdmissionPolicyBindingInformer, client discovery.DiscoveryInterface) *Handler {
+	return &Handler{policies: policyInformer.Lister(), bindings: bindingInformer.Lister(), discovery: client}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	uncovered, _ := strconv.ParseBool(r.URL.Query().Get("uncovered"))
+	policies, err := h.policies.List(labels.Everything())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	bindings, err := h.bindings.List(labels.Everything())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resources, err := restmapper.GetAPIGroupResources(h.discovery)
+	if err != nil {
+		logger.Error(err, "failed to discover resources")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	report := Compute(policies, bindings, resources)
+	if uncovered {
+		report.Entries = BlindSpots(report)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// BlindSpots returns the entries of report not covered by enforcement.
+func BlindSpots(report *Report) []Entry {
+	spots := []Entry{}
+	for _, entry := range report.Entries {
+		if !entry.Covered() {
+			spots = append(spots, entry)
+		}
+	}
+	return spots
+}
@@ -0,0 +1,127 @@
+package crdscheme
+
+import (
+	"math"
+	"sync"
+
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	structuralschema "k8s.io/apiextensions-apiserver/pkg/apiserver/schema"
+	"k8s.io/apiextensions-apiserver/pkg/apiserver/schema/defaulting"
+	crdinformers "k8s.io/apiextensions-apiserver/pkg/client/informers/externalversions/apiextensions/v1"
+	crdlisters "k8s.io/apiextensions-apiserver/pkg/client/listers/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Coercer brings custom resources decoded as unstructured in line with the
+// structural schemas of their CRDs, as the API server sees them: defaults
+// are applied, and numbers take the type of their field, an int64 for
+// integer fields and a float64 for number fields, whatever their JSON
+// encoding. Otherwise CEL expressions would, e.g., fail to compare a number
+// field set to 1 with a double.
+type Coercer struct {
+	crds crdlisters.CustomResourceDefinitionLister
+
+	lock sync.Mutex
+	// schemas caches the structural schemas by CRD name and version.
+	schemas map[string]structuralEntry
+}
+
+type structuralEntry struct {
+	resourceVersion string
+	schema          *structuralschema.Structural
+}
+
+// NewCoercer creates a Coercer reading CRDs from crdInformer, which must be
+// started by the caller.
+func NewCoercer(crdInformer crdinformers.CustomResourceDefinitionInformer) *Coercer {
+	return &Coercer{crds: crdInformer.Lister(), schemas: map[string]structuralEntry{}}
+}
+
+// Coerce defaults and coerces obj in place. Objects of kinds without a CRD
+// or with a schema that is not structural are left unchanged.
+func (c *Coercer) Coerce(obj *unstructured.Unstructured) {
+	s := c.structural(obj.GroupVersionKind())
+	if s == nil {
+		return
+	}
+	defaulting.PruneNonNullableNullsWithoutDefaults(obj.Object, s)
+	defaulting.Default(obj.Object, s)
+	coerceNumbers(obj.Object, s)
+}
+
+// structural returns the structural schema of the custom resource kind.
+func (c *Coercer) structural(kind schema.GroupVersionKind) *structuralschema.Structural {
+	crds, err := c.crds.List(labels.Everything())
+	if err != nil {
+		return nil
+	}
+	for _, crd := range crds {
+		if crd.Spec.Group != kind.Group || crd.Spec.Names.Kind != kind.Kind {
+			continue
+		}
+		for i := range crd.Spec.Versions {
+			if crd.Spec.Versions[i].Name == kind.Version {
+				return c.versionSchema(crd, &crd.Spec.Versions[i])
+			}
+		}
+		return nil
+	}
+	return nil
+}
+
+func (c *Coercer) versionSchema(crd *apiextensionsv1.CustomResourceDefinition, version *apiextensionsv1.CustomResourceDefinitionVersion) *structuralschema.Structural {
+	key := crd.Name + "/" + version.Name
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if entry, ok := c.schemas[key]; ok && entry.resourceVersion == crd.ResourceVersion {
+		return entry.schema
+	}
+
+	var s *structuralschema.Structural
+	if version.Schema != nil && version.Schema.OpenAPIV3Schema != nil {
+		var internal apiextensions.JSONSchemaProps
+		err := apiextensionsv1.Convert_v1_JSONSchemaProps_To_apiextensions_JSONSchemaProps(version.Schema.OpenAPIV3Schema, &internal, nil)
+		if err == nil {
+			s, err = structuralschema.NewStructural(&internal)
+		}
+		if err != nil {
+			logger.V(2).Info("not coercing custom resources with a schema that is not structural", "crd", crd.Name, "version", version.Name, "err", err)
+			s = nil
+		}
+	}
+	c.schemas[key] = structuralEntry{resourceVersion: crd.ResourceVersion, schema: s}
+	return s
+}
+
+// coerceNumbers returns x with the numbers under it converted to the types
+// of their fields in s.
+func coerceNumbers(x interface{}, s *structuralschema.Structural) interface{} {
+	switch x := x.(type) {
+	case map[string]interface{}:
+		for k, v := range x {
+			if prop, ok := s.Properties[k]; ok {
+				x[k] = coerceNumbers(v, &prop)
+			} else if s.AdditionalProperties != nil && s.AdditionalProperties.Structural != nil {
+				x[k] = coerceNumbers(v, s.AdditionalProperties.Structural)
+			}
+		}
+	case []interface{}:
+		if s.Items != nil {
+			for i, v := range x {
+				x[i] = coerceNumbers(v, s.Items)
+			}
+		}
+	case int64:
+		if s.Type == "number" {
+			return float64(x)
+		}
+	case float64:
+		if s.Type == "integer" && x == math.Trunc(x) && x >= math.MinInt64 && x < math.MaxInt64 {
+			return int64(x)
+		}
+	}
+	return x
+}
@@ -0,0 +1,160 @@
+package crdscheme
+
+import (
+	"fmt"
+
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	crdinformers "k8s.io/apiextensions-apiserver/pkg/client/informers/externalversions/apiextensions/v1"
+	crdlisters "k8s.io/apiextensions-apiserver/pkg/client/listers/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apiserver/pkg/admission"
+)
+
+// ObjectInterfaces extends the object interfaces of a scheme with the
+// custom resources defined by CRDs: policies matching another version of a
+// custom resource with the Equivalent match policy match it, and are
+// evaluated against the object converted to that version. Only CRDs
+// without a conversion webhook are converted, by changing the apiVersion of
+// objects, as the API server does.
+type ObjectInterfaces struct {
+	admission.ObjectInterfaces
+	crds crdlisters.CustomResourceDefinitionLister
+}
+
+var _ admission.ObjectInterfaces = (*ObjectInterfaces)(nil)
+
+// NewObjectInterfaces extends base with the CRDs of crdInformer, which must
+// be started by the caller.
+func NewObjectInterfaces(base admission.ObjectInterfaces, crdInformer crdinformers.CustomResourceDefinitionInformer) *ObjectInterfaces {
+	return &ObjectInterfaces{ObjectInterfaces: base, crds: crdInformer.Lister()}
+}
+
+func (o *ObjectInterfaces) GetObjectCreater() runtime.ObjectCreater {
+	return creater{o}
+}
+
+func (o *ObjectInterfaces) GetObjectConvertor() runtime.ObjectConvertor {
+	return convertor{o}
+}
+
+func (o *ObjectInterfaces) GetEquivalentResourceMapper() runtime.EquivalentResourceMapper {
+	return mapper{o}
+}
+
+// convertible returns the CRD of the resource, if it has no conversion
+// webhook.
+func (o *ObjectInterfaces) convertible(resource schema.GroupResource) *apiextensionsv1.CustomResourceDefinition {
+	crd, err := o.crds.Get(resource.String())
+	if err != nil || (crd.Spec.Conversion != nil && crd.Spec.Conversion.Strategy != apiextensionsv1.NoneConverter) {
+		return nil
+	}
+	return crd
+}
+
+// convertibleKind returns the CRD of the kind, if it has no conversion
+// webhook.
+func (o *ObjectInterfaces) convertibleKind(kind schema.GroupKind) *apiextensionsv1.CustomResourceDefinition {
+	crds, err := o.crds.List(labels.Everything())
+	if err != nil {
+		return nil
+	}
+	for _, crd := range crds {
+		if crd.Spec.Group == kind.Group && crd.Spec.Names.Kind == kind.Kind {
+			return o.convertible(schema.GroupResource{Group: crd.Spec.Group, Resource: crd.Spec.Names.Plural})
+		}
+	}
+	return nil
+}
+
+func served(crd *apiextensionsv1.CustomResourceDefinition, version string) bool {
+	for _, v := range crd.Spec.Versions {
+		if v.Name == version {
+			return v.Served
+		}
+	}
+	return false
+}
+
+type creater struct{ o *ObjectInterfaces }
+
+func (c creater) New(kind schema.GroupVersionKind) (runtime.Object, error) {
+	if crd := c.o.convertibleKind(kind.GroupKind()); crd != nil && served(crd, kind.Version) {
+		u := &unstructured.Unstructured{}
+		u.SetGroupVersionKind(kind)
+		return u, nil
+	}
+	return c.o.ObjectInterfaces.GetObjectCreater().New(kind)
+}
+
+type convertor struct{ o *ObjectInterfaces }
+
+func (c convertor) Convert(in, out, context interface{}) error {
+	inU, inOK := in.(*unstructured.Unstructured)
+	outU, outOK := out.(*unstructured.Unstructured)
+	if !inOK || !outOK {
+		return c.o.ObjectInterfaces.GetObjectConvertor().Convert(in, out, context)
+	}
+	from, to := inU.GroupVersionKind(), outU.GroupVersionKind()
+	if from.GroupKind() != to.GroupKind() {
+		return fmt.Errorf("cannot convert %v to %v", from, to)
+	}
+	if crd := c.o.convertibleKind(to.GroupKind()); crd == nil || !served(crd, to.Version) {
+		return fmt.Errorf("cannot convert %v to %v: not a custom resource without conversion webhook", from, to)
+	}
+	outU.Object = inU.DeepCopy().Object
+	outU.SetGroupVersionKind(to)
+	return nil
+}
+
+func (c convertor) ConvertToVersion(in runtime.Object, gv runtime.GroupVersioner) (runtime.Object, error) {
+	if u, ok := in.(*unstructured.Unstructured); ok {
+		if kind, ok := gv.KindForGroupVersionKinds([]schema.GroupVersionKind{u.GroupVersionKind()}); ok {
+			out, err := creater(c).New(kind)
+			if err != nil {
+				return nil, err
+			}
+			if err := c.Convert(u, out, nil); err != nil {
+				return nil, err
+			}
+			return out, nil
+		}
+	}
+	return c.o.ObjectInterfaces.GetObjectConvertor().ConvertToVersion(in, gv)
+}
+
+func (c convertor) ConvertFieldLabel(gvk schema.GroupVersionKind, label, value string) (string, string, error) {
+	return c.o.ObjectInterfaces.GetObjectConvertor().ConvertFieldLabel(gvk, label, value)
+}
+
+type mapper struct{ o *ObjectInterfaces }
+
+// EquivalentResourcesFor returns the served versions of a custom resource.
+func (m mapper) EquivalentResourcesFor(resource schema.GroupVersionResource, subresource string) []schema.GroupVersionResource {
+	crd := m.o.convertible(resource.GroupResource())
+	if crd == nil || !served(crd, resource.Version) {
+		return m.o.ObjectInterfaces.GetEquivalentResourceMapper().EquivalentResourcesFor(resource, subresource)
+	}
+	var equivalents []schema.GroupVersionResource
+	for _, v := range crd.Spec.Versions {
+		if v.Served {
+			equivalents = append(equivalents, resource.GroupResource().WithVersion(v.Name))
+		}
+	}
+	return equivalents
+}
+
+// KindFor returns the kind of a custom resource, or of its scale
+// subresource.
+func (m mapper) KindFor(resource schema.GroupVersionResource, subresource string) schema.GroupVersionKind {
+	crd := m.o.convertible(resource.GroupResource())
+	if crd == nil || !served(crd, resource.Version) {
+		return m.o.ObjectInterfaces.GetEquivalentResourceMapper().KindFor(resource, subresource)
+	}
+	if subresource == "scale" {
+		return schema.GroupVersionKind{Group: "autoscaling", Version: "v1", Kind: "Scale"}
+	}
+	return schema.GroupVersionKind{Group: crd.Spec.Group, Version: resource.Version, Kind: crd.Spec.Names.Kind}
+}
@@ -0,0 +1,90 @@
+package crdscheme
+
+import (
+	"sync"
+	"time"
+
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	crdinformers "k8s.io/apiextensions-apiserver/pkg/client/informers/externalversions/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apiserver/pkg/cel/openapi/resolver"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+)
+
+var logger klog.Logger = klog.LoggerWithName(klog.Background(), "crdscheme")
+
+type cacheEntry struct {
+	schema   *spec.Schema
+	err      error
+	resolved time.Time
+}
+
+// Resolver resolves the schemas of built-in and custom resources from the
+// OpenAPI v3 documents published through discovery, which include the
+// structural schemas of CRDs. Schemas are cached, dropped when the CRD of
+// their kind changes, and resolved again after the refresh interval, so
+// policies are type checked against CRDs installed or upgraded after
+// startup.
+type Resolver struct {
+	discovery resolver.ClientDiscoveryResolver
+	refresh   time.Duration
+
+	lock  sync.RWMutex
+	cache map[schema.GroupVersionKind]cacheEntry
+}
+
+var _ resolver.SchemaResolver = (*Resolver)(nil)
+
+// NewResolver creates a Resolver. It registers its handler on crdInformer,
+// which must be started by the caller.
+func NewResolver(crdInformer crdinformers.CustomResourceDefinitionInformer, disco discovery.DiscoveryInterface, refresh time.Duration) *Resolver {
+	r := &Resolver{
+		discovery: resolver.ClientDiscoveryResolver{Discovery: disco},
+		refresh:   refresh,
+		cache:     map[schema.GroupVersionKind]cacheEntry{},
+	}
+	crdInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    r.purge,
+		UpdateFunc: func(_, obj interface{}) { r.purge(obj) },
+		DeleteFunc: r.purge,
+	})
+	return r
+}
+
+func (r *Resolver) ResolveSchema(gvk schema.GroupVersionKind) (*spec.Schema, error) {
+	r.lock.RLock()
+	entry, ok := r.cache[gvk]
+	r.lock.RUnlock()
+	if ok && (r.refresh <= 0 || time.Since(entry.resolved) < r.refresh) {
+		return entry.schema, entry.err
+	}
+
+	s, err := r.discovery.ResolveSchema(gvk)
+	r.lock.Lock()
+	r.cache[gvk] = cacheEntry{schema: s, err: err, resolved: time.Now()}
+	r.lock.Unlock()
+	return s, err
+}
+
+// purge drops the cached schemas of the kind of a changed CRD.
+func (r *Resolver) purge(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	crd, ok := obj.(*apiextensionsv1.CustomResourceDefinition)
+	if !ok {
+		return
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for gvk := range r.cache {
+		if gvk.Group == crd.Spec.Group && gvk.Kind == crd.Spec.Names.Kind {
+			delete(r.cache, gvk)
+			logger.V(2).Info("dropped cached schema", "gvk", gvk, "crd", crd.Name)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package dashboard
+
+import (
+	"bytes"
+	_ "embed"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+
+	listers "k8s.io/cel-admission-webhook/pkg/generated/listers/admissionregistration.x-k8s.io/v1alpha1"
+
+	"github.com/kubescape/kubeenforcer/pkg/certexpiry"
+	"github.com/kubescape/kubeenforcer/pkg/decision"
+)
+
+var logger klog.Logger = klog.LoggerWithName(klog.Background(), "dashboard")
+
+// DefaultDecisions is the number of recent decisions to keep for the
+// dashboard, unless more are kept for other uses.
+const DefaultDecisions = 1000
+
+// statsWindow is how far back decisions are counted.
+const statsWindow = 24 * time.Hour
+
+// recentDenials is the number of denials listed on the dashboard.
+const recentDenials = 50
+
+//go:embed dashboard.html
+var page string
+
+var pageTemplate = template.Must(template.New("dashboard").Funcs(template.FuncMap{
+	"join": strings.Join,
+	"time": func(t time.Time) string { return t.UTC().Format(time.RFC3339) },
+}).Parse(page))
+
+// Dashboard is a read-only web page showing the loaded policies, recent
+// denials, per-policy decision counts and certificate expiry.
+type Dashboard struct {
+	policies  listers.ValidatingAdmissionPolicyLister
+	bindings  listers.ValidatingAdmissionPolicyBindingLister
+	decisions decision.Querier
+	certs     *certexpiry.Monitor
+}
+
+// New creates a Dashboard. Decision counts and recent denials cover the
+// decisions of the last day kept by decisions.
+func New(policies listers.ValidatingAdmissionPolicyLister, bindings listers.ValidatingAdmissionPolicyBindingLister, decisions decision.Querier, certs *certexpiry.Monitor) *Dashboard {
+	return &Dashboard{policies: policies, bindings: bindings, decisions: decisions, certs: certs}
+}
+
+type policyRow struct {
+	Name          string
+	FailurePolicy string
+	Bindings      []string
+	Actions       []string
+	Denied        int
+	Audited       int
+}
+
+type certRow struct {
+	certexpiry.Status
+	Remaining string
+}
+
+type pageData struct {
+	Generated time.Time
+	Policies  []*policyRow
+	Denials   []*decision.Decision
+	Certs     []certRow
+	Error     string
+}
+
+func (d *Dashboard) data() *pageData {
+	data := &pageData{Generated: time.Now()}
+
+	rows := map[string]*policyRow{}
+	policies, err := d.policies.List(labels.Everything())
+	if err != nil {
+		data.Error = err.Error()
+	}
+	for _, p := range policies {
+		row := &policyRow{Name: p.Name, FailurePolicy: "Fail"}
+		if p.Spec.FailurePolicy != nil {
+			row.FailurePolicy = string(*p.Spec.FailurePolicy)
+		}
+		rows[p.Name] = row
+		data.Policies = append(data.Policies, row)
+	}
+	bindings, err := d.bindings.List(labels.Everything())
+	if err != nil {
+		data.Error = err.Error()
+	}
+	for _, b := range bindings {
+		row, ok := rows[b.Spec.PolicyName]
+		if !ok {
+			continue
+		}
+		row.Bindings = append(row.Bindings, b.Name)
+		for _, action := range b.Spec.ValidationActions {
+			if !contains(row.Actions, string(action)) {
+				row.Actions = append(row.Actions, string(action))
+			}
+		}
+	}
+
+	decisions, err := d.decisions.Query(decision.Filter{Since: time.Now().Add(-statsWindow)})
+	if err != nil {
+		data.Error = err.Error()
+	}
+	for _, dec := range decisions {
+		row, ok := rows[dec.Policy]
+		if ok {
+			for _, action := range dec.Actions {
+				switch action {
+				case "Deny":
+					row.Denied++
+				case "Audit":
+					row.Audited++
+				}
+			}
+		}
+		if !dec.Allowed && len(data.Denials) < recentDenials {
+			data.Denials = append(data.Denials, dec)
+		}
+	}
+
+	for _, row := range data.Policies {
+		sort.Strings(row.Bindings)
+		sort.Strings(row.Actions)
+	}
+	sort.Slice(data.Policies, func(i, j int) bool { return data.Policies[i].Name < data.Policies[j].Name })
+
+	if d.certs != nil {
+		for _, status := range d.certs.Status() {
+			row := certRow{Status: status}
+			if status.Error == "" {
+				row.Remaining = time.Until(status.NotAfter).Truncate(time.Hour).String()
+			}
+			data.Certs = append(data.Certs, row)
+		}
+	}
+	return data
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Dashboard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var buf bytes.Buffer
+	if err := pageTemplate.Execute(&buf, d.data()); err != nil {
+		logger.Error(err, "failed to render dashboard")
+		http.Error(w, "failed to render dashboard", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,275 @@
+package datadog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	listersv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/klog/v2"
+
+	"github.com/kubescape/kubeenforcer/pkg/decision"
+)
+
+var logger klog.Logger = klog.LoggerWithName(klog.Background(), "datadog")
+
+const (
+	bufferSize     = 1000
+	sendTimeout    = 10 * time.Second
+	metricInterval = 10 * time.Second
+)
+
+// Options configures what is sent to Datadog.
+type Options struct {
+	// Site is the Datadog site of the organization, e.g. datadoghq.eu.
+	Site string
+	// Tags are added to every event and metric, e.g. env:prod.
+	Tags []string
+	// TeamLabel is the namespace label whose value is the team tag.
+	TeamLabel string
+	// Metrics submits the count of decisions as the kubeenforcer.decisions
+	// metric.
+	Metrics bool
+}
+
+// event is the body of a request to the events API.
+type event struct {
+	Title          string   `json:"title"`
+	Text           string   `json:"text"`
+	DateHappened   int64    `json:"date_happened"`
+	AlertType      string   `json:"alert_type"`
+	SourceTypeName string   `json:"source_type_name"`
+	AggregationKey string   `json:"aggregation_key,omitempty"`
+	Tags           []string `json:"tags"`
+}
+
+// series is the body of a request to the metrics API.
+type series struct {
+	Series []metric `json:"series"`
+}
+
+type metric struct {
+	Metric   string   `json:"metric"`
+	Type     int      `json:"type"`
+	Interval int64    `json:"interval"`
+	Points   []point  `json:"points"`
+	Tags     []string `json:"tags"`
+}
+
+type point struct {
+	Timestamp int64   `json:"timestamp"`
+	Value     float64 `json:"value"`
+}
+
+// metricTypeCount is the count type of the metrics API.
+const metricTypeCount = 1
+
+// countKey identifies the decisions counted together.
+type countKey struct {
+	allowed   bool
+	cluster   string
+	namespace string
+	policy    string
+}
+
+// Sink sends denials to Datadog as events, tagged with the cluster,
+// namespace, policy and team, so they show up in the event explorer and can
+// trigger event monitors, and optionally counts all decisions as a metric.
+// It is a decision.Sink; denials are buffered and dropped if Datadog cannot
+// keep up so admission is never slowed down.
+type Sink struct {
+	url        string
+	apiKey     string
+	opts       Options
+	namespaces listersv1.NamespaceLister
+	client     *http.Client
+
+	buffer  chan *decision.Decision
+	dropped atomic.Int64
+
+	lock   sync.Mutex
+	counts map[countKey]int
+}
+
+// New creates a Sink sending to Datadog with the API key apiKey. The team
+// tags are read from the namespaces of namespaces.
+func New(apiKey string, opts Options, namespaces listersv1.NamespaceLister, client *http.Client) *Sink {
+	c := *client
+	c.Timeout = sendTimeout
+	return &Sink{
+		url:        "https://api." + opts.Site,
+		apiKey:     apiKey,
+		opts:       opts,
+		namespaces: namespaces,
+		client:     &c,
+		buffer:     make(chan *decision.Decision, bufferSize),
+		counts:     map[countKey]int{},
+	}
+}
+
+func (s *Sink) Record(d *decision.Decision) {
+	if s.opts.Metrics {
+		s.lock.Lock()
+		s.counts[countKey{allowed: d.Allowed, cluster: d.Cluster, namespace: d.Namespace, policy: d.Policy}]++
+		s.lock.Unlock()
+	}
+	if d.Allowed || d.Throttled {
+		return
+	}
+	select {
+	case s.buffer <- d:
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+// Run sends buffered denials, and the decision counts every 10s if enabled,
+// until ctx is cancelled.
+func (s *Sink) Run(ctx context.Context) error {
+	logger.Info("sending denials to Datadog", "site", s.opts.Site, "metrics", s.opts.Metrics)
+	defer logger.Info("stopped sending to Datadog")
+
+	var tick <-chan time.Time
+	if s.opts.Metrics {
+		ticker := time.NewTicker(metricInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case d := <-s.buffer:
+			if dropped := s.dropped.Swap(0); dropped > 0 {
+				logger.Info("dropped denials because Datadog could not keep up", "count", dropped)
+			}
+			if err := s.post(ctx, "/api/v1/events", s.event(d)); err != nil && ctx.Err() == nil {
+				logger.Error(err, "failed to send event", "decision", d.ID)
+			}
+		case now := <-tick:
+			if err := s.submitCounts(ctx, now); err != nil && ctx.Err() == nil {
+				logger.Error(err, "failed to submit decision counts")
+			}
+		}
+	}
+}
+
+// submitCounts submits the decisions counted since the previous call.
+func (s *Sink) submitCounts(ctx context.Context, now time.Time) error {
+	s.lock.Lock()
+	counts := s.counts
+	s.counts = map[countKey]int{}
+	s.lock.Unlock()
+	if len(counts) == 0 {
+		return nil
+	}
+
+	body := &series{}
+	for key, count := range counts {
+		outcome := "denied"
+		if key.allowed {
+			outcome = "allowed"
+		}
+		body.Series = append(body.Series, metric{
+			Metric:   "kubeenforcer.decisions",
+			Type:     metricTypeCount,
+			Interval: int64(metricInterval / time.Second),
+			Points:   []point{{Timestamp: now.Unix(), Value: float64(count)}},
+			Tags:     append(s.tags(key.cluster, key.namespace, key.policy), "decision:"+outcome),
+		})
+	}
+	return s.post(ctx, "/api/v2/series", body)
+}
+
+func (s *Sink) event(d *decision.Decision) *event {
+	resource := d.Resource
+	if d.Group != "" {
+		resource += "." + d.Group
+	}
+	if d.Name != "" {
+		resource += "/" + d.Name
+	}
+	if d.Namespace != "" {
+		resource = d.Namespace + "/" + resource
+	}
+	text := fmt.Sprintf("%s was denied %s of %s.", d.User, strings.ToLower(d.Operation), resource)
+	if d.Message != "" {
+		text += "\n\n" + d.Message
+	}
+	text += "\n\nDecision " + d.ID
+
+	tags := s.tags(d.Cluster, d.Namespace, d.Policy)
+	if d.Source != nil && d.Source.Application() != "" {
+		tags = append(tags, "gitops_application:"+d.Source.Application())
+	}
+	return &event{
+		Title:          "kubeenforcer denied " + strings.ToLower(d.Operation) + " of " + resource,
+		Text:           text,
+		DateHappened:   d.Time.Unix(),
+		AlertType:      "error",
+		SourceTypeName: "kubeenforcer",
+		// Groups the repeated denials of a policy in a namespace
+		AggregationKey: d.Policy + "/" + d.Namespace,
+		Tags:           tags,
+	}
+}
+
+// tags returns the tags of the decisions of policy in namespace.
+func (s *Sink) tags(cluster, namespace, policy string) []string {
+	tags := append([]string{"source:kubeenforcer"}, s.opts.Tags...)
+	if cluster != "" {
+		tags = append(tags, "cluster:"+cluster)
+	}
+	if namespace != "" {
+		tags = append(tags, "kube_namespace:"+namespace)
+		if team := s.team(namespace); team != "" {
+			tags = append(tags, "team:"+team)
+		}
+	}
+	if policy != "" {
+		tags = append(tags, "policy:"+policy)
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+func (s *Sink) team(namespace string) string {
+	if s.opts.TeamLabel == "" || s.namespaces == nil {
+		return ""
+	}
+	ns, err := s.namespaces.Get(namespace)
+	if err != nil {
+		return ""
+	}
+	return ns.Labels[s.opts.TeamLabel]
+}
+
+func (s *Sink) post(ctx context.Context, path string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("DD-API-KEY", s.apiKey)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("datadog returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package decision
+
+import (
+	"context"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/uuid"
+)
+
+// Decision records the outcome of a single admission review.
+type Decision struct {
+	Time    time.Time `json:"time"`
+	ID      string    `json:"id"`
+	UID     string    `json:"uid"`
+	Cluster string    `json:"cluster,omitempty"`
+
+	Operation   string `json:"operation"`
+	Group       string `json:"group,omitempty"`
+	Version     string `json:"version"`
+	Resource    string `json:"resource"`
+	SubResource string `json:"subResource,omitempty"`
+	Namespace   string `json:"namespace,omitempty"`
+	Name        string `json:"name,omitempty"`
+	User        string `json:"user"`
+
+	Allowed bool     `json:"allowed"`
+	Policy  string   `json:"policy,omitempty"`
+	Actions []string `json:"actions,omitempty"`
+	Message string   `json:"message,omitempty"`
+	// Throttled is set on denials returned as rate limited during a deny
+	// storm of the user, which are not alerted on.
+	Throttled bool `json:"throttled,omitempty"`
+	// Collection is set on deletions of the items of a deletecollection
+	// request, which are evaluated one by one.
+	Collection bool `json:"collection,omitempty"`
+	// DryRun is set on requests whose changes are not persisted.
+	DryRun bool `json:"dryRun,omitempty"`
+
+	// PolicySet is the hash of the policies and bindings in effect, and
+	// KubeenforcerVersion the version of kubeenforcer that decided.
+	PolicySet           string `json:"policySet,omitempty"`
+	KubeenforcerVersion string `json:"kubeenforcerVersion,omitempty"`
+
+	// Source is where the object was deployed from, if it carries GitOps
+	// annotations.
+	Source *Source `json:"source,omitempty"`
+	// HelmRelease is the Helm release owning the object, if any.
+	HelmRelease *HelmRelease `json:"helmRelease,omitempty"`
+}
+
+// NewID returns a new decision ID. Every evaluation gets one, which is
+// returned to the client and attached to logs, alerts and decision records
+// so they can be correlated.
+func NewID() string {
+	return string(uuid.NewUUID())
+}
+
+type idKey struct{}
+
+// WithID returns a copy of ctx carrying the decision ID id.
+func WithID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, idKey{}, id)
+}
+
+// IDFromContext returns the decision ID carried by ctx, or "" if none.
+func IDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(idKey{}).(string)
+	return id
+}
+
+// Sink receives decisions as they are made. Record is called on the request
+// path so implementations must not block.
+type Sink interface {
+	Record(d *Decision)
+}
+
+// NewMulti returns a Sink that forwards every decision to all of sinks.
+func NewMulti(sinks ...Sink) Sink {
+	return multi(sinks)
+}
+
+type multi []Sink
+
+func (m multi) Record(d *Decision) {
+	for _, s := range m {
+		s.Record(d)
+	}
+}
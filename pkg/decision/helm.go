@@ -0,0 +1,56 @@
+package decision
+
+// Metadata Helm sets on the objects of a release.
+const (
+	// HelmReleaseNameAnnotation and HelmReleaseNamespaceAnnotation are set by
+	// Helm 3 on the objects it creates, to the name and namespace of their
+	// release.
+	HelmReleaseNameAnnotation      = "meta.helm.sh/release-name"
+	HelmReleaseNamespaceAnnotation = "meta.helm.sh/release-namespace"
+
+	// HelmChartLabel is set by most charts, to <chart>-<version>.
+	HelmChartLabel = "helm.sh/chart"
+
+	// ManagedByLabel and InstanceLabel are the recommended labels most
+	// charts set on their objects and pod templates, to Helm and the name of
+	// the release.
+	ManagedByLabel = "app.kubernetes.io/managed-by"
+	InstanceLabel  = "app.kubernetes.io/instance"
+)
+
+// HelmRelease is the Helm release owning an admitted object.
+type HelmRelease struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	// Chart is the chart of the release as <name>-<version>, if known.
+	Chart string `json:"chart,omitempty"`
+	// Revision is the latest revision of the release, if known.
+	Revision int `json:"revision,omitempty"`
+}
+
+// HelmReleaseOf returns the release owning an object in namespace with
+// labels and annotations according to its meta.helm.sh annotations, or nil
+// if it has none.
+func HelmReleaseOf(namespace string, labels, annotations map[string]string) *HelmRelease {
+	name := annotations[HelmReleaseNameAnnotation]
+	if name == "" {
+		return nil
+	}
+	release := &HelmRelease{
+		Name:      name,
+		Namespace: annotations[HelmReleaseNamespaceAnnotation],
+		Chart:     labels[HelmChartLabel],
+	}
+	if release.Namespace == "" {
+		release.Namespace = namespace
+	}
+	return release
+}
+
+func (r *HelmRelease) String() string {
+	s := r.Namespace + "/" + r.Name
+	if r.Chart != "" {
+		s += " (chart " + r.Chart + ")"
+	}
+	return s
+}
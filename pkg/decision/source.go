@@ -0,0 +1,80 @@
+package decision
+
+import "strings"
+
+// Annotations identifying where an object was deployed from.
+const (
+	// RepositoryAnnotation and CommitAnnotation are set by deployment
+	// pipelines to the URL of the git repository and the SHA of the commit
+	// of the object.
+	RepositoryAnnotation = "kubeenforcer.kubescape.io/git-repository"
+	CommitAnnotation     = "kubeenforcer.kubescape.io/git-commit"
+
+	// ArgoCDTrackingAnnotation is set by Argo CD with annotation based
+	// resource tracking, to <application>:<group>/<kind>:<namespace>/<name>.
+	ArgoCDTrackingAnnotation = "argocd.argoproj.io/tracking-id"
+)
+
+// Labels set by Flux on the objects it applies, to the name and namespace of
+// the Kustomization or HelmRelease applying them.
+const (
+	FluxKustomizationNameLabel      = "kustomize.toolkit.fluxcd.io/name"
+	FluxKustomizationNamespaceLabel = "kustomize.toolkit.fluxcd.io/namespace"
+	FluxHelmReleaseNameLabel        = "helm.toolkit.fluxcd.io/name"
+	FluxHelmReleaseNamespaceLabel   = "helm.toolkit.fluxcd.io/namespace"
+)
+
+// Source is where an admitted object was deployed from, according to its
+// GitOps labels and annotations.
+type Source struct {
+	Repository string `json:"repository,omitempty"`
+	Commit     string `json:"commit,omitempty"`
+	// ArgoCDApplication is the Argo CD application tracking the object,
+	// <namespace>_<name> for applications outside the Argo CD namespace.
+	ArgoCDApplication string `json:"argocdApplication,omitempty"`
+	// FluxKustomization and FluxHelmRelease are the Flux Kustomization or
+	// HelmRelease applying the object, as <namespace>/<name>.
+	FluxKustomization string `json:"fluxKustomization,omitempty"`
+	FluxHelmRelease   string `json:"fluxHelmRelease,omitempty"`
+}
+
+// SourceOf returns the source of an object with labels and annotations, or
+// nil if they do not identify one.
+func SourceOf(labels, annotations map[string]string) *Source {
+	source := &Source{
+		Repository: annotations[RepositoryAnnotation],
+		Commit:     annotations[CommitAnnotation],
+	}
+	if application, _, ok := strings.Cut(annotations[ArgoCDTrackingAnnotation], ":"); ok {
+		source.ArgoCDApplication = application
+	}
+	source.FluxKustomization = namespacedName(labels[FluxKustomizationNamespaceLabel], labels[FluxKustomizationNameLabel])
+	source.FluxHelmRelease = namespacedName(labels[FluxHelmReleaseNamespaceLabel], labels[FluxHelmReleaseNameLabel])
+	if source.Commit == "" && source.Application() == "" {
+		return nil
+	}
+	return source
+}
+
+// Application returns the GitOps application deploying the object, as
+// Kind/name like workloads, e.g. Application/payments or
+// Kustomization/flux-system/apps, or "" if it is not deployed by Argo CD or
+// Flux. A HelmRelease wins over the Kustomization applying it.
+func (s *Source) Application() string {
+	switch {
+	case s.ArgoCDApplication != "":
+		return "Application/" + strings.Replace(s.ArgoCDApplication, "_", "/", 1)
+	case s.FluxHelmRelease != "":
+		return "HelmRelease/" + s.FluxHelmRelease
+	case s.FluxKustomization != "":
+		return "Kustomization/" + s.FluxKustomization
+	}
+	return ""
+}
+
+func namespacedName(namespace, name string) string {
+	if name == "" || namespace == "" {
+		return name
+	}
+	return namespace + "/" + name
+}
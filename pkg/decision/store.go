@@ -0,0 +1,173 @@
+package decision
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Querier looks up recorded decisions.
+type Querier interface {
+	// Query returns the decisions matching filter, most recent first.
+	Query(filter Filter) ([]*Decision, error)
+}
+
+// Store keeps the most recent decisions in memory so they can be queried.
+type Store struct {
+	lock      sync.RWMutex
+	decisions []*Decision
+	next      int
+	full      bool
+}
+
+// NewStore returns a Store keeping the last size decisions.
+func NewStore(size int) *Store {
+	return &Store{decisions: make([]*Decision, size)}
+}
+
+func (s *Store) Record(d *Decision) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.decisions[s.next] = d
+	s.next = (s.next + 1) % len(s.decisions)
+	if s.next == 0 {
+		s.full = true
+	}
+}
+
+// Filter selects decisions. Zero fields match every decision.
+type Filter struct {
+	Namespace string
+	Policy    string
+	// Action matches decisions with the validation action, e.g. Deny or
+	// Audit, case insensitively.
+	Action  string
+	Allowed *bool
+	Since   time.Time
+	Until   time.Time
+	// Limit caps the number of decisions returned.
+	Limit int
+}
+
+// Matches reports whether d is selected by the filter, ignoring Limit.
+func (f *Filter) Matches(d *Decision) bool {
+	if f.Namespace != "" && d.Namespace != f.Namespace {
+		return false
+	}
+	if f.Policy != "" && d.Policy != f.Policy {
+		return false
+	}
+	if f.Allowed != nil && d.Allowed != *f.Allowed {
+		return false
+	}
+	if !f.Since.IsZero() && d.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && d.Time.After(f.Until) {
+		return false
+	}
+	if f.Action != "" {
+		for _, action := range d.Actions {
+			if strings.EqualFold(action, f.Action) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func (s *Store) Query(filter Filter) ([]*Decision, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	res := []*Decision{}
+	count := s.next
+	if s.full {
+		count = len(s.decisions)
+	}
+	for i := 1; i <= count; i++ {
+		d := s.decisions[(s.next-i+len(s.decisions))%len(s.decisions)]
+		if !filter.Matches(d) {
+			continue
+		}
+		res = append(res, d)
+		if filter.Limit > 0 && len(res) == filter.Limit {
+			break
+		}
+	}
+	return res, nil
+}
+
+// NewHandler serves the decisions of querier matching the query parameters
+// as JSON, most recent first:
+//
+//	namespace, policy  exact matches
+//	action             validation action, e.g. deny or audit
+//	allowed            true or false
+//	since, until       RFC 3339 times, or durations before now, e.g. 1h
+//	limit              maximum number of decisions
+func NewHandler(querier Querier) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		filter, err := parseFilter(r, time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		decisions, err := querier.Query(filter)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(decisions)
+	})
+}
+
+func parseFilter(r *http.Request, now time.Time) (Filter, error) {
+	query := r.URL.Query()
+	filter := Filter{
+		Namespace: query.Get("namespace"),
+		Policy:    query.Get("policy"),
+		Action:    query.Get("action"),
+	}
+	if value := query.Get("allowed"); value != "" {
+		allowed, err := strconv.ParseBool(value)
+		if err != nil {
+			return filter, fmt.Errorf("invalid allowed: %w", err)
+		}
+		filter.Allowed = &allowed
+	}
+	var err error
+	if filter.Since, err = parseTime(query.Get("since"), now); err != nil {
+		return filter, fmt.Errorf("invalid since: %w", err)
+	}
+	if filter.Until, err = parseTime(query.Get("until"), now); err != nil {
+		return filter, fmt.Errorf("invalid until: %w", err)
+	}
+	if value := query.Get("limit"); value != "" {
+		if filter.Limit, err = strconv.Atoi(value); err != nil || filter.Limit < 0 {
+			return filter, fmt.Errorf("invalid limit %q", value)
+		}
+	}
+	return filter, nil
+}
+
+// parseTime parses an RFC 3339 time, or a duration before now.
+func parseTime(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
@@ -0,0 +1,268 @@
+package decisiondb
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"k8s.io/klog/v2"
+
+	"github.com/kubescape/kubeenforcer/pkg/decision"
+)
+
+var logger klog.Logger = klog.LoggerWithName(klog.Background(), "decisiondb")
+
+const (
+	bufferSize    = 10000
+	batchSize     = 500
+	flushInterval = time.Second
+
+	// openTimeout bounds how long Open waits for the file lock held by
+	// another process, e.g. a previous replica still shutting down.
+	openTimeout = 30 * time.Second
+)
+
+var (
+	decisionsBucket = []byte("decisions")
+	policyBucket    = []byte("policy")
+	namespaceBucket = []byte("namespace")
+)
+
+// Options configures how long decisions are kept.
+type Options struct {
+	// Retention is how long decisions are kept. Forever if 0.
+	Retention time.Duration
+	// MaxSize is the size in bytes the database is kept under by deleting
+	// the oldest decisions. Unlimited if 0.
+	MaxSize int64
+}
+
+// DB persists decisions in an embedded bbolt database so they survive
+// restarts. Decisions are keyed by time, and indexed by policy and
+// namespace. It is a decision.Sink; decisions are buffered and written in
+// batches, and dropped if the disk cannot keep up so admission is never
+// slowed down.
+type DB struct {
+	path string
+	opts Options
+
+	// lock guards db, which is replaced when the database is compacted.
+	lock sync.RWMutex
+	db   *bolt.DB
+
+	buffer  chan *decision.Decision
+	dropped atomic.Int64
+}
+
+// Open opens or creates the database at path.
+func Open(path string, opts Options) (*DB, error) {
+	db, err := open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &DB{path: path, opts: opts, db: db, buffer: make(chan *decision.Decision, bufferSize)}, nil
+}
+
+func open(path string) (*bolt.DB, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open decision database %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{decisionsBucket, policyBucket, namespaceBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func (d *DB) Record(dec *decision.Decision) {
+	select {
+	case d.buffer <- dec:
+	default:
+		d.dropped.Add(1)
+	}
+}
+
+// Run writes buffered decisions, and enforces the retention and size limit,
+// until ctx is cancelled, then closes the database.
+func (d *DB) Run(ctx context.Context) error {
+	logger.Info("persisting decisions", "path", d.path, "retention", d.opts.Retention, "maxSize", d.opts.MaxSize)
+	defer logger.Info("stopped persisting decisions")
+	defer func() {
+		d.lock.Lock()
+		defer d.lock.Unlock()
+		d.db.Close()
+	}()
+
+	d.maintain()
+	flush := time.NewTicker(flushInterval)
+	defer flush.Stop()
+	maintenance := time.NewTicker(maintenanceInterval)
+	defer maintenance.Stop()
+
+	var batch []*decision.Decision
+	write := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if dropped := d.dropped.Swap(0); dropped > 0 {
+			logger.Info("dropped decisions because the database could not keep up", "count", dropped)
+		}
+		if err := d.write(batch); err != nil {
+			logger.Error(err, "failed to persist decisions", "count", len(batch))
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			for len(d.buffer) > 0 {
+				batch = append(batch, <-d.buffer)
+			}
+			write()
+			return nil
+		case dec := <-d.buffer:
+			batch = append(batch, dec)
+			if len(batch) >= batchSize {
+				write()
+			}
+		case <-flush.C:
+			write()
+		case <-maintenance.C:
+			d.maintain()
+		}
+	}
+}
+
+func (d *DB) write(batch []*decision.Decision) error {
+	return d.db.Update(func(tx *bolt.Tx) error {
+		decisions := tx.Bucket(decisionsBucket)
+		for _, dec := range batch {
+			value, err := json.Marshal(dec)
+			if err != nil {
+				return err
+			}
+			key := timeKey(dec)
+			if err := decisions.Put(key, value); err != nil {
+				return err
+			}
+			if err := putIndexes(tx, dec, key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// indexes returns the index buckets and the index prefixes of dec.
+func indexes(dec *decision.Decision) map[string][]byte {
+	idx := map[string][]byte{}
+	if dec.Policy != "" {
+		idx[string(policyBucket)] = prefix(dec.Policy)
+	}
+	if dec.Namespace != "" {
+		idx[string(namespaceBucket)] = prefix(dec.Namespace)
+	}
+	return idx
+}
+
+func putIndexes(tx *bolt.Tx, dec *decision.Decision, key []byte) error {
+	for bucket, p := range indexes(dec) {
+		if err := tx.Bucket([]byte(bucket)).Put(append(p, key...), nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Query returns the decisions matching filter, most recent first. Decisions
+// are looked up through the policy or namespace index if the filter sets
+// them, and in the time range of the filter.
+func (d *DB) Query(filter decision.Filter) ([]*decision.Decision, error) {
+	bucket, p := decisionsBucket, []byte{}
+	switch {
+	case filter.Policy != "":
+		bucket, p = policyBucket, prefix(filter.Policy)
+	case filter.Namespace != "":
+		bucket, p = namespaceBucket, prefix(filter.Namespace)
+	}
+
+	lower := p
+	if !filter.Since.IsZero() {
+		lower = append(append([]byte{}, p...), timeBytes(filter.Since)...)
+	}
+	upper := append(append([]byte{}, p...), 0xff)
+	if !filter.Until.IsZero() {
+		upper = append(append(append([]byte{}, p...), timeBytes(filter.Until)...), 0xff)
+	}
+
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	res := []*decision.Decision{}
+	err := d.db.View(func(tx *bolt.Tx) error {
+		decisions := tx.Bucket(decisionsBucket)
+		c := tx.Bucket(bucket).Cursor()
+
+		k, v := c.Seek(upper)
+		if k == nil {
+			k, v = c.Last()
+		} else {
+			k, v = c.Prev()
+		}
+		for ; k != nil && bytes.HasPrefix(k, p) && bytes.Compare(k, lower) >= 0; k, v = c.Prev() {
+			if len(p) > 0 {
+				// Index entries have no value, read the decision they point to
+				v = decisions.Get(k[len(p):])
+				if v == nil {
+					continue
+				}
+			}
+			dec := &decision.Decision{}
+			if err := json.Unmarshal(v, dec); err != nil {
+				return err
+			}
+			if !filter.Matches(dec) {
+				continue
+			}
+			res = append(res, dec)
+			if filter.Limit > 0 && len(res) == filter.Limit {
+				break
+			}
+		}
+		return nil
+	})
+	return res, err
+}
+
+// prefix returns the index prefix of value. Values are terminated by a NUL
+// byte so one value is not the prefix of another.
+func prefix(value string) []byte {
+	return append([]byte(value), 0)
+}
+
+func timeBytes(t time.Time) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(t.UnixNano()))
+	return b
+}
+
+// timeKey returns the key of dec, its time followed by its ID.
+func timeKey(dec *decision.Decision) []byte {
+	return append(timeBytes(dec.Time), dec.ID...)
+}
@@ -0,0 +1,200 @@
+package decisiondb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/kubescape/kubeenforcer/pkg/decision"
+	"github.com/kubescape/kubeenforcer/pkg/metrics"
+)
+
+const (
+	maintenanceInterval = 5 * time.Minute
+
+	// sizeTarget is the fraction of the maximum size the database is
+	// shrunk to once it exceeds it, so deletions are not needed on every
+	// maintenance.
+	sizeTarget = 0.8
+
+	// compactMinSize is the file size below which free space is not worth
+	// reclaiming.
+	compactMinSize = 16 << 20
+
+	compactTxMaxSize = 64 << 20
+)
+
+var (
+	dbSizeBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "decision_db",
+		Name:      "size_bytes",
+		Help:      "Size of the decision database file.",
+	})
+
+	dbInUseBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "decision_db",
+		Name:      "in_use_bytes",
+		Help:      "Bytes of the decision database file holding data, the rest is reclaimed by compaction.",
+	})
+
+	dbDecisions = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "decision_db",
+		Name:      "decisions",
+		Help:      "Decisions stored in the decision database.",
+	})
+
+	dbDeleted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "decision_db",
+		Name:      "deleted_total",
+		Help:      "Decisions deleted from the decision database, by reason: age or size.",
+	}, []string{"reason"})
+
+	dbCompactions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "decision_db",
+		Name:      "compactions_total",
+		Help:      "Compactions of the decision database, by result: success or error.",
+	}, []string{"result"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(dbSizeBytes, dbInUseBytes, dbDecisions, dbDeleted, dbCompactions)
+}
+
+// maintain deletes decisions older than the retention, then the oldest
+// decisions while the database exceeds its maximum size, and compacts the
+// file once enough of it is free.
+func (d *DB) maintain() {
+	if d.opts.Retention > 0 {
+		cutoff := timeBytes(time.Now().Add(-d.opts.Retention))
+		d.delete("age", func(k []byte, _ int) bool { return bytes.Compare(k, cutoff) < 0 })
+	}
+
+	size, inUse, count, err := d.stats()
+	if err != nil {
+		logger.Error(err, "failed to read decision database stats")
+		return
+	}
+	if d.opts.MaxSize > 0 && inUse > d.opts.MaxSize && count > 0 {
+		// Decisions are about the same size, delete the share of them
+		// exceeding the target
+		excess := int(float64(count) * (1 - sizeTarget*float64(d.opts.MaxSize)/float64(inUse)))
+		d.delete("size", func(_ []byte, i int) bool { return i <= excess })
+	}
+
+	if size, inUse, count, err = d.stats(); err != nil {
+		logger.Error(err, "failed to read decision database stats")
+		return
+	}
+	if size >= compactMinSize && (size-inUse >= size/2 || (d.opts.MaxSize > 0 && size > d.opts.MaxSize)) {
+		if err := d.compact(); err != nil {
+			dbCompactions.WithLabelValues("error").Inc()
+			logger.Error(err, "failed to compact decision database")
+		} else {
+			dbCompactions.WithLabelValues("success").Inc()
+			logger.V(2).Info("compacted decision database", "before", size, "inUse", inUse)
+		}
+		if size, inUse, count, err = d.stats(); err != nil {
+			logger.Error(err, "failed to read decision database stats")
+			return
+		}
+	}
+
+	dbSizeBytes.Set(float64(size))
+	dbInUseBytes.Set(float64(inUse))
+	dbDecisions.Set(float64(count))
+}
+
+// delete deletes the oldest decisions for which expired returns true, given
+// their key and position starting at 1, stopping at the first it returns
+// false for.
+func (d *DB) delete(reason string, expired func(k []byte, i int) bool) {
+	deleted := 0
+	err := d.db.Update(func(tx *bolt.Tx) error {
+		decisions := tx.Bucket(decisionsBucket)
+		// Keys are collected first, deleting while iterating skips keys
+		var keys [][]byte
+		c := decisions.Cursor()
+		for k, _ := c.First(); k != nil && expired(k, len(keys)+1); k, _ = c.Next() {
+			keys = append(keys, append([]byte{}, k...))
+		}
+		for _, k := range keys {
+			var dec decision.Decision
+			if err := json.Unmarshal(decisions.Get(k), &dec); err == nil {
+				for bucket, p := range indexes(&dec) {
+					if err := tx.Bucket([]byte(bucket)).Delete(append(p, k...)); err != nil {
+						return err
+					}
+				}
+			}
+			if err := decisions.Delete(k); err != nil {
+				return err
+			}
+		}
+		deleted = len(keys)
+		return nil
+	})
+	if err != nil {
+		logger.Error(err, "failed to delete decisions", "reason", reason)
+		return
+	}
+	if deleted > 0 {
+		dbDeleted.WithLabelValues(reason).Add(float64(deleted))
+		logger.V(2).Info("deleted decisions", "reason", reason, "count", deleted)
+	}
+}
+
+// stats returns the size of the database file, the bytes of it holding data
+// and the number of decisions.
+func (d *DB) stats() (size, inUse int64, count int, err error) {
+	err = d.db.View(func(tx *bolt.Tx) error {
+		size = tx.Size()
+		count = tx.Bucket(decisionsBucket).Stats().KeyN
+		return nil
+	})
+	inUse = size - int64(d.db.Stats().FreeAlloc)
+	return size, inUse, count, err
+}
+
+// compact copies the database to a new file without its free pages, and
+// replaces the database with it. Queries wait while the files are swapped.
+func (d *DB) compact() error {
+	tmp := d.path + ".compact"
+	os.Remove(tmp)
+	dst, err := bolt.Open(tmp, 0600, &bolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return err
+	}
+	if err := bolt.Compact(dst, d.db, compactTxMaxSize); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if err := d.db.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	renameErr := os.Rename(tmp, d.path)
+	db, err := open(d.path)
+	if err != nil {
+		return fmt.Errorf("failed to reopen decision database: %w", err)
+	}
+	d.db = db
+	return renameErr
+}
@@ -0,0 +1,213 @@
+package decisionstream
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"k8s.io/klog/v2"
+
+	"github.com/kubescape/kubeenforcer/pkg/decision"
+	"github.com/kubescape/kubeenforcer/pkg/grpcjson"
+)
+
+var logger klog.Logger = klog.LoggerWithName(klog.Background(), "decisionstream")
+
+// The decision stream protocol is a single server-streaming RPC using the
+// JSON codec: the subscriber sends a Filter and receives every matching
+// decision.Decision until it disconnects.
+const (
+	serviceName = "kubeenforcer.decisions.v1.Decisions"
+	watchPath   = "/" + serviceName + "/Watch"
+)
+
+// subscriberBuffer is how many decisions a slow subscriber may fall behind
+// before decisions are dropped for it.
+const subscriberBuffer = 1000
+
+// Filter selects the decisions a subscriber receives. Empty fields match
+// everything.
+type Filter struct {
+	Namespaces []string `json:"namespaces,omitempty"`
+	Policies   []string `json:"policies,omitempty"`
+
+	// Actions is any of Allow, Deny or Audit.
+	Actions []string `json:"actions,omitempty"`
+}
+
+func (f *Filter) Matches(d *decision.Decision) bool {
+	if len(f.Namespaces) > 0 && !contains(f.Namespaces, d.Namespace) {
+		return false
+	}
+	if len(f.Policies) > 0 && !contains(f.Policies, d.Policy) {
+		return false
+	}
+	if len(f.Actions) > 0 {
+		for _, action := range f.Actions {
+			switch {
+			case action == "Allow" && d.Allowed,
+				action == "Deny" && !d.Allowed,
+				action == "Audit" && contains(d.Actions, "Audit"):
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func contains(values []string, v string) bool {
+	for _, s := range values {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Server fans decisions out to gRPC subscribers. It is a decision.Sink.
+type Server struct {
+	lock        sync.RWMutex
+	subscribers map[*subscriber]struct{}
+}
+
+type subscriber struct {
+	filter    Filter
+	decisions chan *decision.Decision
+	dropped   atomic.Int64
+}
+
+func NewServer() *Server {
+	return &Server{subscribers: map[*subscriber]struct{}{}}
+}
+
+func (s *Server) Record(d *decision.Decision) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	for sub := range s.subscribers {
+		if !sub.filter.Matches(d) {
+			continue
+		}
+		select {
+		case sub.decisions <- d:
+		default:
+			sub.dropped.Add(1)
+		}
+	}
+}
+
+// Serve accepts subscribers on l until ctx is cancelled. If tlsConfig is nil
+// the listener is served without TLS, which is only suitable for loopback or
+// unix socket listeners.
+func (s *Server) Serve(ctx context.Context, l net.Listener, tlsConfig *tls.Config) error {
+	var opts []grpc.ServerOption
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	server := grpc.NewServer(opts...)
+	server.RegisterService(&serviceDesc, s)
+	healthServer := registerHealth(server)
+
+	go func() {
+		<-ctx.Done()
+		healthServer.Shutdown()
+		server.Stop()
+	}()
+
+	logger.Info("serving decision stream", "addr", l.Addr().String())
+	if err := server.Serve(l); err != nil && ctx.Err() == nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+func (s *Server) watch(stream grpc.ServerStream) error {
+	var filter Filter
+	if err := stream.RecvMsg(&filter); err != nil {
+		return err
+	}
+
+	sub := &subscriber{
+		filter:    filter,
+		decisions: make(chan *decision.Decision, subscriberBuffer),
+	}
+
+	s.lock.Lock()
+	s.subscribers[sub] = struct{}{}
+	s.lock.Unlock()
+
+	defer func() {
+		s.lock.Lock()
+		delete(s.subscribers, sub)
+		s.lock.Unlock()
+
+		logger.V(2).Info("decision subscriber disconnected", "dropped", sub.dropped.Load())
+	}()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case d := <-sub.decisions:
+			if err := stream.SendMsg(d); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// watchServer is implemented by Server.
+type watchServer interface {
+	watch(stream grpc.ServerStream) error
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*watchServer)(nil),
+	Streams: []grpc.StreamDesc{{
+		StreamName:    "Watch",
+		ServerStreams: true,
+		Handler: func(srv interface{}, stream grpc.ServerStream) error {
+			return srv.(watchServer).watch(stream)
+		},
+	}},
+}
+
+// Watch subscribes to the decision stream served on conn and calls fn for
+// every decision matching filter until ctx is cancelled or the stream fails.
+func Watch(ctx context.Context, conn *grpc.ClientConn, filter Filter, fn func(*decision.Decision)) error {
+	stream, err := conn.NewStream(ctx, &serviceDesc.Streams[0], watchPath, grpc.CallContentSubtype(grpcjson.Name))
+	if err != nil {
+		return err
+	}
+	if err := stream.SendMsg(&filter); err != nil {
+		return err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return err
+	}
+
+	for {
+		var d decision.Decision
+		if err := stream.RecvMsg(&d); err != nil {
+			return err
+		}
+		fn(&d)
+	}
+}
+
+// registerHealth serves the grpc.health.v1 service on server, reporting the
+// decision stream service, and the server as a whole, as serving until shut down.
+func registerHealth(server *grpc.Server) *health.Server {
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus(serviceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(server, healthServer)
+	return healthServer
+}
@@ -0,0 +1,103 @@
+package deletionprotection
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apiserver/pkg/admission"
+	"k8s.io/klog/v2"
+)
+
+var logger klog.Logger = klog.LoggerWithName(klog.Background(), "deletionprotection")
+
+// ProtectedLabel protects the objects it is set to "true" on from deletion.
+const ProtectedLabel = "kubeenforcer.kubescape.io/protected"
+
+// DefaultResources are the resources protected by default: namespaces and
+// CRDs, whose deletion deletes everything they contain.
+var DefaultResources = []schema.GroupResource{
+	{Resource: "namespaces"},
+	{Group: "apiextensions.k8s.io", Resource: "customresourcedefinitions"},
+}
+
+// ParseResources parses comma separated resources, e.g.
+// namespaces,customresourcedefinitions.apiextensions.k8s.io.
+func ParseResources(s string) ([]schema.GroupResource, error) {
+	var resources []schema.GroupResource
+	for _, r := range strings.Split(s, ",") {
+		if r = strings.TrimSpace(r); r == "" {
+			continue
+		}
+		gr := schema.ParseGroupResource(r)
+		if gr.Resource == "" {
+			return nil, fmt.Errorf("invalid resource %q", r)
+		}
+		resources = append(resources, gr)
+	}
+	return resources, nil
+}
+
+// Validator denies the deletion of objects of the protected resources
+// labelled with ProtectedLabel=true, including as items of deletecollection
+// requests, which are admitted one by one. The label must be removed, which
+// can itself be restricted by policies, before the object can be deleted.
+type Validator struct {
+	resources map[schema.GroupResource]bool
+}
+
+// New creates a Validator protecting the objects of resources.
+func New(resources []schema.GroupResource) *Validator {
+	v := &Validator{resources: map[schema.GroupResource]bool{}}
+	for _, r := range resources {
+		v.resources[r] = true
+	}
+	return v
+}
+
+// Rules returns the webhook rules sending deletions of the protected
+// resources to the Validator.
+func (v *Validator) Rules() []admissionregistrationv1.RuleWithOperations {
+	resources := map[string][]string{}
+	for gr := range v.resources {
+		resources[gr.Group] = append(resources[gr.Group], gr.Resource)
+	}
+	rules := make([]admissionregistrationv1.RuleWithOperations, 0, len(resources))
+	for group, groupResources := range resources {
+		sort.Strings(groupResources)
+		rules = append(rules, admissionregistrationv1.RuleWithOperations{
+			Operations: []admissionregistrationv1.OperationType{admissionregistrationv1.Delete},
+			Rule: admissionregistrationv1.Rule{
+				APIGroups:   []string{group},
+				APIVersions: []string{"*"},
+				Resources:   groupResources,
+			},
+		})
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].APIGroups[0] < rules[j].APIGroups[0] })
+	return rules
+}
+
+func (v *Validator) Handles(operation admission.Operation) bool {
+	return operation == admission.Delete
+}
+
+func (v *Validator) Validate(ctx context.Context, a admission.Attributes, o admission.ObjectInterfaces) error {
+	if a.GetSubresource() != "" || !v.resources[a.GetResource().GroupResource()] || a.GetOldObject() == nil {
+		return nil
+	}
+	accessor, err := meta.Accessor(a.GetOldObject())
+	if err != nil {
+		return nil
+	}
+	if accessor.GetLabels()[ProtectedLabel] != "true" {
+		return nil
+	}
+
+	logger.V(2).Info("denied deletion of protected object", "resource", a.GetResource().Resource, "namespace", accessor.GetNamespace(), "name", accessor.GetName())
+	return admission.NewForbidden(a, fmt.Errorf("protected from deletion by the %s=true label, which must be removed first", ProtectedLabel))
+}
@@ -0,0 +1,279 @@
+package distribution
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"k8s.io/cel-admission-webhook/pkg/apis/admissionregistration.x-k8s.io/v1alpha1"
+	"k8s.io/cel-admission-webhook/pkg/generated/clientset/versioned"
+
+	"github.com/kubescape/kubeenforcer/pkg/errorbudget"
+	"github.com/kubescape/kubeenforcer/pkg/exception"
+	"github.com/kubescape/kubeenforcer/pkg/maintenance"
+)
+
+const (
+	// ManagedByLabel marks policies and bindings applied from a bundle.
+	// Only objects carrying it are updated or deleted by the agent.
+	ManagedByLabel = "kubeenforcer.kubescape.io/managed-by"
+	managedByValue = "policy-server"
+
+	// VersionAnnotation records the bundle version an object was applied from.
+	VersionAnnotation = "kubeenforcer.kubescape.io/bundle-version"
+)
+
+// controllerAnnotations are set on policies and bindings by kubeenforcer
+// controllers rather than by bundles.
+var controllerAnnotations = []string{
+	maintenance.WindowAnnotation,
+	exception.ExceptedNamespacesAnnotation,
+	errorbudget.DegradedAnnotation,
+	errorbudget.ErrorRateAnnotation,
+}
+
+// Agent polls a policy server, or an OPA bundle server, for the bundle
+// assigned to this cluster, verifies its signature and applies it.
+type Agent struct {
+	url      string
+	http     *http.Client
+	client   versioned.Interface
+	interval time.Duration
+
+	// fetch returns the bundle to apply, or nil if it has not changed, and
+	// the ETag to send once it is applied
+	fetch func(ctx context.Context) (*Bundle, string, error)
+
+	// Policy server
+	cluster string
+	labels  labels.Set
+	key     ed25519.PublicKey
+
+	// OPA bundle server
+	token       string
+	opaVerifier *OPAVerifier
+
+	etag string
+}
+
+// NewAgent creates an Agent fetching from the policy server at serverURL.
+// Bundles not signed by key are rejected.
+func NewAgent(serverURL, cluster string, clusterLabels labels.Set, key ed25519.PublicKey, httpClient *http.Client, client versioned.Interface, interval time.Duration) *Agent {
+	a := &Agent{
+		url:      serverURL,
+		cluster:  cluster,
+		labels:   clusterLabels,
+		key:      key,
+		http:     httpClient,
+		client:   client,
+		interval: interval,
+	}
+	a.fetch = a.fetchPolicyServer
+	return a
+}
+
+// NewOPAAgent creates an Agent polling the bundle at bundleURL from a server
+// implementing the OPA Bundle Service API, authenticating with token unless
+// empty. Bundles are verified with verifier unless it is nil.
+func NewOPAAgent(bundleURL, token string, verifier *OPAVerifier, httpClient *http.Client, client versioned.Interface, interval time.Duration) *Agent {
+	a := &Agent{
+		url:         bundleURL,
+		token:       token,
+		opaVerifier: verifier,
+		http:        httpClient,
+		client:      client,
+		interval:    interval,
+	}
+	a.fetch = a.fetchOPABundle
+	return a
+}
+
+// Run polls the policy server until ctx is cancelled.
+func (a *Agent) Run(ctx context.Context) error {
+	logger.Info("starting policy distribution agent", "server", a.url)
+	defer logger.Info("stopped policy distribution agent")
+
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if err := a.sync(ctx); err != nil {
+			logger.Error(err, "failed to sync policy bundle")
+		}
+	}, a.interval)
+	return nil
+}
+
+func (a *Agent) sync(ctx context.Context) error {
+	bundle, etag, err := a.fetch(ctx)
+	if err != nil || bundle == nil {
+		return err
+	}
+
+	if err := a.apply(ctx, bundle); err != nil {
+		return fmt.Errorf("applying bundle %s: %w", bundle.Version, err)
+	}
+	logger.Info("applied policy bundle", "version", bundle.Version, "policies", len(bundle.Policies), "bindings", len(bundle.Bindings))
+	a.etag = etag
+	return nil
+}
+
+// fetchPolicyServer fetches the bundle assigned to this cluster from a
+// kubeenforcer policy server.
+func (a *Agent) fetchPolicyServer(ctx context.Context) (*Bundle, string, error) {
+	query := url.Values{}
+	query.Set("cluster", a.cluster)
+	query.Set("labels", a.labels.String())
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.url+"/v1/bundle?"+query.Encode(), nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if a.etag != "" {
+		req.Header.Set("If-None-Match", a.etag)
+	}
+
+	resp, err := a.http.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return nil, "", nil
+	default:
+		return nil, "", fmt.Errorf("policy server returned %s", resp.Status)
+	}
+
+	signed := &SignedBundle{}
+	if err := json.NewDecoder(resp.Body).Decode(signed); err != nil {
+		return nil, "", err
+	}
+	bundle, err := Verify(signed, a.key)
+	if err != nil {
+		return nil, "", err
+	}
+	return bundle, fmt.Sprintf("%q", bundle.Version), nil
+}
+
+// apply creates or updates every object in the bundle and deletes managed
+// objects that are no longer part of it.
+func (a *Agent) apply(ctx context.Context, bundle *Bundle) error {
+	policies := a.client.AdmissionregistrationV1alpha1().ValidatingAdmissionPolicies()
+	bindings := a.client.AdmissionregistrationV1alpha1().ValidatingAdmissionPolicyBindings()
+	selector := labels.SelectorFromSet(labels.Set{ManagedByLabel: managedByValue}).String()
+
+	wanted := map[string]bool{}
+	for i := range bundle.Policies {
+		desired := bundle.Policies[i].DeepCopy()
+		markManaged(&desired.ObjectMeta, bundle.Version)
+		wanted[desired.Name] = true
+
+		existing, err := policies.Get(ctx, desired.Name, metav1.GetOptions{})
+		if k8serrors.IsNotFound(err) {
+			_, err = policies.Create(ctx, desired, metav1.CreateOptions{})
+		} else if err == nil {
+			if !isManaged(existing.ObjectMeta) {
+				return fmt.Errorf("policy %s exists and is not managed by the policy server", desired.Name)
+			}
+			keepAnnotations(&desired.ObjectMeta, existing.ObjectMeta)
+			desired.ResourceVersion = existing.ResourceVersion
+			_, err = policies.Update(ctx, desired, metav1.UpdateOptions{})
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	existingPolicies, err := policies.List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return err
+	}
+	var stalePolicies []v1alpha1.ValidatingAdmissionPolicy
+	for _, p := range existingPolicies.Items {
+		if !wanted[p.Name] {
+			stalePolicies = append(stalePolicies, p)
+		}
+	}
+
+	wanted = map[string]bool{}
+	for i := range bundle.Bindings {
+		desired := bundle.Bindings[i].DeepCopy()
+		markManaged(&desired.ObjectMeta, bundle.Version)
+		wanted[desired.Name] = true
+
+		existing, err := bindings.Get(ctx, desired.Name, metav1.GetOptions{})
+		if k8serrors.IsNotFound(err) {
+			_, err = bindings.Create(ctx, desired, metav1.CreateOptions{})
+		} else if err == nil {
+			if !isManaged(existing.ObjectMeta) {
+				return fmt.Errorf("binding %s exists and is not managed by the policy server", desired.Name)
+			}
+			keepAnnotations(&desired.ObjectMeta, existing.ObjectMeta)
+			desired.ResourceVersion = existing.ResourceVersion
+			_, err = bindings.Update(ctx, desired, metav1.UpdateOptions{})
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// Delete stale bindings before stale policies so a policy is never left
+	// bound while being removed
+	existingBindings, err := bindings.List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return err
+	}
+	for _, b := range existingBindings.Items {
+		if wanted[b.Name] {
+			continue
+		}
+		if err := bindings.Delete(ctx, b.Name, metav1.DeleteOptions{}); err != nil && !k8serrors.IsNotFound(err) {
+			return err
+		}
+	}
+	for _, p := range stalePolicies {
+		if err := policies.Delete(ctx, p.Name, metav1.DeleteOptions{}); err != nil && !k8serrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func markManaged(meta *metav1.ObjectMeta, version string) {
+	meta.ResourceVersion = ""
+	meta.UID = ""
+	if meta.Labels == nil {
+		meta.Labels = map[string]string{}
+	}
+	meta.Labels[ManagedByLabel] = managedByValue
+	if meta.Annotations == nil {
+		meta.Annotations = map[string]string{}
+	}
+	meta.Annotations[VersionAnnotation] = version
+}
+
+// keepAnnotations copies the annotations of kubeenforcer controllers on
+// existing that desired does not set, such as those of maintenance windows
+// and policy exceptions, so applying a bundle does not undo them.
+func keepAnnotations(desired *metav1.ObjectMeta, existing metav1.ObjectMeta) {
+	for _, key := range controllerAnnotations {
+		value, ok := existing.Annotations[key]
+		if _, set := desired.Annotations[key]; !ok || set {
+			continue
+		}
+		desired.Annotations[key] = value
+	}
+}
+
+func isManaged(meta metav1.ObjectMeta) bool {
+	return meta.Labels[ManagedByLabel] == managedByValue
+}
@@ -0,0 +1,76 @@
+package distribution
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"k8s.io/cel-admission-webhook/pkg/apis/admissionregistration.x-k8s.io/v1alpha1"
+	"k8s.io/cel-admission-webhook/pkg/generated/clientset/versioned/fake"
+)
+
+func TestApplyKeepsAnnotations(t *testing.T) {
+	managed := map[string]string{ManagedByLabel: managedByValue}
+	existing := &v1alpha1.ValidatingAdmissionPolicyBinding{ObjectMeta: metav1.ObjectMeta{
+		Name:   "deny-exec",
+		Labels: managed,
+		Annotations: map[string]string{
+			VersionAnnotation: "v1",
+			"kubeenforcer.kubescape.io/maintenance-windows": "weekly",
+			"kubeenforcer.kubescape.io/excepted-namespaces": "payments",
+			"kubeenforcer.kubescape.io/owner":               "platform",
+			"kubeenforcer.kubescape.io/auto-remediate":      "true",
+		},
+	}}
+	policy := &v1alpha1.ValidatingAdmissionPolicy{ObjectMeta: metav1.ObjectMeta{
+		Name:   "deny-exec",
+		Labels: managed,
+		Annotations: map[string]string{
+			"kubeenforcer.kubescape.io/degraded-since": "2024-03-01T12:00:00Z",
+			"kubeenforcer.kubescape.io/variables":      "[]",
+		},
+	}}
+	client := fake.NewSimpleClientset(existing, policy)
+	a := &Agent{client: client}
+
+	bundle := &Bundle{
+		Version:  "v2",
+		Policies: []v1alpha1.ValidatingAdmissionPolicy{{ObjectMeta: metav1.ObjectMeta{Name: "deny-exec"}}},
+		Bindings: []v1alpha1.ValidatingAdmissionPolicyBinding{{ObjectMeta: metav1.ObjectMeta{
+			Name:        "deny-exec",
+			Annotations: map[string]string{"kubeenforcer.kubescape.io/owner": "security"},
+		}}},
+	}
+	if err := a.apply(context.Background(), bundle); err != nil {
+		t.Fatalf("apply() error = %v", err)
+	}
+
+	binding, err := client.AdmissionregistrationV1alpha1().ValidatingAdmissionPolicyBindings().Get(context.Background(), "deny-exec", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		VersionAnnotation: "v2",
+		"kubeenforcer.kubescape.io/maintenance-windows": "weekly",
+		"kubeenforcer.kubescape.io/excepted-namespaces": "payments",
+		// Other annotations are the bundle's
+		"kubeenforcer.kubescape.io/owner": "security",
+	}
+	if !reflect.DeepEqual(binding.Annotations, want) {
+		t.Errorf("binding annotations = %v, want %v", binding.Annotations, want)
+	}
+
+	updated, err := client.AdmissionregistrationV1alpha1().ValidatingAdmissionPolicies().Get(context.Background(), "deny-exec", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = map[string]string{
+		VersionAnnotation:                          "v2",
+		"kubeenforcer.kubescape.io/degraded-since": "2024-03-01T12:00:00Z",
+	}
+	if !reflect.DeepEqual(updated.Annotations, want) {
+		t.Errorf("policy annotations = %v, want %v", updated.Annotations, want)
+	}
+}
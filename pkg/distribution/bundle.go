@@ -0,0 +1,201 @@
+package distribution
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/klog/v2"
+
+	"k8s.io/cel-admission-webhook/pkg/apis/admissionregistration.x-k8s.io/v1alpha1"
+)
+
+var logger klog.Logger = klog.LoggerWithName(klog.Background(), "distribution")
+
+// Bundle is a versioned set of policies and bindings distributed to agents.
+type Bundle struct {
+	Version  string                                      `json:"version"`
+	Created  time.Time                                   `json:"created"`
+	Policies []v1alpha1.ValidatingAdmissionPolicy        `json:"policies,omitempty"`
+	Bindings []v1alpha1.ValidatingAdmissionPolicyBinding `json:"bindings,omitempty"`
+}
+
+// SignedBundle is a Bundle as served to agents. Signature is the ed25519
+// signature of the exact Bundle bytes, so agents verify before decoding.
+type SignedBundle struct {
+	Bundle    json.RawMessage `json:"bundle"`
+	Signature []byte          `json:"signature"`
+}
+
+// Sign encodes and signs bundle with key.
+func Sign(bundle *Bundle, key ed25519.PrivateKey) (*SignedBundle, error) {
+	raw, err := json.Marshal(bundle)
+	if err != nil {
+		return nil, err
+	}
+	return &SignedBundle{Bundle: raw, Signature: ed25519.Sign(key, raw)}, nil
+}
+
+// Verify checks the signature of signed against key and decodes the bundle.
+func Verify(signed *SignedBundle, key ed25519.PublicKey) (*Bundle, error) {
+	if !ed25519.Verify(key, signed.Bundle, signed.Signature) {
+		return nil, errors.New("bundle signature verification failed")
+	}
+	bundle := &Bundle{}
+	if err := json.Unmarshal(signed.Bundle, bundle); err != nil {
+		return nil, err
+	}
+	return bundle, nil
+}
+
+// LoadBundle reads every YAML or JSON manifest in dir into a Bundle. Objects
+// other than ValidatingAdmissionPolicies and their bindings are rejected.
+func LoadBundle(version, dir string) (*Bundle, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	bundle := &Bundle{Version: version, Created: time.Now()}
+	for _, file := range files {
+		switch filepath.Ext(file) {
+		case ".yaml", ".yml", ".json":
+		default:
+			continue
+		}
+		if err := loadManifests(bundle, file, true); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+	}
+	return bundle, nil
+}
+
+// LoadFile reads the policies and bindings in a single YAML or JSON file into
+// a Bundle. Unlike LoadBundle, other objects such as policy parameters are
+// skipped.
+func LoadFile(version, file string) (*Bundle, error) {
+	bundle := &Bundle{Version: version, Created: time.Now()}
+	if err := loadManifests(bundle, file, false); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return bundle, nil
+}
+
+// loadManifests adds the policies and bindings in file to bundle. Other
+// objects are rejected if strict, and skipped otherwise.
+func loadManifests(bundle *Bundle, file string, strict bool) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return decodeManifests(bundle, f, strict)
+}
+
+// decodeManifests adds the policies and bindings in the YAML or JSON
+// documents read from r to bundle. Other documents are rejected if strict,
+// and skipped otherwise.
+func decodeManifests(bundle *Bundle, r io.Reader, strict bool) error {
+	decoder := yaml.NewYAMLOrJSONDecoder(bufio.NewReader(r), 4096)
+	for {
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if len(raw) == 0 || string(raw) == "null" {
+			continue
+		}
+
+		var typeMeta metav1.TypeMeta
+		if err := json.Unmarshal(raw, &typeMeta); err != nil {
+			if !strict {
+				continue
+			}
+			return err
+		}
+		if typeMeta.APIVersion != v1alpha1.GroupVersion.String() {
+			if !strict {
+				continue
+			}
+			return fmt.Errorf("unsupported apiVersion %q", typeMeta.APIVersion)
+		}
+
+		switch typeMeta.Kind {
+		case "ValidatingAdmissionPolicy":
+			var policy v1alpha1.ValidatingAdmissionPolicy
+			if err := json.Unmarshal(raw, &policy); err != nil {
+				return err
+			}
+			bundle.Policies = append(bundle.Policies, policy)
+		case "ValidatingAdmissionPolicyBinding":
+			var binding v1alpha1.ValidatingAdmissionPolicyBinding
+			if err := json.Unmarshal(raw, &binding); err != nil {
+				return err
+			}
+			bundle.Bindings = append(bundle.Bindings, binding)
+		default:
+			if !strict {
+				continue
+			}
+			return fmt.Errorf("unsupported kind %q", typeMeta.Kind)
+		}
+	}
+}
+
+// LoadPrivateKey reads a PEM encoded PKCS#8 ed25519 private key.
+func LoadPrivateKey(file string) (ed25519.PrivateKey, error) {
+	der, err := readPEM(file)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	if k, ok := key.(ed25519.PrivateKey); ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("%s is not an ed25519 private key", file)
+}
+
+// LoadPublicKey reads a PEM encoded PKIX ed25519 public key.
+func LoadPublicKey(file string) (ed25519.PublicKey, error) {
+	der, err := readPEM(file)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, err
+	}
+	if k, ok := key.(ed25519.PublicKey); ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("%s is not an ed25519 public key", file)
+}
+
+func readPEM(file string) ([]byte, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", file)
+	}
+	return block.Bytes, nil
+}
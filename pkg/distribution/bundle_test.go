@@ -0,0 +1,206 @@
+package distribution
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"k8s.io/cel-admission-webhook/pkg/apis/admissionregistration.x-k8s.io/v1alpha1"
+)
+
+func newKey(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestSignVerify(t *testing.T) {
+	key, other := newKey(t), newKey(t)
+	bundle := &Bundle{
+		Version:  "v1",
+		Created:  time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Policies: []v1alpha1.ValidatingAdmissionPolicy{{ObjectMeta: metav1.ObjectMeta{Name: "deny-exec"}}},
+		Bindings: []v1alpha1.ValidatingAdmissionPolicyBinding{{ObjectMeta: metav1.ObjectMeta{Name: "deny-exec"}}},
+	}
+
+	tests := []struct {
+		name    string
+		tamper  func(*SignedBundle)
+		key     ed25519.PublicKey
+		wantErr bool
+	}{
+		{
+			name:   "signed bundle",
+			tamper: func(*SignedBundle) {},
+			key:    key.Public().(ed25519.PublicKey),
+		},
+		{
+			name:    "other key",
+			tamper:  func(*SignedBundle) {},
+			key:     other.Public().(ed25519.PublicKey),
+			wantErr: true,
+		},
+		{
+			name: "modified bundle",
+			tamper: func(s *SignedBundle) {
+				s.Bundle = json.RawMessage(strings.Replace(string(s.Bundle), "deny-exec", "deny-all", 1))
+			},
+			key:     key.Public().(ed25519.PublicKey),
+			wantErr: true,
+		},
+		{
+			name:    "missing signature",
+			tamper:  func(s *SignedBundle) { s.Signature = nil },
+			key:     key.Public().(ed25519.PublicKey),
+			wantErr: true,
+		},
+		{
+			name: "signed bundle that is not JSON",
+			tamper: func(s *SignedBundle) {
+				s.Bundle = json.RawMessage("not json")
+				s.Signature = ed25519.Sign(key, s.Bundle)
+			},
+			key:     key.Public().(ed25519.PublicKey),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signed, err := Sign(bundle, key)
+			if err != nil {
+				t.Fatalf("Sign() error = %v", err)
+			}
+			// Bundles are verified as received, after a round trip
+			data, err := json.Marshal(signed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			received := &SignedBundle{}
+			if err := json.Unmarshal(data, received); err != nil {
+				t.Fatal(err)
+			}
+			tt.tamper(received)
+
+			got, err := Verify(received, tt.key)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Verify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(got, bundle) {
+				t.Errorf("Verify() = %+v, want %+v", got, bundle)
+			}
+		})
+	}
+}
+
+func TestDecodeManifests(t *testing.T) {
+	const policy = `apiVersion: admissionregistration.x-k8s.io/v1alpha1
+kind: ValidatingAdmissionPolicy
+metadata:
+  name: deny-exec
+`
+	const binding = `apiVersion: admissionregistration.x-k8s.io/v1alpha1
+kind: ValidatingAdmissionPolicyBinding
+metadata:
+  name: deny-exec-binding
+`
+	const configMap = `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: params
+`
+
+	tests := []struct {
+		name         string
+		manifests    string
+		strict       bool
+		wantPolicies []string
+		wantBindings []string
+		wantErr      bool
+	}{
+		{
+			name:      "empty",
+			manifests: "",
+			strict:    true,
+		},
+		{
+			name:         "policy and binding",
+			manifests:    policy + "---\n" + binding,
+			strict:       true,
+			wantPolicies: []string{"deny-exec"},
+			wantBindings: []string{"deny-exec-binding"},
+		},
+		{
+			name:         "empty documents are skipped",
+			manifests:    "---\n" + policy + "---\n---\n",
+			strict:       true,
+			wantPolicies: []string{"deny-exec"},
+		},
+		{
+			name:         "JSON",
+			manifests:    `{"apiVersion": "admissionregistration.x-k8s.io/v1alpha1", "kind": "ValidatingAdmissionPolicy", "metadata": {"name": "deny-exec"}}`,
+			strict:       true,
+			wantPolicies: []string{"deny-exec"},
+		},
+		{
+			name:      "other objects are rejected if strict",
+			manifests: policy + "---\n" + configMap,
+			strict:    true,
+			wantErr:   true,
+		},
+		{
+			name:         "other objects are skipped otherwise",
+			manifests:    configMap + "---\n" + policy,
+			wantPolicies: []string{"deny-exec"},
+		},
+		{
+			name:      "other kinds of the group are rejected if strict",
+			manifests: "apiVersion: admissionregistration.x-k8s.io/v1alpha1\nkind: ParamKind\n",
+			strict:    true,
+			wantErr:   true,
+		},
+		{
+			name:      "invalid YAML",
+			manifests: "kind: [",
+			wantErr:   true,
+		},
+		{
+			name:      "invalid policy",
+			manifests: policy + "spec: []\n",
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bundle := &Bundle{}
+			err := decodeManifests(bundle, strings.NewReader(tt.manifests), tt.strict)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeManifests() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			var policies, bindings []string
+			for _, p := range bundle.Policies {
+				policies = append(policies, p.Name)
+			}
+			for _, b := range bundle.Bindings {
+				bindings = append(bindings, b.Name)
+			}
+			if !reflect.DeepEqual(policies, tt.wantPolicies) {
+				t.Errorf("decodeManifests() policies = %v, want %v", policies, tt.wantPolicies)
+			}
+			if !reflect.DeepEqual(bindings, tt.wantBindings) {
+				t.Errorf("decodeManifests() bindings = %v, want %v", bindings, tt.wantBindings)
+			}
+		})
+	}
+}
@@ -0,0 +1,353 @@
+package distribution
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math/big"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	opaManifestFile   = ".manifest"
+	opaSignaturesFile = ".signatures.json"
+
+	// maxOPABundleSize caps the uncompressed size of OPA bundles.
+	maxOPABundleSize = 64 << 20
+)
+
+// OPAVerifier verifies the signatures of OPA bundles, as produced by
+// opa build --signing-key.
+type OPAVerifier struct {
+	key   crypto.PublicKey
+	keyID string
+	scope string
+}
+
+// LoadOPAVerifier reads a PEM encoded PKIX RSA or ECDSA public key, for the
+// RS*, PS* and ES* signing algorithms. Signatures must name keyID and scope
+// if they are set.
+func LoadOPAVerifier(file, keyID, scope string) (*OPAVerifier, error) {
+	der, err := readPEM(file)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, err
+	}
+	switch key.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+	default:
+		return nil, fmt.Errorf("%s is not an RSA or ECDSA public key", file)
+	}
+	return &OPAVerifier{key: key, keyID: keyID, scope: scope}, nil
+}
+
+type opaSignatures struct {
+	Signatures []string `json:"signatures"`
+}
+
+type opaSignedFiles struct {
+	Files []struct {
+		Name      string `json:"name"`
+		Hash      string `json:"hash"`
+		Algorithm string `json:"algorithm"`
+	} `json:"files"`
+	KeyID string `json:"keyid"`
+	Scope string `json:"scope"`
+}
+
+// verify checks the JWS in the signatures file of an OPA bundle, and that
+// it lists exactly the files of the bundle with their hashes.
+func (v *OPAVerifier) verify(signatures []byte, files map[string][]byte) error {
+	var s opaSignatures
+	if err := json.Unmarshal(signatures, &s); err != nil {
+		return fmt.Errorf("invalid %s: %w", opaSignaturesFile, err)
+	}
+	if len(s.Signatures) != 1 {
+		return fmt.Errorf("%s must contain exactly one signature", opaSignaturesFile)
+	}
+
+	payload, err := v.verifyJWS(s.Signatures[0])
+	if err != nil {
+		return err
+	}
+	var signed opaSignedFiles
+	if err := json.Unmarshal(payload, &signed); err != nil {
+		return fmt.Errorf("invalid signature payload: %w", err)
+	}
+	if v.keyID != "" && signed.KeyID != "" && signed.KeyID != v.keyID {
+		return fmt.Errorf("bundle signed with key %q, expected %q", signed.KeyID, v.keyID)
+	}
+	if v.scope != "" && signed.Scope != v.scope {
+		return fmt.Errorf("bundle signed for scope %q, expected %q", signed.Scope, v.scope)
+	}
+
+	listed := map[string]bool{}
+	for _, f := range signed.Files {
+		name := strings.TrimPrefix(f.Name, "/")
+		content, ok := files[name]
+		if !ok {
+			return fmt.Errorf("signed file %s is missing from the bundle", f.Name)
+		}
+		sum, err := opaFileHash(name, content, f.Algorithm)
+		if err != nil {
+			return err
+		}
+		if sum != f.Hash {
+			return fmt.Errorf("hash of %s does not match its signature", f.Name)
+		}
+		listed[name] = true
+	}
+	for name := range files {
+		if !listed[name] {
+			return fmt.Errorf("file %s is not signed", name)
+		}
+	}
+	return nil
+}
+
+// verifyJWS verifies a compact JWS and returns its payload.
+func (v *OPAVerifier) verifyJWS(token string) ([]byte, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed bundle signature")
+	}
+	rawHeader, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("malformed bundle signature header: %w", err)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := json.Unmarshal(rawHeader, &header); err != nil {
+		return nil, fmt.Errorf("malformed bundle signature header: %w", err)
+	}
+	if v.keyID != "" && header.Kid != "" && header.Kid != v.keyID {
+		return nil, fmt.Errorf("bundle signed with key %q, expected %q", header.Kid, v.keyID)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed bundle signature: %w", err)
+	}
+
+	if len(header.Alg) != 5 {
+		return nil, fmt.Errorf("unsupported bundle signing algorithm %q", header.Alg)
+	}
+	var hashFunc crypto.Hash
+	switch header.Alg[2:] {
+	case "256":
+		hashFunc = crypto.SHA256
+	case "384":
+		hashFunc = crypto.SHA384
+	case "512":
+		hashFunc = crypto.SHA512
+	default:
+		return nil, fmt.Errorf("unsupported bundle signing algorithm %q", header.Alg)
+	}
+	h := hashFunc.New()
+	h.Write([]byte(parts[0] + "." + parts[1]))
+	digest := h.Sum(nil)
+
+	switch key := v.key.(type) {
+	case *rsa.PublicKey:
+		switch header.Alg[:2] {
+		case "RS":
+			err = rsa.VerifyPKCS1v15(key, hashFunc, digest, signature)
+		case "PS":
+			err = rsa.VerifyPSS(key, hashFunc, digest, signature, nil)
+		default:
+			err = fmt.Errorf("algorithm %q does not use an RSA key", header.Alg)
+		}
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if header.Alg[:2] != "ES" {
+			err = fmt.Errorf("algorithm %q does not use an ECDSA key", header.Alg)
+		} else if len(signature) != 2*size {
+			err = errors.New("malformed ECDSA signature")
+		} else if !ecdsa.Verify(key, digest, new(big.Int).SetBytes(signature[:size]), new(big.Int).SetBytes(signature[size:])) {
+			err = errors.New("verification error")
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("bundle signature verification failed: %w", err)
+	}
+
+	return base64.RawURLEncoding.DecodeString(parts[1])
+}
+
+// opaFileHash hashes a bundle file as OPA does: JSON files are hashed in
+// their canonical encoding, with sorted keys and no whitespace, and other
+// files as is.
+func opaFileHash(name string, content []byte, algorithm string) (string, error) {
+	var h hash.Hash
+	switch algorithm {
+	case "", "SHA-256":
+		h = sha256.New()
+	case "SHA-384":
+		h = sha512.New384()
+	case "SHA-512":
+		h = sha512.New()
+	default:
+		return "", fmt.Errorf("unsupported hash algorithm %q for %s", algorithm, name)
+	}
+
+	if path.Ext(name) == ".json" || path.Base(name) == opaManifestFile {
+		decoder := json.NewDecoder(bytes.NewReader(content))
+		decoder.UseNumber()
+		var value interface{}
+		if err := decoder.Decode(&value); err != nil {
+			return "", fmt.Errorf("%s: %w", name, err)
+		}
+		canonical, err := json.Marshal(value)
+		if err != nil {
+			return "", err
+		}
+		content = canonical
+	}
+	h.Write(content)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ReadOPABundle reads the policies and bindings in the YAML and JSON files
+// of a gzipped tar OPA bundle, verifying it with verifier unless nil. Other
+// files, such as Rego modules, are ignored. The bundle version is the
+// revision of its manifest, or version if it has none.
+func ReadOPABundle(r io.Reader, version string, verifier *OPAVerifier) (*Bundle, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	files := map[string][]byte{}
+	var signatures []byte
+	var size int64
+	archive := tar.NewReader(gz)
+	for {
+		header, err := archive.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		size += header.Size
+		if size > maxOPABundleSize {
+			return nil, fmt.Errorf("bundle exceeds %d bytes", maxOPABundleSize)
+		}
+		content, err := io.ReadAll(archive)
+		if err != nil {
+			return nil, err
+		}
+
+		name := strings.TrimPrefix(path.Clean("/"+header.Name), "/")
+		if name == opaSignaturesFile {
+			signatures = content
+			continue
+		}
+		files[name] = content
+	}
+
+	if verifier != nil {
+		if signatures == nil {
+			return nil, fmt.Errorf("bundle is not signed")
+		}
+		if err := verifier.verify(signatures, files); err != nil {
+			return nil, err
+		}
+	}
+
+	if manifest, ok := files[opaManifestFile]; ok {
+		var m struct {
+			Revision string `json:"revision"`
+		}
+		if err := json.Unmarshal(manifest, &m); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", opaManifestFile, err)
+		}
+		if m.Revision != "" {
+			version = m.Revision
+		}
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	bundle := &Bundle{Version: version, Created: time.Now()}
+	for _, name := range names {
+		switch path.Ext(name) {
+		case ".yaml", ".yml", ".json":
+		default:
+			continue
+		}
+		if path.Base(name) == opaManifestFile {
+			continue
+		}
+		if err := decodeManifests(bundle, bytes.NewReader(files[name]), false); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return bundle, nil
+}
+
+// fetchOPABundle downloads the bundle at the agent's URL from an OPA bundle
+// server. It returns a nil bundle if it has not changed since the last one
+// applied.
+func (a *Agent) fetchOPABundle(ctx context.Context) (*Bundle, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.url, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if a.etag != "" {
+		req.Header.Set("If-None-Match", a.etag)
+	}
+	if a.token != "" {
+		req.Header.Set("Authorization", "Bearer "+a.token)
+	}
+
+	resp, err := a.http.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return nil, "", nil
+	default:
+		return nil, "", fmt.Errorf("bundle server returned %s", resp.Status)
+	}
+
+	etag := resp.Header.Get("ETag")
+	bundle, err := ReadOPABundle(resp.Body, strings.Trim(etag, `"`), a.opaVerifier)
+	if err != nil {
+		return nil, "", err
+	}
+	return bundle, etag, nil
+}
@@ -0,0 +1,189 @@
+package distribution
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/yaml"
+)
+
+// Config describes the bundles served by a Server and which clusters receive
+// which bundle.
+//
+//	bundles:
+//	- version: v2
+//	  path: bundles/v2
+//	- version: v1
+//	  path: bundles/v1
+//	stages:
+//	- name: canary
+//	  selector:
+//	    matchLabels:
+//	      env: staging
+//	  version: v2
+//	- name: everyone
+//	  version: v1
+type Config struct {
+	Bundles []BundleSource `json:"bundles"`
+	Stages  []Stage        `json:"stages"`
+}
+
+// BundleSource is a directory of policy manifests making up one bundle
+// version. Relative paths are resolved against the config file.
+type BundleSource struct {
+	Version string `json:"version"`
+	Path    string `json:"path"`
+}
+
+// Stage assigns a bundle version to the clusters whose labels match
+// Selector. Stages are evaluated in order and the first match wins; an empty
+// selector matches every cluster.
+type Stage struct {
+	Name     string                `json:"name"`
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+	Version  string                `json:"version"`
+}
+
+type stage struct {
+	name     string
+	selector labels.Selector
+	version  string
+}
+
+// Server serves signed policy bundles to agents over HTTPS.
+type Server struct {
+	key ed25519.PrivateKey
+
+	lock    sync.RWMutex
+	bundles map[string]*servedBundle
+	stages  []stage
+}
+
+// servedBundle is a signed bundle with its ETag, the hash of its signed
+// bytes and signature, so agents fetch it again whenever it changes, even
+// under the same version.
+type servedBundle struct {
+	signed *SignedBundle
+	etag   string
+}
+
+func newServedBundle(signed *SignedBundle) *servedBundle {
+	h := sha256.New()
+	h.Write(signed.Bundle)
+	h.Write(signed.Signature)
+	return &servedBundle{signed: signed, etag: `"` + hex.EncodeToString(h.Sum(nil)) + `"`}
+}
+
+func NewServer(key ed25519.PrivateKey) *Server {
+	return &Server{key: key}
+}
+
+// Load reads the config file and the bundles it references, signs them and
+// replaces what is being served. On error the previous config stays active.
+func (s *Server) Load(configFile string) error {
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		return err
+	}
+	config := Config{}
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return err
+	}
+
+	bundles := map[string]*servedBundle{}
+	for _, source := range config.Bundles {
+		path := source.Path
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(filepath.Dir(configFile), path)
+		}
+		bundle, err := LoadBundle(source.Version, path)
+		if err != nil {
+			return fmt.Errorf("bundle %s: %w", source.Version, err)
+		}
+		signed, err := Sign(bundle, s.key)
+		if err != nil {
+			return fmt.Errorf("bundle %s: %w", source.Version, err)
+		}
+		bundles[source.Version] = newServedBundle(signed)
+	}
+
+	var stages []stage
+	for _, st := range config.Stages {
+		if _, ok := bundles[st.Version]; !ok {
+			return fmt.Errorf("stage %s references unknown bundle version %q", st.Name, st.Version)
+		}
+		selector := labels.Everything()
+		if st.Selector != nil {
+			if selector, err = metav1.LabelSelectorAsSelector(st.Selector); err != nil {
+				return fmt.Errorf("stage %s: %w", st.Name, err)
+			}
+		}
+		stages = append(stages, stage{name: st.Name, selector: selector, version: st.Version})
+	}
+
+	s.lock.Lock()
+	s.bundles = bundles
+	s.stages = stages
+	s.lock.Unlock()
+
+	logger.Info("loaded policy bundles", "bundles", len(bundles), "stages", len(stages))
+	return nil
+}
+
+// bundleFor returns the bundle assigned to a cluster with the given labels.
+func (s *Server) bundleFor(clusterLabels labels.Set) (string, string, *servedBundle) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	for _, st := range s.stages {
+		if st.selector.Matches(clusterLabels) {
+			return st.name, st.version, s.bundles[st.version]
+		}
+	}
+	return "", "", nil
+}
+
+// Handler serves the bundle assigned to the requesting cluster:
+//
+//	/v1/bundle?cluster=x&labels=env%3Dprod,region%3Deu
+//
+// The hash of the signed bundle is returned as the ETag, so agents polling
+// with If-None-Match get 304 Not Modified until their stage moves on or
+// their bundle is changed.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/bundle", func(w http.ResponseWriter, r *http.Request) {
+		cluster := r.URL.Query().Get("cluster")
+		clusterLabels, err := labels.ConvertSelectorToLabelsMap(r.URL.Query().Get("labels"))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid labels: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		stageName, version, bundle := s.bundleFor(clusterLabels)
+		if bundle == nil {
+			http.Error(w, "no bundle is assigned to this cluster", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("ETag", bundle.etag)
+		if r.Header.Get("If-None-Match") == bundle.etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		logger.V(2).Info("serving policy bundle", "cluster", cluster, "stage", stageName, "version", version)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(bundle.signed)
+	})
+	return mux
+}
@@ -0,0 +1,66 @@
+package distribution
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHandlerETag(t *testing.T) {
+	dir := t.TempDir()
+	config := filepath.Join(dir, "config.yaml")
+	write := func(file, data string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(config, "bundles:\n- version: v1\n  path: v1\nstages:\n- name: everyone\n  version: v1\n")
+	setPolicy := func(name string) {
+		t.Helper()
+		write(filepath.Join(dir, "v1", "policy.yaml"), "apiVersion: admissionregistration.x-k8s.io/v1alpha1\nkind: ValidatingAdmissionPolicy\nmetadata:\n  name: "+name+"\n")
+	}
+
+	server := NewServer(newKey(t))
+	fetch := func(etag string) *http.Response {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodGet, "/v1/bundle?cluster=a", nil)
+		if etag != "" {
+			r.Header.Set("If-None-Match", etag)
+		}
+		w := httptest.NewRecorder()
+		server.Handler().ServeHTTP(w, r)
+		return w.Result()
+	}
+
+	setPolicy("deny-exec")
+	if err := server.Load(config); err != nil {
+		t.Fatal(err)
+	}
+	first := fetch("")
+	etag := first.Header.Get("ETag")
+	if first.StatusCode != http.StatusOK || etag == "" || etag == `"v1"` {
+		t.Fatalf("first fetch: status %d, ETag %q, want 200 with a hash", first.StatusCode, etag)
+	}
+	if resp := fetch(etag); resp.StatusCode != http.StatusNotModified {
+		t.Errorf("fetch with the current ETag: status %d, want 304", resp.StatusCode)
+	}
+
+	// Changing a bundle without bumping its version changes its ETag
+	setPolicy("deny-attach")
+	if err := server.Load(config); err != nil {
+		t.Fatal(err)
+	}
+	resp := fetch(etag)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("fetch with the previous ETag: status %d, want 200", resp.StatusCode)
+	}
+	if resp.Header.Get("ETag") == etag {
+		t.Errorf("ETag %q is unchanged after the bundle changed", etag)
+	}
+}
@@ -0,0 +1,271 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/klog/v2"
+
+	"github.com/kubescape/kubeenforcer/pkg/decision"
+	"github.com/kubescape/kubeenforcer/pkg/metrics"
+)
+
+var logger klog.Logger = klog.LoggerWithName(klog.Background(), "elasticsearch")
+
+var droppedDecisions = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Name:      "elasticsearch_decisions_dropped_total",
+	Help:      "Decisions not indexed in Elasticsearch or OpenSearch, because it could not keep up or rejected them.",
+})
+
+func init() {
+	metrics.Registry.MustRegister(droppedDecisions)
+}
+
+const (
+	bufferSize    = 2000
+	batchSize     = 500
+	flushInterval = 5 * time.Second
+	sendTimeout   = 30 * time.Second
+)
+
+// Auth authenticates requests, with an API key if set, or else basic auth
+// if Username is set.
+type Auth struct {
+	APIKey   string
+	Username string
+	Password string
+}
+
+// Sink indexes decisions in Elasticsearch or OpenSearch through the bulk
+// API, in daily indices named <prefix>-YYYY.MM.DD, so lifecycle policies can
+// roll them over and delete them by age. It installs an index template
+// mapping the decision fields on start. It is a decision.Sink; decisions
+// are buffered and dropped if the cluster cannot keep up.
+type Sink struct {
+	url    string
+	prefix string
+	auth   Auth
+	// lifecyclePolicy is the ILM policy set on new indices
+	lifecyclePolicy string
+	client          *http.Client
+
+	buffer  chan *decision.Decision
+	dropped atomic.Int64
+}
+
+// New creates a Sink indexing into the cluster at url with client, in
+// indices prefixed with prefix, e.g. kubeenforcer-decisions. New indices are
+// managed by the Elasticsearch ILM policy lifecyclePolicy if set; OpenSearch
+// ISM policies select indices through their own ism_template instead.
+func New(url, prefix string, auth Auth, lifecyclePolicy string, client *http.Client) *Sink {
+	c := *client
+	c.Timeout = sendTimeout
+	return &Sink{
+		url:             strings.TrimSuffix(url, "/"),
+		prefix:          prefix,
+		auth:            auth,
+		lifecyclePolicy: lifecyclePolicy,
+		client:          &c,
+		buffer:          make(chan *decision.Decision, bufferSize),
+	}
+}
+
+func (s *Sink) Record(d *decision.Decision) {
+	select {
+	case s.buffer <- d:
+	default:
+		s.dropped.Add(1)
+		droppedDecisions.Inc()
+	}
+}
+
+// Run installs the index template, then indexes buffered decisions until
+// ctx is cancelled.
+func (s *Sink) Run(ctx context.Context) error {
+	logger.Info("indexing decisions", "url", s.url, "indices", s.prefix+"-*")
+	defer logger.Info("stopped indexing decisions")
+
+	// Decisions indexed without the template get dynamic mappings, so keep
+	// going; the template applies from the next daily index
+	if err := s.putTemplate(ctx); err != nil && ctx.Err() == nil {
+		logger.Error(err, "failed to install the index template", "template", s.prefix)
+	}
+
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	var batch []*decision.Decision
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := s.bulk(ctx, batch); err != nil && ctx.Err() == nil {
+			logger.Error(err, "failed to index decisions")
+		}
+		batch = batch[:0]
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case d := <-s.buffer:
+			batch = append(batch, d)
+			if len(batch) >= batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			if dropped := s.dropped.Swap(0); dropped > 0 {
+				logger.Info("dropped decisions because the cluster could not keep up", "count", dropped)
+			}
+			flush()
+		}
+	}
+}
+
+// document is the indexed form of a decision, with the @timestamp field
+// Kibana and OpenSearch Dashboards default to.
+type document struct {
+	Timestamp time.Time `json:"@timestamp"`
+	*decision.Decision
+}
+
+// index returns the daily index of d.
+func (s *Sink) index(d *decision.Decision) string {
+	return s.prefix + "-" + d.Time.UTC().Format("2006.01.02")
+}
+
+// bulk indexes batch with the decision IDs as document IDs, so a decision
+// sent twice is indexed once.
+func (s *Sink) bulk(ctx context.Context, batch []*decision.Decision) error {
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	for _, d := range batch {
+		action := map[string]interface{}{"index": map[string]string{"_index": s.index(d), "_id": d.ID}}
+		if err := encoder.Encode(action); err != nil {
+			return err
+		}
+		if err := encoder.Encode(&document{Timestamp: d.Time, Decision: d}); err != nil {
+			return err
+		}
+	}
+
+	var result struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			Status int `json:"status"`
+			Error  *struct {
+				Type   string `json:"type"`
+				Reason string `json:"reason"`
+			} `json:"error"`
+		} `json:"items"`
+	}
+	if err := s.do(ctx, http.MethodPost, "/_bulk", "application/x-ndjson", &body, &result); err != nil {
+		droppedDecisions.Add(float64(len(batch)))
+		return err
+	}
+	if !result.Errors {
+		return nil
+	}
+	failed := 0
+	var reason string
+	for _, item := range result.Items {
+		for _, r := range item {
+			if r.Error != nil {
+				failed++
+				reason = r.Error.Type + ": " + r.Error.Reason
+			}
+		}
+	}
+	droppedDecisions.Add(float64(failed))
+	return fmt.Errorf("%d of %d decisions were rejected, e.g. %s", failed, len(batch), reason)
+}
+
+// putTemplate installs the composable index template of the indices,
+// replacing any previous version.
+func (s *Sink) putTemplate(ctx context.Context) error {
+	settings := map[string]interface{}{}
+	if s.lifecyclePolicy != "" {
+		settings["index.lifecycle.name"] = s.lifecyclePolicy
+	}
+	keyword := map[string]string{"type": "keyword"}
+	template := map[string]interface{}{
+		"index_patterns": []string{s.prefix + "-*"},
+		"priority":       100,
+		"_meta":          map[string]string{"managed_by": "kubeenforcer"},
+		"template": map[string]interface{}{
+			"settings": settings,
+			"mappings": map[string]interface{}{
+				"dynamic_templates": []interface{}{
+					map[string]interface{}{"strings": map[string]interface{}{
+						"match_mapping_type": "string",
+						"mapping":            keyword,
+					}},
+				},
+				"properties": map[string]interface{}{
+					"@timestamp": map[string]string{"type": "date"},
+					"time":       map[string]string{"type": "date"},
+					"allowed":    map[string]string{"type": "boolean"},
+					"throttled":  map[string]string{"type": "boolean"},
+					"collection": map[string]string{"type": "boolean"},
+					"message":    map[string]string{"type": "text"},
+					"id":         keyword,
+					"uid":        keyword,
+					"cluster":    keyword,
+					"operation":  keyword,
+					"group":      keyword,
+					"version":    keyword,
+					"resource":   keyword,
+					"namespace":  keyword,
+					"name":       keyword,
+					"user":       keyword,
+					"policy":     keyword,
+					"actions":    keyword,
+					"policySet":  keyword,
+				},
+			},
+		},
+	}
+	body, err := json.Marshal(template)
+	if err != nil {
+		return err
+	}
+	return s.do(ctx, http.MethodPut, "/_index_template/"+s.prefix, "application/json", bytes.NewReader(body), nil)
+}
+
+// do sends a request to the cluster and decodes the response into result
+// if not nil.
+func (s *Sink) do(ctx context.Context, method, path, contentType string, body io.Reader, result interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, s.url+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	switch {
+	case s.auth.APIKey != "":
+		req.Header.Set("Authorization", "ApiKey "+s.auth.APIKey)
+	case s.auth.Username != "":
+		req.SetBasicAuth(s.auth.Username, s.auth.Password)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s %s returned %s", method, path, resp.Status)
+	}
+	if result != nil {
+		return json.NewDecoder(resp.Body).Decode(result)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package errorbudget
+
+import (
+	"context"
+
+	"k8s.io/api/admissionregistration/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	admissionregistrationv1alpha1 "k8s.io/client-go/kubernetes/typed/admissionregistration/v1alpha1"
+)
+
+// NewClient returns a client whose degraded ValidatingAdmissionPolicies have
+// the Ignore failure policy, so an evaluator built on it allows the requests
+// they fail to evaluate. Other policies are returned as they are.
+func NewClient(client kubernetes.Interface) kubernetes.Interface {
+	return degradingClient{Interface: client}
+}
+
+type degradingClient struct {
+	kubernetes.Interface
+}
+
+func (c degradingClient) AdmissionregistrationV1alpha1() admissionregistrationv1alpha1.AdmissionregistrationV1alpha1Interface {
+	return degradingGroup{AdmissionregistrationV1alpha1Interface: c.Interface.AdmissionregistrationV1alpha1()}
+}
+
+type degradingGroup struct {
+	admissionregistrationv1alpha1.AdmissionregistrationV1alpha1Interface
+}
+
+func (g degradingGroup) ValidatingAdmissionPolicies() admissionregistrationv1alpha1.ValidatingAdmissionPolicyInterface {
+	return degradingPolicies{ValidatingAdmissionPolicyInterface: g.AdmissionregistrationV1alpha1Interface.ValidatingAdmissionPolicies()}
+}
+
+type degradingPolicies struct {
+	admissionregistrationv1alpha1.ValidatingAdmissionPolicyInterface
+}
+
+func (p degradingPolicies) Get(ctx context.Context, name string, opts metav1.GetOptions) (*v1alpha1.ValidatingAdmissionPolicy, error) {
+	policy, err := p.ValidatingAdmissionPolicyInterface.Get(ctx, name, opts)
+	if err != nil {
+		return nil, err
+	}
+	return degraded(policy), nil
+}
+
+func (p degradingPolicies) List(ctx context.Context, opts metav1.ListOptions) (*v1alpha1.ValidatingAdmissionPolicyList, error) {
+	list, err := p.ValidatingAdmissionPolicyInterface.List(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	for i := range list.Items {
+		list.Items[i] = *degraded(&list.Items[i])
+	}
+	return list, nil
+}
+
+func (p degradingPolicies) Watch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error) {
+	w, err := p.ValidatingAdmissionPolicyInterface.Watch(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return watch.Filter(w, func(event watch.Event) (watch.Event, bool) {
+		if policy, ok := event.Object.(*v1alpha1.ValidatingAdmissionPolicy); ok {
+			event.Object = degraded(policy)
+		}
+		return event, true
+	}), nil
+}
+
+// degraded returns a copy of policy with the Ignore failure policy if it is
+// degraded, or policy as it is.
+func degraded(policy *v1alpha1.ValidatingAdmissionPolicy) *v1alpha1.ValidatingAdmissionPolicy {
+	if _, ok := policy.Annotations[DegradedAnnotation]; !ok {
+		return policy
+	}
+	out := policy.DeepCopy()
+	ignore := v1alpha1.Ignore
+	out.Spec.FailurePolicy = &ignore
+	return out
+}
@@ -0,0 +1,302 @@
+package errorbudget
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/klog/v2"
+
+	"k8s.io/cel-admission-webhook/pkg/apis/admissionregistration.x-k8s.io/v1alpha1"
+	"k8s.io/cel-admission-webhook/pkg/generated/clientset/versioned"
+	informers "k8s.io/cel-admission-webhook/pkg/generated/informers/externalversions/admissionregistration.x-k8s.io/v1alpha1"
+	listers "k8s.io/cel-admission-webhook/pkg/generated/listers/admissionregistration.x-k8s.io/v1alpha1"
+
+	"github.com/kubescape/kubeenforcer/pkg/alertmanager"
+	"github.com/kubescape/kubeenforcer/pkg/metrics"
+	"github.com/kubescape/kubeenforcer/pkg/outcome"
+)
+
+var logger klog.Logger = klog.LoggerWithName(klog.Background(), "errorbudget")
+
+var policyDegraded = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: metrics.Namespace,
+	Name:      "policy_degraded",
+	Help:      "Whether a policy is degraded for exceeding its evaluation error budget.",
+}, []string{"policy"})
+
+func init() {
+	metrics.Registry.MustRegister(policyDegraded)
+}
+
+const (
+	// DegradedAnnotation records when a policy was degraded. Its evaluation
+	// errors are ignored until the cooldown has passed.
+	DegradedAnnotation = "kubeenforcer.kubescape.io/degraded-since"
+
+	// ErrorRateAnnotation records the error rate a policy was degraded at.
+	ErrorRateAnnotation = "kubeenforcer.kubescape.io/degraded-error-rate"
+
+	checkInterval = 30 * time.Second
+
+	// leaseName is the Lease electing the replica which degrades and
+	// restores policies.
+	leaseName = "kubeenforcer-policy-error-budget"
+
+	leaseDuration = 15 * time.Second
+	renewDeadline = 10 * time.Second
+	retryPeriod   = 2 * time.Second
+
+	// minEvaluations is the number of requests a policy must have matched
+	// over the window for its error rate to be significant.
+	minEvaluations = 20
+)
+
+// Options configures the error budget of policies.
+type Options struct {
+	// Threshold is the fraction of the requests matched by a policy over
+	// Window whose evaluation may fail before it is degraded.
+	Threshold float64
+	Window    time.Duration
+	// Cooldown is how long a policy stays degraded before it is enforced
+	// as configured again.
+	Cooldown time.Duration
+
+	// LeaseNamespace is the namespace of the Lease electing the replica
+	// which degrades and restores policies, identified by Identity.
+	LeaseNamespace string
+	Identity       string
+}
+
+// Counter returns the outcomes of the requests matched by each policy over
+// a window.
+type Counter interface {
+	PolicyCounts(window time.Duration) map[string]outcome.Counts
+}
+
+// Degraded is a policy degraded for exceeding its error budget.
+type Degraded struct {
+	Policy    string    `json:"policy"`
+	Since     time.Time `json:"since"`
+	Until     time.Time `json:"until"`
+	ErrorRate string    `json:"errorRate,omitempty"`
+}
+
+// Controller degrades policies failing closed whose evaluation errors
+// exceed their budget, so runtime errors of a flapping policy, e.g. on a
+// missing field or an unavailable param, stop failing every request it
+// matches. Degraded policies are annotated, leaving their spec as it is,
+// and evaluated by the webhook as if their failure policy were Ignore, see
+// NewClient: requests they fail to evaluate are allowed, while their
+// validations keep denying the ones they do evaluate. The annotation is
+// removed after the cooldown, degrading the policy again if its errors
+// persist.
+//
+// Every replica reports the degraded policies, while only the one elected
+// through a Lease degrades and restores them.
+type Controller struct {
+	client     versioned.Interface
+	kubeClient kubernetes.Interface
+	policies   listers.ValidatingAdmissionPolicyLister
+	hasSynced  cache.InformerSynced
+	counter    Counter
+	opts       Options
+	alerter    *alertmanager.AlertManager
+	leading    atomic.Bool
+}
+
+// New creates a Controller for the policies of policyInformer, whose
+// outcomes are counted by counter, alerting through alerter, which may be
+// nil, when a policy is degraded or restored. kubeClient holds the Lease.
+func New(client versioned.Interface, kubeClient kubernetes.Interface, policyInformer informers.ValidatingAdmissionPolicyInformer, counter Counter, opts Options, alerter *alertmanager.AlertManager) *Controller {
+	return &Controller{
+		client:     client,
+		kubeClient: kubeClient,
+		policies:   policyInformer.Lister(),
+		hasSynced:  policyInformer.Informer().HasSynced,
+		counter:    counter,
+		opts:       opts,
+		alerter:    alerter,
+	}
+}
+
+// Run checks the error budgets every 30 seconds until ctx is cancelled.
+func (c *Controller) Run(ctx context.Context) error {
+	logger.Info("starting policy error budget controller", "threshold", c.opts.Threshold, "window", c.opts.Window, "cooldown", c.opts.Cooldown)
+	defer logger.Info("stopped policy error budget controller")
+
+	if !cache.WaitForCacheSync(ctx.Done(), c.hasSynced) {
+		return ctx.Err()
+	}
+
+	go c.elect(ctx)
+	wait.UntilWithContext(ctx, c.check, checkInterval)
+	return nil
+}
+
+// elect takes part in the election of the replica degrading and restoring
+// policies until ctx is cancelled.
+func (c *Controller) elect(ctx context.Context) {
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta:  metav1.ObjectMeta{Namespace: c.opts.LeaseNamespace, Name: leaseName},
+		Client:     c.kubeClient.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: c.opts.Identity},
+	}
+	// RunOrDie returns once leadership is lost, to run for it again
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+			Lock:            lock,
+			LeaseDuration:   leaseDuration,
+			RenewDeadline:   renewDeadline,
+			RetryPeriod:     retryPeriod,
+			ReleaseOnCancel: true,
+			Name:            leaseName,
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: func(context.Context) {
+					logger.Info("elected to degrade and restore policies", "identity", c.opts.Identity)
+					c.leading.Store(true)
+				},
+				OnStoppedLeading: func() {
+					c.leading.Store(false)
+				},
+			},
+		})
+	}, retryPeriod)
+}
+
+func (c *Controller) check(ctx context.Context) {
+	policies, err := c.policies.List(labels.Everything())
+	if err != nil {
+		logger.Error(err, "failed to list policies")
+		return
+	}
+	counts := c.counter.PolicyCounts(c.opts.Window)
+	now := time.Now()
+	leading := c.leading.Load()
+
+	policyDegraded.Reset()
+	for _, policy := range policies {
+		var err error
+		if since, ok := degradedSince(policy); ok {
+			policyDegraded.WithLabelValues(policy.Name).Set(1)
+			if leading && now.Sub(since) >= c.opts.Cooldown {
+				err = c.restore(ctx, policy)
+			}
+		} else if leading && failsClosed(policy) {
+			count := counts[policy.Name]
+			if count.Matched >= minEvaluations && float64(count.Errors) > c.opts.Threshold*float64(count.Matched) {
+				err = c.degrade(ctx, policy, float64(count.Errors)/float64(count.Matched), now)
+			}
+		}
+		if err != nil {
+			logger.Error(err, "failed to update policy", "policy", policy.Name)
+		}
+	}
+}
+
+func failsClosed(policy *v1alpha1.ValidatingAdmissionPolicy) bool {
+	return policy.Spec.FailurePolicy == nil || *policy.Spec.FailurePolicy == v1alpha1.Fail
+}
+
+func degradedSince(policy *v1alpha1.ValidatingAdmissionPolicy) (time.Time, bool) {
+	value, ok := policy.Annotations[DegradedAnnotation]
+	if !ok {
+		return time.Time{}, false
+	}
+	// A malformed annotation restores the policy on the next check
+	since, _ := time.Parse(time.RFC3339, value)
+	return since, true
+}
+
+// degrade annotates policy with when it was degraded and its error rate.
+func (c *Controller) degrade(ctx context.Context, policy *v1alpha1.ValidatingAdmissionPolicy, errorRate float64, now time.Time) error {
+	updated := policy.DeepCopy()
+	if updated.Annotations == nil {
+		updated.Annotations = map[string]string{}
+	}
+	updated.Annotations[DegradedAnnotation] = now.UTC().Format(time.RFC3339)
+	updated.Annotations[ErrorRateAnnotation] = fmt.Sprintf("%.2f", errorRate)
+
+	if _, err := c.client.AdmissionregistrationV1alpha1().ValidatingAdmissionPolicies().Update(ctx, updated, metav1.UpdateOptions{}); err != nil {
+		return err
+	}
+	policyDegraded.WithLabelValues(policy.Name).Set(1)
+	logger.Info("degraded policy exceeding its error budget", "policy", policy.Name, "errorRate", errorRate, "until", now.Add(c.opts.Cooldown))
+	if c.alerter != nil {
+		c.alerter.Alert(&alertmanager.AlertInfo{
+			Name:        "Policy degraded",
+			Severity:    "warning",
+			Resource:    "validatingadmissionpolicies",
+			Instance:    policy.Name,
+			Description: fmt.Sprintf("%.0f%% of the evaluations of policy %s failed over the last %s; requests it fails to evaluate are allowed until %s", errorRate*100, policy.Name, c.opts.Window, now.Add(c.opts.Cooldown).UTC().Format(time.RFC3339)),
+		})
+	}
+	return nil
+}
+
+// restore removes the annotations of a degraded policy.
+func (c *Controller) restore(ctx context.Context, policy *v1alpha1.ValidatingAdmissionPolicy) error {
+	updated := policy.DeepCopy()
+	delete(updated.Annotations, DegradedAnnotation)
+	delete(updated.Annotations, ErrorRateAnnotation)
+
+	if _, err := c.client.AdmissionregistrationV1alpha1().ValidatingAdmissionPolicies().Update(ctx, updated, metav1.UpdateOptions{}); err != nil {
+		return err
+	}
+	policyDegraded.DeleteLabelValues(policy.Name)
+	logger.Info("restored degraded policy", "policy", policy.Name)
+	if c.alerter != nil {
+		c.alerter.Alert(&alertmanager.AlertInfo{
+			Name:        "Policy restored",
+			Severity:    "info",
+			Resource:    "validatingadmissionpolicies",
+			Instance:    policy.Name,
+			Description: fmt.Sprintf("Policy %s fails closed again after its cooldown", policy.Name),
+		})
+	}
+	return nil
+}
+
+// Degraded returns the degraded policies.
+func (c *Controller) Degraded() ([]Degraded, error) {
+	policies, err := c.policies.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	degraded := []Degraded{}
+	for _, policy := range policies {
+		if since, ok := degradedSince(policy); ok {
+			degraded = append(degraded, Degraded{
+				Policy:    policy.Name,
+				Since:     since,
+				Until:     since.Add(c.opts.Cooldown),
+				ErrorRate: policy.Annotations[ErrorRateAnnotation],
+			})
+		}
+	}
+	sort.Slice(degraded, func(i, j int) bool { return degraded[i].Policy < degraded[j].Policy })
+	return degraded, nil
+}
+
+// ServeHTTP writes the degraded policies as a JSON list.
+func (c *Controller) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	degraded, err := c.Degraded()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(degraded)
+}
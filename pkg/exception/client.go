@@ -0,0 +1,96 @@
+package exception
+
+import (
+	"context"
+	"strings"
+
+	"k8s.io/api/admissionregistration/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	admissionregistrationv1alpha1 "k8s.io/client-go/kubernetes/typed/admissionregistration/v1alpha1"
+)
+
+// NewClient returns a client whose ValidatingAdmissionPolicyBindings
+// annotated with excepted namespaces exclude them through a namespace
+// selector requirement, so an evaluator built on it does not enforce their
+// policies there. Other bindings are returned as they are.
+func NewClient(client kubernetes.Interface) kubernetes.Interface {
+	return exceptingClient{Interface: client}
+}
+
+type exceptingClient struct {
+	kubernetes.Interface
+}
+
+func (c exceptingClient) AdmissionregistrationV1alpha1() admissionregistrationv1alpha1.AdmissionregistrationV1alpha1Interface {
+	return exceptingGroup{AdmissionregistrationV1alpha1Interface: c.Interface.AdmissionregistrationV1alpha1()}
+}
+
+type exceptingGroup struct {
+	admissionregistrationv1alpha1.AdmissionregistrationV1alpha1Interface
+}
+
+func (g exceptingGroup) ValidatingAdmissionPolicyBindings() admissionregistrationv1alpha1.ValidatingAdmissionPolicyBindingInterface {
+	return exceptingBindings{ValidatingAdmissionPolicyBindingInterface: g.AdmissionregistrationV1alpha1Interface.ValidatingAdmissionPolicyBindings()}
+}
+
+type exceptingBindings struct {
+	admissionregistrationv1alpha1.ValidatingAdmissionPolicyBindingInterface
+}
+
+func (b exceptingBindings) Get(ctx context.Context, name string, opts metav1.GetOptions) (*v1alpha1.ValidatingAdmissionPolicyBinding, error) {
+	binding, err := b.ValidatingAdmissionPolicyBindingInterface.Get(ctx, name, opts)
+	if err != nil {
+		return nil, err
+	}
+	return excepted(binding), nil
+}
+
+func (b exceptingBindings) List(ctx context.Context, opts metav1.ListOptions) (*v1alpha1.ValidatingAdmissionPolicyBindingList, error) {
+	list, err := b.ValidatingAdmissionPolicyBindingInterface.List(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	for i := range list.Items {
+		list.Items[i] = *excepted(&list.Items[i])
+	}
+	return list, nil
+}
+
+func (b exceptingBindings) Watch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error) {
+	w, err := b.ValidatingAdmissionPolicyBindingInterface.Watch(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return watch.Filter(w, func(event watch.Event) (watch.Event, bool) {
+		if binding, ok := event.Object.(*v1alpha1.ValidatingAdmissionPolicyBinding); ok {
+			event.Object = excepted(binding)
+		}
+		return event, true
+	}), nil
+}
+
+// excepted returns a copy of binding excluding the namespaces of its
+// annotation, or binding as it is if it has none.
+func excepted(binding *v1alpha1.ValidatingAdmissionPolicyBinding) *v1alpha1.ValidatingAdmissionPolicyBinding {
+	namespaces := binding.Annotations[ExceptedNamespacesAnnotation]
+	if namespaces == "" {
+		return binding
+	}
+	out := binding.DeepCopy()
+	if out.Spec.MatchResources == nil {
+		out.Spec.MatchResources = &v1alpha1.MatchResources{}
+	}
+	if out.Spec.MatchResources.NamespaceSelector == nil {
+		out.Spec.MatchResources.NamespaceSelector = &metav1.LabelSelector{}
+	}
+	selector := out.Spec.MatchResources.NamespaceSelector
+	selector.MatchExpressions = append(selector.MatchExpressions, metav1.LabelSelectorRequirement{
+		Key:      corev1.LabelMetadataName,
+		Operator: metav1.LabelSelectorOpNotIn,
+		Values:   strings.Split(namespaces, ","),
+	})
+	return out
+}
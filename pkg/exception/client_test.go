@@ -0,0 +1,64 @@
+package exception
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/api/admissionregistration/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestExcepted(t *testing.T) {
+	notIn := func(values ...string) metav1.LabelSelectorRequirement {
+		return metav1.LabelSelectorRequirement{Key: corev1.LabelMetadataName, Operator: metav1.LabelSelectorOpNotIn, Values: values}
+	}
+	tier := metav1.LabelSelectorRequirement{Key: "tier", Operator: metav1.LabelSelectorOpIn, Values: []string{"prod"}}
+	binding := func(annotation string, requirements ...metav1.LabelSelectorRequirement) *v1alpha1.ValidatingAdmissionPolicyBinding {
+		b := &v1alpha1.ValidatingAdmissionPolicyBinding{
+			ObjectMeta: metav1.ObjectMeta{Name: "deny-privileged"},
+			Spec:       v1alpha1.ValidatingAdmissionPolicyBindingSpec{PolicyName: "deny-privileged"},
+		}
+		if annotation != "" {
+			b.Annotations = map[string]string{ExceptedNamespacesAnnotation: annotation}
+		}
+		if requirements != nil {
+			b.Spec.MatchResources = &v1alpha1.MatchResources{NamespaceSelector: &metav1.LabelSelector{MatchExpressions: requirements}}
+		}
+		return b
+	}
+
+	tests := []struct {
+		name    string
+		binding *v1alpha1.ValidatingAdmissionPolicyBinding
+		want    *v1alpha1.ValidatingAdmissionPolicyBinding
+	}{
+		{
+			name:    "no exceptions",
+			binding: binding("", tier),
+			want:    binding("", tier),
+		},
+		{
+			name:    "namespaces are excluded",
+			binding: binding("team-a,team-b"),
+			want:    binding("team-a,team-b", notIn("team-a", "team-b")),
+		},
+		{
+			name:    "requirements of the author are kept",
+			binding: binding("team-b", tier, notIn("team-a")),
+			want:    binding("team-b", tier, notIn("team-a"), notIn("team-b")),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original := tt.binding.DeepCopy()
+			got := excepted(tt.binding)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("excepted() = %+v, want %+v", got.Spec.MatchResources, tt.want.Spec.MatchResources)
+			}
+			if !reflect.DeepEqual(tt.binding, original) {
+				t.Errorf("excepted() modified the binding")
+			}
+		})
+	}
+}
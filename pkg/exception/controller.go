@@ -0,0 +1,423 @@
+package exception
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+
+	"k8s.io/cel-admission-webhook/pkg/apis/admissionregistration.x-k8s.io/v1alpha1"
+	"k8s.io/cel-admission-webhook/pkg/generated/clientset/versioned"
+	bindinginformers "k8s.io/cel-admission-webhook/pkg/generated/informers/externalversions/admissionregistration.x-k8s.io/v1alpha1"
+	listers "k8s.io/cel-admission-webhook/pkg/generated/listers/admissionregistration.x-k8s.io/v1alpha1"
+
+	"github.com/kubescape/kubeenforcer/pkg/alertmanager"
+)
+
+var logger klog.Logger = klog.LoggerWithName(klog.Background(), "exception")
+
+const (
+	// ExceptedNamespacesAnnotation records the namespaces excluded from a
+	// binding for active exceptions.
+	ExceptedNamespacesAnnotation = "kubeenforcer.kubescape.io/excepted-namespaces"
+
+	// ReviewedAnnotation records when an active exception was first seen or
+	// last reminded about.
+	ReviewedAnnotation = "kubeenforcer.kubescape.io/reviewed-at"
+
+	// ExpiredAnnotation records when the expiry of an exception was alerted
+	// on.
+	ExpiredAnnotation = "kubeenforcer.kubescape.io/expired-at"
+
+	queueKey = "exceptions"
+
+	// leaseName is the Lease electing the replica which applies exceptions
+	// and alerts on them.
+	leaseName = "kubeenforcer-policy-exceptions"
+
+	leaseDuration = 15 * time.Second
+	renewDeadline = 10 * time.Second
+	retryPeriod   = 2 * time.Second
+)
+
+// Options configures which policies may be excepted, how long exceptions may
+// last and how often they are reviewed.
+type Options struct {
+	// Policies are the policies exceptions may stop enforcing. Exceptions
+	// naming other policies are not applied to them, so creating an
+	// exception cannot waive policies not deemed exceptable.
+	Policies []string
+
+	// MaxTTL caps the lifetime of exceptions from their creation. Unlimited
+	// if 0.
+	MaxTTL time.Duration
+
+	// ReviewInterval is how often a reminder to review an active exception
+	// is alerted on, unless the exception sets its own.
+	ReviewInterval time.Duration
+
+	// LeaseNamespace is the namespace of the Lease electing the replica
+	// which applies exceptions, identified by Identity.
+	LeaseNamespace string
+	Identity       string
+}
+
+// Controller excludes the namespaces of active PolicyExceptions from the
+// bindings of the excepted policies, if they may be excepted, and enforces the policies again once the
+// exceptions expire, whether or not they were deleted. Bindings are annotated
+// with their excepted namespaces, leaving their spec as it is, and evaluated
+// by the webhook as if their namespace selector excluded them, see NewClient.
+//
+// Only the replica elected through a Lease annotates bindings and alerts on
+// exceptions. When exceptions were reviewed and whether their expiry was
+// alerted on is recorded on them, so reminders and expiry alerts are sent
+// once, whichever replica is elected and however often it restarts.
+type Controller struct {
+	client     versioned.Interface
+	dynamic    dynamic.Interface
+	kubeClient kubernetes.Interface
+	exceptions cache.GenericLister
+	bindings   listers.ValidatingAdmissionPolicyBindingLister
+	hasSynced  []cache.InformerSynced
+	opts       Options
+	alerter    *alertmanager.AlertManager
+	queue      workqueue.RateLimitingInterface
+	leading    atomic.Bool
+}
+
+// New creates a Controller for the PolicyExceptions watched by informer,
+// which are annotated through dynamicClient, alerting through alerter, which
+// may be nil. kubeClient holds the Lease.
+func New(client versioned.Interface, dynamicClient dynamic.Interface, kubeClient kubernetes.Interface, informer informers.GenericInformer, bindingInformer bindinginformers.ValidatingAdmissionPolicyBindingInformer, opts Options, alerter *alertmanager.AlertManager) *Controller {
+	c := &Controller{
+		client:     client,
+		dynamic:    dynamicClient,
+		kubeClient: kubeClient,
+		exceptions: informer.Lister(),
+		bindings:   bindingInformer.Lister(),
+		hasSynced: []cache.InformerSynced{
+			informer.Informer().HasSynced,
+			bindingInformer.Informer().HasSynced,
+		},
+		opts:    opts,
+		alerter: alerter,
+		queue:   workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+	}
+
+	enqueue := func(interface{}) { c.queue.Add(queueKey) }
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc:    enqueue,
+		UpdateFunc: func(_, _ interface{}) { enqueue(nil) },
+		DeleteFunc: enqueue,
+	}
+	informer.Informer().AddEventHandler(handler)
+	bindingInformer.Informer().AddEventHandler(handler)
+
+	return c
+}
+
+// Run processes exception and binding changes until ctx is cancelled.
+func (c *Controller) Run(ctx context.Context) error {
+	defer c.queue.ShutDown()
+
+	logger.Info("starting policy exception controller")
+	defer logger.Info("stopped policy exception controller")
+
+	if !cache.WaitForCacheSync(ctx.Done(), c.hasSynced...) {
+		return ctx.Err()
+	}
+
+	go c.elect(ctx)
+
+	// Periodically check for due review reminders
+	go wait.UntilWithContext(ctx, func(ctx context.Context) {
+		c.queue.Add(queueKey)
+	}, time.Minute)
+
+	go func() {
+		<-ctx.Done()
+		c.queue.ShutDown()
+	}()
+
+	for c.processNext(ctx) {
+	}
+	return nil
+}
+
+// elect takes part in the election of the replica applying exceptions until
+// ctx is cancelled.
+func (c *Controller) elect(ctx context.Context) {
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta:  metav1.ObjectMeta{Namespace: c.opts.LeaseNamespace, Name: leaseName},
+		Client:     c.kubeClient.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: c.opts.Identity},
+	}
+	// RunOrDie returns once leadership is lost, to run for it again
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+			Lock:            lock,
+			LeaseDuration:   leaseDuration,
+			RenewDeadline:   renewDeadline,
+			RetryPeriod:     retryPeriod,
+			ReleaseOnCancel: true,
+			Name:            leaseName,
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: func(context.Context) {
+					logger.Info("elected to apply policy exceptions", "identity", c.opts.Identity)
+					c.leading.Store(true)
+					c.queue.Add(queueKey)
+				},
+				OnStoppedLeading: func() {
+					c.leading.Store(false)
+				},
+			},
+		})
+	}, retryPeriod)
+}
+
+func (c *Controller) processNext(ctx context.Context) bool {
+	key, shutdown := c.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer c.queue.Done(key)
+
+	if err := c.reconcile(ctx); err != nil {
+		logger.Error(err, "failed to reconcile policy exceptions")
+		c.queue.AddRateLimited(key)
+		return true
+	}
+	c.queue.Forget(key)
+	return true
+}
+
+func (c *Controller) reconcile(ctx context.Context) error {
+	if !c.leading.Load() {
+		return nil
+	}
+	now := time.Now()
+
+	objs, err := c.exceptions.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+
+	// Namespaces excepted from each policy
+	excepted := map[string][]string{}
+	var nextExpiry time.Time
+	for _, obj := range objs {
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			return fmt.Errorf("unexpected object type %T", obj)
+		}
+		exception := &PolicyException{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.UnstructuredContent(), exception); err != nil {
+			logger.Error(err, "ignoring invalid policy exception", "namespace", u.GetNamespace(), "name", u.GetName())
+			continue
+		}
+
+		expires := c.expiry(exception)
+		if !now.Before(expires) {
+			// Only alert on exceptions that were active, once
+			if exception.Annotations[ReviewedAnnotation] != "" && exception.Annotations[ExpiredAnnotation] == "" {
+				if err := c.annotate(ctx, u, ExpiredAnnotation, now); err != nil {
+					return err
+				}
+				c.lapsed(exception)
+			}
+			continue
+		}
+
+		for _, policy := range exception.Spec.Policies {
+			if !c.exceptable(policy) {
+				logger.V(2).Info("ignoring exception of a policy that may not be excepted", "namespace", exception.Namespace, "name", exception.Name, "policy", policy)
+				continue
+			}
+			excepted[policy] = append(excepted[policy], exception.Namespace)
+		}
+		if nextExpiry.IsZero() || expires.Before(nextExpiry) {
+			nextExpiry = expires
+		}
+		// An exception extended after it expired may be alerted on again
+		if c.review(exception, expires, now) || exception.Annotations[ExpiredAnnotation] != "" {
+			if err := c.annotate(ctx, u, ReviewedAnnotation, now); err != nil {
+				return err
+			}
+		}
+	}
+
+	bindings, err := c.bindings.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	for _, binding := range bindings {
+		if err := c.annotateBinding(ctx, binding, excepted[binding.Spec.PolicyName]); err != nil {
+			return fmt.Errorf("failed to update binding %s: %w", binding.Name, err)
+		}
+	}
+
+	// Enforce again as soon as the next exception expires
+	if !nextExpiry.IsZero() {
+		c.queue.AddAfter(queueKey, nextExpiry.Sub(now))
+	}
+	return nil
+}
+
+// exceptable returns whether exceptions may stop enforcing policy.
+func (c *Controller) exceptable(policy string) bool {
+	for _, p := range c.opts.Policies {
+		if p == policy {
+			return true
+		}
+	}
+	return false
+}
+
+// expiry returns when exception expires, capped by the maximum TTL. An
+// exception without expiry lasts for the maximum TTL, or is never active
+// without one.
+func (c *Controller) expiry(exception *PolicyException) time.Time {
+	expires := exception.Spec.ExpiresAt.Time
+	if c.opts.MaxTTL > 0 {
+		limit := exception.CreationTimestamp.Add(c.opts.MaxTTL)
+		if expires.IsZero() || limit.Before(expires) {
+			expires = limit
+		}
+	}
+	return expires
+}
+
+// review logs new exceptions and alerts on those due for review. It returns
+// whether the exception was reviewed now, to be recorded on it.
+func (c *Controller) review(exception *PolicyException, expires, now time.Time) bool {
+	// A malformed annotation counts as a new exception
+	last, err := time.Parse(time.RFC3339, exception.Annotations[ReviewedAnnotation])
+	if err != nil {
+		logger.Info("policy exception active", "namespace", exception.Namespace, "name", exception.Name, "policies", exception.Spec.Policies, "expires", expires, "reason", exception.Spec.Reason)
+		return true
+	}
+
+	interval := c.opts.ReviewInterval
+	if exception.Spec.ReviewInterval != nil {
+		interval = exception.Spec.ReviewInterval.Duration
+	}
+	if interval <= 0 || now.Sub(last) < interval {
+		return false
+	}
+
+	logger.Info("policy exception due for review", "namespace", exception.Namespace, "name", exception.Name, "expires", expires)
+	if c.alerter != nil {
+		c.alerter.Alert(&alertmanager.AlertInfo{
+			Name:        "Policy exception review",
+			Severity:    "info",
+			Resource:    "policyexceptions",
+			Instance:    exception.Name,
+			Namespace:   exception.Namespace,
+			Description: fmt.Sprintf("Policies %s are not enforced until %s: %s", strings.Join(exception.Spec.Policies, ", "), expires.UTC().Format(time.RFC3339), exception.Spec.Reason),
+		})
+	}
+	return true
+}
+
+// annotate records now in the annotation of the exception u. Recording that
+// an exception was reviewed clears the record of its expiry, since it was
+// extended.
+func (c *Controller) annotate(ctx context.Context, u *unstructured.Unstructured, annotation string, now time.Time) error {
+	updated := u.DeepCopy()
+	annotations := updated.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[annotation] = now.UTC().Format(time.RFC3339)
+	if annotation == ReviewedAnnotation {
+		delete(annotations, ExpiredAnnotation)
+	}
+	updated.SetAnnotations(annotations)
+	if _, err := c.dynamic.Resource(GroupVersionResource).Namespace(u.GetNamespace()).Update(ctx, updated, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to annotate policy exception %s/%s: %w", u.GetNamespace(), u.GetName(), err)
+	}
+	return nil
+}
+
+func (c *Controller) lapsed(exception *PolicyException) {
+	logger.Info("policy exception expired, enforcing policies again", "namespace", exception.Namespace, "name", exception.Name, "policies", exception.Spec.Policies)
+	if c.alerter != nil {
+		c.alerter.Alert(&alertmanager.AlertInfo{
+			Name:        "Policy exception expired",
+			Severity:    "info",
+			Resource:    "policyexceptions",
+			Instance:    exception.Name,
+			Namespace:   exception.Namespace,
+			Description: fmt.Sprintf("Policies %s are enforced again", strings.Join(exception.Spec.Policies, ", ")),
+		})
+	}
+}
+
+// annotateBinding records the excepted namespaces of binding in its
+// annotation, removing it if there are none.
+func (c *Controller) annotateBinding(ctx context.Context, binding *v1alpha1.ValidatingAdmissionPolicyBinding, namespaces []string) error {
+	namespaces = dedup(namespaces)
+	var current []string
+	if v := binding.Annotations[ExceptedNamespacesAnnotation]; v != "" {
+		current = strings.Split(v, ",")
+	}
+	if equal(current, namespaces) {
+		return nil
+	}
+
+	updated := binding.DeepCopy()
+	if len(namespaces) > 0 {
+		if updated.Annotations == nil {
+			updated.Annotations = map[string]string{}
+		}
+		updated.Annotations[ExceptedNamespacesAnnotation] = strings.Join(namespaces, ",")
+	} else {
+		delete(updated.Annotations, ExceptedNamespacesAnnotation)
+	}
+
+	if _, err := c.client.AdmissionregistrationV1alpha1().ValidatingAdmissionPolicyBindings().Update(ctx, updated, metav1.UpdateOptions{}); err != nil {
+		return err
+	}
+	logger.Info("updated excepted namespaces of binding", "binding", binding.Name, "policy", binding.Spec.PolicyName, "namespaces", namespaces)
+	return nil
+}
+
+// dedup returns the sorted unique values.
+func dedup(values []string) []string {
+	sort.Strings(values)
+	var result []string
+	for i, v := range values {
+		if i == 0 || v != values[i-1] {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
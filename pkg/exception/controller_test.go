@@ -0,0 +1,390 @@
+package exception
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+
+	"k8s.io/cel-admission-webhook/pkg/apis/admissionregistration.x-k8s.io/v1alpha1"
+	"k8s.io/cel-admission-webhook/pkg/generated/clientset/versioned/fake"
+	listers "k8s.io/cel-admission-webhook/pkg/generated/listers/admissionregistration.x-k8s.io/v1alpha1"
+)
+
+func TestExpiry(t *testing.T) {
+	created := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	exception := func(expiresAt time.Time) *PolicyException {
+		return &PolicyException{
+			ObjectMeta: metav1.ObjectMeta{CreationTimestamp: metav1.NewTime(created)},
+			Spec:       PolicyExceptionSpec{ExpiresAt: metav1.NewTime(expiresAt)},
+		}
+	}
+
+	tests := []struct {
+		name      string
+		maxTTL    time.Duration
+		expiresAt time.Time
+		want      time.Time
+	}{
+		{
+			name:      "expiry without maximum TTL",
+			expiresAt: created.Add(30 * 24 * time.Hour),
+			want:      created.Add(30 * 24 * time.Hour),
+		},
+		{
+			name:      "expiry within the maximum TTL",
+			maxTTL:    7 * 24 * time.Hour,
+			expiresAt: created.Add(24 * time.Hour),
+			want:      created.Add(24 * time.Hour),
+		},
+		{
+			name:      "expiry past the maximum TTL is capped",
+			maxTTL:    7 * 24 * time.Hour,
+			expiresAt: created.Add(30 * 24 * time.Hour),
+			want:      created.Add(7 * 24 * time.Hour),
+		},
+		{
+			name:   "no expiry lasts for the maximum TTL",
+			maxTTL: 7 * 24 * time.Hour,
+			want:   created.Add(7 * 24 * time.Hour),
+		},
+		{
+			name: "no expiry without maximum TTL is never active",
+		},
+		{
+			name:      "expiry before creation",
+			maxTTL:    7 * 24 * time.Hour,
+			expiresAt: created.Add(-time.Hour),
+			want:      created.Add(-time.Hour),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Controller{opts: Options{MaxTTL: tt.maxTTL}}
+			if got := c.expiry(exception(tt.expiresAt)); !got.Equal(tt.want) {
+				t.Errorf("expiry() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReview(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	hour := &metav1.Duration{Duration: time.Hour}
+
+	tests := []struct {
+		name           string
+		reviewInterval time.Duration
+		override       *metav1.Duration
+		// reviewedAt is the annotation recording the last review
+		reviewedAt string
+		// reviewed is whether the exception is reviewed at now
+		reviewed bool
+	}{
+		{
+			name:           "new exception is recorded",
+			reviewInterval: 24 * time.Hour,
+			reviewed:       true,
+		},
+		{
+			name:           "malformed record counts as new",
+			reviewInterval: 24 * time.Hour,
+			reviewedAt:     "yesterday",
+			reviewed:       true,
+		},
+		{
+			name:           "reminder before the interval",
+			reviewInterval: 24 * time.Hour,
+			reviewedAt:     "2024-02-29T13:00:00Z",
+		},
+		{
+			name:           "reminder after the interval",
+			reviewInterval: 24 * time.Hour,
+			reviewedAt:     "2024-02-29T12:00:00Z",
+			reviewed:       true,
+		},
+		{
+			name:           "exception overrides the interval",
+			reviewInterval: 24 * time.Hour,
+			override:       hour,
+			reviewedAt:     "2024-03-01T11:00:00Z",
+			reviewed:       true,
+		},
+		{
+			name:       "no reminders without interval",
+			reviewedAt: "2023-03-01T12:00:00Z",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Controller{opts: Options{ReviewInterval: tt.reviewInterval}}
+			exception := &PolicyException{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: "debug"},
+				Spec:       PolicyExceptionSpec{Policies: []string{"deny-privileged"}, ReviewInterval: tt.override},
+			}
+			if tt.reviewedAt != "" {
+				exception.Annotations = map[string]string{ReviewedAnnotation: tt.reviewedAt}
+			}
+			if got := c.review(exception, now.Add(time.Hour), now); got != tt.reviewed {
+				t.Errorf("review() = %v, want %v", got, tt.reviewed)
+			}
+		})
+	}
+}
+
+func TestAnnotate(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	exception := func(annotations map[string]interface{}) *unstructured.Unstructured {
+		metadata := map[string]interface{}{"namespace": "team-a", "name": "debug"}
+		if annotations != nil {
+			metadata["annotations"] = annotations
+		}
+		return &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": GroupVersionResource.GroupVersion().String(),
+			"kind":       "PolicyException",
+			"metadata":   metadata,
+		}}
+	}
+
+	tests := []struct {
+		name        string
+		exception   *unstructured.Unstructured
+		annotation  string
+		annotations map[string]string
+	}{
+		{
+			name:        "review is recorded",
+			exception:   exception(nil),
+			annotation:  ReviewedAnnotation,
+			annotations: map[string]string{ReviewedAnnotation: "2024-03-01T12:00:00Z"},
+		},
+		{
+			name:        "expiry is recorded",
+			exception:   exception(map[string]interface{}{ReviewedAnnotation: "2024-02-01T12:00:00Z"}),
+			annotation:  ExpiredAnnotation,
+			annotations: map[string]string{ReviewedAnnotation: "2024-02-01T12:00:00Z", ExpiredAnnotation: "2024-03-01T12:00:00Z"},
+		},
+		{
+			name:        "review of an extended exception clears its expiry",
+			exception:   exception(map[string]interface{}{ReviewedAnnotation: "2024-02-01T12:00:00Z", ExpiredAnnotation: "2024-02-15T12:00:00Z"}),
+			annotation:  ReviewedAnnotation,
+			annotations: map[string]string{ReviewedAnnotation: "2024-03-01T12:00:00Z"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{GroupVersionResource: "PolicyExceptionList"}, tt.exception)
+			c := &Controller{dynamic: client}
+			if err := c.annotate(context.Background(), tt.exception, tt.annotation, now); err != nil {
+				t.Fatalf("annotate() error = %v", err)
+			}
+
+			got, err := client.Resource(GroupVersionResource).Namespace("team-a").Get(context.Background(), "debug", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got.GetAnnotations(), tt.annotations) {
+				t.Errorf("annotate() annotations = %v, want %v", got.GetAnnotations(), tt.annotations)
+			}
+		})
+	}
+}
+
+func TestAnnotateBinding(t *testing.T) {
+	tier := metav1.LabelSelectorRequirement{Key: "tier", Operator: metav1.LabelSelectorOpIn, Values: []string{"prod"}}
+	binding := func(annotation string) *v1alpha1.ValidatingAdmissionPolicyBinding {
+		b := &v1alpha1.ValidatingAdmissionPolicyBinding{
+			ObjectMeta: metav1.ObjectMeta{Name: "deny-privileged"},
+			Spec: v1alpha1.ValidatingAdmissionPolicyBindingSpec{
+				PolicyName:     "deny-privileged",
+				MatchResources: &v1alpha1.MatchResources{NamespaceSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{tier}}},
+			},
+		}
+		if annotation != "" {
+			b.Annotations = map[string]string{ExceptedNamespacesAnnotation: annotation}
+		}
+		return b
+	}
+
+	tests := []struct {
+		name       string
+		binding    *v1alpha1.ValidatingAdmissionPolicyBinding
+		namespaces []string
+		want       string
+	}{
+		{
+			name:    "no exceptions",
+			binding: binding(""),
+		},
+		{
+			name:       "namespaces are excepted",
+			binding:    binding(""),
+			namespaces: []string{"team-b", "team-a", "team-b"},
+			want:       "team-a,team-b",
+		},
+		{
+			name:       "same namespaces are excepted",
+			binding:    binding("team-a"),
+			namespaces: []string{"team-a"},
+			want:       "team-a",
+		},
+		{
+			name:       "namespaces are added",
+			binding:    binding("team-a"),
+			namespaces: []string{"team-a", "team-b"},
+			want:       "team-a,team-b",
+		},
+		{
+			name:    "expired exceptions are enforced again",
+			binding: binding("team-a"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset(tt.binding)
+			c := &Controller{client: client}
+			if err := c.annotateBinding(context.Background(), tt.binding, tt.namespaces); err != nil {
+				t.Fatalf("annotateBinding() error = %v", err)
+			}
+
+			got, err := client.AdmissionregistrationV1alpha1().ValidatingAdmissionPolicyBindings().Get(context.Background(), tt.binding.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if a := got.Annotations[ExceptedNamespacesAnnotation]; a != tt.want {
+				t.Errorf("annotateBinding() excepted namespaces = %q, want %q", a, tt.want)
+			}
+			if !reflect.DeepEqual(got.Spec, tt.binding.Spec) {
+				t.Errorf("annotateBinding() changed the spec to %+v", got.Spec.MatchResources)
+			}
+		})
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	now := time.Now()
+	exception := func(namespace, name string, expiresAt time.Time, annotations map[string]interface{}, policies ...interface{}) *unstructured.Unstructured {
+		metadata := map[string]interface{}{"namespace": namespace, "name": name}
+		if annotations != nil {
+			metadata["annotations"] = annotations
+		}
+		return &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": GroupVersionResource.GroupVersion().String(),
+			"kind":       "PolicyException",
+			"metadata":   metadata,
+			"spec": map[string]interface{}{
+				"policies":  policies,
+				"expiresAt": expiresAt.UTC().Format(time.RFC3339),
+			},
+		}}
+	}
+	binding := func(policy, excepted string) *v1alpha1.ValidatingAdmissionPolicyBinding {
+		b := &v1alpha1.ValidatingAdmissionPolicyBinding{
+			ObjectMeta: metav1.ObjectMeta{Name: policy},
+			Spec:       v1alpha1.ValidatingAdmissionPolicyBindingSpec{PolicyName: policy},
+		}
+		if excepted != "" {
+			b.Annotations = map[string]string{ExceptedNamespacesAnnotation: excepted}
+		}
+		return b
+	}
+	reviewed := map[string]interface{}{ReviewedAnnotation: now.Add(-time.Hour).UTC().Format(time.RFC3339)}
+
+	exceptions := []*unstructured.Unstructured{
+		// Active, one of whose policies may not be excepted
+		exception("team-a", "active", now.Add(time.Hour), nil, "deny-exec", "deny-host-path"),
+		// Expired while active
+		exception("team-b", "lapsed", now.Add(-time.Minute), reviewed, "deny-privileged"),
+		// Expired before it was ever active
+		exception("team-c", "stale", now.Add(-time.Minute), nil, "deny-exec"),
+	}
+	bindings := []*v1alpha1.ValidatingAdmissionPolicyBinding{
+		binding("deny-exec", ""),
+		binding("deny-privileged", "team-b"),
+		binding("deny-host-path", ""),
+	}
+
+	exceptionIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	var exceptionObjects []runtime.Object
+	for _, e := range exceptions {
+		exceptionIndexer.Add(e)
+		exceptionObjects = append(exceptionObjects, e)
+	}
+	bindingIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	var bindingObjects []runtime.Object
+	for _, b := range bindings {
+		bindingIndexer.Add(b)
+		bindingObjects = append(bindingObjects, b)
+	}
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{GroupVersionResource: "PolicyExceptionList"}, exceptionObjects...)
+	client := fake.NewSimpleClientset(bindingObjects...)
+
+	c := &Controller{
+		client:     client,
+		dynamic:    dynamicClient,
+		exceptions: cache.NewGenericLister(exceptionIndexer, GroupVersionResource.GroupResource()),
+		bindings:   listers.NewValidatingAdmissionPolicyBindingLister(bindingIndexer),
+		opts:       Options{Policies: []string{"deny-exec", "deny-privileged"}},
+		queue:      workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+	}
+	defer c.queue.ShutDown()
+
+	// Only the elected replica reconciles
+	if err := c.reconcile(context.Background()); err != nil {
+		t.Fatalf("reconcile() error = %v", err)
+	}
+	if actions := client.Actions(); len(actions) > 0 {
+		t.Fatalf("reconcile() without being elected = %v, want no action", actions)
+	}
+
+	c.leading.Store(true)
+	if err := c.reconcile(context.Background()); err != nil {
+		t.Fatalf("reconcile() error = %v", err)
+	}
+
+	wantBindings := map[string]string{
+		"deny-exec":       "team-a",
+		"deny-privileged": "",
+		"deny-host-path":  "",
+	}
+	for name, want := range wantBindings {
+		got, err := client.AdmissionregistrationV1alpha1().ValidatingAdmissionPolicyBindings().Get(context.Background(), name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if excepted := got.Annotations[ExceptedNamespacesAnnotation]; excepted != want {
+			t.Errorf("binding %s excepted namespaces = %q, want %q", name, excepted, want)
+		}
+	}
+
+	wantAnnotations := map[string][]string{
+		"team-a/active": {ReviewedAnnotation},
+		"team-b/lapsed": {ReviewedAnnotation, ExpiredAnnotation},
+		"team-c/stale":  nil,
+	}
+	for key, want := range wantAnnotations {
+		namespace, name, _ := cache.SplitMetaNamespaceKey(key)
+		got, err := dynamicClient.Resource(GroupVersionResource).Namespace(namespace).Get(context.Background(), name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var annotations []string
+		for annotation := range got.GetAnnotations() {
+			annotations = append(annotations, annotation)
+		}
+		sort.Strings(annotations)
+		sort.Strings(want)
+		if !reflect.DeepEqual(annotations, want) {
+			t.Errorf("exception %s annotations = %v, want %v", key, annotations, want)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package exception
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// GroupVersionResource of the PolicyException CRD.
+var GroupVersionResource = schema.GroupVersionResource{
+	Group:    "kubeenforcer.kubescape.io",
+	Version:  "v1alpha1",
+	Resource: "policyexceptions",
+}
+
+// PolicyException temporarily stops enforcing policies in its namespace.
+type PolicyException struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec PolicyExceptionSpec `json:"spec"`
+}
+
+// PolicyExceptionSpec selects the excepted policies and how long the
+// exception lasts.
+type PolicyExceptionSpec struct {
+	// Policies are the names of the ValidatingAdmissionPolicies not enforced
+	// in the exception's namespace.
+	Policies []string `json:"policies"`
+
+	// Reason documents why the exception was granted. It is included in
+	// review reminders.
+	Reason string `json:"reason,omitempty"`
+
+	// ExpiresAt is when the policies are enforced again.
+	ExpiresAt metav1.Time `json:"expiresAt"`
+
+	// ReviewInterval overrides how often a reminder to review the exception
+	// is alerted on while it is active.
+	ReviewInterval *metav1.Duration `json:"reviewInterval,omitempty"`
+}
@@ -0,0 +1,259 @@
+package explain
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/api/admissionregistration/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apiserver/pkg/admission"
+	plugincel "k8s.io/apiserver/pkg/admission/plugin/cel"
+	"k8s.io/apiserver/pkg/admission/plugin/validatingadmissionpolicy"
+	"k8s.io/apiserver/pkg/admission/plugin/validatingadmissionpolicy/matching"
+	"k8s.io/apiserver/pkg/admission/plugin/webhook/matchconditions"
+	celconfig "k8s.io/apiserver/pkg/apis/cel"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	"k8s.io/client-go/dynamic"
+	informers "k8s.io/client-go/informers/admissionregistration/v1alpha1"
+	"k8s.io/client-go/kubernetes"
+	listers "k8s.io/client-go/listers/admissionregistration/v1alpha1"
+	listersv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/klog/v2"
+
+	"github.com/kubescape/kubeenforcer/pkg/decision"
+)
+
+var logger klog.Logger = klog.LoggerWithName(klog.Background(), "explain")
+
+const (
+	// Header asks for an explanation of a request sent directly to /validate.
+	Header = "X-Kubeenforcer-Explain"
+
+	// Annotation asks for an explanation of the admission of the annotated
+	// object.
+	Annotation = "kubeenforcer.kubescape.io/explain"
+)
+
+// Options selects who may ask for explanations. Explanations disclose the
+// policies and the objects they read, so they are only returned to the
+// listed users and members of the listed groups.
+type Options struct {
+	Users  []string
+	Groups []string
+}
+
+// Explainer traces the evaluation of a request by every policy: whether it
+// and its bindings matched, and the result and cost of each matchCondition
+// and validation.
+type Explainer struct {
+	policies   listers.ValidatingAdmissionPolicyLister
+	bindings   listers.ValidatingAdmissionPolicyBindingLister
+	matcher    validatingadmissionpolicy.Matcher
+	restMapper meta.RESTMapper
+	dynamic    dynamic.Interface
+	authorizer authorizer.Authorizer
+	compiler   plugincel.FilterCompiler
+	users      sets.Set[string]
+	groups     sets.Set[string]
+}
+
+// New creates an Explainer for the policies and bindings watched by the
+// given informers. Params are read through dynamicClient, and authorizer,
+// which may be nil, backs the authorizer variable.
+func New(policyInformer informers.ValidatingAdmissionPolicyInformer, bindingInformer informers.ValidatingAdmissionPolicyBindingInformer, namespaces listersv1.NamespaceLister, client kubernetes.Interface, restMapper meta.RESTMapper, dynamicClient dynamic.Interface, authz authorizer.Authorizer, opts Options) *Explainer {
+	return &Explainer{
+		policies:   policyInformer.Lister(),
+		bindings:   bindingInformer.Lister(),
+		matcher:    validatingadmissionpolicy.NewMatcher(matching.NewMatcher(namespaces, client)),
+		restMapper: restMapper,
+		dynamic:    dynamicClient,
+		authorizer: authz,
+		compiler:   plugincel.NewFilterCompiler(),
+		users:      sets.New(opts.Users...),
+		groups:     sets.New(opts.Groups...),
+	}
+}
+
+// Requested returns whether an explanation of attrs was asked for through
+// the request header or the object annotation by an allowed user.
+func (e *Explainer) Requested(req *http.Request, attrs admission.Attributes) bool {
+	asked := req.Header.Get(Header) == "true"
+	if obj, err := meta.Accessor(attrs.GetObject()); err == nil && obj.GetAnnotations()[Annotation] == "true" {
+		asked = true
+	}
+	if !asked {
+		return false
+	}
+
+	user := attrs.GetUserInfo()
+	if user != nil && (e.users.Has(user.GetName()) || e.groups.HasAny(user.GetGroups()...)) {
+		return true
+	}
+	logger.V(2).Info("ignoring explanation request from unauthorized user", "user", userName(attrs))
+	return false
+}
+
+// Explain evaluates attrs against every policy and returns the trace, one
+// line per step.
+func (e *Explainer) Explain(ctx context.Context, attrs admission.Attributes, o admission.ObjectInterfaces) []string {
+	t := &trace{}
+	policies, err := e.policies.List(labels.Everything())
+	if err != nil {
+		return []string{fmt.Sprintf("explain: failed to list policies: %v", err)}
+	}
+	bindings, err := e.bindings.List(labels.Everything())
+	if err != nil {
+		return []string{fmt.Sprintf("explain: failed to list bindings: %v", err)}
+	}
+
+	var unmatched int
+	for _, policy := range policies {
+		// Policies without match constraints match nothing
+		if policy.Spec.MatchConstraints == nil {
+			unmatched++
+			continue
+		}
+		matches, gvk, err := e.matcher.DefinitionMatches(attrs, o, policy)
+		if err != nil {
+			t.add("policy %s: failed to match: %v", policy.Name, err)
+			continue
+		}
+		if !matches {
+			unmatched++
+			continue
+		}
+
+		versionedAttr, err := admission.NewVersionedAttributes(attrs, gvk, o)
+		if err != nil {
+			t.add("policy %s: failed to convert object to %v: %v", policy.Name, gvk, err)
+			continue
+		}
+		request := plugincel.CreateAdmissionRequest(versionedAttr.Attributes)
+
+		bound := false
+		for _, binding := range bindings {
+			if binding.Spec.PolicyName != policy.Name {
+				continue
+			}
+			bound = true
+			e.explainBinding(ctx, t, policy, binding, versionedAttr, request, o)
+		}
+		if !bound {
+			t.add("policy %s: matched, but has no binding", policy.Name)
+		}
+	}
+	t.add("%d of %d policies did not match", unmatched, len(policies))
+
+	logger.Info("explained request", "decision", decision.IDFromContext(ctx), "user", userName(attrs), "resource", attrs.GetResource().String(), "namespace", attrs.GetNamespace(), "name", attrs.GetName(), "trace", t.lines)
+	return t.lines
+}
+
+func (e *Explainer) explainBinding(ctx context.Context, t *trace, policy *v1alpha1.ValidatingAdmissionPolicy, binding *v1alpha1.ValidatingAdmissionPolicyBinding, versionedAttr *admission.VersionedAttributes, request *admissionv1.AdmissionRequest, o admission.ObjectInterfaces) {
+	prefix := fmt.Sprintf("policy %s binding %s", policy.Name, binding.Name)
+
+	matches, err := e.matcher.BindingMatches(versionedAttr.Attributes, o, binding)
+	if err != nil {
+		t.add("%s: failed to match: %v", prefix, err)
+		return
+	}
+	if !matches {
+		t.add("%s: not matched", prefix)
+		return
+	}
+	actions := make([]string, len(binding.Spec.ValidationActions))
+	for i, action := range binding.Spec.ValidationActions {
+		actions[i] = string(action)
+	}
+	t.add("%s: matched, actions %s", prefix, strings.Join(actions, ","))
+
+	vars := plugincel.OptionalVariableBindings{Authorizer: e.authorizer}
+	if policy.Spec.ParamKind != nil && binding.Spec.ParamRef != nil {
+		params, err := e.params(ctx, policy.Spec.ParamKind, binding.Spec.ParamRef)
+		if err != nil {
+			t.add("%s: failed to get params: %v", prefix, err)
+			return
+		}
+		vars.VersionedParams = params
+	}
+	decls := plugincel.OptionalVariableDeclarations{HasParams: policy.Spec.ParamKind != nil, HasAuthorizer: e.authorizer != nil}
+
+	for i, c := range policy.Spec.MatchConditions {
+		result := e.eval(ctx, &matchconditions.MatchCondition{Name: c.Name, Expression: c.Expression}, decls, versionedAttr, request, vars)
+		t.add("%s: matchConditions[%d] %s: %s", prefix, i, c.Name, result)
+		if result.value != "true" {
+			t.add("%s: skipped, matchCondition %s did not pass", prefix, c.Name)
+			return
+		}
+	}
+	for i, v := range policy.Spec.Validations {
+		result := e.eval(ctx, &validatingadmissionpolicy.ValidationCondition{Expression: v.Expression}, decls, versionedAttr, request, vars)
+		t.add("%s: validations[%d] %q: %s", prefix, i, v.Expression, result)
+	}
+}
+
+type result struct {
+	value string
+	cost  int64
+	err   error
+}
+
+func (r result) String() string {
+	if r.err != nil {
+		return fmt.Sprintf("error (cost %d): %v", r.cost, r.err)
+	}
+	return fmt.Sprintf("%s (cost %d)", r.value, r.cost)
+}
+
+// eval evaluates a single expression, so its cost can be told apart.
+func (e *Explainer) eval(ctx context.Context, accessor plugincel.ExpressionAccessor, decls plugincel.OptionalVariableDeclarations, versionedAttr *admission.VersionedAttributes, request *admissionv1.AdmissionRequest, vars plugincel.OptionalVariableBindings) result {
+	filter := e.compiler.Compile([]plugincel.ExpressionAccessor{accessor}, decls, celconfig.PerCallLimit)
+	results, remaining, err := filter.ForInput(ctx, versionedAttr, request, vars, celconfig.RuntimeCELCostBudget)
+	if err != nil {
+		return result{err: err}
+	}
+	r := result{cost: celconfig.RuntimeCELCostBudget - remaining, err: results[0].Error}
+	if results[0].EvalResult != nil {
+		r.value = fmt.Sprint(results[0].EvalResult.Value())
+	}
+	return r
+}
+
+// params gets the params object referenced by a binding.
+func (e *Explainer) params(ctx context.Context, kind *v1alpha1.ParamKind, ref *v1alpha1.ParamRef) (runtime.Object, error) {
+	gv, err := schema.ParseGroupVersion(kind.APIVersion)
+	if err != nil {
+		return nil, err
+	}
+	mapping, err := e.restMapper.RESTMapping(schema.GroupKind{Group: gv.Group, Kind: kind.Kind}, gv.Version)
+	if err != nil {
+		return nil, err
+	}
+	var client dynamic.ResourceInterface = e.dynamic.Resource(mapping.Resource)
+	if ref.Namespace != "" {
+		client = e.dynamic.Resource(mapping.Resource).Namespace(ref.Namespace)
+	}
+	return client.Get(ctx, ref.Name, metav1.GetOptions{})
+}
+
+func userName(attrs admission.Attributes) string {
+	if user := attrs.GetUserInfo(); user != nil {
+		return user.GetName()
+	}
+	return ""
+}
+
+type trace struct {
+	lines []string
+}
+
+func (t *trace) add(format string, args ...interface{}) {
+	t.lines = append(t.lines, "explain: "+fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,276 @@
+package exprcache
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/interpreter"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apiserver/pkg/cel/library"
+	"k8s.io/klog/v2"
+
+	"github.com/kubescape/kubeenforcer/pkg/metrics"
+)
+
+var logger klog.Logger = klog.LoggerWithName(klog.Background(), "exprcache")
+
+var cacheResults = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Name:      "expression_cache_results_total",
+	Help:      "Evaluations of sub-expressions shared across policies, by result: hit when computed earlier for the same request, or miss.",
+}, []string{"result"})
+
+func init() {
+	metrics.Registry.MustRegister(cacheResults)
+}
+
+// function is the CEL function marking a cached sub-expression:
+// cache.memo(key, expression) returns expression, computed once per object,
+// old object and request for all the expressions sharing key.
+const function = "cache.memo"
+
+// paramsFunction is the CEL function marking a cached expression that may
+// also refer to params: cache.memoParams(key, expression) returns expression,
+// computed once per object, old object, request and params for all the
+// expressions sharing key. It binds the variables of policies.
+const paramsFunction = "cache.memoParams"
+
+// DefaultSize is the number of results cached when the variables of
+// policies alone are cached.
+const DefaultSize = 4096
+
+var enableOnce sync.Once
+
+// Enable declares the functions marking the sub-expressions rewritten by
+// Rewrite and the variables bound by Memo, whose results are cached in up to
+// size entries. It must be called before any expression is compiled, since
+// the functions are added to the Kubernetes CEL libraries.
+func Enable(size int) {
+	enableOnce.Do(func() {
+		cache.size = size
+		library.ExtensionLibs = append(library.ExtensionLibs, cel.Lib(cacheLib{}))
+	})
+}
+
+// Memo returns expression marked to be computed once per object, old object,
+// request and params for all the expressions marked with key. expression may
+// refer to params but not to the authorizer, and key must identify it,
+// e.g. by hashing it, since results are shared by key.
+func Memo(key, expression string) string {
+	return fmt.Sprintf("%s(%s, (%s))", paramsFunction, strconv.Quote(key), expression)
+}
+
+type cacheLib struct{}
+
+func (cacheLib) CompileOptions() []cel.EnvOption {
+	t := cel.TypeParamType("T")
+	return []cel.EnvOption{
+		cel.Function(function,
+			cel.Overload("cache_memo_string_T", []*cel.Type{cel.StringType, t}, t,
+				cel.BinaryBinding(func(_, value ref.Val) ref.Val { return value }))),
+		cel.Function(paramsFunction,
+			cel.Overload("cache_memoParams_string_T", []*cel.Type{cel.StringType, t}, t,
+				cel.BinaryBinding(func(_, value ref.Val) ref.Val { return value }))),
+	}
+}
+
+func (cacheLib) ProgramOptions() []cel.ProgramOption {
+	return []cel.ProgramOption{cel.CustomDecorator(decorate)}
+}
+
+// decorate replaces the calls of function and paramsFunction with a constant
+// key by memos, which only evaluate the expression they mark when it is not
+// cached.
+func decorate(i interpreter.Interpretable) (interpreter.Interpretable, error) {
+	call, ok := i.(interpreter.InterpretableCall)
+	if !ok || (call.Function() != function && call.Function() != paramsFunction) || len(call.Args()) != 2 {
+		return i, nil
+	}
+	key, ok := call.Args()[0].(interpreter.InterpretableConst)
+	if !ok {
+		return i, nil
+	}
+	k, ok := key.Value().(types.String)
+	if !ok {
+		return i, nil
+	}
+	return &memo{Interpretable: call, key: string(k), expression: call.Args()[1], params: call.Function() == paramsFunction}, nil
+}
+
+type memo struct {
+	interpreter.Interpretable
+	key        string
+	expression interpreter.Interpretable
+	// params is whether the expression may refer to params, which then
+	// scope its results too
+	params bool
+}
+
+func (m *memo) Eval(activation interpreter.Activation) ref.Val {
+	scope, ok := scopeOf(activation, m.params)
+	if !ok {
+		return m.expression.Eval(activation)
+	}
+	k := entryKey{key: m.key, scope: scope}
+	if value, ok := cache.get(k); ok {
+		cacheResults.WithLabelValues("hit").Inc()
+		return value
+	}
+	value := m.expression.Eval(activation)
+	if types.IsUnknown(value) {
+		return value
+	}
+	cacheResults.WithLabelValues("miss").Inc()
+	cache.put(k, value)
+	return value
+}
+
+// scopeOf returns the digest of the object, old object and request evaluated
+// in activation, the only variables cached expressions refer to, and of the
+// params if params is set. Results are keyed by content rather than by
+// request, since the same request is evaluated again after it was mutated,
+// e.g. by auto-remediation or another mutating webhook.
+func scopeOf(activation interpreter.Activation, params bool) (digest, bool) {
+	request, ok := activation.ResolveName("request")
+	if !ok {
+		return digest{}, false
+	}
+	object, _ := activation.ResolveName("object")
+	oldObject, _ := activation.ResolveName("oldObject")
+	values := []interface{}{object, oldObject, request}
+	if params {
+		p, _ := activation.ResolveName("params")
+		values = append(values, p)
+	}
+
+	h := sha256.New()
+	for _, v := range values {
+		sum := digests.of(v)
+		h.Write(sum[:])
+	}
+	var scope digest
+	h.Sum(scope[:0])
+	return scope, true
+}
+
+type digest [sha256.Size]byte
+
+type entryKey struct {
+	key   string
+	scope digest
+}
+
+// cache holds the cached results. Entries are only of use while their
+// request is evaluated, so it is emptied when full rather than evicting
+// entries one by one.
+var cache = &resultCache{entries: map[entryKey]ref.Val{}}
+
+type resultCache struct {
+	lock    sync.Mutex
+	size    int
+	entries map[entryKey]ref.Val
+}
+
+func (r *resultCache) get(k entryKey) (ref.Val, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	value, ok := r.entries[k]
+	return value, ok
+}
+
+func (r *resultCache) put(k entryKey, value ref.Val) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if len(r.entries) >= r.size {
+		r.entries = map[entryKey]ref.Val{}
+	}
+	r.entries[k] = value
+}
+
+// digests remembers the digests of the JSON objects evaluated, by identity,
+// as every expression of a policy is evaluated against the same objects.
+// Entries hold on to their object, so its address is not reused by another
+// object while it is cached. Objects are not modified once evaluated.
+var digests = &digestCache{entries: map[uintptr]digestEntry{}}
+
+type digestCache struct {
+	lock    sync.Mutex
+	entries map[uintptr]digestEntry
+}
+
+type digestEntry struct {
+	object map[string]interface{}
+	sum    digest
+}
+
+// of returns the digest of the JSON value v, a ref.Val or the value of one.
+func (d *digestCache) of(v interface{}) digest {
+	if val, ok := v.(ref.Val); ok {
+		v = val.Value()
+	}
+	object, ok := v.(map[string]interface{})
+	if !ok || object == nil {
+		return sum(v)
+	}
+
+	id := reflect.ValueOf(object).Pointer()
+	d.lock.Lock()
+	entry, ok := d.entries[id]
+	d.lock.Unlock()
+	if ok {
+		return entry.sum
+	}
+
+	entry = digestEntry{object: object, sum: sum(object)}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if len(d.entries) >= cache.size {
+		d.entries = map[uintptr]digestEntry{}
+	}
+	d.entries[id] = entry
+	return entry.sum
+}
+
+// sum hashes the JSON value v, with the keys of objects sorted.
+func sum(v interface{}) digest {
+	h := sha256.New()
+	writeValue(h, v)
+	var s digest
+	h.Sum(s[:0])
+	return s
+}
+
+func writeValue(h hash.Hash, v interface{}) {
+	switch v := v.(type) {
+	case nil:
+		h.Write([]byte{'n'})
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		fmt.Fprintf(h, "m%d:", len(keys))
+		for _, k := range keys {
+			fmt.Fprintf(h, "%d:%s", len(k), k)
+			writeValue(h, v[k])
+		}
+	case []interface{}:
+		fmt.Fprintf(h, "l%d:", len(v))
+		for _, e := range v {
+			writeValue(h, e)
+		}
+	case string:
+		fmt.Fprintf(h, "s%d:%s", len(v), v)
+	default:
+		fmt.Fprintf(h, "%T:%v;", v, v)
+	}
+}
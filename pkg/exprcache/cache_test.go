@@ -0,0 +1,172 @@
+package exprcache
+
+import (
+	"testing"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types/ref"
+)
+
+func TestMemo(t *testing.T) {
+	cache.size = 16
+	env, err := cel.NewEnv(cel.Lib(cacheLib{}),
+		cel.Variable("object", cel.DynType),
+		cel.Variable("oldObject", cel.DynType),
+		cel.Variable("request", cel.DynType))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expression, err := Rewrite("object.spec.containers.all(c, has(c.resources))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ast, issues := env.Compile(expression)
+	if issues != nil && issues.Err() != nil {
+		t.Fatal(issues.Err())
+	}
+	program, err := env.Program(ast)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pod := func(resources bool) map[string]interface{} {
+		container := map[string]interface{}{"name": "web"}
+		if resources {
+			container["resources"] = map[string]interface{}{}
+		}
+		return map[string]interface{}{"spec": map[string]interface{}{"containers": []interface{}{container}}}
+	}
+	request := map[string]interface{}{"uid": "8d9c", "operation": "CREATE", "name": "web"}
+
+	tests := []struct {
+		name   string
+		object map[string]interface{}
+		want   bool
+	}{
+		{name: "object", object: pod(false), want: false},
+		{name: "same object", object: pod(false), want: false},
+		// e.g. the object remediated by the webhook, evaluated again for
+		// the same request
+		{name: "changed object", object: pod(true), want: true},
+		{name: "object changed back", object: pod(false), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, _, err := program.Eval(map[string]interface{}{"object": tt.object, "oldObject": nil, "request": request})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := out.Value(); got != tt.want {
+				t.Errorf("Eval() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoParams(t *testing.T) {
+	cache.size = 16
+	evaluations := 0
+	env, err := cel.NewEnv(cel.Lib(cacheLib{}),
+		cel.Variable("object", cel.DynType),
+		cel.Variable("oldObject", cel.DynType),
+		cel.Variable("request", cel.DynType),
+		cel.Variable("params", cel.DynType),
+		cel.Function("counted", cel.Overload("counted_dyn", []*cel.Type{cel.DynType}, cel.DynType,
+			cel.UnaryBinding(func(value ref.Val) ref.Val {
+				evaluations++
+				return value
+			}))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Two expressions of a policy binding the same variable
+	var programs []cel.Program
+	for _, expression := range []string{"variables.replicas > params.min", "variables.replicas < 10"} {
+		bound := "[{'replicas': " + Memo("variable:replicas", "counted(object.spec.replicas + params.offset)") + "}].map(variables, " + expression + ")[0]"
+		ast, issues := env.Compile(bound)
+		if issues != nil && issues.Err() != nil {
+			t.Fatal(issues.Err())
+		}
+		program, err := env.Program(ast)
+		if err != nil {
+			t.Fatal(err)
+		}
+		programs = append(programs, program)
+	}
+
+	object := map[string]interface{}{"spec": map[string]interface{}{"replicas": int64(3)}}
+	request := map[string]interface{}{"uid": "8d9c", "operation": "CREATE", "name": "web"}
+	params := func(offset int64) map[string]interface{} {
+		return map[string]interface{}{"min": int64(1), "offset": offset}
+	}
+
+	tests := []struct {
+		name   string
+		params map[string]interface{}
+		// evaluations of the variable by the expressions of the policy
+		evaluations int
+	}{
+		{name: "computed once by the expressions of a policy", params: params(0), evaluations: 1},
+		{name: "cached for the same params", params: params(0), evaluations: 0},
+		{name: "computed again for other params", params: params(1), evaluations: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			evaluations = 0
+			for _, program := range programs {
+				out, _, err := program.Eval(map[string]interface{}{"object": object, "oldObject": nil, "request": request, "params": tt.params})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if out.Value() != true {
+					t.Errorf("Eval() = %v, want true", out.Value())
+				}
+			}
+			if evaluations != tt.evaluations {
+				t.Errorf("evaluations = %d, want %d", evaluations, tt.evaluations)
+			}
+		})
+	}
+}
+
+func TestSum(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b interface{}
+		same bool
+	}{
+		{
+			name: "maps with the same keys in another order",
+			a:    map[string]interface{}{"a": "1", "b": int64(2)},
+			b:    map[string]interface{}{"b": int64(2), "a": "1"},
+			same: true,
+		},
+		{
+			name: "string and number",
+			a:    map[string]interface{}{"a": "1"},
+			b:    map[string]interface{}{"a": int64(1)},
+		},
+		{
+			name: "keys and values shifted",
+			a:    map[string]interface{}{"ab": "c"},
+			b:    map[string]interface{}{"a": "bc"},
+		},
+		{
+			name: "nested list",
+			a:    []interface{}{[]interface{}{"a"}, "b"},
+			b:    []interface{}{[]interface{}{"a", "b"}},
+		},
+		{
+			name: "null and empty object",
+			a:    nil,
+			b:    map[string]interface{}{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if (sum(tt.a) == sum(tt.b)) != tt.same {
+				t.Errorf("sum() of %v and %v same = %v, want %v", tt.a, tt.b, !tt.same, tt.same)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package exprcache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"k8s.io/api/admissionregistration/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	admissionregistrationv1alpha1 "k8s.io/client-go/kubernetes/typed/admissionregistration/v1alpha1"
+
+	"github.com/kubescape/kubeenforcer/pkg/variables"
+)
+
+// NewClient returns a client whose ValidatingAdmissionPolicies have the
+// comprehensions of their expressions and variables that only depend on the
+// request marked to be cached, so an evaluator built on it computes those
+// shared by several policies once per request. Policies that cannot be
+// rewritten are returned as they are.
+func NewClient(client kubernetes.Interface) kubernetes.Interface {
+	return markingClient{Interface: client}
+}
+
+type markingClient struct {
+	kubernetes.Interface
+}
+
+func (c markingClient) AdmissionregistrationV1alpha1() admissionregistrationv1alpha1.AdmissionregistrationV1alpha1Interface {
+	return markingGroup{AdmissionregistrationV1alpha1Interface: c.Interface.AdmissionregistrationV1alpha1()}
+}
+
+type markingGroup struct {
+	admissionregistrationv1alpha1.AdmissionregistrationV1alpha1Interface
+}
+
+func (g markingGroup) ValidatingAdmissionPolicies() admissionregistrationv1alpha1.ValidatingAdmissionPolicyInterface {
+	return markingPolicies{ValidatingAdmissionPolicyInterface: g.AdmissionregistrationV1alpha1Interface.ValidatingAdmissionPolicies()}
+}
+
+type markingPolicies struct {
+	admissionregistrationv1alpha1.ValidatingAdmissionPolicyInterface
+}
+
+func (p markingPolicies) Get(ctx context.Context, name string, opts metav1.GetOptions) (*v1alpha1.ValidatingAdmissionPolicy, error) {
+	policy, err := p.ValidatingAdmissionPolicyInterface.Get(ctx, name, opts)
+	if err != nil {
+		return nil, err
+	}
+	return marked(policy), nil
+}
+
+func (p markingPolicies) List(ctx context.Context, opts metav1.ListOptions) (*v1alpha1.ValidatingAdmissionPolicyList, error) {
+	list, err := p.ValidatingAdmissionPolicyInterface.List(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	for i := range list.Items {
+		list.Items[i] = *marked(&list.Items[i])
+	}
+	return list, nil
+}
+
+func (p markingPolicies) Watch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error) {
+	w, err := p.ValidatingAdmissionPolicyInterface.Watch(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return watch.Filter(w, func(event watch.Event) (watch.Event, bool) {
+		if policy, ok := event.Object.(*v1alpha1.ValidatingAdmissionPolicy); ok {
+			event.Object = marked(policy)
+		}
+		return event, true
+	}), nil
+}
+
+// marked returns policy with its shared comprehensions marked, or as it is
+// if they cannot be.
+func marked(policy *v1alpha1.ValidatingAdmissionPolicy) *v1alpha1.ValidatingAdmissionPolicy {
+	out, err := RewritePolicy(policy)
+	if err != nil {
+		logger.Error(err, "failed to mark cached expressions", "policy", policy.Name)
+		return policy
+	}
+	return out
+}
+
+// RewritePolicy returns a copy of policy with Rewrite applied to its
+// expressions and to the expressions of the variables it declares.
+func RewritePolicy(policy *v1alpha1.ValidatingAdmissionPolicy) (*v1alpha1.ValidatingAdmissionPolicy, error) {
+	out := policy.DeepCopy()
+	var errs []error
+	rewrite := func(expression *string) {
+		if *expression == "" {
+			return
+		}
+		rewritten, err := Rewrite(*expression)
+		if err != nil {
+			errs = append(errs, err)
+			return
+		}
+		*expression = rewritten
+	}
+	for i := range out.Spec.MatchConditions {
+		rewrite(&out.Spec.MatchConditions[i].Expression)
+	}
+	for i := range out.Spec.Validations {
+		rewrite(&out.Spec.Validations[i].Expression)
+		rewrite(&out.Spec.Validations[i].MessageExpression)
+	}
+	for i := range out.Spec.AuditAnnotations {
+		rewrite(&out.Spec.AuditAnnotations[i].ValueExpression)
+	}
+
+	// Invalid variables are left for their expansion to report
+	if vars, err := variables.Parse(policy.Annotations); err == nil && len(vars) > 0 {
+		for i := range vars {
+			rewrite(&vars[i].Expression)
+		}
+		value, err := json.Marshal(vars)
+		if err != nil {
+			return nil, err
+		}
+		out.Annotations[variables.Annotation] = string(value)
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return out, nil
+}
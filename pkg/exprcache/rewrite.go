@@ -0,0 +1,184 @@
+package exprcache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/parser"
+	exprpb "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+// requestVariables are the variables identical across the policies
+// evaluating a request, which cached sub-expressions may refer to.
+var requestVariables = map[string]bool{"object": true, "oldObject": true, "request": true}
+
+// Rewrite returns expression with the comprehensions that only depend on
+// the request, e.g. object.spec.containers.all(c, has(c.resources)), marked
+// to be cached under the hash of their text, so policies sharing them compute
+// them once per request. Comprehensions within other comprehensions are not
+// marked, nor are expressions already marked. expression is returned
+// unchanged if nothing is marked.
+func Rewrite(expression string) (string, error) {
+	// Macros are unparsed from the calls tracked
+	env, err := cel.NewEnv(cel.EnableMacroCallTracking())
+	if err != nil {
+		return "", err
+	}
+	ast, issues := env.Parse(expression)
+	if issues != nil && issues.Err() != nil {
+		return "", issues.Err()
+	}
+	root := ast.Expr()
+	info := ast.SourceInfo()
+
+	// Nodes added are numbered after the existing ones
+	lastID := maxID(root)
+	for id := range info.GetMacroCalls() {
+		if id > lastID {
+			lastID = id
+		}
+	}
+	newID := func() int64 {
+		lastID++
+		return lastID
+	}
+
+	marked := 0
+	var rewriteErr error
+	var walk func(e **exprpb.Expr)
+	walk = func(e **exprpb.Expr) {
+		if *e == nil {
+			return
+		}
+		switch k := (*e).ExprKind.(type) {
+		case *exprpb.Expr_SelectExpr:
+			walk(&k.SelectExpr.Operand)
+		case *exprpb.Expr_CallExpr:
+			// Expressions already marked are left as they are
+			if target := k.CallExpr.Target.GetIdentExpr(); target != nil && target.Name == "cache" && k.CallExpr.Function == "memo" {
+				return
+			}
+			walk(&k.CallExpr.Target)
+			for i := range k.CallExpr.Args {
+				walk(&k.CallExpr.Args[i])
+			}
+		case *exprpb.Expr_ListExpr:
+			for i := range k.ListExpr.Elements {
+				walk(&k.ListExpr.Elements[i])
+			}
+		case *exprpb.Expr_StructExpr:
+			for _, entry := range k.StructExpr.Entries {
+				if key, ok := entry.KeyKind.(*exprpb.Expr_CreateStruct_Entry_MapKey); ok {
+					walk(&key.MapKey)
+				}
+				walk(&entry.Value)
+			}
+		case *exprpb.Expr_ComprehensionExpr:
+			// Macros record their arguments apart from their expansion, which
+			// is not walked, as marks within it would not be unparsed
+			free := freeVariables(*e)
+			if len(free) == 0 {
+				return
+			}
+			for name := range free {
+				if !requestVariables[name] {
+					return
+				}
+			}
+			text, err := parser.Unparse(*e, info)
+			if err != nil {
+				rewriteErr = err
+				return
+			}
+			sum := sha256.Sum256([]byte(text))
+			key := &exprpb.Expr{Id: newID(), ExprKind: &exprpb.Expr_ConstExpr{ConstExpr: &exprpb.Constant{
+				ConstantKind: &exprpb.Constant_StringValue{StringValue: hex.EncodeToString(sum[:8])},
+			}}}
+			target := &exprpb.Expr{Id: newID(), ExprKind: &exprpb.Expr_IdentExpr{IdentExpr: &exprpb.Expr_Ident{Name: "cache"}}}
+			*e = &exprpb.Expr{Id: newID(), ExprKind: &exprpb.Expr_CallExpr{CallExpr: &exprpb.Expr_Call{
+				Target:   target,
+				Function: "memo",
+				Args:     []*exprpb.Expr{key, *e},
+			}}}
+			marked++
+		}
+	}
+	walk(&root)
+	if rewriteErr != nil {
+		return "", rewriteErr
+	}
+	if marked == 0 {
+		return expression, nil
+	}
+	return parser.Unparse(root, info)
+}
+
+// maxID returns the largest ID of the nodes of e.
+func maxID(e *exprpb.Expr) int64 {
+	if e == nil {
+		return 0
+	}
+	id := e.Id
+	for _, child := range children(e) {
+		if c := maxID(child); c > id {
+			id = c
+		}
+	}
+	return id
+}
+
+func children(e *exprpb.Expr) []*exprpb.Expr {
+	switch k := e.ExprKind.(type) {
+	case *exprpb.Expr_SelectExpr:
+		return []*exprpb.Expr{k.SelectExpr.Operand}
+	case *exprpb.Expr_CallExpr:
+		return append([]*exprpb.Expr{k.CallExpr.Target}, k.CallExpr.Args...)
+	case *exprpb.Expr_ListExpr:
+		return k.ListExpr.Elements
+	case *exprpb.Expr_StructExpr:
+		var out []*exprpb.Expr
+		for _, entry := range k.StructExpr.Entries {
+			out = append(out, entry.GetMapKey(), entry.Value)
+		}
+		return out
+	case *exprpb.Expr_ComprehensionExpr:
+		c := k.ComprehensionExpr
+		return []*exprpb.Expr{c.IterRange, c.AccuInit, c.LoopCondition, c.LoopStep, c.Result}
+	}
+	return nil
+}
+
+// freeVariables returns the names of the variables e refers to that it does
+// not bind.
+func freeVariables(e *exprpb.Expr) map[string]bool {
+	free := map[string]bool{}
+	if e == nil {
+		return free
+	}
+	switch k := e.ExprKind.(type) {
+	case *exprpb.Expr_IdentExpr:
+		free[k.IdentExpr.Name] = true
+	case *exprpb.Expr_ComprehensionExpr:
+		c := k.ComprehensionExpr
+		for _, child := range []*exprpb.Expr{c.IterRange, c.AccuInit} {
+			for name := range freeVariables(child) {
+				free[name] = true
+			}
+		}
+		for _, child := range []*exprpb.Expr{c.LoopCondition, c.LoopStep, c.Result} {
+			for name := range freeVariables(child) {
+				if name != c.IterVar && name != c.AccuVar {
+					free[name] = true
+				}
+			}
+		}
+	default:
+		for _, child := range children(e) {
+			for name := range freeVariables(child) {
+				free[name] = true
+			}
+		}
+	}
+	return free
+}
@@ -0,0 +1,121 @@
+package exprcache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/parser"
+)
+
+func TestRewrite(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		// marked are the comprehensions expected to be marked
+		marked []string
+	}{
+		{
+			name:       "comprehension over the object",
+			expression: "object.spec.containers.all(c, has(c.resources))",
+			marked:     []string{"object.spec.containers.all(c, has(c.resources))"},
+		},
+		{
+			name:       "comprehensions over the object and the old object",
+			expression: "object.spec.containers.all(c, has(c.resources)) || oldObject.spec.containers.exists(c, c.name == request.name)",
+			marked: []string{
+				"object.spec.containers.all(c, has(c.resources))",
+				"oldObject.spec.containers.exists(c, c.name == request.name)",
+			},
+		},
+		{
+			name:       "nested macros only mark the outer one",
+			expression: "object.spec.containers.all(c, c.ports.all(p, p.containerPort > 1024))",
+			marked:     []string{"object.spec.containers.all(c, c.ports.all(p, p.containerPort > 1024))"},
+		},
+		{
+			name:       "comprehension within a marked expression",
+			expression: "size(object.spec.containers.filter(c, c.image.startsWith('nginx'))) == 0",
+			marked:     []string{"object.spec.containers.filter(c, c.image.startsWith(\"nginx\"))"},
+		},
+		{
+			name:       "comprehension referring to params",
+			expression: "object.spec.containers.all(c, c.image in params.images)",
+		},
+		{
+			name:       "comprehension referring to variables",
+			expression: "object.spec.containers.all(c, c.name != variables.sidecar)",
+		},
+		{
+			name:       "comprehension over params",
+			expression: "params.images.exists(i, i == 'nginx')",
+		},
+		{
+			name:       "no comprehension",
+			expression: "object.metadata.name == 'web' && has(object.spec.replicas)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Rewrite(tt.expression)
+			if err != nil {
+				t.Fatalf("Rewrite() error = %v", err)
+			}
+			if len(tt.marked) == 0 {
+				if got != tt.expression {
+					t.Errorf("Rewrite() = %q, want it unchanged", got)
+				}
+				return
+			}
+
+			if n := strings.Count(got, function+"("); n != len(tt.marked) {
+				t.Errorf("Rewrite() = %q, marks %d expressions, want %d", got, n, len(tt.marked))
+			}
+			for _, text := range tt.marked {
+				sum := sha256.Sum256([]byte(text))
+				mark := function + "(\"" + hex.EncodeToString(sum[:8]) + "\", " + text + ")"
+				if !strings.Contains(got, mark) {
+					t.Errorf("Rewrite() = %q, want it to contain %q", got, mark)
+				}
+			}
+
+			// The rewritten expression unparses to itself and is not
+			// marked again
+			if unparsed := unparse(t, got); unparsed != got {
+				t.Errorf("Unparse(Rewrite()) = %q, want %q", unparsed, got)
+			}
+			again, err := Rewrite(got)
+			if err != nil {
+				t.Fatalf("Rewrite(Rewrite()) error = %v", err)
+			}
+			if strings.Count(again, function+"(") != len(tt.marked) {
+				t.Errorf("Rewrite(Rewrite()) = %q, marks expressions again", again)
+			}
+		})
+	}
+}
+
+func TestRewriteInvalid(t *testing.T) {
+	if _, err := Rewrite("object.spec.containers.all(c,"); err == nil {
+		t.Error("Rewrite() error = nil, want a parse error")
+	}
+}
+
+func unparse(t *testing.T, expression string) string {
+	t.Helper()
+	env, err := cel.NewEnv(cel.EnableMacroCallTracking())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ast, issues := env.Parse(expression)
+	if issues != nil && issues.Err() != nil {
+		t.Fatalf("Parse(%q) error = %v", expression, issues.Err())
+	}
+	text, err := parser.Unparse(ast.Expr(), ast.SourceInfo())
+	if err != nil {
+		t.Fatalf("Unparse(%q) error = %v", expression, err)
+	}
+	return text
+}
@@ -0,0 +1,18 @@
+package fips
+
+import "errors"
+
+// Enabled returns whether kubeenforcer was built with the FIPS 140
+// validated BoringCrypto module, GOEXPERIMENT=boringcrypto, in which case
+// TLS is restricted to FIPS-approved versions, cipher suites and curves.
+func Enabled() bool {
+	return enabled()
+}
+
+// Check fails unless FIPS 140 validated crypto is in use.
+func Check() error {
+	if !Enabled() {
+		return errors.New("not built with FIPS 140 validated crypto, build with GOEXPERIMENT=boringcrypto")
+	}
+	return nil
+}
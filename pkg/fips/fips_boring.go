@@ -0,0 +1,14 @@
+//go:build boringcrypto
+
+package fips
+
+import (
+	"crypto/boring"
+
+	// Restricts TLS to FIPS-approved settings
+	_ "crypto/tls/fipsonly"
+)
+
+func enabled() bool {
+	return boring.Enabled()
+}
@@ -0,0 +1,7 @@
+//go:build !boringcrypto
+
+package fips
+
+func enabled() bool {
+	return false
+}
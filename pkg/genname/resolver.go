@@ -0,0 +1,211 @@
+package genname
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/klog/v2"
+
+	"github.com/kubescape/kubeenforcer/pkg/metrics"
+)
+
+var logger klog.Logger = klog.LoggerWithName(klog.Background(), "genname")
+
+var resolutions = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Name:      "generated_name_resolutions_total",
+	Help:      "Objects created with generateName whose name was waited for, by result: resolved, timeout or dropped when too many were pending.",
+}, []string{"result"})
+
+func init() {
+	metrics.Registry.MustRegister(resolutions)
+}
+
+// maxPending bounds the objects waited for, beyond which names are not
+// resolved.
+const maxPending = 1000
+
+// key identifies the objects of a resource in a namespace watched together.
+type key struct {
+	gvr       schema.GroupVersionResource
+	namespace string
+}
+
+type pending struct {
+	generateName string
+	uid          types.UID
+	since        time.Time
+	done         func(name string)
+}
+
+// Resolver resolves the names the API server generates for objects created
+// with generateName, which admission requests for their creation do not
+// carry, by watching for their creation.
+type Resolver struct {
+	client  dynamic.Interface
+	timeout time.Duration
+
+	lock sync.Mutex
+	ctx  context.Context
+	// pending are the objects waited for, oldest first, by watched
+	// resource and namespace
+	pending  map[key][]*pending
+	total    int
+	watching map[key]bool
+}
+
+// New creates a Resolver watching resources through client, giving up on
+// objects not created within timeout.
+func New(client dynamic.Interface, timeout time.Duration) *Resolver {
+	return &Resolver{client: client, timeout: timeout, pending: map[key][]*pending{}, watching: map[key]bool{}}
+}
+
+// Run gives up on the objects not created in time until ctx is cancelled.
+// Names are only resolved while it runs.
+func (r *Resolver) Run(ctx context.Context) error {
+	r.lock.Lock()
+	r.ctx = ctx
+	r.lock.Unlock()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			r.expire(time.Time{})
+			return nil
+		case now := <-ticker.C:
+			r.expire(now.Add(-r.timeout))
+		}
+	}
+}
+
+// Resolve calls done with the name of the object of resource gvr created in
+// namespace from generateName, and with uid if it is known, once it is
+// created, or with "" if it is not within the timeout. done is called at
+// most once, from another goroutine.
+func (r *Resolver) Resolve(gvr schema.GroupVersionResource, namespace, generateName string, uid types.UID, done func(name string)) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.ctx == nil || r.ctx.Err() != nil || r.total >= maxPending {
+		resolutions.WithLabelValues("dropped").Inc()
+		go done("")
+		return
+	}
+
+	k := key{gvr: gvr, namespace: namespace}
+	r.pending[k] = append(r.pending[k], &pending{generateName: generateName, uid: uid, since: time.Now(), done: done})
+	r.total++
+	if !r.watching[k] {
+		r.watching[k] = true
+		go r.watch(r.ctx, k)
+	}
+}
+
+// watch resolves the objects pending for k as they are created, until none
+// is left.
+func (r *Resolver) watch(ctx context.Context, k key) {
+	client := r.client.Resource(k.gvr).Namespace(k.namespace)
+	// Only objects created from now on are of interest
+	list, err := client.List(ctx, metav1.ListOptions{Limit: 1})
+	if err != nil {
+		logger.Error(err, "failed to list objects", "resource", k.gvr.String(), "namespace", k.namespace)
+		r.stopped(k)
+		return
+	}
+	w, err := client.Watch(ctx, metav1.ListOptions{ResourceVersion: list.GetResourceVersion()})
+	if err != nil {
+		logger.Error(err, "failed to watch objects", "resource", k.gvr.String(), "namespace", k.namespace)
+		r.stopped(k)
+		return
+	}
+	defer w.Stop()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			r.stopped(k)
+			return
+		case <-ticker.C:
+			if r.idle(k) {
+				return
+			}
+		case event, ok := <-w.ResultChan():
+			if !ok {
+				// Objects still pending time out, unless another is
+				// waited for, which watches again
+				r.stopped(k)
+				return
+			}
+			if obj, isObj := event.Object.(*unstructured.Unstructured); isObj && event.Type == watch.Added {
+				r.created(k, obj)
+			}
+		}
+	}
+}
+
+// created resolves the oldest object pending for k obj was created for.
+func (r *Resolver) created(k key, obj *unstructured.Unstructured) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for i, p := range r.pending[k] {
+		if p.generateName != obj.GetGenerateName() || (p.uid != "" && p.uid != obj.GetUID()) {
+			continue
+		}
+		r.pending[k] = append(r.pending[k][:i:i], r.pending[k][i+1:]...)
+		r.total--
+		resolutions.WithLabelValues("resolved").Inc()
+		go p.done(obj.GetName())
+		return
+	}
+}
+
+// idle returns whether no object is pending for k, which then stops being
+// watched.
+func (r *Resolver) idle(k key) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if len(r.pending[k]) > 0 {
+		return false
+	}
+	delete(r.pending, k)
+	delete(r.watching, k)
+	return true
+}
+
+// stopped records that k is no longer watched.
+func (r *Resolver) stopped(k key) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.watching, k)
+}
+
+// expire gives up on the objects pending since before, all of them if
+// before is zero.
+func (r *Resolver) expire(before time.Time) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for k, pending := range r.pending {
+		kept := pending[:0]
+		for _, p := range pending {
+			if before.IsZero() || p.since.Before(before) {
+				r.total--
+				resolutions.WithLabelValues("timeout").Inc()
+				go p.done("")
+				continue
+			}
+			kept = append(kept, p)
+		}
+		r.pending[k] = kept
+	}
+}
@@ -0,0 +1,369 @@
+package gitfeedback
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/klog/v2"
+
+	"github.com/kubescape/kubeenforcer/pkg/decision"
+)
+
+var logger klog.Logger = klog.LoggerWithName(klog.Background(), "gitfeedback")
+
+const (
+	bufferSize  = 1000
+	sendTimeout = 10 * time.Second
+
+	// repostAfter is how long the status of a policy on a commit is not
+	// posted again, since GitOps controllers retry denied syncs.
+	repostAfter = time.Hour
+
+	// maxDescription is the longest status description GitHub accepts.
+	maxDescription = 140
+)
+
+var applicationResource = schema.GroupVersionResource{Group: "argoproj.io", Version: "v1alpha1", Resource: "applications"}
+
+// Options configures the forges statuses are posted to. A forge is disabled
+// without a token.
+type Options struct {
+	// GitHubAPI is the API URL of GitHub, e.g. https://api.github.com or
+	// https://github.example.com/api/v3 for GitHub Enterprise Server.
+	GitHubAPI   string
+	GitHubToken string
+
+	// GitLabURL is the URL of GitLab, e.g. https://gitlab.com.
+	GitLabURL   string
+	GitLabToken string
+
+	// ArgoCDNamespace is the namespace of the Argo CD applications whose
+	// tracking IDs do not name one.
+	ArgoCDNamespace string
+
+	// Repositories are the repositories, as host/path, e.g.
+	// github.com/example/payments, whose commits are read from the git
+	// annotations of objects. Anyone creating objects can set those, so
+	// commits of other repositories are only read from Argo CD.
+	Repositories []string
+}
+
+// Sink posts a failed commit status for every denial of an object deployed
+// from a GitHub or GitLab commit, named after the policy and describing the
+// denial, so developers see policy failures on their pull or merge
+// requests. The commit is read from the git annotations of the object if
+// its repository is allowed, or else from the Argo CD application tracking
+// it if the application manages the object. Dry-run denials are ignored. It
+// is a decision.Sink; denials are buffered and dropped if the forges cannot
+// keep up.
+type Sink struct {
+	opts         Options
+	dynamic      dynamic.Interface
+	client       *http.Client
+	repositories map[string]bool
+
+	githubHost string
+	gitlabHost string
+
+	buffer  chan *decision.Decision
+	dropped atomic.Int64
+	// posted is when the status of a policy on a commit was last posted
+	posted map[string]time.Time
+}
+
+// New creates a Sink posting with client, reading Argo CD applications with
+// dynamicClient.
+func New(opts Options, dynamicClient dynamic.Interface, client *http.Client) (*Sink, error) {
+	c := *client
+	c.Timeout = sendTimeout
+	s := &Sink{
+		opts:         opts,
+		dynamic:      dynamicClient,
+		client:       &c,
+		repositories: map[string]bool{},
+		buffer:       make(chan *decision.Decision, bufferSize),
+		posted:       map[string]time.Time{},
+	}
+	for _, repository := range opts.Repositories {
+		key := repositoryKey(repository)
+		if key == "" {
+			return nil, fmt.Errorf("invalid repository %q", repository)
+		}
+		s.repositories[key] = true
+	}
+	if opts.GitHubToken != "" {
+		u, err := url.Parse(opts.GitHubAPI)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid GitHub API URL %q", opts.GitHubAPI)
+		}
+		s.githubHost = strings.TrimPrefix(u.Host, "api.")
+	}
+	if opts.GitLabToken != "" {
+		u, err := url.Parse(opts.GitLabURL)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid GitLab URL %q", opts.GitLabURL)
+		}
+		s.gitlabHost = u.Host
+	}
+	return s, nil
+}
+
+func (s *Sink) Record(d *decision.Decision) {
+	if d.Allowed || d.Throttled || d.DryRun || d.Source == nil {
+		return
+	}
+	select {
+	case s.buffer <- d:
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+// Run posts the statuses of buffered denials until ctx is cancelled.
+func (s *Sink) Run(ctx context.Context) error {
+	logger.Info("posting commit statuses for denials", "github", s.githubHost, "gitlab", s.gitlabHost)
+	defer logger.Info("stopped posting commit statuses")
+
+	ticker := time.NewTicker(repostAfter)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case d := <-s.buffer:
+			if dropped := s.dropped.Swap(0); dropped > 0 {
+				logger.Info("dropped denials because the forges could not keep up", "count", dropped)
+			}
+			if err := s.feedback(ctx, d); err != nil && ctx.Err() == nil {
+				logger.Error(err, "failed to post commit status", "decision", d.ID)
+			}
+		case now := <-ticker.C:
+			for key, posted := range s.posted {
+				if now.Sub(posted) > repostAfter {
+					delete(s.posted, key)
+				}
+			}
+		}
+	}
+}
+
+func (s *Sink) feedback(ctx context.Context, d *decision.Decision) error {
+	repository, commit, err := s.resolve(ctx, d)
+	if err != nil || repository == "" || !isCommit(commit) {
+		return err
+	}
+	host, path := parseRepository(repository)
+
+	key := repository + "@" + commit + "/" + d.Policy
+	if time.Since(s.posted[key]) < repostAfter {
+		return nil
+	}
+
+	name := "kubeenforcer"
+	if d.Policy != "" {
+		name += "/" + d.Policy
+	}
+	description := truncate(describe(d), maxDescription)
+	switch {
+	case host != "" && host == s.githubHost:
+		owner, repo, ok := strings.Cut(path, "/")
+		if !ok {
+			return fmt.Errorf("invalid GitHub repository %s", repository)
+		}
+		err = s.post(ctx, fmt.Sprintf("%s/repos/%s/%s/statuses/%s", strings.TrimSuffix(s.opts.GitHubAPI, "/"), url.PathEscape(owner), url.PathEscape(repo), commit),
+			map[string]string{"Authorization": "Bearer " + s.opts.GitHubToken, "Accept": "application/vnd.github+json"},
+			map[string]string{"state": "failure", "context": name, "description": description})
+	case host != "" && host == s.gitlabHost:
+		err = s.post(ctx, fmt.Sprintf("%s/api/v4/projects/%s/statuses/%s", strings.TrimSuffix(s.opts.GitLabURL, "/"), url.PathEscape(path), commit),
+			map[string]string{"PRIVATE-TOKEN": s.opts.GitLabToken},
+			map[string]string{"state": "failed", "name": name, "description": description})
+	default:
+		logger.V(4).Info("no forge configured for repository", "repository", repository, "decision", d.ID)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	s.posted[key] = time.Now()
+	logger.V(2).Info("posted commit status", "repository", repository, "commit", commit, "policy", d.Policy, "decision", d.ID)
+	return nil
+}
+
+// resolve returns the repository and commit of the object of d: those of
+// its annotations if the repository is allowed, or else those of its Argo CD
+// application if it manages the object.
+func (s *Sink) resolve(ctx context.Context, d *decision.Decision) (string, string, error) {
+	source := d.Source
+	if source.Repository != "" && source.Commit != "" && s.repositories[repositoryKey(source.Repository)] {
+		return source.Repository, source.Commit, nil
+	}
+	if source.ArgoCDApplication == "" || s.dynamic == nil {
+		return "", "", nil
+	}
+
+	namespace, name, ok := strings.Cut(source.ArgoCDApplication, "_")
+	if !ok {
+		namespace, name = s.opts.ArgoCDNamespace, source.ArgoCDApplication
+	}
+	app, err := s.dynamic.Resource(applicationResource).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get Argo CD application %s/%s: %w", namespace, name, err)
+	}
+	// The tracking ID is set on the object, so is only trusted if the
+	// application lists the object among its resources
+	if !manages(app.Object, d) {
+		logger.V(4).Info("Argo CD application does not manage the object", "application", source.ArgoCDApplication, "decision", d.ID)
+		return "", "", nil
+	}
+	repository := firstString(app.Object, "spec", "source", "repoURL")
+	if repository == "" {
+		repository = firstString(app.Object, "spec", "sources", "repoURL")
+	}
+	commit := firstString(app.Object, "status", "sync", "revision")
+	if commit == "" {
+		commit = firstString(app.Object, "status", "sync", "revisions")
+	}
+	return repository, commit, nil
+}
+
+// manages reports whether the Argo CD application app lists the object of d
+// in its status.resources, which include the resources of the target
+// revision that are not synced yet.
+func manages(app map[string]interface{}, d *decision.Decision) bool {
+	if d.Name == "" {
+		return false
+	}
+	resources, _, _ := unstructured.NestedSlice(app, "status", "resources")
+	for _, r := range resources {
+		resource, ok := r.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		group, _, _ := unstructured.NestedString(resource, "group")
+		namespace, _, _ := unstructured.NestedString(resource, "namespace")
+		name, _, _ := unstructured.NestedString(resource, "name")
+		if group == d.Group && namespace == d.Namespace && name == d.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// firstString returns the string at fields of obj, taking the first item of
+// the lists along the way, e.g. of spec.sources.
+func firstString(obj map[string]interface{}, fields ...string) string {
+	var current interface{} = obj
+	for _, field := range fields {
+		if list, ok := current.([]interface{}); ok && len(list) > 0 {
+			current = list[0]
+		}
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return ""
+		}
+		current = m[field]
+	}
+	if list, ok := current.([]interface{}); ok && len(list) > 0 {
+		current = list[0]
+	}
+	value, _ := current.(string)
+	return value
+}
+
+// isCommit reports whether revision is a commit SHA, rather than e.g. the
+// version of a Helm chart.
+func isCommit(revision string) bool {
+	if len(revision) != 40 && len(revision) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(revision)
+	return err == nil
+}
+
+// parseRepository returns the host and path, without .git, of a git
+// repository URL, e.g. https://github.com/org/repo.git or
+// git@github.com:org/repo.git.
+func parseRepository(repository string) (string, string) {
+	var host, path string
+	if u, err := url.Parse(repository); err == nil && u.Host != "" {
+		host, path = u.Hostname(), u.Path
+	} else if at := strings.Index(repository, "@"); at >= 0 {
+		// scp-like syntax
+		host, path, _ = strings.Cut(repository[at+1:], ":")
+	}
+	return host, strings.TrimSuffix(strings.Trim(path, "/"), ".git")
+}
+
+// repositoryKey returns the host/path identifying a repository URL or a
+// host/path itself, or "" if it is invalid.
+func repositoryKey(repository string) string {
+	host, path := parseRepository(repository)
+	if host == "" {
+		// Not a URL, e.g. github.com/example/payments
+		host, path, _ = strings.Cut(strings.TrimSuffix(strings.Trim(repository, "/"), ".git"), "/")
+	}
+	if host == "" || path == "" {
+		return ""
+	}
+	return strings.ToLower(host + "/" + path)
+}
+
+func describe(d *decision.Decision) string {
+	resource := d.Resource
+	if d.Name != "" {
+		resource += "/" + d.Name
+	}
+	if d.Namespace != "" {
+		resource = d.Namespace + "/" + resource
+	}
+	description := fmt.Sprintf("Denied %s of %s", strings.ToLower(d.Operation), resource)
+	if d.Message != "" {
+		description += ": " + d.Message
+	}
+	return description
+}
+
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-1]) + "…"
+}
+
+func (s *Sink) post(ctx context.Context, url string, headers map[string]string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s returned %s", req.URL.Host, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package gitfeedback
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+
+	"github.com/kubescape/kubeenforcer/pkg/decision"
+)
+
+const (
+	annotatedCommit = "3f786850e387550fdab836ed7e6dc881de23001b"
+	syncedCommit    = "89e6c98d92887913cadf06b2adb97f26cde4849b"
+)
+
+func TestResolve(t *testing.T) {
+	app := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "argoproj.io/v1alpha1",
+		"kind":       "Application",
+		"metadata":   map[string]interface{}{"namespace": "argocd", "name": "payments"},
+		"spec": map[string]interface{}{
+			"source": map[string]interface{}{"repoURL": "https://github.com/example/payments.git"},
+		},
+		"status": map[string]interface{}{
+			"sync": map[string]interface{}{"revision": syncedCommit},
+			"resources": []interface{}{
+				map[string]interface{}{"group": "apps", "kind": "Deployment", "namespace": "payments", "name": "api"},
+			},
+		},
+	}}
+	denial := func(name string, source decision.Source) *decision.Decision {
+		return &decision.Decision{Group: "apps", Resource: "deployments", Namespace: "payments", Name: name, Source: &source}
+	}
+
+	tests := []struct {
+		name       string
+		decision   *decision.Decision
+		repository string
+		commit     string
+	}{
+		{
+			name:       "annotations of an allowed repository",
+			decision:   denial("api", decision.Source{Repository: "git@github.com:Example/payments.git", Commit: annotatedCommit}),
+			repository: "git@github.com:Example/payments.git",
+			commit:     annotatedCommit,
+		},
+		{
+			name:     "annotations of another repository are ignored",
+			decision: denial("api", decision.Source{Repository: "https://github.com/example/other", Commit: annotatedCommit}),
+		},
+		{
+			name:       "application managing the object",
+			decision:   denial("api", decision.Source{ArgoCDApplication: "payments"}),
+			repository: "https://github.com/example/payments.git",
+			commit:     syncedCommit,
+		},
+		{
+			name:       "application wins over annotations of another repository",
+			decision:   denial("api", decision.Source{Repository: "https://github.com/example/other", Commit: annotatedCommit, ArgoCDApplication: "payments"}),
+			repository: "https://github.com/example/payments.git",
+			commit:     syncedCommit,
+		},
+		{
+			name:     "application not managing the object is ignored",
+			decision: denial("worker", decision.Source{ArgoCDApplication: "payments"}),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := New(Options{ArgoCDNamespace: "argocd", Repositories: []string{"github.com/example/payments"}}, dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), app), http.DefaultClient)
+			if err != nil {
+				t.Fatal(err)
+			}
+			repository, commit, err := s.resolve(context.Background(), tt.decision)
+			if err != nil {
+				t.Fatalf("resolve() error = %v", err)
+			}
+			if repository != tt.repository || commit != tt.commit {
+				t.Errorf("resolve() = %q, %q, want %q, %q", repository, commit, tt.repository, tt.commit)
+			}
+		})
+	}
+}
+
+func TestRepositoryKey(t *testing.T) {
+	tests := []struct {
+		repository string
+		want       string
+	}{
+		{"https://github.com/example/payments.git", "github.com/example/payments"},
+		{"git@github.com:example/payments.git", "github.com/example/payments"},
+		{"github.com/Example/payments/", "github.com/example/payments"},
+		{"https://gitlab.example.com/group/subgroup/project", "gitlab.example.com/group/subgroup/project"},
+		{"github.com", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := repositoryKey(tt.repository); got != tt.want {
+			t.Errorf("repositoryKey(%q) = %q, want %q", tt.repository, got, tt.want)
+		}
+	}
+}
+
+func TestRecordSkipsDryRun(t *testing.T) {
+	s, err := New(Options{}, nil, http.DefaultClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Record(&decision.Decision{DryRun: true, Source: &decision.Source{ArgoCDApplication: "payments"}})
+	if len(s.buffer) != 0 {
+		t.Errorf("Record() buffered a dry-run denial")
+	}
+	s.Record(&decision.Decision{Source: &decision.Source{ArgoCDApplication: "payments"}})
+	if len(s.buffer) != 1 {
+		t.Errorf("Record() did not buffer a denial")
+	}
+}
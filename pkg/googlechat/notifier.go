@@ -0,0 +1,187 @@
+package googlechat
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/kubescape/kubeenforcer/pkg/decision"
+)
+
+var logger klog.Logger = klog.LoggerWithName(klog.Background(), "googlechat")
+
+const (
+	bufferSize  = 1000
+	sendTimeout = 10 * time.Second
+
+	// minInterval paces the messages to a space, which Google Chat limits
+	// to one per second.
+	minInterval = time.Second
+)
+
+// Notifier posts denials as cards to Google Chat spaces through their
+// incoming webhooks, routed by namespace. The denials of a policy in a
+// namespace are threaded together. It is a decision.Sink; denials are
+// buffered and dropped if Google Chat cannot keep up so admission is never
+// slowed down.
+type Notifier struct {
+	config *Config
+	client *http.Client
+
+	buffer  chan *decision.Decision
+	dropped atomic.Int64
+	// sent is when a message was last sent to each webhook
+	sent map[string]time.Time
+}
+
+// New creates a Notifier routing denials as configured by config.
+func New(config *Config) *Notifier {
+	return &Notifier{
+		config: config,
+		client: &http.Client{Timeout: sendTimeout},
+		buffer: make(chan *decision.Decision, bufferSize),
+		sent:   map[string]time.Time{},
+	}
+}
+
+func (n *Notifier) Record(d *decision.Decision) {
+	if d.Allowed || d.Throttled || n.config.webhook(d.Namespace) == "" {
+		return
+	}
+	select {
+	case n.buffer <- d:
+	default:
+		n.dropped.Add(1)
+	}
+}
+
+// Run posts buffered denials until ctx is cancelled.
+func (n *Notifier) Run(ctx context.Context) error {
+	logger.Info("posting denials to Google Chat", "routes", len(n.config.Routes), "default", n.config.Default != "")
+	defer logger.Info("stopped posting to Google Chat")
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case d := <-n.buffer:
+			if dropped := n.dropped.Swap(0); dropped > 0 {
+				logger.Info("dropped denials because Google Chat could not keep up", "count", dropped)
+			}
+			if err := n.post(ctx, d); err != nil && ctx.Err() == nil {
+				// Not the webhook URL, which holds credentials
+				logger.Error(err, "failed to post denial", "decision", d.ID, "namespace", d.Namespace)
+			}
+		}
+	}
+}
+
+func (n *Notifier) post(ctx context.Context, d *decision.Decision) error {
+	webhook := n.config.webhook(d.Namespace)
+	if wait := minInterval - time.Since(n.sent[webhook]); wait > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+	n.sent[webhook] = time.Now()
+
+	u, err := url.Parse(webhook)
+	if err != nil {
+		return err
+	}
+	query := u.Query()
+	query.Set("threadKey", "kubeenforcer/"+d.Policy+"/"+d.Namespace)
+	query.Set("messageReplyOption", "REPLY_MESSAGE_FALLBACK_TO_NEW_THREAD")
+	u.RawQuery = query.Encode()
+
+	body, err := json.Marshal(message(d))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		// The error of the client quotes the URL
+		if urlErr, ok := err.(*url.Error); ok {
+			return urlErr.Err
+		}
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("google chat returned %s", resp.Status)
+	}
+	return nil
+}
+
+// message returns the Google Chat message of the denial d, the operation and
+// object as text and a card with the requester, policy and denial message.
+func message(d *decision.Decision) map[string]interface{} {
+	resource := d.Resource
+	if d.Group != "" {
+		resource += "." + d.Group
+	}
+	if d.Name != "" {
+		resource += "/" + d.Name
+	}
+	if d.Namespace != "" {
+		resource = d.Namespace + "/" + resource
+	}
+
+	field := func(label, text string) map[string]interface{} {
+		return map[string]interface{}{"decoratedText": map[string]interface{}{
+			"topLabel": label,
+			"text":     html.EscapeString(text),
+		}}
+	}
+	widgets := []interface{}{field("Requested by", d.User)}
+	if d.Policy != "" {
+		widgets = append(widgets, field("Policy", d.Policy))
+	}
+	if d.Cluster != "" {
+		widgets = append(widgets, field("Cluster", d.Cluster))
+	}
+	if d.Source != nil && d.Source.Application() != "" {
+		widgets = append(widgets, field("GitOps application", d.Source.Application()))
+	}
+	widgets = append(widgets, field("Decision", d.ID))
+	sections := []interface{}{map[string]interface{}{"widgets": widgets}}
+	if d.Message != "" {
+		sections = append(sections, map[string]interface{}{
+			"header": "Message",
+			"widgets": []interface{}{map[string]interface{}{"textParagraph": map[string]interface{}{
+				"text": html.EscapeString(d.Message),
+			}}},
+		})
+	}
+
+	return map[string]interface{}{
+		"text": fmt.Sprintf("Denied %s of %s", strings.ToLower(d.Operation), resource),
+		"cardsV2": []interface{}{map[string]interface{}{
+			"cardId": "denial-" + d.ID,
+			"card": map[string]interface{}{
+				"header": map[string]interface{}{
+					"title":    "Admission denied",
+					"subtitle": "kubeenforcer",
+				},
+				"sections": sections,
+			},
+		}},
+	}
+}
@@ -0,0 +1,98 @@
+package googlechat
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Config is the Google Chat routing configuration file. It holds webhook
+// URLs, which embed their credentials, so it should be kept in a Secret.
+type Config struct {
+	// Routes send the denials in the namespaces they select to their
+	// webhook. The first matching route wins.
+	Routes []Route `json:"routes"`
+
+	// Default is the webhook of the denials no route selects, which are not
+	// sent if empty. Denials of cluster-scoped objects always go there.
+	Default string `json:"default"`
+}
+
+// Route sends the denials in some namespaces to the webhook of a space.
+type Route struct {
+	// Namespaces are the names of the selected namespaces, or glob patterns
+	// such as team-a-*.
+	Namespaces []string `json:"namespaces"`
+
+	// URL is the incoming webhook URL of the space.
+	URL string `json:"url"`
+}
+
+func (r *Route) matches(namespace string) bool {
+	for _, pattern := range r.Namespaces {
+		if ok, _ := path.Match(pattern, namespace); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadConfig reads and validates the routing configuration file at file.
+func LoadConfig(file string) (*Config, error) {
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var config Config
+	if err := yaml.UnmarshalStrict(raw, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", file, err)
+	}
+	for i, route := range config.Routes {
+		if len(route.Namespaces) == 0 {
+			return nil, fmt.Errorf("route %d selects no namespaces", i)
+		}
+		for _, pattern := range route.Namespaces {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("route %d: invalid namespace pattern %s", i, pattern)
+			}
+		}
+		if err := validateURL(route.URL); err != nil {
+			return nil, fmt.Errorf("route %d: %w", i, err)
+		}
+	}
+	if config.Default != "" {
+		if err := validateURL(config.Default); err != nil {
+			return nil, fmt.Errorf("default: %w", err)
+		}
+	}
+	if len(config.Routes) == 0 && config.Default == "" {
+		return nil, fmt.Errorf("%s has neither routes nor a default webhook", file)
+	}
+	return &config, nil
+}
+
+func validateURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		// The URL holds credentials, so it is not logged
+		return errors.New("invalid webhook URL, expected https://chat.googleapis.com/v1/spaces/...")
+	}
+	return nil
+}
+
+// webhook returns the webhook URL of the denials in namespace, or "" if
+// they are not sent.
+func (c *Config) webhook(namespace string) string {
+	if namespace != "" {
+		for i := range c.Routes {
+			if c.Routes[i].matches(namespace) {
+				return c.Routes[i].URL
+			}
+		}
+	}
+	return c.Default
+}
@@ -0,0 +1,142 @@
+package grafana
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/kubescape/kubeenforcer/pkg/decision"
+)
+
+var logger klog.Logger = klog.LoggerWithName(klog.Background(), "grafana")
+
+const (
+	bufferSize  = 1000
+	sendTimeout = 10 * time.Second
+)
+
+// annotation is the body of a request to the Grafana annotations API.
+type annotation struct {
+	Time int64    `json:"time"`
+	Tags []string `json:"tags"`
+	Text string   `json:"text"`
+}
+
+// Publisher publishes denials as Grafana annotations, tagged with the policy
+// and namespace, so enforcement shows up on dashboards with annotation
+// queries filtering by tag. It is a decision.Sink; denials are buffered and
+// dropped if Grafana cannot keep up so admission is never slowed down.
+type Publisher struct {
+	url    string
+	token  string
+	tags   []string
+	client *http.Client
+
+	buffer  chan *decision.Decision
+	dropped atomic.Int64
+}
+
+// New creates a Publisher posting to the Grafana instance at url,
+// authenticating with the service account token or API key token if set.
+// tags are added to the tags of every annotation.
+func New(url, token string, tags []string) *Publisher {
+	return &Publisher{
+		url:    strings.TrimSuffix(url, "/") + "/api/annotations",
+		token:  token,
+		tags:   tags,
+		client: &http.Client{Timeout: sendTimeout},
+		buffer: make(chan *decision.Decision, bufferSize),
+	}
+}
+
+func (p *Publisher) Record(d *decision.Decision) {
+	if d.Allowed || d.Throttled {
+		return
+	}
+	select {
+	case p.buffer <- d:
+	default:
+		p.dropped.Add(1)
+	}
+}
+
+// Run publishes buffered denials until ctx is cancelled.
+func (p *Publisher) Run(ctx context.Context) error {
+	logger.Info("publishing denials as Grafana annotations", "url", p.url)
+	defer logger.Info("stopped publishing Grafana annotations")
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case d := <-p.buffer:
+			if dropped := p.dropped.Swap(0); dropped > 0 {
+				logger.Info("dropped denials because Grafana could not keep up", "count", dropped)
+			}
+			if err := p.publish(ctx, d); err != nil && ctx.Err() == nil {
+				logger.Error(err, "failed to publish annotation", "decision", d.ID)
+			}
+		}
+	}
+}
+
+func (p *Publisher) publish(ctx context.Context, d *decision.Decision) error {
+	body, err := json.Marshal(p.annotation(d))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.token)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("grafana returned %s", resp.Status)
+	}
+	return nil
+}
+
+func (p *Publisher) annotation(d *decision.Decision) *annotation {
+	tags := append([]string{"kubeenforcer", "denied"}, p.tags...)
+	if d.Policy != "" {
+		tags = append(tags, "policy:"+d.Policy)
+	}
+	if d.Namespace != "" {
+		tags = append(tags, "namespace:"+d.Namespace)
+	}
+	if d.Cluster != "" {
+		tags = append(tags, "cluster:"+d.Cluster)
+	}
+
+	resource := d.Resource
+	if d.Group != "" {
+		resource += "." + d.Group
+	}
+	if d.Name != "" {
+		resource += "/" + d.Name
+	}
+	if d.Namespace != "" {
+		resource = d.Namespace + "/" + resource
+	}
+	text := fmt.Sprintf("Denied %s of %s by %s", strings.ToLower(d.Operation), resource, d.User)
+	if d.Message != "" {
+		text += ": " + d.Message
+	}
+	return &annotation{Time: d.Time.UnixMilli(), Tags: tags, Text: text}
+}
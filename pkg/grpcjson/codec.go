@@ -0,0 +1,22 @@
+package grpcjson
+
+import (
+	"encoding/json"
+
+	"google.golang.org/grpc/encoding"
+)
+
+// Name is the gRPC content-subtype of the JSON codec. kubeenforcer's gRPC
+// services exchange JSON rather than protobuf so no generated code is needed;
+// clients select the codec with grpc.CallContentSubtype(Name).
+const Name = "json"
+
+type codec struct{}
+
+func (codec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (codec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+func (codec) Name() string                               { return Name }
+
+func init() {
+	encoding.RegisterCodec(codec{})
+}
@@ -0,0 +1,198 @@
+package helmrelease
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/metadata/metadatainformer"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	"github.com/kubescape/kubeenforcer/pkg/decision"
+)
+
+var logger klog.Logger = klog.LoggerWithName(klog.Background(), "helmrelease")
+
+const (
+	// ownerSelector selects the Secrets of the Helm storage driver, named
+	// sh.helm.release.v1.<release>.v<revision> and labelled with the name,
+	// status and version of their release.
+	ownerSelector = "owner=helm"
+	releaseIndex  = "release"
+
+	bufferSize = 100
+	getTimeout = 10 * time.Second
+	retryAfter = time.Minute
+)
+
+// Resolver looks up the Helm releases installed in the cluster from the
+// Secrets Helm stores them in. Only their metadata is watched; the chart of
+// a release revision is read from its Secret in the background the first
+// time the release is looked up, so lookups never wait on the API server.
+type Resolver struct {
+	client   kubernetes.Interface
+	informer cache.SharedIndexInformer
+
+	fetch chan *corev1.Secret
+	lock  sync.Mutex
+	// charts are the charts of release revisions by Secret namespace/name,
+	// "" while being read
+	charts map[string]string
+}
+
+// New creates a Resolver watching the metadata of Helm release Secrets
+// through metadataClient and reading them through client.
+func New(client kubernetes.Interface, metadataClient metadata.Interface) *Resolver {
+	informer := metadatainformer.NewFilteredMetadataInformer(metadataClient, corev1.SchemeGroupVersion.WithResource("secrets"), metav1.NamespaceAll, 10*time.Minute,
+		cache.Indexers{releaseIndex: indexRelease},
+		func(options *metav1.ListOptions) {
+			options.LabelSelector = ownerSelector
+		},
+	).Informer()
+	r := &Resolver{
+		client:   client,
+		informer: informer,
+		fetch:    make(chan *corev1.Secret, bufferSize),
+		charts:   map[string]string{},
+	}
+	// Helm prunes old revisions
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		DeleteFunc: func(obj interface{}) {
+			if key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj); err == nil {
+				r.lock.Lock()
+				delete(r.charts, key)
+				r.lock.Unlock()
+			}
+		},
+	})
+	return r
+}
+
+func indexRelease(obj interface{}) ([]string, error) {
+	secret, ok := obj.(*metav1.PartialObjectMetadata)
+	if !ok || secret.Labels["name"] == "" {
+		return nil, nil
+	}
+	return []string{secret.Namespace + "/" + secret.Labels["name"]}, nil
+}
+
+// Run watches release Secrets and reads the charts of looked up releases
+// until ctx is cancelled.
+func (r *Resolver) Run(ctx context.Context) error {
+	logger.Info("watching Helm releases")
+	defer logger.Info("stopped watching Helm releases")
+
+	go r.informer.Run(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), r.informer.HasSynced) {
+		return nil
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case secret := <-r.fetch:
+			chart, err := r.readChart(ctx, secret)
+			if err != nil {
+				if ctx.Err() == nil {
+					logger.Error(err, "failed to read the chart of a Helm release", "namespace", secret.Namespace, "secret", secret.Name)
+				}
+				// Read again on a lookup a minute later
+				time.AfterFunc(retryAfter, func() {
+					r.lock.Lock()
+					delete(r.charts, secret.Namespace+"/"+secret.Name)
+					r.lock.Unlock()
+				})
+				continue
+			}
+			r.lock.Lock()
+			r.charts[secret.Namespace+"/"+secret.Name] = chart
+			r.lock.Unlock()
+		}
+	}
+}
+
+// Release returns the latest revision of the release name in namespace,
+// deployed or being deployed, or nil if there is none. Its chart is empty
+// until it was read.
+func (r *Resolver) Release(namespace, name string) *decision.HelmRelease {
+	objs, err := r.informer.GetIndexer().ByIndex(releaseIndex, namespace+"/"+name)
+	if err != nil {
+		return nil
+	}
+	var latest *metav1.PartialObjectMetadata
+	latestRevision := 0
+	for _, obj := range objs {
+		secret := obj.(*metav1.PartialObjectMetadata)
+		if revision, err := strconv.Atoi(secret.Labels["version"]); err == nil && revision > latestRevision {
+			latest, latestRevision = secret, revision
+		}
+	}
+	if latest == nil {
+		return nil
+	}
+
+	release := &decision.HelmRelease{Name: name, Namespace: namespace, Revision: latestRevision}
+	key := latest.Namespace + "/" + latest.Name
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	chart, ok := r.charts[key]
+	if !ok {
+		select {
+		case r.fetch <- &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: latest.Namespace, Name: latest.Name}}:
+			r.charts[key] = ""
+		default:
+		}
+	}
+	release.Chart = chart
+	return release
+}
+
+// readChart returns the chart of the release revision stored in secret, as
+// <name>-<version> like the helm.sh/chart label.
+func (r *Resolver) readChart(ctx context.Context, secret *corev1.Secret) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, getTimeout)
+	defer cancel()
+	secret, err := r.client.CoreV1().Secrets(secret.Namespace).Get(ctx, secret.Name, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	// The release is gzipped JSON, base64 encoded once more by Helm
+	data, err := base64.StdEncoding.DecodeString(string(secret.Data["release"]))
+	if err != nil {
+		return "", fmt.Errorf("invalid release: %w", err)
+	}
+	if bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return "", fmt.Errorf("invalid release: %w", err)
+		}
+		if data, err = io.ReadAll(reader); err != nil {
+			return "", fmt.Errorf("invalid release: %w", err)
+		}
+	}
+	var release struct {
+		Chart struct {
+			Metadata struct {
+				Name    string `json:"name"`
+				Version string `json:"version"`
+			} `json:"metadata"`
+		} `json:"chart"`
+	}
+	if err := json.Unmarshal(data, &release); err != nil {
+		return "", fmt.Errorf("invalid release: %w", err)
+	}
+	return release.Chart.Metadata.Name + "-" + release.Chart.Metadata.Version, nil
+}
@@ -0,0 +1,94 @@
+package impact
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// maxRows caps the rows of each table of the Markdown report. The JSON
+// report is complete.
+const maxRows = 50
+
+// WriteMarkdown writes the report as Markdown, e.g. to attach to a change
+// request.
+func (r *Report) WriteMarkdown(w io.Writer) error {
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "# Policy impact report\n\nGenerated %s.", r.Generated.UTC().Format(time.RFC3339))
+	if r.Shadow != nil {
+		fmt.Fprintf(b, " Shadow evaluation of %d requests from %s to %s.", r.Shadow.Requests, r.Shadow.Since.UTC().Format(time.RFC3339), r.Shadow.Until.UTC().Format(time.RFC3339))
+	} else {
+		b.WriteString(" No shadow evaluation results.")
+	}
+	b.WriteString("\n\n## Policies\n\n")
+	if r.Shadow != nil {
+		b.WriteString("| Policy | Existing objects | Would be denied | Object deny rate | Requests | Would have been denied | Request deny rate |\n|---|---:|---:|---:|---:|---:|---:|\n")
+		for _, p := range r.Policies {
+			fmt.Fprintf(b, "| %s | %d | %d | %s | %d | %d | %s |\n", cell(p.Name), p.ExistingObjects, p.DeniedObjects, percent(p.ObjectDenyRate), p.Requests, p.DeniedRequests, percent(p.RequestDenyRate))
+		}
+	} else {
+		b.WriteString("| Policy | Existing objects | Would be denied | Object deny rate |\n|---|---:|---:|---:|\n")
+		for _, p := range r.Policies {
+			fmt.Fprintf(b, "| %s | %d | %d | %s |\n", cell(p.Name), p.ExistingObjects, p.DeniedObjects, percent(p.ObjectDenyRate))
+		}
+	}
+
+	writeCounts(b, "Affected namespaces", []string{"Namespace", "Objects", "Requests"}, r.Namespaces, func(c Count) []string {
+		return []string{c.Name, fmt.Sprint(c.Objects), fmt.Sprint(c.Requests)}
+	})
+	writeCounts(b, "Affected workloads", []string{"Workload", "Namespace", "Owner", "Objects"}, r.Workloads, func(c Count) []string {
+		return []string{c.Name, c.Namespace, c.Owner, fmt.Sprint(c.Objects)}
+	})
+	writeCounts(b, "Affected owners", []string{"Owner", "Objects"}, r.Owners, func(c Count) []string {
+		return []string{c.Name, fmt.Sprint(c.Objects)}
+	})
+	writeCounts(b, "Affected users", []string{"User", "Requests"}, r.Users, func(c Count) []string {
+		return []string{c.Name, fmt.Sprint(c.Requests)}
+	})
+
+	if len(r.Violations) > 0 {
+		b.WriteString("\n## Violations\n\n| Policy | Actions | Resource | Object | Workload | Message |\n|---|---|---|---|---|---|\n")
+		for i, v := range r.Violations {
+			if i == maxRows {
+				fmt.Fprintf(b, "\n%d more violations are listed in the JSON report.\n", len(r.Violations)-maxRows)
+				break
+			}
+			object := v.Name
+			if v.Namespace != "" {
+				object = v.Namespace + "/" + v.Name
+			}
+			fmt.Fprintf(b, "| %s | %s | %s | %s | %s | %s |\n", cell(v.Policy), cell(strings.Join(v.Actions, ", ")), cell(v.Resource), cell(object), cell(v.Workload), cell(v.Message))
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeCounts(b *strings.Builder, title string, columns []string, counts []Count, row func(Count) []string) {
+	if len(counts) == 0 {
+		return
+	}
+	fmt.Fprintf(b, "\n## %s\n\n| %s |\n|%s\n", title, strings.Join(columns, " | "), strings.Repeat("---|", len(columns)))
+	for i, c := range counts {
+		if i == maxRows {
+			fmt.Fprintf(b, "\n%d more are listed in the JSON report.\n", len(counts)-maxRows)
+			break
+		}
+		cells := row(c)
+		for j := range cells {
+			cells[j] = cell(cells[j])
+		}
+		fmt.Fprintf(b, "| %s |\n", strings.Join(cells, " | "))
+	}
+}
+
+// cell escapes s for a table cell.
+func cell(s string) string {
+	return strings.NewReplacer("|", `\|`, "\n", " ").Replace(s)
+}
+
+func percent(rate float64) string {
+	return fmt.Sprintf("%.1f%%", rate*100)
+}
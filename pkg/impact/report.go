@@ -0,0 +1,247 @@
+package impact
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+
+	"k8s.io/cel-admission-webhook/pkg/apis/admissionregistration.x-k8s.io/v1alpha1"
+
+	"github.com/kubescape/kubeenforcer/pkg/decision"
+	"github.com/kubescape/kubeenforcer/pkg/simulate"
+)
+
+// Shadow holds the decisions recorded while policies were bound with the
+// Audit action only, which evaluates them on live requests without
+// denying them.
+type Shadow struct {
+	Since time.Time
+	Until time.Time
+	// Decisions are all the decisions of the window, which the requests
+	// matched by each policy are counted from.
+	Decisions []*decision.Decision
+}
+
+// Report is the impact of enabling policies, for change review: the
+// existing objects they would deny, from a live simulation, and the
+// requests they would have denied, from their shadow evaluation.
+type Report struct {
+	Generated time.Time      `json:"generated"`
+	Shadow    *ShadowWindow  `json:"shadow,omitempty"`
+	Policies  []PolicyImpact `json:"policies"`
+	// Namespaces, Workloads, Owners and Users are the affected namespaces,
+	// workloads, owners and requesting users, most affected first.
+	Namespaces []Count              `json:"namespaces"`
+	Workloads  []Count              `json:"workloads"`
+	Owners     []Count              `json:"owners,omitempty"`
+	Users      []Count              `json:"users,omitempty"`
+	Violations []simulate.Violation `json:"violations"`
+}
+
+// ShadowWindow is the period of the shadow evaluation.
+type ShadowWindow struct {
+	Since    time.Time `json:"since"`
+	Until    time.Time `json:"until"`
+	Requests int       `json:"requests"`
+}
+
+// PolicyImpact is the impact of enabling a single policy.
+type PolicyImpact struct {
+	Name string `json:"name"`
+	// ExistingObjects is the number of existing objects the policy matches,
+	// and DeniedObjects those it would deny.
+	ExistingObjects int     `json:"existingObjects"`
+	DeniedObjects   int     `json:"deniedObjects"`
+	ObjectDenyRate  float64 `json:"objectDenyRate"`
+	// Requests is the number of requests of the shadow window the policy
+	// matches, and DeniedRequests those it would have denied.
+	Requests        int     `json:"requests,omitempty"`
+	DeniedRequests  int     `json:"deniedRequests,omitempty"`
+	RequestDenyRate float64 `json:"requestDenyRate,omitempty"`
+}
+
+// Count is the number of existing objects that would be denied and of
+// requests that would have been denied, for a namespace, workload, owner or
+// user.
+type Count struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+	Owner     string `json:"owner,omitempty"`
+	Objects   int    `json:"objects,omitempty"`
+	Requests  int    `json:"requests,omitempty"`
+}
+
+// Build combines the simulation of policies against existing objects with
+// their shadow evaluation, which may be nil.
+func Build(policies []*v1alpha1.ValidatingAdmissionPolicy, simulation *simulate.Report, shadow *Shadow, now time.Time) *Report {
+	r := &Report{Generated: now, Violations: simulation.Violations}
+	namespaces := counter{}
+	workloads := counter{}
+	owners := counter{}
+	users := counter{}
+
+	// Objects denied by several policies are counted once
+	denied := map[string]bool{}
+	for _, v := range simulation.Violations {
+		key := v.Resource + "/" + v.Namespace + "/" + v.Name
+		if !v.Denied() || denied[key] {
+			continue
+		}
+		denied[key] = true
+		if v.Namespace != "" {
+			namespaces.get(v.Namespace, "", "").Objects++
+		}
+		workload := workloads.get(v.Workload, v.Namespace, v.Owner)
+		workload.Objects++
+		if v.Owner != "" {
+			owners.get(v.Owner, "", "").Objects++
+		}
+	}
+
+	for _, policy := range policies {
+		impact := PolicyImpact{Name: policy.Name, ExistingObjects: simulation.PolicyObjects[policy.Name]}
+		objects := map[string]bool{}
+		for _, v := range simulation.Violations {
+			if v.Policy == policy.Name && v.Denied() {
+				objects[v.Resource+"/"+v.Namespace+"/"+v.Name] = true
+			}
+		}
+		impact.DeniedObjects = len(objects)
+		impact.ObjectDenyRate = rate(impact.DeniedObjects, impact.ExistingObjects)
+		r.Policies = append(r.Policies, impact)
+	}
+
+	if shadow != nil {
+		r.Shadow = &ShadowWindow{Since: shadow.Since, Until: shadow.Until, Requests: len(shadow.Decisions)}
+		for i, policy := range policies {
+			impact := &r.Policies[i]
+			for _, d := range shadow.Decisions {
+				if policy.Spec.MatchConstraints == nil || !rulesMatch(policy.Spec.MatchConstraints.ResourceRules, d) {
+					continue
+				}
+				impact.Requests++
+				if d.Policy != policy.Name || !hasAction(d, string(v1alpha1.Audit)) {
+					continue
+				}
+				impact.DeniedRequests++
+				if d.Namespace != "" {
+					namespaces.get(d.Namespace, "", "").Requests++
+				}
+				users.get(d.User, "", "").Requests++
+			}
+			impact.RequestDenyRate = rate(impact.DeniedRequests, impact.Requests)
+		}
+	}
+
+	r.Namespaces = namespaces.sorted()
+	r.Workloads = workloads.sorted()
+	r.Owners = owners.sorted()
+	r.Users = users.sorted()
+	return r
+}
+
+func rate(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) / float64(total)
+}
+
+// rulesMatch returns whether the resource and operation of d are matched by
+// rules. Selectors and exclusions are not known for past requests, so the
+// requests matched by a policy may be overestimated.
+func rulesMatch(rules []v1alpha1.NamedRuleWithOperations, d *decision.Decision) bool {
+	resource := d.Resource
+	if d.SubResource != "" {
+		resource += "/" + d.SubResource
+	}
+	for _, rule := range rules {
+		if len(rule.ResourceNames) > 0 && !contains(rule.ResourceNames, d.Name) {
+			continue
+		}
+		if matchesAny(rule.APIGroups, d.Group) && matchesAny(rule.APIVersions, d.Version) && matchesResource(rule.Resources, resource) && matchesOperation(rule.Operations, d.Operation) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesAny(values []string, value string) bool {
+	return contains(values, value) || contains(values, "*")
+}
+
+func matchesResource(patterns []string, resource string) bool {
+	main, sub, hasSub := strings.Cut(resource, "/")
+	for _, pattern := range patterns {
+		switch {
+		case pattern == resource, pattern == "*/*":
+			return true
+		case pattern == "*" && !hasSub:
+			return true
+		case hasSub && (pattern == main+"/*" || pattern == "*/"+sub):
+			return true
+		}
+	}
+	return false
+}
+
+func matchesOperation(operations []admissionregistrationv1.OperationType, operation string) bool {
+	for _, op := range operations {
+		if op == admissionregistrationv1.OperationAll || string(op) == operation {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func hasAction(d *decision.Decision, action string) bool {
+	for _, a := range d.Actions {
+		if strings.EqualFold(a, action) {
+			return true
+		}
+	}
+	return false
+}
+
+// counter counts objects and requests by namespace and name.
+type counter map[string]*Count
+
+func (c counter) get(name, namespace, owner string) *Count {
+	key := namespace + "/" + name
+	if count, ok := c[key]; ok {
+		return count
+	}
+	count := &Count{Name: name, Namespace: namespace, Owner: owner}
+	c[key] = count
+	return count
+}
+
+// sorted returns the counts, most objects and requests first.
+func (c counter) sorted() []Count {
+	counts := make([]Count, 0, len(c))
+	for _, count := range c {
+		counts = append(counts, *count)
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		a, b := counts[i], counts[j]
+		if a.Objects+a.Requests != b.Objects+b.Requests {
+			return a.Objects+a.Requests > b.Objects+b.Requests
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+	return counts
+}
@@ -0,0 +1,109 @@
+package informerhealth
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+var logger klog.Logger = klog.LoggerWithName(klog.Background(), "informerhealth")
+
+// retryWindow is the longest time between two errors of the same failure.
+// Reflectors retry failed list and watch calls at least every 30 seconds.
+const retryWindow = time.Minute
+
+type informer struct {
+	informer cache.SharedIndexInformer
+
+	// failingSince is when the current streak of list and watch errors
+	// started, and lastError the last of them.
+	failingSince time.Time
+	lastError    time.Time
+	err          error
+}
+
+// Tracker tells whether informers are synced and still watching, so a
+// process whose caches went stale stops serving instead of evaluating
+// requests against outdated objects.
+type Tracker struct {
+	staleAfter time.Duration
+
+	lock      sync.Mutex
+	informers map[string]*informer
+}
+
+// New creates a Tracker reporting informers as stale once they failed to
+// list or watch for staleAfter.
+func New(staleAfter time.Duration) *Tracker {
+	return &Tracker{staleAfter: staleAfter, informers: map[string]*informer{}}
+}
+
+// Track tracks the informer named name. It must be called before the
+// informer is started, since it installs its watch error handler.
+func (t *Tracker) Track(name string, i cache.SharedIndexInformer) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if _, ok := t.informers[name]; ok {
+		return nil
+	}
+	tracked := &informer{informer: i}
+	err := i.SetWatchErrorHandler(func(r *cache.Reflector, err error) {
+		cache.DefaultWatchErrorHandler(r, err)
+		// Expired resource versions and closed watches are recovered from
+		// by relisting
+		if apierrors.IsResourceExpired(err) || apierrors.IsGone(err) || errors.Is(err, io.EOF) {
+			return
+		}
+		t.failed(tracked, err)
+	})
+	if err != nil {
+		return fmt.Errorf("informer %s: %w", name, err)
+	}
+	t.informers[name] = tracked
+	return nil
+}
+
+func (t *Tracker) failed(i *informer, err error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	now := time.Now()
+	if now.Sub(i.lastError) > retryWindow {
+		i.failingSince = now
+	}
+	i.lastError = now
+	i.err = err
+}
+
+// Check returns an error naming the informers that are not synced, were
+// stopped, or failed to list or watch for longer than the stale timeout.
+func (t *Tracker) Check() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	now := time.Now()
+	var problems []string
+	for name, i := range t.informers {
+		switch {
+		case i.informer.IsStopped():
+			problems = append(problems, name+" stopped")
+		case !i.informer.HasSynced():
+			problems = append(problems, name+" not synced")
+		case now.Sub(i.lastError) <= retryWindow && now.Sub(i.failingSince) >= t.staleAfter:
+			problems = append(problems, fmt.Sprintf("%s stale, failing to watch for %s: %v", name, now.Sub(i.failingSince).Round(time.Second), i.err))
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	sort.Strings(problems)
+	logger.V(2).Info("informers unhealthy", "problems", problems)
+	return errors.New(strings.Join(problems, ", "))
+}
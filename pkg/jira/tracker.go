@@ -0,0 +1,337 @@
+package jira
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/klog/v2"
+
+	"github.com/kubescape/kubeenforcer/pkg/alertmanager"
+	"github.com/kubescape/kubeenforcer/pkg/metrics"
+)
+
+var logger klog.Logger = klog.LoggerWithName(klog.Background(), "jira")
+
+var issueUpdates = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Name:      "jira_issue_updates_total",
+	Help:      "Jira issues opened or commented on for persistent violations, by result.",
+}, []string{"result"})
+
+func init() {
+	metrics.Registry.MustRegister(issueUpdates)
+}
+
+const (
+	bufferSize  = 1000
+	sendTimeout = 10 * time.Second
+
+	// forgetAfter is how long a violation is tracked after it was last seen.
+	forgetAfter = 24 * time.Hour
+
+	// label is the label of every issue; the fingerprint label identifies
+	// the violation of an issue.
+	label            = "kubeenforcer"
+	fingerprintLabel = "kubeenforcer-"
+)
+
+// Options configures the issues.
+type Options struct {
+	// Project is the key of the project issues are opened in.
+	Project string
+	// IssueType is the name of the type of the issues, e.g. Task.
+	IssueType string
+	// Threshold is how long a violation must persist for an issue to be
+	// opened, and how often the issue is commented on while it does.
+	Threshold time.Duration
+}
+
+// Auth authenticates to Jira: Jira Cloud with the email address User and an
+// API token, Jira Data Center with a personal access token and no User.
+type Auth struct {
+	User  string
+	Token string
+}
+
+// occurrence is an alert of a violation.
+type occurrence struct {
+	alert       alertmanager.AlertInfo
+	fingerprint string
+}
+
+// violation is the state of a violation of a policy by a workload.
+type violation struct {
+	alert alertmanager.AlertInfo
+	first time.Time
+	last  time.Time
+	count int
+	// updated is when its issue was last opened or commented on
+	updated time.Time
+}
+
+// Tracker turns chronic policy violations into Jira issues. It counts the
+// violation alerts of every policy and workload, identified by their
+// fingerprint, and once a violation is still seen Threshold after it was
+// first seen, opens an issue for it, or comments on the unresolved issue of
+// the violation if there is one, and so again at most every Threshold while
+// the violation persists. It is an alertmanager.Forwarder;
+// with deduplication, it only counts the alerts sent.
+type Tracker struct {
+	url    string
+	auth   Auth
+	opts   Options
+	client *http.Client
+
+	buffer     chan *occurrence
+	dropped    atomic.Int64
+	violations map[string]*violation
+}
+
+// New creates a Tracker opening issues in the Jira instance at url with
+// client.
+func New(url string, auth Auth, opts Options, client *http.Client) *Tracker {
+	c := *client
+	c.Timeout = sendTimeout
+	return &Tracker{
+		url:        strings.TrimSuffix(url, "/"),
+		auth:       auth,
+		opts:       opts,
+		client:     &c,
+		buffer:     make(chan *occurrence, bufferSize),
+		violations: map[string]*violation{},
+	}
+}
+
+func (t *Tracker) Forward(alertInfo *alertmanager.AlertInfo, fingerprint string) {
+	// Only policy violations are tied to a workload
+	if alertInfo.Policy == "" || alertInfo.Workload == "" {
+		return
+	}
+	select {
+	case t.buffer <- &occurrence{alert: *alertInfo, fingerprint: fingerprint}:
+	default:
+		t.dropped.Add(1)
+	}
+}
+
+// Run tracks buffered violations until ctx is cancelled.
+func (t *Tracker) Run(ctx context.Context) error {
+	logger.Info("opening issues for persistent violations", "url", t.url, "project", t.opts.Project, "threshold", t.opts.Threshold)
+	defer logger.Info("stopped opening issues")
+
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case o := <-t.buffer:
+			if dropped := t.dropped.Swap(0); dropped > 0 {
+				logger.Info("dropped violations because Jira could not keep up", "count", dropped)
+			}
+			t.observe(ctx, o, time.Now())
+		case now := <-ticker.C:
+			for fingerprint, v := range t.violations {
+				if now.Sub(v.last) > forgetAfter {
+					delete(t.violations, fingerprint)
+				}
+			}
+		}
+	}
+}
+
+// observe records an occurrence of a violation, and opens or comments on
+// its issue if it is due.
+func (t *Tracker) observe(ctx context.Context, o *occurrence, now time.Time) {
+	alert, fingerprint := &o.alert, o.fingerprint
+	v, ok := t.violations[fingerprint]
+	if !ok || now.Sub(v.last) > forgetAfter {
+		v = &violation{first: now}
+		t.violations[fingerprint] = v
+	}
+	v.alert = *alert
+	v.last = now
+	v.count++
+
+	if now.Sub(v.first) < t.opts.Threshold || now.Sub(v.updated) < t.opts.Threshold {
+		return
+	}
+	logger := logger.WithValues("policy", alert.Policy, "namespace", alert.Namespace, "workload", alert.Workload)
+	if err := t.update(ctx, v, fingerprint); err != nil {
+		issueUpdates.WithLabelValues("failed").Inc()
+		if ctx.Err() == nil {
+			logger.Error(err, "failed to update the issue of a persistent violation")
+		}
+		return
+	}
+	v.updated = now
+}
+
+// update comments on the unresolved issue of v, or opens one if there is
+// none, e.g. because the previous one was resolved while the violation
+// persisted.
+func (t *Tracker) update(ctx context.Context, v *violation, fingerprint string) error {
+	issue, err := t.findIssue(ctx, fingerprint)
+	if err != nil {
+		return err
+	}
+	if issue != "" {
+		if err := t.comment(ctx, issue, v); err != nil {
+			return err
+		}
+		issueUpdates.WithLabelValues("commented").Inc()
+		logger.Info("commented on the issue of a persistent violation", "issue", issue, "policy", v.alert.Policy, "workload", v.alert.Workload)
+		return nil
+	}
+
+	issue, err = t.createIssue(ctx, v, fingerprint)
+	if err != nil {
+		return err
+	}
+	issueUpdates.WithLabelValues("opened").Inc()
+	logger.Info("opened an issue for a persistent violation", "issue", issue, "policy", v.alert.Policy, "workload", v.alert.Workload)
+	return nil
+}
+
+// findIssue returns the key of the unresolved issue labelled with
+// fingerprint, e.g. opened by another replica or before a restart, or "" if
+// there is none.
+func (t *Tracker) findIssue(ctx context.Context, fingerprint string) (string, error) {
+	jql := fmt.Sprintf(`project = "%s" AND labels = "%s" AND statusCategory != Done ORDER BY created DESC`, t.opts.Project, issueLabel(fingerprint))
+	query := url.Values{"jql": {jql}, "fields": {"key"}, "maxResults": {"1"}}
+	var result struct {
+		Issues []struct {
+			Key string `json:"key"`
+		} `json:"issues"`
+	}
+	if err := t.do(ctx, http.MethodGet, "/rest/api/2/search?"+query.Encode(), nil, &result); err != nil {
+		return "", err
+	}
+	if len(result.Issues) == 0 {
+		return "", nil
+	}
+	return result.Issues[0].Key, nil
+}
+
+func (t *Tracker) createIssue(ctx context.Context, v *violation, fingerprint string) (string, error) {
+	fields := map[string]interface{}{
+		"project":     map[string]string{"key": t.opts.Project},
+		"issuetype":   map[string]string{"name": t.opts.IssueType},
+		"summary":     summary(&v.alert),
+		"description": description(v),
+		"labels":      []string{label, issueLabel(fingerprint)},
+	}
+	var result struct {
+		Key string `json:"key"`
+	}
+	if err := t.do(ctx, http.MethodPost, "/rest/api/2/issue", map[string]interface{}{"fields": fields}, &result); err != nil {
+		return "", err
+	}
+	return result.Key, nil
+}
+
+func (t *Tracker) comment(ctx context.Context, issue string, v *violation) error {
+	body := fmt.Sprintf("The violation persists: seen %d times since %s, last at %s.", v.count, v.first.UTC().Format(time.RFC3339), v.last.UTC().Format(time.RFC3339))
+	if v.alert.DecisionID != "" {
+		body += " Last decision: " + v.alert.DecisionID + "."
+	}
+	return t.do(ctx, http.MethodPost, "/rest/api/2/issue/"+url.PathEscape(issue)+"/comment", map[string]string{"body": body}, nil)
+}
+
+// issueLabel returns the label identifying the issue of the violation with
+// fingerprint.
+func issueLabel(fingerprint string) string {
+	return fingerprintLabel + fingerprint[:16]
+}
+
+func summary(alert *alertmanager.AlertInfo) string {
+	workload := alert.Workload
+	if alert.Namespace != "" {
+		workload = alert.Namespace + "/" + workload
+	}
+	return fmt.Sprintf("%s persistently violates policy %s", workload, alert.Policy)
+}
+
+// description returns the issue description of v, in Jira wiki markup.
+func description(v *violation) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "kubeenforcer has seen *%s* violate the policy *%s* %d times since %s.\n\n",
+		v.alert.Workload, v.alert.Policy, v.count, v.first.UTC().Format(time.RFC3339))
+	for _, row := range [][2]string{
+		{"Namespace", v.alert.Namespace},
+		{"Workload", v.alert.Workload},
+		{"Policy", v.alert.Policy},
+		{"GitOps application", v.alert.GitOpsApplication},
+		{"Last revision", v.alert.GitOpsRevision},
+		{"Last object", v.alert.Instance},
+		{"Last requested by", v.alert.RequestingUser},
+		{"Last decision", v.alert.DecisionID},
+	} {
+		if row[1] != "" {
+			fmt.Fprintf(&b, "||%s|%s|\n", row[0], row[1])
+		}
+	}
+	fmt.Fprintf(&b, "\n{noformat}\n%s\n{noformat}\n", v.alert.Description)
+	if v.alert.Remediation != "" {
+		fmt.Fprintf(&b, "\nSuggested fix:\n{code}\n%s\n{code}\n", v.alert.Remediation)
+	}
+	return b.String()
+}
+
+// do sends a request to the Jira REST API, encoding in as the body if not
+// nil and decoding the response into out if not nil.
+func (t *Tracker) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, t.url+path, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if t.auth.User != "" {
+		req.SetBasicAuth(t.auth.User, t.auth.Token)
+	} else {
+		req.Header.Set("Authorization", "Bearer "+t.auth.Token)
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		// Jira explains errors as {"errorMessages": [...], "errors": {...}}
+		var reply struct {
+			ErrorMessages []string          `json:"errorMessages"`
+			Errors        map[string]string `json:"errors"`
+		}
+		json.NewDecoder(resp.Body).Decode(&reply)
+		messages := reply.ErrorMessages
+		for field, message := range reply.Errors {
+			messages = append(messages, field+": "+message)
+		}
+		if len(messages) > 0 {
+			return fmt.Errorf("jira returned %s: %s", resp.Status, strings.Join(messages, "; "))
+		}
+		return fmt.Errorf("jira returned %s", resp.Status)
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package loglevel
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+var logger klog.Logger = klog.LoggerWithName(klog.Background(), "loglevel")
+
+// Levels changes the klog verbosity, -v, and the per-file verbosity,
+// -vmodule, at runtime.
+type Levels struct {
+	flags *flag.FlagSet
+
+	lock sync.Mutex
+	// saved is the levels restored when revert fires.
+	saved  Settings
+	revert *time.Timer
+}
+
+// Settings are log levels. Verbosity is the -v level, and VModule the
+// comma separated pattern=N levels of the files matching pattern.
+type Settings struct {
+	Verbosity string `json:"v"`
+	VModule   string `json:"vmodule"`
+	// Until is when the levels are reverted, if they were changed for a
+	// limited time.
+	Until *time.Time `json:"until,omitempty"`
+}
+
+// New creates Levels controlling the global klog levels.
+func New() *Levels {
+	flags := flag.NewFlagSet("klog", flag.ContinueOnError)
+	klog.InitFlags(flags)
+	return &Levels{flags: flags}
+}
+
+// AddFlags registers -v and -vmodule in fs.
+func (l *Levels) AddFlags(fs *flag.FlagSet) {
+	for _, name := range []string{"v", "vmodule"} {
+		f := l.flags.Lookup(name)
+		fs.Var(f.Value, f.Name, f.Usage)
+	}
+}
+
+func (l *Levels) current() Settings {
+	return Settings{Verbosity: l.flags.Lookup("v").Value.String(), VModule: l.flags.Lookup("vmodule").Value.String()}
+}
+
+func (l *Levels) set(s Settings) error {
+	if err := l.flags.Set("v", s.Verbosity); err != nil {
+		return fmt.Errorf("invalid v %q: %w", s.Verbosity, err)
+	}
+	if err := l.flags.Set("vmodule", s.VModule); err != nil {
+		return fmt.Errorf("invalid vmodule %q: %w", s.VModule, err)
+	}
+	return nil
+}
+
+// ServeHTTP returns the current levels on GET, and changes them on PUT or
+// POST from the query parameters:
+//
+//	v         verbosity, e.g. 4
+//	vmodule   per-file verbosity, e.g. server=4,validator=2
+//	duration  reverts the change after this long, e.g. 15m
+//
+// Parameters that are not set keep their value.
+func (l *Levels) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		if err := l.change(r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	current := l.current()
+	if l.revert != nil {
+		current.Until = l.saved.Until
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(current)
+}
+
+func (l *Levels) change(r *http.Request) error {
+	query := r.URL.Query()
+	previous := l.current()
+	next := previous
+	if query.Has("v") {
+		next.Verbosity = query.Get("v")
+	}
+	if query.Has("vmodule") {
+		next.VModule = query.Get("vmodule")
+	}
+	var duration time.Duration
+	if value := query.Get("duration"); value != "" {
+		var err error
+		if duration, err = time.ParseDuration(value); err != nil || duration <= 0 {
+			return fmt.Errorf("invalid duration %q", value)
+		}
+	}
+
+	if err := l.set(next); err != nil {
+		// Restore the verbosity if only the vmodule was invalid
+		l.set(previous)
+		return err
+	}
+	logger.Info("changed log levels", "v", next.Verbosity, "vmodule", next.VModule, "duration", duration)
+
+	// A pending revert restores the levels from before the first change
+	if l.revert != nil {
+		l.revert.Stop()
+		l.revert = nil
+		previous = l.saved
+	}
+	if duration > 0 {
+		until := time.Now().Add(duration)
+		l.saved = Settings{Verbosity: previous.Verbosity, VModule: previous.VModule, Until: &until}
+		var revert *time.Timer
+		revert = time.AfterFunc(duration, func() {
+			l.lock.Lock()
+			defer l.lock.Unlock()
+			// The levels were changed again while waiting for the lock
+			if l.revert != revert {
+				return
+			}
+			if err := l.set(l.saved); err != nil {
+				logger.Error(err, "failed to revert log levels")
+			}
+			logger.Info("reverted log levels", "v", l.saved.Verbosity, "vmodule", l.saved.VModule)
+			l.revert = nil
+		})
+		l.revert = revert
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package lookup
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apiserver/pkg/cel/library"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+var logger klog.Logger = klog.LoggerWithName(klog.Background(), "lookup")
+
+var (
+	lock      sync.RWMutex
+	resources map[string]*resource
+)
+
+type resource struct {
+	lister    cache.GenericLister
+	hasSynced cache.InformerSynced
+}
+
+// Enable exposes the resources named in names, e.g. namespaces or
+// networkpolicies.networking.k8s.io, to policies through the lookup.get and
+// lookup.list CEL functions, backed by listers of informers from factory,
+// which must be started afterwards. It must be called before any expression
+// is compiled, since the functions are added to the Kubernetes CEL libraries.
+func Enable(factory dynamicinformer.DynamicSharedInformerFactory, mapper meta.RESTMapper, names []string) error {
+	exposed := map[string]*resource{}
+	for _, name := range names {
+		gvr, err := mapper.ResourceFor(schema.ParseGroupResource(name).WithVersion(""))
+		if err != nil {
+			return fmt.Errorf("lookup resource %s: %w", name, err)
+		}
+		informer := factory.ForResource(gvr)
+		exposed[name] = &resource{
+			lister:    informer.Lister(),
+			hasSynced: informer.Informer().HasSynced,
+		}
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if resources == nil {
+		library.ExtensionLibs = append(library.ExtensionLibs, functions)
+	}
+	resources = exposed
+	logger.Info("enabled lookups", "resources", names)
+	return nil
+}
+
+// functions declares lookup.get(resource, namespace, name), which returns the
+// named object or null, and lookup.list(resource, namespace), which returns
+// the objects in namespace, or of all namespaces if it is empty.
+var functions = cel.Lib(lookupLib{})
+
+type lookupLib struct{}
+
+func (lookupLib) CompileOptions() []cel.EnvOption {
+	return []cel.EnvOption{
+		cel.Function("lookup.get",
+			cel.Overload("lookup_get_string_string_string", []*cel.Type{cel.StringType, cel.StringType, cel.StringType}, cel.DynType,
+				cel.FunctionBinding(get))),
+		cel.Function("lookup.list",
+			cel.Overload("lookup_list_string_string", []*cel.Type{cel.StringType, cel.StringType}, cel.ListType(cel.DynType),
+				cel.BinaryBinding(list))),
+	}
+}
+
+func (lookupLib) ProgramOptions() []cel.ProgramOption {
+	return nil
+}
+
+// lister returns the lister of the resource named name, if it is exposed
+// and synced.
+func lister(name ref.Val) (cache.GenericLister, ref.Val) {
+	lock.RLock()
+	r, ok := resources[string(name.(types.String))]
+	lock.RUnlock()
+	if !ok {
+		return nil, types.NewErr("resource %s is not exposed to lookups", name)
+	}
+	if !r.hasSynced() {
+		return nil, types.NewErr("lookups of %s are not synced yet", name)
+	}
+	return r.lister, nil
+}
+
+func get(args ...ref.Val) ref.Val {
+	l, errVal := lister(args[0])
+	if errVal != nil {
+		return errVal
+	}
+	namespace, name := string(args[1].(types.String)), string(args[2].(types.String))
+
+	var obj interface{}
+	var err error
+	if namespace == "" {
+		obj, err = l.Get(name)
+	} else {
+		obj, err = l.ByNamespace(namespace).Get(name)
+	}
+	if err != nil {
+		// Only not found errors are returned by listers
+		return types.NullValue
+	}
+	return types.DefaultTypeAdapter.NativeToValue(obj.(*unstructured.Unstructured).UnstructuredContent())
+}
+
+func list(resourceName, namespace ref.Val) ref.Val {
+	l, errVal := lister(resourceName)
+	if errVal != nil {
+		return errVal
+	}
+
+	var objs []interface{}
+	var err error
+	if ns := string(namespace.(types.String)); ns == "" {
+		objs, err = toInterfaces(l.List(labels.Everything()))
+	} else {
+		objs, err = toInterfaces(l.ByNamespace(ns).List(labels.Everything()))
+	}
+	if err != nil {
+		return types.NewErr("lookup of %s failed: %v", resourceName, err)
+	}
+	return types.DefaultTypeAdapter.NativeToValue(objs)
+}
+
+func toInterfaces(objs []runtime.Object, err error) ([]interface{}, error) {
+	if err != nil {
+		return nil, err
+	}
+	contents := make([]interface{}, len(objs))
+	for i, obj := range objs {
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			return nil, fmt.Errorf("unexpected object type %T", obj)
+		}
+		contents[i] = u.UnstructuredContent()
+	}
+	return contents, nil
+}
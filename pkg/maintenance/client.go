@@ -0,0 +1,93 @@
+package maintenance
+
+import (
+	"context"
+
+	"k8s.io/api/admissionregistration/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	admissionregistrationv1alpha1 "k8s.io/client-go/kubernetes/typed/admissionregistration/v1alpha1"
+)
+
+// NewClient returns a client whose ValidatingAdmissionPolicyBindings
+// annotated with open maintenance windows have Deny replaced with Audit in
+// their validation actions, so an evaluator built on it audits the requests
+// they would deny. Other bindings are returned as they are.
+func NewClient(client kubernetes.Interface) kubernetes.Interface {
+	return downgradingClient{Interface: client}
+}
+
+type downgradingClient struct {
+	kubernetes.Interface
+}
+
+func (c downgradingClient) AdmissionregistrationV1alpha1() admissionregistrationv1alpha1.AdmissionregistrationV1alpha1Interface {
+	return downgradingGroup{AdmissionregistrationV1alpha1Interface: c.Interface.AdmissionregistrationV1alpha1()}
+}
+
+type downgradingGroup struct {
+	admissionregistrationv1alpha1.AdmissionregistrationV1alpha1Interface
+}
+
+func (g downgradingGroup) ValidatingAdmissionPolicyBindings() admissionregistrationv1alpha1.ValidatingAdmissionPolicyBindingInterface {
+	return downgradingBindings{ValidatingAdmissionPolicyBindingInterface: g.AdmissionregistrationV1alpha1Interface.ValidatingAdmissionPolicyBindings()}
+}
+
+type downgradingBindings struct {
+	admissionregistrationv1alpha1.ValidatingAdmissionPolicyBindingInterface
+}
+
+func (b downgradingBindings) Get(ctx context.Context, name string, opts metav1.GetOptions) (*v1alpha1.ValidatingAdmissionPolicyBinding, error) {
+	binding, err := b.ValidatingAdmissionPolicyBindingInterface.Get(ctx, name, opts)
+	if err != nil {
+		return nil, err
+	}
+	return downgraded(binding), nil
+}
+
+func (b downgradingBindings) List(ctx context.Context, opts metav1.ListOptions) (*v1alpha1.ValidatingAdmissionPolicyBindingList, error) {
+	list, err := b.ValidatingAdmissionPolicyBindingInterface.List(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	for i := range list.Items {
+		list.Items[i] = *downgraded(&list.Items[i])
+	}
+	return list, nil
+}
+
+func (b downgradingBindings) Watch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error) {
+	w, err := b.ValidatingAdmissionPolicyBindingInterface.Watch(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return watch.Filter(w, func(event watch.Event) (watch.Event, bool) {
+		if binding, ok := event.Object.(*v1alpha1.ValidatingAdmissionPolicyBinding); ok {
+			event.Object = downgraded(binding)
+		}
+		return event, true
+	}), nil
+}
+
+// downgraded returns a copy of binding auditing instead of denying if a
+// maintenance window is open for its policy, or binding as it is.
+func downgraded(binding *v1alpha1.ValidatingAdmissionPolicyBinding) *v1alpha1.ValidatingAdmissionPolicyBinding {
+	if binding.Annotations[WindowAnnotation] == "" {
+		return binding
+	}
+	out := binding.DeepCopy()
+	out.Spec.ValidationActions = auditActions(binding.Spec.ValidationActions)
+	return out
+}
+
+// auditActions returns actions with Deny replaced by Audit.
+func auditActions(actions []v1alpha1.ValidationAction) []v1alpha1.ValidationAction {
+	result := []v1alpha1.ValidationAction{v1alpha1.Audit}
+	for _, action := range actions {
+		if action != v1alpha1.Deny && action != v1alpha1.Audit {
+			result = append(result, action)
+		}
+	}
+	return result
+}
@@ -0,0 +1,226 @@
+package maintenance
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/klog/v2"
+
+	"k8s.io/cel-admission-webhook/pkg/apis/admissionregistration.x-k8s.io/v1alpha1"
+	"k8s.io/cel-admission-webhook/pkg/generated/clientset/versioned"
+	informers "k8s.io/cel-admission-webhook/pkg/generated/informers/externalversions/admissionregistration.x-k8s.io/v1alpha1"
+	listers "k8s.io/cel-admission-webhook/pkg/generated/listers/admissionregistration.x-k8s.io/v1alpha1"
+
+	"github.com/kubescape/kubeenforcer/pkg/alertmanager"
+)
+
+var logger klog.Logger = klog.LoggerWithName(klog.Background(), "maintenance")
+
+const (
+	// WindowAnnotation names the open windows a binding is downgraded for.
+	WindowAnnotation = "kubeenforcer.kubescape.io/maintenance-windows"
+
+	checkInterval = 30 * time.Second
+
+	// leaseName is the Lease electing the replica which annotates bindings
+	// and reports window transitions.
+	leaseName = "kubeenforcer-maintenance-windows"
+
+	leaseDuration = 15 * time.Second
+	renewDeadline = 10 * time.Second
+	retryPeriod   = 2 * time.Second
+)
+
+// Options configures the election of the replica managing the windows.
+type Options struct {
+	// LeaseNamespace is the namespace of the Lease electing the replica
+	// which annotates bindings, identified by Identity.
+	LeaseNamespace string
+	Identity       string
+}
+
+// Controller downgrades the bindings of the policies selected by open
+// maintenance windows to Audit, and restores them when the windows close.
+// Downgraded bindings are annotated with their open windows, leaving their
+// spec as it is, and evaluated by the webhook as if Deny were Audit, see
+// NewClient. Removing the annotation once the windows close keeps changes
+// made to the bindings in the meantime.
+//
+// Every replica follows the windows, while only the one elected through a
+// Lease annotates bindings and logs and alerts on windows opening and
+// closing.
+type Controller struct {
+	client     versioned.Interface
+	kubeClient kubernetes.Interface
+	bindings   listers.ValidatingAdmissionPolicyBindingLister
+	hasSynced  cache.InformerSynced
+	windows    []Window
+	opts       Options
+	alerter    *alertmanager.AlertManager
+	leading    atomic.Bool
+
+	// open holds the windows found open by the last check
+	open map[string]bool
+}
+
+// New creates a Controller for windows loaded with LoadWindows, alerting
+// through alerter, which may be nil, when a window opens or closes.
+// kubeClient holds the Lease.
+func New(client versioned.Interface, kubeClient kubernetes.Interface, bindingInformer informers.ValidatingAdmissionPolicyBindingInformer, windows []Window, opts Options, alerter *alertmanager.AlertManager) *Controller {
+	return &Controller{
+		client:     client,
+		kubeClient: kubeClient,
+		bindings:   bindingInformer.Lister(),
+		hasSynced:  bindingInformer.Informer().HasSynced,
+		windows:    windows,
+		opts:       opts,
+		alerter:    alerter,
+		open:       map[string]bool{},
+	}
+}
+
+// Run checks the windows every 30 seconds until ctx is cancelled.
+func (c *Controller) Run(ctx context.Context) error {
+	logger.Info("starting maintenance window controller", "windows", len(c.windows))
+	defer logger.Info("stopped maintenance window controller")
+
+	if !cache.WaitForCacheSync(ctx.Done(), c.hasSynced) {
+		return ctx.Err()
+	}
+
+	go c.elect(ctx)
+	wait.UntilWithContext(ctx, c.check, checkInterval)
+	return nil
+}
+
+// elect takes part in the election of the replica annotating bindings until
+// ctx is cancelled.
+func (c *Controller) elect(ctx context.Context) {
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta:  metav1.ObjectMeta{Namespace: c.opts.LeaseNamespace, Name: leaseName},
+		Client:     c.kubeClient.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: c.opts.Identity},
+	}
+	// RunOrDie returns once leadership is lost, to run for it again
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+			Lock:            lock,
+			LeaseDuration:   leaseDuration,
+			RenewDeadline:   renewDeadline,
+			RetryPeriod:     retryPeriod,
+			ReleaseOnCancel: true,
+			Name:            leaseName,
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: func(context.Context) {
+					logger.Info("elected to manage maintenance windows", "identity", c.opts.Identity)
+					c.leading.Store(true)
+				},
+				OnStoppedLeading: func() {
+					c.leading.Store(false)
+				},
+			},
+		})
+	}, retryPeriod)
+}
+
+func (c *Controller) check(ctx context.Context) {
+	now := time.Now()
+	leading := c.leading.Load()
+
+	// Windows selecting each policy that are open now
+	downgraded := map[string][]string{}
+	for i := range c.windows {
+		w := &c.windows[i]
+		open, end := w.Active(now)
+		if open != c.open[w.Name] {
+			// Transitions seen before being elected are not reported, so a
+			// new leader does not report windows opened under the previous one
+			if leading {
+				c.logTransition(w, open, end)
+			}
+			c.open[w.Name] = open
+		}
+		if open {
+			for _, policy := range w.Policies {
+				downgraded[policy] = append(downgraded[policy], w.Name)
+			}
+		}
+	}
+	if !leading {
+		return
+	}
+
+	bindings, err := c.bindings.List(labels.Everything())
+	if err != nil {
+		logger.Error(err, "failed to list bindings")
+		return
+	}
+	for _, binding := range bindings {
+		windows := downgraded[binding.Spec.PolicyName]
+		sort.Strings(windows)
+		if err := c.annotate(ctx, binding, strings.Join(windows, ",")); err != nil {
+			logger.Error(err, "failed to update binding", "binding", binding.Name, "policy", binding.Spec.PolicyName)
+		}
+	}
+}
+
+func (c *Controller) logTransition(w *Window, open bool, end time.Time) {
+	var name, description string
+	if open {
+		logger.Info("maintenance window opened", "window", w.Name, "policies", w.Policies, "closes", end)
+		name = "Maintenance window opened"
+		description = fmt.Sprintf("Policies %s are audited instead of denied until %s", strings.Join(w.Policies, ", "), end.UTC().Format(time.RFC3339))
+	} else {
+		logger.Info("maintenance window closed", "window", w.Name, "policies", w.Policies)
+		name = "Maintenance window closed"
+		description = fmt.Sprintf("Policies %s are enforced again", strings.Join(w.Policies, ", "))
+	}
+	if c.alerter != nil {
+		c.alerter.Alert(&alertmanager.AlertInfo{
+			Name:        name,
+			Severity:    "info",
+			Resource:    "maintenancewindow",
+			Instance:    w.Name,
+			Description: description,
+		})
+	}
+}
+
+// annotate sets the open windows of binding, a comma separated list, or
+// removes the annotation if there are none.
+func (c *Controller) annotate(ctx context.Context, binding *v1alpha1.ValidatingAdmissionPolicyBinding, windows string) error {
+	current, annotated := binding.Annotations[WindowAnnotation]
+	if current == windows && annotated == (windows != "") {
+		return nil
+	}
+
+	updated := binding.DeepCopy()
+	if windows == "" {
+		delete(updated.Annotations, WindowAnnotation)
+	} else {
+		if updated.Annotations == nil {
+			updated.Annotations = map[string]string{}
+		}
+		updated.Annotations[WindowAnnotation] = windows
+	}
+	if _, err := c.client.AdmissionregistrationV1alpha1().ValidatingAdmissionPolicyBindings().Update(ctx, updated, metav1.UpdateOptions{}); err != nil {
+		return err
+	}
+	if windows == "" {
+		logger.Info("restored binding", "binding", binding.Name, "policy", binding.Spec.PolicyName)
+	} else {
+		logger.Info("downgraded binding to audit", "binding", binding.Name, "policy", binding.Spec.PolicyName, "windows", windows)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package maintenance
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// Config is the maintenance window configuration file.
+type Config struct {
+	Windows []Window `json:"windows"`
+}
+
+// Window is a recurring period during which the selected policies are
+// downgraded from Deny to Audit.
+type Window struct {
+	// Name identifies the window in logs and alerts.
+	Name string `json:"name"`
+
+	// Schedule is a standard five field cron expression for the start of
+	// the window, optionally prefixed with CRON_TZ=<zone>.
+	Schedule string `json:"schedule"`
+
+	// Duration is how long the window stays open after each start.
+	Duration metav1.Duration `json:"duration"`
+
+	// Policies are the names of the ValidatingAdmissionPolicies downgraded
+	// while the window is open.
+	Policies []string `json:"policies"`
+
+	schedule cron.Schedule
+}
+
+// LoadWindows reads and validates the windows in the configuration file at
+// path.
+func LoadWindows(path string) ([]Window, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config Config
+	if err := yaml.UnmarshalStrict(raw, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	names := map[string]bool{}
+	for i := range config.Windows {
+		w := &config.Windows[i]
+		if w.Name == "" {
+			return nil, fmt.Errorf("window %d has no name", i)
+		}
+		if names[w.Name] {
+			return nil, fmt.Errorf("duplicate window %s", w.Name)
+		}
+		names[w.Name] = true
+
+		if w.schedule, err = cron.ParseStandard(w.Schedule); err != nil {
+			return nil, fmt.Errorf("window %s: invalid schedule: %w", w.Name, err)
+		}
+		if w.Duration.Duration <= 0 {
+			return nil, fmt.Errorf("window %s: duration must be positive", w.Name)
+		}
+		if len(w.Policies) == 0 {
+			return nil, fmt.Errorf("window %s selects no policies", w.Name)
+		}
+	}
+	return config.Windows, nil
+}
+
+// Active returns whether the window is open at now, and if so when it
+// closes.
+func (w *Window) Active(now time.Time) (bool, time.Time) {
+	// The latest start that could still be open is the first one after
+	// now-duration
+	start := w.schedule.Next(now.Add(-w.Duration.Duration))
+	if start.After(now) {
+		return false, time.Time{}
+	}
+	return true, start.Add(w.Duration.Duration)
+}
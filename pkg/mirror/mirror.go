@@ -0,0 +1,157 @@
+package mirror
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/klog/v2"
+
+	"github.com/kubescape/kubeenforcer/pkg/metrics"
+)
+
+var logger klog.Logger = klog.LoggerWithName(klog.Background(), "mirror")
+
+var mirroredReviews = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Name:      "mirrored_reviews_total",
+	Help:      "Admission reviews mirrored to the secondary webhook, by result: agreed or disagreed with the response of this webhook, failed, or dropped when the secondary could not keep up.",
+}, []string{"result"})
+
+func init() {
+	metrics.Registry.MustRegister(mirroredReviews)
+}
+
+const (
+	bufferSize  = 1000
+	workers     = 4
+	sendTimeout = 10 * time.Second
+)
+
+type review struct {
+	path    string
+	body    []byte
+	allowed bool
+}
+
+// Mirror sends a sample of the admission reviews served to a secondary
+// webhook, e.g. a deployment running a candidate version or policy set, and
+// counts how often its responses agree. Reviews are buffered and dropped if
+// the secondary cannot keep up, and its responses are discarded, so it never
+// affects admission.
+type Mirror struct {
+	url    string
+	rate   float64
+	client *http.Client
+
+	buffer  chan review
+	dropped atomic.Int64
+}
+
+// New creates a Mirror posting the given fraction of reviews, between 0 and
+// 1, to the webhook at url, on the path they were received on, with client.
+func New(url string, rate float64, client *http.Client) *Mirror {
+	c := *client
+	c.Timeout = sendTimeout
+	return &Mirror{
+		url:    strings.TrimSuffix(url, "/"),
+		rate:   rate,
+		client: &c,
+		buffer: make(chan review, bufferSize),
+	}
+}
+
+// Sample returns whether to mirror the next review.
+func (m *Mirror) Sample() bool {
+	return m.rate >= 1 || rand.Float64() < m.rate
+}
+
+// Send mirrors the admission review body received on path, to which this
+// webhook answered allowed.
+func (m *Mirror) Send(path string, body []byte, allowed bool) {
+	select {
+	case m.buffer <- review{path: path, body: body, allowed: allowed}:
+	default:
+		m.dropped.Add(1)
+		mirroredReviews.WithLabelValues("dropped").Inc()
+	}
+}
+
+// Run mirrors buffered reviews until ctx is cancelled.
+func (m *Mirror) Run(ctx context.Context) error {
+	logger.Info("mirroring admission reviews", "url", m.url, "rate", m.rate)
+	defer logger.Info("stopped mirroring admission reviews")
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.work(ctx)
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+func (m *Mirror) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case r := <-m.buffer:
+			if dropped := m.dropped.Swap(0); dropped > 0 {
+				logger.Info("dropped admission reviews because the secondary webhook could not keep up", "count", dropped)
+			}
+			allowed, err := m.send(ctx, r)
+			switch {
+			case err != nil:
+				if ctx.Err() != nil {
+					return
+				}
+				mirroredReviews.WithLabelValues("failed").Inc()
+				logger.V(2).Info("failed to mirror admission review", "err", err)
+			case allowed == r.allowed:
+				mirroredReviews.WithLabelValues("agreed").Inc()
+			default:
+				mirroredReviews.WithLabelValues("disagreed").Inc()
+				logger.V(2).Info("secondary webhook disagreed", "path", r.path, "allowed", r.allowed, "secondaryAllowed", allowed)
+			}
+		}
+	}
+}
+
+// send posts r to the secondary webhook and returns whether it allowed it.
+func (m *Mirror) send(ctx context.Context, r review) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.url+r.path, bytes.NewReader(r.body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return false, fmt.Errorf("secondary webhook returned %s", resp.Status)
+	}
+	var out admissionv1.AdmissionReview
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return false, err
+	}
+	if out.Response == nil {
+		return false, fmt.Errorf("secondary webhook returned no response")
+	}
+	return out.Response.Allowed, nil
+}
@@ -0,0 +1,149 @@
+package mutation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/structpb"
+	celconfig "k8s.io/apiserver/pkg/apis/cel"
+	"k8s.io/apiserver/pkg/cel/library"
+)
+
+var (
+	envOnce sync.Once
+	env     *cel.Env
+	envErr  error
+)
+
+// getEnv returns the environment mutation expressions are compiled in. It
+// provides the Kubernetes CEL libraries and the object, oldObject and
+// request variables.
+func getEnv() (*cel.Env, error) {
+	envOnce.Do(func() {
+		opts := []cel.EnvOption{
+			cel.Variable("object", cel.DynType),
+			cel.Variable("oldObject", cel.DynType),
+			cel.Variable("request", cel.DynType),
+		}
+		env, envErr = cel.NewEnv(append(opts, library.ExtensionLibs...)...)
+	})
+	return env, envErr
+}
+
+type compiledPolicy struct {
+	resourceVersion string
+	matchConditions []namedProgram
+	mutations       []compiledMutation
+}
+
+type namedProgram struct {
+	name    string
+	program cel.Program
+}
+
+type compiledMutation struct {
+	patchType PatchType
+	program   cel.Program
+}
+
+func compileExpression(expression string) (cel.Program, error) {
+	env, err := getEnv()
+	if err != nil {
+		return nil, err
+	}
+	ast, issues := env.Compile(expression)
+	if issues != nil && issues.Err() != nil {
+		return nil, issues.Err()
+	}
+	return env.Program(ast, cel.CostLimit(celconfig.PerCallLimit))
+}
+
+func compile(policy *MutatingAdmissionPolicy) (*compiledPolicy, error) {
+	compiled := &compiledPolicy{resourceVersion: policy.ResourceVersion}
+	for _, condition := range policy.Spec.MatchConditions {
+		program, err := compileExpression(condition.Expression)
+		if err != nil {
+			return nil, fmt.Errorf("matchCondition %s: %w", condition.Name, err)
+		}
+		compiled.matchConditions = append(compiled.matchConditions, namedProgram{name: condition.Name, program: program})
+	}
+
+	for i, mutation := range policy.Spec.Mutations {
+		var expression *Expression
+		switch mutation.PatchType {
+		case PatchTypeApplyConfiguration:
+			expression = mutation.ApplyConfiguration
+		case PatchTypeJSONPatch:
+			expression = mutation.JSONPatch
+		default:
+			return nil, fmt.Errorf("mutation %d: unsupported patchType %q", i, mutation.PatchType)
+		}
+		if expression == nil {
+			return nil, fmt.Errorf("mutation %d: missing %s expression", i, mutation.PatchType)
+		}
+		program, err := compileExpression(expression.Expression)
+		if err != nil {
+			return nil, fmt.Errorf("mutation %d: %w", i, err)
+		}
+		compiled.mutations = append(compiled.mutations, compiledMutation{patchType: mutation.PatchType, program: program})
+	}
+	return compiled, nil
+}
+
+// matches evaluates the match conditions against vars.
+func (p *compiledPolicy) matches(ctx context.Context, vars map[string]interface{}) (bool, error) {
+	for _, condition := range p.matchConditions {
+		result, _, err := condition.program.ContextEval(ctx, vars)
+		if err != nil {
+			return false, fmt.Errorf("matchCondition %s: %w", condition.name, err)
+		}
+		matched, ok := result.(types.Bool)
+		if !ok {
+			return false, fmt.Errorf("matchCondition %s must evaluate to bool, got %v", condition.name, result.Type())
+		}
+		if !matched {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+var jsonValueType = reflect.TypeOf(&structpb.Value{})
+
+// evaluate returns the JSON encoded result of a mutation expression.
+func (m *compiledMutation) evaluate(ctx context.Context, vars map[string]interface{}) ([]byte, error) {
+	result, _, err := m.program.ContextEval(ctx, vars)
+	if err != nil {
+		return nil, err
+	}
+	native, err := result.ConvertToNative(jsonValueType)
+	if err != nil {
+		return nil, fmt.Errorf("%s expression result is not JSON: %w", m.patchType, err)
+	}
+	raw, err := protojson.Marshal(native.(*structpb.Value))
+	if err != nil {
+		return nil, err
+	}
+
+	// Reject results of the wrong shape here rather than in the patch
+	// libraries, whose errors do not mention the expression
+	switch m.patchType {
+	case PatchTypeApplyConfiguration:
+		var object map[string]interface{}
+		if err := json.Unmarshal(raw, &object); err != nil {
+			return nil, fmt.Errorf("ApplyConfiguration expression must evaluate to an object")
+		}
+	case PatchTypeJSONPatch:
+		var ops []map[string]interface{}
+		if err := json.Unmarshal(raw, &ops); err != nil {
+			return nil, fmt.Errorf("JSONPatch expression must evaluate to a list of patch operations")
+		}
+	}
+	return raw, nil
+}
@@ -0,0 +1,221 @@
+package mutation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"k8s.io/api/admissionregistration/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/apiserver/pkg/admission"
+	"k8s.io/apiserver/pkg/admission/plugin/cel"
+	"k8s.io/apiserver/pkg/admission/plugin/validatingadmissionpolicy/matching"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+var logger klog.Logger = klog.LoggerWithName(klog.Background(), "mutation")
+
+// Mutator applies the bound MutatingAdmissionPolicies to admitted objects.
+type Mutator struct {
+	policies       cache.GenericLister
+	bindings       cache.GenericLister
+	policiesSynced cache.InformerSynced
+	bindingsSynced cache.InformerSynced
+	matcher        *matching.Matcher
+
+	lock     sync.Mutex
+	compiled map[string]*compiledPolicy
+}
+
+// New creates a Mutator for the policies and bindings watched by the given
+// informers. Namespaces are looked up to evaluate namespace selectors.
+func New(policies, bindings informers.GenericInformer, namespaces corev1listers.NamespaceLister, client kubernetes.Interface) *Mutator {
+	return &Mutator{
+		policies:       policies.Lister(),
+		bindings:       bindings.Lister(),
+		policiesSynced: policies.Informer().HasSynced,
+		bindingsSynced: bindings.Informer().HasSynced,
+		matcher:        matching.NewMatcher(namespaces, client),
+		compiled:       map[string]*compiledPolicy{},
+	}
+}
+
+// Mutate applies the matching policies in binding name order to object, the
+// JSON encoding of the object in attrs, and returns the mutated object.
+func (m *Mutator) Mutate(ctx context.Context, attrs admission.Attributes, o admission.ObjectInterfaces, object []byte) ([]byte, []string, error) {
+	if !m.policiesSynced() || !m.bindingsSynced() {
+		return nil, nil, fmt.Errorf("mutating admission policies are not synced yet")
+	}
+
+	objs, err := m.bindings.List(labels.Everything())
+	if err != nil {
+		return nil, nil, err
+	}
+	bindings := make([]*MutatingAdmissionPolicyBinding, 0, len(objs))
+	for _, obj := range objs {
+		binding := &MutatingAdmissionPolicyBinding{}
+		if err := fromUnstructured(obj, binding); err != nil {
+			return nil, nil, err
+		}
+		bindings = append(bindings, binding)
+	}
+	sort.Slice(bindings, func(i, j int) bool { return bindings[i].Name < bindings[j].Name })
+
+	var oldObject interface{}
+	if attrs.GetOldObject() != nil {
+		if oldObject, err = runtime.DefaultUnstructuredConverter.ToUnstructured(attrs.GetOldObject()); err != nil {
+			return nil, nil, err
+		}
+	}
+	request, err := runtime.DefaultUnstructuredConverter.ToUnstructured(cel.CreateAdmissionRequest(attrs))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, binding := range bindings {
+		obj, err := m.policies.Get(binding.Spec.PolicyName)
+		if err != nil {
+			logger.V(4).Info("skipping binding of missing policy", "binding", binding.Name, "policy", binding.Spec.PolicyName)
+			continue
+		}
+		policy := &MutatingAdmissionPolicy{}
+		if err := fromUnstructured(obj, policy); err != nil {
+			return nil, nil, err
+		}
+
+		mutated, err := m.apply(ctx, policy, binding, attrs, o, object, oldObject, request)
+		if err != nil {
+			if policy.Spec.FailurePolicy != nil && *policy.Spec.FailurePolicy == v1alpha1.Ignore {
+				logger.Error(err, "ignoring failed mutating admission policy", "policy", policy.Name, "binding", binding.Name)
+				continue
+			}
+			return nil, nil, fmt.Errorf("MutatingAdmissionPolicy '%s' with binding '%s' failed: %w", policy.Name, binding.Name, err)
+		}
+		object = mutated
+	}
+	return object, nil, nil
+}
+
+func (m *Mutator) apply(ctx context.Context, policy *MutatingAdmissionPolicy, binding *MutatingAdmissionPolicyBinding, attrs admission.Attributes, o admission.ObjectInterfaces, object []byte, oldObject interface{}, request map[string]interface{}) ([]byte, error) {
+	// Policies without match constraints match nothing, as in the
+	// ValidatingAdmissionPolicy API
+	if policy.Spec.MatchConstraints == nil {
+		return object, nil
+	}
+	if matches, _, err := m.matcher.Matches(attrs, o, matchCriteria{*policy.Spec.MatchConstraints}); err != nil || !matches {
+		return object, err
+	}
+	if binding.Spec.MatchResources != nil {
+		if matches, _, err := m.matcher.Matches(attrs, o, matchCriteria{*binding.Spec.MatchResources}); err != nil || !matches {
+			return object, err
+		}
+	}
+
+	compiled, err := m.compile(policy)
+	if err != nil {
+		return nil, err
+	}
+
+	vars := map[string]interface{}{"oldObject": oldObject, "request": request}
+	current := map[string]interface{}{}
+	if err := json.Unmarshal(object, &current); err != nil {
+		return nil, err
+	}
+	vars["object"] = current
+	if matches, err := compiled.matches(ctx, vars); err != nil || !matches {
+		return object, err
+	}
+
+	for i, mutation := range compiled.mutations {
+		patch, err := mutation.evaluate(ctx, vars)
+		if err != nil {
+			return nil, fmt.Errorf("mutation %d: %w", i, err)
+		}
+
+		switch mutation.patchType {
+		case PatchTypeApplyConfiguration:
+			if _, ok := attrs.GetObject().(*unstructured.Unstructured); ok || attrs.GetObject() == nil {
+				object, err = jsonpatch.MergePatch(object, patch)
+			} else {
+				// Typed objects merge lists by key, e.g. containers by name
+				object, err = strategicpatch.StrategicMergePatch(object, patch, attrs.GetObject())
+			}
+		case PatchTypeJSONPatch:
+			var decoded jsonpatch.Patch
+			if decoded, err = jsonpatch.DecodePatch(patch); err == nil {
+				object, err = decoded.Apply(object)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("mutation %d: %w", i, err)
+		}
+
+		// Later mutations see the changes of earlier ones
+		current = map[string]interface{}{}
+		if err := json.Unmarshal(object, &current); err != nil {
+			return nil, err
+		}
+		vars["object"] = current
+	}
+	return object, nil
+}
+
+// compile returns the compiled policy, compiling it again only when it
+// changed.
+func (m *Mutator) compile(policy *MutatingAdmissionPolicy) (*compiledPolicy, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if compiled, ok := m.compiled[policy.Name]; ok && compiled.resourceVersion == policy.ResourceVersion {
+		return compiled, nil
+	}
+	compiled, err := compile(policy)
+	if err != nil {
+		return nil, err
+	}
+	m.compiled[policy.Name] = compiled
+	return compiled, nil
+}
+
+func fromUnstructured(obj runtime.Object, into interface{}) error {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return fmt.Errorf("unexpected object type %T", obj)
+	}
+	return runtime.DefaultUnstructuredConverter.FromUnstructured(u.UnstructuredContent(), into)
+}
+
+// matchCriteria adapts MatchResources to the admission matcher. Unset
+// selectors match everything.
+type matchCriteria struct {
+	resources v1alpha1.MatchResources
+}
+
+func (c matchCriteria) GetParsedNamespaceSelector() (labels.Selector, error) {
+	if c.resources.NamespaceSelector == nil {
+		return labels.Everything(), nil
+	}
+	return metav1.LabelSelectorAsSelector(c.resources.NamespaceSelector)
+}
+
+func (c matchCriteria) GetParsedObjectSelector() (labels.Selector, error) {
+	if c.resources.ObjectSelector == nil {
+		return labels.Everything(), nil
+	}
+	return metav1.LabelSelectorAsSelector(c.resources.ObjectSelector)
+}
+
+func (c matchCriteria) GetMatchResources() v1alpha1.MatchResources {
+	return c.resources
+}
@@ -0,0 +1,161 @@
+package mutation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apiserver/pkg/admission"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+)
+
+// SecurityContextDefaultsLabel opts a namespace in to security context
+// defaulting when set to "enabled".
+const SecurityContextDefaultsLabel = "kubeenforcer.kubescape.io/security-context-defaults"
+
+// SecurityContextDefaults selects the defaults injected into pod specs that
+// omit them.
+type SecurityContextDefaults struct {
+	// RunAsNonRoot sets the pod securityContext.runAsNonRoot.
+	RunAsNonRoot bool
+	// SeccompProfile sets the pod securityContext.seccompProfile to
+	// RuntimeDefault.
+	SeccompProfile bool
+	// DropAllCapabilities drops ALL capabilities in every container.
+	DropAllCapabilities bool
+	// ReadOnlyRootFilesystem sets readOnlyRootFilesystem in every container.
+	ReadOnlyRootFilesystem bool
+}
+
+// ParseSecurityContextDefaults parses a list of runAsNonRoot,
+// seccompProfile, dropAllCapabilities and readOnlyRootFilesystem.
+func ParseSecurityContextDefaults(names []string) (SecurityContextDefaults, error) {
+	var defaults SecurityContextDefaults
+	for _, name := range names {
+		switch strings.TrimSpace(name) {
+		case "runAsNonRoot":
+			defaults.RunAsNonRoot = true
+		case "seccompProfile":
+			defaults.SeccompProfile = true
+		case "dropAllCapabilities":
+			defaults.DropAllCapabilities = true
+		case "readOnlyRootFilesystem":
+			defaults.ReadOnlyRootFilesystem = true
+		default:
+			return defaults, fmt.Errorf("unknown security context default %q", name)
+		}
+	}
+	return defaults, nil
+}
+
+// SecurityContextDefaulter injects safe security context defaults into the
+// pod specs of pods and workloads in opted in namespaces. Fields already set
+// are never changed.
+type SecurityContextDefaulter struct {
+	defaults   SecurityContextDefaults
+	namespaces corev1listers.NamespaceLister
+}
+
+func NewSecurityContextDefaulter(defaults SecurityContextDefaults, namespaces corev1listers.NamespaceLister) *SecurityContextDefaulter {
+	return &SecurityContextDefaulter{defaults: defaults, namespaces: namespaces}
+}
+
+func (d *SecurityContextDefaulter) Mutate(ctx context.Context, attrs admission.Attributes, o admission.ObjectInterfaces, object []byte) ([]byte, []string, error) {
+	if attrs.GetSubresource() != "" || attrs.GetNamespace() == "" {
+		return object, nil, nil
+	}
+
+	var fields []string
+	switch attrs.GetKind().Kind {
+	case "Pod":
+		// The pod spec is immutable once created
+		if attrs.GetOperation() != admission.Create {
+			return object, nil, nil
+		}
+		fields = []string{"spec"}
+	case "Deployment", "ReplicaSet", "DaemonSet", "StatefulSet", "Job":
+		fields = []string{"spec", "template", "spec"}
+	case "CronJob":
+		fields = []string{"spec", "jobTemplate", "spec", "template", "spec"}
+	default:
+		return object, nil, nil
+	}
+
+	namespace, err := d.namespaces.Get(attrs.GetNamespace())
+	if k8serrors.IsNotFound(err) {
+		return object, nil, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+	if namespace.Labels[SecurityContextDefaultsLabel] != "enabled" {
+		return object, nil, nil
+	}
+
+	content := map[string]interface{}{}
+	if err := json.Unmarshal(object, &content); err != nil {
+		return nil, nil, err
+	}
+	spec, found, err := unstructured.NestedMap(content, fields...)
+	if !found || err != nil {
+		return object, nil, err
+	}
+
+	applied := d.apply(spec)
+	if len(applied) == 0 {
+		return object, nil, nil
+	}
+	if err := unstructured.SetNestedMap(content, spec, fields...); err != nil {
+		return nil, nil, err
+	}
+	mutated, err := json.Marshal(content)
+	if err != nil {
+		return nil, nil, err
+	}
+	return mutated, []string{"kubeenforcer defaulted " + strings.Join(applied, ", ")}, nil
+}
+
+// apply sets the enabled defaults missing from spec and returns the fields
+// it set.
+func (d *SecurityContextDefaulter) apply(spec map[string]interface{}) []string {
+	var applied []string
+	setDefault := func(obj map[string]interface{}, value interface{}, path string, fields ...string) {
+		if _, found, _ := unstructured.NestedFieldNoCopy(obj, fields...); found {
+			return
+		}
+		if err := unstructured.SetNestedField(obj, value, fields...); err == nil {
+			applied = append(applied, path)
+		}
+	}
+
+	if d.defaults.RunAsNonRoot {
+		setDefault(spec, true, "securityContext.runAsNonRoot", "securityContext", "runAsNonRoot")
+	}
+	if d.defaults.SeccompProfile {
+		setDefault(spec, map[string]interface{}{"type": "RuntimeDefault"}, "securityContext.seccompProfile", "securityContext", "seccompProfile")
+	}
+
+	for _, field := range []string{"initContainers", "containers"} {
+		list, _, _ := unstructured.NestedSlice(spec, field)
+		for i, c := range list {
+			container, ok := c.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			name, _, _ := unstructured.NestedString(container, "name")
+			if d.defaults.DropAllCapabilities {
+				setDefault(container, []interface{}{"ALL"}, fmt.Sprintf("%s[%s].securityContext.capabilities.drop", field, name), "securityContext", "capabilities", "drop")
+			}
+			if d.defaults.ReadOnlyRootFilesystem {
+				setDefault(container, true, fmt.Sprintf("%s[%s].securityContext.readOnlyRootFilesystem", field, name), "securityContext", "readOnlyRootFilesystem")
+			}
+			list[i] = container
+		}
+		if len(list) > 0 {
+			unstructured.SetNestedSlice(spec, list, field)
+		}
+	}
+	return applied
+}
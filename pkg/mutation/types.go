@@ -0,0 +1,91 @@
+package mutation
+
+import (
+	"k8s.io/api/admissionregistration/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// PolicyResource and BindingResource are the GroupVersionResources of the
+// MutatingAdmissionPolicy CRDs.
+var (
+	PolicyResource = schema.GroupVersionResource{
+		Group:    "kubeenforcer.kubescape.io",
+		Version:  "v1alpha1",
+		Resource: "mutatingadmissionpolicies",
+	}
+	BindingResource = schema.GroupVersionResource{
+		Group:    "kubeenforcer.kubescape.io",
+		Version:  "v1alpha1",
+		Resource: "mutatingadmissionpolicybindings",
+	}
+)
+
+// MutatingAdmissionPolicy mutates admitted objects with CEL expressions. It
+// follows the shape of the upcoming admissionregistration
+// MutatingAdmissionPolicy API so policies can move to it unchanged once it
+// is available.
+type MutatingAdmissionPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec MutatingAdmissionPolicySpec `json:"spec"`
+}
+
+type MutatingAdmissionPolicySpec struct {
+	// MatchConstraints selects the requests the policy mutates.
+	MatchConstraints *v1alpha1.MatchResources `json:"matchConstraints,omitempty"`
+
+	// MatchConditions further narrow the requests mutated. All must
+	// evaluate to true.
+	MatchConditions []v1alpha1.MatchCondition `json:"matchConditions,omitempty"`
+
+	// Mutations are applied in order, each seeing the object as changed by
+	// the previous ones.
+	Mutations []Mutation `json:"mutations"`
+
+	// FailurePolicy defaults to Fail.
+	FailurePolicy *v1alpha1.FailurePolicyType `json:"failurePolicy,omitempty"`
+}
+
+type PatchType string
+
+const (
+	PatchTypeApplyConfiguration PatchType = "ApplyConfiguration"
+	PatchTypeJSONPatch          PatchType = "JSONPatch"
+)
+
+// Mutation is a single change to the admitted object.
+type Mutation struct {
+	PatchType PatchType `json:"patchType"`
+
+	// ApplyConfiguration evaluates to a partial object merged into the
+	// admitted object, e.g. {"metadata": {"labels": {"team": "payments"}}}.
+	ApplyConfiguration *Expression `json:"applyConfiguration,omitempty"`
+
+	// JSONPatch evaluates to a list of JSON patch operations, e.g.
+	// [{"op": "add", "path": "/metadata/labels/team", "value": "payments"}].
+	JSONPatch *Expression `json:"jsonPatch,omitempty"`
+}
+
+// Expression is a CEL expression with object, oldObject and request in
+// scope.
+type Expression struct {
+	Expression string `json:"expression"`
+}
+
+// MutatingAdmissionPolicyBinding enables a MutatingAdmissionPolicy, optionally
+// for a subset of the requests it matches.
+type MutatingAdmissionPolicyBinding struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec MutatingAdmissionPolicyBindingSpec `json:"spec"`
+}
+
+type MutatingAdmissionPolicyBindingSpec struct {
+	PolicyName string `json:"policyName"`
+
+	// MatchResources further narrows the requests mutated.
+	MatchResources *v1alpha1.MatchResources `json:"matchResources,omitempty"`
+}
@@ -0,0 +1,298 @@
+package namespacepolicy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+
+	"k8s.io/cel-admission-webhook/pkg/apis/admissionregistration.x-k8s.io/v1alpha1"
+	"k8s.io/cel-admission-webhook/pkg/generated/clientset/versioned"
+)
+
+var logger klog.Logger = klog.LoggerWithName(klog.Background(), "namespacepolicy")
+
+const (
+	// ManagedByLabel marks the policies and bindings generated from
+	// NamespacePolicies. Only objects carrying it are modified.
+	ManagedByLabel = "kubeenforcer.kubescape.io/managed-by"
+	managedByValue = "namespacepolicy"
+
+	// SourceAnnotation records the namespace/name of the NamespacePolicy a
+	// policy or binding was generated from.
+	SourceAnnotation = "kubeenforcer.kubescape.io/namespace-policy"
+
+	cleanupInterval = 5 * time.Minute
+)
+
+// Controller compiles NamespacePolicies into cluster-wide
+// ValidatingAdmissionPolicies and bindings restricted to the namespace of
+// their source, so they are enforced by the same validator as every other
+// policy.
+type Controller struct {
+	client    versioned.Interface
+	lister    cache.GenericLister
+	hasSynced cache.InformerSynced
+	queue     workqueue.RateLimitingInterface
+}
+
+// New creates a Controller for the NamespacePolicies watched by informer.
+func New(client versioned.Interface, informer informers.GenericInformer) *Controller {
+	c := &Controller{
+		client:    client,
+		lister:    informer.Lister(),
+		hasSynced: informer.Informer().HasSynced,
+		queue:     workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+	}
+
+	enqueue := func(obj interface{}) {
+		key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+		if err != nil {
+			logger.Error(err, "failed to get key of namespace policy")
+			return
+		}
+		c.queue.Add(key)
+	}
+	informer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    enqueue,
+		UpdateFunc: func(_, obj interface{}) { enqueue(obj) },
+		DeleteFunc: enqueue,
+	})
+
+	return c
+}
+
+// Run processes NamespacePolicy changes until ctx is cancelled.
+func (c *Controller) Run(ctx context.Context) error {
+	defer c.queue.ShutDown()
+
+	logger.Info("starting namespace policy controller")
+	defer logger.Info("stopped namespace policy controller")
+
+	if !cache.WaitForCacheSync(ctx.Done(), c.hasSynced) {
+		return ctx.Err()
+	}
+
+	// Remove generated objects whose source was deleted while we were not
+	// watching
+	go wait.UntilWithContext(ctx, c.cleanup, cleanupInterval)
+
+	go func() {
+		for c.processNextItem(ctx) {
+		}
+	}()
+
+	<-ctx.Done()
+	return nil
+}
+
+func (c *Controller) processNextItem(ctx context.Context) bool {
+	key, shutdown := c.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer c.queue.Done(key)
+
+	if err := c.reconcile(ctx, key.(string)); err != nil {
+		logger.Error(err, "failed to reconcile namespace policy", "key", key)
+		c.queue.AddRateLimited(key)
+		return true
+	}
+	c.queue.Forget(key)
+	return true
+}
+
+func (c *Controller) reconcile(ctx context.Context, key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+
+	obj, err := c.lister.ByNamespace(namespace).Get(name)
+	if k8serrors.IsNotFound(err) {
+		return c.delete(ctx, generatedName(namespace, name))
+	} else if err != nil {
+		return err
+	}
+
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return fmt.Errorf("unexpected object type %T", obj)
+	}
+	source := &NamespacePolicy{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.UnstructuredContent(), source); err != nil {
+		return err
+	}
+
+	policy, binding := compile(source)
+	if err := c.applyPolicy(ctx, policy); err != nil {
+		return err
+	}
+	if err := c.applyBinding(ctx, binding); err != nil {
+		return err
+	}
+	logger.V(2).Info("compiled namespace policy", "namespace", namespace, "name", name, "policy", policy.Name)
+	return nil
+}
+
+// compile translates a NamespacePolicy into a policy and a binding that can
+// only match namespaced objects in the source's namespace.
+func compile(source *NamespacePolicy) (*v1alpha1.ValidatingAdmissionPolicy, *v1alpha1.ValidatingAdmissionPolicyBinding) {
+	name := generatedName(source.Namespace, source.Name)
+	meta := metav1.ObjectMeta{
+		Name:        name,
+		Labels:      map[string]string{ManagedByLabel: managedByValue},
+		Annotations: map[string]string{SourceAnnotation: source.Namespace + "/" + source.Name},
+	}
+
+	// Cluster-scoped objects are not subject to namespaceSelector, so rules
+	// must never match them
+	rules := make([]v1alpha1.NamedRuleWithOperations, len(source.Spec.ResourceRules))
+	for i, rule := range source.Spec.ResourceRules {
+		rules[i] = *rule.DeepCopy()
+		scope := v1alpha1.NamespacedScope
+		rules[i].Scope = &scope
+	}
+
+	policy := &v1alpha1.ValidatingAdmissionPolicy{
+		ObjectMeta: meta,
+		Spec: v1alpha1.ValidatingAdmissionPolicySpec{
+			MatchConstraints: &v1alpha1.MatchResources{ResourceRules: rules},
+			MatchConditions:  source.Spec.MatchConditions,
+			Validations:      source.Spec.Validations,
+			AuditAnnotations: source.Spec.AuditAnnotations,
+			FailurePolicy:    source.Spec.FailurePolicy,
+		},
+	}
+
+	actions := source.Spec.ValidationActions
+	if len(actions) == 0 {
+		actions = []v1alpha1.ValidationAction{v1alpha1.Deny}
+	}
+
+	binding := &v1alpha1.ValidatingAdmissionPolicyBinding{
+		ObjectMeta: *meta.DeepCopy(),
+		Spec: v1alpha1.ValidatingAdmissionPolicyBindingSpec{
+			PolicyName: name,
+			MatchResources: &v1alpha1.MatchResources{
+				NamespaceSelector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{corev1.LabelMetadataName: source.Namespace},
+				},
+				ObjectSelector: source.Spec.ObjectSelector,
+			},
+			ValidationActions: actions,
+		},
+	}
+	return policy, binding
+}
+
+// generatedName is the name of the policy and binding compiled from a
+// NamespacePolicy. Names too long for an object name are hashed.
+func generatedName(namespace, name string) string {
+	generated := "namespacepolicy." + namespace + "." + name
+	if len(generated) <= validation.DNS1123SubdomainMaxLength {
+		return generated
+	}
+	sum := sha256.Sum256([]byte(namespace + "/" + name))
+	return "namespacepolicy." + namespace + "." + hex.EncodeToString(sum[:16])
+}
+
+func (c *Controller) applyPolicy(ctx context.Context, desired *v1alpha1.ValidatingAdmissionPolicy) error {
+	policies := c.client.AdmissionregistrationV1alpha1().ValidatingAdmissionPolicies()
+
+	existing, err := policies.Get(ctx, desired.Name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		_, err = policies.Create(ctx, desired, metav1.CreateOptions{})
+		return err
+	} else if err != nil {
+		return err
+	}
+	if existing.Labels[ManagedByLabel] != managedByValue {
+		return fmt.Errorf("policy %s exists and was not generated from a namespace policy", desired.Name)
+	}
+	desired.ResourceVersion = existing.ResourceVersion
+	_, err = policies.Update(ctx, desired, metav1.UpdateOptions{})
+	return err
+}
+
+func (c *Controller) applyBinding(ctx context.Context, desired *v1alpha1.ValidatingAdmissionPolicyBinding) error {
+	bindings := c.client.AdmissionregistrationV1alpha1().ValidatingAdmissionPolicyBindings()
+
+	existing, err := bindings.Get(ctx, desired.Name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		_, err = bindings.Create(ctx, desired, metav1.CreateOptions{})
+		return err
+	} else if err != nil {
+		return err
+	}
+	if existing.Labels[ManagedByLabel] != managedByValue {
+		return fmt.Errorf("binding %s exists and was not generated from a namespace policy", desired.Name)
+	}
+	desired.ResourceVersion = existing.ResourceVersion
+	_, err = bindings.Update(ctx, desired, metav1.UpdateOptions{})
+	return err
+}
+
+// delete removes the binding and policy generated under name, if they were
+// generated by this controller.
+func (c *Controller) delete(ctx context.Context, name string) error {
+	bindings := c.client.AdmissionregistrationV1alpha1().ValidatingAdmissionPolicyBindings()
+	binding, err := bindings.Get(ctx, name, metav1.GetOptions{})
+	if err == nil && binding.Labels[ManagedByLabel] == managedByValue {
+		err = bindings.Delete(ctx, name, metav1.DeleteOptions{})
+	}
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return err
+	}
+
+	policies := c.client.AdmissionregistrationV1alpha1().ValidatingAdmissionPolicies()
+	policy, err := policies.Get(ctx, name, metav1.GetOptions{})
+	if err == nil && policy.Labels[ManagedByLabel] == managedByValue {
+		err = policies.Delete(ctx, name, metav1.DeleteOptions{})
+	}
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func (c *Controller) cleanup(ctx context.Context) {
+	selector := labels.SelectorFromSet(labels.Set{ManagedByLabel: managedByValue}).String()
+	policies, err := c.client.AdmissionregistrationV1alpha1().ValidatingAdmissionPolicies().List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		logger.Error(err, "failed to list generated policies")
+		return
+	}
+
+	for _, p := range policies.Items {
+		source := p.Annotations[SourceAnnotation]
+		namespace, name, err := cache.SplitMetaNamespaceKey(source)
+		if err == nil && source != "" {
+			if _, err = c.lister.ByNamespace(namespace).Get(name); err == nil {
+				continue
+			}
+		}
+		if err != nil && !k8serrors.IsNotFound(err) {
+			logger.Error(err, "failed to look up namespace policy", "policy", p.Name)
+			continue
+		}
+		logger.Info("removing policy generated from deleted namespace policy", "policy", p.Name, "source", source)
+		if err := c.delete(ctx, p.Name); err != nil {
+			logger.Error(err, "failed to remove generated policy", "policy", p.Name)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package namespacepolicy
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"k8s.io/cel-admission-webhook/pkg/apis/admissionregistration.x-k8s.io/v1alpha1"
+)
+
+// GroupVersionResource of the NamespacePolicy CRD.
+var GroupVersionResource = schema.GroupVersionResource{
+	Group:    "kubeenforcer.kubescape.io",
+	Version:  "v1alpha1",
+	Resource: "namespacepolicies",
+}
+
+// NamespacePolicy lets an application team define a policy in its own
+// namespace. It is enforced like a ValidatingAdmissionPolicy that can only
+// ever match namespaced objects in that namespace.
+type NamespacePolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec NamespacePolicySpec `json:"spec"`
+}
+
+// NamespacePolicySpec is the subset of a ValidatingAdmissionPolicy and its
+// binding that a team may control.
+type NamespacePolicySpec struct {
+	// ResourceRules selects the namespaced resources and operations the
+	// policy validates. The scope of every rule is forced to Namespaced.
+	ResourceRules []v1alpha1.NamedRuleWithOperations `json:"resourceRules"`
+
+	// ObjectSelector further narrows the objects validated by the policy.
+	ObjectSelector *metav1.LabelSelector `json:"objectSelector,omitempty"`
+
+	MatchConditions  []v1alpha1.MatchCondition   `json:"matchConditions,omitempty"`
+	Validations      []v1alpha1.Validation       `json:"validations,omitempty"`
+	AuditAnnotations []v1alpha1.AuditAnnotation  `json:"auditAnnotations,omitempty"`
+	FailurePolicy    *v1alpha1.FailurePolicyType `json:"failurePolicy,omitempty"`
+
+	// ValidationActions defaults to Deny.
+	ValidationActions []v1alpha1.ValidationAction `json:"validationActions,omitempty"`
+}
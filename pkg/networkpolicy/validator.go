@@ -0,0 +1,172 @@
+package networkpolicy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apiserver/pkg/admission"
+	"k8s.io/apiserver/pkg/warning"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	networkinginformers "k8s.io/client-go/informers/networking/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	networkinglisters "k8s.io/client-go/listers/networking/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+var logger klog.Logger = klog.LoggerWithName(klog.Background(), "networkpolicy")
+
+// ModeLabel overrides the mode of the Validator in the namespace it is set
+// on.
+const ModeLabel = "kubeenforcer.kubescape.io/require-network-policy"
+
+// Mode is how workloads created in namespaces lacking a NetworkPolicy are
+// handled.
+type Mode string
+
+const (
+	Deny     Mode = "Deny"
+	Warn     Mode = "Warn"
+	Disabled Mode = "Disabled"
+)
+
+// ParseMode parses Deny, Warn or Disabled.
+func ParseMode(s string) (Mode, error) {
+	switch mode := Mode(s); mode {
+	case Deny, Warn, Disabled:
+		return mode, nil
+	}
+	return "", fmt.Errorf("invalid mode %q, expected Deny, Warn or Disabled", s)
+}
+
+// workloads are the resources whose creation requires a NetworkPolicy.
+var workloads = map[schema.GroupResource]bool{
+	{Resource: "pods"}:                        true,
+	{Resource: "replicationcontrollers"}:      true,
+	{Group: "apps", Resource: "deployments"}:  true,
+	{Group: "apps", Resource: "replicasets"}:  true,
+	{Group: "apps", Resource: "statefulsets"}: true,
+	{Group: "apps", Resource: "daemonsets"}:   true,
+	{Group: "batch", Resource: "jobs"}:        true,
+	{Group: "batch", Resource: "cronjobs"}:    true,
+}
+
+// Validator denies, or warns on, creating workloads in namespaces without
+// any NetworkPolicy, so no workload runs unrestricted by default. Namespaces
+// labelled with ModeLabel use the mode of the label instead of the
+// Validator's.
+type Validator struct {
+	mode            Mode
+	namespaces      corelisters.NamespaceLister
+	networkPolicies networkinglisters.NetworkPolicyLister
+	hasSynced       []cache.InformerSynced
+}
+
+// New creates a Validator with the default mode, reading namespaces and
+// NetworkPolicies from the given informers.
+func New(mode Mode, namespaceInformer coreinformers.NamespaceInformer, networkPolicyInformer networkinginformers.NetworkPolicyInformer) *Validator {
+	return &Validator{
+		mode:            mode,
+		namespaces:      namespaceInformer.Lister(),
+		networkPolicies: networkPolicyInformer.Lister(),
+		hasSynced: []cache.InformerSynced{
+			namespaceInformer.Informer().HasSynced,
+			networkPolicyInformer.Informer().HasSynced,
+		},
+	}
+}
+
+// Rules returns the webhook rules sending the creation of namespaced
+// workloads to the Validator.
+func (v *Validator) Rules() []admissionregistrationv1.RuleWithOperations {
+	resources := map[string][]string{}
+	for gr := range workloads {
+		resources[gr.Group] = append(resources[gr.Group], gr.Resource)
+	}
+	scope := admissionregistrationv1.NamespacedScope
+	rules := make([]admissionregistrationv1.RuleWithOperations, 0, len(resources))
+	for group, groupResources := range resources {
+		sort.Strings(groupResources)
+		rules = append(rules, admissionregistrationv1.RuleWithOperations{
+			Operations: []admissionregistrationv1.OperationType{admissionregistrationv1.Create},
+			Rule: admissionregistrationv1.Rule{
+				APIGroups:   []string{group},
+				APIVersions: []string{"*"},
+				Resources:   groupResources,
+				Scope:       &scope,
+			},
+		})
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].APIGroups[0] < rules[j].APIGroups[0] })
+	return rules
+}
+
+func (v *Validator) Handles(operation admission.Operation) bool {
+	return operation == admission.Create
+}
+
+// Health returns an error until the namespaces and NetworkPolicies are
+// synced.
+func (v *Validator) Health() ([]string, error) {
+	for _, hasSynced := range v.hasSynced {
+		if !hasSynced() {
+			return nil, fmt.Errorf("namespaces and NetworkPolicies are not synced yet")
+		}
+	}
+	return nil, nil
+}
+
+func (v *Validator) Validate(ctx context.Context, a admission.Attributes, o admission.ObjectInterfaces) error {
+	if a.GetSubresource() != "" || a.GetNamespace() == "" || !workloads[a.GetResource().GroupResource()] {
+		return nil
+	}
+	if _, err := v.Health(); err != nil {
+		return err
+	}
+
+	mode := v.namespaceMode(a.GetNamespace())
+	if mode == Disabled {
+		return nil
+	}
+
+	policies, err := v.networkPolicies.NetworkPolicies(a.GetNamespace()).List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	if len(policies) > 0 {
+		return nil
+	}
+
+	message := fmt.Sprintf("namespace %s has no NetworkPolicy", a.GetNamespace())
+	logger.V(2).Info("workload created without NetworkPolicy", "mode", mode, "resource", a.GetResource().Resource, "namespace", a.GetNamespace(), "name", a.GetName())
+	if mode == Warn {
+		warning.AddWarning(ctx, "", message)
+		return nil
+	}
+	return admission.NewForbidden(a, errors.New(message))
+}
+
+// namespaceMode returns the mode set by the label of namespace, or the
+// default mode if it is unset or invalid.
+func (v *Validator) namespaceMode(namespace string) Mode {
+	ns, err := v.namespaces.Get(namespace)
+	if err != nil {
+		// The namespace may not be in the cache yet
+		return v.mode
+	}
+	value, ok := ns.Labels[ModeLabel]
+	if !ok {
+		return v.mode
+	}
+	mode, err := ParseMode(value)
+	if err != nil {
+		logger.Error(err, "ignoring invalid namespace label", "namespace", namespace, "label", ModeLabel)
+		return v.mode
+	}
+	return mode
+}
@@ -0,0 +1,215 @@
+package otellog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/kubescape/kubeenforcer/pkg/metrics"
+)
+
+var droppedRecords = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Name:      "otlp_log_records_dropped_total",
+	Help:      "Log records not exported over OTLP, because the collector could not keep up or rejected them.",
+})
+
+func init() {
+	metrics.Registry.MustRegister(droppedRecords)
+}
+
+const (
+	bufferSize    = 4096
+	batchSize     = 512
+	flushInterval = time.Second
+	sendTimeout   = 10 * time.Second
+)
+
+// record is a log record, before it is encoded.
+type record struct {
+	time       time.Time
+	severity   int
+	message    string
+	attributes []keyValue
+}
+
+// Exporter exports log records to an OpenTelemetry collector over OTLP/HTTP
+// with the JSON encoding. Records are buffered and sent in batches, and
+// dropped if the collector cannot keep up, so logging never blocks.
+type Exporter struct {
+	url      string
+	headers  map[string]string
+	resource []keyValue
+	client   *http.Client
+
+	buffer  chan record
+	dropped atomic.Int64
+}
+
+// New creates an Exporter posting to the OTLP/HTTP endpoint, e.g.
+// http://otel-collector:4318, with client, setting headers on every request.
+// resource describes the process, e.g. service.name.
+func New(endpoint string, headers map[string]string, resource map[string]string, client *http.Client) *Exporter {
+	c := *client
+	c.Timeout = sendTimeout
+	url := strings.TrimSuffix(endpoint, "/")
+	if !strings.HasSuffix(url, "/v1/logs") {
+		url += "/v1/logs"
+	}
+	e := &Exporter{
+		url:     url,
+		headers: headers,
+		client:  &c,
+		buffer:  make(chan record, bufferSize),
+	}
+	for k, v := range resource {
+		e.resource = append(e.resource, keyValue{Key: k, Value: value(v)})
+	}
+	return e
+}
+
+func (e *Exporter) export(r record) {
+	select {
+	case e.buffer <- r:
+	default:
+		e.dropped.Add(1)
+		droppedRecords.Inc()
+	}
+}
+
+// Run exports buffered records until ctx is cancelled, then sends the
+// records left.
+func (e *Exporter) Run(ctx context.Context) error {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	var batch []record
+	flush := func(ctx context.Context) {
+		if len(batch) == 0 {
+			return
+		}
+		if err := e.send(ctx, batch); err != nil {
+			droppedRecords.Add(float64(len(batch)))
+			// Not logged, which would feed the records back
+			fmt.Fprintf(os.Stderr, "failed to export %d log records over OTLP: %v\n", len(batch), err)
+		}
+		batch = batch[:0]
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			for len(e.buffer) > 0 && len(batch) < bufferSize {
+				batch = append(batch, <-e.buffer)
+			}
+			final, cancel := context.WithTimeout(context.Background(), sendTimeout)
+			flush(final)
+			cancel()
+			return nil
+		case r := <-e.buffer:
+			batch = append(batch, r)
+			if len(batch) >= batchSize {
+				flush(ctx)
+			}
+		case <-ticker.C:
+			if dropped := e.dropped.Swap(0); dropped > 0 {
+				fmt.Fprintf(os.Stderr, "dropped %d log records because the OTLP collector could not keep up\n", dropped)
+			}
+			flush(ctx)
+		}
+	}
+}
+
+func (e *Exporter) send(ctx context.Context, batch []record) error {
+	body, err := json.Marshal(e.request(batch))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector returned %s", resp.Status)
+	}
+	return nil
+}
+
+// request encodes batch as an OTLP ExportLogsServiceRequest.
+func (e *Exporter) request(batch []record) *exportRequest {
+	logs := scopeLogs{Scope: scope{Name: "kubeenforcer"}}
+	for _, r := range batch {
+		logs.LogRecords = append(logs.LogRecords, logRecord{
+			TimeUnixNano:   fmt.Sprint(r.time.UnixNano()),
+			SeverityNumber: r.severity,
+			SeverityText:   severityText(r.severity),
+			Body:           value(r.message),
+			Attributes:     r.attributes,
+		})
+	}
+	return &exportRequest{ResourceLogs: []resourceLogs{{
+		Resource:  resource{Attributes: e.resource},
+		ScopeLogs: []scopeLogs{logs},
+	}}}
+}
+
+// The OTLP JSON encoding of the logs service, see
+// https://github.com/open-telemetry/opentelemetry-proto/blob/main/opentelemetry/proto/logs/v1/logs.proto.
+type exportRequest struct {
+	ResourceLogs []resourceLogs `json:"resourceLogs"`
+}
+
+type resourceLogs struct {
+	Resource  resource    `json:"resource"`
+	ScopeLogs []scopeLogs `json:"scopeLogs"`
+}
+
+type resource struct {
+	Attributes []keyValue `json:"attributes,omitempty"`
+}
+
+type scopeLogs struct {
+	Scope      scope       `json:"scope"`
+	LogRecords []logRecord `json:"logRecords"`
+}
+
+type scope struct {
+	Name string `json:"name,omitempty"`
+}
+
+type logRecord struct {
+	TimeUnixNano   string     `json:"timeUnixNano"`
+	SeverityNumber int        `json:"severityNumber"`
+	SeverityText   string     `json:"severityText"`
+	Body           anyValue   `json:"body"`
+	Attributes     []keyValue `json:"attributes,omitempty"`
+}
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+type anyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
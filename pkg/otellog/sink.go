@@ -0,0 +1,170 @@
+package otellog
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"k8s.io/klog/v2"
+	"k8s.io/klog/v2/textlogger"
+)
+
+// Severity numbers of the OTLP log data model.
+const (
+	severityDebug = 5
+	severityInfo  = 9
+	severityWarn  = 13
+	severityError = 17
+)
+
+func severityText(severity int) string {
+	switch severity {
+	case severityDebug:
+		return "DEBUG"
+	case severityWarn:
+		return "WARN"
+	case severityError:
+		return "ERROR"
+	}
+	return "INFO"
+}
+
+// Install routes the logs of klog, and of the loggers derived from it, to
+// exporter in addition to stderr. klog still applies its verbosity flags,
+// and writes to stderr in its text format. It must be called before logging
+// starts, and exporter run until the program exits.
+func Install(exporter *Exporter) {
+	// klog filters records before passing them on
+	text := textlogger.NewLogger(textlogger.NewConfig(textlogger.Verbosity(math.MaxInt32)))
+	s := &sink{text: text.GetSink(), exporter: exporter}
+	klog.SetLoggerWithOptions(logr.New(s), klog.WriteKlogBuffer(s.writeKlogBuffer))
+}
+
+// sink writes records to text, and exports them. Names are part of the
+// messages klog passes on.
+type sink struct {
+	text     logr.LogSink
+	exporter *Exporter
+	values   []interface{}
+}
+
+func (s *sink) Init(info logr.RuntimeInfo) {
+	// Skip the frame of the sink
+	s.text.Init(logr.RuntimeInfo{CallDepth: info.CallDepth + 1})
+}
+
+func (s *sink) Enabled(level int) bool {
+	return true
+}
+
+func (s *sink) Info(level int, msg string, keysAndValues ...interface{}) {
+	s.text.Info(level, msg, keysAndValues...)
+	severity := severityInfo
+	if level > 0 {
+		severity = severityDebug
+	}
+	s.export(severity, msg, nil, keysAndValues)
+}
+
+func (s *sink) Error(err error, msg string, keysAndValues ...interface{}) {
+	s.text.Error(err, msg, keysAndValues...)
+	s.export(severityError, msg, err, keysAndValues)
+}
+
+// writeKlogBuffer writes a line formatted by klog, for calls such as Infof,
+// and exports its message.
+func (s *sink) writeKlogBuffer(data []byte) {
+	os.Stderr.Write(data)
+	line := strings.TrimSuffix(string(data), "\n")
+	severity := severityInfo
+	switch {
+	case strings.HasPrefix(line, "W"):
+		severity = severityWarn
+	case strings.HasPrefix(line, "E"), strings.HasPrefix(line, "F"):
+		severity = severityError
+	}
+	// Strip the header, e.g. I0102 15:04:05.000000    1 file.go:10]
+	if i := strings.Index(line, "] "); i >= 0 {
+		line = line[i+2:]
+	}
+	s.exporter.export(record{time: time.Now(), severity: severity, message: line})
+}
+
+func (s *sink) export(severity int, msg string, err error, keysAndValues []interface{}) {
+	var attributes []keyValue
+	if err != nil {
+		attributes = append(attributes, keyValue{Key: "err", Value: value(err)})
+	}
+	for _, kvs := range [][]interface{}{s.values, keysAndValues} {
+		for i := 0; i+1 < len(kvs); i += 2 {
+			attributes = append(attributes, keyValue{Key: fmt.Sprint(kvs[i]), Value: value(kvs[i+1])})
+		}
+	}
+	s.exporter.export(record{
+		time:       time.Now(),
+		severity:   severity,
+		message:    strings.TrimSuffix(msg, "\n"),
+		attributes: attributes,
+	})
+}
+
+func (s *sink) WithValues(keysAndValues ...interface{}) logr.LogSink {
+	out := *s
+	out.text = s.text.WithValues(keysAndValues...)
+	out.values = append(append([]interface{}{}, s.values...), keysAndValues...)
+	return &out
+}
+
+func (s *sink) WithName(name string) logr.LogSink {
+	out := *s
+	out.text = s.text.WithName(name)
+	return &out
+}
+
+func (s *sink) WithCallDepth(depth int) logr.LogSink {
+	out := *s
+	if text, ok := s.text.(logr.CallDepthLogSink); ok {
+		out.text = text.WithCallDepth(depth)
+	}
+	return &out
+}
+
+// value encodes v as an OTLP value: strings, booleans and numbers as such,
+// and other values as strings, in JSON if possible.
+func value(v interface{}) anyValue {
+	switch v := v.(type) {
+	case string:
+		return anyValue{StringValue: &v}
+	case bool:
+		return anyValue{BoolValue: &v}
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		s := fmt.Sprint(v)
+		return anyValue{IntValue: &s}
+	case float32:
+		f := float64(v)
+		return anyValue{DoubleValue: &f}
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			s := strconv.FormatFloat(v, 'g', -1, 64)
+			return anyValue{StringValue: &s}
+		}
+		return anyValue{DoubleValue: &v}
+	case error:
+		s := v.Error()
+		return anyValue{StringValue: &s}
+	case fmt.Stringer:
+		s := v.String()
+		return anyValue{StringValue: &s}
+	}
+	if raw, err := json.Marshal(v); err == nil {
+		s := string(raw)
+		return anyValue{StringValue: &s}
+	}
+	s := fmt.Sprintf("%+v", v)
+	return anyValue{StringValue: &s}
+}
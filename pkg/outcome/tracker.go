@@ -0,0 +1,482 @@
+package outcome
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/api/admissionregistration/v1alpha1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apiserver/pkg/admission"
+	"k8s.io/apiserver/pkg/admission/plugin/validatingadmissionpolicy"
+	"k8s.io/apiserver/pkg/admission/plugin/validatingadmissionpolicy/matching"
+	informers "k8s.io/client-go/informers/admissionregistration/v1alpha1"
+	"k8s.io/client-go/kubernetes"
+	listers "k8s.io/client-go/listers/admissionregistration/v1alpha1"
+	listersv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	"github.com/kubescape/kubeenforcer/pkg/metrics"
+)
+
+var logger klog.Logger = klog.LoggerWithName(klog.Background(), "outcome")
+
+var (
+	bindingOutcomes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "binding_outcomes_total",
+		Help:      "Requests matched by each policy binding, by outcome: matched, denied or error.",
+	}, []string{"policy", "binding", "outcome"})
+
+	bindingDenyRate = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Name:      "binding_deny_rate",
+		Help:      "Fraction of the requests matched by each policy binding over the last hour that it denied.",
+	}, []string{"policy", "binding"})
+
+	bindingErrorRate = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Name:      "binding_error_rate",
+		Help:      "Fraction of the requests matched by each policy binding over the last hour whose evaluation failed.",
+	}, []string{"policy", "binding"})
+
+	bindingAnomalies = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Name:      "binding_anomaly",
+		Help:      "Whether a policy binding is flagged with an anomaly, by kind: ErrorSpike or NoMatches.",
+	}, []string{"policy", "binding", "kind"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(bindingOutcomes, bindingDenyRate, bindingErrorRate, bindingAnomalies)
+}
+
+const (
+	// bucketWidth is the resolution of the rolling rates, kept for the
+	// recent window and the baseline before it.
+	bucketWidth    = 5 * time.Minute
+	recentWindow   = time.Hour
+	baselineWindow = 24 * time.Hour
+	buckets        = int((recentWindow + baselineWindow) / bucketWidth)
+
+	// minSpikeErrors is the number of errors over the recent window below
+	// which error rates are too noisy to be flagged.
+	minSpikeErrors = 5
+)
+
+// Anomaly is a kind of anomaly a binding is flagged with.
+type Anomaly string
+
+const (
+	// ErrorSpike flags bindings whose error rate over the last hour is
+	// several times their baseline error rate.
+	ErrorSpike Anomaly = "ErrorSpike"
+	// NoMatches flags bindings that have not matched any request for the
+	// idle period, which are likely dead.
+	NoMatches Anomaly = "NoMatches"
+)
+
+// deniedBindingPattern extracts the policy and binding named by the denial
+// of the ValidatingAdmissionPolicy plugin.
+var deniedBindingPattern = regexp.MustCompile(`ValidatingAdmissionPolicy '([^']+)' with binding '([^']+)' denied request: (.*)`)
+
+// evaluationErrors are the denial messages of evaluation and configuration
+// errors under the Fail failure policy, as opposed to failed validations.
+var evaluationErrors = []string{
+	"failed to configure",
+	"failed to evaluate",
+	"failed messageExpression",
+	"compilation error",
+	"resulted in error",
+	"cost budget",
+	"runtime cost could not be calculated",
+	"unexpected internal error",
+}
+
+// Options tunes anomaly detection.
+type Options struct {
+	// ErrorSpikeFactor is how many times its baseline error rate over the
+	// previous day the error rate of a binding over the last hour must reach
+	// to be flagged.
+	ErrorSpikeFactor float64
+	// IdleAfter is how long a binding must match no request to be flagged.
+	IdleAfter time.Duration
+}
+
+// Stats are the outcomes of a binding.
+type Stats struct {
+	Policy  string `json:"policy"`
+	Binding string `json:"binding"`
+	// Matched, Denied and Errors are the requests matched by the binding
+	// over the last hour, and those it denied or failed to evaluate.
+	Matched   int     `json:"matched"`
+	Denied    int     `json:"denied"`
+	Errors    int     `json:"errors"`
+	DenyRate  float64 `json:"denyRate"`
+	ErrorRate float64 `json:"errorRate"`
+	// BaselineErrorRate is the error rate over the day before the last hour.
+	BaselineErrorRate float64    `json:"baselineErrorRate"`
+	LastMatch         *time.Time `json:"lastMatch,omitempty"`
+	Anomalies         []Anomaly  `json:"anomalies,omitempty"`
+}
+
+// counts are the outcomes of a binding over a bucket.
+type counts struct {
+	bucket  int64
+	matched int
+	denied  int
+	errors  int
+}
+
+// history is the rolling outcomes of a binding.
+type history struct {
+	buckets   [buckets]counts
+	lastMatch time.Time
+}
+
+// Tracker tracks the outcomes of the requests matched by each binding, and
+// flags bindings whose errors spike or which match nothing for a while, so
+// broken or dead policies are noticed. It exports them as metrics and
+// serves them as JSON. History is kept in memory, so it restarts with the
+// webhook.
+type Tracker struct {
+	policies  listers.ValidatingAdmissionPolicyLister
+	bindings  listers.ValidatingAdmissionPolicyBindingLister
+	matcher   validatingadmissionpolicy.Matcher
+	hasSynced []cache.InformerSynced
+	opts      Options
+	started   time.Time
+	now       func() time.Time
+
+	lock      sync.Mutex
+	histories map[string]*history
+	flagged   map[string][]Anomaly
+}
+
+// New creates a Tracker for the policies and bindings watched by the given
+// informers.
+func New(policyInformer informers.ValidatingAdmissionPolicyInformer, bindingInformer informers.ValidatingAdmissionPolicyBindingInformer, namespaces listersv1.NamespaceLister, client kubernetes.Interface, opts Options) *Tracker {
+	return &Tracker{
+		policies: policyInformer.Lister(),
+		bindings: bindingInformer.Lister(),
+		matcher:  validatingadmissionpolicy.NewMatcher(matching.NewMatcher(namespaces, client)),
+		hasSynced: []cache.InformerSynced{
+			policyInformer.Informer().HasSynced,
+			bindingInformer.Informer().HasSynced,
+		},
+		opts:      opts,
+		started:   time.Now(),
+		now:       time.Now,
+		histories: map[string]*history{},
+		flagged:   map[string][]Anomaly{},
+	}
+}
+
+// Observe records the outcome of the evaluation of attrs for the bindings
+// matching it, from err, the result of the validation. Only the first
+// denial of a request is reported by the plugin, so bindings denying a
+// request another one denied too are counted as matched only.
+func (t *Tracker) Observe(ctx context.Context, attrs admission.Attributes, o admission.ObjectInterfaces, err error) {
+	policies, listErr := t.policies.List(labels.Everything())
+	if listErr != nil {
+		return
+	}
+	bindings, listErr := t.bindings.List(labels.Everything())
+	if listErr != nil {
+		return
+	}
+	byPolicy := map[string][]*v1alpha1.ValidatingAdmissionPolicyBinding{}
+	for _, binding := range bindings {
+		byPolicy[binding.Spec.PolicyName] = append(byPolicy[binding.Spec.PolicyName], binding)
+	}
+
+	var deniedPolicy, deniedBinding string
+	var failed bool
+	if err != nil {
+		if match := deniedBindingPattern.FindStringSubmatch(err.Error()); match != nil {
+			deniedPolicy, deniedBinding = match[1], match[2]
+			failed = isEvaluationError(match[3])
+		}
+	}
+
+	now := t.now()
+	for _, policy := range policies {
+		if policy.Spec.MatchConstraints == nil || len(byPolicy[policy.Name]) == 0 {
+			continue
+		}
+		matches, _, matchErr := t.matcher.DefinitionMatches(attrs, o, policy)
+		if matchErr != nil {
+			for _, binding := range byPolicy[policy.Name] {
+				t.record(policy.Name, binding.Name, now, false, true)
+			}
+			continue
+		}
+		if !matches {
+			continue
+		}
+		for _, binding := range byPolicy[policy.Name] {
+			matches, matchErr := t.matcher.BindingMatches(attrs, o, binding)
+			if matchErr == nil && !matches {
+				continue
+			}
+			denied := policy.Name == deniedPolicy && binding.Name == deniedBinding
+			t.record(policy.Name, binding.Name, now, denied && !failed, matchErr != nil || (denied && failed))
+		}
+	}
+}
+
+func isEvaluationError(message string) bool {
+	for _, e := range evaluationErrors {
+		if strings.Contains(message, e) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *Tracker) record(policy, binding string, now time.Time, denied, failed bool) {
+	bindingOutcomes.WithLabelValues(policy, binding, "matched").Inc()
+	if denied {
+		bindingOutcomes.WithLabelValues(policy, binding, "denied").Inc()
+	}
+	if failed {
+		bindingOutcomes.WithLabelValues(policy, binding, "error").Inc()
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	h, ok := t.histories[key(policy, binding)]
+	if !ok {
+		h = &history{}
+		t.histories[key(policy, binding)] = h
+	}
+	c := h.current(now)
+	c.matched++
+	if denied {
+		c.denied++
+	}
+	if failed {
+		c.errors++
+	}
+	h.lastMatch = now
+}
+
+func key(policy, binding string) string {
+	return policy + "/" + binding
+}
+
+// current returns the bucket of now, resetting it if it is stale.
+func (h *history) current(now time.Time) *counts {
+	bucket := now.UnixNano() / int64(bucketWidth)
+	c := &h.buckets[bucket%int64(buckets)]
+	if c.bucket != bucket {
+		*c = counts{bucket: bucket}
+	}
+	return c
+}
+
+// sum returns the outcomes of the buckets from since to until, excluded.
+func (h *history) sum(since, until time.Time) counts {
+	from, to := since.UnixNano()/int64(bucketWidth), until.UnixNano()/int64(bucketWidth)
+	var total counts
+	for _, c := range h.buckets {
+		if c.bucket > from && c.bucket <= to {
+			total.matched += c.matched
+			total.denied += c.denied
+			total.errors += c.errors
+		}
+	}
+	return total
+}
+
+// Run refreshes the rates and anomalies of bindings every minute until ctx
+// is cancelled.
+func (t *Tracker) Run(ctx context.Context) error {
+	logger.Info("starting binding outcome tracker")
+	defer logger.Info("stopped binding outcome tracker")
+
+	if !cache.WaitForCacheSync(ctx.Done(), t.hasSynced...) {
+		return ctx.Err()
+	}
+
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		t.refresh()
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (t *Tracker) refresh() {
+	stats, err := t.Stats()
+	if err != nil {
+		logger.Error(err, "failed to compute binding outcomes")
+		return
+	}
+
+	bindingDenyRate.Reset()
+	bindingErrorRate.Reset()
+	bindingAnomalies.Reset()
+	flagged := map[string][]Anomaly{}
+	for _, s := range stats {
+		bindingDenyRate.WithLabelValues(s.Policy, s.Binding).Set(s.DenyRate)
+		bindingErrorRate.WithLabelValues(s.Policy, s.Binding).Set(s.ErrorRate)
+		for _, anomaly := range []Anomaly{ErrorSpike, NoMatches} {
+			value := 0.0
+			if hasAnomaly(s.Anomalies, anomaly) {
+				value = 1
+			}
+			bindingAnomalies.WithLabelValues(s.Policy, s.Binding, string(anomaly)).Set(value)
+		}
+		k := key(s.Policy, s.Binding)
+		flagged[k] = s.Anomalies
+		for _, anomaly := range s.Anomalies {
+			if !hasAnomaly(t.flagged[k], anomaly) {
+				logger.Info("binding anomaly detected", "policy", s.Policy, "binding", s.Binding, "anomaly", anomaly, "errorRate", s.ErrorRate, "baselineErrorRate", s.BaselineErrorRate, "matched", s.Matched)
+			}
+		}
+	}
+	t.lock.Lock()
+	t.flagged = flagged
+	t.lock.Unlock()
+}
+
+func hasAnomaly(anomalies []Anomaly, anomaly Anomaly) bool {
+	for _, a := range anomalies {
+		if a == anomaly {
+			return true
+		}
+	}
+	return false
+}
+
+// Stats returns the outcomes of every loaded binding, flagged ones first.
+func (t *Tracker) Stats() ([]Stats, error) {
+	bindings, err := t.bindings.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	now := t.now()
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	stats := make([]Stats, 0, len(bindings))
+	keys := map[string]bool{}
+	for _, binding := range bindings {
+		k := key(binding.Spec.PolicyName, binding.Name)
+		keys[k] = true
+		h := t.histories[k]
+		if h == nil {
+			h = &history{}
+		}
+		s := Stats{Policy: binding.Spec.PolicyName, Binding: binding.Name}
+		recent := h.sum(now.Add(-recentWindow), now)
+		baseline := h.sum(now.Add(-recentWindow-baselineWindow), now.Add(-recentWindow))
+		s.Matched, s.Denied, s.Errors = recent.matched, recent.denied, recent.errors
+		s.DenyRate = rate(recent.denied, recent.matched)
+		s.ErrorRate = rate(recent.errors, recent.matched)
+		s.BaselineErrorRate = rate(baseline.errors, baseline.matched)
+		if !h.lastMatch.IsZero() {
+			lastMatch := h.lastMatch
+			s.LastMatch = &lastMatch
+		}
+
+		if recent.errors >= minSpikeErrors && s.ErrorRate > s.BaselineErrorRate && s.ErrorRate >= t.opts.ErrorSpikeFactor*s.BaselineErrorRate {
+			s.Anomalies = append(s.Anomalies, ErrorSpike)
+		}
+		// Bindings are only known to be idle once they have been observed
+		// for the whole period
+		observedSince := t.started
+		if created := binding.CreationTimestamp.Time; created.After(observedSince) {
+			observedSince = created
+		}
+		if t.opts.IdleAfter > 0 && now.Sub(observedSince) >= t.opts.IdleAfter && (h.lastMatch.IsZero() || now.Sub(h.lastMatch) >= t.opts.IdleAfter) {
+			s.Anomalies = append(s.Anomalies, NoMatches)
+		}
+		stats = append(stats, s)
+	}
+
+	// Deleted bindings are forgotten
+	for k := range t.histories {
+		if !keys[k] {
+			delete(t.histories, k)
+		}
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		a, b := stats[i], stats[j]
+		if (len(a.Anomalies) > 0) != (len(b.Anomalies) > 0) {
+			return len(a.Anomalies) > 0
+		}
+		if a.Policy != b.Policy {
+			return a.Policy < b.Policy
+		}
+		return a.Binding < b.Binding
+	})
+	return stats, nil
+}
+
+// Counts are the outcomes of the requests matched by a policy.
+type Counts struct {
+	Matched int
+	Denied  int
+	Errors  int
+}
+
+// PolicyCounts returns the outcomes of the requests matched by the bindings
+// of each policy over the last window, of at most a day.
+func (t *Tracker) PolicyCounts(window time.Duration) map[string]Counts {
+	now := t.now()
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	result := map[string]Counts{}
+	for k, h := range t.histories {
+		policy, _, _ := strings.Cut(k, "/")
+		c := h.sum(now.Add(-window), now)
+		total := result[policy]
+		total.Matched += c.matched
+		total.Denied += c.denied
+		total.Errors += c.errors
+		result[policy] = total
+	}
+	return result
+}
+
+func rate(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) / float64(total)
+}
+
+// ServeHTTP writes the outcomes of bindings as a JSON list, only the
+// flagged ones with ?anomalous=true.
+func (t *Tracker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	stats, err := t.Stats()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if r.URL.Query().Get("anomalous") == "true" {
+		flagged := []Stats{}
+		for _, s := range stats {
+			if len(s.Anomalies) > 0 {
+				flagged = append(flagged, s)
+			}
+		}
+		stats = flagged
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
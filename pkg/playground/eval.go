@@ -0,0 +1,137 @@
+package playground
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"time"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types/ref"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/structpb"
+	plugincel "k8s.io/apiserver/pkg/admission/plugin/cel"
+	celconfig "k8s.io/apiserver/pkg/apis/cel"
+	"k8s.io/apiserver/pkg/cel/library"
+	"k8s.io/klog/v2"
+
+	"github.com/kubescape/kubeenforcer/pkg/typecheck"
+)
+
+var logger klog.Logger = klog.LoggerWithName(klog.Background(), "playground")
+
+const (
+	// maxRequestBytes limits the size of evaluation requests, which hold up
+	// to three objects.
+	maxRequestBytes = 3 * 1024 * 1024
+
+	// evalTimeout bounds the evaluation of a single expression.
+	evalTimeout = 5 * time.Second
+)
+
+// Input is an expression and the variables it is evaluated with. Variables
+// that are not set are null.
+type Input struct {
+	Expression string      `json:"expression"`
+	Object     interface{} `json:"object,omitempty"`
+	OldObject  interface{} `json:"oldObject,omitempty"`
+	Params     interface{} `json:"params,omitempty"`
+	Request    interface{} `json:"request,omitempty"`
+}
+
+// Output is the result of an evaluation, or its compile or evaluation
+// error.
+type Output struct {
+	Result json.RawMessage `json:"result,omitempty"`
+	Type   string          `json:"type,omitempty"`
+	Cost   uint64          `json:"cost"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// Eval compiles and evaluates the expression of input in the environment
+// policies are evaluated in, with the same cost limit. The authorizer
+// variables are declared but not bound.
+func Eval(ctx context.Context, input Input) Output {
+	env, err := typecheck.NewEnv(nil, nil, true)
+	if err != nil {
+		return Output{Error: err.Error()}
+	}
+	ast, issues := env.Compile(input.Expression)
+	if issues != nil {
+		return Output{Error: "compilation failed: " + issues.String()}
+	}
+	program, err := env.Program(ast,
+		cel.EvalOptions(cel.OptOptimize, cel.OptTrackCost),
+		cel.OptimizeRegex(library.ExtensionLibRegexOptimizations...),
+		cel.InterruptCheckFrequency(celconfig.CheckFrequency),
+		cel.CostLimit(celconfig.PerCallLimit),
+	)
+	if err != nil {
+		return Output{Error: "program instantiation failed: " + err.Error()}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, evalTimeout)
+	defer cancel()
+	val, details, err := program.ContextEval(ctx, map[string]interface{}{
+		plugincel.ObjectVarName:    input.Object,
+		plugincel.OldObjectVarName: input.OldObject,
+		plugincel.ParamsVarName:    input.Params,
+		plugincel.RequestVarName:   input.Request,
+	})
+
+	var output Output
+	if details != nil && details.ActualCost() != nil {
+		output.Cost = *details.ActualCost()
+	}
+	if err != nil {
+		output.Error = "evaluation failed: " + err.Error()
+		return output
+	}
+	output.Type = val.Type().TypeName()
+	if output.Result, err = toJSON(val); err != nil {
+		output.Error = fmt.Sprintf("cannot represent result as JSON: %v", err)
+	}
+	return output
+}
+
+// toJSON converts val to JSON, falling back to its string form for values
+// JSON cannot hold, such as durations and timestamps.
+func toJSON(val ref.Val) (json.RawMessage, error) {
+	native, err := val.ConvertToNative(reflect.TypeOf(&structpb.Value{}))
+	if err != nil {
+		return json.Marshal(fmt.Sprint(val.Value()))
+	}
+	return protojson.Marshal(native.(*structpb.Value))
+}
+
+// Handler evaluates JSON encoded Inputs POSTed to it and responds with their
+// Output.
+type Handler struct{}
+
+func (Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var input Input
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBytes)).Decode(&input); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+	if input.Expression == "" {
+		http.Error(w, "invalid request: expression is required", http.StatusBadRequest)
+		return
+	}
+
+	output := Eval(r.Context(), input)
+	logger.V(2).Info("evaluated expression", "expression", input.Expression, "cost", output.Cost, "error", output.Error)
+
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(output)
+}
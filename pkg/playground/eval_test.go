@@ -0,0 +1,196 @@
+package playground
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// digits is a list of ten elements, nested in comprehensions to make
+// expressions expensive.
+const digits = "[0, 1, 2, 3, 4, 5, 6, 7, 8, 9]"
+
+func TestEval(t *testing.T) {
+	expired, cancel := context.WithDeadline(context.Background(), time.Now())
+	defer cancel()
+
+	tests := []struct {
+		name       string
+		ctx        context.Context
+		input      Input
+		result     string
+		typeName   string
+		error      string
+		costlyThan uint64
+	}{
+		{
+			name: "result and type",
+			input: Input{
+				Expression: "object.spec.containers.map(c, c.name)",
+				Object:     map[string]interface{}{"spec": map[string]interface{}{"containers": []interface{}{map[string]interface{}{"name": "app"}}}},
+			},
+			result:   `["app"]`,
+			typeName: "list",
+		},
+		{
+			name:     "unset variables are null",
+			input:    Input{Expression: "object == null && params == null"},
+			result:   "true",
+			typeName: "bool",
+		},
+		{
+			name:  "compile error",
+			input: Input{Expression: "object.spec.containers.all(c,"},
+			error: "compilation failed: ",
+		},
+		{
+			name:  "evaluation error",
+			input: Input{Expression: "object.spec", Object: map[string]interface{}{}},
+			error: "evaluation failed: no such key: spec",
+		},
+		{
+			name:       "cost limit",
+			input:      Input{Expression: "size(" + nested(6) + ") > 0"},
+			error:      "evaluation failed: operation cancelled: actual cost limit exceeded",
+			costlyThan: 1000000,
+		},
+		{
+			name:  "timeout",
+			ctx:   expired,
+			input: Input{Expression: "size(" + nested(3) + ") > 0"},
+			error: "evaluation failed: operation interrupted",
+		},
+		{
+			name:     "timestamp as JSON",
+			input:    Input{Expression: "timestamp('2024-03-01T12:00:00Z')"},
+			result:   `"2024-03-01T12:00:00Z"`,
+			typeName: "google.protobuf.Timestamp",
+		},
+		{
+			name:     "duration as JSON",
+			input:    Input{Expression: "{'timeout': duration('90m')}"},
+			result:   `{"timeout":"5400s"}`,
+			typeName: "map",
+		},
+		{
+			name:     "type falls back to its string form",
+			input:    Input{Expression: "type(1)"},
+			result:   `"int"`,
+			typeName: "type",
+		},
+		{
+			name:     "result JSON cannot represent",
+			input:    Input{Expression: "double('NaN')"},
+			error:    "cannot represent result as JSON: ",
+			typeName: "double",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := tt.ctx
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			output := Eval(ctx, tt.input)
+			if !strings.HasPrefix(output.Error, tt.error) || (tt.error == "") != (output.Error == "") {
+				t.Fatalf("Eval() error = %q, want %q", output.Error, tt.error)
+			}
+			if got := compact(output.Result); got != tt.result {
+				t.Errorf("Eval() result = %s, want %s", got, tt.result)
+			}
+			if output.Type != tt.typeName {
+				t.Errorf("Eval() type = %q, want %q", output.Type, tt.typeName)
+			}
+			if output.Cost < tt.costlyThan {
+				t.Errorf("Eval() cost = %d, want at least %d", output.Cost, tt.costlyThan)
+			}
+		})
+	}
+}
+
+// nested returns comprehensions over digits nested depth times, whose
+// evaluation iterates 10^depth times.
+func nested(depth int) string {
+	expression := "x"
+	for i := 0; i < depth; i++ {
+		expression = digits + ".map(x, " + expression + ")"
+	}
+	return expression
+}
+
+func compact(result []byte) string {
+	return strings.Join(strings.Fields(string(result)), "")
+}
+
+func TestHandler(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		status int
+		// response is a substring of the response body
+		response string
+	}{
+		{
+			name:     "evaluates expressions",
+			method:   http.MethodPost,
+			body:     `{"expression": "1 + 1"}`,
+			status:   http.StatusOK,
+			response: `"result":2`,
+		},
+		{
+			name:     "evaluation errors are reported in the output",
+			method:   http.MethodPost,
+			body:     `{"expression": "1 +"}`,
+			status:   http.StatusOK,
+			response: `"error":"compilation failed`,
+		},
+		{
+			name:     "method not allowed",
+			method:   http.MethodGet,
+			status:   http.StatusMethodNotAllowed,
+			response: "method not allowed",
+		},
+		{
+			name:     "missing expression",
+			method:   http.MethodPost,
+			body:     `{"object": {}}`,
+			status:   http.StatusBadRequest,
+			response: "expression is required",
+		},
+		{
+			name:     "malformed body",
+			method:   http.MethodPost,
+			body:     `{"expression":`,
+			status:   http.StatusBadRequest,
+			response: "invalid request",
+		},
+		{
+			name:     "oversized body",
+			method:   http.MethodPost,
+			body:     `{"expression": "true", "object": {"data": "` + strings.Repeat("x", maxRequestBytes) + `"}}`,
+			status:   http.StatusBadRequest,
+			response: "request body too large",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/admin/eval", strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			Handler{}.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Errorf("ServeHTTP() status = %d, want %d", rec.Code, tt.status)
+			}
+			if body := compact(rec.Body.Bytes()); !strings.Contains(body, compact([]byte(tt.response))) {
+				t.Errorf("ServeHTTP() body = %s, want it to contain %s", body, tt.response)
+			}
+			if tt.status == http.StatusMethodNotAllowed && rec.Header().Get("Allow") != http.MethodPost {
+				t.Errorf("ServeHTTP() Allow = %q, want %q", rec.Header().Get("Allow"), http.MethodPost)
+			}
+		})
+	}
+}
@@ -0,0 +1,220 @@
+package policycache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/klog/v2"
+
+	"k8s.io/cel-admission-webhook/pkg/apis/admissionregistration.x-k8s.io/v1alpha1"
+
+	"github.com/kubescape/kubeenforcer/pkg/metrics"
+	"github.com/kubescape/kubeenforcer/pkg/variables"
+)
+
+var logger klog.Logger = klog.LoggerWithName(klog.Background(), "policycache")
+
+var cacheResults = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Name:      "policy_cache_results_total",
+	Help:      "Lookups of the results of validating policies in the on-disk policy cache, by result: hit when validated earlier, or miss.",
+}, []string{"result"})
+
+func init() {
+	metrics.Registry.MustRegister(cacheResults)
+}
+
+// suffix is the file name suffix of entries.
+const suffix = ".json"
+
+// Options configures what a Cache is keyed by and how many entries it keeps.
+type Options struct {
+	// Salt is hashed into every key along with the build, e.g. the CEL
+	// libraries enabled, so results validated in another environment are
+	// not reused.
+	Salt string
+	// MaxEntries bounds the entries kept, the least recently used being
+	// deleted first. Unlimited if 0.
+	MaxEntries int
+}
+
+// Cache persists the results of validating ValidatingAdmissionPolicies in a
+// directory, keyed by the hash of their content, so a policy validated
+// before a restart is not compiled again. Each entry is a file holding the
+// validation errors of a policy, none if it is valid. Entries are written to
+// a temporary file first, so replicas may share the directory.
+type Cache struct {
+	dir  string
+	opts Options
+	// build is the hash of the build and the salt, hashed into every key
+	build []byte
+
+	// lock serializes writes, so pruning sees the entries written
+	lock sync.Mutex
+}
+
+// Open creates the directory dir if needed and returns the Cache of its
+// entries.
+func Open(dir string, opts Options) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create policy cache directory %s: %w", dir, err)
+	}
+	h := sha256.New()
+	if info, ok := debug.ReadBuildInfo(); ok {
+		h.Write([]byte(info.String()))
+	}
+	fmt.Fprintf(h, "%d:%s", len(opts.Salt), opts.Salt)
+	return &Cache{dir: dir, opts: opts, build: h.Sum(nil)}, nil
+}
+
+// Key returns the key of the results of validating policy: the hash of its
+// spec and variables, which are all its validation depends on.
+func (c *Cache) Key(policy *v1alpha1.ValidatingAdmissionPolicy) (string, error) {
+	content, err := json.Marshal(struct {
+		Spec      v1alpha1.ValidatingAdmissionPolicySpec `json:"spec"`
+		Variables string                                 `json:"variables"`
+	}{Spec: policy.Spec, Variables: policy.Annotations[variables.Annotation]})
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	h.Write(c.build)
+	h.Write(content)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// entry is the content of the file of an entry.
+type entry struct {
+	Errors []entryError `json:"errors"`
+}
+
+type entryError struct {
+	Type     field.ErrorType `json:"type"`
+	Field    string          `json:"field"`
+	BadValue interface{}     `json:"badValue,omitempty"`
+	Detail   string          `json:"detail,omitempty"`
+}
+
+// Get returns the validation errors cached under key, and whether it is
+// cached. Entries that cannot be read are missing.
+func (c *Cache) Get(key string) (field.ErrorList, bool) {
+	path := c.path(key)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Error(err, "failed to read policy cache entry", "path", path)
+		}
+		cacheResults.WithLabelValues("miss").Inc()
+		return nil, false
+	}
+	var e entry
+	if err := json.Unmarshal(data, &e); err != nil {
+		logger.Error(err, "ignoring invalid policy cache entry", "path", path)
+		cacheResults.WithLabelValues("miss").Inc()
+		return nil, false
+	}
+	cacheResults.WithLabelValues("hit").Inc()
+
+	// Entries are pruned by modification time, so mark it used
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); err != nil {
+		logger.V(2).Info("failed to mark policy cache entry used", "path", path, "err", err)
+	}
+
+	var errs field.ErrorList
+	for _, e := range e.Errors {
+		errs = append(errs, &field.Error{Type: e.Type, Field: e.Field, BadValue: e.BadValue, Detail: e.Detail})
+	}
+	return errs, true
+}
+
+// Put caches errs, the validation errors of the policy of key. Failures are
+// logged, leaving the policy to be validated again.
+func (c *Cache) Put(key string, errs field.ErrorList) {
+	e := entry{Errors: []entryError{}}
+	for _, err := range errs {
+		e.Errors = append(e.Errors, entryError{Type: err.Type, Field: err.Field, BadValue: err.BadValue, Detail: err.Detail})
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		logger.Error(err, "failed to encode policy cache entry", "key", key)
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if err := c.write(key, data); err != nil {
+		logger.Error(err, "failed to write policy cache entry", "key", key)
+		return
+	}
+	if c.opts.MaxEntries > 0 {
+		c.prune()
+	}
+}
+
+// write writes the entry of key through a temporary file, so it is never
+// read partly written.
+func (c *Cache) write(key string, data []byte) error {
+	f, err := os.CreateTemp(c.dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), c.path(key))
+}
+
+// prune deletes the least recently used entries beyond MaxEntries.
+func (c *Cache) prune() {
+	dirEntries, err := os.ReadDir(c.dir)
+	if err != nil {
+		logger.Error(err, "failed to list policy cache entries", "dir", c.dir)
+		return
+	}
+	type used struct {
+		name    string
+		modTime time.Time
+	}
+	var entries []used
+	for _, d := range dirEntries {
+		if d.IsDir() || !strings.HasSuffix(d.Name(), suffix) {
+			continue
+		}
+		info, err := d.Info()
+		if err != nil {
+			// Deleted by another replica
+			continue
+		}
+		entries = append(entries, used{name: d.Name(), modTime: info.ModTime()})
+	}
+	if len(entries) <= c.opts.MaxEntries {
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].modTime.Before(entries[j].modTime) })
+	for _, e := range entries[:len(entries)-c.opts.MaxEntries] {
+		if err := os.Remove(filepath.Join(c.dir, e.name)); err != nil && !os.IsNotExist(err) {
+			logger.Error(err, "failed to delete policy cache entry", "name", e.name)
+		}
+	}
+}
+
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.dir, key+suffix)
+}
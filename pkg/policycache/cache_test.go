@@ -0,0 +1,118 @@
+package policycache
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	"k8s.io/cel-admission-webhook/pkg/apis/admissionregistration.x-k8s.io/v1alpha1"
+
+	"github.com/kubescape/kubeenforcer/pkg/variables"
+)
+
+func TestKey(t *testing.T) {
+	policy := func(expression, vars string) *v1alpha1.ValidatingAdmissionPolicy {
+		p := &v1alpha1.ValidatingAdmissionPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "replicas", ResourceVersion: "1"},
+			Spec:       v1alpha1.ValidatingAdmissionPolicySpec{Validations: []v1alpha1.Validation{{Expression: expression}}},
+		}
+		if vars != "" {
+			p.Annotations = map[string]string{variables.Annotation: vars}
+		}
+		return p
+	}
+	renamed := policy("object.spec.replicas > 1", "")
+	renamed.Name, renamed.ResourceVersion = "other", "2"
+
+	cache, err := Open(t.TempDir(), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	salted, err := Open(t.TempDir(), Options{Salt: "lookup=configmaps"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := func(c *Cache, p *v1alpha1.ValidatingAdmissionPolicy) string {
+		k, err := c.Key(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+	want := key(cache, policy("object.spec.replicas > 1", ""))
+
+	tests := []struct {
+		name  string
+		cache *Cache
+		got   string
+		same  bool
+	}{
+		{name: "same content", cache: cache, got: key(cache, policy("object.spec.replicas > 1", "")), same: true},
+		{name: "other name and version", cache: cache, got: key(cache, renamed), same: true},
+		{name: "other expression", cache: cache, got: key(cache, policy("object.spec.replicas > 2", ""))},
+		{name: "variables", cache: cache, got: key(cache, policy("object.spec.replicas > 1", `[{"name": "a", "expression": "1"}]`))},
+		{name: "other salt", cache: salted, got: key(salted, policy("object.spec.replicas > 1", ""))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if (tt.got == want) != tt.same {
+				t.Errorf("Key() = %s, same as %s = %v, want %v", tt.got, want, !tt.same, tt.same)
+			}
+		})
+	}
+}
+
+func TestCache(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := Open(dir, Options{MaxEntries: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	invalid := field.ErrorList{field.Invalid(field.NewPath("spec", "validations").Index(0).Child("expression"), "object.spec.replicas >", "Syntax error")}
+
+	if _, ok := cache.Get("valid"); ok {
+		t.Fatalf("Get() of a missing entry is cached")
+	}
+	cache.Put("valid", nil)
+	cache.Put("invalid", invalid)
+
+	// A restart opens the directory again
+	cache, err = Open(dir, Options{MaxEntries: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errs, ok := cache.Get("valid"); !ok || len(errs) != 0 {
+		t.Errorf("Get() of a valid policy = %v, %v, want no errors", errs, ok)
+	}
+	if errs, ok := cache.Get("invalid"); !ok || !reflect.DeepEqual(errs, invalid) {
+		t.Errorf("Get() of an invalid policy = %v, %v, want %v", errs, ok, invalid)
+	}
+
+	// The least recently used entry is deleted beyond MaxEntries
+	past := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "invalid"+suffix), past, past); err != nil {
+		t.Fatal(err)
+	}
+	cache.Put("other", nil)
+	if _, ok := cache.Get("invalid"); ok {
+		t.Errorf("Get() of the least recently used entry is cached")
+	}
+	for _, key := range []string{"valid", "other"} {
+		if _, ok := cache.Get(key); !ok {
+			t.Errorf("Get(%s) is not cached", key)
+		}
+	}
+
+	// Entries that cannot be decoded are validated again
+	if err := os.WriteFile(filepath.Join(dir, "corrupt"+suffix), []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cache.Get("corrupt"); ok {
+		t.Errorf("Get() of a corrupt entry is cached")
+	}
+}
@@ -0,0 +1,220 @@
+package policycheck
+
+import (
+	"context"
+	"fmt"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/apiserver/pkg/admission"
+	plugincel "k8s.io/apiserver/pkg/admission/plugin/cel"
+	"k8s.io/apiserver/pkg/admission/plugin/validatingadmissionpolicy"
+	"k8s.io/apiserver/pkg/admission/plugin/webhook/matchconditions"
+	celconfig "k8s.io/apiserver/pkg/apis/cel"
+	"k8s.io/klog/v2"
+
+	"k8s.io/cel-admission-webhook/pkg/apis/admissionregistration.x-k8s.io/v1alpha1"
+
+	"github.com/kubescape/kubeenforcer/pkg/policycache"
+	"github.com/kubescape/kubeenforcer/pkg/variables"
+)
+
+var logger klog.Logger = klog.LoggerWithName(klog.Background(), "policycheck")
+
+var (
+	policyResource  = v1alpha1.SchemeGroupVersion.WithResource("validatingadmissionpolicies")
+	bindingResource = v1alpha1.SchemeGroupVersion.WithResource("validatingadmissionpolicybindings")
+)
+
+// Validator rejects ValidatingAdmissionPolicies whose expressions do not
+// compile, and bindings with invalid validation actions, so broken policies
+// are never loaded. Expressions are compiled against the same environment
+// as when they are evaluated, which also checks their result type.
+type Validator struct {
+	// cache holds the results of validating policies, if set
+	cache *policycache.Cache
+}
+
+// New creates a Validator, which looks policies up in cache before
+// compiling them, and caches the results of those it compiles. Policies are
+// always compiled if cache is nil.
+func New(cache *policycache.Cache) *Validator {
+	return &Validator{cache: cache}
+}
+
+// Rules returns the webhook rules sending policy and binding changes to the
+// Validator.
+func Rules() []admissionregistrationv1.RuleWithOperations {
+	scope := admissionregistrationv1.ClusterScope
+	return []admissionregistrationv1.RuleWithOperations{{
+		Operations: []admissionregistrationv1.OperationType{admissionregistrationv1.Create, admissionregistrationv1.Update},
+		Rule: admissionregistrationv1.Rule{
+			APIGroups:   []string{policyResource.Group},
+			APIVersions: []string{policyResource.Version},
+			Resources:   []string{policyResource.Resource, bindingResource.Resource},
+			Scope:       &scope,
+		},
+	}}
+}
+
+// Rules returns the webhook rules sending policy and binding changes to the
+// Validator, as the package level Rules.
+func (v *Validator) Rules() []admissionregistrationv1.RuleWithOperations {
+	return Rules()
+}
+
+func (v *Validator) Handles(operation admission.Operation) bool {
+	return operation == admission.Create || operation == admission.Update
+}
+
+func (v *Validator) Validate(ctx context.Context, a admission.Attributes, o admission.ObjectInterfaces) error {
+	if a.GetSubresource() != "" {
+		return nil
+	}
+
+	var errs field.ErrorList
+	switch a.GetResource() {
+	case policyResource:
+		policy := &v1alpha1.ValidatingAdmissionPolicy{}
+		if err := convert(a.GetObject(), policy); err != nil {
+			return admission.NewForbidden(a, err)
+		}
+		errs = v.validatePolicy(policy)
+	case bindingResource:
+		binding := &v1alpha1.ValidatingAdmissionPolicyBinding{}
+		if err := convert(a.GetObject(), binding); err != nil {
+			return admission.NewForbidden(a, err)
+		}
+		errs = ValidateBinding(binding)
+	default:
+		return nil
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	logger.Info("rejected invalid policy", "resource", a.GetResource().Resource, "name", a.GetName(), "errors", errs.ToAggregate().Error())
+	return k8serrors.NewInvalid(schema.GroupKind{Group: v1alpha1.GroupName, Kind: a.GetKind().Kind}, a.GetName(), errs)
+}
+
+// validatePolicy returns the compile errors of policy, cached if it was
+// validated before.
+func (v *Validator) validatePolicy(policy *v1alpha1.ValidatingAdmissionPolicy) field.ErrorList {
+	if v.cache == nil {
+		return ValidatePolicy(policy)
+	}
+	key, err := v.cache.Key(policy)
+	if err != nil {
+		logger.Error(err, "failed to hash policy", "policy", policy.Name)
+		return ValidatePolicy(policy)
+	}
+	if errs, ok := v.cache.Get(key); ok {
+		return errs
+	}
+	errs := ValidatePolicy(policy)
+	v.cache.Put(key, errs)
+	return errs
+}
+
+// convert converts obj, which is typed when the scheme knows it and
+// unstructured otherwise, into out.
+func convert(obj runtime.Object, out interface{}) error {
+	switch o := obj.(type) {
+	case *v1alpha1.ValidatingAdmissionPolicy:
+		*out.(*v1alpha1.ValidatingAdmissionPolicy) = *o
+	case *v1alpha1.ValidatingAdmissionPolicyBinding:
+		*out.(*v1alpha1.ValidatingAdmissionPolicyBinding) = *o
+	case *unstructured.Unstructured:
+		return runtime.DefaultUnstructuredConverter.FromUnstructured(o.UnstructuredContent(), out)
+	default:
+		return fmt.Errorf("unexpected object type %T", obj)
+	}
+	return nil
+}
+
+// ValidatePolicy compiles the expressions of policy and returns their
+// compile errors.
+func ValidatePolicy(policy *v1alpha1.ValidatingAdmissionPolicy) field.ErrorList {
+	vars := plugincel.OptionalVariableDeclarations{HasParams: policy.Spec.ParamKind != nil, HasAuthorizer: true}
+	spec := field.NewPath("spec")
+
+	var errs field.ErrorList
+	declared, err := variables.Parse(policy.Annotations)
+	if err != nil {
+		return append(errs, field.Invalid(field.NewPath("metadata", "annotations").Key(variables.Annotation), policy.Annotations[variables.Annotation], err.Error()))
+	}
+	check := func(path *field.Path, accessor plugincel.ExpressionAccessor) {
+		expression := accessor.GetExpression()
+		expanded, err := variables.Expand(declared, expression)
+		if err != nil {
+			errs = append(errs, field.Invalid(path, expression, err.Error()))
+			return
+		}
+		accessor = expandedExpression{ExpressionAccessor: accessor, expression: expanded}
+		result := plugincel.CompileCELExpression(accessor, vars, celconfig.PerCallLimit)
+		if result.Error != nil {
+			errs = append(errs, field.Invalid(path, expression, result.Error.Detail))
+		}
+	}
+
+	for i, c := range policy.Spec.MatchConditions {
+		check(spec.Child("matchConditions").Index(i).Child("expression"), &matchconditions.MatchCondition{Name: c.Name, Expression: c.Expression})
+	}
+	for i, validation := range policy.Spec.Validations {
+		path := spec.Child("validations").Index(i)
+		check(path.Child("expression"), &validatingadmissionpolicy.ValidationCondition{Expression: validation.Expression})
+		if validation.MessageExpression != "" {
+			check(path.Child("messageExpression"), &validatingadmissionpolicy.MessageExpressionCondition{MessageExpression: validation.MessageExpression})
+		}
+	}
+	for i, annotation := range policy.Spec.AuditAnnotations {
+		check(spec.Child("auditAnnotations").Index(i).Child("valueExpression"), &validatingadmissionpolicy.AuditAnnotationCondition{Key: annotation.Key, ValueExpression: annotation.ValueExpression})
+	}
+	return errs
+}
+
+// expandedExpression is an expression with its variables expanded.
+type expandedExpression struct {
+	plugincel.ExpressionAccessor
+	expression string
+}
+
+func (e expandedExpression) GetExpression() string {
+	return e.expression
+}
+
+// ValidateBinding returns the errors in the policy name and validation
+// actions of binding.
+func ValidateBinding(binding *v1alpha1.ValidatingAdmissionPolicyBinding) field.ErrorList {
+	spec := field.NewPath("spec")
+
+	var errs field.ErrorList
+	if binding.Spec.PolicyName == "" {
+		errs = append(errs, field.Required(spec.Child("policyName"), ""))
+	}
+
+	path := spec.Child("validationActions")
+	if len(binding.Spec.ValidationActions) == 0 {
+		errs = append(errs, field.Required(path, ""))
+	}
+	seen := map[v1alpha1.ValidationAction]bool{}
+	for i, action := range binding.Spec.ValidationActions {
+		switch action {
+		case v1alpha1.Deny, v1alpha1.Warn, v1alpha1.Audit:
+		default:
+			errs = append(errs, field.NotSupported(path.Index(i), action, []string{string(v1alpha1.Deny), string(v1alpha1.Warn), string(v1alpha1.Audit)}))
+		}
+		if seen[action] {
+			errs = append(errs, field.Duplicate(path.Index(i), action))
+		}
+		seen[action] = true
+	}
+	if seen[v1alpha1.Deny] && seen[v1alpha1.Warn] {
+		errs = append(errs, field.Invalid(path, binding.Spec.ValidationActions, "must not contain both Deny and Warn"))
+	}
+	return errs
+}
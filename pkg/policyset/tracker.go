@@ -0,0 +1,105 @@
+package policyset
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	informers "k8s.io/cel-admission-webhook/pkg/generated/informers/externalversions/admissionregistration.x-k8s.io/v1alpha1"
+)
+
+var logger klog.Logger = klog.LoggerWithName(klog.Background(), "policyset")
+
+// annotationPrefix is the prefix of the annotations that change how a
+// policy is evaluated without changing its generation, e.g. its variables.
+const annotationPrefix = "kubeenforcer.kubescape.io/"
+
+// Tracker hashes the policies and bindings in effect, so decisions can be
+// tied to the exact policies that produced them. The hash covers the name,
+// UID, generation and kubeenforcer annotations of every policy and binding,
+// and is recomputed on the first call after they change.
+type Tracker struct {
+	policies cache.Store
+	bindings cache.Store
+
+	lock  sync.Mutex
+	dirty bool
+	hash  string
+}
+
+// NewTracker creates a Tracker for the policies and bindings watched by the
+// given informers.
+func NewTracker(policyInformer informers.ValidatingAdmissionPolicyInformer, bindingInformer informers.ValidatingAdmissionPolicyBindingInformer) *Tracker {
+	t := &Tracker{
+		policies: policyInformer.Informer().GetStore(),
+		bindings: bindingInformer.Informer().GetStore(),
+		dirty:    true,
+	}
+
+	invalidate := func(interface{}) {
+		t.lock.Lock()
+		t.dirty = true
+		t.lock.Unlock()
+	}
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc:    invalidate,
+		UpdateFunc: func(_, _ interface{}) { invalidate(nil) },
+		DeleteFunc: invalidate,
+	}
+	policyInformer.Informer().AddEventHandler(handler)
+	bindingInformer.Informer().AddEventHandler(handler)
+
+	return t
+}
+
+// Hash returns the hash of the policies and bindings in effect, as 16
+// hexadecimal digits.
+func (t *Tracker) Hash() string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if !t.dirty {
+		return t.hash
+	}
+	t.dirty = false
+
+	var entries []string
+	for kind, store := range map[string]cache.Store{"policy": t.policies, "binding": t.bindings} {
+		for _, obj := range store.List() {
+			if o, ok := obj.(metav1.Object); ok {
+				entries = append(entries, entry(kind, o))
+			}
+		}
+	}
+	sort.Strings(entries)
+	sum := sha256.Sum256([]byte(strings.Join(entries, "\n")))
+	hash := hex.EncodeToString(sum[:8])
+	if hash != t.hash {
+		logger.Info("policy set changed", "hash", hash, "policies", entries)
+		t.hash = hash
+	}
+	return t.hash
+}
+
+// entry identifies the version of the policy or binding o.
+func entry(kind string, o metav1.Object) string {
+	var annotations []string
+	for k, v := range o.GetAnnotations() {
+		if strings.HasPrefix(k, annotationPrefix) {
+			sum := sha256.Sum256([]byte(v))
+			annotations = append(annotations, k+"="+hex.EncodeToString(sum[:4]))
+		}
+	}
+	sort.Strings(annotations)
+	entry := fmt.Sprintf("%s/%s uid=%s generation=%d", kind, o.GetName(), o.GetUID(), o.GetGeneration())
+	if len(annotations) > 0 {
+		entry += " " + strings.Join(annotations, " ")
+	}
+	return entry
+}
@@ -0,0 +1,52 @@
+package priority
+
+import (
+	"context"
+
+	"k8s.io/api/admissionregistration/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	admissionregistrationv1alpha1 "k8s.io/client-go/kubernetes/typed/admissionregistration/v1alpha1"
+)
+
+// NewClient returns a client that only lists and watches the
+// ValidatingAdmissionPolicies matching selector, so an evaluator built on it
+// only loads the policies of one tier. Bindings of other policies are ignored
+// by the evaluator as their policy is missing.
+func NewClient(client kubernetes.Interface, selector string) kubernetes.Interface {
+	return tierClient{Interface: client, selector: selector}
+}
+
+type tierClient struct {
+	kubernetes.Interface
+	selector string
+}
+
+func (c tierClient) AdmissionregistrationV1alpha1() admissionregistrationv1alpha1.AdmissionregistrationV1alpha1Interface {
+	return tierGroup{AdmissionregistrationV1alpha1Interface: c.Interface.AdmissionregistrationV1alpha1(), selector: c.selector}
+}
+
+type tierGroup struct {
+	admissionregistrationv1alpha1.AdmissionregistrationV1alpha1Interface
+	selector string
+}
+
+func (g tierGroup) ValidatingAdmissionPolicies() admissionregistrationv1alpha1.ValidatingAdmissionPolicyInterface {
+	return tierPolicies{ValidatingAdmissionPolicyInterface: g.AdmissionregistrationV1alpha1Interface.ValidatingAdmissionPolicies(), selector: g.selector}
+}
+
+type tierPolicies struct {
+	admissionregistrationv1alpha1.ValidatingAdmissionPolicyInterface
+	selector string
+}
+
+func (p tierPolicies) List(ctx context.Context, opts metav1.ListOptions) (*v1alpha1.ValidatingAdmissionPolicyList, error) {
+	opts.LabelSelector = p.selector
+	return p.ValidatingAdmissionPolicyInterface.List(ctx, opts)
+}
+
+func (p tierPolicies) Watch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error) {
+	opts.LabelSelector = p.selector
+	return p.ValidatingAdmissionPolicyInterface.Watch(ctx, opts)
+}
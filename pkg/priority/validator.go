@@ -0,0 +1,177 @@
+package priority
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apiserver/pkg/admission"
+)
+
+// PriorityLabel sets the priority of a ValidatingAdmissionPolicy. Policies
+// with a higher priority are evaluated first. Policies without it, or with a
+// priority that is not configured, have priority 0.
+const PriorityLabel = "kubeenforcer.kubescape.io/priority"
+
+// Level is a configured priority and the label selector of its policies.
+type Level struct {
+	Priority int
+	Selector string
+}
+
+// ParseLevels parses a list of non-negative priorities into levels, highest
+// first. Level 0 is always included as the default for unlabelled policies.
+func ParseLevels(values []string) ([]Level, error) {
+	seen := map[int]bool{0: true}
+	priorities := []int{0}
+	for _, v := range values {
+		p, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil || p < 0 {
+			return nil, fmt.Errorf("invalid priority %q", v)
+		}
+		if !seen[p] {
+			seen[p] = true
+			priorities = append(priorities, p)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(priorities)))
+
+	var others []string
+	for _, p := range priorities {
+		if p != 0 {
+			others = append(others, strconv.Itoa(p))
+		}
+	}
+	levels := make([]Level, len(priorities))
+	for i, p := range priorities {
+		if p == 0 && len(others) == 0 {
+			levels[i] = Level{Priority: 0}
+		} else if p == 0 {
+			// Also matches unlabelled policies
+			levels[i] = Level{Priority: 0, Selector: fmt.Sprintf("%s notin (%s)", PriorityLabel, strings.Join(others, ","))}
+		} else {
+			levels[i] = Level{Priority: p, Selector: fmt.Sprintf("%s=%d", PriorityLabel, p)}
+		}
+	}
+	return levels, nil
+}
+
+// Tier evaluates the policies of one priority.
+type Tier struct {
+	Priority  int
+	Validator admission.ValidationInterface
+}
+
+// Validator evaluates tiers from the highest priority to the lowest.
+type Validator struct {
+	tiers        []Tier
+	shortCircuit bool
+}
+
+// NewValidator creates a Validator for tiers. With shortCircuit, evaluation
+// stops at the first tier denying the request, so cheap guard policies can
+// spare the evaluation of expensive ones. Otherwise every tier is evaluated
+// and their denials are reported in priority order.
+func NewValidator(tiers []Tier, shortCircuit bool) *Validator {
+	sorted := append([]Tier(nil), tiers...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Priority > sorted[j].Priority })
+	return &Validator{tiers: sorted, shortCircuit: shortCircuit}
+}
+
+func (v *Validator) Handles(operation admission.Operation) bool {
+	for _, t := range v.tiers {
+		if t.Validator.Handles(operation) {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *Validator) Validate(ctx context.Context, a admission.Attributes, o admission.ObjectInterfaces) error {
+	var denials []error
+	for _, t := range v.tiers {
+		if !t.Validator.Handles(a.GetOperation()) {
+			continue
+		}
+		if err := t.Validator.Validate(ctx, a, o); err != nil {
+			if v.shortCircuit {
+				return err
+			}
+			denials = append(denials, err)
+		}
+	}
+	return aggregate(denials)
+}
+
+// aggregate returns the first denial with the messages of the others
+// appended, keeping its status reason and code.
+func aggregate(denials []error) error {
+	if len(denials) == 0 {
+		return nil
+	}
+	var first *k8serrors.StatusError
+	if len(denials) == 1 || !errors.As(denials[0], &first) {
+		return denials[0]
+	}
+
+	combined := &k8serrors.StatusError{ErrStatus: *first.ErrStatus.DeepCopy()}
+	messages := []string{combined.ErrStatus.Message}
+	for _, denial := range denials[1:] {
+		message := denial.Error()
+		var statusErr *k8serrors.StatusError
+		if errors.As(denial, &statusErr) && statusErr.ErrStatus.Details != nil && len(statusErr.ErrStatus.Details.Causes) > 0 {
+			causes := statusErr.ErrStatus.Details.Causes
+			// The last cause is the bare policy denial message
+			message = causes[len(causes)-1].Message
+			if combined.ErrStatus.Details != nil {
+				combined.ErrStatus.Details.Causes = append(combined.ErrStatus.Details.Causes, causes...)
+			}
+		}
+		messages = append(messages, message)
+	}
+	combined.ErrStatus.Message = strings.Join(messages, "; ")
+	return combined
+}
+
+// HasSynced returns whether the evaluators of every tier have synced.
+func (v *Validator) HasSynced() bool {
+	type syncer interface {
+		HasSynced() bool
+	}
+
+	for _, t := range v.tiers {
+		if s, ok := t.Validator.(syncer); ok && !s.HasSynced() {
+			return false
+		}
+	}
+	return true
+}
+
+// Run runs the tiers' evaluators until ctx is cancelled.
+func (v *Validator) Run(ctx context.Context) error {
+	type runnable interface {
+		Run(context.Context) error
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(v.tiers))
+	for _, t := range v.tiers {
+		if r, ok := t.Validator.(runnable); ok {
+			wg.Add(1)
+			go func(priority int) {
+				defer wg.Done()
+				if err := r.Run(ctx); err != nil {
+					errs <- fmt.Errorf("priority %d: %w", priority, err)
+				}
+			}(t.Priority)
+		}
+	}
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
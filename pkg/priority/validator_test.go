@@ -0,0 +1,234 @@
+package priority
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apiserver/pkg/admission"
+)
+
+func TestParseLevels(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  []string
+		want    []Level
+		wantErr bool
+	}{
+		{
+			name:   "no priorities",
+			values: nil,
+			want:   []Level{{Priority: 0}},
+		},
+		{
+			name:   "only the default priority",
+			values: []string{"0"},
+			want:   []Level{{Priority: 0}},
+		},
+		{
+			name:   "priorities are sorted highest first",
+			values: []string{"10", " 100 ", "10"},
+			want: []Level{
+				{Priority: 100, Selector: PriorityLabel + "=100"},
+				{Priority: 10, Selector: PriorityLabel + "=10"},
+				{Priority: 0, Selector: PriorityLabel + " notin (100,10)"},
+			},
+		},
+		{
+			name:   "explicit default priority",
+			values: []string{"0", "5"},
+			want: []Level{
+				{Priority: 5, Selector: PriorityLabel + "=5"},
+				{Priority: 0, Selector: PriorityLabel + " notin (5)"},
+			},
+		},
+		{
+			name:    "negative priority",
+			values:  []string{"-1"},
+			wantErr: true,
+		},
+		{
+			name:    "not a number",
+			values:  []string{"high"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseLevels(tt.values)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseLevels() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseLevels() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// denial is a policy denial as the evaluator returns it, with the bare
+// message as its last cause.
+func denial(message string) error {
+	return &k8serrors.StatusError{ErrStatus: metav1.Status{
+		Status:  metav1.StatusFailure,
+		Code:    422,
+		Reason:  metav1.StatusReasonInvalid,
+		Message: "denied: " + message,
+		Details: &metav1.StatusDetails{Causes: []metav1.StatusCause{{Type: "FieldValueInvalid", Message: message}}},
+	}}
+}
+
+func TestAggregate(t *testing.T) {
+	tests := []struct {
+		name    string
+		denials []error
+		// want is the message of the aggregate, and causes those of its
+		// status
+		want   string
+		causes []string
+	}{
+		{
+			name: "no denials",
+		},
+		{
+			name:    "one denial",
+			denials: []error{denial("replicas must be at most 5")},
+			want:    "denied: replicas must be at most 5",
+			causes:  []string{"replicas must be at most 5"},
+		},
+		{
+			name:    "denials are appended by their bare message",
+			denials: []error{denial("replicas must be at most 5"), denial("image must be signed")},
+			want:    "denied: replicas must be at most 5; image must be signed",
+			causes:  []string{"replicas must be at most 5", "image must be signed"},
+		},
+		{
+			name:    "other errors are appended whole",
+			denials: []error{denial("replicas must be at most 5"), errors.New("params not found")},
+			want:    "denied: replicas must be at most 5; params not found",
+			causes:  []string{"replicas must be at most 5"},
+		},
+		{
+			name:    "first error without status is returned as is",
+			denials: []error{errors.New("params not found"), denial("image must be signed")},
+			want:    "params not found",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := aggregate(tt.denials)
+			if tt.want == "" {
+				if err != nil {
+					t.Fatalf("aggregate() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.want {
+				t.Fatalf("aggregate() = %v, want %q", err, tt.want)
+			}
+			var statusErr *k8serrors.StatusError
+			if !errors.As(err, &statusErr) {
+				if tt.causes != nil {
+					t.Fatalf("aggregate() = %T, want a status error", err)
+				}
+				return
+			}
+			if statusErr.ErrStatus.Code != 422 || statusErr.ErrStatus.Reason != metav1.StatusReasonInvalid {
+				t.Errorf("aggregate() status = %d %s, want the status of the first denial", statusErr.ErrStatus.Code, statusErr.ErrStatus.Reason)
+			}
+			var causes []string
+			for _, cause := range statusErr.ErrStatus.Details.Causes {
+				causes = append(causes, cause.Message)
+			}
+			if !reflect.DeepEqual(causes, tt.causes) {
+				t.Errorf("aggregate() causes = %v, want %v", causes, tt.causes)
+			}
+		})
+	}
+	// The first denial is not modified
+	first := denial("replicas must be at most 5")
+	aggregate([]error{first, denial("image must be signed")})
+	if first.Error() != "denied: replicas must be at most 5" {
+		t.Errorf("aggregate() modified the first denial: %v", first)
+	}
+}
+
+// fakeValidator denies with err, recording that it was called.
+type fakeValidator struct {
+	err    error
+	called *[]string
+	name   string
+}
+
+func (f fakeValidator) Handles(admission.Operation) bool { return true }
+
+func (f fakeValidator) Validate(context.Context, admission.Attributes, admission.ObjectInterfaces) error {
+	*f.called = append(*f.called, f.name)
+	return f.err
+}
+
+func TestValidator(t *testing.T) {
+	tests := []struct {
+		name         string
+		shortCircuit bool
+		// denied are the names of the tiers denying
+		denied     map[string]bool
+		wantCalled []string
+		wantErr    string
+	}{
+		{
+			name:       "tiers are evaluated highest first",
+			wantCalled: []string{"guard", "default", "expensive"},
+		},
+		{
+			name:       "denials are reported in priority order",
+			denied:     map[string]bool{"guard": true, "expensive": true},
+			wantCalled: []string{"guard", "default", "expensive"},
+			wantErr:    "denied: guard; expensive",
+		},
+		{
+			name:         "short-circuit stops at the first denial",
+			shortCircuit: true,
+			denied:       map[string]bool{"guard": true, "expensive": true},
+			wantCalled:   []string{"guard"},
+			wantErr:      "denied: guard",
+		},
+		{
+			name:         "short-circuit evaluates every allowing tier",
+			shortCircuit: true,
+			denied:       map[string]bool{"expensive": true},
+			wantCalled:   []string{"guard", "default", "expensive"},
+			wantErr:      "denied: expensive",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var called []string
+			tier := func(name string, priority int) Tier {
+				v := fakeValidator{called: &called, name: name}
+				if tt.denied[name] {
+					v.err = denial(name)
+				}
+				return Tier{Priority: priority, Validator: v}
+			}
+			v := NewValidator([]Tier{tier("default", 0), tier("expensive", 0), tier("guard", 100)}, tt.shortCircuit)
+
+			a := admission.NewAttributesRecord(nil, nil, schema.GroupVersionKind{}, "", "", schema.GroupVersionResource{}, "", admission.Create, nil, false, nil)
+			err := v.Validate(context.Background(), a, nil)
+			if !reflect.DeepEqual(called, tt.wantCalled) {
+				t.Errorf("Validate() called %v, want %v", called, tt.wantCalled)
+			}
+			got := ""
+			if err != nil {
+				got = err.Error()
+			}
+			if got != tt.wantErr {
+				t.Errorf("Validate() = %q, want %q", got, tt.wantErr)
+			}
+		})
+	}
+}
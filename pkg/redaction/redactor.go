@@ -0,0 +1,170 @@
+package redaction
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/client-go/util/jsonpath"
+	"sigs.k8s.io/yaml"
+)
+
+// Mask replaces redacted values.
+const Mask = "[REDACTED]"
+
+// minLength is the length under which values are not redacted, since
+// masking every occurrence of short values such as true or 1 would garble
+// messages.
+const minLength = 4
+
+// Config is the redaction configuration file.
+type Config struct {
+	Rules []Rule `json:"rules"`
+}
+
+// Rule redacts the values of fields of the objects of a kind.
+type Rule struct {
+	// Group and Kind select the objects, e.g. "" and Secret. Kind * selects
+	// every kind of the group, and group * every group.
+	Group string `json:"group"`
+	Kind  string `json:"kind"`
+
+	// Fields are JSONPath templates of the redacted values, e.g.
+	// {.spec.containers[*].env[*].value}. Every string under the selected
+	// fields is redacted.
+	Fields []string `json:"fields"`
+}
+
+func (r *Rule) matches(group, kind string) bool {
+	return (r.Group == "*" || r.Group == group) && (r.Kind == "*" || r.Kind == kind)
+}
+
+// Redactor masks the values of sensitive fields of admitted objects in the
+// logs, decision records and alerts about them, so they never leave the
+// cluster. Requesters still get them in responses.
+type Redactor struct {
+	rules []Rule
+}
+
+// Load reads and validates the redaction configuration file at path.
+func Load(path string) (*Redactor, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config Config
+	if err := yaml.UnmarshalStrict(raw, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	for i, rule := range config.Rules {
+		if rule.Kind == "" {
+			return nil, fmt.Errorf("rule %d has no kind", i)
+		}
+		if len(rule.Fields) == 0 {
+			return nil, fmt.Errorf("rule %d selects no fields", i)
+		}
+		for _, field := range rule.Fields {
+			if err := jsonpath.New("redaction").Parse(field); err != nil {
+				return nil, fmt.Errorf("rule %d: invalid field %s: %w", i, field, err)
+			}
+		}
+	}
+	return &Redactor{rules: config.Rules}, nil
+}
+
+// Func masks redacted values in s.
+type Func func(s string) string
+
+// All masks redacted values in each of values.
+func (f Func) All(values []string) []string {
+	redacted := make([]string, len(values))
+	for i, s := range values {
+		redacted[i] = f(s)
+	}
+	return redacted
+}
+
+// None redacts nothing.
+func None(s string) string {
+	return s
+}
+
+// ForRequest returns a Func masking the values of the redacted fields of
+// the object and old object of request. A nil Redactor redacts nothing.
+func (r *Redactor) ForRequest(request *admissionv1.AdmissionRequest) Func {
+	if r == nil {
+		return None
+	}
+	var fields []string
+	for i := range r.rules {
+		if r.rules[i].matches(request.Kind.Group, request.Kind.Kind) {
+			fields = append(fields, r.rules[i].Fields...)
+		}
+	}
+	if len(fields) == 0 {
+		return None
+	}
+
+	seen := map[string]bool{}
+	var values []string
+	for _, raw := range [][]byte{request.Object.Raw, request.OldObject.Raw} {
+		if len(raw) == 0 {
+			continue
+		}
+		var object map[string]interface{}
+		if err := json.Unmarshal(raw, &object); err != nil {
+			continue
+		}
+		for _, field := range fields {
+			path := jsonpath.New("redaction").AllowMissingKeys(true)
+			if err := path.Parse(field); err != nil {
+				continue
+			}
+			results, err := path.FindResults(object)
+			if err != nil {
+				continue
+			}
+			for _, result := range results {
+				for _, v := range result {
+					collect(v.Interface(), func(value string) {
+						if len(value) >= minLength && !seen[value] {
+							seen[value] = true
+							values = append(values, value)
+						}
+					})
+				}
+			}
+		}
+	}
+	if len(values) == 0 {
+		return None
+	}
+
+	// Longer values first, so a value containing another is masked whole
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	pairs := make([]string, 0, 2*len(values))
+	for _, value := range values {
+		pairs = append(pairs, value, Mask)
+	}
+	return strings.NewReplacer(pairs...).Replace
+}
+
+// collect calls add with every scalar under v.
+func collect(v interface{}, add func(string)) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for _, item := range v {
+			collect(item, add)
+		}
+	case []interface{}:
+		for _, item := range v {
+			collect(item, add)
+		}
+	case nil:
+	default:
+		add(fmt.Sprint(v))
+	}
+}
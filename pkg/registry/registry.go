@@ -0,0 +1,117 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apiserver/pkg/admission"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/kubescape/kubeenforcer/pkg/webhook"
+)
+
+// Options are passed to factories. Informers obtained from InformerFactory
+// are started by kubeenforcer.
+type Options struct {
+	KubeClient      kubernetes.Interface
+	DynamicClient   dynamic.Interface
+	InformerFactory informers.SharedInformerFactory
+	RESTMapper      meta.RESTMapper
+
+	// Config is the JSON configuration of the plugin, if any.
+	Config json.RawMessage
+}
+
+// ValidatorFactory creates a validator. Validators implementing
+// Run(context.Context) error are run until kubeenforcer stops.
+type ValidatorFactory func(opts Options) (admission.ValidationInterface, error)
+
+// MutatorFactory creates a mutator.
+type MutatorFactory func(opts Options) (webhook.Mutator, error)
+
+var (
+	lock       sync.RWMutex
+	validators = map[string]ValidatorFactory{}
+	mutators   = map[string]MutatorFactory{}
+)
+
+// RegisterValidator makes a validator available under name, for other Go
+// modules to contribute validators from an init function. It panics if name
+// is already registered.
+func RegisterValidator(name string, factory ValidatorFactory) {
+	lock.Lock()
+	defer lock.Unlock()
+	if _, ok := validators[name]; ok {
+		panic(fmt.Sprintf("registry: validator %s registered twice", name))
+	}
+	validators[name] = factory
+}
+
+// RegisterMutator makes a mutator available under name, like
+// RegisterValidator. It panics if name is already registered.
+func RegisterMutator(name string, factory MutatorFactory) {
+	lock.Lock()
+	defer lock.Unlock()
+	if _, ok := mutators[name]; ok {
+		panic(fmt.Sprintf("registry: mutator %s registered twice", name))
+	}
+	mutators[name] = factory
+}
+
+// NewValidator creates the validator registered under name.
+func NewValidator(name string, opts Options) (admission.ValidationInterface, error) {
+	lock.RLock()
+	factory, ok := validators[name]
+	lock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown validator %s, registered: %v", name, Validators())
+	}
+	v, err := factory(opts)
+	if err != nil {
+		return nil, fmt.Errorf("validator %s: %w", name, err)
+	}
+	return v, nil
+}
+
+// NewMutator creates the mutator registered under name.
+func NewMutator(name string, opts Options) (webhook.Mutator, error) {
+	lock.RLock()
+	factory, ok := mutators[name]
+	lock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown mutator %s, registered: %v", name, Mutators())
+	}
+	m, err := factory(opts)
+	if err != nil {
+		return nil, fmt.Errorf("mutator %s: %w", name, err)
+	}
+	return m, nil
+}
+
+// Validators returns the names of the registered validators, sorted.
+func Validators() []string {
+	lock.RLock()
+	defer lock.RUnlock()
+	return sortedKeys(validators)
+}
+
+// Mutators returns the names of the registered mutators, sorted.
+func Mutators() []string {
+	lock.RLock()
+	defer lock.RUnlock()
+	return sortedKeys(mutators)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,33 @@
+package remediation
+
+import (
+	listers "k8s.io/cel-admission-webhook/pkg/generated/listers/admissionregistration.x-k8s.io/v1alpha1"
+)
+
+// AutoRemediateAnnotation marks a ValidatingAdmissionPolicy whose violations
+// are fixed by the mutating webhook, rather than denied, when a remediation
+// is known for them.
+const AutoRemediateAnnotation = "kubeenforcer.kubescape.io/auto-remediate"
+
+// Policies decides which policies are auto-remediated.
+type Policies interface {
+	AutoRemediate(policy string) bool
+}
+
+// NewPolicies returns Policies auto-remediating the policies annotated with
+// AutoRemediateAnnotation set to "true".
+func NewPolicies(lister listers.ValidatingAdmissionPolicyLister) Policies {
+	return annotatedPolicies{lister: lister}
+}
+
+type annotatedPolicies struct {
+	lister listers.ValidatingAdmissionPolicyLister
+}
+
+func (p annotatedPolicies) AutoRemediate(policy string) bool {
+	vap, err := p.lister.Get(policy)
+	if err != nil {
+		return false
+	}
+	return vap.Annotations[AutoRemediateAnnotation] == "true"
+}
@@ -0,0 +1,216 @@
+package remediation
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apiserver/pkg/admission"
+)
+
+// Hint is a machine-readable fix for a policy violation.
+type Hint struct {
+	Control string `json:"control"`
+	Summary string `json:"summary"`
+
+	// Patch is a JSON patch that fixes the rejected object.
+	Patch []PatchOperation `json:"patch,omitempty"`
+
+	// Command applies Patch to the live object. Only set on updates, as
+	// there is nothing to patch before an object is created.
+	Command string `json:"command,omitempty"`
+}
+
+// PatchOperation is a single RFC 6902 JSON patch operation.
+type PatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// String renders the hint as single line JSON.
+func (h *Hint) String() string {
+	raw, err := json.Marshal(h)
+	if err != nil {
+		return ""
+	}
+	return string(raw)
+}
+
+// control builds the patch fixing a violation of a built-in control in the
+// pod spec at specPath.
+type control struct {
+	summary string
+	patch   func(spec map[string]interface{}, specPath string) []PatchOperation
+}
+
+// controls are the built-in Kubescape controls hints are known for, keyed
+// by control ID.
+var controls = map[string]control{
+	"C-0046": {
+		summary: "Remove the added capabilities from the container security context, adding back only those the workload needs.",
+		patch:   removeAddedCapabilities,
+	},
+	"C-0048": {
+		summary: "Remove the hostPath volumes and their mounts, e.g. in favour of emptyDir or a persistent volume.",
+		patch:   removeHostPathVolumes,
+	},
+	"C-0057": {
+		summary: "Run the containers unprivileged and without SYS_ADMIN.",
+		patch:   removePrivileged,
+	},
+}
+
+var controlPattern = regexp.MustCompile(`(?i)\bc-(\d{4})\b`)
+
+// For returns the hint for a violation of a built-in policy, identified by
+// the control ID in its message, or nil if none is known.
+func For(message string, attrs admission.Attributes) *Hint {
+	match := controlPattern.FindStringSubmatch(message)
+	if match == nil || attrs == nil {
+		return nil
+	}
+	id := "C-" + match[1]
+	c, ok := controls[id]
+	if !ok {
+		return nil
+	}
+
+	hint := &Hint{Control: id, Summary: c.summary}
+
+	object, specPath := podSpec(attrs.GetObject(), attrs.GetKind().Kind)
+	if object == nil {
+		return hint
+	}
+	hint.Patch = c.patch(object, specPath)
+
+	if attrs.GetOperation() == admission.Update && len(hint.Patch) > 0 && attrs.GetName() != "" {
+		raw, err := json.Marshal(hint.Patch)
+		if err == nil {
+			resource := attrs.GetResource().Resource
+			if group := attrs.GetResource().Group; group != "" {
+				resource += "." + group
+			}
+			hint.Command = fmt.Sprintf("kubectl patch %s %s", resource, attrs.GetName())
+			if namespace := attrs.GetNamespace(); namespace != "" {
+				hint.Command += " -n " + namespace
+			}
+			hint.Command += fmt.Sprintf(" --type=json -p '%s'", raw)
+		}
+	}
+	return hint
+}
+
+// podSpec returns the pod spec of a pod or workload and its JSON pointer.
+func podSpec(obj runtime.Object, kind string) (map[string]interface{}, string) {
+	if obj == nil {
+		return nil, ""
+	}
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, ""
+	}
+
+	var fields []string
+	switch kind {
+	case "Pod":
+		fields = []string{"spec"}
+	case "Deployment", "ReplicaSet", "DaemonSet", "StatefulSet", "Job":
+		fields = []string{"spec", "template", "spec"}
+	case "CronJob":
+		fields = []string{"spec", "jobTemplate", "spec", "template", "spec"}
+	default:
+		return nil, ""
+	}
+
+	spec, found, err := unstructured.NestedMap(content, fields...)
+	if !found || err != nil {
+		return nil, ""
+	}
+	return spec, "/" + strings.Join(fields, "/")
+}
+
+func containers(spec map[string]interface{}, specPath string, each func(container map[string]interface{}, path string)) {
+	for _, field := range []string{"initContainers", "containers"} {
+		list, _, _ := unstructured.NestedSlice(spec, field)
+		for i, c := range list {
+			if container, ok := c.(map[string]interface{}); ok {
+				each(container, fmt.Sprintf("%s/%s/%d", specPath, field, i))
+			}
+		}
+	}
+}
+
+func removeAddedCapabilities(spec map[string]interface{}, specPath string) []PatchOperation {
+	var patch []PatchOperation
+	containers(spec, specPath, func(container map[string]interface{}, path string) {
+		if add, _, _ := unstructured.NestedSlice(container, "securityContext", "capabilities", "add"); len(add) > 0 {
+			patch = append(patch, PatchOperation{Op: "remove", Path: path + "/securityContext/capabilities/add"})
+		}
+	})
+	return patch
+}
+
+func removePrivileged(spec map[string]interface{}, specPath string) []PatchOperation {
+	var patch []PatchOperation
+	containers(spec, specPath, func(container map[string]interface{}, path string) {
+		if privileged, _, _ := unstructured.NestedBool(container, "securityContext", "privileged"); privileged {
+			patch = append(patch, PatchOperation{Op: "replace", Path: path + "/securityContext/privileged", Value: false})
+		}
+
+		add, _, _ := unstructured.NestedStringSlice(container, "securityContext", "capabilities", "add")
+		kept := make([]string, 0, len(add))
+		for _, capability := range add {
+			if capability != "SYS_ADMIN" && capability != "CAP_SYS_ADMIN" {
+				kept = append(kept, capability)
+			}
+		}
+		if len(kept) != len(add) {
+			patch = append(patch, PatchOperation{Op: "replace", Path: path + "/securityContext/capabilities/add", Value: kept})
+		}
+	})
+	return patch
+}
+
+func removeHostPathVolumes(spec map[string]interface{}, specPath string) []PatchOperation {
+	volumes, _, _ := unstructured.NestedSlice(spec, "volumes")
+	hostPaths := map[string]bool{}
+	var volumeIndexes []int
+	for i, v := range volumes {
+		volume, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if _, ok := volume["hostPath"]; ok {
+			name, _, _ := unstructured.NestedString(volume, "name")
+			hostPaths[name] = true
+			volumeIndexes = append(volumeIndexes, i)
+		}
+	}
+	if len(volumeIndexes) == 0 {
+		return nil
+	}
+
+	// Remove from the highest index down so earlier removals do not shift
+	// the paths of later ones
+	var patch []PatchOperation
+	containers(spec, specPath, func(container map[string]interface{}, path string) {
+		mounts, _, _ := unstructured.NestedSlice(container, "volumeMounts")
+		for i := len(mounts) - 1; i >= 0; i-- {
+			mount, ok := mounts[i].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if name, _, _ := unstructured.NestedString(mount, "name"); hostPaths[name] {
+				patch = append(patch, PatchOperation{Op: "remove", Path: fmt.Sprintf("%s/volumeMounts/%d", path, i)})
+			}
+		}
+	})
+	for i := len(volumeIndexes) - 1; i >= 0; i-- {
+		patch = append(patch, PatchOperation{Op: "remove", Path: fmt.Sprintf("%s/volumes/%d", specPath, volumeIndexes[i])})
+	}
+	return patch
+}
@@ -0,0 +1,55 @@
+package requiredmetadata
+
+import (
+	"k8s.io/api/admissionregistration/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// GroupVersionResource of the MetadataRequirement CRD.
+var GroupVersionResource = schema.GroupVersionResource{
+	Group:    "kubeenforcer.kubescape.io",
+	Version:  "v1alpha1",
+	Resource: "metadatarequirements",
+}
+
+// MetadataRequirement requires the objects it matches to carry labels and
+// annotations, optionally with constrained values.
+type MetadataRequirement struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec MetadataRequirementSpec `json:"spec"`
+}
+
+type MetadataRequirementSpec struct {
+	// MatchConstraints selects the requests the requirement applies to, as
+	// in ValidatingAdmissionPolicies.
+	MatchConstraints v1alpha1.MatchResources `json:"matchConstraints"`
+
+	// Labels and Annotations are the required keys.
+	Labels      []MetadataKey `json:"labels,omitempty"`
+	Annotations []MetadataKey `json:"annotations,omitempty"`
+
+	// ValidationAction is Deny, the default, or Warn.
+	ValidationAction v1alpha1.ValidationAction `json:"validationAction,omitempty"`
+}
+
+// MetadataKey is a required label or annotation.
+type MetadataKey struct {
+	Key string `json:"key"`
+
+	// Optional only constrains the value when the key is set.
+	Optional bool `json:"optional,omitempty"`
+
+	// Pattern is a regular expression the whole value must match.
+	Pattern string `json:"pattern,omitempty"`
+
+	// Expression is a CEL expression with value, object and request in
+	// scope that must evaluate to true, e.g. value in ['dev', 'prod'].
+	Expression string `json:"expression,omitempty"`
+
+	// Message replaces the default message when the key is missing or its
+	// value is invalid.
+	Message string `json:"message,omitempty"`
+}
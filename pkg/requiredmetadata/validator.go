@@ -0,0 +1,323 @@
+package requiredmetadata
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"k8s.io/api/admissionregistration/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apiserver/pkg/admission"
+	plugincel "k8s.io/apiserver/pkg/admission/plugin/cel"
+	"k8s.io/apiserver/pkg/admission/plugin/validatingadmissionpolicy/matching"
+	celconfig "k8s.io/apiserver/pkg/apis/cel"
+	"k8s.io/apiserver/pkg/cel/library"
+	"k8s.io/apiserver/pkg/warning"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+var logger klog.Logger = klog.LoggerWithName(klog.Background(), "requiredmetadata")
+
+var (
+	envOnce sync.Once
+	env     *cel.Env
+	envErr  error
+)
+
+// getEnv returns the environment value expressions are compiled in.
+func getEnv() (*cel.Env, error) {
+	envOnce.Do(func() {
+		opts := []cel.EnvOption{
+			cel.Variable("value", cel.StringType),
+			cel.Variable("object", cel.DynType),
+			cel.Variable("request", cel.DynType),
+		}
+		env, envErr = cel.NewEnv(append(opts, library.ExtensionLibs...)...)
+	})
+	return env, envErr
+}
+
+// compiledKey is a MetadataKey with its pattern and expression compiled.
+type compiledKey struct {
+	MetadataKey
+	pattern    *regexp.Regexp
+	expression cel.Program
+}
+
+type compiledRequirement struct {
+	resourceVersion string
+	labels          []compiledKey
+	annotations     []compiledKey
+	// err is set if the requirement failed to compile.
+	err error
+}
+
+func compileKeys(keys []MetadataKey) ([]compiledKey, error) {
+	compiled := make([]compiledKey, len(keys))
+	for i, key := range keys {
+		compiled[i].MetadataKey = key
+		if key.Pattern != "" {
+			pattern, err := regexp.Compile("^(?:" + key.Pattern + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("key %s: invalid pattern: %w", key.Key, err)
+			}
+			compiled[i].pattern = pattern
+		}
+		if key.Expression != "" {
+			env, err := getEnv()
+			if err != nil {
+				return nil, err
+			}
+			ast, issues := env.Compile(key.Expression)
+			if issues != nil && issues.Err() != nil {
+				return nil, fmt.Errorf("key %s: %w", key.Key, issues.Err())
+			}
+			if compiled[i].expression, err = env.Program(ast, cel.CostLimit(celconfig.PerCallLimit)); err != nil {
+				return nil, fmt.Errorf("key %s: %w", key.Key, err)
+			}
+		}
+	}
+	return compiled, nil
+}
+
+// Validator denies, or warns on, creating or updating objects lacking the
+// labels and annotations required by the MetadataRequirements matching them,
+// or with invalid values.
+type Validator struct {
+	requirements cache.GenericLister
+	hasSynced    cache.InformerSynced
+	matcher      *matching.Matcher
+
+	lock     sync.Mutex
+	compiled map[string]*compiledRequirement
+}
+
+// New creates a Validator for the MetadataRequirements watched by informer.
+// Namespaces are looked up to evaluate namespace selectors.
+func New(informer informers.GenericInformer, namespaces corev1listers.NamespaceLister, client kubernetes.Interface) *Validator {
+	return &Validator{
+		requirements: informer.Lister(),
+		hasSynced:    informer.Informer().HasSynced,
+		matcher:      matching.NewMatcher(namespaces, client),
+		compiled:     map[string]*compiledRequirement{},
+	}
+}
+
+func (v *Validator) Handles(operation admission.Operation) bool {
+	return operation == admission.Create || operation == admission.Update
+}
+
+// Health returns an error until the MetadataRequirements are synced, and
+// warns about those that fail to compile.
+func (v *Validator) Health() ([]string, error) {
+	if !v.hasSynced() {
+		return nil, fmt.Errorf("metadata requirements are not synced yet")
+	}
+	requirements, err := v.list()
+	if err != nil {
+		return nil, err
+	}
+	var warnings []string
+	for _, requirement := range requirements {
+		if _, err := v.compile(requirement); err != nil {
+			warnings = append(warnings, fmt.Sprintf("MetadataRequirement '%s': %v", requirement.Name, err))
+		}
+	}
+	return warnings, nil
+}
+
+// list returns the MetadataRequirements sorted by name.
+func (v *Validator) list() ([]*MetadataRequirement, error) {
+	objs, err := v.requirements.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	requirements := make([]*MetadataRequirement, 0, len(objs))
+	for _, obj := range objs {
+		requirement := &MetadataRequirement{}
+		if err := fromUnstructured(obj, requirement); err != nil {
+			return nil, err
+		}
+		requirements = append(requirements, requirement)
+	}
+	sort.Slice(requirements, func(i, j int) bool { return requirements[i].Name < requirements[j].Name })
+	return requirements, nil
+}
+
+func (v *Validator) Validate(ctx context.Context, a admission.Attributes, o admission.ObjectInterfaces) error {
+	if a.GetSubresource() != "" || a.GetObject() == nil {
+		return nil
+	}
+	if !v.hasSynced() {
+		return fmt.Errorf("metadata requirements are not synced yet")
+	}
+
+	requirements, err := v.list()
+	if err != nil {
+		return err
+	}
+
+	accessor, err := meta.Accessor(a.GetObject())
+	if err != nil {
+		return err
+	}
+	vars := map[string]interface{}{}
+
+	var denials []string
+	for _, requirement := range requirements {
+		if matches, _, err := v.matcher.Matches(a, o, matchCriteria{requirement.Spec.MatchConstraints}); err != nil || !matches {
+			if err != nil {
+				return fmt.Errorf("MetadataRequirement '%s': %w", requirement.Name, err)
+			}
+			continue
+		}
+
+		compiled, err := v.compile(requirement)
+		if err != nil {
+			return fmt.Errorf("MetadataRequirement '%s': %w", requirement.Name, err)
+		}
+		if len(vars) == 0 && compiled.hasExpressions() {
+			if vars["object"], err = runtime.DefaultUnstructuredConverter.ToUnstructured(a.GetObject()); err != nil {
+				return err
+			}
+			if vars["request"], err = runtime.DefaultUnstructuredConverter.ToUnstructured(plugincel.CreateAdmissionRequest(a)); err != nil {
+				return err
+			}
+		}
+
+		var violations []string
+		violations = append(violations, check(ctx, "label", compiled.labels, accessor.GetLabels(), vars)...)
+		violations = append(violations, check(ctx, "annotation", compiled.annotations, accessor.GetAnnotations(), vars)...)
+		if len(violations) == 0 {
+			continue
+		}
+
+		message := fmt.Sprintf("MetadataRequirement '%s' failed: %s", requirement.Name, strings.Join(violations, "; "))
+		logger.V(2).Info("metadata requirement failed", "requirement", requirement.Name, "action", requirement.Spec.ValidationAction, "resource", a.GetResource().Resource, "namespace", a.GetNamespace(), "name", a.GetName())
+		if requirement.Spec.ValidationAction == v1alpha1.Warn {
+			warning.AddWarning(ctx, "", message)
+			continue
+		}
+		denials = append(denials, message)
+	}
+
+	if len(denials) == 0 {
+		return nil
+	}
+	return admission.NewForbidden(a, errors.New(strings.Join(denials, "\n")))
+}
+
+// check returns the violations of keys by values.
+func check(ctx context.Context, kind string, keys []compiledKey, values map[string]string, vars map[string]interface{}) []string {
+	var violations []string
+	violation := func(key compiledKey, format string, args ...interface{}) {
+		if key.Message != "" {
+			violations = append(violations, key.Message)
+			return
+		}
+		violations = append(violations, kind+" "+key.Key+" "+fmt.Sprintf(format, args...))
+	}
+
+	for _, key := range keys {
+		value, ok := values[key.Key]
+		if !ok {
+			if !key.Optional {
+				violation(key, "is required")
+			}
+			continue
+		}
+		if key.pattern != nil && !key.pattern.MatchString(value) {
+			violation(key, "value %q does not match %s", value, key.Pattern)
+			continue
+		}
+		if key.expression != nil {
+			vars["value"] = value
+			result, _, err := key.expression.ContextEval(ctx, vars)
+			if err != nil {
+				violation(key, "value %q could not be checked: %v", value, err)
+				continue
+			}
+			if result != types.True {
+				violation(key, "value %q does not satisfy %s", value, key.Expression)
+			}
+		}
+	}
+	return violations
+}
+
+func (c *compiledRequirement) hasExpressions() bool {
+	for _, keys := range [][]compiledKey{c.labels, c.annotations} {
+		for _, key := range keys {
+			if key.expression != nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// compile returns the compiled requirement, compiling it again only when it
+// changed.
+func (v *Validator) compile(requirement *MetadataRequirement) (*compiledRequirement, error) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if compiled, ok := v.compiled[requirement.Name]; ok && compiled.resourceVersion == requirement.ResourceVersion {
+		return compiled, compiled.err
+	}
+	compiled := &compiledRequirement{resourceVersion: requirement.ResourceVersion}
+	var err error
+	if compiled.labels, err = compileKeys(requirement.Spec.Labels); err != nil {
+		compiled.err = fmt.Errorf("labels: %w", err)
+	} else if compiled.annotations, err = compileKeys(requirement.Spec.Annotations); err != nil {
+		compiled.err = fmt.Errorf("annotations: %w", err)
+	}
+	v.compiled[requirement.Name] = compiled
+	return compiled, compiled.err
+}
+
+func fromUnstructured(obj runtime.Object, into interface{}) error {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return fmt.Errorf("unexpected object type %T", obj)
+	}
+	return runtime.DefaultUnstructuredConverter.FromUnstructured(u.UnstructuredContent(), into)
+}
+
+// matchCriteria adapts MatchResources to the admission matcher. Unset
+// selectors match everything.
+type matchCriteria struct {
+	resources v1alpha1.MatchResources
+}
+
+func (c matchCriteria) GetParsedNamespaceSelector() (labels.Selector, error) {
+	if c.resources.NamespaceSelector == nil {
+		return labels.Everything(), nil
+	}
+	return metav1.LabelSelectorAsSelector(c.resources.NamespaceSelector)
+}
+
+func (c matchCriteria) GetParsedObjectSelector() (labels.Selector, error) {
+	if c.resources.ObjectSelector == nil {
+		return labels.Everything(), nil
+	}
+	return metav1.LabelSelectorAsSelector(c.resources.ObjectSelector)
+}
+
+func (c matchCriteria) GetMatchResources() v1alpha1.MatchResources {
+	return c.resources
+}
@@ -0,0 +1,127 @@
+package schemavalidation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apiserver/pkg/admission"
+	"k8s.io/apiserver/pkg/cel/openapi/resolver"
+	"k8s.io/apiserver/pkg/warning"
+	"k8s.io/klog/v2"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+	"k8s.io/kube-openapi/pkg/validation/strfmt"
+	"k8s.io/kube-openapi/pkg/validation/validate"
+)
+
+var logger klog.Logger = klog.LoggerWithName(klog.Background(), "schemavalidation")
+
+// Mode is how objects violating their schema are handled.
+type Mode string
+
+const (
+	Deny Mode = "Deny"
+	Warn Mode = "Warn"
+)
+
+// ParseMode parses Deny or Warn.
+func ParseMode(s string) (Mode, error) {
+	switch mode := Mode(s); mode {
+	case Deny, Warn:
+		return mode, nil
+	}
+	return "", fmt.Errorf("invalid mode %q, expected Deny or Warn", s)
+}
+
+// maxViolations is the number of violations listed in a denial.
+const maxViolations = 10
+
+// Validator denies, or warns on, custom resources that do not match the
+// OpenAPI schema published for their kind, before policies evaluate them:
+// CEL expressions of policies written against the schema would otherwise
+// fail or misbehave on malformed objects. Only objects decoded as
+// unstructured, i.e. kinds missing from the scheme, are validated; their
+// metadata is left to the API server.
+type Validator struct {
+	mode     Mode
+	resolver resolver.SchemaResolver
+}
+
+// New creates a Validator resolving schemas with schemaResolver.
+func New(mode Mode, schemaResolver resolver.SchemaResolver) *Validator {
+	return &Validator{mode: mode, resolver: schemaResolver}
+}
+
+func (v *Validator) Handles(operation admission.Operation) bool {
+	return operation == admission.Create || operation == admission.Update
+}
+
+func (v *Validator) Validate(ctx context.Context, a admission.Attributes, o admission.ObjectInterfaces) error {
+	if a.GetSubresource() != "" {
+		return nil
+	}
+	object, ok := a.GetObject().(*unstructured.Unstructured)
+	if !ok || object == nil {
+		return nil
+	}
+
+	kind := object.GroupVersionKind()
+	s, err := v.resolver.ResolveSchema(kind)
+	if err != nil {
+		// Kinds without a published schema are not validated
+		logger.V(4).Info("not validating object without schema", "kind", kind, "err", err)
+		return nil
+	}
+
+	violations := schemaViolations(s, object)
+	if len(violations) == 0 {
+		return nil
+	}
+	if len(violations) > maxViolations {
+		violations = append(violations[:maxViolations], fmt.Sprintf("and %d more", len(violations)-maxViolations))
+	}
+	message := fmt.Sprintf("%s does not match its schema: %s", kind.Kind, strings.Join(violations, "; "))
+	logger.V(2).Info("object does not match its schema", "mode", v.mode, "kind", kind, "namespace", a.GetNamespace(), "name", a.GetName(), "violations", len(violations))
+	if v.mode == Warn {
+		warning.AddWarning(ctx, "", message)
+		return nil
+	}
+	return admission.NewForbidden(a, errors.New(message))
+}
+
+// schemaViolations returns the sorted violations of the schema s by object,
+// ignoring its metadata.
+func schemaViolations(s *spec.Schema, object *unstructured.Unstructured) []string {
+	root := *s
+	if _, ok := root.Properties["metadata"]; ok {
+		root.Properties = make(map[string]spec.Schema, len(s.Properties))
+		for name, property := range s.Properties {
+			if name != "metadata" {
+				root.Properties[name] = property
+			}
+		}
+		root.Required = nil
+		for _, name := range s.Required {
+			if name != "metadata" {
+				root.Required = append(root.Required, name)
+			}
+		}
+	}
+	content := make(map[string]interface{}, len(object.Object))
+	for name, value := range object.Object {
+		if name != "metadata" {
+			content[name] = value
+		}
+	}
+
+	result := validate.NewSchemaValidator(&root, nil, "", strfmt.Default).Validate(content)
+	var violations []string
+	for _, err := range result.Errors {
+		violations = append(violations, err.Error())
+	}
+	sort.Strings(violations)
+	return violations
+}
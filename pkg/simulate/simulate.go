@@ -0,0 +1,631 @@
+package simulate
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	nativev1alpha1 "k8s.io/api/admissionregistration/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apiserver/pkg/admission"
+	plugincel "k8s.io/apiserver/pkg/admission/plugin/cel"
+	"k8s.io/apiserver/pkg/admission/plugin/validatingadmissionpolicy"
+	"k8s.io/apiserver/pkg/admission/plugin/validatingadmissionpolicy/matching"
+	"k8s.io/apiserver/pkg/admission/plugin/webhook/matchconditions"
+	celconfig "k8s.io/apiserver/pkg/apis/cel"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	clientsetscheme "k8s.io/client-go/kubernetes/scheme"
+	listersv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	"k8s.io/cel-admission-webhook/pkg/apis/admissionregistration.x-k8s.io/v1alpha1"
+	controller "k8s.io/cel-admission-webhook/pkg/controller/admissionregistration.x-k8s.io/v1alpha1"
+	"k8s.io/cel-admission-webhook/pkg/generated/clientset/versioned"
+
+	"github.com/kubescape/kubeenforcer/pkg/variables"
+)
+
+var logger klog.Logger = klog.LoggerWithName(klog.Background(), "simulate")
+
+// pageSize is the number of objects listed per request.
+const pageSize = 500
+
+// Options restricts the simulation.
+type Options struct {
+	// Namespace restricts the objects evaluated to a namespace. All
+	// namespaces and cluster-scoped objects if empty.
+	Namespace string
+	// OwnerLabel is the label naming the owner of objects, e.g. a team,
+	// read from the workload of violating objects, or else their
+	// namespace. Owners are not reported if empty.
+	OwnerLabel string
+}
+
+// maxOwnerDepth bounds the controller owner references followed to the
+// workload of an object.
+const maxOwnerDepth = 5
+
+// Violation is an existing object a policy would act on.
+type Violation struct {
+	Policy    string   `json:"policy"`
+	Binding   string   `json:"binding,omitempty"`
+	Actions   []string `json:"actions"`
+	Resource  string   `json:"resource"`
+	Namespace string   `json:"namespace,omitempty"`
+	Name      string   `json:"name"`
+	// Workload is the kind and name of the top controller of the object,
+	// e.g. Deployment/web for its pods, or of the object itself.
+	Workload string `json:"workload"`
+	// Owner is the value of the owner label of the workload or namespace.
+	Owner   string `json:"owner,omitempty"`
+	Message string `json:"message"`
+	// Error is set if the policy failed to evaluate and its failure policy
+	// rejects the object.
+	Error bool `json:"error,omitempty"`
+}
+
+// Denied returns whether the violation would deny the object.
+func (v Violation) Denied() bool {
+	for _, action := range v.Actions {
+		if action == string(v1alpha1.Deny) {
+			return true
+		}
+	}
+	return false
+}
+
+// Report is the outcome of a simulation.
+type Report struct {
+	// Objects is the number of existing objects matched by the policies.
+	Objects int `json:"objects"`
+	// PolicyObjects is the number of existing objects matched by each
+	// policy, by name.
+	PolicyObjects map[string]int `json:"policyObjects"`
+	Violations    []Violation    `json:"violations"`
+}
+
+// Denied returns the number of objects that would be denied.
+func (r *Report) Denied() int {
+	denied := map[string]bool{}
+	for _, v := range r.Violations {
+		if v.Denied() {
+			denied[v.Resource+"/"+v.Namespace+"/"+v.Name] = true
+		}
+	}
+	return len(denied)
+}
+
+// Simulator evaluates policies that are not applied yet against the objects
+// that exist in a cluster, to report which of them the policies would deny
+// if they were enforced. Objects are evaluated as if they were created, or
+// updated without changes for policies that only match updates, by an
+// anonymous user.
+type Simulator struct {
+	client   kubernetes.Interface
+	policies versioned.Interface
+	dynamic  dynamic.Interface
+	compiler plugincel.FilterCompiler
+}
+
+// New creates a Simulator reading the cluster through the given clients.
+func New(client kubernetes.Interface, policyClient versioned.Interface, dynamicClient dynamic.Interface) *Simulator {
+	return &Simulator{
+		client:   client,
+		policies: policyClient,
+		dynamic:  dynamicClient,
+		compiler: plugincel.NewFilterCompiler(),
+	}
+}
+
+// simulation holds the state of a single Run.
+type simulation struct {
+	*Simulator
+	opts       Options
+	resources  []*restmapper.APIGroupResources
+	restMapper meta.RESTMapper
+	matcher    validatingadmissionpolicy.Matcher
+	namespaces listersv1.NamespaceLister
+	o          admission.ObjectInterfaces
+	params     map[string]runtime.Object
+	// owners caches the controllers of objects by namespace, kind and name.
+	owners map[string]*unstructured.Unstructured
+}
+
+// boundPolicy is a policy with its bindings and compiled validator.
+type boundPolicy struct {
+	policy    *nativev1alpha1.ValidatingAdmissionPolicy
+	bindings  []*nativev1alpha1.ValidatingAdmissionPolicyBinding
+	validator validatingadmissionpolicy.Validator
+	operation admission.Operation
+}
+
+// Run evaluates policies against the existing objects they match. Policies
+// are bound by the bindings given for them, or else by those already in the
+// cluster, or else by an implicit binding denying in every namespace.
+func (s *Simulator) Run(ctx context.Context, policies []*v1alpha1.ValidatingAdmissionPolicy, bindings []*v1alpha1.ValidatingAdmissionPolicyBinding, opts Options) (*Report, error) {
+	sim := &simulation{Simulator: s, opts: opts, o: admission.NewObjectInterfacesFromScheme(clientsetscheme.Scheme), params: map[string]runtime.Object{}, owners: map[string]*unstructured.Unstructured{}}
+	var err error
+	if sim.resources, err = restmapper.GetAPIGroupResources(s.client.Discovery()); err != nil {
+		return nil, fmt.Errorf("failed to discover resources: %w", err)
+	}
+	sim.restMapper = restmapper.NewDiscoveryRESTMapper(sim.resources)
+	if sim.namespaces, err = sim.namespaceLister(ctx); err != nil {
+		return nil, err
+	}
+	sim.matcher = validatingadmissionpolicy.NewMatcher(matching.NewMatcher(sim.namespaces, s.client))
+
+	bound, err := sim.bind(ctx, policies, bindings)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{PolicyObjects: map[string]int{}, Violations: []Violation{}}
+	for _, resource := range sim.matchedResources(bound) {
+		if err := sim.evaluateResource(ctx, resource, bound, report); err != nil {
+			return nil, fmt.Errorf("failed to evaluate %s: %w", resource.String(), err)
+		}
+	}
+	sort.SliceStable(report.Violations, func(i, j int) bool {
+		a, b := report.Violations[i], report.Violations[j]
+		if a.Resource != b.Resource {
+			return a.Resource < b.Resource
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+	return report, nil
+}
+
+// namespaceLister returns a lister of the namespaces of the cluster, for
+// namespace selectors.
+func (sim *simulation) namespaceLister(ctx context.Context) (listersv1.NamespaceLister, error) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	list, err := sim.client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list namespaces: %w", err)
+	}
+	for i := range list.Items {
+		indexer.Add(&list.Items[i])
+	}
+	return listersv1.NewNamespaceLister(indexer), nil
+}
+
+// bind converts policies and bindings to the types the matcher and the
+// validator evaluate, and compiles the policies.
+func (sim *simulation) bind(ctx context.Context, policies []*v1alpha1.ValidatingAdmissionPolicy, bindings []*v1alpha1.ValidatingAdmissionPolicyBinding) ([]*boundPolicy, error) {
+	byPolicy := map[string][]*v1alpha1.ValidatingAdmissionPolicyBinding{}
+	for _, binding := range bindings {
+		byPolicy[binding.Spec.PolicyName] = append(byPolicy[binding.Spec.PolicyName], binding)
+	}
+
+	var existing []v1alpha1.ValidatingAdmissionPolicyBinding
+	listed := false
+	for _, policy := range policies {
+		if len(byPolicy[policy.Name]) > 0 {
+			continue
+		}
+		if !listed {
+			list, err := sim.policies.AdmissionregistrationV1alpha1().ValidatingAdmissionPolicyBindings().List(ctx, metav1.ListOptions{})
+			if err != nil {
+				return nil, fmt.Errorf("failed to list bindings: %w", err)
+			}
+			existing, listed = list.Items, true
+		}
+		for i := range existing {
+			if existing[i].Spec.PolicyName == policy.Name {
+				byPolicy[policy.Name] = append(byPolicy[policy.Name], &existing[i])
+			}
+		}
+		if len(byPolicy[policy.Name]) == 0 {
+			if policy.Spec.ParamKind != nil {
+				return nil, fmt.Errorf("policy %s has a paramKind but no binding", policy.Name)
+			}
+			logger.V(2).Info("simulating policy without binding as denying in every namespace", "policy", policy.Name)
+			byPolicy[policy.Name] = []*v1alpha1.ValidatingAdmissionPolicyBinding{{
+				Spec: v1alpha1.ValidatingAdmissionPolicyBindingSpec{
+					PolicyName:        policy.Name,
+					ValidationActions: []v1alpha1.ValidationAction{v1alpha1.Deny},
+				},
+			}}
+		}
+	}
+
+	var bound []*boundPolicy
+	for _, policy := range policies {
+		native, err := controller.CRDToNativePolicy(policy)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert policy %s: %w", policy.Name, err)
+		}
+		native, err = variables.ExpandPolicy(native)
+		if err != nil {
+			return nil, fmt.Errorf("failed to expand variables of policy %s: %w", policy.Name, err)
+		}
+		if native.Spec.MatchConstraints == nil {
+			// Policies without match constraints match nothing
+			continue
+		}
+		defaultMatchResources(native.Spec.MatchConstraints)
+		b := &boundPolicy{policy: native, validator: sim.compile(native), operation: simulatedOperation(native.Spec.MatchConstraints.ResourceRules)}
+		if b.operation == "" {
+			logger.Info("skipping policy matching neither creates nor updates", "policy", policy.Name)
+			continue
+		}
+		for _, binding := range byPolicy[policy.Name] {
+			nativeBinding, err := controller.CRDToNativePolicyBinding(binding)
+			if err != nil {
+				return nil, fmt.Errorf("failed to convert binding %s: %w", binding.Name, err)
+			}
+			if nativeBinding.Spec.MatchResources != nil {
+				defaultMatchResources(nativeBinding.Spec.MatchResources)
+			}
+			b.bindings = append(b.bindings, nativeBinding)
+		}
+		bound = append(bound, b)
+	}
+	return bound, nil
+}
+
+// defaultMatchResources sets the defaults the API server would set on match
+// resources read from manifests: unset selectors match everything rather
+// than nothing.
+func defaultMatchResources(m *nativev1alpha1.MatchResources) {
+	if m.NamespaceSelector == nil {
+		m.NamespaceSelector = &metav1.LabelSelector{}
+	}
+	if m.ObjectSelector == nil {
+		m.ObjectSelector = &metav1.LabelSelector{}
+	}
+	if m.MatchPolicy == nil {
+		equivalent := nativev1alpha1.Equivalent
+		m.MatchPolicy = &equivalent
+	}
+	for _, rules := range [][]nativev1alpha1.NamedRuleWithOperations{m.ResourceRules, m.ExcludeResourceRules} {
+		for i := range rules {
+			if rules[i].Scope == nil {
+				scope := admissionregistrationv1.AllScopes
+				rules[i].Scope = &scope
+			}
+		}
+	}
+}
+
+// compile compiles the validator of policy as the API server does.
+func (sim *simulation) compile(policy *nativev1alpha1.ValidatingAdmissionPolicy) validatingadmissionpolicy.Validator {
+	hasParams := policy.Spec.ParamKind != nil
+	optionalVars := plugincel.OptionalVariableDeclarations{HasParams: hasParams, HasAuthorizer: true}
+	expressionOptionalVars := plugincel.OptionalVariableDeclarations{HasParams: hasParams}
+	var failurePolicy *admissionregistrationv1.FailurePolicyType
+	if policy.Spec.FailurePolicy != nil {
+		f := admissionregistrationv1.FailurePolicyType(*policy.Spec.FailurePolicy)
+		failurePolicy = &f
+	}
+
+	var matcher matchconditions.Matcher
+	if len(policy.Spec.MatchConditions) > 0 {
+		accessors := make([]plugincel.ExpressionAccessor, len(policy.Spec.MatchConditions))
+		for i := range policy.Spec.MatchConditions {
+			accessors[i] = (*matchconditions.MatchCondition)(&policy.Spec.MatchConditions[i])
+		}
+		matcher = matchconditions.NewMatcher(sim.compiler.Compile(accessors, optionalVars, celconfig.PerCallLimit), nil, failurePolicy, "validatingadmissionpolicy", policy.Name)
+	}
+
+	validations := make([]plugincel.ExpressionAccessor, len(policy.Spec.Validations))
+	messages := make([]plugincel.ExpressionAccessor, len(policy.Spec.Validations))
+	for i, v := range policy.Spec.Validations {
+		validations[i] = &validatingadmissionpolicy.ValidationCondition{Expression: v.Expression, Message: v.Message, Reason: v.Reason}
+		if v.MessageExpression != "" {
+			messages[i] = &validatingadmissionpolicy.MessageExpressionCondition{MessageExpression: v.MessageExpression}
+		}
+	}
+	auditAnnotations := make([]plugincel.ExpressionAccessor, len(policy.Spec.AuditAnnotations))
+	for i, a := range policy.Spec.AuditAnnotations {
+		auditAnnotations[i] = &validatingadmissionpolicy.AuditAnnotationCondition{Key: a.Key, ValueExpression: a.ValueExpression}
+	}
+
+	return validatingadmissionpolicy.NewValidator(
+		sim.compiler.Compile(validations, optionalVars, celconfig.PerCallLimit),
+		matcher,
+		sim.compiler.Compile(auditAnnotations, optionalVars, celconfig.PerCallLimit),
+		sim.compiler.Compile(messages, expressionOptionalVars, celconfig.PerCallLimit),
+		failurePolicy,
+		nil,
+	)
+}
+
+// simulatedOperation returns the operation existing objects are evaluated
+// as: a create, or an update if the rules only match updates.
+func simulatedOperation(rules []nativev1alpha1.NamedRuleWithOperations) admission.Operation {
+	var update bool
+	for _, rule := range rules {
+		for _, op := range rule.Operations {
+			switch op {
+			case admissionregistrationv1.Create, admissionregistrationv1.OperationAll:
+				return admission.Create
+			case admissionregistrationv1.Update:
+				update = true
+			}
+		}
+	}
+	if update {
+		return admission.Update
+	}
+	return ""
+}
+
+// matchedResources returns the listable resources that the rules of the
+// policies may match, each in its preferred version if matched, so objects
+// are evaluated once.
+func (sim *simulation) matchedResources(bound []*boundPolicy) []schema.GroupVersionResource {
+	var matched []schema.GroupVersionResource
+	for _, group := range sim.resources {
+		versions := []string{group.Group.PreferredVersion.Version}
+		for _, version := range group.Group.Versions {
+			if version.Version != group.Group.PreferredVersion.Version {
+				versions = append(versions, version.Version)
+			}
+		}
+		seen := map[string]bool{}
+		for _, version := range versions {
+			for _, resource := range group.VersionedResources[version] {
+				if seen[resource.Name] || strings.Contains(resource.Name, "/") || !hasVerb(resource.Verbs, "list") {
+					continue
+				}
+				gvr := schema.GroupVersionResource{Group: group.Group.Name, Version: version, Resource: resource.Name}
+				for _, b := range bound {
+					if rulesMatch(b.policy.Spec.MatchConstraints.ResourceRules, gvr) {
+						seen[resource.Name] = true
+						matched = append(matched, gvr)
+						break
+					}
+				}
+			}
+		}
+	}
+	return matched
+}
+
+func rulesMatch(rules []nativev1alpha1.NamedRuleWithOperations, gvr schema.GroupVersionResource) bool {
+	for _, rule := range rules {
+		if matchesAny(rule.APIGroups, gvr.Group) && matchesAny(rule.APIVersions, gvr.Version) && (matchesAny(rule.Resources, gvr.Resource) || matchesAny(rule.Resources, "*/*")) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesAny(values []string, value string) bool {
+	for _, v := range values {
+		if v == value || v == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func hasVerb(verbs metav1.Verbs, verb string) bool {
+	for _, v := range verbs {
+		if v == verb {
+			return true
+		}
+	}
+	return false
+}
+
+// evaluateResource lists the objects of resource, page by page, and
+// evaluates the policies against each of them.
+func (sim *simulation) evaluateResource(ctx context.Context, gvr schema.GroupVersionResource, bound []*boundPolicy, report *Report) error {
+	var client dynamic.ResourceInterface = sim.dynamic.Resource(gvr)
+	if sim.opts.Namespace != "" {
+		mapping, err := sim.restMapper.RESTMapping(schema.GroupKind{Group: gvr.Group, Kind: sim.kind(gvr)}, gvr.Version)
+		if err != nil {
+			return err
+		}
+		if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+			return nil
+		}
+		client = sim.dynamic.Resource(gvr).Namespace(sim.opts.Namespace)
+	}
+
+	options := metav1.ListOptions{Limit: pageSize}
+	for {
+		list, err := client.List(ctx, options)
+		if err != nil {
+			return err
+		}
+		for i := range list.Items {
+			sim.evaluate(ctx, gvr, &list.Items[i], bound, report)
+		}
+		if options.Continue = list.GetContinue(); options.Continue == "" {
+			return nil
+		}
+	}
+}
+
+// kind returns the kind of resource, as discovered.
+func (sim *simulation) kind(gvr schema.GroupVersionResource) string {
+	for _, group := range sim.resources {
+		if group.Group.Name != gvr.Group {
+			continue
+		}
+		for _, resource := range group.VersionedResources[gvr.Version] {
+			if resource.Name == gvr.Resource {
+				return resource.Kind
+			}
+		}
+	}
+	return ""
+}
+
+// evaluate evaluates the policies against obj and adds their violations to
+// report.
+func (sim *simulation) evaluate(ctx context.Context, gvr schema.GroupVersionResource, obj *unstructured.Unstructured, bound []*boundPolicy, report *Report) {
+	counted := false
+	var workload *unstructured.Unstructured
+	for _, b := range bound {
+		var oldObj runtime.Object
+		if b.operation == admission.Update {
+			oldObj = obj
+		}
+		attrs := admission.NewAttributesRecord(obj, oldObj, obj.GroupVersionKind(), obj.GetNamespace(), obj.GetName(), gvr, "", b.operation, nil, false, &user.DefaultInfo{})
+		matches, gvk, err := sim.matcher.DefinitionMatches(attrs, sim.o, b.policy)
+		if err != nil {
+			logger.Error(err, "failed to match policy", "policy", b.policy.Name, "resource", gvr.String(), "namespace", obj.GetNamespace(), "name", obj.GetName())
+			continue
+		}
+		if !matches {
+			continue
+		}
+		if !counted {
+			report.Objects++
+			counted = true
+		}
+		report.PolicyObjects[b.policy.Name]++
+		versionedAttr, err := admission.NewVersionedAttributes(attrs, gvk, sim.o)
+		if err != nil {
+			logger.Error(err, "failed to convert object", "policy", b.policy.Name, "kind", gvk.String())
+			continue
+		}
+
+		for _, binding := range b.bindings {
+			if matches, err := sim.matcher.BindingMatches(attrs, sim.o, binding); err != nil || !matches {
+				continue
+			}
+			violation := Violation{
+				Policy:    b.policy.Name,
+				Binding:   binding.Name,
+				Resource:  gvr.GroupResource().String(),
+				Namespace: obj.GetNamespace(),
+				Name:      obj.GetName(),
+			}
+			if workload == nil {
+				workload = sim.workload(ctx, obj)
+			}
+			violation.Workload = workload.GetKind() + "/" + workload.GetName()
+			violation.Owner = sim.owner(workload)
+			for _, action := range binding.Spec.ValidationActions {
+				violation.Actions = append(violation.Actions, string(action))
+			}
+
+			var params runtime.Object
+			if b.policy.Spec.ParamKind != nil && binding.Spec.ParamRef != nil {
+				if params, err = sim.param(ctx, b.policy.Spec.ParamKind, binding.Spec.ParamRef); err != nil {
+					violation.Message = fmt.Sprintf("failed to get params: %v", err)
+					violation.Error = true
+					report.Violations = append(report.Violations, violation)
+					continue
+				}
+			}
+			result := b.validator.Validate(ctx, versionedAttr, params, celconfig.RuntimeCELCostBudget)
+			for _, decision := range result.Decisions {
+				if decision.Action != validatingadmissionpolicy.ActionDeny {
+					continue
+				}
+				v := violation
+				v.Message = decision.Message
+				v.Error = decision.Evaluation == validatingadmissionpolicy.EvalError
+				report.Violations = append(report.Violations, v)
+			}
+		}
+	}
+}
+
+// param gets the params object referenced by a binding, cached for the
+// simulation.
+func (sim *simulation) param(ctx context.Context, kind *nativev1alpha1.ParamKind, ref *nativev1alpha1.ParamRef) (runtime.Object, error) {
+	key := kind.APIVersion + "/" + kind.Kind + "/" + ref.Namespace + "/" + ref.Name
+	if params, ok := sim.params[key]; ok {
+		return params, nil
+	}
+	gv, err := schema.ParseGroupVersion(kind.APIVersion)
+	if err != nil {
+		return nil, err
+	}
+	mapping, err := sim.restMapper.RESTMapping(schema.GroupKind{Group: gv.Group, Kind: kind.Kind}, gv.Version)
+	if err != nil {
+		return nil, err
+	}
+	var client dynamic.ResourceInterface = sim.dynamic.Resource(mapping.Resource)
+	if ref.Namespace != "" {
+		client = sim.dynamic.Resource(mapping.Resource).Namespace(ref.Namespace)
+	}
+	params, err := client.Get(ctx, ref.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	sim.params[key] = params
+	return params, nil
+}
+
+// workload returns the top controller of obj, following its controller
+// owner references, or obj itself if it has no controller.
+func (sim *simulation) workload(ctx context.Context, obj *unstructured.Unstructured) *unstructured.Unstructured {
+	for depth := 0; depth < maxOwnerDepth; depth++ {
+		ref := metav1.GetControllerOfNoCopy(obj)
+		if ref == nil {
+			break
+		}
+		owner, err := sim.controller(ctx, obj.GetNamespace(), ref)
+		if err != nil {
+			logger.V(2).Info("failed to get controller", "namespace", obj.GetNamespace(), "kind", ref.Kind, "name", ref.Name, "err", err)
+			break
+		}
+		obj = owner
+	}
+	return obj
+}
+
+// controller gets the object referenced by ref, cached for the simulation.
+func (sim *simulation) controller(ctx context.Context, namespace string, ref *metav1.OwnerReference) (*unstructured.Unstructured, error) {
+	key := namespace + "/" + ref.APIVersion + "/" + ref.Kind + "/" + ref.Name
+	if owner, ok := sim.owners[key]; ok {
+		return owner, nil
+	}
+	gv, err := schema.ParseGroupVersion(ref.APIVersion)
+	if err != nil {
+		return nil, err
+	}
+	mapping, err := sim.restMapper.RESTMapping(schema.GroupKind{Group: gv.Group, Kind: ref.Kind}, gv.Version)
+	if err != nil {
+		return nil, err
+	}
+	var client dynamic.ResourceInterface = sim.dynamic.Resource(mapping.Resource)
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+		client = sim.dynamic.Resource(mapping.Resource).Namespace(namespace)
+	}
+	owner, err := client.Get(ctx, ref.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	sim.owners[key] = owner
+	return owner, nil
+}
+
+// owner returns the owner label of workload, or else of its namespace.
+func (sim *simulation) owner(workload *unstructured.Unstructured) string {
+	if sim.opts.OwnerLabel == "" {
+		return ""
+	}
+	if owner, ok := workload.GetLabels()[sim.opts.OwnerLabel]; ok {
+		return owner
+	}
+	if workload.GetNamespace() == "" {
+		return ""
+	}
+	namespace, err := sim.namespaces.Get(workload.GetNamespace())
+	if err != nil {
+		return ""
+	}
+	return namespace.Labels[sim.opts.OwnerLabel]
+}
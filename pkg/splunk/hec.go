@@ -0,0 +1,295 @@
+package splunk
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/klog/v2"
+
+	"github.com/kubescape/kubeenforcer/pkg/alertmanager"
+	"github.com/kubescape/kubeenforcer/pkg/decision"
+	"github.com/kubescape/kubeenforcer/pkg/metrics"
+)
+
+var logger klog.Logger = klog.LoggerWithName(klog.Background(), "splunk")
+
+var droppedEvents = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Name:      "splunk_hec_events_dropped_total",
+	Help:      "Decisions and alerts not sent to the Splunk HTTP Event Collector, because it could not keep up or kept failing.",
+})
+
+func init() {
+	metrics.Registry.MustRegister(droppedEvents)
+}
+
+const (
+	bufferSize    = 2000
+	batchSize     = 100
+	flushInterval = time.Second
+	sendTimeout   = 10 * time.Second
+
+	// A batch is sent up to maxAttempts times, waiting from minBackoff,
+	// doubled after every attempt, up to maxBackoff between attempts.
+	maxAttempts = 5
+	minBackoff  = time.Second
+	maxBackoff  = 16 * time.Second
+)
+
+// Options configures the events sent to the HTTP Event Collector.
+type Options struct {
+	// Index is the index events are written to. The default index of the
+	// token if empty.
+	Index string
+	// DecisionSourceType and AlertSourceType are the source types of
+	// decisions and alerts.
+	DecisionSourceType string
+	AlertSourceType    string
+	// Host is the host field of events, e.g. the pod name.
+	Host string
+	// AllDecisions sends allowed decisions without actions too, rather than
+	// only the denied, audited and warned ones.
+	AllDecisions bool
+}
+
+// event is an event of the HEC event endpoint.
+type event struct {
+	Time       float64     `json:"time"`
+	Host       string      `json:"host,omitempty"`
+	Source     string      `json:"source"`
+	SourceType string      `json:"sourcetype"`
+	Index      string      `json:"index,omitempty"`
+	Event      interface{} `json:"event"`
+}
+
+// alert is the event of an alert.
+type alert struct {
+	Name           string `json:"name"`
+	Severity       string `json:"severity"`
+	Resource       string `json:"resource,omitempty"`
+	Instance       string `json:"instance,omitempty"`
+	Namespace      string `json:"namespace,omitempty"`
+	RequestingUser string `json:"requestingUser,omitempty"`
+	Description    string `json:"description"`
+	Remediation    string `json:"remediation,omitempty"`
+	Policy         string `json:"policy,omitempty"`
+	Workload       string `json:"workload,omitempty"`
+	DecisionID     string `json:"decisionID,omitempty"`
+	Fingerprint    string `json:"fingerprint"`
+
+	GitOpsApplication string `json:"gitopsApplication,omitempty"`
+	GitOpsRevision    string `json:"gitopsRevision,omitempty"`
+}
+
+// HEC sends decisions and alerts to a Splunk HTTP Event Collector, as JSON
+// events with the source kubeenforcer. It is a decision.Sink and an
+// alertmanager.Forwarder; events are buffered and sent in batches, retried
+// with backoff while the collector is unavailable, and dropped if it cannot
+// keep up so admission is never slowed down.
+type HEC struct {
+	url    string
+	token  string
+	opts   Options
+	client *http.Client
+
+	buffer  chan *event
+	dropped atomic.Int64
+}
+
+// New creates an HEC posting to the collector at url, e.g.
+// https://splunk:8088, with client, authenticating with token.
+func New(url, token string, opts Options, client *http.Client) *HEC {
+	c := *client
+	c.Timeout = sendTimeout
+	url = strings.TrimSuffix(url, "/")
+	if !strings.HasSuffix(url, "/services/collector/event") {
+		url += "/services/collector/event"
+	}
+	return &HEC{
+		url:    url,
+		token:  token,
+		opts:   opts,
+		client: &c,
+		buffer: make(chan *event, bufferSize),
+	}
+}
+
+func (h *HEC) Record(d *decision.Decision) {
+	if !h.opts.AllDecisions && d.Allowed && len(d.Actions) == 0 {
+		return
+	}
+	h.enqueue(h.event(d.Time, h.opts.DecisionSourceType, d))
+}
+
+func (h *HEC) Forward(alertInfo *alertmanager.AlertInfo, fingerprint string) {
+	h.enqueue(h.event(time.Now(), h.opts.AlertSourceType, &alert{
+		Name:           alertInfo.Name,
+		Severity:       alertInfo.Severity,
+		Resource:       alertInfo.Resource,
+		Instance:       alertInfo.Instance,
+		Namespace:      alertInfo.Namespace,
+		RequestingUser: alertInfo.RequestingUser,
+		Description:    alertInfo.Description,
+		Remediation:    alertInfo.Remediation,
+		Policy:         alertInfo.Policy,
+		Workload:       alertInfo.Workload,
+		DecisionID:     alertInfo.DecisionID,
+		Fingerprint:    fingerprint,
+
+		GitOpsApplication: alertInfo.GitOpsApplication,
+		GitOpsRevision:    alertInfo.GitOpsRevision,
+	}))
+}
+
+func (h *HEC) event(t time.Time, sourceType string, payload interface{}) *event {
+	return &event{
+		Time:       float64(t.UnixMilli()) / 1000,
+		Host:       h.opts.Host,
+		Source:     "kubeenforcer",
+		SourceType: sourceType,
+		Index:      h.opts.Index,
+		Event:      payload,
+	}
+}
+
+func (h *HEC) enqueue(e *event) {
+	select {
+	case h.buffer <- e:
+	default:
+		h.dropped.Add(1)
+		droppedEvents.Inc()
+	}
+}
+
+// Run sends buffered events until ctx is cancelled, then tries once to send
+// the events left.
+func (h *HEC) Run(ctx context.Context) error {
+	logger.Info("sending decisions and alerts to the Splunk HTTP Event Collector", "url", h.url, "index", h.opts.Index)
+	defer logger.Info("stopped sending to the Splunk HTTP Event Collector")
+
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	var batch []*event
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := h.sendWithRetry(ctx, batch); err != nil && ctx.Err() == nil {
+			droppedEvents.Add(float64(len(batch)))
+			logger.Error(err, "failed to send events to the Splunk HTTP Event Collector", "count", len(batch))
+		}
+		batch = batch[:0]
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			for len(h.buffer) > 0 {
+				batch = append(batch, <-h.buffer)
+			}
+			if len(batch) > 0 {
+				final, cancel := context.WithTimeout(context.Background(), sendTimeout)
+				if err := h.send(final, batch); err != nil {
+					logger.Error(err, "failed to send the last events to the Splunk HTTP Event Collector", "count", len(batch))
+				}
+				cancel()
+			}
+			return nil
+		case e := <-h.buffer:
+			batch = append(batch, e)
+			if len(batch) >= batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			if dropped := h.dropped.Swap(0); dropped > 0 {
+				logger.Info("dropped events because the Splunk HTTP Event Collector could not keep up", "count", dropped)
+			}
+			flush()
+		}
+	}
+}
+
+// sendWithRetry sends batch, retrying failures other than the rejection of
+// the events or of the token.
+func (h *HEC) sendWithRetry(ctx context.Context, batch []*event) error {
+	backoff := minBackoff
+	for attempt := 1; ; attempt++ {
+		err := h.send(ctx, batch)
+		if err == nil {
+			return nil
+		}
+		if statusErr, ok := err.(*statusError); ok && !statusErr.retriable() {
+			return err
+		}
+		if attempt == maxAttempts {
+			return fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+		}
+		logger.V(2).Info("retrying events", "count", len(batch), "attempt", attempt, "err", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// statusError is an error response of the collector.
+type statusError struct {
+	code int
+	text string
+}
+
+func (e *statusError) Error() string {
+	if e.text != "" {
+		return fmt.Sprintf("collector returned %d: %s", e.code, e.text)
+	}
+	return fmt.Sprintf("collector returned %d", e.code)
+}
+
+// retriable reports whether the collector was busy or unavailable rather
+// than rejecting the request.
+func (e *statusError) retriable() bool {
+	return e.code == http.StatusTooManyRequests || e.code >= 500
+}
+
+// send posts batch as concatenated JSON events.
+func (h *HEC) send(ctx context.Context, batch []*event) error {
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	for _, e := range batch {
+		if err := encoder.Encode(e); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Splunk "+h.token)
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	// The collector explains errors as {"text": ..., "code": ...}
+	var reply struct {
+		Text string `json:"text"`
+	}
+	json.NewDecoder(resp.Body).Decode(&reply)
+	return &statusError{code: resp.StatusCode, text: reply.Text}
+}
@@ -0,0 +1,171 @@
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+
+	listersv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/klog/v2"
+
+	"github.com/kubescape/kubeenforcer/pkg/decision"
+)
+
+var logger klog.Logger = klog.LoggerWithName(klog.Background(), "telemetry")
+
+const (
+	// firstReportDelay leaves time for the informers to sync before the
+	// first report.
+	firstReportDelay = time.Minute
+
+	sendTimeout = 10 * time.Second
+)
+
+// Report is the anonymous usage data sent to the telemetry endpoint. It holds
+// counts only, never names of clusters, policies, users or objects.
+type Report struct {
+	// Installation is a hash of the UID of the kube-system namespace, which
+	// tells reports of different clusters apart without identifying them.
+	Installation string `json:"installation,omitempty"`
+	Version      string `json:"version"`
+	GoVersion    string `json:"goVersion"`
+
+	Policies int `json:"policies"`
+	Bindings int `json:"bindings"`
+
+	// Requests and Denied count the admission requests reviewed since the
+	// previous report.
+	Requests        int64 `json:"requests"`
+	Denied          int64 `json:"denied"`
+	IntervalSeconds int64 `json:"intervalSeconds"`
+}
+
+// Counts are the numbers of loaded policies and bindings.
+type Counts struct {
+	Policies int
+	Bindings int
+}
+
+// Reporter periodically sends a Report to a telemetry endpoint. It is a
+// decision.Sink counting the reviewed requests.
+type Reporter struct {
+	endpoint   string
+	interval   time.Duration
+	counts     func() (Counts, error)
+	namespaces listersv1.NamespaceLister
+	client     *http.Client
+
+	requests atomic.Int64
+	denied   atomic.Int64
+}
+
+// New creates a Reporter sending a Report to endpoint every interval. counts
+// is called for each report, and namespaces is used to derive the
+// installation hash.
+func New(endpoint string, interval time.Duration, counts func() (Counts, error), namespaces listersv1.NamespaceLister) *Reporter {
+	return &Reporter{
+		endpoint:   endpoint,
+		interval:   interval,
+		counts:     counts,
+		namespaces: namespaces,
+		client:     &http.Client{Timeout: sendTimeout},
+	}
+}
+
+func (r *Reporter) Record(d *decision.Decision) {
+	r.requests.Add(1)
+	if !d.Allowed {
+		r.denied.Add(1)
+	}
+}
+
+// Run sends reports until ctx is cancelled. Failed reports are logged and
+// not retried; their requests are counted in the next report.
+func (r *Reporter) Run(ctx context.Context) error {
+	logger.Info("starting telemetry reporter", "endpoint", r.endpoint, "interval", r.interval)
+	defer logger.Info("stopped telemetry reporter")
+
+	timer := time.NewTimer(firstReportDelay)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-timer.C:
+		}
+		if err := r.send(ctx); err != nil {
+			logger.V(2).Info("failed to send telemetry report", "err", err)
+		}
+		timer.Reset(r.interval)
+	}
+}
+
+func (r *Reporter) send(ctx context.Context) error {
+	counts, err := r.counts()
+	if err != nil {
+		return err
+	}
+	report := &Report{
+		Installation:    r.installation(),
+		Version:         Version(),
+		GoVersion:       runtime.Version(),
+		Policies:        counts.Policies,
+		Bindings:        counts.Bindings,
+		Requests:        r.requests.Swap(0),
+		Denied:          r.denied.Swap(0),
+		IntervalSeconds: int64(r.interval.Seconds()),
+	}
+	restore := func() {
+		r.requests.Add(report.Requests)
+		r.denied.Add(report.Denied)
+	}
+
+	body, err := json.Marshal(report)
+	if err != nil {
+		restore()
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.endpoint, bytes.NewReader(body))
+	if err != nil {
+		restore()
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := r.client.Do(req)
+	if err != nil {
+		restore()
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		restore()
+		return fmt.Errorf("telemetry endpoint responded %s", resp.Status)
+	}
+	logger.V(4).Info("sent telemetry report", "report", report)
+	return nil
+}
+
+func (r *Reporter) installation() string {
+	ns, err := r.namespaces.Get("kube-system")
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(ns.UID))
+	return hex.EncodeToString(sum[:])
+}
+
+// Version returns the version kubeenforcer was built as, or "unknown".
+func Version() string {
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "unknown"
+}
@@ -0,0 +1,161 @@
+package testing
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	gotesting "testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/api/v2/models"
+
+	"github.com/kubescape/kubeenforcer/pkg/alertmanager"
+)
+
+// Clients send alerts asynchronously, so the alerts of a FakeAlertManager are
+// read once no alert was posted for settleInterval, or after settleTimeout
+// if they keep coming.
+const (
+	settleInterval = 100 * time.Millisecond
+	settleTimeout  = 5 * time.Second
+)
+
+// Alert is an alert received by a FakeAlertManager.
+type Alert struct {
+	Labels      map[string]string
+	Annotations map[string]string
+}
+
+// FakeAlertManager serves the alerts API of alertmanager and captures the
+// alerts posted to it, so alert routing can be tested with the alertmanager
+// client kubeenforcer uses, deduplication included. Alertmanager routes
+// alerts by their labels, which the assertions match.
+type FakeAlertManager struct {
+	server *httptest.Server
+	ctx    context.Context
+
+	lock   sync.Mutex
+	alerts []Alert
+	status int
+	// posts counts the posts received, accepted or not
+	posts int
+}
+
+// NewFakeAlertManager starts a FakeAlertManager, stopped when t completes.
+func NewFakeAlertManager(t gotesting.TB) *FakeAlertManager {
+	ctx, cancel := context.WithCancel(context.Background())
+	f := &FakeAlertManager{ctx: ctx}
+	mux := http.NewServeMux()
+	mux.HandleFunc(strings.TrimSuffix(alertmanager.API_PATH, "/")+"/alerts", f.postAlerts)
+	f.server = httptest.NewServer(mux)
+	t.Cleanup(f.server.Close)
+	t.Cleanup(cancel)
+	return f
+}
+
+func (f *FakeAlertManager) postAlerts(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var posted models.PostableAlerts
+	if err := json.NewDecoder(req.Body).Decode(&posted); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.posts++
+	if f.status != 0 {
+		http.Error(w, "failing as requested", f.status)
+		return
+	}
+	for _, alert := range posted {
+		f.alerts = append(f.alerts, Alert{Labels: alert.Labels, Annotations: alert.Annotations})
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// Host returns the address of the fake, as given to -alertmanager.
+func (f *FakeAlertManager) Host() string {
+	return strings.TrimPrefix(f.server.URL, "http://")
+}
+
+// Client returns an alertmanager client sending alerts to the fake, e.g. for
+// webhook.WithAlertManager. It runs until the test completes.
+func (f *FakeAlertManager) Client() *alertmanager.AlertManager {
+	client := alertmanager.New(f.Host(), "")
+	go client.Run(f.ctx)
+	return client
+}
+
+// Fail makes the fake reject alerts with status, e.g. 503, or accept them
+// again if status is 0.
+func (f *FakeAlertManager) Fail(status int) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.status = status
+}
+
+// Alerts returns the alerts received, in order, once no alert was posted
+// for 100ms.
+func (f *FakeAlertManager) Alerts() []Alert {
+	deadline := time.Now().Add(settleTimeout)
+	posts := -1
+	for {
+		f.lock.Lock()
+		if f.posts == posts || time.Now().After(deadline) {
+			defer f.lock.Unlock()
+			return append([]Alert(nil), f.alerts...)
+		}
+		posts = f.posts
+		f.lock.Unlock()
+		time.Sleep(settleInterval)
+	}
+}
+
+// Reset forgets the alerts received so far.
+func (f *FakeAlertManager) Reset() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.alerts = nil
+}
+
+// ExpectAlert fails t unless an alert with all of labels was received, and
+// returns the first one.
+func (f *FakeAlertManager) ExpectAlert(t gotesting.TB, labels map[string]string) Alert {
+	t.Helper()
+	alerts := f.Alerts()
+	for _, alert := range alerts {
+		if matchLabels(alert.Labels, labels) {
+			return alert
+		}
+	}
+	t.Errorf("expected an alert with labels %v, received %d alerts: %v", labels, len(alerts), alerts)
+	return Alert{}
+}
+
+// ExpectNoAlert fails t if an alert with all of labels was received. Empty
+// labels match any alert.
+func (f *FakeAlertManager) ExpectNoAlert(t gotesting.TB, labels map[string]string) {
+	t.Helper()
+	for _, alert := range f.Alerts() {
+		if matchLabels(alert.Labels, labels) {
+			t.Errorf("expected no alert with labels %v, received %v", labels, alert)
+			return
+		}
+	}
+}
+
+func matchLabels(labels, selector map[string]string) bool {
+	for name, value := range selector {
+		if labels[name] != value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,256 @@
+package testing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	gotesting "testing"
+	"time"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apiserver/pkg/admission"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/client-go/dynamic"
+	clientsetscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+
+	xv1alpha1 "k8s.io/cel-admission-webhook/pkg/apis/admissionregistration.x-k8s.io/v1alpha1"
+)
+
+// errNotDecided is returned for errors that are not decisions of the
+// webhook, e.g. missing namespaces.
+var errNotDecided = errors.New("request failed before being admitted")
+
+// Decision is the outcome of submitting an object.
+type Decision struct {
+	Allowed bool
+	// Message is the denial message of the webhook, without the prefix the
+	// API server adds.
+	Message  string
+	Warnings []string
+}
+
+// warningRecorder collects the warnings of the responses of a client.
+type warningRecorder struct {
+	lock     sync.Mutex
+	warnings []string
+}
+
+func (r *warningRecorder) HandleWarningHeader(code int, agent string, message string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.warnings = append(r.warnings, message)
+}
+
+// Decide submits the creation of obj as a server-side dry run, which
+// persists nothing, and returns the decision of the webhook. obj may be
+// typed or unstructured; the namespace of namespaced objects must exist.
+func (h *Harness) Decide(t gotesting.TB, obj runtime.Object) Decision {
+	t.Helper()
+	decision, err := h.decide(obj)
+	if err != nil {
+		t.Fatalf("failed to submit %s: %v", describe(obj), err)
+	}
+	return decision
+}
+
+func (h *Harness) decide(obj runtime.Object) (Decision, error) {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return Decision{}, err
+	}
+	u := &unstructured.Unstructured{Object: content}
+	gvk := u.GroupVersionKind()
+	if gvk.Empty() {
+		kinds, _, err := clientsetscheme.Scheme.ObjectKinds(obj)
+		if err != nil {
+			return Decision{}, fmt.Errorf("kind is not set: %w", err)
+		}
+		gvk = kinds[0]
+		u.SetGroupVersionKind(gvk)
+	}
+	mapping, err := h.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return Decision{}, err
+	}
+
+	recorder := &warningRecorder{}
+	config := rest.CopyConfig(h.Config)
+	config.WarningHandler = recorder
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return Decision{}, err
+	}
+	resource := client.Resource(mapping.Resource)
+	var ri dynamic.ResourceInterface = resource
+	if mapping.Scope.Name() == "namespace" {
+		ri = resource.Namespace(u.GetNamespace())
+	}
+	_, err = ri.Create(context.Background(), u, metav1.CreateOptions{DryRun: []string{metav1.DryRunAll}})
+
+	decision := Decision{Allowed: err == nil, Warnings: recorder.warnings}
+	if err != nil {
+		prefix := fmt.Sprintf("admission webhook %q denied the request: ", h.name)
+		_, message, denied := strings.Cut(err.Error(), prefix)
+		if !denied {
+			return Decision{}, fmt.Errorf("%w: %v", errNotDecided, err)
+		}
+		decision.Message = message
+	}
+	return decision, nil
+}
+
+// ExpectAllowed fails t unless obj is allowed.
+func (h *Harness) ExpectAllowed(t gotesting.TB, obj runtime.Object) Decision {
+	t.Helper()
+	decision := h.Decide(t, obj)
+	if !decision.Allowed {
+		t.Errorf("expected %s to be allowed, denied: %s", describe(obj), decision.Message)
+	}
+	return decision
+}
+
+// ExpectDenied fails t unless obj is denied with a message containing
+// message. Since policies take a moment to be loaded, obj is submitted again
+// until it is denied or the timeout of the harness expires.
+func (h *Harness) ExpectDenied(t gotesting.TB, obj runtime.Object, message string) Decision {
+	t.Helper()
+	var decision Decision
+	err := wait.PollUntilContextTimeout(context.Background(), 200*time.Millisecond, h.timeout, true, func(context.Context) (bool, error) {
+		var err error
+		decision, err = h.decide(obj)
+		return err == nil && !decision.Allowed, err
+	})
+	switch {
+	case err != nil && decision.Allowed:
+		t.Errorf("expected %s to be denied, allowed", describe(obj))
+	case err != nil:
+		t.Fatalf("failed to submit %s: %v", describe(obj), err)
+	case !strings.Contains(decision.Message, message):
+		t.Errorf("expected %s to be denied with %q, denied: %s", describe(obj), message, decision.Message)
+	}
+	return decision
+}
+
+// ApplyPolicy creates policy and its bindings, deleted when t completes,
+// and waits until the webhook evaluates them.
+func (h *Harness) ApplyPolicy(t gotesting.TB, policy *xv1alpha1.ValidatingAdmissionPolicy, bindings ...*xv1alpha1.ValidatingAdmissionPolicyBinding) {
+	t.Helper()
+	ctx := context.Background()
+	policies := h.Policies.AdmissionregistrationV1alpha1().ValidatingAdmissionPolicies()
+	created, err := policies.Create(ctx, policy, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("failed to create policy %s: %v", policy.Name, err)
+	}
+	t.Cleanup(func() { policies.Delete(context.Background(), created.Name, metav1.DeleteOptions{}) })
+	versions := map[string]string{}
+	policyVersion := created.ResourceVersion
+
+	bindingClient := h.Policies.AdmissionregistrationV1alpha1().ValidatingAdmissionPolicyBindings()
+	for _, binding := range bindings {
+		created, err := bindingClient.Create(ctx, binding, metav1.CreateOptions{})
+		if err != nil {
+			t.Fatalf("failed to create binding %s: %v", binding.Name, err)
+		}
+		t.Cleanup(func() { bindingClient.Delete(context.Background(), created.Name, metav1.DeleteOptions{}) })
+		versions[created.Name] = created.ResourceVersion
+	}
+
+	policyLister := h.factory.Admissionregistration().V1alpha1().ValidatingAdmissionPolicies().Lister()
+	bindingLister := h.factory.Admissionregistration().V1alpha1().ValidatingAdmissionPolicyBindings().Lister()
+	if err := wait.PollUntilContextTimeout(ctx, 50*time.Millisecond, h.timeout, true, func(context.Context) (bool, error) {
+		if cached, err := policyLister.Get(policy.Name); err != nil || cached.ResourceVersion != policyVersion {
+			return false, nil
+		}
+		for name, version := range versions {
+			if cached, err := bindingLister.Get(name); err != nil || cached.ResourceVersion != version {
+				return false, nil
+			}
+		}
+		return true, nil
+	}); err != nil {
+		t.Fatalf("policy %s was not loaded: %v", policy.Name, err)
+	}
+	if err := h.awaitCompiled(ctx); err != nil {
+		t.Fatalf("policy %s was not compiled: %v", policy.Name, err)
+	}
+}
+
+// awaitCompiled waits until the policies in the informer caches are
+// evaluated. Cached policies are compiled on a periodic refresh, which
+// reports nothing, so a marker policy denying a probe ConfigMap is created
+// after them, and the probe is validated by the plugin until it is denied:
+// the refresh that compiled the marker compiled the policies cached before.
+func (h *Harness) awaitCompiled(ctx context.Context) error {
+	name := "kubeenforcer-probe-" + rand.String(5)
+	policy := &xv1alpha1.ValidatingAdmissionPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: xv1alpha1.ValidatingAdmissionPolicySpec{
+			MatchConstraints: &xv1alpha1.MatchResources{
+				ResourceRules: []xv1alpha1.NamedRuleWithOperations{{
+					ResourceNames: []string{name},
+					RuleWithOperations: admissionregistrationv1.RuleWithOperations{
+						Operations: []admissionregistrationv1.OperationType{admissionregistrationv1.Create},
+						Rule: admissionregistrationv1.Rule{
+							APIGroups:   []string{""},
+							APIVersions: []string{"v1"},
+							Resources:   []string{"configmaps"},
+						},
+					},
+				}},
+			},
+			Validations: []xv1alpha1.Validation{{Expression: "false", Message: name}},
+		},
+	}
+	binding := &xv1alpha1.ValidatingAdmissionPolicyBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: xv1alpha1.ValidatingAdmissionPolicyBindingSpec{
+			PolicyName:        name,
+			ValidationActions: []xv1alpha1.ValidationAction{xv1alpha1.Deny},
+		},
+	}
+	policies := h.Policies.AdmissionregistrationV1alpha1().ValidatingAdmissionPolicies()
+	if _, err := policies.Create(ctx, policy, metav1.CreateOptions{}); err != nil {
+		return err
+	}
+	defer policies.Delete(context.Background(), name, metav1.DeleteOptions{})
+	bindings := h.Policies.AdmissionregistrationV1alpha1().ValidatingAdmissionPolicyBindings()
+	if _, err := bindings.Create(ctx, binding, metav1.CreateOptions{}); err != nil {
+		return err
+	}
+	defer bindings.Delete(context.Background(), name, metav1.DeleteOptions{})
+
+	probe := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: metav1.NamespaceDefault}}
+	gvr := corev1.SchemeGroupVersion.WithResource("configmaps")
+	attributes := admission.NewAttributesRecord(probe, nil, corev1.SchemeGroupVersion.WithKind("ConfigMap"), probe.Namespace, name, gvr, "",
+		admission.Create, &metav1.CreateOptions{}, true, &user.DefaultInfo{Name: "kubeenforcer-harness"})
+	interfaces := admission.NewObjectInterfacesFromScheme(clientsetscheme.Scheme)
+	return wait.PollUntilContextTimeout(ctx, 100*time.Millisecond, h.timeout, true, func(ctx context.Context) (bool, error) {
+		err := h.plugin.Validate(ctx, attributes, interfaces)
+		return err != nil && strings.Contains(err.Error(), name), nil
+	})
+}
+
+func describe(obj runtime.Object) string {
+	kind := obj.GetObjectKind().GroupVersionKind().Kind
+	if kind == "" {
+		if kinds, _, err := clientsetscheme.Scheme.ObjectKinds(obj); err == nil {
+			kind = kinds[0].Kind
+		}
+	}
+	if accessor, ok := obj.(metav1.Object); ok {
+		if accessor.GetNamespace() != "" {
+			return fmt.Sprintf("%s %s/%s", kind, accessor.GetNamespace(), accessor.GetName())
+		}
+		return fmt.Sprintf("%s %s", kind, accessor.GetName())
+	}
+	return kind
+}
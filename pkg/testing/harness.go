@@ -0,0 +1,291 @@
+package testing
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync/atomic"
+	gotesting "testing"
+	"time"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apiextensionsclientset "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
+	apiextensionsinformers "k8s.io/apiextensions-apiserver/pkg/client/informers/externalversions"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apiserver/pkg/admission"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	clientsetscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+	certutil "k8s.io/client-go/util/cert"
+	"sigs.k8s.io/yaml"
+
+	"k8s.io/cel-admission-webhook/pkg/controller/admissionregistration.x-k8s.io/v1alpha1"
+	"k8s.io/cel-admission-webhook/pkg/generated/clientset/versioned"
+	"k8s.io/cel-admission-webhook/pkg/generated/clientset/versioned/scheme"
+
+	"github.com/kubescape/kubeenforcer/pkg/crdscheme"
+	"github.com/kubescape/kubeenforcer/pkg/variables"
+	"github.com/kubescape/kubeenforcer/pkg/webhook"
+)
+
+// Options configures a Harness.
+type Options struct {
+	// Host is the address the webhook listens on and the API server calls
+	// it at. Defaults to 127.0.0.1, which suits envtest, whose API server
+	// runs on the test host. With kind, use an address of the test host
+	// reachable from the nodes, e.g. the gateway of the kind docker network.
+	Host string
+	// CRDDirectory holds the CRDs installed before the webhook starts.
+	// Defaults to the CRDs of the chart of this module.
+	CRDDirectory string
+	// Rules select the requests sent to the webhook. Defaults to creating
+	// and updating any resource outside of kube-system.
+	Rules []admissionregistrationv1.RuleWithOperations
+	// WebhookOptions are passed to webhook.New after the options of the
+	// harness, e.g. to add validators.
+	WebhookOptions []webhook.Option
+	// Timeout bounds waiting for the webhook, policies and denials.
+	// Defaults to 30s.
+	Timeout time.Duration
+}
+
+// Harness runs kubeenforcer in-process against the API server of a test
+// cluster, such as one started by envtest or kind, and registers it as a
+// validating webhook, so tests can submit objects through the API server
+// and assert the decisions on them.
+type Harness struct {
+	// Config is the configuration of the test cluster.
+	Config *rest.Config
+	// Client and Policies are clients of the test cluster, the latter of
+	// ValidatingAdmissionPolicies and their bindings.
+	Client   kubernetes.Interface
+	Policies versioned.Interface
+
+	dynamic dynamic.Interface
+	mapper  meta.RESTMapper
+	factory informers.SharedInformerFactory
+	plugin  v1alpha1.ValidationInterface
+	name    string
+	timeout time.Duration
+	calls   atomic.Int64
+}
+
+// Start installs the CRDs, starts the webhook and registers it in the
+// cluster of config. Everything is torn down when t completes.
+func Start(t gotesting.TB, config *rest.Config, opts Options) *Harness {
+	t.Helper()
+	if opts.Host == "" {
+		opts.Host = "127.0.0.1"
+	}
+	if opts.CRDDirectory == "" {
+		_, file, _, _ := runtime.Caller(0)
+		opts.CRDDirectory = filepath.Join(filepath.Dir(file), "..", "..", "charts", "kubeenforcer", "crds")
+	}
+	if opts.Rules == nil {
+		opts.Rules = []admissionregistrationv1.RuleWithOperations{{
+			Operations: []admissionregistrationv1.OperationType{admissionregistrationv1.Create, admissionregistrationv1.Update},
+			Rule: admissionregistrationv1.Rule{
+				APIGroups:   []string{"*"},
+				APIVersions: []string{"*"},
+				Resources:   []string{"*"},
+			},
+		}}
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 30 * time.Second
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	// Policy objects are decoded by the webhook like any other object
+	scheme.AddToScheme(clientsetscheme.Scheme)
+
+	h := &Harness{Config: config, name: "kubeenforcer-test-" + rand.String(5) + ".kubescape.io", timeout: opts.Timeout}
+	var err error
+	if h.Policies, err = versioned.NewForConfig(config); err != nil {
+		t.Fatalf("failed to create policy client: %v", err)
+	}
+	if h.Client, err = kubernetes.NewForConfig(config); err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	if h.dynamic, err = dynamic.NewForConfig(config); err != nil {
+		t.Fatalf("failed to create dynamic client: %v", err)
+	}
+	apiextensionsClient, err := apiextensionsclientset.NewForConfig(config)
+	if err != nil {
+		t.Fatalf("failed to create apiextensions client: %v", err)
+	}
+	if err := h.installCRDs(ctx, apiextensionsClient, opts.CRDDirectory); err != nil {
+		t.Fatalf("failed to install CRDs: %v", err)
+	}
+
+	// CRDs created by the test are discovered when objects of their kind
+	// are first submitted
+	h.mapper = restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(h.Client.Discovery()))
+	kubeClient := variables.NewClient(v1alpha1.NewWrappedClient(h.Client, h.Policies), nil)
+	h.factory = informers.NewSharedInformerFactory(kubeClient, 30*time.Second)
+	apiextensionsFactory := apiextensionsinformers.NewSharedInformerFactory(apiextensionsClient, 30*time.Second)
+	crds := apiextensionsFactory.Apiextensions().V1().CustomResourceDefinitions()
+	plugin := v1alpha1.NewPlugin(h.factory, kubeClient, h.mapper, crdscheme.NewResolver(crds, h.Client.Discovery(), 0), h.dynamic, nil)
+	h.plugin = plugin
+	// The harness watches the informers of the plugin for ApplyPolicy
+	h.factory.Admissionregistration().V1alpha1().ValidatingAdmissionPolicies().Informer()
+	h.factory.Admissionregistration().V1alpha1().ValidatingAdmissionPolicyBindings().Informer()
+
+	wh := webhook.New("", append([]webhook.Option{
+		webhook.WithValidator("policies", plugin, admissionregistrationv1.Fail),
+		webhook.WithScheme(clientsetscheme.Scheme),
+		webhook.WithObjectInterfaces(crdscheme.NewObjectInterfaces(admission.NewObjectInterfacesFromScheme(clientsetscheme.Scheme), crds)),
+		webhook.WithCoercer(crdscheme.NewCoercer(crds)),
+	}, opts.WebhookOptions...)...)
+	h.factory.Start(ctx.Done())
+	apiextensionsFactory.Start(ctx.Done())
+	go plugin.Run(ctx)
+	if err := wait.PollUntilContextTimeout(ctx, 100*time.Millisecond, opts.Timeout, true, func(context.Context) (bool, error) {
+		return plugin.HasSynced(), nil
+	}); err != nil {
+		t.Fatalf("policies did not sync: %v", err)
+	}
+
+	caBundle, url, err := h.serve(t, wh.Handler(), opts.Host)
+	if err != nil {
+		t.Fatalf("failed to serve the webhook: %v", err)
+	}
+	if err := h.register(ctx, t, url, caBundle, opts.Rules); err != nil {
+		t.Fatalf("failed to register the webhook: %v", err)
+	}
+	return h
+}
+
+// installCRDs creates the CRDs of the YAML files in dir that do not exist
+// yet, and waits for them to be established.
+func (h *Harness) installCRDs(ctx context.Context, client apiextensionsclientset.Interface, dir string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.yaml"))
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no CRDs in %s", dir)
+	}
+	for _, file := range files {
+		raw, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		var crd apiextensionsv1.CustomResourceDefinition
+		if err := yaml.Unmarshal(raw, &crd); err != nil {
+			return fmt.Errorf("failed to parse %s: %w", file, err)
+		}
+		if _, err := client.ApiextensionsV1().CustomResourceDefinitions().Create(ctx, &crd, metav1.CreateOptions{}); err != nil && !k8serrors.IsAlreadyExists(err) {
+			return fmt.Errorf("failed to create %s: %w", crd.Name, err)
+		}
+		if err := wait.PollUntilContextTimeout(ctx, 100*time.Millisecond, h.timeout, true, func(ctx context.Context) (bool, error) {
+			current, err := client.ApiextensionsV1().CustomResourceDefinitions().Get(ctx, crd.Name, metav1.GetOptions{})
+			if err != nil {
+				return false, nil
+			}
+			for _, condition := range current.Status.Conditions {
+				if condition.Type == apiextensionsv1.Established && condition.Status == apiextensionsv1.ConditionTrue {
+					return true, nil
+				}
+			}
+			return false, nil
+		}); err != nil {
+			return fmt.Errorf("%s was not established: %w", crd.Name, err)
+		}
+	}
+	return nil
+}
+
+// serve serves handler over TLS with a self-signed certificate for host,
+// returning the CA bundle of the certificate and the URL of /validate.
+func (h *Harness) serve(t gotesting.TB, handler http.Handler, host string) ([]byte, string, error) {
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = append(ips, ip)
+	}
+	certPEM, keyPEM, err := certutil.GenerateSelfSignedCertKey(host, ips, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, "", err
+	}
+	listener, err := tls.Listen("tcp", net.JoinHostPort(host, "0"), &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		return nil, "", err
+	}
+
+	counted := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		h.calls.Add(1)
+		handler.ServeHTTP(w, req)
+	})
+	server := &http.Server{Handler: counted}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	return certPEM, "https://" + net.JoinHostPort(host, port) + "/validate", nil
+}
+
+// register creates the ValidatingWebhookConfiguration calling url, and
+// waits until the API server calls it.
+func (h *Harness) register(ctx context.Context, t gotesting.TB, url string, caBundle []byte, rules []admissionregistrationv1.RuleWithOperations) error {
+	failurePolicy := admissionregistrationv1.Fail
+	sideEffects := admissionregistrationv1.SideEffectClassNone
+	timeout := int32(10)
+	config := &admissionregistrationv1.ValidatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: h.name},
+		Webhooks: []admissionregistrationv1.ValidatingWebhook{{
+			Name:                    h.name,
+			ClientConfig:            admissionregistrationv1.WebhookClientConfig{URL: &url, CABundle: caBundle},
+			Rules:                   rules,
+			FailurePolicy:           &failurePolicy,
+			SideEffects:             &sideEffects,
+			TimeoutSeconds:          &timeout,
+			AdmissionReviewVersions: []string{"v1"},
+			NamespaceSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{
+				Key:      corev1.LabelMetadataName,
+				Operator: metav1.LabelSelectorOpNotIn,
+				Values:   []string{metav1.NamespaceSystem},
+			}}},
+		}},
+	}
+	configs := h.Client.AdmissionregistrationV1().ValidatingWebhookConfigurations()
+	if _, err := configs.Create(ctx, config, metav1.CreateOptions{}); err != nil {
+		return err
+	}
+	t.Cleanup(func() {
+		configs.Delete(context.Background(), h.name, metav1.DeleteOptions{})
+	})
+
+	// The API server picks up webhook configurations asynchronously
+	probe := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "kubeenforcer-probe", Namespace: metav1.NamespaceDefault}}
+	var probeErr error
+	err := wait.PollUntilContextTimeout(ctx, 200*time.Millisecond, h.timeout, true, func(ctx context.Context) (bool, error) {
+		calls := h.calls.Load()
+		_, probeErr = h.Client.CoreV1().ConfigMaps(probe.Namespace).Create(ctx, probe, metav1.CreateOptions{DryRun: []string{metav1.DryRunAll}})
+		return h.calls.Load() > calls, nil
+	})
+	if err != nil {
+		return fmt.Errorf("the API server did not call the webhook at %s: %v, last probe error: %v", url, err, probeErr)
+	}
+	return nil
+}
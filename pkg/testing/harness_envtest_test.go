@@ -0,0 +1,158 @@
+//go:build envtest
+
+package testing
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	gotesting "testing"
+	"time"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/keyutil"
+
+	xv1alpha1 "k8s.io/cel-admission-webhook/pkg/apis/admissionregistration.x-k8s.io/v1alpha1"
+)
+
+// startAPIServer starts etcd and kube-apiserver from the binaries in
+// $KUBEBUILDER_ASSETS, as installed by setup-envtest, and returns the
+// configuration of an admin of the API server. Both are stopped when t
+// completes. The test is skipped if $KUBEBUILDER_ASSETS is not set.
+func startAPIServer(t *gotesting.T) *rest.Config {
+	assets := os.Getenv("KUBEBUILDER_ASSETS")
+	if assets == "" {
+		t.Skip("KUBEBUILDER_ASSETS is not set")
+	}
+	dir := t.TempDir()
+	etcdPort, peerPort, securePort := freePort(t), freePort(t), freePort(t)
+	etcdURL := fmt.Sprintf("http://127.0.0.1:%d", etcdPort)
+	run(t, filepath.Join(assets, "etcd"),
+		"--data-dir", filepath.Join(dir, "etcd"),
+		"--listen-client-urls", etcdURL,
+		"--advertise-client-urls", etcdURL,
+		"--listen-peer-urls", fmt.Sprintf("http://127.0.0.1:%d", peerPort),
+		"--unsafe-no-fsync")
+
+	key, err := keyutil.MakeEllipticPrivateKeyPEM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile, tokenFile := filepath.Join(dir, "sa.key"), filepath.Join(dir, "tokens.csv")
+	if err := keyutil.WriteKey(keyFile, key); err != nil {
+		t.Fatal(err)
+	}
+	const token = "kubeenforcer-envtest"
+	if err := os.WriteFile(tokenFile, []byte(token+",admin,admin,system:masters\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	run(t, filepath.Join(assets, "kube-apiserver"),
+		"--etcd-servers", etcdURL,
+		"--bind-address", "127.0.0.1",
+		"--secure-port", fmt.Sprint(securePort),
+		"--cert-dir", filepath.Join(dir, "certs"),
+		"--token-auth-file", tokenFile,
+		"--authorization-mode", "RBAC",
+		"--service-account-issuer", "https://kubernetes.default.svc",
+		"--service-account-key-file", keyFile,
+		"--service-account-signing-key-file", keyFile,
+		"--service-cluster-ip-range", "10.0.0.0/24",
+		"--disable-admission-plugins", "ServiceAccount")
+
+	config := &rest.Config{
+		Host:            fmt.Sprintf("https://127.0.0.1:%d", securePort),
+		BearerToken:     token,
+		TLSClientConfig: rest.TLSClientConfig{Insecure: true},
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wait.PollUntilContextTimeout(context.Background(), 200*time.Millisecond, time.Minute, true, func(ctx context.Context) (bool, error) {
+		if err := client.Discovery().RESTClient().Get().AbsPath("/readyz").Do(ctx).Error(); err != nil {
+			return false, nil
+		}
+		_, err := client.CoreV1().Namespaces().Get(ctx, metav1.NamespaceDefault, metav1.GetOptions{})
+		return err == nil, nil
+	}); err != nil {
+		t.Fatalf("the API server did not become ready: %v", err)
+	}
+	return config
+}
+
+// run starts the binary at path with args, killed when t completes.
+func run(t *gotesting.T, path string, args ...string) {
+	cmd := exec.Command(path, args...)
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("failed to start %s: %v", path, err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+}
+
+func freePort(t *gotesting.T) int {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestHarness(t *gotesting.T) {
+	h := Start(t, startAPIServer(t), Options{})
+
+	policy := &xv1alpha1.ValidatingAdmissionPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "deny-forbidden-keys"},
+		Spec: xv1alpha1.ValidatingAdmissionPolicySpec{
+			MatchConstraints: &xv1alpha1.MatchResources{
+				ResourceRules: []xv1alpha1.NamedRuleWithOperations{{
+					RuleWithOperations: admissionregistrationv1.RuleWithOperations{
+						Operations: []admissionregistrationv1.OperationType{admissionregistrationv1.Create},
+						Rule: admissionregistrationv1.Rule{
+							APIGroups:   []string{""},
+							APIVersions: []string{"v1"},
+							Resources:   []string{"configmaps"},
+						},
+					},
+				}},
+			},
+			Validations: []xv1alpha1.Validation{{
+				Expression: "!has(object.data) || !('forbidden' in object.data)",
+				Message:    "forbidden keys are not allowed",
+			}},
+		},
+	}
+	binding := &xv1alpha1.ValidatingAdmissionPolicyBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: "deny-forbidden-keys"},
+		Spec: xv1alpha1.ValidatingAdmissionPolicyBindingSpec{
+			PolicyName:        policy.Name,
+			ValidationActions: []xv1alpha1.ValidationAction{xv1alpha1.Deny},
+		},
+	}
+	h.ApplyPolicy(t, policy, binding)
+
+	denied := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "denied", Namespace: metav1.NamespaceDefault},
+		Data:       map[string]string{"forbidden": "true"},
+	}
+	// The policy is evaluated once ApplyPolicy returns, without retries
+	if decision := h.Decide(t, denied); decision.Allowed {
+		t.Errorf("expected ConfigMap %s to be denied right after ApplyPolicy, allowed", denied.Name)
+	}
+	h.ExpectDenied(t, denied, "forbidden keys are not allowed")
+	h.ExpectAllowed(t, &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "allowed", Namespace: metav1.NamespaceDefault},
+		Data:       map[string]string{"allowed": "true"},
+	})
+}
@@ -0,0 +1,60 @@
+package testing
+
+import (
+	"sync"
+	gotesting "testing"
+
+	"github.com/kubescape/kubeenforcer/pkg/decision"
+)
+
+// FakeSink is a decision.Sink capturing the decisions recorded in it, e.g.
+// through webhook.WithDecisionSinks. The zero value is ready to use.
+type FakeSink struct {
+	lock      sync.Mutex
+	decisions []decision.Decision
+}
+
+func (s *FakeSink) Record(d *decision.Decision) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.decisions = append(s.decisions, *d)
+}
+
+// Decisions returns the decisions recorded so far, in order.
+func (s *FakeSink) Decisions() []decision.Decision {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]decision.Decision(nil), s.decisions...)
+}
+
+// Reset forgets the decisions recorded so far.
+func (s *FakeSink) Reset() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.decisions = nil
+}
+
+// ExpectDecision fails t unless a decision matching match was recorded, and
+// returns the first one.
+func (s *FakeSink) ExpectDecision(t gotesting.TB, match func(*decision.Decision) bool) decision.Decision {
+	t.Helper()
+	decisions := s.Decisions()
+	for i := range decisions {
+		if match(&decisions[i]) {
+			return decisions[i]
+		}
+	}
+	t.Errorf("expected a matching decision, recorded %d decisions", len(decisions))
+	return decision.Decision{}
+}
+
+// ExpectNoDecision fails t if a decision matching match was recorded.
+func (s *FakeSink) ExpectNoDecision(t gotesting.TB, match func(*decision.Decision) bool) {
+	t.Helper()
+	for _, d := range s.Decisions() {
+		if match(&d) {
+			t.Errorf("expected no matching decision, recorded %s %s/%s %s/%s by %s, allowed: %t", d.Operation, d.Group, d.Resource, d.Namespace, d.Name, d.User, d.Allowed)
+			return
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package typecheck
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types/ref"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	plugincel "k8s.io/apiserver/pkg/admission/plugin/cel"
+	apiservercel "k8s.io/apiserver/pkg/cel"
+	"k8s.io/apiserver/pkg/cel/common"
+	"k8s.io/apiserver/pkg/cel/library"
+	"k8s.io/apiserver/pkg/cel/openapi"
+	"k8s.io/apiserver/pkg/cel/openapi/resolver"
+
+	"k8s.io/cel-admission-webhook/pkg/apis/admissionregistration.x-k8s.io/v1alpha1"
+
+	"github.com/kubescape/kubeenforcer/pkg/variables"
+)
+
+// maxTypes limits the number of kinds a policy is checked against.
+const maxTypes = 10
+
+// Checker type checks the expressions of policies against the schemas of the
+// kinds they match, as the in-tree ValidatingAdmissionPolicy controller does.
+// Unlike at compile time, where object is dynamically typed, this catches
+// references to fields that do not exist or have another type.
+type Checker struct {
+	schemaResolver resolver.SchemaResolver
+	restMapper     meta.RESTMapper
+}
+
+// NewChecker creates a Checker resolving the kinds matched by policies
+// through restMapper and their schemas through schemaResolver.
+func NewChecker(schemaResolver resolver.SchemaResolver, restMapper meta.RESTMapper) *Checker {
+	return &Checker{schemaResolver: schemaResolver, restMapper: restMapper}
+}
+
+// Check returns the warnings for the validation expressions of policy, ready
+// to be set in its status. Kinds whose schema cannot be resolved are skipped.
+func (c *Checker) Check(policy *v1alpha1.ValidatingAdmissionPolicy) []v1alpha1.ExpressionWarning {
+	type typed struct {
+		gvk    schema.GroupVersionKind
+		object *apiservercel.DeclType
+	}
+	var types []typed
+	for _, gvk := range c.kinds(policy) {
+		declType, err := c.declType(gvk)
+		if err != nil {
+			if !errors.Is(err, resolver.ErrSchemaNotFound) {
+				logger.Error(err, "failed to resolve schema", "gvk", gvk)
+			}
+			continue
+		}
+		types = append(types, typed{gvk: gvk, object: declType})
+	}
+	if len(types) == 0 {
+		return nil
+	}
+
+	hasParams := policy.Spec.ParamKind != nil
+	var params *apiservercel.DeclType
+	if hasParams {
+		if gv, err := schema.ParseGroupVersion(policy.Spec.ParamKind.APIVersion); err == nil {
+			params, err = c.declType(gv.WithKind(policy.Spec.ParamKind.Kind))
+			if err != nil && !errors.Is(err, resolver.ErrSchemaNotFound) {
+				logger.V(2).Error(err, "failed to resolve schema of params", "policy", policy.Name)
+			}
+		}
+	}
+
+	// Policies whose variables cannot be expanded are rejected when
+	// validated, and fail to compile
+	declared, err := variables.Parse(policy.Annotations)
+	if err != nil {
+		return nil
+	}
+
+	var warnings []v1alpha1.ExpressionWarning
+	path := field.NewPath("spec", "validations")
+	for i, validation := range policy.Spec.Validations {
+		expression, err := variables.Expand(declared, validation.Expression)
+		if err != nil {
+			continue
+		}
+		var lines []string
+		for _, t := range types {
+			env, err := NewEnv(t.object, params, hasParams)
+			if err != nil {
+				lines = append(lines, fmt.Sprintf("%v: type checking error: %v", t.gvk, err))
+				continue
+			}
+			// Compile both parses and checks, only the check issues matter
+			if _, issues := env.Compile(expression); issues != nil {
+				lines = append(lines, fmt.Sprintf("%v: %s", t.gvk, issues))
+			}
+		}
+		if len(lines) > 0 {
+			warnings = append(warnings, v1alpha1.ExpressionWarning{
+				FieldRef: path.Index(i).Child("expression").String(),
+				Warning:  strings.Join(lines, "\n"),
+			})
+		}
+	}
+	return warnings
+}
+
+func (c *Checker) declType(gvk schema.GroupVersionKind) (*apiservercel.DeclType, error) {
+	s, err := c.schemaResolver.ResolveSchema(gvk)
+	if err != nil {
+		return nil, err
+	}
+	return common.SchemaDeclType(&openapi.Schema{Schema: s}, true), nil
+}
+
+// kinds returns the kinds matched by the resource rules of policy, sorted.
+// Rules with wildcard groups or versions are skipped, as are wildcard
+// resources and subresources.
+func (c *Checker) kinds(policy *v1alpha1.ValidatingAdmissionPolicy) []schema.GroupVersionKind {
+	if policy.Spec.MatchConstraints == nil {
+		return nil
+	}
+
+	seen := map[schema.GroupVersionKind]bool{}
+	var result []schema.GroupVersionKind
+	for _, rule := range policy.Spec.MatchConstraints.ResourceRules {
+		if wildcard(rule.APIGroups) || wildcard(rule.APIVersions) {
+			continue
+		}
+		for _, group := range rule.APIGroups {
+			for _, version := range rule.APIVersions {
+				for _, resource := range rule.Resources {
+					if strings.ContainsAny(resource, "*/") {
+						continue
+					}
+					gvks, err := c.restMapper.KindsFor(schema.GroupVersionResource{Group: group, Version: version, Resource: resource})
+					if err != nil {
+						continue
+					}
+					for _, gvk := range gvks {
+						if gvk.Empty() || seen[gvk] {
+							continue
+						}
+						seen[gvk] = true
+						result = append(result, gvk)
+						if len(result) == maxTypes {
+							return sortKinds(result)
+						}
+					}
+				}
+			}
+		}
+	}
+	return sortKinds(result)
+}
+
+func wildcard(values []string) bool {
+	for _, v := range values {
+		if strings.Contains(v, "*") {
+			return true
+		}
+	}
+	return false
+}
+
+func sortKinds(gvks []schema.GroupVersionKind) []schema.GroupVersionKind {
+	sort.Slice(gvks, func(i, j int) bool {
+		if gvks[i].Group != gvks[j].Group {
+			return gvks[i].Group < gvks[j].Group
+		}
+		if gvks[i].Version != gvks[j].Version {
+			return gvks[i].Version < gvks[j].Version
+		}
+		return gvks[i].Kind < gvks[j].Kind
+	})
+	return gvks
+}
+
+// NewEnv returns an environment declaring object and oldObject with the
+// given type, and params with its type if hasParams. Nil types are dynamic,
+// as when policies are evaluated. The authorizer variables are declared too,
+// as kubeenforcer backs them.
+func NewEnv(object, params *apiservercel.DeclType, hasParams bool) (*cel.Env, error) {
+	base, err := baseEnv()
+	if err != nil {
+		return nil, err
+	}
+	registry := apiservercel.NewRegistry(base)
+
+	var ruleTypes []*apiservercel.RuleTypes
+	var vars []cel.EnvOption
+	declare := func(declType *apiservercel.DeclType, names ...string) error {
+		var rt *apiservercel.RuleTypes
+		if declType != nil {
+			var err error
+			if rt, err = apiservercel.NewRuleTypes(declType.TypeName(), declType, registry); err != nil {
+				return err
+			}
+		}
+		if rt == nil {
+			for _, name := range names {
+				vars = append(vars, cel.Variable(name, cel.DynType))
+			}
+			return nil
+		}
+		ruleTypes = append(ruleTypes, rt)
+		for _, name := range names {
+			vars = append(vars, cel.Variable(name, declType.CelType()))
+		}
+		return nil
+	}
+
+	if err := declare(plugincel.BuildRequestType(), plugincel.RequestVarName); err != nil {
+		return nil, err
+	}
+	if err := declare(object, plugincel.ObjectVarName, plugincel.OldObjectVarName); err != nil {
+		return nil, err
+	}
+	if hasParams {
+		if err := declare(params, plugincel.ParamsVarName); err != nil {
+			return nil, err
+		}
+	}
+	vars = append(vars,
+		cel.Variable(plugincel.AuthorizerVarName, library.AuthorizerType),
+		cel.Variable(plugincel.RequestResourceAuthorizerVarName, library.ResourceCheckType),
+	)
+
+	opts, err := typeProviderOptions(ruleTypes, base.TypeProvider())
+	if err != nil {
+		return nil, err
+	}
+	// Variables must be declared after the types they refer to
+	return base.Extend(append(opts, vars...)...)
+}
+
+func typeProviderOptions(ruleTypes []*apiservercel.RuleTypes, underlying ref.TypeProvider) ([]cel.EnvOption, error) {
+	var providers []ref.TypeProvider
+	var adapters []ref.TypeAdapter
+	for _, rt := range ruleTypes {
+		withProvider, err := rt.WithTypeProvider(underlying)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, withProvider)
+		adapters = append(adapters, withProvider)
+	}
+
+	switch len(providers) {
+	case 0:
+		return nil, nil
+	case 1:
+		return []cel.EnvOption{cel.CustomTypeProvider(providers[0]), cel.CustomTypeAdapter(adapters[0])}, nil
+	default:
+		return []cel.EnvOption{
+			cel.CustomTypeProvider(&apiservercel.CompositedTypeProvider{Providers: providers}),
+			cel.CustomTypeAdapter(&apiservercel.CompositedTypeAdapter{Adapters: adapters}),
+		}, nil
+	}
+}
+
+var (
+	baseEnvOnce  sync.Once
+	baseEnvValue *cel.Env
+	baseEnvErr   error
+)
+
+// baseEnv returns the environment shared by all type checks, with the same
+// libraries as at evaluation time.
+func baseEnv() (*cel.Env, error) {
+	baseEnvOnce.Do(func() {
+		opts := []cel.EnvOption{
+			cel.HomogeneousAggregateLiterals(),
+			cel.EagerlyValidateDeclarations(true),
+			cel.DefaultUTCTimeZone(true),
+		}
+		opts = append(opts, library.ExtensionLibs...)
+		baseEnvValue, baseEnvErr = cel.NewEnv(opts...)
+	})
+	return baseEnvValue, baseEnvErr
+}
@@ -0,0 +1,113 @@
+package typecheck
+
+import (
+	"context"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+
+	"k8s.io/cel-admission-webhook/pkg/apis/admissionregistration.x-k8s.io/v1alpha1"
+	"k8s.io/cel-admission-webhook/pkg/generated/clientset/versioned"
+	informers "k8s.io/cel-admission-webhook/pkg/generated/informers/externalversions/admissionregistration.x-k8s.io/v1alpha1"
+	listers "k8s.io/cel-admission-webhook/pkg/generated/listers/admissionregistration.x-k8s.io/v1alpha1"
+)
+
+var logger klog.Logger = klog.LoggerWithName(klog.Background(), "typecheck")
+
+// Controller type checks every new generation of a policy and publishes the
+// warnings in its status.typeChecking.
+type Controller struct {
+	client    versioned.Interface
+	policies  listers.ValidatingAdmissionPolicyLister
+	hasSynced cache.InformerSynced
+	checker   *Checker
+	queue     workqueue.RateLimitingInterface
+}
+
+// New creates a Controller for the policies watched by policyInformer.
+func New(client versioned.Interface, policyInformer informers.ValidatingAdmissionPolicyInformer, checker *Checker) *Controller {
+	c := &Controller{
+		client:    client,
+		policies:  policyInformer.Lister(),
+		hasSynced: policyInformer.Informer().HasSynced,
+		checker:   checker,
+		queue:     workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+	}
+
+	enqueue := func(obj interface{}) {
+		if key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj); err == nil {
+			c.queue.Add(key)
+		}
+	}
+	policyInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    enqueue,
+		UpdateFunc: func(_, obj interface{}) { enqueue(obj) },
+	})
+
+	return c
+}
+
+// Run type checks policies until ctx is cancelled.
+func (c *Controller) Run(ctx context.Context) error {
+	defer c.queue.ShutDown()
+
+	logger.Info("starting policy type checking controller")
+	defer logger.Info("stopped policy type checking controller")
+
+	if !cache.WaitForCacheSync(ctx.Done(), c.hasSynced) {
+		return ctx.Err()
+	}
+
+	go func() {
+		<-ctx.Done()
+		c.queue.ShutDown()
+	}()
+
+	for c.processNext(ctx) {
+	}
+	return nil
+}
+
+func (c *Controller) processNext(ctx context.Context) bool {
+	key, shutdown := c.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer c.queue.Done(key)
+
+	if err := c.reconcile(ctx, key.(string)); err != nil {
+		logger.Error(err, "failed to type check policy", "policy", key)
+		c.queue.AddRateLimited(key)
+		return true
+	}
+	c.queue.Forget(key)
+	return true
+}
+
+func (c *Controller) reconcile(ctx context.Context, name string) error {
+	policy, err := c.policies.Get(name)
+	if k8serrors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if policy.Status.ObservedGeneration == policy.Generation && policy.Status.TypeChecking != nil {
+		return nil
+	}
+
+	typeChecking := &v1alpha1.TypeChecking{ExpressionWarnings: c.checker.Check(policy)}
+
+	updated := policy.DeepCopy()
+	updated.Status.ObservedGeneration = policy.Generation
+	updated.Status.TypeChecking = typeChecking
+	if _, err := c.client.AdmissionregistrationV1alpha1().ValidatingAdmissionPolicies().UpdateStatus(ctx, updated, metav1.UpdateOptions{}); err != nil {
+		return err
+	}
+	if len(typeChecking.ExpressionWarnings) > 0 {
+		logger.Info("policy has type checking warnings", "policy", policy.Name, "warnings", len(typeChecking.ExpressionWarnings))
+	}
+	return nil
+}
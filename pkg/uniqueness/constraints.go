@@ -0,0 +1,109 @@
+package uniqueness
+
+import (
+	"fmt"
+	"os"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/util/jsonpath"
+	"sigs.k8s.io/yaml"
+)
+
+// Config is the uniqueness constraint configuration file.
+type Config struct {
+	Constraints []Constraint `json:"constraints"`
+}
+
+// Constraint requires the values of fields of a resource to be unique
+// across all its objects.
+type Constraint struct {
+	// Name identifies the constraint in denials and logs.
+	Name string `json:"name"`
+
+	// Group, Version and Resource select the constrained resource, e.g.
+	// networking.k8s.io, v1 and ingresses.
+	Group    string `json:"group"`
+	Version  string `json:"version"`
+	Resource string `json:"resource"`
+
+	// Fields are JSONPath templates of the unique values, e.g.
+	// {.spec.rules[*].host}. All their values share one set, and empty
+	// values are ignored.
+	Fields []string `json:"fields"`
+
+	// Namespaced only requires values to be unique within each namespace.
+	Namespaced bool `json:"namespaced,omitempty"`
+
+	// Message replaces the default denial message.
+	Message string `json:"message,omitempty"`
+}
+
+// GroupVersionResource returns the constrained resource.
+func (c *Constraint) GroupVersionResource() schema.GroupVersionResource {
+	return schema.GroupVersionResource{Group: c.Group, Version: c.Version, Resource: c.Resource}
+}
+
+// values returns the non-empty values of the fields of object.
+func (c *Constraint) values(object map[string]interface{}) ([]string, error) {
+	seen := map[string]bool{}
+	var values []string
+	for _, field := range c.Fields {
+		path := jsonpath.New(c.Name).AllowMissingKeys(true)
+		if err := path.Parse(field); err != nil {
+			return nil, err
+		}
+		results, err := path.FindResults(object)
+		if err != nil {
+			return nil, err
+		}
+		for _, result := range results {
+			for _, v := range result {
+				value := fmt.Sprint(v.Interface())
+				if value == "" || seen[value] {
+					continue
+				}
+				seen[value] = true
+				values = append(values, value)
+			}
+		}
+	}
+	return values, nil
+}
+
+// LoadConstraints reads and validates the constraints in the configuration
+// file at path.
+func LoadConstraints(path string) ([]Constraint, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config Config
+	if err := yaml.UnmarshalStrict(raw, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	names := map[string]bool{}
+	for i := range config.Constraints {
+		c := &config.Constraints[i]
+		if c.Name == "" {
+			return nil, fmt.Errorf("constraint %d has no name", i)
+		}
+		if names[c.Name] {
+			return nil, fmt.Errorf("duplicate constraint %s", c.Name)
+		}
+		names[c.Name] = true
+
+		if c.Version == "" || c.Resource == "" {
+			return nil, fmt.Errorf("constraint %s: version and resource are required", c.Name)
+		}
+		if len(c.Fields) == 0 {
+			return nil, fmt.Errorf("constraint %s selects no fields", c.Name)
+		}
+		for _, field := range c.Fields {
+			if err := jsonpath.New(c.Name).Parse(field); err != nil {
+				return nil, fmt.Errorf("constraint %s: invalid field %s: %w", c.Name, field, err)
+			}
+		}
+	}
+	return config.Constraints, nil
+}
@@ -0,0 +1,167 @@
+package uniqueness
+
+import (
+	"context"
+	"fmt"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apiserver/pkg/admission"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+var logger klog.Logger = klog.LoggerWithName(klog.Background(), "uniqueness")
+
+type indexedConstraint struct {
+	Constraint
+	indexer   cache.Indexer
+	hasSynced cache.InformerSynced
+}
+
+// Validator denies creating or updating objects with a value already used
+// by another object of the same resource, according to constraints. Values
+// are looked up in indexes of informers watching the constrained resources,
+// so two objects with the same value admitted at the same time may both be
+// allowed.
+type Validator struct {
+	constraints []indexedConstraint
+}
+
+// New creates a Validator for constraints loaded with LoadConstraints,
+// adding an index per constraint to the informers of factory, which must be
+// started afterwards.
+func New(factory dynamicinformer.DynamicSharedInformerFactory, constraints []Constraint) (*Validator, error) {
+	v := &Validator{}
+	for _, c := range constraints {
+		c := c
+		informer := factory.ForResource(c.GroupVersionResource()).Informer()
+		err := informer.AddIndexers(cache.Indexers{c.Name: func(obj interface{}) ([]string, error) {
+			u, ok := obj.(*unstructured.Unstructured)
+			if !ok {
+				return nil, nil
+			}
+			values, err := c.values(u.UnstructuredContent())
+			if err != nil {
+				return nil, err
+			}
+			return c.keys(u.GetNamespace(), values), nil
+		}})
+		if err != nil {
+			return nil, fmt.Errorf("constraint %s: %w", c.Name, err)
+		}
+		v.constraints = append(v.constraints, indexedConstraint{
+			Constraint: c,
+			indexer:    informer.GetIndexer(),
+			hasSynced:  informer.HasSynced,
+		})
+	}
+	return v, nil
+}
+
+// keys returns the index keys of values, which are prefixed with namespace
+// for namespaced constraints.
+func (c *Constraint) keys(namespace string, values []string) []string {
+	if !c.Namespaced {
+		return values
+	}
+	keys := make([]string, len(values))
+	for i, value := range values {
+		keys[i] = namespace + "/" + value
+	}
+	return keys
+}
+
+// Rules returns the webhook rules sending the constrained resources to the
+// Validator.
+func (v *Validator) Rules() []admissionregistrationv1.RuleWithOperations {
+	rules := make([]admissionregistrationv1.RuleWithOperations, 0, len(v.constraints))
+	for _, c := range v.constraints {
+		rules = append(rules, admissionregistrationv1.RuleWithOperations{
+			Operations: []admissionregistrationv1.OperationType{admissionregistrationv1.Create, admissionregistrationv1.Update},
+			Rule: admissionregistrationv1.Rule{
+				APIGroups:   []string{c.Group},
+				APIVersions: []string{c.Version},
+				Resources:   []string{c.Resource},
+			},
+		})
+	}
+	return rules
+}
+
+func (v *Validator) Handles(operation admission.Operation) bool {
+	return operation == admission.Create || operation == admission.Update
+}
+
+// Health returns an error until the informers of every constraint are
+// synced.
+func (v *Validator) Health() ([]string, error) {
+	for _, c := range v.constraints {
+		if !c.hasSynced() {
+			return nil, fmt.Errorf("uniqueness constraint %s is not synced yet", c.Name)
+		}
+	}
+	return nil, nil
+}
+
+func (v *Validator) Validate(ctx context.Context, a admission.Attributes, o admission.ObjectInterfaces) error {
+	if a.GetSubresource() != "" {
+		return nil
+	}
+
+	var object map[string]interface{}
+	for _, c := range v.constraints {
+		if c.GroupVersionResource() != a.GetResource() {
+			continue
+		}
+		if !c.hasSynced() {
+			return fmt.Errorf("uniqueness constraint %s is not synced yet", c.Name)
+		}
+
+		if object == nil {
+			var err error
+			if object, err = runtime.DefaultUnstructuredConverter.ToUnstructured(a.GetObject()); err != nil {
+				return err
+			}
+		}
+		values, err := c.values(object)
+		if err != nil {
+			return fmt.Errorf("uniqueness constraint %s: %w", c.Name, err)
+		}
+
+		keys := c.keys(a.GetNamespace(), values)
+		for i, key := range keys {
+			items, err := c.indexer.ByIndex(c.Name, key)
+			if err != nil {
+				return err
+			}
+			for _, item := range items {
+				other, err := meta.Accessor(item)
+				if err != nil {
+					return err
+				}
+				if other.GetNamespace() == a.GetNamespace() && other.GetName() == a.GetName() {
+					continue
+				}
+
+				owner := other.GetName()
+				if other.GetNamespace() != "" {
+					owner = other.GetNamespace() + "/" + owner
+				}
+				logger.V(2).Info("denied duplicate value", "constraint", c.Name, "value", values[i], "object", a.GetName(), "namespace", a.GetNamespace(), "owner", owner)
+				return admission.NewForbidden(a, fmt.Errorf("%s: %q is already used by %s", c.message(), values[i], owner))
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Constraint) message() string {
+	if c.Message != "" {
+		return c.Message
+	}
+	return fmt.Sprintf("uniqueness constraint %s violated", c.Name)
+}
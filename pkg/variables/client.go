@@ -0,0 +1,132 @@
+package variables
+
+import (
+	"context"
+	"errors"
+
+	"k8s.io/api/admissionregistration/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	admissionregistrationv1alpha1 "k8s.io/client-go/kubernetes/typed/admissionregistration/v1alpha1"
+)
+
+// NewClient returns a client whose ValidatingAdmissionPolicies have the
+// variables they declare expanded into their expressions, so an evaluator
+// built on it supports variables. Variables are bound with memo, so each is
+// computed once per request, or by each expression referring to it if memo
+// is nil. Policies whose variables cannot be expanded are returned as they
+// are, failing to compile where they refer to variables.
+func NewClient(client kubernetes.Interface, memo Memo) kubernetes.Interface {
+	return expandingClient{Interface: client, memo: memo}
+}
+
+type expandingClient struct {
+	kubernetes.Interface
+	memo Memo
+}
+
+func (c expandingClient) AdmissionregistrationV1alpha1() admissionregistrationv1alpha1.AdmissionregistrationV1alpha1Interface {
+	return expandingGroup{AdmissionregistrationV1alpha1Interface: c.Interface.AdmissionregistrationV1alpha1(), memo: c.memo}
+}
+
+type expandingGroup struct {
+	admissionregistrationv1alpha1.AdmissionregistrationV1alpha1Interface
+	memo Memo
+}
+
+func (g expandingGroup) ValidatingAdmissionPolicies() admissionregistrationv1alpha1.ValidatingAdmissionPolicyInterface {
+	return expandingPolicies{ValidatingAdmissionPolicyInterface: g.AdmissionregistrationV1alpha1Interface.ValidatingAdmissionPolicies(), memo: g.memo}
+}
+
+type expandingPolicies struct {
+	admissionregistrationv1alpha1.ValidatingAdmissionPolicyInterface
+	memo Memo
+}
+
+func (p expandingPolicies) Get(ctx context.Context, name string, opts metav1.GetOptions) (*v1alpha1.ValidatingAdmissionPolicy, error) {
+	policy, err := p.ValidatingAdmissionPolicyInterface.Get(ctx, name, opts)
+	if err != nil {
+		return nil, err
+	}
+	return expanded(policy, p.memo), nil
+}
+
+func (p expandingPolicies) List(ctx context.Context, opts metav1.ListOptions) (*v1alpha1.ValidatingAdmissionPolicyList, error) {
+	list, err := p.ValidatingAdmissionPolicyInterface.List(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	for i := range list.Items {
+		list.Items[i] = *expanded(&list.Items[i], p.memo)
+	}
+	return list, nil
+}
+
+func (p expandingPolicies) Watch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error) {
+	w, err := p.ValidatingAdmissionPolicyInterface.Watch(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return watch.Filter(w, func(event watch.Event) (watch.Event, bool) {
+		if policy, ok := event.Object.(*v1alpha1.ValidatingAdmissionPolicy); ok {
+			event.Object = expanded(policy, p.memo)
+		}
+		return event, true
+	}), nil
+}
+
+// expanded returns policy with its variables expanded and bound with memo,
+// or as it is if they cannot be.
+func expanded(policy *v1alpha1.ValidatingAdmissionPolicy, memo Memo) *v1alpha1.ValidatingAdmissionPolicy {
+	out, err := expandPolicy(policy, memo)
+	if err != nil {
+		logger.Error(err, "failed to expand policy variables", "policy", policy.Name)
+		return policy
+	}
+	return out
+}
+
+// ExpandPolicy returns a copy of policy with the variables it declares
+// expanded into its expressions, or policy if it declares none.
+func ExpandPolicy(policy *v1alpha1.ValidatingAdmissionPolicy) (*v1alpha1.ValidatingAdmissionPolicy, error) {
+	return expandPolicy(policy, nil)
+}
+
+func expandPolicy(policy *v1alpha1.ValidatingAdmissionPolicy, memo Memo) (*v1alpha1.ValidatingAdmissionPolicy, error) {
+	vars, err := Parse(policy.Annotations)
+	if err != nil {
+		return nil, err
+	}
+	if len(vars) == 0 {
+		return policy, nil
+	}
+
+	out := policy.DeepCopy()
+	var errs []error
+	expand := func(expression *string) {
+		if *expression == "" {
+			return
+		}
+		expanded, err := expand(vars, *expression, memo)
+		if err != nil {
+			errs = append(errs, err)
+			return
+		}
+		*expression = expanded
+	}
+	for i := range out.Spec.MatchConditions {
+		expand(&out.Spec.MatchConditions[i].Expression)
+	}
+	for i := range out.Spec.Validations {
+		expand(&out.Spec.Validations[i].Expression)
+		expand(&out.Spec.Validations[i].MessageExpression)
+	}
+	for i := range out.Spec.AuditAnnotations {
+		expand(&out.Spec.AuditAnnotations[i].ValueExpression)
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return out, nil
+}
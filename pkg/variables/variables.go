@@ -0,0 +1,267 @@
+package variables
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	exprpb "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+)
+
+var logger klog.Logger = klog.LoggerWithName(klog.Background(), "variables")
+
+// Annotation declares the variables of a policy, as a YAML or JSON list of
+// names and expressions, e.g.
+//
+//	[{"name": "images", "expression": "object.spec.containers.map(c, c.image)"}]
+//
+// Expressions of the policy refer to them as variables.<name>.
+const Annotation = "kubeenforcer.kubescape.io/variables"
+
+// Variable is a named expression of a policy.
+type Variable struct {
+	Name       string `json:"name"`
+	Expression string `json:"expression"`
+}
+
+var namePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// Parse returns the variables declared in annotations, none if the
+// annotation is not set.
+func Parse(annotations map[string]string) ([]Variable, error) {
+	value, ok := annotations[Annotation]
+	if !ok {
+		return nil, nil
+	}
+	var vars []Variable
+	if err := yaml.UnmarshalStrict([]byte(value), &vars); err != nil {
+		return nil, fmt.Errorf("invalid %s annotation: %w", Annotation, err)
+	}
+	seen := map[string]bool{}
+	for _, v := range vars {
+		if !namePattern.MatchString(v.Name) {
+			return nil, fmt.Errorf("invalid variable name %q: must be a CEL identifier", v.Name)
+		}
+		if seen[v.Name] {
+			return nil, fmt.Errorf("variable %q is declared more than once", v.Name)
+		}
+		if strings.TrimSpace(v.Expression) == "" {
+			return nil, fmt.Errorf("variable %q has no expression", v.Name)
+		}
+		seen[v.Name] = true
+	}
+	return vars, nil
+}
+
+// Expand returns expression with the variables it refers to, directly or
+// through other variables, bound in comprehensions around it, so each is
+// computed once per evaluation of expression however many times it is
+// referred to. Variables are bound in the order they are declared, and may
+// only refer to the ones declared before them. expression is returned
+// unchanged if it refers to no variable.
+//
+// A variable is bound as a field of a map named variables, with a level per
+// variable: [{'a': A}].map(variables, [{'a': variables.a, 'b': B}].map(
+// variables, expression)[0])[0].
+//
+// Variables expanded by Expand are not shared between expressions: each
+// expression referring to a variable computes it again. The clients of
+// NewClient bind them with a Memo instead.
+func Expand(vars []Variable, expression string) (string, error) {
+	return expand(vars, expression, nil)
+}
+
+// Memo marks expression to be computed once per object, old object, request
+// and params for all the expressions marked with key, e.g. exprcache.Memo.
+type Memo func(key, expression string) string
+
+// expand is Expand with each variable bound to its expression marked with
+// memo, if not nil, keyed by the hash of its declaration and of the
+// declarations of the variables it refers to, so the expressions of a
+// policy, and of the policies declaring the same variable, compute it once
+// per request. Variables referring to the authorizer, directly or through
+// other variables, are computed by each expression, since their results
+// depend on the authorizer of the request.
+func expand(vars []Variable, expression string, memo Memo) (string, error) {
+	if len(vars) == 0 {
+		return expression, nil
+	}
+	index := map[string]int{}
+	for i, v := range vars {
+		index[v.Name] = i
+	}
+
+	refs, _, err := references(expression, vars)
+	if err != nil {
+		return "", err
+	}
+	if len(refs) == 0 {
+		return expression, nil
+	}
+	used := make([]bool, len(vars))
+	// deps holds the variables each used variable refers to, and authorizer
+	// whether it refers to the authorizer, directly or through them
+	deps := make([][]int, len(vars))
+	authorizer := make([]bool, len(vars))
+	pending := refs
+	for len(pending) > 0 {
+		name := pending[0]
+		pending = pending[1:]
+		i := index[name]
+		if used[i] {
+			continue
+		}
+		used[i] = true
+		varRefs, usesAuthorizer, err := references(vars[i].Expression, vars)
+		if err != nil {
+			return "", fmt.Errorf("variable %q: %w", name, err)
+		}
+		for _, ref := range varRefs {
+			if index[ref] >= i {
+				return "", fmt.Errorf("variable %q refers to variable %q, which is not declared before it", name, ref)
+			}
+			deps[i] = append(deps[i], index[ref])
+		}
+		authorizer[i] = usesAuthorizer
+		pending = append(pending, varRefs...)
+	}
+
+	// closure holds the variables each used variable depends on, itself
+	// included; variables only depend on the ones declared before them
+	closure := make([]map[int]bool, len(vars))
+	for i := range vars {
+		if !used[i] {
+			continue
+		}
+		closure[i] = map[int]bool{i: true}
+		for _, j := range deps[i] {
+			for k := range closure[j] {
+				closure[i][k] = true
+			}
+			authorizer[i] = authorizer[i] || authorizer[j]
+		}
+	}
+
+	var bound []string
+	var levels []string
+	for i, v := range vars {
+		if !used[i] {
+			continue
+		}
+		fields := make([]string, 0, len(bound)+1)
+		for _, name := range bound {
+			fields = append(fields, fmt.Sprintf("'%s': variables.%s", name, name))
+		}
+		value := "(" + v.Expression + ")"
+		if memo != nil && !authorizer[i] {
+			value = memo(key(vars, closure[i]), v.Expression)
+		}
+		fields = append(fields, fmt.Sprintf("'%s': %s", v.Name, value))
+		levels = append(levels, "[{"+strings.Join(fields, ", ")+"}].map(variables, ")
+		bound = append(bound, v.Name)
+	}
+	return strings.Join(levels, "") + "(" + expression + ")" + strings.Repeat(")[0]", len(levels)), nil
+}
+
+// key returns the memo key of the variable depending on the variables of
+// vars in closure, the hash of their declarations in order.
+func key(vars []Variable, closure map[int]bool) string {
+	h := sha256.New()
+	for i, v := range vars {
+		if closure[i] {
+			fmt.Fprintf(h, "%d:%s%d:%s", len(v.Name), v.Name, len(v.Expression), v.Expression)
+		}
+	}
+	return "variable:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// references returns the names of the variables expression refers to, all
+// of them if it uses the variables map as a whole, and whether it refers to
+// the authorizer.
+func references(expression string, vars []Variable) ([]string, bool, error) {
+	env, err := cel.NewEnv()
+	if err != nil {
+		return nil, false, err
+	}
+	ast, issues := env.Parse(expression)
+	if issues != nil && issues.Err() != nil {
+		return nil, false, issues.Err()
+	}
+	declared := map[string]bool{}
+	for _, v := range vars {
+		declared[v.Name] = true
+	}
+
+	var refs []string
+	var undeclared error
+	all := false
+	authorizer := false
+	var walk func(e *exprpb.Expr)
+	walk = func(e *exprpb.Expr) {
+		if e == nil {
+			return
+		}
+		switch k := e.ExprKind.(type) {
+		case *exprpb.Expr_IdentExpr:
+			// The map as a whole, e.g. indexed
+			if k.IdentExpr.Name == "variables" {
+				all = true
+			}
+			if k.IdentExpr.Name == "authorizer" {
+				authorizer = true
+			}
+		case *exprpb.Expr_SelectExpr:
+			if isVariables(k.SelectExpr.Operand) && !k.SelectExpr.TestOnly {
+				if !declared[k.SelectExpr.Field] {
+					undeclared = fmt.Errorf("undeclared variable %q", k.SelectExpr.Field)
+				}
+				refs = append(refs, k.SelectExpr.Field)
+				return
+			}
+			walk(k.SelectExpr.Operand)
+		case *exprpb.Expr_CallExpr:
+			walk(k.CallExpr.Target)
+			for _, arg := range k.CallExpr.Args {
+				walk(arg)
+			}
+		case *exprpb.Expr_ListExpr:
+			for _, elem := range k.ListExpr.Elements {
+				walk(elem)
+			}
+		case *exprpb.Expr_StructExpr:
+			for _, entry := range k.StructExpr.Entries {
+				walk(entry.GetMapKey())
+				walk(entry.Value)
+			}
+		case *exprpb.Expr_ComprehensionExpr:
+			c := k.ComprehensionExpr
+			walk(c.IterRange)
+			walk(c.AccuInit)
+			walk(c.LoopCondition)
+			walk(c.LoopStep)
+			walk(c.Result)
+		}
+	}
+	walk(ast.Expr())
+	if undeclared != nil {
+		return nil, false, undeclared
+	}
+	if all {
+		refs = refs[:0]
+		for _, v := range vars {
+			refs = append(refs, v.Name)
+		}
+	}
+	return refs, authorizer, nil
+}
+
+// isVariables returns whether e is the variables map.
+func isVariables(e *exprpb.Expr) bool {
+	ident, ok := e.ExprKind.(*exprpb.Expr_IdentExpr)
+	return ok && ident.IdentExpr.Name == "variables"
+}
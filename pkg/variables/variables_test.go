@@ -0,0 +1,300 @@
+package variables
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name       string
+		annotation *string
+		want       []Variable
+		wantErr    string
+	}{
+		{
+			name: "no annotation",
+		},
+		{
+			name:       "variables",
+			annotation: strPtr(`[{"name": "images", "expression": "object.spec.containers.map(c, c.image)"}, {"name": "latest", "expression": "variables.images.exists(i, i.endsWith(':latest'))"}]`),
+			want: []Variable{
+				{Name: "images", Expression: "object.spec.containers.map(c, c.image)"},
+				{Name: "latest", Expression: "variables.images.exists(i, i.endsWith(':latest'))"},
+			},
+		},
+		{
+			name:       "YAML",
+			annotation: strPtr("- name: replicas\n  expression: object.spec.replicas\n"),
+			want:       []Variable{{Name: "replicas", Expression: "object.spec.replicas"}},
+		},
+		{
+			name:       "unknown field",
+			annotation: strPtr(`[{"name": "replicas", "expr": "object.spec.replicas"}]`),
+			wantErr:    "invalid " + Annotation + " annotation",
+		},
+		{
+			name:       "name that is not an identifier",
+			annotation: strPtr(`[{"name": "spec-replicas", "expression": "object.spec.replicas"}]`),
+			wantErr:    `invalid variable name "spec-replicas"`,
+		},
+		{
+			name:       "declared twice",
+			annotation: strPtr(`[{"name": "replicas", "expression": "1"}, {"name": "replicas", "expression": "2"}]`),
+			wantErr:    `variable "replicas" is declared more than once`,
+		},
+		{
+			name:       "empty expression",
+			annotation: strPtr(`[{"name": "replicas", "expression": " "}]`),
+			wantErr:    `variable "replicas" has no expression`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			annotations := map[string]string{}
+			if tt.annotation != nil {
+				annotations[Annotation] = *tt.annotation
+			}
+			got, err := Parse(annotations)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Parse() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpand(t *testing.T) {
+	vars := []Variable{
+		{Name: "containers", Expression: "object.spec.containers"},
+		{Name: "images", Expression: "variables.containers.map(c, c.image)"},
+		{Name: "replicas", Expression: "object.spec.replicas"},
+		{Name: "allowed", Expression: "authorizer.requestResource.check('create').allowed()"},
+		{Name: "checked", Expression: "variables.allowed && variables.replicas > 1"},
+	}
+	// memo marks expressions with the start of their key, which hashes the
+	// variables they depend on
+	memo := func(key, expression string) string {
+		return fmt.Sprintf("memo(%s, (%s))", strings.TrimPrefix(key, "variable:")[:8], expression)
+	}
+	keyOf := func(names ...string) string {
+		closure := map[int]bool{}
+		for i, v := range vars {
+			for _, name := range names {
+				if v.Name == name {
+					closure[i] = true
+				}
+			}
+		}
+		return strings.TrimPrefix(key(vars, closure), "variable:")[:8]
+	}
+
+	tests := []struct {
+		name       string
+		vars       []Variable
+		expression string
+		memo       Memo
+		want       string
+		wantErr    string
+	}{
+		{
+			name:       "no variables",
+			expression: "object.spec.replicas > 1",
+			want:       "object.spec.replicas > 1",
+		},
+		{
+			name:       "no reference",
+			vars:       vars,
+			expression: "object.spec.replicas > 1",
+			want:       "object.spec.replicas > 1",
+		},
+		{
+			name:       "unreferenced variables are not bound",
+			vars:       vars,
+			expression: "variables.replicas > 1",
+			want:       "[{'replicas': (object.spec.replicas)}].map(variables, (variables.replicas > 1))[0]",
+		},
+		{
+			name:       "chained variables",
+			vars:       vars,
+			expression: "variables.images.all(i, i.startsWith('registry.example.com/'))",
+			want: "[{'containers': (object.spec.containers)}].map(variables, " +
+				"[{'containers': variables.containers, 'images': (variables.containers.map(c, c.image))}].map(variables, " +
+				"(variables.images.all(i, i.startsWith('registry.example.com/'))))[0])[0]",
+		},
+		{
+			name:       "variables map as a whole",
+			vars:       vars[:1],
+			expression: "size(variables) == 1",
+			want:       "[{'containers': (object.spec.containers)}].map(variables, (size(variables) == 1))[0]",
+		},
+		{
+			name:       "unknown variable",
+			vars:       vars,
+			expression: "variables.image == ''",
+			wantErr:    `undeclared variable "image"`,
+		},
+		{
+			name:       "variable referring to an unknown variable",
+			vars:       []Variable{{Name: "images", Expression: "variables.containers.map(c, c.image)"}},
+			expression: "size(variables.images) > 0",
+			wantErr:    `variable "images": undeclared variable "containers"`,
+		},
+		{
+			name:       "variable referring to a later variable",
+			vars:       []Variable{{Name: "images", Expression: "variables.containers.map(c, c.image)"}, {Name: "containers", Expression: "object.spec.containers"}},
+			expression: "size(variables.images) > 0",
+			wantErr:    `variable "images" refers to variable "containers", which is not declared before it`,
+		},
+		{
+			name:       "invalid expression",
+			vars:       vars,
+			expression: "variables.replicas >",
+			wantErr:    "Syntax error",
+		},
+		{
+			name:       "memoized chained variables",
+			vars:       vars,
+			memo:       memo,
+			expression: "size(variables.images) > 0",
+			want: "[{'containers': memo(" + keyOf("containers") + ", (object.spec.containers))}].map(variables, " +
+				"[{'containers': variables.containers, 'images': memo(" + keyOf("containers", "images") + ", (variables.containers.map(c, c.image)))}].map(variables, " +
+				"(size(variables.images) > 0))[0])[0]",
+		},
+		{
+			name:       "variables referring to the authorizer are not memoized",
+			vars:       vars,
+			memo:       memo,
+			expression: "variables.checked",
+			want: "[{'replicas': memo(" + keyOf("replicas") + ", (object.spec.replicas))}].map(variables, " +
+				"[{'replicas': variables.replicas, 'allowed': (authorizer.requestResource.check('create').allowed())}].map(variables, " +
+				"[{'replicas': variables.replicas, 'allowed': variables.allowed, 'checked': (variables.allowed && variables.replicas > 1)}].map(variables, " +
+				"(variables.checked))[0])[0])[0]",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := expand(tt.vars, tt.expression, tt.memo)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expand() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("expand() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKey(t *testing.T) {
+	vars := []Variable{
+		{Name: "containers", Expression: "object.spec.containers"},
+		{Name: "images", Expression: "variables.containers.map(c, c.image)"},
+	}
+	// The same variable of another policy declaring other variables before it
+	other := []Variable{
+		{Name: "replicas", Expression: "object.spec.replicas"},
+		{Name: "containers", Expression: "object.spec.containers"},
+		{Name: "images", Expression: "variables.containers.map(c, c.image)"},
+	}
+	// A variable of the same name referring to another definition
+	redefined := []Variable{
+		{Name: "containers", Expression: "object.spec.initContainers"},
+		{Name: "images", Expression: "variables.containers.map(c, c.image)"},
+	}
+
+	images := key(vars, map[int]bool{0: true, 1: true})
+	if got := key(other, map[int]bool{1: true, 2: true}); got != images {
+		t.Errorf("key() of the same declarations = %s, want %s", got, images)
+	}
+	if got := key(redefined, map[int]bool{0: true, 1: true}); got == images {
+		t.Errorf("key() of a variable referring to another definition = %s, want another key", got)
+	}
+	if got := key(vars, map[int]bool{0: true}); got == images {
+		t.Errorf("key() of another variable = %s, want another key", got)
+	}
+}
+
+func TestReferences(t *testing.T) {
+	vars := []Variable{
+		{Name: "containers", Expression: "object.spec.containers"},
+		{Name: "images", Expression: "variables.containers.map(c, c.image)"},
+	}
+	tests := []struct {
+		name       string
+		expression string
+		want       []string
+		authorizer bool
+		wantErr    string
+	}{
+		{
+			name:       "no reference",
+			expression: "object.spec.replicas > 1",
+		},
+		{
+			name:       "references",
+			expression: "variables.images.all(i, i in variables.images && size(variables.containers) > 0)",
+			want:       []string{"images", "images", "containers"},
+		},
+		{
+			// has() tests the map, so all the variables are bound
+			name:       "presence test",
+			expression: "has(variables.images)",
+			want:       []string{"containers", "images"},
+		},
+		{
+			name:       "variables map as a whole",
+			expression: "variables['images'].size() > 0",
+			want:       []string{"containers", "images"},
+		},
+		{
+			name:       "authorizer",
+			expression: "authorizer.requestResource.check('create').allowed()",
+			authorizer: true,
+		},
+		{
+			name:       "unknown variable",
+			expression: "variables.replicas > 1",
+			wantErr:    `undeclared variable "replicas"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, authorizer, err := references(tt.expression, vars)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("references() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("references() = %v, want %v", got, tt.want)
+			}
+			if authorizer != tt.authorizer {
+				t.Errorf("references() authorizer = %v, want %v", authorizer, tt.authorizer)
+			}
+		})
+	}
+}
+
+func strPtr(s string) *string {
+	return &s
+}
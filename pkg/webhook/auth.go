@@ -0,0 +1,275 @@
+package webhook
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/cache"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/flowcontrol"
+	"k8s.io/klog/v2"
+)
+
+// AuthOptions configures how callers of /validate authenticate. A request is
+// accepted if it passes any of the configured methods; authentication is
+// disabled if none is configured.
+type AuthOptions struct {
+	// Token is a static bearer token.
+	Token string
+
+	// TokenReviewClient validates bearer tokens with the TokenReview API,
+	// e.g. the API server's service account token.
+	TokenReviewClient kubernetes.Interface
+	// Audiences the reviewed token must be valid for, one of which the review
+	// must return. Any if empty.
+	Audiences []string
+	// Users allowed to call the webhook with a reviewed token. Any
+	// authenticated user if empty.
+	Users []string
+
+	// ClientCAFile verifies client certificates. Requests with a verified
+	// client certificate are accepted.
+	ClientCAFile string
+
+	// AdminUsers and AdminGroups may call the admin endpoints, authenticated
+	// with a reviewed token, or with a client certificate naming the user in
+	// its common name and the groups in its organizations. The admin
+	// endpoints are not served if both are empty.
+	AdminUsers  []string
+	AdminGroups []string
+}
+
+func (o AuthOptions) enabled() bool {
+	return o.Token != "" || o.TokenReviewClient != nil || o.ClientCAFile != ""
+}
+
+func (o AuthOptions) adminEnabled() bool {
+	return len(o.AdminUsers) > 0 || len(o.AdminGroups) > 0
+}
+
+func loadClientCAs(file string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", file)
+	}
+	return pool, nil
+}
+
+const (
+	// tokenReviewCacheTTL is how long an authenticated TokenReview result is
+	// reused, so the API server is not asked to review its own token on every
+	// admission.
+	tokenReviewCacheTTL = time.Minute
+	// tokenReviewCacheSize bounds the number of cached reviews, the least
+	// recently used being evicted first.
+	tokenReviewCacheSize = 1024
+
+	// Reviews of tokens that are not cached, failed ones included since they
+	// are not, are limited to tokenReviewQPS with bursts of tokenReviewBurst,
+	// so unauthenticated clients cannot have the API server review a token
+	// per request. Requests beyond the limit are refused with 429.
+	tokenReviewQPS   = 10
+	tokenReviewBurst = 50
+)
+
+// errTooManyReviews is returned when a token cannot be reviewed because of
+// the review rate limit.
+var errTooManyReviews = errors.New("too many token reviews")
+
+type authenticator struct {
+	opts   AuthOptions
+	logger klog.Logger
+	// selfTest requests are accepted, if set
+	selfTest *selfTest
+
+	// reviews caches the callers of authenticated tokens by token hash
+	reviews *cache.LRUExpireCache
+	limiter flowcontrol.PassiveRateLimiter
+}
+
+// caller is the authenticated caller of a request. Callers authenticated
+// with the static token or the self-test token have no name.
+type caller struct {
+	name   string
+	groups []string
+	// reviewed is set on callers authenticated with a reviewed token
+	reviewed bool
+}
+
+func newAuthenticator(opts AuthOptions, logger klog.Logger) *authenticator {
+	return &authenticator{
+		opts:    opts,
+		logger:  logger,
+		reviews: cache.NewLRUExpireCache(tokenReviewCacheSize),
+		limiter: flowcontrol.NewTokenBucketPassiveRateLimiter(tokenReviewQPS, tokenReviewBurst),
+	}
+}
+
+// wrap rejects unauthenticated requests before they reach next.
+func (a *authenticator) wrap(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		allowed, err := a.authenticate(req)
+		if err != nil {
+			a.fail(w, req, err)
+			return
+		}
+		if !allowed {
+			a.logger.V(2).Info("rejected unauthenticated request", "remote", req.RemoteAddr)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, req)
+	}
+}
+
+// wrapAdmin rejects requests before they reach next unless their caller is
+// one of the admin users or groups.
+func (a *authenticator) wrapAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		c, err := a.caller(req)
+		if err != nil {
+			a.fail(w, req, err)
+			return
+		}
+		if c == nil {
+			a.logger.V(2).Info("rejected unauthenticated request", "remote", req.RemoteAddr)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !a.isAdmin(c) {
+			a.logger.V(2).Info("rejected admin request", "remote", req.RemoteAddr, "user", c.name, "path", req.URL.Path)
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, req)
+	}
+}
+
+// fail responds to req, which could not be authenticated because of err.
+func (a *authenticator) fail(w http.ResponseWriter, req *http.Request, err error) {
+	if errors.Is(err, errTooManyReviews) {
+		a.logger.V(2).Info("rejected request over the token review rate limit", "remote", req.RemoteAddr)
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+	a.logger.Error(err, "failed to authenticate request", "remote", req.RemoteAddr)
+	http.Error(w, "authentication failed", http.StatusInternalServerError)
+}
+
+func (a *authenticator) isAdmin(c *caller) bool {
+	if c.name == "" {
+		return false
+	}
+	if contains(a.opts.AdminUsers, c.name) {
+		return true
+	}
+	for _, group := range c.groups {
+		if contains(a.opts.AdminGroups, group) {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticate reports whether req may call /validate: any caller, except
+// that callers with reviewed tokens must be one of the users, if set.
+func (a *authenticator) authenticate(req *http.Request) (bool, error) {
+	c, err := a.caller(req)
+	if err != nil || c == nil {
+		return false, err
+	}
+	if c.reviewed && len(a.opts.Users) > 0 {
+		return contains(a.opts.Users, c.name), nil
+	}
+	return true, nil
+}
+
+// caller returns who made req, or nil if it is not authenticated.
+func (a *authenticator) caller(req *http.Request) (*caller, error) {
+	if a.selfTest.is(req) {
+		return &caller{}, nil
+	}
+	if a.opts.ClientCAFile != "" && req.TLS != nil && len(req.TLS.VerifiedChains) > 0 {
+		subject := req.TLS.VerifiedChains[0][0].Subject
+		return &caller{name: subject.CommonName, groups: subject.Organization}, nil
+	}
+
+	token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return nil, nil
+	}
+
+	if a.opts.Token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(a.opts.Token)) == 1 {
+		return &caller{}, nil
+	}
+	if a.opts.TokenReviewClient != nil {
+		return a.review(req.Context(), token)
+	}
+	return nil, nil
+}
+
+// review returns the caller authenticated by token through a TokenReview,
+// or nil if the token is not valid for any of the audiences. Only
+// authenticated callers are cached.
+func (a *authenticator) review(ctx context.Context, token string) (*caller, error) {
+	key := sha256.Sum256([]byte(token))
+	if cached, ok := a.reviews.Get(key); ok {
+		return cached.(*caller), nil
+	}
+	if !a.limiter.TryAccept() {
+		return nil, errTooManyReviews
+	}
+
+	review, err := a.opts.TokenReviewClient.AuthenticationV1().TokenReviews().Create(ctx, &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: token, Audiences: a.opts.Audiences},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if !review.Status.Authenticated {
+		return nil, nil
+	}
+	// Authenticators that do not support audiences return none, and are
+	// not trusted to have checked them
+	if len(a.opts.Audiences) > 0 && !overlaps(review.Status.Audiences, a.opts.Audiences) {
+		a.logger.V(2).Info("rejected token reviewed for other audiences", "user", review.Status.User.Username, "audiences", review.Status.Audiences)
+		return nil, nil
+	}
+
+	c := &caller{name: review.Status.User.Username, groups: review.Status.User.Groups, reviewed: true}
+	a.reviews.Add(key, c, tokenReviewCacheTTL)
+	return c, nil
+}
+
+func overlaps(values, others []string) bool {
+	for _, v := range values {
+		if contains(others, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
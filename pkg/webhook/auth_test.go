@@ -0,0 +1,270 @@
+package webhook
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/cache"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/util/flowcontrol"
+	"k8s.io/klog/v2"
+)
+
+// newReviewClient returns a client whose TokenReviews authenticate the
+// tokens of users as them.
+func newReviewClient(users map[string]authenticationv1.UserInfo) *fake.Clientset {
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+		user, ok := users[review.Spec.Token]
+		review.Status = authenticationv1.TokenReviewStatus{Authenticated: ok, User: user}
+		return true, review, nil
+	})
+	return client
+}
+
+func TestAdminAuthentication(t *testing.T) {
+	client := newReviewClient(map[string]authenticationv1.UserInfo{
+		"admin-token":  {Username: "alice"},
+		"member-token": {Username: "bob", Groups: []string{"system:authenticated", "platform"}},
+		"sa-token":     {Username: "system:serviceaccount:default:app", Groups: []string{"system:serviceaccounts"}},
+	})
+	opts := AuthOptions{
+		Token:             "static-token",
+		TokenReviewClient: client,
+		ClientCAFile:      "ca.pem",
+		Users:             []string{"system:apiserver"},
+		AdminUsers:        []string{"alice"},
+		AdminGroups:       []string{"platform"},
+	}
+	certificate := func(name string, groups ...string) *tls.ConnectionState {
+		cert := &x509.Certificate{Subject: pkix.Name{CommonName: name, Organization: groups}}
+		return &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		tls   *tls.ConnectionState
+		// validate and admin are the statuses of /validate and /admin/
+		validate, admin int
+	}{
+		{
+			name:     "unauthenticated",
+			validate: http.StatusUnauthorized,
+			admin:    http.StatusUnauthorized,
+		},
+		{
+			name:     "admin user",
+			token:    "admin-token",
+			validate: http.StatusUnauthorized,
+			admin:    http.StatusOK,
+		},
+		{
+			name:     "member of an admin group",
+			token:    "member-token",
+			validate: http.StatusUnauthorized,
+			admin:    http.StatusOK,
+		},
+		{
+			name:     "any other authenticated token",
+			token:    "sa-token",
+			validate: http.StatusUnauthorized,
+			admin:    http.StatusForbidden,
+		},
+		{
+			name:     "static token identifies no one",
+			token:    "static-token",
+			validate: http.StatusOK,
+			admin:    http.StatusForbidden,
+		},
+		{
+			name:     "client certificate of an admin user",
+			tls:      certificate("alice"),
+			validate: http.StatusOK,
+			admin:    http.StatusOK,
+		},
+		{
+			name:     "client certificate of an admin group",
+			tls:      certificate("carol", "platform"),
+			validate: http.StatusOK,
+			admin:    http.StatusOK,
+		},
+		{
+			name:     "client certificate of another user",
+			tls:      certificate("kube-apiserver"),
+			validate: http.StatusOK,
+			admin:    http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newAuthenticator(opts, klog.Background())
+			ok := func(w http.ResponseWriter, _ *http.Request) {}
+			for path, want := range map[string]int{"/validate": tt.validate, "/admin/eval": tt.admin} {
+				req := httptest.NewRequest(http.MethodPost, path, nil)
+				if tt.token != "" {
+					req.Header.Set("Authorization", "Bearer "+tt.token)
+				}
+				req.TLS = tt.tls
+
+				handler := a.wrap(ok)
+				if path != "/validate" {
+					handler = a.wrapAdmin(ok)
+				}
+				rec := httptest.NewRecorder()
+				handler(rec, req)
+				if rec.Code != want {
+					t.Errorf("%s status = %d, want %d", path, rec.Code, want)
+				}
+			}
+		})
+	}
+}
+
+func TestAdminEndpointsRequireAdmins(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {})
+	tests := []struct {
+		name   string
+		opts   AuthOptions
+		status int
+	}{
+		{
+			name:   "without authentication",
+			status: http.StatusNotFound,
+		},
+		{
+			name:   "without admin users or groups",
+			opts:   AuthOptions{TokenReviewClient: newReviewClient(map[string]authenticationv1.UserInfo{"token": {Username: "alice"}})},
+			status: http.StatusNotFound,
+		},
+		{
+			name:   "with admin users",
+			opts:   AuthOptions{TokenReviewClient: newReviewClient(map[string]authenticationv1.UserInfo{"token": {Username: "alice"}}), AdminUsers: []string{"alice"}},
+			status: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wh := New("", WithAuth(tt.opts), WithAdminHandler(handler), WithAPIHandler(handler))
+			for _, path := range []string{"/admin/eval", "/api/v1/decisions"} {
+				req := httptest.NewRequest(http.MethodGet, path, nil)
+				req.Header.Set("Authorization", "Bearer token")
+				rec := httptest.NewRecorder()
+				wh.Handler().ServeHTTP(rec, req)
+				if rec.Code != tt.status {
+					t.Errorf("%s status = %d, want %d", path, rec.Code, tt.status)
+				}
+			}
+		})
+	}
+}
+
+func TestTokenReview(t *testing.T) {
+	tests := []struct {
+		name      string
+		audiences []string
+		// reviewed are the audiences returned by reviews of valid tokens
+		reviewed []string
+		// limiter limits reviews, none if nil
+		limiter flowcontrol.PassiveRateLimiter
+		// cacheSize bounds the cached reviews, the default if 0
+		cacheSize int
+		// tokens are sent in order, each expecting the status in statuses
+		tokens   []string
+		statuses []int
+		reviews  int
+	}{
+		{
+			name:     "authenticated tokens are cached",
+			tokens:   []string{"valid", "valid", "valid"},
+			statuses: []int{http.StatusOK, http.StatusOK, http.StatusOK},
+			reviews:  1,
+		},
+		{
+			name:     "failed reviews are not cached",
+			tokens:   []string{"invalid", "invalid"},
+			statuses: []int{http.StatusUnauthorized, http.StatusUnauthorized},
+			reviews:  2,
+		},
+		{
+			name:      "least recently used reviews are evicted",
+			cacheSize: 1,
+			tokens:    []string{"valid", "other", "valid"},
+			statuses:  []int{http.StatusOK, http.StatusOK, http.StatusOK},
+			reviews:   3,
+		},
+		{
+			name:     "reviews beyond the rate limit are refused",
+			limiter:  flowcontrol.NewTokenBucketPassiveRateLimiter(0.001, 2),
+			tokens:   []string{"invalid", "valid", "invalid", "valid"},
+			statuses: []int{http.StatusUnauthorized, http.StatusOK, http.StatusTooManyRequests, http.StatusOK},
+			reviews:  2,
+		},
+		{
+			name:      "reviewed for one of the audiences",
+			audiences: []string{"kubeenforcer", "https://kubernetes.default.svc"},
+			reviewed:  []string{"kubeenforcer"},
+			tokens:    []string{"valid"},
+			statuses:  []int{http.StatusOK},
+			reviews:   1,
+		},
+		{
+			name:      "reviewed for other audiences",
+			audiences: []string{"kubeenforcer"},
+			reviewed:  []string{"https://kubernetes.default.svc"},
+			tokens:    []string{"valid", "valid"},
+			statuses:  []int{http.StatusUnauthorized, http.StatusUnauthorized},
+			reviews:   2,
+		},
+		{
+			name:      "reviewed without audiences",
+			audiences: []string{"kubeenforcer"},
+			tokens:    []string{"valid"},
+			statuses:  []int{http.StatusUnauthorized},
+			reviews:   1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reviews := 0
+			client := fake.NewSimpleClientset()
+			client.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				reviews++
+				review := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+				if token := review.Spec.Token; token == "valid" || token == "other" {
+					review.Status = authenticationv1.TokenReviewStatus{Authenticated: true, User: authenticationv1.UserInfo{Username: token}, Audiences: tt.reviewed}
+				}
+				return true, review, nil
+			})
+
+			a := newAuthenticator(AuthOptions{TokenReviewClient: client, Audiences: tt.audiences}, klog.Background())
+			if tt.limiter != nil {
+				a.limiter = tt.limiter
+			}
+			if tt.cacheSize > 0 {
+				a.reviews = cache.NewLRUExpireCache(tt.cacheSize)
+			}
+			handler := a.wrap(func(w http.ResponseWriter, _ *http.Request) {})
+			for i, token := range tt.tokens {
+				req := httptest.NewRequest(http.MethodPost, "/validate", nil)
+				req.Header.Set("Authorization", "Bearer "+token)
+				rec := httptest.NewRecorder()
+				handler(rec, req)
+				if rec.Code != tt.statuses[i] {
+					t.Errorf("request %d with %s token status = %d, want %d", i, token, rec.Code, tt.statuses[i])
+				}
+			}
+			if reviews != tt.reviews {
+				t.Errorf("reviews = %d, want %d", reviews, tt.reviews)
+			}
+		})
+	}
+}
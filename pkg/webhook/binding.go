@@ -0,0 +1,63 @@
+package webhook
+
+import (
+	"context"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var (
+	podsResource  = schema.GroupVersionResource{Version: "v1", Resource: "pods"}
+	nodesResource = schema.GroupVersionResource{Version: "v1", Resource: "nodes"}
+)
+
+// isBinding returns whether request binds a pod to a node, through the
+// pods/binding subresource or the deprecated bindings resource.
+func isBinding(request *admissionv1.AdmissionRequest) bool {
+	if request.Resource.Group != "" {
+		return false
+	}
+	return (request.Resource.Resource == "pods" && request.SubResource == "binding") ||
+		(request.Resource.Resource == "bindings" && request.SubResource == "")
+}
+
+// addBindingMetadata returns the Binding object of request with the labels
+// and annotations of the bound pod, which it carries none of, and the labels
+// of the target node in target.labels, so policies can constrain which pods
+// are scheduled where. Labels and annotations of the Binding take
+// precedence.
+func (wh *webhook) addBindingMetadata(ctx context.Context, request *admissionv1.AdmissionRequest, object runtime.Object) (runtime.Object, error) {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(object)
+	if err != nil {
+		return nil, err
+	}
+	binding := &unstructured.Unstructured{Object: content}
+	binding.SetGroupVersionKind(schema.GroupVersionKind(request.Kind))
+
+	// Bindings are named after their pod
+	pod, err := wh.bindingTargets.Resource(podsResource).Namespace(request.Namespace).Get(ctx, binding.GetName(), metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	binding.SetLabels(merge(pod.GetLabels(), binding.GetLabels()))
+	binding.SetAnnotations(merge(pod.GetAnnotations(), binding.GetAnnotations()))
+
+	nodeName, _, err := unstructured.NestedString(binding.Object, "target", "name")
+	if err != nil || nodeName == "" {
+		return binding, err
+	}
+	node, err := wh.bindingTargets.Resource(nodesResource).Get(ctx, nodeName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if labels := node.GetLabels(); len(labels) > 0 {
+		if err := unstructured.SetNestedStringMap(binding.Object, labels, "target", "labels"); err != nil {
+			return nil, err
+		}
+	}
+	return binding, nil
+}
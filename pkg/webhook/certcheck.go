@@ -0,0 +1,51 @@
+package webhook
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"time"
+)
+
+// HealthOptions configures the certificate checks behind the health and
+// readiness endpoints.
+type HealthOptions struct {
+	// CertExpiryWindow fails readiness once a serving certificate expires
+	// within this window, or within a third of its lifetime if shorter, so
+	// short-lived certificates, rotated well before they expire, still pass.
+	// Disabled if 0.
+	CertExpiryWindow time.Duration
+
+	// CertChecksFailHealth also fails /health on certificate problems, so
+	// the pod is restarted rather than only taken out of rotation.
+	CertChecksFailHealth bool
+}
+
+// checkCertificates verifies that every listener's certificate matches its
+// key and does not expire within window, capped at a third of its lifetime.
+// A failed rotation is caught here before the API server starts rejecting
+// the webhook.
+func checkCertificates(listeners []Listener, window time.Duration) error {
+	for _, l := range listeners {
+		pair, err := tls.LoadX509KeyPair(l.CertFile, l.KeyFile)
+		if err != nil {
+			return fmt.Errorf("serving certificate for %s: %w", l.Addr, err)
+		}
+		if window <= 0 {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(pair.Certificate[0])
+		if err != nil {
+			return fmt.Errorf("serving certificate for %s: %w", l.Addr, err)
+		}
+		certWindow := window
+		if lifetime := cert.NotAfter.Sub(cert.NotBefore); lifetime/3 < certWindow {
+			certWindow = lifetime / 3
+		}
+		if remaining := time.Until(cert.NotAfter); remaining < certWindow {
+			return fmt.Errorf("serving certificate for %s expires in %s", l.Addr, remaining.Round(time.Second))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package webhook
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCertificate writes a self-signed certificate valid from notBefore to
+// notAfter and its key to dir, and returns a listener serving it.
+func writeCertificate(t *testing.T, dir string, notBefore, notAfter time.Time) Listener {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "kubeenforcer"},
+		DNSNames:     []string{"kubeenforcer"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l := Listener{Addr: ":8443", CertFile: filepath.Join(dir, "tls.crt"), KeyFile: filepath.Join(dir, "tls.key")}
+	if err := os.WriteFile(l.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(l.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func TestCheckCertificates(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name                string
+		notBefore, notAfter time.Time
+		window              time.Duration
+		wantErr             bool
+	}{
+		{
+			name:      "long-lived certificate outside the window",
+			notBefore: now.Add(-24 * time.Hour),
+			notAfter:  now.Add(90 * 24 * time.Hour),
+			window:    72 * time.Hour,
+		},
+		{
+			name:      "long-lived certificate within the window",
+			notBefore: now.Add(-88 * 24 * time.Hour),
+			notAfter:  now.Add(48 * time.Hour),
+			window:    72 * time.Hour,
+			wantErr:   true,
+		},
+		{
+			name:      "fresh short-lived certificate",
+			notBefore: now.Add(-5 * time.Minute),
+			notAfter:  now.Add(55 * time.Minute),
+			window:    72 * time.Hour,
+		},
+		{
+			name:      "short-lived certificate past two thirds of its lifetime",
+			notBefore: now.Add(-50 * time.Minute),
+			notAfter:  now.Add(10 * time.Minute),
+			window:    72 * time.Hour,
+			wantErr:   true,
+		},
+		{
+			name:      "short-lived certificate within a shorter window",
+			notBefore: now.Add(-30 * time.Minute),
+			notAfter:  now.Add(30 * time.Minute),
+			window:    time.Hour,
+		},
+		{
+			name:      "expiring certificate with the check disabled",
+			notBefore: now.Add(-time.Hour),
+			notAfter:  now.Add(time.Minute),
+		},
+		{
+			name:      "expired certificate",
+			notBefore: now.Add(-2 * time.Hour),
+			notAfter:  now.Add(-time.Hour),
+			window:    72 * time.Hour,
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := writeCertificate(t, t.TempDir(), tt.notBefore, tt.notAfter)
+			err := checkCertificates([]Listener{l}, tt.window)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkCertificates() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckCertificatesMismatchedKey(t *testing.T) {
+	now := time.Now()
+	l := writeCertificate(t, t.TempDir(), now.Add(-time.Hour), now.Add(time.Hour))
+	other := writeCertificate(t, t.TempDir(), now.Add(-time.Hour), now.Add(time.Hour))
+	l.KeyFile = other.KeyFile
+
+	if err := checkCertificates([]Listener{l}, 0); err == nil {
+		t.Error("checkCertificates() succeeded with the key of another certificate")
+	}
+}
@@ -0,0 +1,110 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apiserver/pkg/admission"
+	"k8s.io/klog/v2"
+
+	"github.com/kubescape/kubeenforcer/pkg/metrics"
+)
+
+var (
+	validatorEvaluations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "validator",
+		Name:      "evaluations_total",
+		Help:      "Requests evaluated by each validator of the chain, by result: allowed, denied, error or ignored_error.",
+	}, []string{"validator", "result"})
+
+	validatorLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "validator",
+		Name:      "evaluation_duration_seconds",
+		Help:      "Latency of the evaluation of a request by each validator of the chain.",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 14),
+	}, []string{"validator"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(validatorEvaluations, validatorLatency)
+}
+
+type chainedValidator struct {
+	name          string
+	validator     admission.ValidationInterface
+	failurePolicy admissionregistrationv1.FailurePolicyType
+}
+
+// chain runs validators in order until one denies the request. Errors of a
+// validator that are not denials are returned if its failure policy is Fail,
+// and ignored if it is Ignore.
+type chain []chainedValidator
+
+// only returns the validators of the chain named in names, in chain order.
+func (c chain) only(names []string) chain {
+	selected := map[string]bool{}
+	for _, name := range names {
+		selected[name] = true
+	}
+	var res chain
+	for _, v := range c {
+		if selected[v.name] {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+func (c chain) Handles(operation admission.Operation) bool {
+	for _, v := range c {
+		if v.validator.Handles(operation) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c chain) Validate(ctx context.Context, a admission.Attributes, o admission.ObjectInterfaces) error {
+	for _, v := range c {
+		if !v.validator.Handles(a.GetOperation()) {
+			continue
+		}
+
+		started := time.Now()
+		err := v.validator.Validate(ctx, a, o)
+		validatorLatency.WithLabelValues(v.name).Observe(time.Since(started).Seconds())
+
+		switch {
+		case err == nil:
+			validatorEvaluations.WithLabelValues(v.name, "allowed").Inc()
+		case isDenial(err):
+			validatorEvaluations.WithLabelValues(v.name, "denied").Inc()
+			return err
+		case v.failurePolicy == admissionregistrationv1.Ignore:
+			validatorEvaluations.WithLabelValues(v.name, "ignored_error").Inc()
+			klog.FromContext(ctx).Error(err, "ignoring failure of validator", "validator", v.name)
+		default:
+			validatorEvaluations.WithLabelValues(v.name, "error").Inc()
+			return err
+		}
+	}
+	return nil
+}
+
+// isDenial returns whether err rejects the request, rather than reporting
+// that it could not be evaluated: API status errors other than server
+// errors are denials.
+func isDenial(err error) bool {
+	var status k8serrors.APIStatus
+	if !errors.As(err, &status) {
+		return false
+	}
+	return status.Status().Code < http.StatusInternalServerError
+}
@@ -0,0 +1,53 @@
+package webhook
+
+import (
+	"encoding/json"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// operationOptions decodes the CreateOptions, UpdateOptions, also sent for
+// patches, or DeleteOptions of request, or returns nil.
+func operationOptions(request *admissionv1.AdmissionRequest) runtime.Object {
+	if len(request.Options.Raw) == 0 {
+		return nil
+	}
+	var options runtime.Object
+	switch request.Operation {
+	case admissionv1.Create:
+		options = &metav1.CreateOptions{}
+	case admissionv1.Update:
+		options = &metav1.UpdateOptions{}
+	case admissionv1.Delete:
+		options = &metav1.DeleteOptions{}
+	default:
+		return nil
+	}
+	if err := json.Unmarshal(request.Options.Raw, options); err != nil {
+		logger.V(4).Info("ignoring undecodable operation options", "operation", request.Operation, "err", err)
+		return nil
+	}
+	return options
+}
+
+// collectionItem completes the DELETE request of an item of a
+// deletecollection request, which the API server sends without the name of
+// the item, with its name and namespace from oldObject, the deleted item.
+// It returns whether request was one.
+func collectionItem(request *admissionv1.AdmissionRequest, oldObject runtime.Object) bool {
+	if request.Operation != admissionv1.Delete || request.Name != "" || oldObject == nil {
+		return false
+	}
+	accessor, err := meta.Accessor(oldObject)
+	if err != nil || accessor.GetName() == "" {
+		return false
+	}
+	request.Name = accessor.GetName()
+	if request.Namespace == "" {
+		request.Namespace = accessor.GetNamespace()
+	}
+	return true
+}
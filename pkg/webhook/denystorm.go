@@ -0,0 +1,171 @@
+package webhook
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/klog/v2"
+
+	"github.com/kubescape/kubeenforcer/pkg/alertmanager"
+	"github.com/kubescape/kubeenforcer/pkg/metrics"
+)
+
+var (
+	denyStorms = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "deny_storm",
+		Name:      "started_total",
+		Help:      "Deny storms detected, i.e. users whose requests were denied more often than the deny storm threshold.",
+	})
+
+	denyStormThrottled = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "deny_storm",
+		Name:      "throttled_total",
+		Help:      "Denials returned as rate limited during a deny storm, without an alert nor a log.",
+	})
+
+	denyStormActive = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "deny_storm",
+		Name:      "active",
+		Help:      "Users currently in a deny storm.",
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(denyStorms, denyStormThrottled, denyStormActive)
+}
+
+// DenyStormOptions configures the detection of users, typically controllers
+// stuck in a retry loop, whose requests are denied at an excessive rate.
+type DenyStormOptions struct {
+	// Threshold is the number of denials of a user within Window above
+	// which a deny storm starts. Disabled if 0.
+	Threshold int
+	// Window is the sliding window denials are counted over.
+	Window time.Duration
+	// Cooldown is how long a deny storm lasts after the last denial that
+	// exceeded the threshold.
+	Cooldown time.Duration
+}
+
+func (o DenyStormOptions) enabled() bool {
+	return o.Threshold > 0 && o.Window > 0
+}
+
+// stormGuard tracks the denials of each user. During a storm, denials are
+// still evaluated, but returned as rate limited, and neither alerted on nor
+// logged individually; a single alert is sent when the storm starts.
+type stormGuard struct {
+	opts   DenyStormOptions
+	logger klog.Logger
+
+	lock      sync.Mutex
+	users     map[string]*denials
+	lastSweep time.Time
+}
+
+type denials struct {
+	// times is a ring of the times of the last Threshold denials, next the
+	// position of the oldest of them.
+	times []time.Time
+	next  int
+
+	// until is when the storm ends, zero if there is none, and throttled
+	// the denials throttled since it started.
+	until     time.Time
+	throttled int
+}
+
+func newStormGuard(opts DenyStormOptions, logger klog.Logger) *stormGuard {
+	return &stormGuard{opts: opts, logger: logger, users: map[string]*denials{}}
+}
+
+// denied records a denial of user, and returns whether the user is in a
+// deny storm, and whether this denial started it.
+func (g *stormGuard) denied(user string, now time.Time) (storming, started bool) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	g.sweep(now)
+	d, ok := g.users[user]
+	if !ok {
+		d = &denials{times: make([]time.Time, g.opts.Threshold)}
+		g.users[user] = d
+	}
+	if !d.until.IsZero() && now.After(d.until) {
+		g.logger.Info("deny storm ended", "user", user, "throttled", d.throttled)
+		d.until, d.throttled = time.Time{}, 0
+		denyStormActive.Dec()
+	}
+
+	// The oldest of the last Threshold denials is within the window
+	oldest := d.times[d.next]
+	d.times[d.next] = now
+	d.next = (d.next + 1) % len(d.times)
+	exceeded := !oldest.IsZero() && now.Sub(oldest) <= g.opts.Window
+
+	if exceeded {
+		if d.until.IsZero() {
+			started = true
+			denyStorms.Inc()
+			denyStormActive.Inc()
+		}
+		d.until = now.Add(g.opts.Cooldown)
+	}
+	if d.until.IsZero() {
+		return false, false
+	}
+	d.throttled++
+	denyStormThrottled.Inc()
+	return true, started
+}
+
+// sweep forgets the users without a recent denial nor a storm, at most once
+// per window.
+func (g *stormGuard) sweep(now time.Time) {
+	if now.Sub(g.lastSweep) < g.opts.Window {
+		return
+	}
+	g.lastSweep = now
+	for user, d := range g.users {
+		last := d.times[(d.next+len(d.times)-1)%len(d.times)]
+		if now.Sub(last) > g.opts.Window && (d.until.IsZero() || now.After(d.until)) {
+			if !d.until.IsZero() {
+				g.logger.Info("deny storm ended", "user", user, "throttled", d.throttled)
+				denyStormActive.Dec()
+			}
+			delete(g.users, user)
+		}
+	}
+}
+
+// throttle returns err, a denial during a deny storm of user, as a rate
+// limited denial asking the client to back off for the cooldown.
+func (g *stormGuard) throttle(err error, user string) error {
+	retryAfter := int(math.Ceil(g.opts.Cooldown.Seconds()))
+	return k8serrors.NewTooManyRequests(fmt.Sprintf("too many denied requests from %s, back off for %ds: %v", user, retryAfter, err), retryAfter)
+}
+
+// alert sends the single alert of the deny storm of user, whose last denial
+// was message.
+func (g *stormGuard) alert(alerter *alertmanager.AlertManager, user string, message string, decisionID string) {
+	g.logger.Info("deny storm started, throttling denials", "user", user, "threshold", g.opts.Threshold, "window", g.opts.Window, "cooldown", g.opts.Cooldown, "decision", decisionID, "lastDenial", message)
+	if alerter == nil {
+		return
+	}
+	alerter.Alert(&alertmanager.AlertInfo{
+		Name:           "Deny storm",
+		Severity:       "warning",
+		Resource:       "users",
+		Instance:       user,
+		RequestingUser: user,
+		Description:    fmt.Sprintf("%d or more requests of %s were denied within %s; further denials are throttled without alerts until the rate stays below the threshold for %s. Last denial: %s", g.opts.Threshold, user, g.opts.Window, g.opts.Cooldown, message),
+		DecisionID:     decisionID,
+	})
+}
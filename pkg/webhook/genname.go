@@ -0,0 +1,69 @@
+package webhook
+
+import (
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apiserver/pkg/admission"
+
+	"github.com/kubescape/kubeenforcer/pkg/alertmanager"
+	"github.com/kubescape/kubeenforcer/pkg/decision"
+	"github.com/kubescape/kubeenforcer/pkg/redaction"
+	"github.com/kubescape/kubeenforcer/pkg/remediation"
+)
+
+// NameResolver resolves the names the API server generates for objects
+// created with generateName.
+type NameResolver interface {
+	// Resolve calls done with the name of the object of resource gvr created
+	// in namespace from generateName, and with uid if known, once it is
+	// created, or with "" if it is not.
+	Resolve(gvr schema.GroupVersionResource, namespace, generateName string, uid types.UID, done func(name string))
+}
+
+// generatedName returns the generateName of the object created by request
+// without a name, and its generated name and UID if the API server already
+// set them on the object.
+func generatedName(request *admissionv1.AdmissionRequest, attrs admission.Attributes) (string, string, types.UID) {
+	if attrs == nil || request.Operation != admissionv1.Create || request.Name != "" || request.SubResource != "" {
+		return "", "", ""
+	}
+	accessor, err := meta.Accessor(attrs.GetObject())
+	if err != nil || accessor.GetGenerateName() == "" {
+		return "", "", ""
+	}
+	return accessor.GetGenerateName(), accessor.GetName(), accessor.GetUID()
+}
+
+func resourceOf(request *admissionv1.AdmissionRequest) schema.GroupVersionResource {
+	return schema.GroupVersionResource{Group: request.Resource.Group, Version: request.Resource.Version, Resource: request.Resource.Resource}
+}
+
+// deferAlert sends the alert of the audited object created by request with
+// generateName once its name is resolved.
+func (wh *webhook) deferAlert(alerter *alertmanager.AlertManager, request *admissionv1.AdmissionRequest, generateName string, uid types.UID, decisionID string, attrs admission.Attributes, redact redaction.Func) {
+	audit, deny := getValidationAnnotations(attrs)
+	if alerter == nil || !(audit || deny) {
+		return
+	}
+	if redact == nil {
+		redact = redaction.None
+	}
+	hint := remediation.For("valid "+getMessage(attrs), attrs)
+	alertInfo := violationAlert(decisionID, metav1.StatusReasonUnknown, hint, request.Resource.Resource, "", request.Namespace, attrs, &request.UserInfo, redact)
+	wh.names.Resolve(resourceOf(request), request.Namespace, generateName, uid, func(name string) {
+		alertInfo.Instance = name
+		alerter.Alert(alertInfo)
+	})
+}
+
+// deferDecision records d, of the object created by request with
+// generateName, once its name is resolved.
+func (wh *webhook) deferDecision(d *decision.Decision, request *admissionv1.AdmissionRequest, generateName string, uid types.UID) {
+	wh.names.Resolve(resourceOf(request), request.Namespace, generateName, uid, func(name string) {
+		d.Name = name
+		wh.decisions.Record(d)
+	})
+}
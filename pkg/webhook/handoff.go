@@ -0,0 +1,229 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// listenFDsEnv is the number of listeners a previous process passed on
+	// handoff, as file descriptors from 3, like systemd's LISTEN_FDS.
+	listenFDsEnv = "KUBEENFORCER_LISTEN_FDS"
+
+	// handoffParentEnv is the PID of the process to stop once started.
+	handoffParentEnv = "KUBEENFORCER_HANDOFF_PARENT"
+)
+
+// executable is resolved when the process starts, so a handoff starts the
+// binary swapped in at its path rather than the one running.
+var executable, _ = os.Executable()
+
+var (
+	inheritOnce sync.Once
+	inheritLock sync.Mutex
+	// inherited are the listeners passed by systemd socket activation or by
+	// a previous process that are not listened on yet
+	inherited []net.Listener
+)
+
+func inheritListeners() {
+	n := 0
+	if v := os.Getenv(listenFDsEnv); v != "" {
+		n, _ = strconv.Atoi(v)
+	} else if pid, _ := strconv.Atoi(os.Getenv("LISTEN_PID")); pid == os.Getpid() {
+		n, _ = strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	}
+	// They are not passed on to processes started later
+	for _, env := range []string{listenFDsEnv, "LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		os.Unsetenv(env)
+	}
+
+	for fd := 3; fd < 3+n; fd++ {
+		f := os.NewFile(uintptr(fd), "inherited")
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			logger.Error(err, "ignoring inherited file descriptor", "fd", fd)
+			continue
+		}
+		inherited = append(inherited, l)
+	}
+	if n > 0 {
+		logger.Info("inherited listeners", "count", len(inherited))
+	}
+}
+
+// takeInherited returns the inherited listener listening on address, or nil.
+func takeInherited(network, address string) net.Listener {
+	inheritOnce.Do(inheritListeners)
+	inheritLock.Lock()
+	defer inheritLock.Unlock()
+	for i, l := range inherited {
+		if listensOn(l, network, address) {
+			inherited = append(inherited[:i:i], inherited[i+1:]...)
+			return l
+		}
+	}
+	return nil
+}
+
+// listensOn returns whether l listens on address, where unspecified IPs
+// match one another since dual-stack listeners report [::].
+func listensOn(l net.Listener, network, address string) bool {
+	switch a := l.Addr().(type) {
+	case *net.UnixAddr:
+		return network == "unix" && a.Name == address
+	case *net.TCPAddr:
+		if network == "unix" {
+			return false
+		}
+		want, err := net.ResolveTCPAddr(network, address)
+		if err != nil || want.Port != a.Port {
+			return false
+		}
+		if want.IP == nil || want.IP.IsUnspecified() {
+			return a.IP.IsUnspecified()
+		}
+		return want.IP.Equal(a.IP)
+	}
+	return false
+}
+
+// handoffListeners are the listeners served, by address, which are passed
+// to the new process on handoff.
+type handoffListeners struct {
+	lock   sync.Mutex
+	byAddr map[string]net.Listener
+}
+
+func (h *handoffListeners) serving(addr string, l net.Listener) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.byAddr == nil {
+		h.byAddr = map[string]net.Listener{}
+	}
+	h.byAddr[addr] = l
+}
+
+// files returns duplicates of the file descriptors of the listeners.
+func (h *handoffListeners) files() ([]*os.File, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	addrs := make([]string, 0, len(h.byAddr))
+	for addr := range h.byAddr {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+
+	var files []*os.File
+	for _, addr := range addrs {
+		filer, ok := h.byAddr[addr].(interface{ File() (*os.File, error) })
+		if !ok {
+			closeFiles(files)
+			return nil, fmt.Errorf("listener %s cannot be passed on", addr)
+		}
+		f, err := filer.File()
+		if err != nil {
+			closeFiles(files)
+			return nil, fmt.Errorf("listener %s: %w", addr, err)
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
+
+// runHandoff hands the listeners off to a new process on every handoff
+// signal until ctx is cancelled.
+func (wh *webhook) runHandoff(ctx context.Context) {
+	if handoffSignal == nil {
+		wh.logger.Error(nil, "handoff is not supported on this platform")
+		return
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, handoffSignal)
+	defer signal.Stop(signals)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signals:
+			if err := wh.handoff(); err != nil {
+				wh.logger.Error(err, "handoff failed, serving on")
+			}
+		}
+	}
+}
+
+// handoff starts the executable with the arguments of this process and its
+// listeners, and asks it to stop this process once it started.
+func (wh *webhook) handoff() error {
+	files, err := wh.handoffs.files()
+	if err != nil {
+		return err
+	}
+	defer closeFiles(files)
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("%s=%d", listenFDsEnv, len(files)),
+		fmt.Sprintf("%s=%d", handoffParentEnv, os.Getpid()),
+	)
+	cmd.ExtraFiles = files
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	wh.logger.Info("handing listeners off to new process", "pid", cmd.Process.Pid, "executable", executable, "listeners", len(files))
+	go func() {
+		err := cmd.Wait()
+		wh.logger.Info("handoff process exited", "pid", cmd.Process.Pid, "err", err)
+	}()
+	return nil
+}
+
+// handoffParent returns the PID of the process that handed its listeners
+// off to this one, or 0.
+func handoffParent() int {
+	pid, _ := strconv.Atoi(os.Getenv(handoffParentEnv))
+	os.Unsetenv(handoffParentEnv)
+	return pid
+}
+
+// stopParent sends SIGTERM to the process that handed its listeners off to
+// this one once every startup step completed, so it drains and exits.
+func (wh *webhook) stopParent(ctx context.Context, parent int) {
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	for len(wh.pendingStartup()) > 0 {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+
+	process, err := os.FindProcess(parent)
+	if err == nil {
+		err = process.Signal(syscall.SIGTERM)
+	}
+	if err != nil {
+		wh.logger.Error(err, "failed to stop previous process", "pid", parent)
+		return
+	}
+	wh.logger.Info("started, stopping previous process", "pid", parent)
+}
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package webhook
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+var handoffSignal os.Signal
+
+func reusePortControl(network, address string, c syscall.RawConn) error {
+	return errors.New("SO_REUSEPORT is not supported on this platform")
+}
@@ -0,0 +1,24 @@
+//go:build unix
+
+package webhook
+
+import (
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+var handoffSignal os.Signal = syscall.SIGUSR2
+
+// reusePortControl sets SO_REUSEPORT on the socket of c.
+func reusePortControl(network, address string, c syscall.RawConn) error {
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		sockErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
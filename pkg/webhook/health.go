@@ -0,0 +1,102 @@
+package webhook
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/kubescape/kubeenforcer/pkg/metrics"
+)
+
+var (
+	validatorReady = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "validator",
+		Name:      "ready",
+		Help:      "Whether each validator of the chain, or readiness check, can evaluate requests, as of the last readiness probe.",
+	}, []string{"validator"})
+
+	validatorWarnings = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "validator",
+		Name:      "warnings",
+		Help:      "Problems reported by each validator of the chain that only affect some requests, e.g. objects that failed to compile, as of the last readiness probe.",
+	}, []string{"validator"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(validatorReady, validatorWarnings)
+}
+
+// HealthChecker is implemented by validators that can report whether they
+// are able to evaluate requests. Validators that only implement
+// HasSynced() bool are ready once it returns true.
+type HealthChecker interface {
+	// Health returns an error if the validator cannot evaluate requests,
+	// e.g. because its informers are not synced, and warnings about
+	// problems that only affect some requests, e.g. objects that failed to
+	// compile.
+	Health() (warnings []string, err error)
+}
+
+type syncer interface {
+	HasSynced() bool
+}
+
+// ReadinessCheck returns an error while requests cannot be evaluated.
+type ReadinessCheck func() error
+
+type namedCheck struct {
+	name  string
+	check ReadinessCheck
+}
+
+// checkValidators checks the health of the validators of the chain and of
+// the readiness checks, and returns an error naming those that are not
+// ready. Validators with the Ignore failure policy are checked too, since
+// requests would otherwise be silently allowed.
+func checkValidators(validators chain, checks []namedCheck) error {
+	var notReady []string
+	record := func(name string, warnings []string, err error) {
+		validatorWarnings.WithLabelValues(name).Set(float64(len(warnings)))
+		if err != nil {
+			validatorReady.WithLabelValues(name).Set(0)
+			notReady = append(notReady, fmt.Sprintf("%s: %v", name, err))
+			return
+		}
+		validatorReady.WithLabelValues(name).Set(1)
+	}
+
+	for _, v := range validators {
+		if ok, warnings, err := validatorHealth(v); ok {
+			record(v.name, warnings, err)
+		}
+	}
+	for _, c := range checks {
+		record(c.name, nil, c.check())
+	}
+
+	if len(notReady) == 0 {
+		return nil
+	}
+	sort.Strings(notReady)
+	return fmt.Errorf("validators not ready: %s", strings.Join(notReady, "; "))
+}
+
+// validatorHealth returns whether v reports its health, and its health.
+func validatorHealth(v chainedValidator) (bool, []string, error) {
+	switch checker := v.validator.(type) {
+	case HealthChecker:
+		warnings, err := checker.Health()
+		return true, warnings, err
+	case syncer:
+		if !checker.HasSynced() {
+			return true, nil, errors.New("not synced")
+		}
+		return true, nil, nil
+	}
+	return false, nil, nil
+}
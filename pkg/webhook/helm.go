@@ -0,0 +1,61 @@
+package webhook
+
+import (
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apiserver/pkg/admission"
+
+	"github.com/kubescape/kubeenforcer/pkg/decision"
+)
+
+// HelmReleaseResolver looks up the Helm releases installed in the cluster.
+type HelmReleaseResolver interface {
+	// Release returns the latest revision of the release name in
+	// namespace, or nil if there is none. It must not block.
+	Release(namespace, name string) *decision.HelmRelease
+}
+
+// helmReleaseOf returns the Helm release owning the object of attrs: the
+// release of its meta.helm.sh annotations, or with a resolver, the installed
+// release named by its app.kubernetes.io/instance label if it is managed by
+// Helm, such as the pods of a Deployment of a chart. It returns nil if the
+// object is not known to belong to a release.
+func (wh *webhook) helmReleaseOf(attrs admission.Attributes) *decision.HelmRelease {
+	if attrs == nil {
+		return nil
+	}
+	obj := attrs.GetObject()
+	if obj == nil {
+		obj = attrs.GetOldObject()
+	}
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return nil
+	}
+	namespace, labels := attrs.GetNamespace(), accessor.GetLabels()
+
+	release := decision.HelmReleaseOf(namespace, labels, accessor.GetAnnotations())
+	if wh.helmReleases == nil {
+		return release
+	}
+	// The instance label alone may be set by other tools, so its release
+	// must be installed
+	fromLabels := release == nil
+	if fromLabels {
+		if labels[decision.ManagedByLabel] != "Helm" || labels[decision.InstanceLabel] == "" || namespace == "" {
+			return nil
+		}
+		release = &decision.HelmRelease{Name: labels[decision.InstanceLabel], Namespace: namespace, Chart: labels[decision.HelmChartLabel]}
+	}
+	installed := wh.helmReleases.Release(release.Namespace, release.Name)
+	if installed == nil {
+		if fromLabels {
+			return nil
+		}
+		return release
+	}
+	release.Revision = installed.Revision
+	if release.Chart == "" {
+		release.Chart = installed.Chart
+	}
+	return release
+}
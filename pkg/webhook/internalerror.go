@@ -0,0 +1,138 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/prometheus/client_golang/prometheus"
+	admissionv1 "k8s.io/api/admission/v1"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apiserver/pkg/admission"
+	"k8s.io/klog/v2"
+
+	"github.com/kubescape/kubeenforcer/pkg/metrics"
+)
+
+var (
+	reviewResults = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "review_results_total",
+		Help:      "Admission reviews by result: allowed, denied by a policy or validator, or internal_error when the request could not be evaluated.",
+	}, []string{"result"})
+
+	internalErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "internal_errors_total",
+		Help:      "Requests that could not be evaluated, by kind of error (decode, metadata, stale, panic or validator) and the failure policy applied: Fail, Ignore, or HTTP when left to the webhook configuration.",
+	}, []string{"kind", "failure_policy"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(reviewResults, internalErrors)
+}
+
+// Kinds of internal errors.
+const (
+	internalErrorDecode    = "decode"
+	internalErrorMetadata  = "metadata"
+	internalErrorStale     = "stale"
+	internalErrorPanic     = "panic"
+	internalErrorValidator = "validator"
+)
+
+// InternalErrorOptions sets how requests that cannot be evaluated because of
+// internal errors are answered: objects that fail to decode, targets of
+// scale and binding requests that cannot be read, readiness checks failing,
+// e.g. on stale informers, and panics. Errors of validators that are not
+// denials follow the failure policies of the validators instead.
+type InternalErrorOptions struct {
+	// FailurePolicy denies such requests with Fail and allows them, with a
+	// warning, with Ignore. If empty, they are answered with an HTTP error,
+	// so the failure policy of the webhook configuration applies.
+	FailurePolicy admissionregistrationv1.FailurePolicyType
+	// PathFailurePolicies override FailurePolicy for validate paths.
+	PathFailurePolicies map[string]admissionregistrationv1.FailurePolicyType
+}
+
+func (o InternalErrorOptions) failurePolicy(path string) admissionregistrationv1.FailurePolicyType {
+	if policy, ok := o.PathFailurePolicies[path]; ok {
+		return policy
+	}
+	return o.FailurePolicy
+}
+
+// panicError is a panic recovered while validating a request.
+type panicError struct {
+	value interface{}
+}
+
+func (e *panicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.value)
+}
+
+// validate runs validator on attrs, returning its panics as a *panicError.
+func (wh *webhook) validate(ctx context.Context, validator admission.ValidationInterface, attrs admission.Attributes) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if r == http.ErrAbortHandler {
+				panic(r)
+			}
+			err = &panicError{value: r}
+			klog.FromContext(ctx).Error(err, "recovered panic evaluating request", "stack", string(debug.Stack()))
+		}
+	}()
+	return validator.Validate(ctx, attrs, wh.objectInferfaces)
+}
+
+// checkReadiness returns the error of the first failing readiness check.
+func (wh *webhook) checkReadiness() error {
+	for _, c := range wh.readinessChecks {
+		if err := c.check(); err != nil {
+			return fmt.Errorf("%s: %w", c.name, err)
+		}
+	}
+	return nil
+}
+
+// internalError answers request, which could not be evaluated because of
+// err, per the internal error failure policy of the path of req, or with an
+// HTTP error of status if there is none.
+func (wh *webhook) internalError(w http.ResponseWriter, req *http.Request, request *admissionv1.AdmissionRequest, decisionID, kind string, err error, status int) {
+	reviewResults.WithLabelValues("internal_error").Inc()
+	policy := wh.internalErrors.failurePolicy(req.URL.Path)
+	if policy == "" {
+		internalErrors.WithLabelValues(kind, "HTTP").Inc()
+		http.Error(w, err.Error(), status)
+		wh.logger.Error(err, "review response", "status", status, "kind", kind)
+		return
+	}
+	internalErrors.WithLabelValues(kind, string(policy)).Inc()
+	wh.logger.Error(err, "internal error", "decision", decisionID, "kind", kind, "failurePolicy", policy, "resource", request.Resource.String(), "namespace", request.Namespace, "name", request.Name)
+
+	message := fmt.Sprintf("kubeenforcer internal error (%s): %v", kind, err)
+	response := &admissionv1.AdmissionResponse{UID: request.UID, Allowed: policy == admissionregistrationv1.Ignore}
+	if response.Allowed {
+		response.Warnings = []string{message + ", allowed by the Ignore failure policy", "kubeenforcer decision " + decisionID}
+	} else {
+		response.Result = &metav1.Status{
+			Code:    http.StatusInternalServerError,
+			Reason:  metav1.StatusReasonInternalError,
+			Message: message + "\ndecision: " + decisionID,
+		}
+	}
+	wh.stamp.stamp(response)
+	out, err := json.Marshal(&admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{Kind: "AdmissionReview", APIVersion: "admission.k8s.io/v1"},
+		Response: response,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(out)
+}
@@ -0,0 +1,53 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// listen opens a listener for addr. Plain host:port addresses and tcp://
+// addresses listen on TCP, with SO_REUSEPORT if reusePort is set; unix://
+// addresses listen on a unix domain socket, e.g.
+// unix:///var/run/kubeenforcer/webhook.sock. A listener inherited for addr is
+// used rather than opening one.
+func listen(addr string, reusePort bool) (net.Listener, error) {
+	network, address := "tcp", addr
+	if i := strings.Index(addr, "://"); i >= 0 {
+		network, address = addr[:i], addr[i+len("://"):]
+	}
+	if l := takeInherited(network, address); l != nil {
+		return l, nil
+	}
+
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		var config net.ListenConfig
+		if reusePort {
+			config.Control = reusePortControl
+		}
+		return config.Listen(context.Background(), network, address)
+	case "unix":
+		// A socket left behind by a previous process would make the listen
+		// fail
+		if info, err := os.Stat(address); err == nil && info.Mode()&os.ModeSocket != 0 {
+			if err := os.Remove(address); err != nil {
+				return nil, err
+			}
+		}
+
+		l, err := net.Listen("unix", address)
+		if err != nil {
+			return nil, err
+		}
+
+		// The server is restarted with a new listener when certificates
+		// change; the old listener must not unlink the new socket
+		l.(*net.UnixListener).SetUnlinkOnClose(false)
+		return l, nil
+	default:
+		return nil, fmt.Errorf("unsupported listen address scheme %q", network)
+	}
+}
@@ -0,0 +1,321 @@
+package webhook
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	runtimemetrics "runtime/metrics"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/klog/v2"
+
+	"github.com/kubescape/kubeenforcer/pkg/metrics"
+)
+
+var (
+	memoryPressureActive = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "memory_pressure",
+		Name:      "active",
+		Help:      "Whether memory usage is above a watermark and requests that are not exempt are shed.",
+	})
+
+	memoryPressureShed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "memory_pressure",
+		Name:      "shed_requests_total",
+		Help:      "Admission requests failed fast without being evaluated because of memory pressure, by path.",
+	}, []string{"path"})
+
+	memoryHeapBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "memory_pressure",
+		Name:      "heap_bytes",
+		Help:      "Go heap memory occupied by objects, as of the last sample.",
+	})
+
+	memoryCgroupBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "memory_pressure",
+		Name:      "cgroup_working_set_bytes",
+		Help:      "Working set of the container's memory cgroup, as of the last sample.",
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(memoryPressureActive, memoryPressureShed, memoryHeapBytes, memoryCgroupBytes)
+}
+
+// MemoryPressureOptions configures shedding admission requests while memory
+// usage is high, so a burst of large objects does not get the webhook OOM
+// killed, failing every admission it serves.
+type MemoryPressureOptions struct {
+	// HeapLimit is the Go heap size, in bytes, above which requests are
+	// shed. Disabled if 0.
+	HeapLimit int64
+	// CgroupWatermark is the fraction of the memory limit of the
+	// container's cgroup above which requests are shed, e.g. 0.9. Disabled
+	// if 0, or if the cgroup has no limit.
+	CgroupWatermark float64
+	// ExemptGroups are the groups of users whose requests are never shed,
+	// e.g. system:masters, so cluster administrators and nodes keep being
+	// served.
+	ExemptGroups []string
+	// Interval is how often memory usage is sampled. Defaults to 1s.
+	Interval time.Duration
+}
+
+func (o MemoryPressureOptions) enabled() bool {
+	return o.HeapLimit > 0 || o.CgroupWatermark > 0
+}
+
+// cgroupRoot is where the memory cgroup of the container is mounted.
+const cgroupRoot = "/sys/fs/cgroup"
+
+// memoryGuard samples memory usage, and sheds the requests of users that are
+// not exempt while it is above a watermark.
+type memoryGuard struct {
+	opts   MemoryPressureOptions
+	logger klog.Logger
+	exempt map[string]bool
+
+	// cgroupLimit is the limit of the memory cgroup, 0 if it has none or
+	// the cgroup watermark is disabled.
+	cgroupLimit int64
+	cgroup      cgroupFiles
+
+	pressure atomic.Bool
+}
+
+// cgroupFiles are the files of a memory cgroup: the usage, limit and
+// statistics, and the statistic of inactive page cache, which the kernel
+// reclaims before OOM killing.
+type cgroupFiles struct {
+	usage, limit, stat string
+	inactiveFile       string
+}
+
+var (
+	cgroupV2 = cgroupFiles{usage: "memory.current", limit: "memory.max", stat: "memory.stat", inactiveFile: "inactive_file"}
+	cgroupV1 = cgroupFiles{usage: "memory/memory.usage_in_bytes", limit: "memory/memory.limit_in_bytes", stat: "memory/memory.stat", inactiveFile: "total_inactive_file"}
+)
+
+// noCgroupLimit is the smallest cgroup v1 limit considered unlimited, which
+// is reported as the largest page aligned int64.
+const noCgroupLimit = 1 << 62
+
+func newMemoryGuard(opts MemoryPressureOptions, logger klog.Logger) *memoryGuard {
+	if opts.Interval <= 0 {
+		opts.Interval = time.Second
+	}
+	g := &memoryGuard{opts: opts, logger: logger, exempt: map[string]bool{}}
+	for _, group := range opts.ExemptGroups {
+		g.exempt[group] = true
+	}
+	if opts.CgroupWatermark > 0 {
+		for _, files := range []cgroupFiles{cgroupV2, cgroupV1} {
+			limit, err := readCgroupValue(files.limit)
+			if err != nil {
+				continue
+			}
+			g.cgroup = files
+			if limit > 0 && limit < noCgroupLimit {
+				g.cgroupLimit = limit
+			}
+			break
+		}
+		if g.cgroupLimit == 0 {
+			logger.Info("memory cgroup has no limit, only the heap limit sheds requests")
+		}
+	}
+	return g
+}
+
+// run samples memory usage until ctx is cancelled.
+func (g *memoryGuard) run(ctx context.Context) {
+	ticker := time.NewTicker(g.opts.Interval)
+	defer ticker.Stop()
+	for {
+		g.sample()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (g *memoryGuard) sample() {
+	samples := []runtimemetrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	runtimemetrics.Read(samples)
+	heap := int64(samples[0].Value.Uint64())
+	memoryHeapBytes.Set(float64(heap))
+	pressure := g.opts.HeapLimit > 0 && heap > g.opts.HeapLimit
+
+	var workingSet int64
+	if g.cgroupLimit > 0 {
+		var err error
+		if workingSet, err = g.workingSet(); err != nil {
+			g.logger.Error(err, "failed to read memory cgroup usage")
+		} else {
+			memoryCgroupBytes.Set(float64(workingSet))
+			pressure = pressure || float64(workingSet) > g.opts.CgroupWatermark*float64(g.cgroupLimit)
+		}
+	}
+
+	if g.pressure.Swap(pressure) != pressure {
+		if pressure {
+			g.logger.Info("memory pressure, shedding requests", "heap", heap, "heapLimit", g.opts.HeapLimit, "workingSet", workingSet, "cgroupLimit", g.cgroupLimit)
+			memoryPressureActive.Set(1)
+		} else {
+			g.logger.Info("memory pressure relieved", "heap", heap, "workingSet", workingSet)
+			memoryPressureActive.Set(0)
+		}
+	}
+}
+
+// workingSet returns the usage of the memory cgroup less its inactive page
+// cache, as the kubelet computes it.
+func (g *memoryGuard) workingSet() (int64, error) {
+	usage, err := readCgroupValue(g.cgroup.usage)
+	if err != nil {
+		return 0, err
+	}
+	f, err := os.Open(filepath.Join(cgroupRoot, g.cgroup.stat))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		name, value, ok := strings.Cut(scanner.Text(), " ")
+		if !ok || name != g.cgroup.inactiveFile {
+			continue
+		}
+		if inactive, err := strconv.ParseInt(value, 10, 64); err == nil && inactive < usage {
+			usage -= inactive
+		}
+		break
+	}
+	return usage, scanner.Err()
+}
+
+// readCgroupValue reads a cgroup file holding a number, or max for no limit,
+// returned as 0.
+func readCgroupValue(name string) (int64, error) {
+	raw, err := os.ReadFile(filepath.Join(cgroupRoot, name))
+	if err != nil {
+		return 0, err
+	}
+	value := strings.TrimSpace(string(raw))
+	if value == "max" {
+		return 0, nil
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// shed returns whether to fail req fast because of memory pressure. It is
+// called before the body of req is read, so the objects of shed requests are
+// never loaded: only the user info of the review is decoded to exempt users,
+// and the body is restored for the requests served.
+func (g *memoryGuard) shed(req *http.Request) bool {
+	if !g.pressure.Load() {
+		return false
+	}
+	if len(g.exempt) == 0 {
+		return true
+	}
+
+	var read bytes.Buffer
+	user, err := peekUserInfo(io.TeeReader(req.Body, &read))
+	req.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(&read, req.Body), req.Body}
+	if err != nil || user == nil {
+		return true
+	}
+	for _, group := range user.Groups {
+		if g.exempt[group] {
+			return false
+		}
+	}
+	return true
+}
+
+// peekUserInfo decodes request.userInfo from the admission review read from
+// r, skipping the other fields. It returns nil if the user info does not
+// precede the object of the request, as the API server sends it, so large
+// objects are not decoded.
+func peekUserInfo(r io.Reader) (*authenticationv1.UserInfo, error) {
+	decoder := json.NewDecoder(r)
+	// skip returns the fields of an object until one of names, decoding the
+	// others
+	skip := func(names ...string) (string, error) {
+		for decoder.More() {
+			token, err := decoder.Token()
+			if err != nil {
+				return "", err
+			}
+			name, _ := token.(string)
+			for _, n := range names {
+				if name == n {
+					return name, nil
+				}
+			}
+			var value json.RawMessage
+			if err := decoder.Decode(&value); err != nil {
+				return "", err
+			}
+		}
+		return "", nil
+	}
+	openObject := func() error {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		if token != json.Delim('{') {
+			return errors.New("admission review is not an object")
+		}
+		return nil
+	}
+
+	if err := openObject(); err != nil {
+		return nil, err
+	}
+	if name, err := skip("request"); err != nil || name == "" {
+		return nil, err
+	}
+	if err := openObject(); err != nil {
+		return nil, err
+	}
+	name, err := skip("userInfo", "object", "oldObject")
+	if err != nil || name != "userInfo" {
+		return nil, err
+	}
+	user := &authenticationv1.UserInfo{}
+	if err := decoder.Decode(user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// fail responds to a shed request with 503 Service Unavailable, which the
+// API server handles according to the failure policy of the webhook.
+func (g *memoryGuard) fail(w http.ResponseWriter, req *http.Request) {
+	memoryPressureShed.WithLabelValues(req.URL.Path).Inc()
+	w.Header().Set("Retry-After", "1")
+	http.Error(w, "kubeenforcer is under memory pressure, retry later", http.StatusServiceUnavailable)
+}
@@ -0,0 +1,91 @@
+package webhook
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"k8s.io/klog/v2"
+)
+
+func TestMemoryGuardShed(t *testing.T) {
+	const admin = `{"apiVersion":"admission.k8s.io/v1","kind":"AdmissionReview","request":{"uid":"1","kind":{"group":"","version":"v1","kind":"Pod"},"operation":"CREATE","userInfo":{"username":"admin","groups":["system:masters","system:authenticated"]},"object":{"kind":"Pod"}}}`
+	const user = `{"apiVersion":"admission.k8s.io/v1","kind":"AdmissionReview","request":{"uid":"1","operation":"CREATE","userInfo":{"username":"alice","groups":["system:authenticated"]},"object":{"kind":"Pod"}}}`
+	const adminAfterObject = `{"apiVersion":"admission.k8s.io/v1","kind":"AdmissionReview","request":{"uid":"1","object":{"kind":"Pod"},"userInfo":{"username":"admin","groups":["system:masters"]}}}`
+
+	tests := []struct {
+		name     string
+		pressure bool
+		exempt   []string
+		body     string
+		want     bool
+	}{
+		{
+			name: "no pressure",
+			body: user,
+		},
+		{
+			name:     "pressure",
+			pressure: true,
+			body:     user,
+			want:     true,
+		},
+		{
+			name:     "pressure without exempt groups does not read the body",
+			pressure: true,
+			body:     "not json",
+			want:     true,
+		},
+		{
+			name:     "exempt user",
+			pressure: true,
+			exempt:   []string{"system:masters"},
+			body:     admin,
+		},
+		{
+			name:     "user not exempt",
+			pressure: true,
+			exempt:   []string{"system:masters"},
+			body:     user,
+			want:     true,
+		},
+		{
+			name:     "user info after the object",
+			pressure: true,
+			exempt:   []string{"system:masters"},
+			body:     adminAfterObject,
+			want:     true,
+		},
+		{
+			name:     "malformed review",
+			pressure: true,
+			exempt:   []string{"system:masters"},
+			body:     `{"request": [`,
+			want:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newMemoryGuard(MemoryPressureOptions{HeapLimit: 1, ExemptGroups: tt.exempt}, klog.Background())
+			g.pressure.Store(tt.pressure)
+			req := httptest.NewRequest(http.MethodPost, "/validate", strings.NewReader(tt.body))
+
+			if got := g.shed(req); got != tt.want {
+				t.Errorf("shed() = %v, want %v", got, tt.want)
+			}
+			if tt.want {
+				return
+			}
+			// Served requests are read whole
+			body, err := io.ReadAll(req.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != tt.body {
+				t.Errorf("body = %q, want %q", body, tt.body)
+			}
+		})
+	}
+}
@@ -0,0 +1,256 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apiserver/pkg/admission"
+
+	"github.com/kubescape/kubeenforcer/pkg/remediation"
+)
+
+// Mutator changes objects admitted through /mutate.
+type Mutator interface {
+	// Mutate returns object, the JSON encoding of the object in attrs, with
+	// its changes applied, and warnings to return to the client.
+	Mutate(ctx context.Context, attrs admission.Attributes, o admission.ObjectInterfaces, object []byte) ([]byte, []string, error)
+}
+
+var deniedPolicyPattern = regexp.MustCompile(`ValidatingAdmissionPolicy '([^']+)'`)
+
+// handleWebhookMutate runs the mutators in order, then fixes violations of
+// auto-remediated policies with the remediation of the violated policy. It
+// only denies when a mutator fails: violations it cannot fix are left to
+// /validate.
+func (wh *webhook) handleWebhookMutate(w http.ResponseWriter, req *http.Request) {
+	wh.inFlight.Add(1)
+	defer wh.inFlight.Add(-1)
+
+	if wh.memory != nil && wh.memory.shed(req) {
+		wh.memory.fail(w, req)
+		return
+	}
+	parsed, err := parseRequest(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := &admissionv1.AdmissionResponse{UID: parsed.Request.UID, Allowed: true}
+	if wh.validator.Handles(admission.Operation(parsed.Request.Operation)) && len(parsed.Request.Object.Raw) > 0 {
+		redact := wh.redactor.ForRequest(parsed.Request)
+		mutated, warnings, err := wh.mutate(req.Context(), parsed.Request)
+		if err != nil {
+			wh.logger.Error(errors.New(redact(err.Error())), "failed to mutate object", "uid", parsed.Request.UID)
+			response.Allowed = false
+			response.Result = &metav1.Status{
+				Code:    http.StatusForbidden,
+				Message: err.Error(),
+				Reason:  metav1.StatusReasonForbidden,
+			}
+		} else if patch, err := createPatch(parsed.Request.Object.Raw, mutated); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		} else if len(patch) > 0 {
+			raw, err := json.Marshal(patch)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			patchType := admissionv1.PatchTypeJSONPatch
+			response.Patch = raw
+			response.PatchType = &patchType
+			response.Warnings = warnings
+			wh.logger.V(2).Info("mutated object", "uid", parsed.Request.UID, "resource", parsed.Request.Resource.Resource, "namespace", parsed.Request.Namespace, "name", parsed.Request.Name, "warnings", redact.All(warnings))
+		}
+	}
+
+	wh.stamp.stamp(response)
+	out, err := json.Marshal(&admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "AdmissionReview",
+			APIVersion: "admission.k8s.io/v1",
+		},
+		Response: response,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(out)
+}
+
+// mutate returns the object of request after all mutators and remediations
+// were applied.
+func (wh *webhook) mutate(ctx context.Context, request *admissionv1.AdmissionRequest) ([]byte, []string, error) {
+	var oldObject runtime.Object
+	if len(request.OldObject.Raw) > 0 {
+		var err error
+		oldObject, _, err = wh.decodeObject(request.OldObject.Raw, request.Kind)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var warnings []string
+	raw := request.Object.Raw
+	for _, mutator := range wh.mutators {
+		object, _, err := wh.decodeObject(raw, request.Kind)
+		if err != nil {
+			return nil, nil, err
+		}
+		mutated, mutatorWarnings, err := mutator.Mutate(ctx, newAttributes(request, object, oldObject), wh.objectInferfaces, raw)
+		if err != nil {
+			return nil, nil, err
+		}
+		raw = mutated
+		warnings = append(warnings, mutatorWarnings...)
+	}
+
+	if wh.autoRemediate != nil {
+		remediated, remediationWarnings, err := wh.remediate(ctx, request, oldObject, raw)
+		if err != nil {
+			// Keep the mutations; the violation is left to /validate
+			wh.logger.Error(err, "failed to remediate object", "uid", request.UID)
+		}
+		raw = remediated
+		warnings = append(warnings, remediationWarnings...)
+	}
+	return raw, warnings, nil
+}
+
+// remediate validates the object and, while it is denied by an
+// auto-remediated policy with a known fix, applies the fix and validates
+// again. Denials can combine the messages of several policies, whose fixes
+// are applied in turn. It returns the fixed object and a warning per applied
+// fix.
+func (wh *webhook) remediate(ctx context.Context, request *admissionv1.AdmissionRequest, oldObject runtime.Object, raw []byte) ([]byte, []string, error) {
+	var warnings []string
+	remediated := map[string]bool{}
+	for {
+		object, _, err := wh.decodeObject(raw, request.Kind)
+		if err != nil {
+			return raw, warnings, err
+		}
+		attrs := newAttributes(request, object, oldObject)
+		denial := wh.validator.Validate(ctx, attrs, wh.objectInferfaces)
+		if denial == nil {
+			return raw, warnings, nil
+		}
+
+		// Each policy is fixed once, so a remediation that does not satisfy
+		// its policy cannot loop
+		policy, hint := wh.nextRemediation(denial.Error(), attrs, remediated)
+		if hint == nil {
+			return raw, warnings, nil
+		}
+		remediated[policy] = true
+
+		ops, err := json.Marshal(hint.Patch)
+		if err != nil {
+			return raw, warnings, err
+		}
+		decoded, err := jsonpatch.DecodePatch(ops)
+		if err != nil {
+			return raw, warnings, err
+		}
+		fixed, err := decoded.Apply(raw)
+		if err != nil {
+			return raw, warnings, err
+		}
+
+		raw = fixed
+		warnings = append(warnings, fmt.Sprintf("kubeenforcer auto-remediated policy %s: %s", policy, hint.Summary))
+	}
+}
+
+// nextRemediation returns the first policy denying in message that is
+// auto-remediated, not in remediated, and has a fix, with the hint of the
+// fix. Each policy's hint is found from its own part of message, which runs
+// until the next policy's.
+func (wh *webhook) nextRemediation(message string, attrs admission.Attributes, remediated map[string]bool) (string, *remediation.Hint) {
+	matches := deniedPolicyPattern.FindAllStringSubmatchIndex(message, -1)
+	for i, match := range matches {
+		policy := message[match[2]:match[3]]
+		if remediated[policy] || !wh.autoRemediate.AutoRemediate(policy) {
+			continue
+		}
+		end := len(message)
+		if i+1 < len(matches) {
+			end = matches[i+1][0]
+		}
+		if hint := remediation.For(message[match[0]:end], attrs); hint != nil && len(hint.Patch) > 0 {
+			return policy, hint
+		}
+	}
+	return "", nil
+}
+
+// createPatch returns the JSON patch turning original into mutated. Objects
+// are compared field by field; any other changed value is replaced whole.
+func createPatch(original, mutated []byte) ([]remediation.PatchOperation, error) {
+	var from, to interface{}
+	if err := json.Unmarshal(original, &from); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(mutated, &to); err != nil {
+		return nil, err
+	}
+	return diff("", from, to, nil), nil
+}
+
+func diff(path string, from, to interface{}, patch []remediation.PatchOperation) []remediation.PatchOperation {
+	fromMap, fromIsMap := from.(map[string]interface{})
+	toMap, toIsMap := to.(map[string]interface{})
+	if !fromIsMap || !toIsMap {
+		if !reflect.DeepEqual(from, to) {
+			patch = append(patch, remediation.PatchOperation{Op: "replace", Path: path, Value: to})
+		}
+		return patch
+	}
+
+	keys := make([]string, 0, len(fromMap)+len(toMap))
+	for k := range fromMap {
+		keys = append(keys, k)
+	}
+	for k := range toMap {
+		if _, ok := fromMap[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		fieldPath := path + "/" + escapePointer(k)
+		fromValue, inFrom := fromMap[k]
+		toValue, inTo := toMap[k]
+		switch {
+		case !inTo:
+			patch = append(patch, remediation.PatchOperation{Op: "remove", Path: fieldPath})
+		case !inFrom:
+			patch = append(patch, remediation.PatchOperation{Op: "add", Path: fieldPath, Value: toValue})
+		default:
+			patch = diff(fieldPath, fromValue, toValue, patch)
+		}
+	}
+	return patch
+}
+
+var pointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+func escapePointer(key string) string {
+	return pointerEscaper.Replace(key)
+}
@@ -0,0 +1,117 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apiserver/pkg/admission"
+	clientsetscheme "k8s.io/client-go/kubernetes/scheme"
+)
+
+// controlValidator denies pods violating C-0057, privileged containers, and
+// C-0048, hostPath volumes, in a single denial combining the messages of
+// its policies, like tiers of policy priorities do.
+type controlValidator struct{}
+
+func (controlValidator) Handles(admission.Operation) bool { return true }
+func (controlValidator) Validate(ctx context.Context, a admission.Attributes, o admission.ObjectInterfaces) error {
+	pod, ok := a.GetObject().(*corev1.Pod)
+	if !ok {
+		return nil
+	}
+	var messages []string
+	for _, c := range pod.Spec.Containers {
+		if c.SecurityContext != nil && c.SecurityContext.Privileged != nil && *c.SecurityContext.Privileged {
+			messages = append(messages, "ValidatingAdmissionPolicy 'privileged' with binding 'privileged' denied request: C-0057 privileged containers are not allowed")
+			break
+		}
+	}
+	for _, v := range pod.Spec.Volumes {
+		if v.HostPath != nil {
+			messages = append(messages, "ValidatingAdmissionPolicy 'host-path' with binding 'host-path' denied request: C-0048 hostPath volumes are not allowed")
+			break
+		}
+	}
+	if len(messages) == 0 {
+		return nil
+	}
+	return admission.NewForbidden(a, errors.New(strings.Join(messages, "; ")))
+}
+
+type autoRemediated map[string]bool
+
+func (p autoRemediated) AutoRemediate(policy string) bool { return p[policy] }
+
+func TestRemediate(t *testing.T) {
+	raw := []byte(`{
+		"apiVersion": "v1",
+		"kind": "Pod",
+		"metadata": {"name": "app", "namespace": "default"},
+		"spec": {
+			"containers": [{"name": "app", "image": "app", "securityContext": {"privileged": true}, "volumeMounts": [{"name": "host", "mountPath": "/host"}]}],
+			"volumes": [{"name": "host", "hostPath": {"path": "/"}}]
+		}
+	}`)
+
+	tests := []struct {
+		name     string
+		policies autoRemediated
+		// remediated are the policies whose fixes are applied, in order
+		remediated []string
+	}{
+		{
+			name:       "every denying policy",
+			policies:   autoRemediated{"privileged": true, "host-path": true},
+			remediated: []string{"privileged", "host-path"},
+		},
+		{
+			name:       "policies after one that is not auto-remediated",
+			policies:   autoRemediated{"host-path": true},
+			remediated: []string{"host-path"},
+		},
+		{
+			name:     "none",
+			policies: autoRemediated{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wh := New("", WithScheme(clientsetscheme.Scheme), WithValidators(controlValidator{}), WithAutoRemediation(tt.policies)).(*webhook)
+			request := &admissionv1.AdmissionRequest{
+				Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+				Resource:  metav1.GroupVersionResource{Version: "v1", Resource: "pods"},
+				Namespace: "default",
+				Name:      "app",
+				Operation: admissionv1.Create,
+			}
+			fixed, warnings, err := wh.remediate(context.Background(), request, nil, raw)
+			if err != nil {
+				t.Fatalf("remediate() error = %v", err)
+			}
+			if len(warnings) != len(tt.remediated) {
+				t.Fatalf("remediate() warnings = %q, want one for each of %v", warnings, tt.remediated)
+			}
+			for i, policy := range tt.remediated {
+				if !strings.HasPrefix(warnings[i], "kubeenforcer auto-remediated policy "+policy+": ") {
+					t.Errorf("remediate() warning %d = %q, want one for %s", i, warnings[i], policy)
+				}
+			}
+			// Violations left in the object, by policy
+			violations := map[string]string{"privileged": `"privileged":true`, "host-path": `"hostPath"`}
+			for policy, violation := range violations {
+				if left := strings.Contains(compactJSON(fixed), violation); left == contains(tt.remediated, policy) {
+					t.Errorf("remediate() left the violation of %s = %t, want %t: %s", policy, left, !left, fixed)
+				}
+			}
+		})
+	}
+}
+
+func compactJSON(raw []byte) string {
+	return strings.Join(strings.Fields(string(raw)), "")
+}
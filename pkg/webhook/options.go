@@ -0,0 +1,363 @@
+package webhook
+
+import (
+	"fmt"
+	"net/http"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apiserver/pkg/admission"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/klog/v2"
+
+	"github.com/kubescape/kubeenforcer/pkg/alertmanager"
+	"github.com/kubescape/kubeenforcer/pkg/decision"
+	"github.com/kubescape/kubeenforcer/pkg/redaction"
+	"github.com/kubescape/kubeenforcer/pkg/remediation"
+
+	listers "k8s.io/cel-admission-webhook/pkg/generated/listers/admissionregistration.x-k8s.io/v1alpha1"
+)
+
+// Option configures the webhook created by New.
+type Option func(*config)
+
+type config struct {
+	certFile, keyFile string
+	listeners         []Listener
+	httpOptions       HTTPOptions
+	healthOptions     HealthOptions
+	authOptions       AuthOptions
+	validators        chain
+	paths             []validatePath
+	readinessChecks   []namedCheck
+	alerter           *alertmanager.AlertManager
+	decisions         []decision.Sink
+	mutators          []Mutator
+	autoRemediate     remediation.Policies
+	policies          listers.ValidatingAdmissionPolicyLister
+	scheme            *runtime.Scheme
+	objectInterfaces  admission.ObjectInterfaces
+	coercer           Coercer
+	largeObjectSize   int
+	admin             http.Handler
+	api               http.Handler
+	explainer         Explainer
+	internalErrors    InternalErrorOptions
+	outcomes          OutcomeTracker
+	mirror            Mirror
+	stamp             *versionStamp
+	versionInfo       *VersionInfo
+	names             NameResolver
+	helmReleases      HelmReleaseResolver
+	scaleTargets      dynamic.Interface
+	bindingTargets    dynamic.Interface
+	denyStorm         DenyStormOptions
+	memoryPressure    MemoryPressureOptions
+	selfTest          SelfTestOptions
+	logAllowedEvery   int
+	redactor          *redaction.Redactor
+	logger            klog.Logger
+}
+
+// WithTLS sets the serving certificate and key of the address passed to New,
+// and of listeners that do not set their own.
+func WithTLS(certFile, keyFile string) Option {
+	return func(c *config) {
+		c.certFile = certFile
+		c.keyFile = keyFile
+	}
+}
+
+// WithListeners serves the webhook on additional listeners.
+func WithListeners(listeners ...Listener) Option {
+	return func(c *config) {
+		c.listeners = append(c.listeners, listeners...)
+	}
+}
+
+// WithHTTPOptions tunes connection handling and shutdown timeouts.
+func WithHTTPOptions(opts HTTPOptions) Option {
+	return func(c *config) {
+		c.httpOptions = opts
+	}
+}
+
+// WithHealthOptions configures the health and readiness checks.
+func WithHealthOptions(opts HealthOptions) Option {
+	return func(c *config) {
+		c.healthOptions = opts
+	}
+}
+
+// WithAuth requires callers to authenticate. It also enables the admin
+// endpoints if opts has admin users or groups.
+func WithAuth(opts AuthOptions) Option {
+	return func(c *config) {
+		c.authOptions = opts
+	}
+}
+
+// WithValidator appends a validator named name to the chain requests are
+// validated by. Validators are run in order, and the first denial is
+// returned. Errors that are not denials fail the request if failurePolicy is
+// Fail, and are ignored if it is Ignore. Requests are allowed if no validator
+// is configured.
+func WithValidator(name string, validator admission.ValidationInterface, failurePolicy admissionregistrationv1.FailurePolicyType) Option {
+	return func(c *config) {
+		c.validators = append(c.validators, chainedValidator{name: name, validator: validator, failurePolicy: failurePolicy})
+	}
+}
+
+// WithValidators appends validators to the chain, with the Fail failure
+// policy. They are named after their position in the chain.
+func WithValidators(validators ...admission.ValidationInterface) Option {
+	return func(c *config) {
+		for _, v := range validators {
+			name := fmt.Sprintf("validator-%d", len(c.validators))
+			c.validators = append(c.validators, chainedValidator{name: name, validator: v, failurePolicy: admissionregistrationv1.Fail})
+		}
+	}
+}
+
+type validatePath struct {
+	path       string
+	validators []string
+}
+
+// WithValidatePath serves path, e.g. /validate/workloads, validating
+// requests with only the validators of the chain named in validators, so
+// each can be registered as a webhook with its own rules, failure policy
+// and timeout. Passing /validate restricts its chain too. Unknown names are
+// ignored.
+func WithValidatePath(path string, validators ...string) Option {
+	return func(c *config) {
+		c.paths = append(c.paths, validatePath{path: path, validators: validators})
+	}
+}
+
+// WithReadinessCheck fails readiness while check returns an error, in
+// addition to the validators that implement HealthChecker.
+func WithReadinessCheck(name string, check ReadinessCheck) Option {
+	return func(c *config) {
+		c.readinessChecks = append(c.readinessChecks, namedCheck{name: name, check: check})
+	}
+}
+
+// WithAlertManager sends alerts for audited and denied requests to alerter.
+func WithAlertManager(alerter *alertmanager.AlertManager) Option {
+	return func(c *config) {
+		c.alerter = alerter
+	}
+}
+
+// WithDecisionSinks records every decision in sinks.
+func WithDecisionSinks(sinks ...decision.Sink) Option {
+	return func(c *config) {
+		c.decisions = append(c.decisions, sinks...)
+	}
+}
+
+// WithMutators serves /mutate, which applies mutators in order.
+func WithMutators(mutators ...Mutator) Option {
+	return func(c *config) {
+		c.mutators = append(c.mutators, mutators...)
+	}
+}
+
+// WithAutoRemediation serves /mutate, which fixes violations of policies
+// instead of denying them.
+func WithAutoRemediation(policies remediation.Policies) Option {
+	return func(c *config) {
+		c.autoRemediate = policies
+	}
+}
+
+// WithPolicies reads the denial status annotations of policies through
+// lister.
+func WithPolicies(lister listers.ValidatingAdmissionPolicyLister) Option {
+	return func(c *config) {
+		c.policies = lister
+	}
+}
+
+// WithScheme decodes objects with scheme. Defaults to the client-go scheme.
+func WithScheme(scheme *runtime.Scheme) Option {
+	return func(c *config) {
+		c.scheme = scheme
+	}
+}
+
+// WithObjectInterfaces creates, converts and maps the equivalent resources
+// of objects with o instead of the interfaces of the scheme, e.g. to support
+// custom resources.
+func WithObjectInterfaces(o admission.ObjectInterfaces) Option {
+	return func(c *config) {
+		c.objectInterfaces = o
+	}
+}
+
+// WithCoercer coerces objects decoded as unstructured with coercer before
+// they are evaluated.
+func WithCoercer(coercer Coercer) Option {
+	return func(c *config) {
+		c.coercer = coercer
+	}
+}
+
+// WithLargeObjectThreshold handles admission reviews whose objects are
+// larger than size bytes in total with less memory: their objects are
+// decoded straight into unstructured objects, without a typed copy, and the
+// raw objects are released as soon as they are decoded. Disabled if 0.
+func WithLargeObjectThreshold(size int) Option {
+	return func(c *config) {
+		c.largeObjectSize = size
+	}
+}
+
+// WithAdminHandler serves handler under /admin/. It is only served when
+// authentication and admin users or groups are configured, to them.
+func WithAdminHandler(handler http.Handler) Option {
+	return func(c *config) {
+		c.admin = handler
+	}
+}
+
+// WithAPIHandler serves handler under /api/. Like the admin endpoints, it is
+// only served when authentication and admin users or groups are configured.
+func WithAPIHandler(handler http.Handler) Option {
+	return func(c *config) {
+		c.api = handler
+	}
+}
+
+// WithExplainer returns traces of evaluations to callers asking for them.
+func WithExplainer(explainer Explainer) Option {
+	return func(c *config) {
+		c.explainer = explainer
+	}
+}
+
+// WithInternalErrorOptions sets how requests that cannot be evaluated
+// because of internal errors are answered.
+func WithInternalErrorOptions(opts InternalErrorOptions) Option {
+	return func(c *config) {
+		c.internalErrors = opts
+	}
+}
+
+// WithOutcomeTracker reports the outcome of every validation to tracker.
+func WithOutcomeTracker(tracker OutcomeTracker) Option {
+	return func(c *config) {
+		c.outcomes = tracker
+	}
+}
+
+// WithMirror sends a sample of the admission reviews validated to mirror.
+func WithMirror(mirror Mirror) Option {
+	return func(c *config) {
+		c.mirror = mirror
+	}
+}
+
+// WithVersionStamp adds version, the kubeenforcer version, and the hash of
+// policySet as audit annotations to every admission response and decision
+// record, so decisions can be tied to the policies that produced them.
+func WithVersionStamp(version string, policySet PolicySet) Option {
+	return func(c *config) {
+		c.stamp = &versionStamp{version: version, policySet: policySet}
+	}
+}
+
+// WithVersionInfo serves info on /version, unauthenticated like the health
+// endpoints.
+func WithVersionInfo(info VersionInfo) Option {
+	return func(c *config) {
+		c.versionInfo = &info
+	}
+}
+
+// WithScaleTargetMetadata reads the workloads scaled by scale subresource
+// requests through client, and adds their labels and annotations to the
+// Scale objects policies are evaluated against.
+func WithScaleTargetMetadata(client dynamic.Interface) Option {
+	return func(c *config) {
+		c.scaleTargets = client
+	}
+}
+
+// WithBindingMetadata reads the pods and nodes of pod binding requests
+// through client, and adds the labels and annotations of the pod, and the
+// labels of the node as target.labels, to the Binding objects policies are
+// evaluated against.
+func WithBindingMetadata(client dynamic.Interface) Option {
+	return func(c *config) {
+		c.bindingTargets = client
+	}
+}
+
+// WithDenyStormProtection throttles the denials of users whose requests are
+// denied more often than opts allow: they are returned as rate limited, and
+// replaced by a single alert.
+func WithDenyStormProtection(opts DenyStormOptions) Option {
+	return func(c *config) {
+		c.denyStorm = opts
+	}
+}
+
+// WithMemoryPressureShedding fails the requests of users that are not
+// exempt fast, without evaluating them, while memory usage is above the
+// watermarks of opts.
+func WithMemoryPressureShedding(opts MemoryPressureOptions) Option {
+	return func(c *config) {
+		c.memoryPressure = opts
+	}
+}
+
+// WithLogSampling logs the review response of only one in every allowed
+// requests, to cut log volume on large clusters. Denials are always logged.
+func WithLogSampling(every int) Option {
+	return func(c *config) {
+		c.logAllowedEvery = every
+	}
+}
+
+// WithRedactor masks the values of the fields selected by redactor in logs,
+// decision records and alerts.
+func WithRedactor(redactor *redaction.Redactor) Option {
+	return func(c *config) {
+		c.redactor = redactor
+	}
+}
+
+// WithLogger logs through logger instead of the package logger.
+func WithLogger(logger klog.Logger) Option {
+	return func(c *config) {
+		c.logger = logger
+	}
+}
+
+// WithSelfTest runs a self-test when the webhook starts, failing readiness
+// until it passes.
+func WithSelfTest(opts SelfTestOptions) Option {
+	return func(c *config) {
+		c.selfTest = opts
+	}
+}
+
+// WithNameResolver reports allowed objects created with generateName by the
+// name resolved by resolver, deferring their alerts and decisions until
+// then.
+func WithNameResolver(resolver NameResolver) Option {
+	return func(c *config) {
+		c.names = resolver
+	}
+}
+
+// WithHelmReleases resolves the Helm releases of objects that carry no
+// meta.helm.sh annotations from their app.kubernetes.io labels through
+// resolver, and the charts of those that do not name theirs.
+func WithHelmReleases(resolver HelmReleaseResolver) Option {
+	return func(c *config) {
+		c.helmReleases = resolver
+	}
+}
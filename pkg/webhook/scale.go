@@ -0,0 +1,57 @@
+package webhook
+
+import (
+	"context"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// scaleSubresource is the subresource of requests changing replica counts.
+const scaleSubresource = "scale"
+
+// addScaleTargetMetadata copies the labels and annotations of the workload
+// scaled by request into its Scale objects, which carry none, so object
+// selectors and expressions can tell workloads apart on scale requests.
+// Labels and annotations of the Scale take precedence.
+func (wh *webhook) addScaleTargetMetadata(ctx context.Context, request *admissionv1.AdmissionRequest, objects ...runtime.Object) error {
+	gvr := schema.GroupVersionResource{
+		Group:    request.Resource.Group,
+		Version:  request.Resource.Version,
+		Resource: request.Resource.Resource,
+	}
+	target, err := wh.scaleTargets.Resource(gvr).Namespace(request.Namespace).Get(ctx, request.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	for _, obj := range objects {
+		if obj == nil {
+			continue
+		}
+		accessor, err := meta.Accessor(obj)
+		if err != nil {
+			return err
+		}
+		accessor.SetLabels(merge(target.GetLabels(), accessor.GetLabels()))
+		accessor.SetAnnotations(merge(target.GetAnnotations(), accessor.GetAnnotations()))
+	}
+	return nil
+}
+
+func merge(base, overrides map[string]string) map[string]string {
+	if len(base) == 0 {
+		return overrides
+	}
+	merged := make(map[string]string, len(base)+len(overrides))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range overrides {
+		merged[k] = v
+	}
+	return merged
+}
@@ -0,0 +1,266 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/kubescape/kubeenforcer/pkg/metrics"
+)
+
+var selfTestPassed = prometheus.NewGauge(prometheus.GaugeOpts{
+	Namespace: metrics.Namespace,
+	Name:      "self_test_passed",
+	Help:      "Whether the startup self-test passed. Readiness fails until it does.",
+})
+
+func init() {
+	metrics.Registry.MustRegister(selfTestPassed)
+}
+
+// selfTestHeader carries the token of self-test requests, which are
+// evaluated without alerts, decisions nor metrics.
+const selfTestHeader = "X-Kubeenforcer-Self-Test"
+
+// SelfTestCheck is an additional check of the self-test, e.g. a dry-run of
+// an alert sink.
+type SelfTestCheck func(ctx context.Context) error
+
+// SelfTestOptions configures the self-test run when the webhook starts, which
+// readiness fails until it passes, so misconfigurations are caught before the
+// API server depends on the webhook. It checks that the policies compile,
+// sends a synthetic admission review to /validate of the first listener over
+// TLS, and runs Checks.
+type SelfTestOptions struct {
+	Enabled bool
+	// Timeout bounds each attempt. Attempts are repeated every Interval
+	// until one passes.
+	Timeout  time.Duration
+	Interval time.Duration
+	Checks   map[string]SelfTestCheck
+}
+
+// selfTest is the state of the self-test of a webhook.
+type selfTest struct {
+	opts  SelfTestOptions
+	token string
+
+	lock   sync.Mutex
+	passed bool
+	err    error
+}
+
+func newSelfTest(opts SelfTestOptions) *selfTest {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = 5 * time.Second
+	}
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		panic(err)
+	}
+	return &selfTest{opts: opts, token: hex.EncodeToString(token), err: errors.New("not run yet")}
+}
+
+// ready returns the error of the last attempt until one passed.
+func (s *selfTest) ready() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.passed {
+		return nil
+	}
+	return fmt.Errorf("self-test: %w", s.err)
+}
+
+// is returns whether req was sent by the self-test.
+func (s *selfTest) is(req *http.Request) bool {
+	if s == nil {
+		return false
+	}
+	header := req.Header.Get(selfTestHeader)
+	return header != "" && subtle.ConstantTimeCompare([]byte(header), []byte(s.token)) == 1
+}
+
+// runSelfTest runs the self-test until it passes or ctx is cancelled.
+func (wh *webhook) runSelfTest(ctx context.Context) {
+	ticker := time.NewTicker(wh.selfTest.opts.Interval)
+	defer ticker.Stop()
+	for {
+		attemptCtx, cancel := context.WithTimeout(ctx, wh.selfTest.opts.Timeout)
+		err := wh.selfTestOnce(attemptCtx)
+		cancel()
+
+		wh.selfTest.lock.Lock()
+		wh.selfTest.passed, wh.selfTest.err = err == nil, err
+		wh.selfTest.lock.Unlock()
+		if err == nil {
+			selfTestPassed.Set(1)
+			wh.logger.Info("self-test passed")
+			return
+		}
+		wh.logger.Error(err, "self-test failed, failing readiness")
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (wh *webhook) selfTestOnce(ctx context.Context) error {
+	if err := checkValidators(wh.validators, wh.readinessChecks); err != nil {
+		return err
+	}
+	if err := checkCompiled(wh.validators); err != nil {
+		return err
+	}
+	if err := wh.loopbackReview(ctx); err != nil {
+		return fmt.Errorf("loopback review: %w", err)
+	}
+
+	names := make([]string, 0, len(wh.selfTest.opts.Checks))
+	for name := range wh.selfTest.opts.Checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := wh.selfTest.opts.Checks[name](ctx); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// checkCompiled returns an error listing the problems reported by the
+// validators of the chain, e.g. policies that failed to compile.
+func checkCompiled(validators chain) error {
+	var problems []string
+	for _, v := range validators {
+		if checker, ok := v.validator.(HealthChecker); ok {
+			warnings, _ := checker.Health()
+			for _, w := range warnings {
+				problems = append(problems, v.name+": "+w)
+			}
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	sort.Strings(problems)
+	return fmt.Errorf("policies failed to compile: %s", strings.Join(problems, "; "))
+}
+
+// loopbackReview sends a synthetic admission review, the dry-run creation of
+// a ConfigMap, to /validate of the first listener, and checks that an
+// admission review answering it is returned, whether allowed or denied.
+func (wh *webhook) loopbackReview(ctx context.Context) error {
+	listener := wh.listeners[0]
+	network, address := "tcp", listener.Addr
+	if i := strings.Index(address, "://"); i >= 0 {
+		network, address = address[:i], address[i+len("://"):]
+	}
+	if network != "unix" {
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+		if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+			host = "localhost"
+		}
+		address = net.JoinHostPort(host, port)
+	}
+
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, address)
+		},
+		// The serving certificate names the service rather than the
+		// loopback address; it is checked against its key by readiness
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}
+	defer transport.CloseIdleConnections()
+
+	uid := types.UID("kubeenforcer-self-test-" + time.Now().UTC().Format("20060102T150405.000000000"))
+	dryRun := true
+	object, err := json.Marshal(map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata":   map[string]interface{}{"name": "kubeenforcer-self-test", "namespace": "default"},
+		"data":       map[string]string{"self-test": "true"},
+	})
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(&admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{Kind: "AdmissionReview", APIVersion: "admission.k8s.io/v1"},
+		Request: &admissionv1.AdmissionRequest{
+			UID:       uid,
+			Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "ConfigMap"},
+			Resource:  metav1.GroupVersionResource{Version: "v1", Resource: "configmaps"},
+			Name:      "kubeenforcer-self-test",
+			Namespace: "default",
+			Operation: admissionv1.Create,
+			UserInfo:  authenticationv1.UserInfo{Username: "system:kubeenforcer:self-test"},
+			Object:    runtime.RawExtension{Raw: object},
+			DryRun:    &dryRun,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://localhost/validate", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(selfTestHeader, wh.selfTest.token)
+	resp, err := (&http.Client{Transport: transport}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	out, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(out)))
+	}
+
+	var review admissionv1.AdmissionReview
+	if err := json.Unmarshal(out, &review); err != nil {
+		return fmt.Errorf("invalid response: %w", err)
+	}
+	if review.Response == nil || review.Response.UID != uid {
+		return errors.New("response does not answer the request")
+	}
+	if !review.Response.Allowed && review.Response.Result != nil && review.Response.Result.Reason == metav1.StatusReasonInternalError {
+		return fmt.Errorf("internal error: %s", review.Response.Result.Message)
+	}
+	return nil
+}
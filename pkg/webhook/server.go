@@ -3,6 +3,8 @@ package webhook
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -12,10 +14,17 @@ import (
 	"regexp"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/kubescape/kubeenforcer/pkg/alertmanager"
+	"github.com/kubescape/kubeenforcer/pkg/decision"
+	"github.com/kubescape/kubeenforcer/pkg/metrics"
+	"github.com/kubescape/kubeenforcer/pkg/redaction"
+	"github.com/kubescape/kubeenforcer/pkg/remediation"
+	"golang.org/x/net/http2"
 	admissionv1 "k8s.io/api/admission/v1"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
 	authenticationv1 "k8s.io/api/authentication/v1"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -26,11 +35,20 @@ import (
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apiserver/pkg/admission"
 	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/warning"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/klog/v2"
+
+	listers "k8s.io/cel-admission-webhook/pkg/generated/listers/admissionregistration.x-k8s.io/v1alpha1"
 )
 
 var logger klog.Logger = klog.LoggerWithName(klog.Background(), "webhook")
 
+// maxUnregisteredKinds bounds the kinds cached as missing from the scheme,
+// since callers can send any kind.
+const maxUnregisteredKinds = 10000
+
 type Interface interface {
 
 	// Runs the webhook server until the passed context is cancelled, or it
@@ -41,30 +59,223 @@ type Interface interface {
 	//		context cancelled
 	//		or http listen error
 	Run(ctx context.Context) error
+
+	// Handler returns the admission endpoints, to be mounted in another
+	// HTTPS server instead of calling Run.
+	Handler() http.Handler
+}
+
+// Listener is an address the webhook is served on, with its own TLS
+// certificate.
+type Listener struct {
+	// Addr is a host:port, tcp:// or unix:// address.
+	Addr string
+
+	CertFile, KeyFile string
+}
+
+// HTTPOptions tunes connection handling. API server connection reuse differs
+// across managed distributions, which shows up in tail latency. Zero values
+// keep the net/http defaults.
+type HTTPOptions struct {
+	// MaxConcurrentStreams limits the HTTP/2 streams per connection.
+	MaxConcurrentStreams uint32
+
+	// IdleTimeout closes keep-alive connections idle for longer.
+	IdleTimeout time.Duration
+
+	// DisableKeepAlives closes every connection after one request.
+	DisableKeepAlives bool
+
+	// ShutdownDelay is how long the server keeps accepting requests after
+	// it is marked not ready, so the API server stops routing to it first.
+	ShutdownDelay time.Duration
+
+	// ShutdownGracePeriod bounds how long shutdown waits for in-flight
+	// admissions, including ShutdownDelay, before closing connections.
+	ShutdownGracePeriod time.Duration
+
+	// ReusePort sets SO_REUSEPORT on TCP listeners, so another process can
+	// listen on the same addresses while this one drains.
+	ReusePort bool
+
+	// Handoff starts a new process from the executable on SIGUSR2, passing
+	// it the listeners; the new process stops this one once it started.
+	Handoff bool
 }
 
-func New(addr string, certFile, keyFile string, alertmanagerHost string, scheme *runtime.Scheme, validator admission.ValidationInterface) Interface {
-	codecs := serializer.NewCodecFactory(scheme)
-	return &webhook{
-		objectInferfaces: admission.NewObjectInterfacesFromScheme(scheme),
+// New creates a webhook served on addr, a host:port, tcp:// or unix://
+// address, configured by opts. If addr is empty, the webhook is only served
+// on the listeners added with WithListeners.
+func New(addr string, opts ...Option) Interface {
+	c := &config{scheme: scheme.Scheme, logger: logger}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	var listeners []Listener
+	if addr != "" {
+		listeners = append(listeners, Listener{Addr: addr})
+	}
+	listeners = append(listeners, c.listeners...)
+	for i := range listeners {
+		if listeners[i].CertFile == "" {
+			listeners[i].CertFile = c.certFile
+		}
+		if listeners[i].KeyFile == "" {
+			listeners[i].KeyFile = c.keyFile
+		}
+	}
+
+	if c.objectInterfaces == nil {
+		c.objectInterfaces = admission.NewObjectInterfacesFromScheme(c.scheme)
+	}
+	codecs := serializer.NewCodecFactory(c.scheme)
+	wh := &webhook{
+		objectInferfaces: c.objectInterfaces,
+		coercer:          c.coercer,
+		largeObjectSize:  c.largeObjectSize,
 		decoder:          codecs.UniversalDeserializer(),
-		validator:        validator,
-		addr:             addr,
-		certFile:         certFile,
-		keyFile:          keyFile,
-		alertmanagerHost: alertmanagerHost,
+		validator:        c.validators,
+		validators:       c.validators,
+		readinessChecks:  c.readinessChecks,
+		listeners:        listeners,
+		httpOptions:      c.httpOptions,
+		healthOptions:    c.healthOptions,
+		authOptions:      c.authOptions,
+		alerter:          c.alerter,
+		mutators:         c.mutators,
+		autoRemediate:    c.autoRemediate,
+		policies:         c.policies,
+		explainer:        c.explainer,
+		internalErrors:   c.internalErrors,
+		outcomes:         c.outcomes,
+		mirror:           c.mirror,
+		stamp:            c.stamp,
+		versionInfo:      c.versionInfo,
+		names:            c.names,
+		helmReleases:     c.helmReleases,
+		scaleTargets:     c.scaleTargets,
+		bindingTargets:   c.bindingTargets,
+		logAllowedEvery:  int64(c.logAllowedEvery),
+		redactor:         c.redactor,
+		logger:           c.logger,
+	}
+	if len(c.paths) > 0 {
+		wh.paths = map[string]chain{}
+		for _, p := range c.paths {
+			wh.paths[p.path] = c.validators.only(p.validators)
+		}
+	}
+	if c.denyStorm.enabled() {
+		wh.storms = newStormGuard(c.denyStorm, c.logger)
+	}
+	if c.memoryPressure.enabled() {
+		wh.memory = newMemoryGuard(c.memoryPressure, c.logger)
 	}
+	if c.selfTest.Enabled {
+		wh.selfTest = newSelfTest(c.selfTest)
+	}
+	if len(c.decisions) > 0 {
+		wh.decisions = decision.NewMulti(c.decisions...)
+	}
+	if c.authOptions.enabled() {
+		wh.authenticator = newAuthenticator(c.authOptions, c.logger)
+		wh.authenticator.selfTest = wh.selfTest
+		if c.authOptions.adminEnabled() {
+			wh.admin = c.admin
+			wh.api = c.api
+		} else if c.admin != nil || c.api != nil {
+			c.logger.Info("admin and API endpoints disabled, they require admin users or groups to be configured")
+		}
+	} else if c.admin != nil || c.api != nil {
+		c.logger.Info("admin and API endpoints disabled, they require authentication to be configured")
+	}
+	return wh
 }
 
 type webhook struct {
-	lock              sync.Mutex
-	port              int
-	validator         admission.ValidationInterface
-	objectInferfaces  admission.ObjectInterfaces
-	decoder           runtime.Decoder
-	addr              string
-	alertmanagerHost  string
-	certFile, keyFile string
+	lock             sync.Mutex
+	validator        admission.ValidationInterface
+	validators       chain
+	paths            map[string]chain
+	readinessChecks  []namedCheck
+	objectInferfaces admission.ObjectInterfaces
+	coercer          Coercer
+	largeObjectSize  int
+	decoder          runtime.Decoder
+	listeners        []Listener
+	httpOptions      HTTPOptions
+	healthOptions    HealthOptions
+	authOptions      AuthOptions
+	authenticator    *authenticator
+	clientCAs        *x509.CertPool
+	draining         atomic.Bool
+	started          atomic.Bool
+	handoffs         handoffListeners
+	inFlight         atomic.Int64
+	drainDeadline    time.Time
+	alerter          *alertmanager.AlertManager
+	decisions        decision.Sink
+	mutators         []Mutator
+	autoRemediate    remediation.Policies
+	policies         listers.ValidatingAdmissionPolicyLister
+	admin            http.Handler
+	api              http.Handler
+	explainer        Explainer
+	internalErrors   InternalErrorOptions
+	outcomes         OutcomeTracker
+	mirror           Mirror
+	stamp            *versionStamp
+	versionInfo      *VersionInfo
+	scaleTargets     dynamic.Interface
+	bindingTargets   dynamic.Interface
+	storms           *stormGuard
+	memory           *memoryGuard
+	selfTest         *selfTest
+	names            NameResolver
+	helmReleases     HelmReleaseResolver
+	logAllowedEvery  int64
+	allowedCount     atomic.Int64
+	redactor         *redaction.Redactor
+	logger           klog.Logger
+
+	// unregistered caches the kinds missing from the scheme, decoded as
+	// unstructured without trying the decoder first.
+	unregistered    sync.Map
+	unregisteredLen atomic.Int64
+}
+
+// Explainer traces how requests that ask for it were evaluated.
+type Explainer interface {
+	// Requested returns whether req asked for an explanation of attrs and
+	// its requester may get one.
+	Requested(req *http.Request, attrs admission.Attributes) bool
+	// Explain returns the trace of attrs as warnings.
+	Explain(ctx context.Context, attrs admission.Attributes, o admission.ObjectInterfaces) []string
+}
+
+// OutcomeTracker tracks the outcomes of policy evaluations.
+type OutcomeTracker interface {
+	// Observe records the outcome of the validation of attrs, which
+	// returned err.
+	Observe(ctx context.Context, attrs admission.Attributes, o admission.ObjectInterfaces, err error)
+}
+
+// Mirror sends a sample of the admission reviews served to a secondary
+// webhook.
+type Mirror interface {
+	// Sample returns whether to mirror the next review.
+	Sample() bool
+	// Send mirrors the admission review body received on path, to which
+	// the webhook answered allowed, without waiting for it to be sent.
+	Send(path string, body []byte, allowed bool)
+}
+
+// Coercer normalizes objects decoded as unstructured, e.g. to the schemas of
+// their CRDs.
+type Coercer interface {
+	Coerce(obj *unstructured.Unstructured)
 }
 
 func notifyChanges(ctx context.Context, paths ...string) <-chan struct{} {
@@ -119,11 +330,144 @@ func notifyChanges(ctx context.Context, paths ...string) <-chan struct{} {
 }
 
 func (wh *webhook) Run(ctx context.Context) error {
+	if len(wh.listeners) == 0 {
+		return errors.New("no listen addresses configured")
+	}
+
+	if wh.authOptions.ClientCAFile != "" {
+		clientCAs, err := loadClientCAs(wh.authOptions.ClientCAFile)
+		if err != nil {
+			return err
+		}
+		wh.clientCAs = clientCAs
+	}
+
+	wh.logger.Info("starting webhook HTTP server")
+	defer wh.logger.Info("stopped webhook HTTP server")
+
+	// Listeners are stopped once ctx is cancelled and in-flight admissions
+	// are drained, or as soon as one of them stops
+	serveCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if wh.memory != nil {
+		go wh.memory.run(serveCtx)
+	}
+	if wh.selfTest != nil {
+		go wh.runSelfTest(serveCtx)
+	}
+	if wh.httpOptions.Handoff {
+		go wh.runHandoff(serveCtx)
+	}
+	if parent := handoffParent(); parent != 0 {
+		go wh.stopParent(serveCtx, parent)
+	}
+
+	errs := make(chan error, len(wh.listeners))
+	for _, l := range wh.listeners {
+		go func(l Listener) {
+			errs <- wh.runListener(serveCtx, l)
+		}(l)
+	}
+
+	var serverError error
+	running := len(wh.listeners)
+	select {
+	case <-ctx.Done():
+		wh.drain()
+		serverError = ctx.Err()
+	case serverError = <-errs:
+		running--
+	}
+	cancel()
+
+	for ; running > 0; running-- {
+		<-errs
+	}
+	return serverError
+}
+
+// drain marks the webhook not ready and waits for ShutdownDelay to pass and
+// for in-flight admissions to complete, up to ShutdownGracePeriod.
+func (wh *webhook) drain() {
+	wh.draining.Store(true)
+	wh.drainDeadline = time.Now().Add(wh.httpOptions.ShutdownGracePeriod)
+	if wh.httpOptions.ShutdownGracePeriod <= 0 {
+		return
+	}
+
+	wh.logger.Info("draining webhook", "inFlight", wh.inFlight.Load(), "gracePeriod", wh.httpOptions.ShutdownGracePeriod)
+
+	delay := time.NewTimer(wh.httpOptions.ShutdownDelay)
+	defer delay.Stop()
+	deadline := time.NewTimer(wh.httpOptions.ShutdownGracePeriod)
+	defer deadline.Stop()
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	delayed := wh.httpOptions.ShutdownDelay <= 0
+	for {
+		select {
+		case <-delay.C:
+			delayed = true
+		case <-ticker.C:
+		case <-deadline.C:
+			wh.logger.Info("grace period expired before admissions drained", "inFlight", wh.inFlight.Load())
+			return
+		}
+		if delayed && wh.inFlight.Load() == 0 {
+			return
+		}
+	}
+}
+
+// Handler returns the admission endpoints: /validate, the validate paths,
+// /mutate if mutation or auto-remediation is configured, and /admin/ and
+// /api/ if their handlers are. They are authenticated if authentication is
+// configured, and /admin/ and /api/ are restricted to the admin users and
+// groups; client certificates are only accepted if the server verified them.
+func (wh *webhook) Handler() http.Handler {
+	mux := http.NewServeMux()
+	if _, ok := wh.paths["/validate"]; !ok {
+		if wh.authenticator != nil {
+			mux.HandleFunc("/validate", wh.authenticator.wrap(wh.handleWebhookValidate))
+		} else {
+			mux.HandleFunc("/validate", wh.handleWebhookValidate)
+		}
+	}
+	for path, validators := range wh.paths {
+		validators := validators
+		handler := func(w http.ResponseWriter, req *http.Request) {
+			wh.serveValidate(w, req, validators)
+		}
+		if wh.authenticator != nil {
+			handler = wh.authenticator.wrap(handler)
+		}
+		mux.HandleFunc(path, handler)
+	}
+	if wh.admin != nil {
+		mux.HandleFunc("/admin/", wh.authenticator.wrapAdmin(wh.admin.ServeHTTP))
+	}
+	if wh.api != nil {
+		mux.HandleFunc("/api/", wh.authenticator.wrapAdmin(wh.api.ServeHTTP))
+	}
+	if len(wh.mutators) > 0 || wh.autoRemediate != nil {
+		if wh.authenticator != nil {
+			mux.HandleFunc("/mutate", wh.authenticator.wrap(wh.handleWebhookMutate))
+		} else {
+			mux.HandleFunc("/mutate", wh.handleWebhookMutate)
+		}
+	}
+	return mux
+}
+
+// runListener serves the webhook on a single listener, restarting the server
+// whenever its certificate changes.
+func (wh *webhook) runListener(ctx context.Context, listener Listener) error {
 	var serverError error
 	var wg sync.WaitGroup
 
-	logger.Info("starting webhook HTTP server")
-	defer logger.Info("stopped webhook HTTP server")
+	wh.logger.Info("listening", "addr", listener.Addr)
 	defer wg.Wait()
 
 	wg.Add(1)
@@ -132,10 +476,31 @@ func (wh *webhook) Run(ctx context.Context) error {
 	launchServer := func() (*http.Server, <-chan error) {
 		mux := http.NewServeMux()
 		mux.HandleFunc("/health", wh.handleHealth)
-		mux.HandleFunc("/validate", wh.handleWebhookValidate)
+		mux.HandleFunc("/readyz", wh.handleReady)
+		mux.HandleFunc("/startupz", wh.handleStartup)
+		if wh.versionInfo != nil {
+			mux.HandleFunc("/version", wh.handleVersion)
+		}
+		mux.Handle("/metrics", metrics.Handler())
+		mux.Handle("/", wh.Handler())
 		srv := &http.Server{}
 		srv.Handler = mux
-		srv.Addr = wh.addr
+		srv.Addr = listener.Addr
+		if wh.clientCAs != nil {
+			// Probes and token authenticated callers present no certificate
+			srv.TLSConfig = &tls.Config{ClientCAs: wh.clientCAs, ClientAuth: tls.VerifyClientCertIfGiven}
+		}
+		srv.IdleTimeout = wh.httpOptions.IdleTimeout
+		srv.SetKeepAlivesEnabled(!wh.httpOptions.DisableKeepAlives)
+		if wh.httpOptions.MaxConcurrentStreams > 0 || wh.httpOptions.IdleTimeout > 0 {
+			// Only fails if the server was already configured for HTTP/2
+			if err := http2.ConfigureServer(srv, &http2.Server{
+				MaxConcurrentStreams: wh.httpOptions.MaxConcurrentStreams,
+				IdleTimeout:          wh.httpOptions.IdleTimeout,
+			}); err != nil {
+				wh.logger.Error(err, "failed to configure HTTP/2")
+			}
+		}
 
 		errChan := make(chan error)
 
@@ -144,9 +509,16 @@ func (wh *webhook) Run(ctx context.Context) error {
 			defer wg.Done()
 			defer close(errChan)
 
-			err := srv.ListenAndServeTLS(wh.certFile, wh.keyFile)
+			l, err := listen(listener.Addr, wh.httpOptions.ReusePort)
+			if err != nil {
+				errChan <- err
+				return
+			}
+			wh.handoffs.serving(listener.Addr, l)
+
+			err = srv.ServeTLS(l, listener.CertFile, listener.KeyFile)
 			errChan <- err
-			// ListenAndServeTLS always returns non-nil error
+			// ServeTLS always returns non-nil error
 		}()
 
 		return srv, errChan
@@ -155,7 +527,7 @@ func (wh *webhook) Run(ctx context.Context) error {
 	watchCtx, cancelWatches := context.WithCancel(ctx)
 	defer cancelWatches()
 
-	keyWatch := notifyChanges(watchCtx, wh.certFile, wh.keyFile)
+	keyWatch := notifyChanges(watchCtx, listener.CertFile, listener.KeyFile)
 
 	currentServer, currentErrorChannel := launchServer()
 loop:
@@ -163,15 +535,18 @@ loop:
 		select {
 		case <-ctx.Done():
 			// If the caller closed their context, rather than the server having errored,
-			// close the server. srv.Close() is safe to call on an already-closed server
-			//
-			// note: should we prefer to use Shutdown with a deadline for graceful close
-			// rather than Close?
-			if err := currentServer.Close(); err != nil {
-				// Errors with gracefully shutting down connections. Not fatal. Server
-				// is still closed.
-				logger.Error(err, "shutting down webhook")
+			// shut the server down. In-flight admissions were already drained, so
+			// only idle connections remain unless the grace period ran out
+			shutdownCtx, shutdownCancel := context.WithDeadline(context.Background(), wh.drainDeadline)
+			if err := currentServer.Shutdown(shutdownCtx); err != nil {
+				// Close is safe to call on an already-closed server
+				if err := currentServer.Close(); err != nil {
+					// Errors with closing connections. Not fatal. Server
+					// is still closed.
+					wh.logger.Error(err, "shutting down webhook")
+				}
 			}
+			shutdownCancel()
 			serverError = ctx.Err()
 			break loop
 		case serverError, _ = <-currentErrorChannel:
@@ -184,7 +559,7 @@ loop:
 				break loop
 			}
 
-			logger.Info("TLS input has changed, restarting HTTP server")
+			wh.logger.Info("TLS input has changed, restarting HTTP server", "addr", listener.Addr)
 
 			// Graceful shutdown, ignore any errors
 			wg.Add(1)
@@ -207,10 +582,52 @@ loop:
 }
 
 func (wh *webhook) handleHealth(w http.ResponseWriter, req *http.Request) {
+	if wh.healthOptions.CertChecksFailHealth {
+		if err := checkCertificates(wh.listeners, wh.healthOptions.CertExpiryWindow); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+	}
+	fmt.Fprint(w, "OK")
+}
+
+func (wh *webhook) handleReady(w http.ResponseWriter, req *http.Request) {
+	if wh.draining.Load() {
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	if err := checkCertificates(wh.listeners, wh.healthOptions.CertExpiryWindow); err != nil {
+		wh.logger.Error(err, "failing readiness")
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if err := checkValidators(wh.validators, wh.readinessChecks); err != nil {
+		wh.logger.Error(err, "failing readiness")
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if wh.selfTest != nil {
+		if err := wh.selfTest.ready(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+	}
 	fmt.Fprint(w, "OK")
 }
 
 func (wh *webhook) handleWebhookValidate(w http.ResponseWriter, req *http.Request) {
+	wh.serveValidate(w, req, wh.validator)
+}
+
+// serveValidate validates the admission review of req with validator.
+func (wh *webhook) serveValidate(w http.ResponseWriter, req *http.Request, validator admission.ValidationInterface) {
+	wh.inFlight.Add(1)
+	defer wh.inFlight.Add(-1)
+
+	if wh.memory != nil && wh.memory.shed(req) {
+		wh.memory.fail(w, req)
+		return
+	}
 	parsed, err := parseRequest(req)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -229,124 +646,199 @@ func (wh *webhook) handleWebhookValidate(w http.ResponseWriter, req *http.Reques
 	// 	parsed.Request.UID,
 	// )
 
+	// Self-test requests are evaluated without side effects
+	selfTest := wh.selfTest.is(req)
+
+	// Mirrored reviews are encoded before large raw objects are released
+	var mirrored []byte
+	if wh.mirror != nil && !selfTest && wh.mirror.Sample() {
+		if mirrored, err = json.Marshal(parsed); err != nil {
+			wh.logger.Error(err, "failed to encode mirrored review")
+			mirrored = nil
+		}
+	}
+
+	decisionID := decision.NewID()
+	logger := wh.logger.WithValues("decision", decisionID, "uid", parsed.Request.UID)
+	recorder := &warnings{}
+	ctx := warning.WithWarningRecorder(klog.NewContext(decision.WithID(req.Context(), decisionID), logger), recorder)
+
 	failure := func(err error, status int) {
 		http.Error(w, err.Error(), status)
-		logger.Error(err, "review response", "uid", parsed.Request.UID, "status", status)
+		logger.Error(err, "review response", "status", status)
 	}
 
 	err = nil
 
 	var attrs admission.Attributes
+	var collection bool
 
-	if wh.validator.Handles(admission.Operation(parsed.Request.Operation)) {
+	// Redacted values are collected before large raw objects are released
+	redact := wh.redactor.ForRequest(parsed.Request)
+
+	if validator.Handles(admission.Operation(parsed.Request.Operation)) {
 		var object runtime.Object
 		var oldObject runtime.Object
 
-		if len(parsed.Request.OldObject.Raw) > 0 {
-			obj, gvk, err := wh.decoder.Decode(parsed.Request.OldObject.Raw, nil, nil)
-			switch {
-			case gvk == nil || *gvk != schema.GroupVersionKind(parsed.Request.Kind):
-				// GVK case first. If object type is unknown it is parsed to
-				// unstructured, but
-				failure(fmt.Errorf("unexpected GVK %v. Expected %v", gvk, parsed.Request.Kind), http.StatusBadRequest)
-				return
-			case err != nil && runtime.IsNotRegisteredError(err):
-				var oldUnstructured unstructured.Unstructured
-				err = json.Unmarshal(parsed.Request.OldObject.Raw, &oldUnstructured)
-				if err != nil {
-					failure(err, http.StatusInternalServerError)
-					return
-				}
+		decode := wh.decodeObject
+		large := wh.largeObjectSize > 0 && len(parsed.Request.Object.Raw)+len(parsed.Request.OldObject.Raw) > wh.largeObjectSize
+		if large {
+			logger.V(4).Info("decoding large objects as unstructured", "size", len(parsed.Request.Object.Raw)+len(parsed.Request.OldObject.Raw))
+			decode = wh.decodeUnstructured
+		}
 
-				oldObject = &oldUnstructured
-			case err != nil:
-				failure(err, http.StatusBadRequest)
+		if len(parsed.Request.OldObject.Raw) > 0 {
+			var status int
+			oldObject, status, err = decode(parsed.Request.OldObject.Raw, parsed.Request.Kind)
+			if err != nil {
+				wh.internalError(w, req, parsed.Request, decisionID, internalErrorDecode, err, status)
 				return
-			default:
-				oldObject = obj
+			}
+			if large {
+				parsed.Request.OldObject.Raw = nil
 			}
 		}
 
 		if len(parsed.Request.Object.Raw) > 0 {
-			obj, gvk, err := wh.decoder.Decode(parsed.Request.Object.Raw, nil, nil)
-			switch {
-			case gvk == nil || *gvk != schema.GroupVersionKind(parsed.Request.Kind):
-				// GVK case first. If object type is unknown it is parsed to
-				// unstructured, but
-				failure(fmt.Errorf("unexpected GVK %v. Expected %v", gvk, parsed.Request.Kind), http.StatusBadRequest)
+			var status int
+			object, status, err = decode(parsed.Request.Object.Raw, parsed.Request.Kind)
+			if err != nil {
+				wh.internalError(w, req, parsed.Request, decisionID, internalErrorDecode, err, status)
 				return
-			case err != nil && runtime.IsNotRegisteredError(err):
-				var objUnstructured unstructured.Unstructured
-				err = json.Unmarshal(parsed.Request.Object.Raw, &objUnstructured)
-				if err != nil {
-					failure(err, http.StatusInternalServerError)
-					return
-				}
+			}
+			if large {
+				parsed.Request.Object.Raw = nil
+			}
+		}
 
-				object = &objUnstructured
-			case err != nil:
-				failure(err, http.StatusBadRequest)
+		if parsed.Request.SubResource == scaleSubresource && wh.scaleTargets != nil {
+			if err := wh.addScaleTargetMetadata(ctx, parsed.Request, object, oldObject); err != nil {
+				wh.internalError(w, req, parsed.Request, decisionID, internalErrorMetadata, fmt.Errorf("failed to get the scaled workload: %w", err), http.StatusInternalServerError)
 				return
-			default:
-				object = obj
 			}
 		}
 
-		// Parse into native types if possible
-		convertExtra := func(input map[string]authenticationv1.ExtraValue) map[string][]string {
-			if input == nil {
-				return nil
+		if isBinding(parsed.Request) && object != nil && wh.bindingTargets != nil {
+			if object, err = wh.addBindingMetadata(ctx, parsed.Request, object); err != nil {
+				wh.internalError(w, req, parsed.Request, decisionID, internalErrorMetadata, fmt.Errorf("failed to get the bound pod or node: %w", err), http.StatusInternalServerError)
+				return
 			}
+		}
 
-			res := map[string][]string{}
-			for k, v := range input {
-				var converted []string
-				for _, s := range v {
-					converted = append(converted, string(s))
-				}
-				res[k] = converted
+		collection = collectionItem(parsed.Request, oldObject)
+		attrs = newAttributes(parsed.Request, object, oldObject)
+
+		// Without an explicit failure policy, stale caches are left to
+		// readiness
+		if wh.internalErrors.failurePolicy(req.URL.Path) != "" {
+			if err := wh.checkReadiness(); err != nil {
+				wh.internalError(w, req, parsed.Request, decisionID, internalErrorStale, err, http.StatusServiceUnavailable)
+				return
 			}
-			return res
 		}
 
-		//!TODO: Parse options as v1.CreateOptions, v1.DeleteOptions, or v1.PatchOptions
+		err = wh.validate(ctx, validator, attrs)
+		var panicked *panicError
+		if errors.As(err, &panicked) {
+			wh.internalError(w, req, parsed.Request, decisionID, internalErrorPanic, err, http.StatusInternalServerError)
+			return
+		}
+		if wh.outcomes != nil && !selfTest {
+			wh.outcomes.Observe(ctx, attrs, wh.objectInferfaces, err)
+		}
+		err = wh.mapDenialStatus(err)
+	}
 
-		attrs = admission.NewAttributesRecord(
-			object,
-			oldObject,
-			schema.GroupVersionKind(parsed.Request.Kind),
-			parsed.Request.Namespace,
-			parsed.Request.Name,
-			schema.GroupVersionResource{
-				Group:    parsed.Request.Resource.Group,
-				Version:  parsed.Request.Resource.Version,
-				Resource: parsed.Request.Resource.Resource,
-			},
-			parsed.Request.SubResource,
-			admission.Operation(parsed.Request.Operation),
-			nil, // operation options?
-			false,
-			&user.DefaultInfo{
-				Name:   parsed.Request.UserInfo.Username,
-				UID:    parsed.Request.UserInfo.UID,
-				Groups: parsed.Request.UserInfo.Groups,
-				Extra:  convertExtra(parsed.Request.UserInfo.Extra),
-			})
+	switch {
+	case selfTest:
+	case err == nil:
+		reviewResults.WithLabelValues("allowed").Inc()
+	case isDenial(err):
+		reviewResults.WithLabelValues("denied").Inc()
+	default:
+		reviewResults.WithLabelValues("internal_error").Inc()
+		internalErrors.WithLabelValues(internalErrorValidator, string(admissionregistrationv1.Fail)).Inc()
+	}
 
-		err = wh.validator.Validate(context.TODO(), attrs, wh.objectInferfaces)
+	// Denials during a deny storm of the user are throttled: returned as
+	// rate limited, without alerts nor logs
+	alerter, throttled := wh.alerter, false
+	if selfTest {
+		alerter = nil
+	} else if err != nil && wh.storms != nil {
+		user := parsed.Request.UserInfo.Username
+		var started bool
+		if throttled, started = wh.storms.denied(user, time.Now()); started {
+			wh.storms.alert(wh.alerter, user, redact(err.Error()), decisionID)
+		}
+		if throttled {
+			err = wh.storms.throttle(err, user)
+			alerter = nil
+		}
+	}
+
+	// Objects created with generateName are reported by the name the API
+	// server generated, which is only known once they are created unless it
+	// is set on the object; alerts and decisions of allowed ones are deferred
+	// until then
+	name := parsed.Request.Name
+	generateName, generated, generatedUID := generatedName(parsed.Request, attrs)
+	if generated != "" {
+		name = generated
+	}
+	deferred := generateName != "" && generated == "" && wh.names != nil && err == nil && !selfTest
+	if deferred {
+		wh.deferAlert(alerter, parsed.Request, generateName, generatedUID, decisionID, attrs, redact)
+		alerter = nil
 	}
 
 	response := reviewResponse(
 		parsed.Request.UID,
+		decisionID,
 		err,
-		wh.alertmanagerHost,
+		alerter,
 		parsed.Request.Resource.Resource,
-		parsed.Request.Name,
+		name,
 		parsed.Request.Namespace,
 		attrs,
 		&parsed.Request.UserInfo,
+		redact,
 	)
 
+	var release *decision.HelmRelease
+	if !selfTest {
+		release = wh.helmReleaseOf(attrs)
+	}
+	// Tells operators which chart to fix
+	if release != nil && !response.Response.Allowed && response.Response.Result != nil {
+		response.Response.Result.Message += "\nhelm release: " + release.String()
+	}
+
+	response.Response.Warnings = append(response.Response.Warnings, recorder.list()...)
+	wh.stamp.stamp(response.Response)
+
+	if wh.explainer != nil && attrs != nil && wh.explainer.Requested(req, attrs) {
+		response.Response.Warnings = append(response.Response.Warnings, wh.explainer.Explain(ctx, attrs, wh.objectInferfaces)...)
+	}
+
+	if !throttled && wh.sampleLog(response.Response.Allowed) {
+		logger.V(2).Info("review response", "resource", parsed.Request.Resource.String(), "namespace", parsed.Request.Namespace, "name", parsed.Request.Name, "allowed", response.Response.Allowed)
+	}
+
+	if wh.decisions != nil && !selfTest {
+		d := newDecision(decisionID, parsed.Request, response.Response, attrs)
+		d.Message = redact(d.Message)
+		d.Throttled = throttled
+		d.Collection = collection
+		d.Name = name
+		d.HelmRelease = release
+		if deferred {
+			wh.deferDecision(d, parsed.Request, generateName, generatedUID)
+		} else {
+			wh.decisions.Record(d)
+		}
+	}
+
 	out, err := json.Marshal(response)
 	if err != nil {
 		failure(err, http.StatusInternalServerError)
@@ -355,6 +847,9 @@ func (wh *webhook) handleWebhookValidate(w http.ResponseWriter, req *http.Reques
 
 	w.Header().Set("Content-Type", "application/json")
 	w.Write(out)
+	if mirrored != nil {
+		wh.mirror.Send(req.URL.Path, mirrored, response.Response.Allowed)
+	}
 	// logger.Info(
 	// 	"review response",
 	// 	"resource",
@@ -374,6 +869,106 @@ func (wh *webhook) handleWebhookValidate(w http.ResponseWriter, req *http.Reques
 	// )
 }
 
+// sampleLog returns whether to log the review response of a request: every
+// denial, and one in every logAllowedEvery allowed requests.
+func (wh *webhook) sampleLog(allowed bool) bool {
+	if !allowed || wh.logAllowedEvery <= 1 {
+		return true
+	}
+	return wh.allowedCount.Add(1)%wh.logAllowedEvery == 1
+}
+
+// newAttributes builds the admission attributes policies are evaluated
+// against.
+func newAttributes(request *admissionv1.AdmissionRequest, object, oldObject runtime.Object) admission.Attributes {
+	// Parse into native types if possible
+	convertExtra := func(input map[string]authenticationv1.ExtraValue) map[string][]string {
+		if input == nil {
+			return nil
+		}
+
+		res := map[string][]string{}
+		for k, v := range input {
+			var converted []string
+			for _, s := range v {
+				converted = append(converted, string(s))
+			}
+			res[k] = converted
+		}
+		return res
+	}
+
+	return admission.NewAttributesRecord(
+		object,
+		oldObject,
+		schema.GroupVersionKind(request.Kind),
+		request.Namespace,
+		request.Name,
+		schema.GroupVersionResource{
+			Group:    request.Resource.Group,
+			Version:  request.Resource.Version,
+			Resource: request.Resource.Resource,
+		},
+		request.SubResource,
+		admission.Operation(request.Operation),
+		operationOptions(request),
+		request.DryRun != nil && *request.DryRun,
+		&user.DefaultInfo{
+			Name:   request.UserInfo.Username,
+			UID:    request.UserInfo.UID,
+			Groups: request.UserInfo.Groups,
+			Extra:  convertExtra(request.UserInfo.Extra),
+		})
+}
+
+// decodeObject decodes raw into a typed object if its kind is registered in
+// the scheme, falling back to unstructured otherwise. The decoded kind must
+// match the kind in the admission request. On failure the HTTP status to
+// respond with is returned alongside the error.
+func (wh *webhook) decodeObject(raw []byte, kind metav1.GroupVersionKind) (runtime.Object, int, error) {
+	if _, ok := wh.unregistered.Load(kind); ok {
+		return wh.decodeUnstructured(raw, kind)
+	}
+
+	obj, gvk, err := wh.decoder.Decode(raw, nil, nil)
+	switch {
+	case gvk == nil || *gvk != schema.GroupVersionKind(kind):
+		// GVK case first. If object type is unknown it is parsed to
+		// unstructured, but
+		return nil, http.StatusBadRequest, fmt.Errorf("unexpected GVK %v. Expected %v", gvk, kind)
+	case err != nil && runtime.IsNotRegisteredError(err):
+		// The scheme does not change, so custom resources of this kind can
+		// skip the typed decode from now on
+		if wh.unregisteredLen.Load() < maxUnregisteredKinds {
+			if _, loaded := wh.unregistered.LoadOrStore(kind, struct{}{}); !loaded {
+				wh.unregisteredLen.Add(1)
+			}
+		}
+		return wh.decodeUnstructured(raw, kind)
+	case err != nil:
+		return nil, http.StatusBadRequest, err
+	default:
+		return obj, 0, nil
+	}
+}
+
+// decodeUnstructured decodes raw, an object of a kind missing from the
+// scheme or a large object, and coerces it.
+func (wh *webhook) decodeUnstructured(raw []byte, kind metav1.GroupVersionKind) (runtime.Object, int, error) {
+	var objUnstructured unstructured.Unstructured
+	if err := json.Unmarshal(raw, &objUnstructured); err != nil {
+		// The raw object is malformed rather than the server failing
+		return nil, http.StatusBadRequest, err
+	}
+	if gvk := objUnstructured.GroupVersionKind(); gvk != schema.GroupVersionKind(kind) {
+		return nil, http.StatusBadRequest, fmt.Errorf("unexpected GVK %v. Expected %v", gvk, kind)
+	}
+	if wh.coercer != nil {
+		wh.coercer.Coerce(&objUnstructured)
+	}
+	return &objUnstructured, 0, nil
+}
+
 func getValidationAnnotations(attrs admission.Attributes) (audit bool, deny bool) {
 	validationActionsPattern := `validationActions":\[(.*?)\]`
 	regex, _ := regexp.Compile(validationActionsPattern)
@@ -418,7 +1013,10 @@ func getPolicy(attrs admission.Attributes) (policy string) {
 	return policy
 }
 
-func reviewResponse(uid types.UID, err error, aletmanagerHost string, resource string, name string, namespace string, attrs admission.Attributes, requestingUser *authenticationv1.UserInfo) *admissionv1.AdmissionReview {
+func reviewResponse(uid types.UID, decisionID string, err error, alerter *alertmanager.AlertManager, resource string, name string, namespace string, attrs admission.Attributes, requestingUser *authenticationv1.UserInfo, redact redaction.Func) *admissionv1.AdmissionReview {
+	if redact == nil {
+		redact = redaction.None
+	}
 	allowed := err == nil
 	var status int32 = http.StatusAccepted
 	if err != nil {
@@ -438,21 +1036,28 @@ func reviewResponse(uid types.UID, err error, aletmanagerHost string, resource s
 	}
 
 	audit, deny := getValidationAnnotations(attrs)
-	if audit || deny {
-		if aletmanagerHost != "" {
-			policyName := getPolicy(attrs)
-			alerter := alertmanager.New(aletmanagerHost, "")
-			alertInfo := alertmanager.AlertInfo{
-				Name:           fmt.Sprintf("Failed Policy: %v", policyName),
-				Severity:       string(reason),
-				Resource:       resource,
-				Instance:       name,
-				Namespace:      namespace,
-				RequestingUser: requestingUser.Username,
-				Description:    getMessage(attrs),
-			}
-			alerter.Alert(&alertInfo)
-		}
+
+	// Built-in policies carry their control ID in the message, which is
+	// enough to suggest a fix
+	var hint *remediation.Hint
+	if audit || deny || !allowed {
+		hint = remediation.For(message+" "+getMessage(attrs), attrs)
+	}
+	if hint != nil && !allowed {
+		message += "\nremediation: " + hint.String()
+	}
+	if !allowed {
+		message += "\ndecision: " + decisionID
+	}
+
+	if (audit || deny) && alerter != nil {
+		alerter.Alert(violationAlert(decisionID, reason, hint, resource, name, namespace, attrs, requestingUser, redact))
+	}
+
+	// Clients show warnings, unlike the status, for allowed requests too
+	var warnings []string
+	if audit || deny || !allowed {
+		warnings = append(warnings, "kubeenforcer decision "+decisionID)
 	}
 
 	return &admissionv1.AdmissionReview{
@@ -468,10 +1073,79 @@ func reviewResponse(uid types.UID, err error, aletmanagerHost string, resource s
 				Message: message,
 				Reason:  reason,
 			},
+			Warnings: warnings,
 		},
 	}
 }
 
+// violationAlert returns the alert for a request audited or denied by a
+// policy.
+func violationAlert(decisionID string, reason metav1.StatusReason, hint *remediation.Hint, resource, name, namespace string, attrs admission.Attributes, requestingUser *authenticationv1.UserInfo, redact redaction.Func) *alertmanager.AlertInfo {
+	policyName := getPolicy(attrs)
+	alertInfo := &alertmanager.AlertInfo{
+		Name:           fmt.Sprintf("Failed Policy: %v", policyName),
+		Severity:       string(reason),
+		Resource:       resource,
+		Instance:       name,
+		Namespace:      namespace,
+		RequestingUser: requestingUser.Username,
+		Description:    redact(getMessage(attrs)),
+		Policy:         policyName,
+		Workload:       workloadOf(attrs),
+		DecisionID:     decisionID,
+	}
+	if source := sourceOf(attrs); source != nil {
+		alertInfo.GitOpsApplication = source.Application()
+		alertInfo.GitOpsRevision = source.Commit
+	}
+	if hint != nil {
+		alertInfo.Remediation = redact(hint.String())
+	}
+	return alertInfo
+}
+
+func newDecision(id string, request *admissionv1.AdmissionRequest, response *admissionv1.AdmissionResponse, attrs admission.Attributes) *decision.Decision {
+	d := &decision.Decision{
+		Time:        time.Now(),
+		ID:          id,
+		UID:         string(request.UID),
+		Operation:   string(request.Operation),
+		Group:       request.Resource.Group,
+		Version:     request.Resource.Version,
+		Resource:    request.Resource.Resource,
+		SubResource: request.SubResource,
+		Namespace:   request.Namespace,
+		Name:        request.Name,
+		User:        request.UserInfo.Username,
+		Allowed:     response.Allowed,
+		DryRun:      request.DryRun != nil && *request.DryRun,
+
+		PolicySet:           response.AuditAnnotations[policySetAnnotation],
+		KubeenforcerVersion: response.AuditAnnotations[versionAnnotation],
+	}
+
+	audit, deny := getValidationAnnotations(attrs)
+	if audit {
+		d.Actions = append(d.Actions, "Audit")
+	}
+	if deny {
+		d.Actions = append(d.Actions, "Deny")
+	}
+	if audit || deny {
+		d.Policy = getPolicy(attrs)
+		d.Message = getMessage(attrs)
+	}
+	if !response.Allowed && response.Result != nil {
+		d.Message = response.Result.Message
+	}
+	d.Source = sourceOf(attrs)
+	return d
+}
+
+// maxPreallocatedBody is the largest request body whose buffer is allocated
+// from its Content-Length.
+const maxPreallocatedBody = 64 << 20
+
 // parseRequest extracts an AdmissionReview from an http.Request if possible
 func parseRequest(r *http.Request) (*admissionv1.AdmissionReview, error) {
 	if r.Header.Get("Content-Type") != "application/json" {
@@ -480,6 +1154,10 @@ func parseRequest(r *http.Request) (*admissionv1.AdmissionReview, error) {
 	}
 
 	bodybuf := new(bytes.Buffer)
+	// Read the body into a buffer of its size rather than growing it
+	if r.ContentLength > 0 && r.ContentLength <= maxPreallocatedBody {
+		bodybuf.Grow(int(r.ContentLength) + bytes.MinRead)
+	}
 	bodybuf.ReadFrom(r.Body)
 	body := bodybuf.Bytes()
 
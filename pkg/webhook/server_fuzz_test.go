@@ -0,0 +1,157 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apiserver/pkg/admission"
+	clientsetscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/klog/v2"
+)
+
+// allowAll accepts every request so the fuzz targets exercise the full
+// decode path without needing a cluster.
+type allowAll struct{}
+
+func (allowAll) Handles(admission.Operation) bool { return true }
+func (allowAll) Validate(context.Context, admission.Attributes, admission.ObjectInterfaces) error {
+	return nil
+}
+
+const seedReview = `{
+	"kind": "AdmissionReview",
+	"apiVersion": "admission.k8s.io/v1",
+	"request": {
+		"uid": "705ab4f5-6393-11e8-b7cc-42010a800002",
+		"kind": {"group": "", "version": "v1", "kind": "Pod"},
+		"resource": {"group": "", "version": "v1", "resource": "pods"},
+		"namespace": "default",
+		"name": "nginx",
+		"operation": "CREATE",
+		"userInfo": {"username": "admin", "groups": ["system:authenticated"]},
+		"object": {"apiVersion": "v1", "kind": "Pod", "metadata": {"name": "nginx"}, "spec": {"containers": [{"name": "nginx", "image": "nginx"}]}}
+	}
+}`
+
+const seedUnstructuredReview = `{
+	"kind": "AdmissionReview",
+	"apiVersion": "admission.k8s.io/v1",
+	"request": {
+		"uid": "1",
+		"kind": {"group": "example.com", "version": "v1", "kind": "Widget"},
+		"resource": {"group": "example.com", "version": "v1", "resource": "widgets"},
+		"operation": "UPDATE",
+		"object": {"apiVersion": "example.com/v1", "kind": "Widget", "metadata": {"name": "a"}},
+		"oldObject": {"apiVersion": "example.com/v1", "kind": "Widget", "metadata": {"name": "a"}}
+	}
+}`
+
+func newFuzzWebhook() *webhook {
+	// Rejected inputs are logged on every iteration. Fuzz workers do not
+	// drain their output while running so the logs would eventually block.
+	klog.LogToStderr(false)
+	klog.SetOutput(io.Discard)
+
+	return New("", WithScheme(clientsetscheme.Scheme), WithValidators(allowAll{})).(*webhook)
+}
+
+func FuzzParseRequest(f *testing.F) {
+	f.Add("application/json", []byte(seedReview))
+	f.Add("application/json", []byte(`{"request": null}`))
+	f.Add("text/plain", []byte(seedReview))
+	f.Add("application/json", []byte{})
+
+	f.Fuzz(func(t *testing.T, contentType string, body []byte) {
+		req := httptest.NewRequest(http.MethodPost, "/validate", bytes.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+
+		review, err := parseRequest(req)
+		if err == nil && (review == nil || review.Request == nil) {
+			t.Fatalf("parseRequest returned no error but no request")
+		}
+	})
+}
+
+func FuzzDecodeObject(f *testing.F) {
+	f.Add([]byte(`{"apiVersion": "v1", "kind": "Pod", "metadata": {"name": "nginx"}}`), "", "v1", "Pod")
+	f.Add([]byte(`{"apiVersion": "example.com/v1", "kind": "Widget"}`), "example.com", "v1", "Widget")
+	f.Add([]byte(`{"apiVersion": "v1", "kind": "Pod", "spec": 7}`), "", "v1", "Pod")
+	f.Add([]byte(`not json`), "", "v1", "Pod")
+
+	wh := newFuzzWebhook()
+	f.Fuzz(func(t *testing.T, raw []byte, group, version, kind string) {
+		obj, status, err := wh.decodeObject(raw, metav1.GroupVersionKind{Group: group, Version: version, Kind: kind})
+		switch {
+		case err != nil && status != http.StatusBadRequest && status != http.StatusInternalServerError:
+			t.Fatalf("unexpected status %d for error %v", status, err)
+		case err == nil && obj == nil:
+			t.Fatalf("decodeObject returned no error but no object")
+		}
+	})
+}
+
+func FuzzHandleWebhookValidate(f *testing.F) {
+	f.Add([]byte(seedReview))
+	f.Add([]byte(seedUnstructuredReview))
+	f.Add([]byte(`{"request": {"object": {"kind": "Pod"}}}`))
+	f.Add([]byte(`{"request": {"kind": {"version": "v1", "kind": "Pod"}, "oldObject": "x"}}`))
+
+	wh := newFuzzWebhook()
+	f.Fuzz(func(t *testing.T, body []byte) {
+		req := httptest.NewRequest(http.MethodPost, "/validate", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+
+		wh.handleWebhookValidate(rec, req)
+
+		switch rec.Code {
+		case http.StatusOK:
+			var review admissionv1.AdmissionReview
+			if err := json.Unmarshal(rec.Body.Bytes(), &review); err != nil {
+				t.Fatalf("response is not an AdmissionReview: %v", err)
+			}
+			if review.Response == nil {
+				t.Fatalf("response has no AdmissionResponse")
+			}
+		case http.StatusBadRequest:
+		default:
+			t.Fatalf("unexpected status %d: %s", rec.Code, rec.Body.String())
+		}
+	})
+}
+
+func FuzzReviewResponse(f *testing.F) {
+	f.Add("705ab4f5", "", "admin")
+	f.Add("1", "denied by policy", "system:serviceaccount:default:default")
+
+	f.Fuzz(func(t *testing.T, uid string, message string, username string) {
+		var err error
+		if message != "" {
+			err = errors.New(message)
+		}
+
+		response := reviewResponse(types.UID(uid), "decision", err, nil, "pods", "name", "namespace", nil, &authenticationv1.UserInfo{Username: username}, nil)
+		out, marshalErr := json.Marshal(response)
+		if marshalErr != nil {
+			t.Fatalf("failed to marshal response: %v", marshalErr)
+		}
+
+		var roundTrip admissionv1.AdmissionReview
+		if err := json.Unmarshal(out, &roundTrip); err != nil {
+			t.Fatalf("failed to unmarshal response: %v", err)
+		}
+		if roundTrip.Response.Allowed != (err == nil) {
+			t.Fatalf("allowed = %v for error %v", roundTrip.Response.Allowed, err)
+		}
+	})
+}
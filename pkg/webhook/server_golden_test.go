@@ -0,0 +1,113 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apiserver/pkg/admission"
+	"k8s.io/apiserver/pkg/warning"
+	clientsetscheme "k8s.io/client-go/kubernetes/scheme"
+
+	"github.com/kubescape/kubeenforcer/pkg/decision"
+)
+
+var update = flag.Bool("update", false, "Rewrite the golden files of TestGoldenResponses with the current responses.")
+
+// Labels of fixture objects that make goldenValidator deny or warn, with
+// their value as message.
+const (
+	goldenDenyLabel = "golden.kubescape.io/deny"
+	goldenWarnLabel = "golden.kubescape.io/warn"
+)
+
+// goldenValidator decides on fixtures by their labels, so they cover
+// denials and warnings without a cluster.
+type goldenValidator struct{}
+
+func (goldenValidator) Handles(admission.Operation) bool { return true }
+func (goldenValidator) Validate(ctx context.Context, a admission.Attributes, o admission.ObjectInterfaces) error {
+	object, err := meta.Accessor(a.GetObject())
+	if err != nil {
+		return nil
+	}
+	labels := object.GetLabels()
+	if message, ok := labels[goldenWarnLabel]; ok {
+		warning.AddWarning(ctx, "", message)
+	}
+	if message, ok := labels[goldenDenyLabel]; ok {
+		return admission.NewForbidden(a, fmt.Errorf("%s", message))
+	}
+	return nil
+}
+
+// lastDecision records the ID of the last decision, which is random and
+// replaced in responses before they are compared.
+type lastDecision struct{ id string }
+
+func (l *lastDecision) Record(d *decision.Decision) { l.id = d.ID }
+
+// TestGoldenResponses feeds the AdmissionReviews of testdata/golden/*.review.json
+// through the handler of the webhook and compares the responses with the
+// matching .golden.json files, so changes to the format of responses are
+// deliberate. Run with -update to rewrite the golden files, and review
+// their diff.
+func TestGoldenResponses(t *testing.T) {
+	fixtures, err := filepath.Glob(filepath.Join("testdata", "golden", "*.review.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fixtures) == 0 {
+		t.Fatal("no fixtures in testdata/golden")
+	}
+
+	decisions := &lastDecision{}
+	handler := New("", WithScheme(clientsetscheme.Scheme), WithValidators(goldenValidator{}), WithDecisionSinks(decisions)).Handler()
+	for _, fixture := range fixtures {
+		name := strings.TrimSuffix(filepath.Base(fixture), ".review.json")
+		t.Run(name, func(t *testing.T) {
+			body, err := os.ReadFile(fixture)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := httptest.NewRequest(http.MethodPost, "/validate", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("unexpected status %d: %s", rec.Code, rec.Body.String())
+			}
+
+			var got bytes.Buffer
+			if err := json.Indent(&got, rec.Body.Bytes(), "", "  "); err != nil {
+				t.Fatalf("response is not JSON: %v", err)
+			}
+			got.WriteByte('\n')
+			response := strings.ReplaceAll(got.String(), decisions.id, "DECISION_ID")
+
+			golden := filepath.Join("testdata", "golden", name+".golden.json")
+			if *update {
+				if err := os.WriteFile(golden, []byte(response), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%v, run with -update to create it", err)
+			}
+			if response != string(want) {
+				t.Errorf("response differs from %s, run with -update to accept it\ngot:\n%s\nwant:\n%s", golden, response, want)
+			}
+		})
+	}
+}
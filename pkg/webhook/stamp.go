@@ -0,0 +1,38 @@
+package webhook
+
+import (
+	admissionv1 "k8s.io/api/admission/v1"
+)
+
+// Audit annotations of every response, which the API server records in the
+// audit log prefixed with the name of the webhook.
+const (
+	policySetAnnotation = "policy-set"
+	versionAnnotation   = "version"
+)
+
+// PolicySet identifies the set of policies requests are evaluated against.
+type PolicySet interface {
+	// Hash returns a hash of the policies and bindings in effect.
+	Hash() string
+}
+
+type versionStamp struct {
+	version   string
+	policySet PolicySet
+}
+
+// stamp adds the kubeenforcer version and the hash of the policy set to the
+// audit annotations of response.
+func (s *versionStamp) stamp(response *admissionv1.AdmissionResponse) {
+	if s == nil {
+		return
+	}
+	if response.AuditAnnotations == nil {
+		response.AuditAnnotations = map[string]string{}
+	}
+	response.AuditAnnotations[versionAnnotation] = s.version
+	if s.policySet != nil {
+		response.AuditAnnotations[policySetAnnotation] = s.policySet.Hash()
+	}
+}
@@ -0,0 +1,65 @@
+package webhook
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// startupStatus is the body of /startupz.
+type startupStatus struct {
+	Started bool          `json:"started"`
+	Pending []startupItem `json:"pending,omitempty"`
+}
+
+// startupItem is a startup step that has not completed.
+type startupItem struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// pendingStartup returns the startup steps that have not completed: loading
+// the serving certificates, syncing informers and compiling policies, as
+// reported by the readiness checks and validators, and the self-test.
+func (wh *webhook) pendingStartup() []startupItem {
+	var pending []startupItem
+	if err := checkCertificates(wh.listeners, 0); err != nil {
+		pending = append(pending, startupItem{Name: "certificates", Reason: err.Error()})
+	}
+	for _, c := range wh.readinessChecks {
+		if err := c.check(); err != nil {
+			pending = append(pending, startupItem{Name: c.name, Reason: err.Error()})
+		}
+	}
+	for _, v := range wh.validators {
+		if _, _, err := validatorHealth(v); err != nil {
+			pending = append(pending, startupItem{Name: "validator/" + v.name, Reason: err.Error()})
+		}
+	}
+	if wh.selfTest != nil {
+		if err := wh.selfTest.ready(); err != nil {
+			pending = append(pending, startupItem{Name: "self-test", Reason: err.Error()})
+		}
+	}
+	return pending
+}
+
+// handleStartup serves /startupz for a startup probe: it fails, listing the
+// pending steps, until every step has completed once, and then always
+// succeeds, leaving later failures to readiness.
+func (wh *webhook) handleStartup(w http.ResponseWriter, req *http.Request) {
+	status := startupStatus{Started: wh.started.Load()}
+	if !status.Started {
+		status.Pending = wh.pendingStartup()
+		if len(status.Pending) == 0 {
+			status.Started = true
+			wh.started.Store(true)
+			wh.logger.Info("startup complete")
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !status.Started {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(status)
+}
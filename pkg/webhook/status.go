@@ -0,0 +1,72 @@
+package webhook
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// DenialReasonAnnotation sets the StatusReason returned when a
+	// ValidatingAdmissionPolicy denies a request, e.g. Invalid.
+	DenialReasonAnnotation = "kubeenforcer.kubescape.io/denial-reason"
+
+	// DenialCodeAnnotation sets the HTTP status code returned when a
+	// ValidatingAdmissionPolicy denies a request. Defaults to the code of
+	// the reason.
+	DenialCodeAnnotation = "kubeenforcer.kubescape.io/denial-code"
+)
+
+var reasonCodes = map[metav1.StatusReason]int32{
+	metav1.StatusReasonUnauthorized:          http.StatusUnauthorized,
+	metav1.StatusReasonForbidden:             http.StatusForbidden,
+	metav1.StatusReasonConflict:              http.StatusConflict,
+	metav1.StatusReasonInvalid:               http.StatusUnprocessableEntity,
+	metav1.StatusReasonBadRequest:            http.StatusBadRequest,
+	metav1.StatusReasonRequestEntityTooLarge: http.StatusRequestEntityTooLarge,
+	metav1.StatusReasonTooManyRequests:       http.StatusTooManyRequests,
+}
+
+// mapDenialStatus applies the status reason and code annotations of the
+// policy that denied a request, as GitOps tools retry or surface errors
+// differently depending on them.
+func (wh *webhook) mapDenialStatus(err error) error {
+	var statusErr *k8serrors.StatusError
+	if wh.policies == nil || !errors.As(err, &statusErr) {
+		return err
+	}
+	match := deniedPolicyPattern.FindStringSubmatch(statusErr.ErrStatus.Message)
+	if match == nil {
+		return err
+	}
+	policy, getErr := wh.policies.Get(match[1])
+	if getErr != nil {
+		return err
+	}
+
+	reason := metav1.StatusReason(policy.Annotations[DenialReasonAnnotation])
+	code := policy.Annotations[DenialCodeAnnotation]
+	if reason == "" && code == "" {
+		return err
+	}
+
+	mapped := &k8serrors.StatusError{ErrStatus: *statusErr.ErrStatus.DeepCopy()}
+	if reason != "" {
+		mapped.ErrStatus.Reason = reason
+		if c, ok := reasonCodes[reason]; ok {
+			mapped.ErrStatus.Code = c
+		}
+	}
+	if code != "" {
+		c, parseErr := strconv.Atoi(code)
+		if parseErr != nil || c < 400 || c > 599 {
+			wh.logger.Info("ignoring invalid denial code annotation", "policy", policy.Name, "code", code)
+		} else {
+			mapped.ErrStatus.Code = int32(c)
+		}
+	}
+	return mapped
+}
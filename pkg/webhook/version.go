@@ -0,0 +1,20 @@
+package webhook
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// VersionInfo describes the running build, served on /version.
+type VersionInfo struct {
+	Version   string `json:"version"`
+	GoVersion string `json:"goVersion"`
+	// FIPS is set when the build uses FIPS 140 validated crypto.
+	FIPS bool `json:"fips"`
+}
+
+// handleVersion serves /version.
+func (wh *webhook) handleVersion(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(wh.versionInfo)
+}
@@ -0,0 +1,35 @@
+package webhook
+
+import "sync"
+
+// warnings collects the warnings validators add to the context of a request
+// with warning.AddWarning, e.g. for bindings with the Warn validation action,
+// so they are returned to the client.
+type warnings struct {
+	lock  sync.Mutex
+	texts []string
+}
+
+func (w *warnings) AddWarning(agent, text string) {
+	if text == "" {
+		return
+	}
+	if agent != "" {
+		text = agent + ": " + text
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	for _, t := range w.texts {
+		if t == text {
+			return
+		}
+	}
+	w.texts = append(w.texts, text)
+}
+
+func (w *warnings) list() []string {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return append([]string(nil), w.texts...)
+}
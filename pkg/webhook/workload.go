@@ -0,0 +1,56 @@
+package webhook
+
+import (
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apiserver/pkg/admission"
+
+	"github.com/kubescape/kubeenforcer/pkg/decision"
+)
+
+// workloadOf returns the workload owning the object of attrs, as Kind/name:
+// its controller, or the object itself if it has none. The Deployment of a
+// ReplicaSet is inferred from the pod-template-hash suffix of its name,
+// without reading it, so alerts for the pods of every rollout of a
+// Deployment are attributed to it.
+func workloadOf(attrs admission.Attributes) string {
+	if attrs == nil {
+		return ""
+	}
+	obj := attrs.GetObject()
+	if obj == nil {
+		obj = attrs.GetOldObject()
+	}
+	accessor, err := meta.Accessor(obj)
+	if err != nil || accessor.GetName() == "" {
+		return ""
+	}
+	owner := metav1.GetControllerOf(accessor)
+	if owner == nil {
+		return attrs.GetKind().Kind + "/" + accessor.GetName()
+	}
+	if hash := accessor.GetLabels()[appsv1.DefaultDeploymentUniqueLabelKey]; owner.Kind == "ReplicaSet" && hash != "" && strings.HasSuffix(owner.Name, "-"+hash) {
+		return "Deployment/" + strings.TrimSuffix(owner.Name, "-"+hash)
+	}
+	return owner.Kind + "/" + owner.Name
+}
+
+// sourceOf returns where the object of attrs was deployed from, according to
+// its GitOps labels and annotations, or nil if they do not say.
+func sourceOf(attrs admission.Attributes) *decision.Source {
+	if attrs == nil {
+		return nil
+	}
+	obj := attrs.GetObject()
+	if obj == nil {
+		obj = attrs.GetOldObject()
+	}
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return nil
+	}
+	return decision.SourceOf(accessor.GetLabels(), accessor.GetAnnotations())
+}
@@ -0,0 +1,115 @@
+package webhook
+
+import (
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apiserver/pkg/admission"
+)
+
+func controlledBy(kind, name string) []metav1.OwnerReference {
+	controller := true
+	return []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: kind, Name: name, Controller: &controller}}
+}
+
+func TestWorkloadOf(t *testing.T) {
+	notController := false
+	tests := []struct {
+		name      string
+		kind      string
+		object    runtime.Object
+		oldObject runtime.Object
+		want      string
+	}{
+		{
+			name: "pod of a Deployment",
+			kind: "Pod",
+			object: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+				Name:            "web-7d4b9c8f6-x2x9k",
+				Labels:          map[string]string{appsv1.DefaultDeploymentUniqueLabelKey: "7d4b9c8f6"},
+				OwnerReferences: controlledBy("ReplicaSet", "web-7d4b9c8f6"),
+			}},
+			want: "Deployment/web",
+		},
+		{
+			name: "pod of a ReplicaSet named without the hash",
+			kind: "Pod",
+			object: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+				Name:            "web-x2x9k",
+				Labels:          map[string]string{appsv1.DefaultDeploymentUniqueLabelKey: "7d4b9c8f6"},
+				OwnerReferences: controlledBy("ReplicaSet", "web"),
+			}},
+			want: "ReplicaSet/web",
+		},
+		{
+			name: "pod of a ReplicaSet without the hash label",
+			kind: "Pod",
+			object: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+				Name:            "web-7d4b9c8f6-x2x9k",
+				OwnerReferences: controlledBy("ReplicaSet", "web-7d4b9c8f6"),
+			}},
+			want: "ReplicaSet/web-7d4b9c8f6",
+		},
+		{
+			name: "pod of a StatefulSet",
+			kind: "Pod",
+			object: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+				Name:            "db-0",
+				Labels:          map[string]string{appsv1.DefaultDeploymentUniqueLabelKey: "0"},
+				OwnerReferences: controlledBy("StatefulSet", "db"),
+			}},
+			want: "StatefulSet/db",
+		},
+		{
+			name: "owner that is not the controller",
+			kind: "Pod",
+			object: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+				Name:            "debug",
+				OwnerReferences: []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "web-7d4b9c8f6", Controller: &notController}},
+			}},
+			want: "Pod/debug",
+		},
+		{
+			name:   "object without an owner",
+			kind:   "Deployment",
+			object: &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "web"}},
+			want:   "Deployment/web",
+		},
+		{
+			name: "deleted object",
+			kind: "Pod",
+			oldObject: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+				Name:            "web-7d4b9c8f6-x2x9k",
+				Labels:          map[string]string{appsv1.DefaultDeploymentUniqueLabelKey: "7d4b9c8f6"},
+				OwnerReferences: controlledBy("ReplicaSet", "web-7d4b9c8f6"),
+			}},
+			want: "Deployment/web",
+		},
+		{
+			name:   "object generating its name",
+			kind:   "Pod",
+			object: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{GenerateName: "web-"}},
+		},
+		{
+			name: "no object",
+			kind: "Pod",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attrs := admission.NewAttributesRecord(tt.object, tt.oldObject,
+				corev1.SchemeGroupVersion.WithKind(tt.kind), "payments", "", corev1.SchemeGroupVersion.WithResource("pods"), "",
+				admission.Create, nil, false, nil)
+			if got := workloadOf(attrs); got != tt.want {
+				t.Errorf("workloadOf() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if got := workloadOf(nil); got != "" {
+		t.Errorf("workloadOf(nil) = %q, want empty", got)
+	}
+}
@@ -0,0 +1,326 @@
+package webhookconfig
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	admissionregistrationinformers "k8s.io/client-go/informers/admissionregistration/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+
+	"k8s.io/cel-admission-webhook/pkg/apis/admissionregistration.x-k8s.io/v1alpha1"
+	informers "k8s.io/cel-admission-webhook/pkg/generated/informers/externalversions/admissionregistration.x-k8s.io/v1alpha1"
+	listers "k8s.io/cel-admission-webhook/pkg/generated/listers/admissionregistration.x-k8s.io/v1alpha1"
+)
+
+var logger klog.Logger = klog.LoggerWithName(klog.Background(), "webhookconfig")
+
+// Options configures which parts of the webhook configuration the
+// Reconciler manages. Nil settings are left as they are.
+type Options struct {
+	// Name of the ValidatingWebhookConfiguration to manage.
+	ConfigName string
+
+	// Name of the webhook within the configuration to manage. All webhooks
+	// are managed if empty.
+	WebhookName string
+
+	// ScopeRules narrows the webhook rules to the resources matched by the
+	// loaded policies. It requires WebhookName, as the rules only fit the
+	// webhook of /validate.
+	ScopeRules bool
+
+	// ExtraRules are merged into the scoped rules, for requests kubeenforcer
+	// handles regardless of the loaded policies.
+	ExtraRules []admissionregistrationv1.RuleWithOperations
+
+	FailurePolicy  *admissionregistrationv1.FailurePolicyType
+	TimeoutSeconds *int32
+	SideEffects    *admissionregistrationv1.SideEffectClass
+
+	// OnDrift is called when a managed setting was changed by someone other
+	// than the reconciler, before it is reverted.
+	OnDrift func(webhook, field, actual, desired string)
+}
+
+// RuleSource is implemented by validators that handle requests regardless of
+// the loaded policies. Their rules are added to the ExtraRules so that scoped
+// webhook rules keep sending those requests.
+type RuleSource interface {
+	Rules() []admissionregistrationv1.RuleWithOperations
+}
+
+// Reconciler keeps kubeenforcer's ValidatingWebhookConfiguration in sync with
+// the loaded policies and the configured webhook settings, so the API server
+// only sends requests that some policy could act on and a hand-edited
+// failurePolicy cannot silently fail open.
+type Reconciler struct {
+	client    kubernetes.Interface
+	policies  listers.ValidatingAdmissionPolicyLister
+	hasSynced []cache.InformerSynced
+	opts      Options
+	queue     workqueue.RateLimitingInterface
+
+	// initialized is set after the first successful reconcile. Differences
+	// found before then come from kubeenforcer's own config changing and are
+	// not reported as drift.
+	initialized bool
+}
+
+// New creates a Reconciler for the ValidatingWebhookConfiguration named in
+// opts.
+func New(client kubernetes.Interface, policyInformer informers.ValidatingAdmissionPolicyInformer, configInformer admissionregistrationinformers.ValidatingWebhookConfigurationInformer, opts Options) *Reconciler {
+	r := &Reconciler{
+		client:   client,
+		policies: policyInformer.Lister(),
+		hasSynced: []cache.InformerSynced{
+			policyInformer.Informer().HasSynced,
+			configInformer.Informer().HasSynced,
+		},
+		opts:  opts,
+		queue: workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+	}
+
+	enqueue := func(interface{}) { r.queue.Add(opts.ConfigName) }
+	policyInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    enqueue,
+		UpdateFunc: func(_, _ interface{}) { enqueue(nil) },
+		DeleteFunc: enqueue,
+	})
+
+	configInformer.Informer().AddEventHandler(cache.FilteringResourceEventHandler{
+		FilterFunc: func(obj interface{}) bool {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			config, ok := obj.(*admissionregistrationv1.ValidatingWebhookConfiguration)
+			return ok && config.Name == opts.ConfigName
+		},
+		Handler: cache.ResourceEventHandlerFuncs{
+			AddFunc:    enqueue,
+			UpdateFunc: func(_, _ interface{}) { enqueue(nil) },
+		},
+	})
+
+	return r
+}
+
+// Run processes policy and webhook configuration changes until ctx is
+// cancelled.
+func (r *Reconciler) Run(ctx context.Context) error {
+	defer r.queue.ShutDown()
+
+	logger.Info("starting webhook configuration reconciler", "config", r.opts.ConfigName)
+	defer logger.Info("stopped webhook configuration reconciler")
+
+	if !cache.WaitForCacheSync(ctx.Done(), r.hasSynced...) {
+		return ctx.Err()
+	}
+
+	// Periodically re-apply in case the configuration was edited by hand
+	go wait.UntilWithContext(ctx, func(ctx context.Context) {
+		r.queue.Add(r.opts.ConfigName)
+	}, 5*time.Minute)
+
+	go func() {
+		<-ctx.Done()
+		r.queue.ShutDown()
+	}()
+
+	for r.processNext(ctx) {
+	}
+	return nil
+}
+
+func (r *Reconciler) processNext(ctx context.Context) bool {
+	key, shutdown := r.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer r.queue.Done(key)
+
+	if err := r.reconcile(ctx); err != nil {
+		logger.Error(err, "failed to reconcile webhook configuration", "config", r.opts.ConfigName)
+		r.queue.AddRateLimited(key)
+		return true
+	}
+	r.queue.Forget(key)
+	return true
+}
+
+func (r *Reconciler) reconcile(ctx context.Context) error {
+	var rules []admissionregistrationv1.RuleWithOperations
+	if r.opts.ScopeRules {
+		if r.opts.WebhookName == "" {
+			return fmt.Errorf("scoping the rules of %s requires a webhook name", r.opts.ConfigName)
+		}
+		policies, err := r.policies.List(labels.Everything())
+		if err != nil {
+			return err
+		}
+		rules = Rules(policies, r.opts.ExtraRules...)
+	}
+
+	configs := r.client.AdmissionregistrationV1().ValidatingWebhookConfigurations()
+	config, err := configs.Get(ctx, r.opts.ConfigName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	updated := config.DeepCopy()
+	found := false
+	for i := range updated.Webhooks {
+		webhook := &updated.Webhooks[i]
+		if r.opts.WebhookName != "" && webhook.Name != r.opts.WebhookName {
+			continue
+		}
+		found = true
+
+		if r.opts.ScopeRules {
+			webhook.Rules = rules
+		}
+		if r.opts.FailurePolicy != nil {
+			r.checkDrift(webhook.Name, "failurePolicy", webhook.FailurePolicy, r.opts.FailurePolicy)
+			webhook.FailurePolicy = r.opts.FailurePolicy
+		}
+		if r.opts.TimeoutSeconds != nil {
+			r.checkDrift(webhook.Name, "timeoutSeconds", webhook.TimeoutSeconds, r.opts.TimeoutSeconds)
+			webhook.TimeoutSeconds = r.opts.TimeoutSeconds
+		}
+		if r.opts.SideEffects != nil {
+			r.checkDrift(webhook.Name, "sideEffects", webhook.SideEffects, r.opts.SideEffects)
+			webhook.SideEffects = r.opts.SideEffects
+		}
+	}
+	if !found {
+		return fmt.Errorf("webhook %q not found in %s", r.opts.WebhookName, r.opts.ConfigName)
+	}
+
+	if !equality.Semantic.DeepEqual(config, updated) {
+		logger.Info("updating webhook configuration", "config", r.opts.ConfigName, "rules", len(rules))
+		if _, err := configs.Update(ctx, updated, metav1.UpdateOptions{}); err != nil {
+			return err
+		}
+	}
+
+	r.initialized = true
+	return nil
+}
+
+// checkDrift reports a managed setting whose actual value differs from the
+// desired one after the reconciler has already applied it once.
+func (r *Reconciler) checkDrift(webhook, field string, actual, desired interface{}) {
+	if !r.initialized || equality.Semantic.DeepEqual(actual, desired) {
+		return
+	}
+
+	actualValue, desiredValue := deref(actual), deref(desired)
+	logger.Info("webhook configuration drifted, reverting", "config", r.opts.ConfigName, "webhook", webhook, "field", field, "actual", actualValue, "desired", desiredValue)
+	if r.opts.OnDrift != nil {
+		r.opts.OnDrift(webhook, field, actualValue, desiredValue)
+	}
+}
+
+func deref(v interface{}) string {
+	switch p := v.(type) {
+	case *admissionregistrationv1.FailurePolicyType:
+		if p != nil {
+			return string(*p)
+		}
+	case *admissionregistrationv1.SideEffectClass:
+		if p != nil {
+			return string(*p)
+		}
+	case *int32:
+		if p != nil {
+			return fmt.Sprint(*p)
+		}
+	}
+	return "<unset>"
+}
+
+// Rules computes the webhook rules covering the resource rules of all given
+// policies and the extra rules. Rules that differ only in their resources are
+// merged. Exclude rules are ignored since dropping them can only widen the
+// match.
+func Rules(policies []*v1alpha1.ValidatingAdmissionPolicy, extra ...admissionregistrationv1.RuleWithOperations) []admissionregistrationv1.RuleWithOperations {
+	var all []admissionregistrationv1.RuleWithOperations
+	for _, policy := range policies {
+		if policy.Spec.MatchConstraints == nil {
+			continue
+		}
+		for _, rule := range policy.Spec.MatchConstraints.ResourceRules {
+			all = append(all, rule.RuleWithOperations)
+		}
+	}
+	all = append(all, extra...)
+
+	merged := map[string]*admissionregistrationv1.RuleWithOperations{}
+	resources := map[string]map[string]struct{}{}
+	for _, rule := range all {
+		scope := admissionregistrationv1.AllScopes
+		if rule.Scope != nil {
+			scope = *rule.Scope
+		}
+
+		operations := make([]string, 0, len(rule.Operations))
+		for _, op := range rule.Operations {
+			operations = append(operations, string(op))
+		}
+
+		key := strings.Join([]string{
+			canonical(operations),
+			canonical(rule.APIGroups),
+			canonical(rule.APIVersions),
+			string(scope),
+		}, "|")
+
+		if _, ok := merged[key]; !ok {
+			merged[key] = &admissionregistrationv1.RuleWithOperations{
+				Operations: append([]admissionregistrationv1.OperationType(nil), rule.Operations...),
+				Rule: admissionregistrationv1.Rule{
+					APIGroups:   append([]string(nil), rule.APIGroups...),
+					APIVersions: append([]string(nil), rule.APIVersions...),
+					Scope:       &scope,
+				},
+			}
+			resources[key] = map[string]struct{}{}
+		}
+		for _, resource := range rule.Resources {
+			resources[key][resource] = struct{}{}
+		}
+	}
+
+	keys := make([]string, 0, len(merged))
+	for key := range merged {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	res := make([]admissionregistrationv1.RuleWithOperations, 0, len(keys))
+	for _, key := range keys {
+		rule := merged[key]
+		for resource := range resources[key] {
+			rule.Resources = append(rule.Resources, resource)
+		}
+		sort.Strings(rule.Resources)
+		res = append(res, *rule)
+	}
+	return res
+}
+
+func canonical(values []string) string {
+	sorted := append([]string(nil), values...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, ",")
+}
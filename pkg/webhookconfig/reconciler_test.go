@@ -0,0 +1,126 @@
+package webhookconfig
+
+import (
+	"reflect"
+	"testing"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+
+	"k8s.io/cel-admission-webhook/pkg/apis/admissionregistration.x-k8s.io/v1alpha1"
+)
+
+func rule(operations []admissionregistrationv1.OperationType, groups, versions, resources []string, scope admissionregistrationv1.ScopeType) admissionregistrationv1.RuleWithOperations {
+	return admissionregistrationv1.RuleWithOperations{
+		Operations: operations,
+		Rule: admissionregistrationv1.Rule{
+			APIGroups:   groups,
+			APIVersions: versions,
+			Resources:   resources,
+			Scope:       &scope,
+		},
+	}
+}
+
+func policy(rules ...admissionregistrationv1.RuleWithOperations) *v1alpha1.ValidatingAdmissionPolicy {
+	policy := &v1alpha1.ValidatingAdmissionPolicy{Spec: v1alpha1.ValidatingAdmissionPolicySpec{
+		MatchConstraints: &v1alpha1.MatchResources{},
+	}}
+	for _, rule := range rules {
+		policy.Spec.MatchConstraints.ResourceRules = append(policy.Spec.MatchConstraints.ResourceRules, v1alpha1.NamedRuleWithOperations{RuleWithOperations: rule})
+	}
+	return policy
+}
+
+func TestRules(t *testing.T) {
+	create := []admissionregistrationv1.OperationType{admissionregistrationv1.Create}
+	createUpdate := []admissionregistrationv1.OperationType{admissionregistrationv1.Create, admissionregistrationv1.Update}
+	updateCreate := []admissionregistrationv1.OperationType{admissionregistrationv1.Update, admissionregistrationv1.Create}
+	remove := []admissionregistrationv1.OperationType{admissionregistrationv1.Delete}
+	all, namespaced := admissionregistrationv1.AllScopes, admissionregistrationv1.NamespacedScope
+
+	tests := []struct {
+		name     string
+		policies []*v1alpha1.ValidatingAdmissionPolicy
+		extra    []admissionregistrationv1.RuleWithOperations
+		want     []admissionregistrationv1.RuleWithOperations
+	}{
+		{
+			name: "no policies",
+			want: []admissionregistrationv1.RuleWithOperations{},
+		},
+		{
+			name:     "policy without match constraints",
+			policies: []*v1alpha1.ValidatingAdmissionPolicy{{}},
+			want:     []admissionregistrationv1.RuleWithOperations{},
+		},
+		{
+			name: "same operations, groups, versions and scope merge their resources",
+			policies: []*v1alpha1.ValidatingAdmissionPolicy{
+				policy(rule(createUpdate, []string{"apps"}, []string{"v1"}, []string{"statefulsets", "deployments"}, all)),
+				policy(rule(updateCreate, []string{"apps"}, []string{"v1"}, []string{"deployments", "daemonsets"}, all)),
+			},
+			want: []admissionregistrationv1.RuleWithOperations{
+				rule(createUpdate, []string{"apps"}, []string{"v1"}, []string{"daemonsets", "deployments", "statefulsets"}, all),
+			},
+		},
+		{
+			name: "different scopes stay apart",
+			policies: []*v1alpha1.ValidatingAdmissionPolicy{
+				policy(
+					rule(create, []string{""}, []string{"v1"}, []string{"pods"}, namespaced),
+					rule(create, []string{""}, []string{"v1"}, []string{"namespaces"}, all),
+				),
+			},
+			want: []admissionregistrationv1.RuleWithOperations{
+				rule(create, []string{""}, []string{"v1"}, []string{"namespaces"}, all),
+				rule(create, []string{""}, []string{"v1"}, []string{"pods"}, namespaced),
+			},
+		},
+		{
+			name: "missing scope is all scopes",
+			policies: []*v1alpha1.ValidatingAdmissionPolicy{
+				policy(admissionregistrationv1.RuleWithOperations{
+					Operations: remove,
+					Rule:       admissionregistrationv1.Rule{APIGroups: []string{""}, APIVersions: []string{"*"}, Resources: []string{"namespaces"}},
+				}),
+			},
+			extra: []admissionregistrationv1.RuleWithOperations{
+				rule(remove, []string{""}, []string{"*"}, []string{"configmaps"}, all),
+			},
+			want: []admissionregistrationv1.RuleWithOperations{
+				rule(remove, []string{""}, []string{"*"}, []string{"configmaps", "namespaces"}, all),
+			},
+		},
+		{
+			name: "extra rules merge with policy rules",
+			policies: []*v1alpha1.ValidatingAdmissionPolicy{
+				policy(rule(create, []string{"apps"}, []string{"*"}, []string{"deployments"}, namespaced)),
+			},
+			extra: []admissionregistrationv1.RuleWithOperations{
+				rule(create, []string{"apps"}, []string{"*"}, []string{"deployments", "statefulsets"}, namespaced),
+				rule(remove, []string{"apiextensions.k8s.io"}, []string{"*"}, []string{"customresourcedefinitions"}, all),
+			},
+			want: []admissionregistrationv1.RuleWithOperations{
+				rule(create, []string{"apps"}, []string{"*"}, []string{"deployments", "statefulsets"}, namespaced),
+				rule(remove, []string{"apiextensions.k8s.io"}, []string{"*"}, []string{"customresourcedefinitions"}, all),
+			},
+		},
+		{
+			name: "extra rules without policies",
+			extra: []admissionregistrationv1.RuleWithOperations{
+				rule(createUpdate, []string{"admissionregistration.x-k8s.io"}, []string{"v1alpha1"}, []string{"validatingadmissionpolicies", "validatingadmissionpolicybindings"}, all),
+			},
+			want: []admissionregistrationv1.RuleWithOperations{
+				rule(createUpdate, []string{"admissionregistration.x-k8s.io"}, []string{"v1alpha1"}, []string{"validatingadmissionpolicies", "validatingadmissionpolicybindings"}, all),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Rules(tt.policies, tt.extra...)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Rules() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}